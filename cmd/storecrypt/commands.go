@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+)
+
+func cmdPut(ctx context.Context, st storage.Storage, args []string, std stdio) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	src, dst := args[0], cleanPath(args[1])
+	if dst == "" {
+		return errUsage
+	}
+	if src == "-" {
+		return st.Put(ctx, dst, std.in)
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return st.Put(ctx, dst, f)
+}
+
+func cmdGet(ctx context.Context, st storage.Storage, args []string, std stdio) (err error) {
+	if len(args) < 1 || len(args) > 2 {
+		return errUsage
+	}
+	src := cleanPath(args[0])
+	dst := path.Base(src)
+	if len(args) == 2 {
+		dst = args[1]
+	}
+	if dst == "-" {
+		return copyObject(ctx, st, src, std.out)
+	}
+
+	// Fetch into a temporary file first, so that a failed or corrupt
+	// download does not leave a partial file under the final name.
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".get-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	if err := copyObject(ctx, st, src, tmp); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+func cmdCat(ctx context.Context, st storage.Storage, args []string, std stdio) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	for _, p := range args {
+		if err := copyObject(ctx, st, cleanPath(p), std.out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyObject writes the object at p to w.
+func copyObject(ctx context.Context, st storage.Storage, p string, w io.Writer) error {
+	rc, err := st.Get(ctx, p)
+	if err != nil {
+		return err
+	}
+	_, err = storage.CopyObject(w, rc)
+	return err
+}
+
+func cmdLs(ctx context.Context, st storage.Storage, args []string, std stdio) error {
+	flags := flag.NewFlagSet("ls", flag.ContinueOnError)
+	long := flags.Bool("l", false, "")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return errUsage
+	}
+	prefix := cleanPath(flags.Arg(0))
+
+	if !*long {
+		names, err := st.List(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintln(std.out, name)
+		}
+		return nil
+	}
+	infos, err := st.ListInfo(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Path < infos[j].Path })
+	for _, fi := range infos {
+		size := fi.Size
+		if fi.StoredSize > 0 {
+			size = fi.StoredSize
+		}
+		fmt.Fprintf(std.out, "%12d  %s  %s\n", size, fi.ModTime.UTC().Format(time.RFC3339), fi.Path)
+	}
+	return nil
+}
+
+func cmdRm(ctx context.Context, st storage.Storage, args []string, _ stdio) error {
+	flags := flag.NewFlagSet("rm", flag.ContinueOnError)
+	recursive := flags.Bool("r", false, "")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errUsage
+	}
+	var errs []error
+	for _, p := range flags.Args() {
+		p = cleanPath(p)
+		var err error
+		if *recursive {
+			if p == "" {
+				// Refuse to wipe the whole archive by accident.
+				errs = append(errs, errors.New("refusing to remove the root"))
+				continue
+			}
+			err = st.DeleteAll(ctx, p)
+		} else {
+			err = st.Delete(ctx, p)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func cmdStat(ctx context.Context, st storage.Storage, args []string, std stdio) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	p := cleanPath(args[0])
+	if vs, ok := st.(*storage.VariadicStorage); ok {
+		s, err := vs.Stat(ctx, p)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(std.out, "path:    %s\nstored:  %s\nsize:    %d\nmodtime: %s\n",
+			s.Path, s.StoredPath, s.StoredSize, s.ModTime.UTC().Format(time.RFC3339))
+		if s.LogicalSize >= 0 {
+			fmt.Fprintf(std.out, "logical: %d\n", s.LogicalSize)
+		}
+		return nil
+	}
+	fi, err := storage.StatObject(ctx, st, p)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(std.out, "path:    %s\nsize:    %d\nmodtime: %s\n", fi.Path, fi.Size, fi.ModTime.UTC().Format(time.RFC3339))
+	return nil
+}
+
+func cmdExists(ctx context.Context, st storage.Storage, args []string, _ stdio) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	ok, err := st.Exists(ctx, cleanPath(args[0]))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if !ok {
+		return exitStatus(1)
+	}
+	return nil
+}
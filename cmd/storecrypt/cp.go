@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+	"github.com/hashmap-kz/storecrypt/pkg/sync"
+)
+
+// splitRemote splits a "remote:path" argument. An argument without a
+// colon before its first slash addresses the storage selected by -remote
+// or the flags, and gets an empty name.
+func splitRemote(arg string) (name, p string) {
+	name, p, ok := strings.Cut(arg, ":")
+	if !ok || strings.Contains(name, "/") {
+		return "", cleanPath(arg)
+	}
+	return name, cleanPath(p)
+}
+
+// cmdCp copies objects between remotes. Objects are read through the
+// transforms of the source and written through those of the destination,
+// so they are decrypted and re-encrypted as needed.
+//
+// With -r, every object under src is copied under dst, keeping its path
+// relative to src.
+func cmdCp(ctx context.Context, o *options, args []string, std stdio) (err error) {
+	flags := flag.NewFlagSet("cp", flag.ContinueOnError)
+	recursive := flags.Bool("r", false, "")
+	concurrency := flags.Int("c", 4, "")
+	retries := flags.Int("retries", 3, "")
+	checkpoint := flags.String("checkpoint", "", "")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errUsage
+	}
+	srcName, srcPath := splitRemote(flags.Arg(0))
+	dstName, dstPath := splitRemote(flags.Arg(1))
+
+	src, closeSrc, err := o.openNamed(ctx, srcName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := closeSrc(); err == nil {
+			err = closeErr
+		}
+	}()
+	dst, closeDst, err := o.openNamed(ctx, dstName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := closeDst(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	if !*recursive {
+		if srcPath == "" {
+			return errUsage
+		}
+		if dstPath == "" || strings.HasSuffix(flags.Arg(1), "/") {
+			dstPath = path.Join(dstPath, path.Base(srcPath))
+		}
+		return copyBetween(ctx, src, dst, srcPath, dstPath)
+	}
+
+	report, err := sync.CopyPrefix(ctx, src, &rebased{Storage: dst, from: srcPath, to: dstPath}, srcPath, sync.CopyOptions{
+		Concurrency: *concurrency,
+		Retries:     *retries,
+		Checkpoint:  *checkpoint,
+	})
+	fmt.Fprintf(std.err, "copied %d of %d objects (%d bytes)", report.Copied, report.Total, report.Bytes)
+	if report.Skipped > 0 {
+		fmt.Fprintf(std.err, ", %d already copied", report.Skipped)
+	}
+	if report.Failed > 0 {
+		fmt.Fprintf(std.err, ", %d failed", report.Failed)
+	}
+	fmt.Fprintln(std.err)
+	return err
+}
+
+// copyBetween copies a single object.
+func copyBetween(ctx context.Context, src, dst storage.Storage, srcPath, dstPath string) error {
+	rc, err := src.Get(ctx, srcPath)
+	if err != nil {
+		return err
+	}
+	err = storage.ConsumeObject(rc, func(r io.Reader) error { return dst.Put(ctx, dstPath, r) })
+	if err != nil {
+		return fmt.Errorf("%s: %w", dstPath, err)
+	}
+	return nil
+}
+
+// rebased writes the objects put under from to the same relative path
+// under to, so CopyPrefix can copy a prefix to a different one.
+type rebased struct {
+	storage.Storage
+	from, to string
+}
+
+func (r *rebased) Put(ctx context.Context, p string, rd io.Reader) error {
+	rel := strings.TrimPrefix(p, r.from)
+	if r.from != "" && rel != "" && !strings.HasPrefix(rel, "/") {
+		return fmt.Errorf("%q is outside %q", p, r.from)
+	}
+	return r.Storage.Put(ctx, path.Join(r.to, strings.TrimPrefix(rel, "/")), rd)
+}
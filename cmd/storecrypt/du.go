@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+)
+
+// dirUsage is the space taken by the objects of a directory.
+type dirUsage struct {
+	objects int
+	stored  int64
+	logical int64
+	unknown int // objects whose logical size is not recorded
+}
+
+func (u *dirUsage) add(fi storage.FileInfo) {
+	u.objects++
+	switch {
+	case fi.StoredSize > 0:
+		// The wrapper reported the logical size in Size.
+		u.stored += fi.StoredSize
+		u.logical += fi.Size
+	case fi.StoredPath == "" || fi.StoredPath == fi.Path:
+		// Stored as is.
+		u.stored += fi.Size
+		u.logical += fi.Size
+	default:
+		u.stored += fi.Size
+		u.unknown++
+	}
+}
+
+// cmdDu reports the objects and bytes under a prefix, grouped by the
+// top-level directories below it. Objects right under the prefix are
+// counted as ".".
+func cmdDu(ctx context.Context, o *options, args []string, std stdio) (err error) {
+	flags := flag.NewFlagSet("du", flag.ContinueOnError)
+	human := flags.Bool("h", false, "")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return errUsage
+	}
+	name, prefix := splitRemote(flags.Arg(0))
+	st, closeFn, err := o.openNamed(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := closeFn(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	groups := make(map[string]*dirUsage)
+	dirs, err := st.ListTopLevelDirs(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for dir := range dirs {
+		groups[topLevel(prefix, strings.TrimSuffix(dir, "/")+"/")] = &dirUsage{}
+	}
+	var total dirUsage
+	err = storage.WalkInfo(ctx, st, prefix, func(fi storage.FileInfo) error {
+		dir := topLevel(prefix, fi.Path)
+		if groups[dir] == nil {
+			groups[dir] = &dirUsage{}
+		}
+		groups[dir].add(fi)
+		total.add(fi)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(groups))
+	for dir := range groups {
+		names = append(names, dir)
+	}
+	sort.Strings(names)
+	size := func(n int64) string {
+		if *human {
+			return humanBytes(n)
+		}
+		return fmt.Sprint(n)
+	}
+	logical := func(u *dirUsage) string {
+		if u.unknown > 0 {
+			// A lower bound is misleading for compressed data.
+			return "-"
+		}
+		return size(u.logical)
+	}
+	tw := tabwriter.NewWriter(std.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "OBJECTS\tSTORED\tLOGICAL\tDIR")
+	for _, dir := range names {
+		u := groups[dir]
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", u.objects, size(u.stored), logical(u), dir)
+	}
+	fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", total.objects, size(total.stored), logical(&total), "total")
+	return tw.Flush()
+}
+
+// topLevel returns the first directory of p below prefix, or "." for an
+// object right under it.
+func topLevel(prefix, p string) string {
+	rel := strings.TrimPrefix(p, prefix)
+	if prefix != "" {
+		rel = strings.TrimPrefix(rel, "/")
+	}
+	dir, _, ok := strings.Cut(rel, "/")
+	if !ok {
+		return "."
+	}
+	return path.Join(prefix, dir)
+}
+
+// humanBytes formats n with a binary unit, e.g. "1.5 MiB".
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hashmap-kz/storecrypt/pkg/keys"
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+)
+
+// keyIDLayout names generated keys after their creation time.
+const keyIDLayout = "20060102T150405Z"
+
+// cmdKey manages the keyring file given with -keyring: generate adds a
+// key, list shows the keys and which of them objects still reference,
+// rotate makes a new primary key and rewraps the objects under it.
+func cmdKey(ctx context.Context, o *options, args []string, std stdio) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	if o.keyring == "" {
+		return errors.New("no keyring file: set -keyring or $STORECRYPT_KEYRING")
+	}
+	switch args[0] {
+	case "generate":
+		return cmdKeyGenerate(o, args[1:], std)
+	case "list":
+		return cmdKeyList(ctx, o, args[1:], std)
+	case "rotate":
+		return cmdKeyRotate(ctx, o, args[1:], std)
+	}
+	return errUsage
+}
+
+func cmdKeyGenerate(o *options, args []string, std stdio) error {
+	flags := flag.NewFlagSet("key generate", flag.ContinueOnError)
+	id := flags.String("id", "", "")
+	primary := flags.Bool("primary", false, "")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return errUsage
+	}
+	keyID, err := addKey(o.keyring, *id, *primary)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(std.out, keyID)
+	return nil
+}
+
+// addKey generates a key and adds it to the keyring file, named after the
+// current time if id is empty.
+func addKey(path, id string, primary bool) (string, error) {
+	if id == "" {
+		id = time.Now().UTC().Format(keyIDLayout)
+	}
+	key, err := keys.Generate()
+	if err != nil {
+		return "", err
+	}
+	if err := keys.AddToFile(path, id, key, primary); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func cmdKeyList(ctx context.Context, o *options, args []string, std stdio) (err error) {
+	flags := flag.NewFlagSet("key list", flag.ContinueOnError)
+	refs := flags.Bool("refs", false, "")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 || (!*refs && flags.NArg() != 0) {
+		return errUsage
+	}
+	kr, err := keys.Load(o.keyring)
+	if err != nil {
+		return err
+	}
+	mark := func(id string) string {
+		if id == kr.Primary() {
+			return "*"
+		}
+		return " "
+	}
+	if !*refs {
+		for _, id := range kr.IDs() {
+			fmt.Fprintf(std.out, "%s %s\n", mark(id), id)
+		}
+		return nil
+	}
+
+	name, prefix := splitRemote(flags.Arg(0))
+	backend, closeFn, err := openBackend(ctx, o, name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := closeFn(); err == nil {
+			err = closeErr
+		}
+	}()
+	usage, err := keys.Usage(ctx, backend, prefix)
+	if err != nil {
+		return err
+	}
+
+	ids := kr.IDs()
+	known := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		known[id] = true
+	}
+	var missing []string
+	for id := range usage {
+		if !known[id] {
+			missing = append(missing, id)
+		}
+	}
+	sort.Strings(missing)
+
+	tw := tabwriter.NewWriter(std.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  KEY\tOBJECTS\t")
+	for _, id := range ids {
+		note := ""
+		if usage[id] == 0 {
+			note = "unreferenced"
+		}
+		fmt.Fprintf(tw, "%s %s\t%d\t%s\n", mark(id), id, usage[id], note)
+	}
+	for _, id := range missing {
+		fmt.Fprintf(tw, "  %s\t%d\tMISSING from keyring\n", id, usage[id])
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%d key(s) referenced by objects are not in %s", len(missing), o.keyring)
+	}
+	return nil
+}
+
+func cmdKeyRotate(ctx context.Context, o *options, args []string, std stdio) (err error) {
+	flags := flag.NewFlagSet("key rotate", flag.ContinueOnError)
+	id := flags.String("id", "", "")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return errUsage
+	}
+	name, prefix := splitRemote(flags.Arg(0))
+
+	// Connect first, so a bad remote does not leave a new primary key
+	// behind with nothing rewrapped.
+	backend, closeFn, err := openBackend(ctx, o, name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := closeFn(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	keyID, err := addKey(o.keyring, *id, true)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(std.err, "new primary key %s\n", keyID)
+	kr, err := keys.Load(o.keyring)
+	if err != nil {
+		return err
+	}
+	es, err := kr.EnvelopeStorage(backend)
+	if err != nil {
+		return err
+	}
+	n, err := es.RewrapAll(ctx, prefix)
+	fmt.Fprintf(std.err, "rewrapped %d objects\n", n)
+	if err != nil {
+		// The objects left behind stay readable under their old key.
+		return fmt.Errorf("rewrap: %w", err)
+	}
+	return nil
+}
+
+// openBackend opens the remote like openNamed and returns its backend, on
+// which envelope headers are read and rewritten directly.
+func openBackend(ctx context.Context, o *options, name string) (storage.Storage, func() error, error) {
+	st, closeFn, err := o.openNamed(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	vs, ok := st.(*storage.VariadicStorage)
+	if !ok {
+		_ = closeFn()
+		return nil, nil, fmt.Errorf("unexpected storage %T", st)
+	}
+	return vs.Backend, closeFn, nil
+}
@@ -0,0 +1,169 @@
+// Command storecrypt performs ad-hoc operations on storecrypt archives:
+// storing, fetching, listing and removing objects through the same
+// compression and encryption the library applies.
+//
+// Usage:
+//
+//	storecrypt [flags] <command> [args]
+//
+// The backend and the transforms are chosen with flags, each of which can
+// also be set from the environment (run "storecrypt -h" for the list), or
+// taken from a named remote of the config file with -remote.
+// The password of the ".aes" crypter is only read from STORECRYPT_PASSWORD
+// or -password-file, never from the command line.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+)
+
+// Exit codes.
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// errUsage makes run print the command's usage and exit with exitUsage.
+var errUsage = errors.New("usage")
+
+// exitStatus is returned by commands that report through the exit code
+// alone, e.g. exists.
+type exitStatus int
+
+func (e exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+// stdio groups the streams of a command.
+type stdio struct {
+	in  io.Reader
+	out io.Writer
+	err io.Writer
+}
+
+type command struct {
+	usage string
+	help  string
+	run   func(ctx context.Context, st storage.Storage, args []string, std stdio) error
+
+	// runRemotes, set instead of run, is given the options rather than
+	// an opened storage, for commands that address several remotes.
+	runRemotes func(ctx context.Context, o *options, args []string, std stdio) error
+}
+
+var commands = map[string]command{
+	"put":    {usage: "put <local-file|-> <path>", help: "store a local file (or stdin) at path", run: cmdPut},
+	"get":    {usage: "get <path> [local-file|-]", help: "fetch path into a local file (default: its base name) or stdout", run: cmdGet},
+	"cat":    {usage: "cat <path>...", help: "write objects to stdout", run: cmdCat},
+	"ls":     {usage: "ls [-l] [prefix]", help: "list objects under prefix; -l adds stored size and time", run: cmdLs},
+	"rm":     {usage: "rm [-r] <path>...", help: "remove objects; -r removes everything under the paths", run: cmdRm},
+	"stat":   {usage: "stat <path>", help: "print the stored size and modification time of path", run: cmdStat},
+	"exists": {usage: "exists <path>", help: "exit 0 if path exists, 1 if it does not", run: cmdExists},
+	"sync":   {usage: "sync [-delete] [-dry-run] [-checksum] [-bwlimit N[K|M|G]] [-transfers N] [remote:]src [remote:]dst", help: "mirror new and changed objects from src to dst", runRemotes: cmdSync},
+	"serve":  {usage: "serve http [-listen addr] [-user name] [-index]", help: "serve objects read-only over HTTP (password: $STORECRYPT_HTTP_PASSWORD)", run: cmdServe},
+	"du":     {usage: "du [-h] [remote:][prefix]", help: "count objects and bytes under prefix by top-level directory", runRemotes: cmdDu},
+	"key":    {usage: "key generate|list|rotate [flags] [remote:][prefix]", help: "manage the -keyring file; rotate rewraps objects under a new primary key", runRemotes: cmdKey},
+	"cp":     {usage: "cp [-r] [remote:]src [remote:]dst", help: "copy objects between remotes, re-encoding them for the destination", runRemotes: cmdCp},
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, os.Args[1:], stdio{in: os.Stdin, out: os.Stdout, err: os.Stderr})
+	stop()
+	os.Exit(code)
+}
+
+func run(ctx context.Context, args []string, std stdio) int {
+	var opts options
+	fs := flag.NewFlagSet("storecrypt", flag.ContinueOnError)
+	fs.SetOutput(std.err)
+	opts.register(fs)
+	fs.Usage = func() { usage(fs, std.err) }
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		usage(fs, std.err)
+		return exitUsage
+	}
+	name := fs.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(std.err, "storecrypt: unknown command %q\n", name)
+		usage(fs, std.err)
+		return exitUsage
+	}
+
+	var err error
+	if cmd.runRemotes != nil {
+		err = cmd.runRemotes(ctx, &opts, fs.Args()[1:], std)
+	} else {
+		st, closeFn, openErr := opts.open(ctx)
+		if openErr != nil {
+			fmt.Fprintf(std.err, "storecrypt: %v\n", openErr)
+			return exitError
+		}
+		err = cmd.run(ctx, st, fs.Args()[1:], std)
+		if closeErr := closeFn(); err == nil && closeErr != nil {
+			err = closeErr
+		}
+	}
+
+	var status exitStatus
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &status):
+		return int(status)
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(std.err, "usage: storecrypt [flags] %s\n", cmd.usage)
+		return exitUsage
+	default:
+		fmt.Fprintf(std.err, "storecrypt %s: %v\n", name, err)
+		return exitError
+	}
+}
+
+func usage(fs *flag.FlagSet, w io.Writer) {
+	fmt.Fprintln(w, "usage: storecrypt [flags] <command> [args]")
+	fmt.Fprintln(w, "\ncommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-48s %s\n", commands[name].usage, commands[name].help)
+	}
+	fmt.Fprintln(w, "\nflags:")
+	fs.PrintDefaults()
+}
+
+// parseFlags parses the flags of a subcommand.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	return nil
+}
+
+// cleanPath strips the slashes storage paths do not use.
+func cleanPath(p string) string {
+	return strings.Trim(p, "/")
+}
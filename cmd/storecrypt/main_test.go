@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cli runs the command against a local archive in dir and returns the exit
+// code and the output.
+func cli(t *testing.T, dir, stdin string, args ...string) (code int, stdout, stderr string) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	args = append([]string{"-backend", "local", "-dir", dir}, args...)
+	code = run(context.Background(), args, stdio{in: strings.NewReader(stdin), out: &out, err: &errOut})
+	return code, out.String(), errOut.String()
+}
+
+func TestCLI_PutGetCat(t *testing.T) {
+	t.Setenv("STORECRYPT_PASSWORD", "secret")
+	archive, work := t.TempDir(), t.TempDir()
+
+	src := filepath.Join(work, "src.txt")
+	require.NoError(t, os.WriteFile(src, []byte("hello"), 0o600))
+	code, _, stderr := cli(t, archive, "", "put", src, "docs/a.txt")
+	require.Equal(t, exitOK, code, stderr)
+	code, _, stderr = cli(t, archive, "from stdin", "put", "-", "/docs/b.txt")
+	require.Equal(t, exitOK, code, stderr)
+
+	// Stored compressed and encrypted.
+	assert.FileExists(t, filepath.Join(archive, "docs", "a.txt.gz.aes"))
+	assert.NoFileExists(t, filepath.Join(archive, "docs", "a.txt"))
+
+	code, stdout, _ := cli(t, archive, "", "cat", "docs/a.txt", "docs/b.txt")
+	require.Equal(t, exitOK, code)
+	assert.Equal(t, "hellofrom stdin", stdout)
+
+	dst := filepath.Join(work, "out.txt")
+	code, _, stderr = cli(t, archive, "", "get", "docs/a.txt", dst)
+	require.Equal(t, exitOK, code, stderr)
+	data, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	// The wrong password fails and leaves no file behind.
+	t.Setenv("STORECRYPT_PASSWORD", "wrong")
+	bad := filepath.Join(work, "bad.txt")
+	code, _, _ = cli(t, archive, "", "get", "docs/a.txt", bad)
+	assert.Equal(t, exitError, code)
+	assert.NoFileExists(t, bad)
+	entries, err := os.ReadDir(work)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestCLI_LsRmExistsStat(t *testing.T) {
+	archive := t.TempDir()
+	for _, p := range []string{"a/1", "a/2", "b/1"} {
+		code, _, stderr := cli(t, archive, "data", "-compress", "none", "put", "-", p)
+		require.Equal(t, exitOK, code, stderr)
+	}
+
+	code, stdout, _ := cli(t, archive, "", "ls")
+	require.Equal(t, exitOK, code)
+	assert.Equal(t, "a/1\na/2\nb/1\n", stdout)
+
+	code, stdout, _ = cli(t, archive, "", "ls", "-l", "a")
+	require.Equal(t, exitOK, code)
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, []string{"4", "a/1"}, []string{strings.Fields(lines[0])[0], strings.Fields(lines[0])[2]})
+
+	code, stdout, _ = cli(t, archive, "", "stat", "a/1")
+	require.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, "size:    4\n")
+
+	code, _, _ = cli(t, archive, "", "exists", "a/1")
+	assert.Equal(t, exitOK, code)
+	code, _, _ = cli(t, archive, "", "exists", "a/missing")
+	assert.Equal(t, 1, code)
+
+	code, _, _ = cli(t, archive, "", "rm", "a/1")
+	require.Equal(t, exitOK, code)
+	code, _, _ = cli(t, archive, "", "rm", "-r", "a")
+	require.Equal(t, exitOK, code)
+	code, stdout, _ = cli(t, archive, "", "ls")
+	require.Equal(t, exitOK, code)
+	assert.Equal(t, "b/1\n", stdout)
+
+	code, _, stderr := cli(t, archive, "", "rm", "-r", "/")
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, stderr, "refusing")
+}
+
+func TestCLI_Usage(t *testing.T) {
+	archive := t.TempDir()
+	code, _, stderr := cli(t, archive, "")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "commands:")
+
+	code, _, stderr = cli(t, archive, "", "frobnicate")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, `unknown command "frobnicate"`)
+
+	code, _, stderr = cli(t, archive, "", "put", "only-one-arg")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "usage: storecrypt [flags] put")
+
+	code, _, stderr = cli(t, archive, "", "serve", "ftp")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "usage: storecrypt [flags] serve http")
+
+	code, _, stderr = cli(t, archive, "", "-compress", "lz4", "ls")
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, stderr, `unknown codec "lz4"`)
+
+	code, _, stderr = cli(t, archive, "", "-compress", "zstd", "-compress-level", "30", "ls")
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, stderr, "invalid compression level 30")
+}
+
+func TestCLI_Remote(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(config, []byte("remotes:\n  scratch:\n    backend: local\n    local:\n      dir: data\n    codec: zstd\n"), 0o600))
+	t.Setenv("STORECRYPT_CONFIG", config)
+
+	// The backend flags of cli are overridden by the remote.
+	code, _, stderr := cli(t, t.TempDir(), "x", "-remote", "scratch", "put", "-", "a.txt")
+	require.Equal(t, exitOK, code, stderr)
+	assert.FileExists(t, filepath.Join(dir, "data", "a.txt.zst"))
+
+	code, _, stderr = cli(t, t.TempDir(), "", "-remote", "nope", "ls")
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, stderr, `unknown remote "nope"`)
+}
+
+func TestCLI_Cp(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(config, []byte(`remotes:
+  plain:
+    backend: local
+    local:
+      dir: plain
+    codec: none
+  secret:
+    backend: local
+    local:
+      dir: secret
+    codec: zstd
+    crypter: aes
+`), 0o600))
+	t.Setenv("STORECRYPT_CONFIG", config)
+	t.Setenv("STORECRYPT_PASSWORD", "secret")
+	for _, p := range []string{"pg/a", "pg/sub/b", "other/c"} {
+		code, _, stderr := cli(t, dir, p, "-remote", "plain", "put", "-", p)
+		require.Equal(t, exitOK, code, stderr)
+	}
+
+	code, _, stderr := cli(t, dir, "", "cp", "plain:pg/a", "secret:copies/")
+	require.Equal(t, exitOK, code, stderr)
+	assert.FileExists(t, filepath.Join(dir, "secret", "copies", "a.zst.aes"))
+
+	code, _, stderr = cli(t, dir, "", "cp", "-r", "plain:pg", "secret:backup/pg")
+	require.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, stderr, "copied 2 of 2 objects")
+	assert.FileExists(t, filepath.Join(dir, "secret", "backup", "pg", "sub", "b.zst.aes"))
+	assert.NoFileExists(t, filepath.Join(dir, "secret", "backup", "pg", "c.zst.aes"))
+
+	// And back, decrypting on the way.
+	code, _, stderr = cli(t, dir, "", "cp", "secret:backup/pg/sub/b", "plain:restored")
+	require.Equal(t, exitOK, code, stderr)
+	data, err := os.ReadFile(filepath.Join(dir, "plain", "restored"))
+	require.NoError(t, err)
+	assert.Equal(t, "pg/sub/b", string(data))
+
+	code, _, stderr = cli(t, dir, "", "cp", "nope:x", "plain:y")
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, stderr, `unknown remote "nope"`)
+}
+
+func TestCLI_Du(t *testing.T) {
+	archive := t.TempDir()
+	for p, compress := range map[string]string{"a/1": "none", "a/x/2": "none", "b/1": "gzip", "top": "none"} {
+		code, _, stderr := cli(t, archive, "0123456789", "-compress", compress, "put", "-", p)
+		require.Equal(t, exitOK, code, stderr)
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(archive, "empty"), 0o750))
+
+	code, stdout, stderr := cli(t, archive, "", "du")
+	require.Equal(t, exitOK, code, stderr)
+	rows := make(map[string][]string)
+	for _, line := range strings.Split(strings.TrimSpace(stdout), "\n")[1:] {
+		f := strings.Fields(line)
+		rows[f[3]] = f[:3]
+	}
+	assert.Equal(t, []string{"1", "10", "10"}, rows["."])
+	assert.Equal(t, []string{"2", "20", "20"}, rows["a"])
+	assert.Equal(t, "-", rows["b"][2]) // gzip: logical size unknown
+	assert.Equal(t, []string{"0", "0", "0"}, rows["empty"])
+	assert.Equal(t, "4", rows["total"][0])
+
+	code, stdout, stderr = cli(t, archive, "", "du", "a")
+	require.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, stdout, "a/x\n")
+}
+
+func TestHumanBytes(t *testing.T) {
+	assert.Equal(t, "512 B", humanBytes(512))
+	assert.Equal(t, "1.5 KiB", humanBytes(1536))
+	assert.Equal(t, "2.0 GiB", humanBytes(2<<30))
+}
+
+func TestCLI_Sync(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(config, []byte(`remotes:
+  src:
+    backend: local
+    local:
+      dir: src
+    codec: none
+  dst:
+    backend: local
+    local:
+      dir: dst
+    codec: gzip
+`), 0o600))
+	t.Setenv("STORECRYPT_CONFIG", config)
+	for _, p := range []string{"pg/a", "pg/b"} {
+		code, _, stderr := cli(t, dir, p, "-remote", "src", "put", "-", p)
+		require.Equal(t, exitOK, code, stderr)
+	}
+	code, _, stderr := cli(t, dir, "stale", "-remote", "dst", "put", "-", "pg/stale")
+	require.Equal(t, exitOK, code, stderr)
+
+	code, _, stderr = cli(t, dir, "", "sync", "-dry-run", "-delete", "src:pg", "dst:")
+	require.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, stderr, "would copy 2 objects")
+	assert.NoFileExists(t, filepath.Join(dir, "dst", "pg", "a.gz"))
+
+	code, _, stderr = cli(t, dir, "", "sync", "-delete", "-checksum", "-transfers", "1", "-bwlimit", "1M", "src:pg", "dst:pg")
+	require.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, stderr, "copied 2 objects")
+	assert.Contains(t, stderr, "1 deleted")
+	assert.FileExists(t, filepath.Join(dir, "dst", "pg", "a.gz"))
+	assert.NoFileExists(t, filepath.Join(dir, "dst", "pg", "stale.gz"))
+
+	code, _, stderr = cli(t, dir, "", "sync", "-checksum", "src:pg", "dst:pg")
+	require.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, stderr, "copied 0 objects (0 bytes), 2 unchanged")
+
+	code, _, stderr = cli(t, dir, "", "sync", "src:pg", "dst:other")
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, stderr, "same prefix")
+}
+
+func TestParseBytes(t *testing.T) {
+	for s, want := range map[string]int64{"": 0, "100": 100, "512K": 512 << 10, "10m": 10 << 20, "1GB": 1 << 30} {
+		n, err := parseBytes(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, want, n, s)
+	}
+	_, err := parseBytes("fast")
+	assert.Error(t, err)
+}
+
+func TestCLI_Key(t *testing.T) {
+	archive, work := t.TempDir(), t.TempDir()
+	ring := filepath.Join(work, "ring.json")
+
+	code, _, stderr := cli(t, archive, "", "key", "list")
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, stderr, "no keyring file")
+
+	code, stdout, stderr := cli(t, archive, "", "-keyring", ring, "key", "generate", "-id", "k1")
+	require.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "k1\n", stdout)
+	for _, p := range []string{"pg/a", "pg/b"} {
+		code, _, stderr = cli(t, archive, p, "-keyring", ring, "-compress", "none", "put", "-", p)
+		require.Equal(t, exitOK, code, stderr)
+	}
+	assert.FileExists(t, filepath.Join(archive, "pg", "a.enc"))
+
+	code, stdout, stderr = cli(t, archive, "", "-keyring", ring, "key", "generate", "-id", "spare")
+	require.Equal(t, exitOK, code, stderr)
+	code, stdout, _ = cli(t, archive, "", "-keyring", ring, "key", "list")
+	require.Equal(t, exitOK, code)
+	assert.Equal(t, "* k1\n  spare\n", stdout)
+
+	code, _, stderr = cli(t, archive, "", "-keyring", ring, "key", "rotate", "-id", "k2", "pg")
+	require.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, stderr, "new primary key k2")
+	assert.Contains(t, stderr, "rewrapped 2 objects")
+
+	code, stdout, stderr = cli(t, archive, "", "-keyring", ring, "key", "list", "-refs", "pg")
+	require.Equal(t, exitOK, code, stderr)
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	require.Len(t, lines, 4)
+	assert.Equal(t, []string{"k1", "0", "unreferenced"}, strings.Fields(lines[1]))
+	assert.Equal(t, []string{"*", "k2", "2"}, strings.Fields(lines[2]))
+
+	code, stdout, _ = cli(t, archive, "", "-keyring", ring, "cat", "pg/a")
+	require.Equal(t, exitOK, code)
+	assert.Equal(t, "pg/a", stdout)
+
+	// Objects under a key the ring lacks are reported.
+	other := filepath.Join(work, "other.json")
+	code, _, stderr = cli(t, archive, "", "-keyring", other, "key", "generate", "-id", "k3")
+	require.Equal(t, exitOK, code, stderr)
+	code, stdout, stderr = cli(t, archive, "", "-keyring", other, "key", "list", "-refs", "pg")
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, stdout, "MISSING")
+	assert.Contains(t, stderr, "not in")
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hashmap-kz/storecrypt/pkg/keys"
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+)
+
+// options select the backend and the transforms, either from a named
+// remote of the config file or from the flags.
+type options struct {
+	remote string
+	config string
+
+	rc           storage.RemoteConfig
+	passwordFile string
+
+	keyring string
+}
+
+// register defines the flags, with defaults taken from the environment.
+func (o *options) register(fs *flag.FlagSet) {
+	str := func(p *string, name, env, def, help string) {
+		fs.StringVar(p, name, envOr(env, def), help+" ($"+env+")")
+	}
+	boolean := func(p *bool, name, env, help string) {
+		def, _ := strconv.ParseBool(os.Getenv(env))
+		fs.BoolVar(p, name, def, help+" ($"+env+")")
+	}
+	integer := func(p *int, name, env, help string) {
+		def, _ := strconv.Atoi(os.Getenv(env))
+		fs.IntVar(p, name, def, help+" ($"+env+")")
+	}
+	str(&o.remote, "remote", "STORECRYPT_REMOTE", "", "named remote of the config file, or a backend URL such as s3://bucket/prefix; the backend, compression and password flags are then ignored")
+	str(&o.config, "config", "STORECRYPT_CONFIG", "", "config file (default ~/.config/storecrypt/config.yaml)")
+
+	rc := &o.rc
+	str(&rc.Backend, "backend", "STORECRYPT_BACKEND", "local", "backend: local, s3 or sftp")
+	str(&rc.Prefix, "prefix", "STORECRYPT_PREFIX", "", "prefix (directory) of the archive in the backend")
+	str(&rc.Local.Dir, "dir", "STORECRYPT_DIR", ".", "local: base directory")
+
+	str(&rc.S3.Endpoint, "s3-endpoint", "STORECRYPT_S3_ENDPOINT", "", "s3: endpoint URL")
+	str(&rc.S3.Region, "s3-region", "STORECRYPT_S3_REGION", "us-east-1", "s3: region")
+	str(&rc.S3.Bucket, "s3-bucket", "STORECRYPT_S3_BUCKET", "", "s3: bucket")
+	str(&rc.S3.AccessKeyID, "s3-access-key", "AWS_ACCESS_KEY_ID", "", "s3: access key id")
+	str(&rc.S3.SecretAccessKey, "s3-secret-key", "AWS_SECRET_ACCESS_KEY", "", "s3: secret access key")
+	boolean(&rc.S3.PathStyle, "s3-path-style", "STORECRYPT_S3_PATH_STYLE", "s3: use path-style addressing")
+	boolean(&rc.S3.Insecure, "s3-insecure", "STORECRYPT_S3_INSECURE", "s3: skip TLS certificate verification")
+
+	str(&rc.SFTP.Host, "sftp-host", "STORECRYPT_SFTP_HOST", "", "sftp: host")
+	str(&rc.SFTP.Port, "sftp-port", "STORECRYPT_SFTP_PORT", "22", "sftp: port")
+	str(&rc.SFTP.User, "sftp-user", "STORECRYPT_SFTP_USER", "", "sftp: user")
+	str(&rc.SFTP.KeyFile, "sftp-key", "STORECRYPT_SFTP_KEY", "", "sftp: private key file")
+	str(&rc.SFTP.Passphrase, "sftp-passphrase", "STORECRYPT_SFTP_PASSPHRASE", "", "sftp: private key passphrase")
+
+	str(&rc.Codec, "compress", "STORECRYPT_COMPRESS", "gzip", "compression of new objects: gzip, zstd or none")
+	integer(&rc.CodecLevel, "compress-level", "STORECRYPT_COMPRESS_LEVEL", "compression level: 1-9 for gzip, 1-22 for zstd; 0 is the codec default")
+	str(&o.passwordFile, "password-file", "STORECRYPT_PASSWORD_FILE", "", `file holding the ".aes" password (default $STORECRYPT_PASSWORD)`)
+	str(&o.keyring, "keyring", "STORECRYPT_KEYRING", "", `keyring file; new objects are envelope-encrypted (".enc") with its primary key`)
+}
+
+func envOr(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+// remoteConfig returns the configuration to open: the named remote, or
+// the one the flags describe.
+func (o *options) remoteConfig() (storage.RemoteConfig, error) {
+	if strings.Contains(o.remote, "://") {
+		return storage.ParseURL(o.remote)
+	}
+	if o.remote != "" {
+		return o.namedConfig(o.remote)
+	}
+	rc := o.rc
+	if o.passwordFile != "" || os.Getenv(storage.DefaultPasswordEnv) != "" {
+		rc.Crypter = "aes"
+		rc.PasswordFile = o.passwordFile
+	}
+	return rc, nil
+}
+
+// namedConfig returns the configuration of a remote of the config file.
+func (o *options) namedConfig(name string) (storage.RemoteConfig, error) {
+	path := o.config
+	if path == "" {
+		var err error
+		if path, err = storage.DefaultConfigPath(); err != nil {
+			return storage.RemoteConfig{}, err
+		}
+	}
+	c, err := storage.LoadConfig(path)
+	if err != nil {
+		return storage.RemoteConfig{}, err
+	}
+	return c.Remote(name)
+}
+
+// open opens the storage selected by -remote or the flags.
+func (o *options) open(ctx context.Context) (storage.Storage, func() error, error) {
+	rc, err := o.remoteConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	return o.openConfig(ctx, rc)
+}
+
+// openNamed opens the named remote, or the storage selected by -remote or
+// the flags if name is empty.
+func (o *options) openNamed(ctx context.Context, name string) (storage.Storage, func() error, error) {
+	if name == "" {
+		return o.open(ctx)
+	}
+	rc, err := o.namedConfig(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return o.openConfig(ctx, rc)
+}
+
+// openConfig connects to the backend of rc and wraps it in a
+// VariadicStorage, so objects written with any configured transform can
+// be read. The close function releases the connection.
+func (o *options) openConfig(ctx context.Context, rc storage.RemoteConfig) (storage.Storage, func() error, error) {
+	alg, err := rc.Algorithms()
+	if err != nil {
+		return nil, nil, err
+	}
+	writeExt, err := rc.WriteExtension()
+	if err != nil {
+		return nil, nil, err
+	}
+	if o.keyring != "" {
+		kr, err := keys.Load(o.keyring)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := kr.Register(&alg); err != nil {
+			return nil, nil, err
+		}
+		// The keyring takes over encryption of new objects.
+		if rc.WriteExt == "" {
+			plain := rc
+			plain.Crypter = "none"
+			ext, _ := plain.WriteExtension()
+			writeExt = ext + ".enc"
+		}
+	}
+
+	backend, closeFn, err := rc.OpenBackend(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	vs, err := storage.NewVariadicStorage(backend, alg, writeExt)
+	if err != nil {
+		_ = closeFn()
+		return nil, nil, err
+	}
+	vs.DedupListInfo = true
+	return vs, closeFn, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/hashmap-kz/storecrypt/pkg/gateway"
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+)
+
+// shutdownTimeout bounds the wait for in-flight requests on exit.
+const shutdownTimeout = 30 * time.Second
+
+// cmdServe serves the storage read-only over HTTP until interrupted. The
+// basic auth password is read from STORECRYPT_HTTP_PASSWORD.
+func cmdServe(ctx context.Context, st storage.Storage, args []string, std stdio) error {
+	if len(args) == 0 || args[0] != "http" {
+		return errUsage
+	}
+	flags := flag.NewFlagSet("serve http", flag.ContinueOnError)
+	listen := flags.String("listen", "127.0.0.1:8080", "")
+	user := flags.String("user", "", "")
+	index := flags.Bool("index", false, "")
+	if err := parseFlags(flags, args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return errUsage
+	}
+	opts := gateway.Options{
+		Username: *user,
+		Password: os.Getenv("STORECRYPT_HTTP_PASSWORD"),
+		Index:    *index,
+		ErrorLog: func(r *http.Request, err error) {
+			fmt.Fprintf(std.err, "storecrypt serve: %s %s: %v\n", r.Method, r.URL.Path, err)
+		},
+	}
+	if opts.Username != "" && opts.Password == "" {
+		return errors.New("-user needs $STORECRYPT_HTTP_PASSWORD")
+	}
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:           gateway.New(st, opts),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	fmt.Fprintf(std.err, "serving on http://%s\n", ln.Addr())
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hashmap-kz/storecrypt/pkg/sync"
+)
+
+// cmdSync mirrors src to dst with the sync package: new and changed
+// objects are copied, re-encoded for the destination, and with -delete the
+// objects src no longer has are removed from dst.
+func cmdSync(ctx context.Context, o *options, args []string, std stdio) (err error) {
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	del := flags.Bool("delete", false, "")
+	dryRun := flags.Bool("dry-run", false, "")
+	checksum := flags.Bool("checksum", false, "")
+	bwlimit := flags.String("bwlimit", "", "")
+	transfers := flags.Int("transfers", sync.DefaultConcurrency, "")
+	state := flags.String("state", "", "")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errUsage
+	}
+	limit, err := parseBytes(*bwlimit)
+	if err != nil {
+		return fmt.Errorf("-bwlimit: %w", err)
+	}
+	srcName, srcPath := splitRemote(flags.Arg(0))
+	dstName, dstPath := splitRemote(flags.Arg(1))
+	if dstPath != "" && dstPath != srcPath {
+		return errors.New("sync mirrors a prefix to the same prefix; set the destination's prefix in its remote instead")
+	}
+
+	src, closeSrc, err := o.openNamed(ctx, srcName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := closeSrc(); err == nil {
+			err = closeErr
+		}
+	}()
+	dst, closeDst, err := o.openNamed(ctx, dstName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := closeDst(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	opts := sync.Options{
+		Prefix:         srcPath,
+		Delete:         *del,
+		Concurrency:    *transfers,
+		DryRun:         *dryRun,
+		BandwidthLimit: limit,
+		StatePath:      *state,
+	}
+	if *checksum {
+		opts.Compare = sync.CompareChecksum
+	} else {
+		// Destinations wrapped in transforms report stored sizes, which
+		// never match the source's.
+		opts.Compare = sync.CompareModTime
+	}
+	report, err := sync.Sync(ctx, src, dst, opts)
+	verb := "copied"
+	if *dryRun {
+		verb = "would copy"
+	}
+	fmt.Fprintf(std.err, "%s %d objects (%d bytes), %d unchanged", verb, report.Copied, report.Bytes, report.Skipped)
+	if *del {
+		fmt.Fprintf(std.err, ", %d deleted", report.Deleted)
+	}
+	if report.Failed > 0 {
+		fmt.Fprintf(std.err, ", %d failed", report.Failed)
+	}
+	fmt.Fprintln(std.err)
+	return err
+}
+
+// parseBytes parses a byte count with an optional binary suffix, e.g.
+// "512K" or "10M". An empty string is zero.
+func parseBytes(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	mult := int64(1)
+	num := strings.TrimSuffix(strings.ToUpper(s), "B")
+	switch {
+	case strings.HasSuffix(num, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(num, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(num, "G"):
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		num = num[:len(num)-1]
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
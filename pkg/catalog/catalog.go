@@ -0,0 +1,280 @@
+// Package catalog keeps an append-only record of what was written to a
+// storage: every Put (logical path, size, SHA-256, key id, time) and
+// every delete. Restores and audits can query the catalog instead of
+// listing, and reading, the whole backend.
+//
+// The catalog lives in segment objects under a directory of a backend,
+// normally the same one as the data (and wrapped the same way, so that it
+// is encrypted too). Segments are only ever added; each holds JSON lines.
+package catalog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+)
+
+// DefaultDir is the backend directory holding catalog segments.
+const DefaultDir = ".catalog"
+
+// segmentTimeFormat is sortable and safe in object names on any backend.
+const segmentTimeFormat = "20060102T150405.000000000Z"
+
+// Op is the kind of change an Entry records.
+type Op string
+
+const (
+	OpPut    Op = "put"
+	OpDelete Op = "delete"
+)
+
+// Entry is one catalog record.
+type Entry struct {
+	Op     Op        `json:"op"`
+	Path   string    `json:"path"`
+	Size   int64     `json:"size,omitempty"`   // plaintext bytes written
+	SHA256 string    `json:"sha256,omitempty"` // of the plaintext
+	KeyID  string    `json:"key_id,omitempty"` // encryption key, if known
+	Time   time.Time `json:"time"`
+}
+
+// Catalog appends entries to segment objects and answers queries over
+// them.
+type Catalog struct {
+	Backend storage.Storage
+
+	dir        string
+	flushEvery int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	pending []Entry
+}
+
+// New creates a catalog stored under DefaultDir in backend. Entries are
+// written as they are appended; see SetFlushEvery.
+func New(backend storage.Storage) *Catalog {
+	return &Catalog{Backend: backend, dir: DefaultDir, flushEvery: 1, now: time.Now}
+}
+
+// SetDir changes the backend directory holding the segments.
+func (c *Catalog) SetDir(dir string) {
+	c.dir = strings.Trim(dir, "/")
+}
+
+// Dir returns the backend directory holding the segments.
+func (c *Catalog) Dir() string {
+	return c.dir
+}
+
+// SetFlushEvery buffers up to n entries before writing them as one
+// segment, trading durability of the most recent entries for fewer
+// objects. Call Flush before exiting.
+func (c *Catalog) SetFlushEvery(n int) {
+	c.flushEvery = max(n, 1)
+}
+
+// Append records entries, stamping those without a time.
+func (c *Catalog) Append(ctx context.Context, entries ...Entry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range entries {
+		if e.Time.IsZero() {
+			e.Time = c.now().UTC()
+		}
+		c.pending = append(c.pending, e)
+	}
+	if len(c.pending) < c.flushEvery {
+		return nil
+	}
+	return c.flushLocked(ctx)
+}
+
+// Flush writes buffered entries.
+func (c *Catalog) Flush(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.flushLocked(ctx)
+}
+
+func (c *Catalog) flushLocked(ctx context.Context) error {
+	if len(c.pending) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range c.pending {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	name := path.Join(c.dir, c.now().UTC().Format(segmentTimeFormat)+"-"+hex.EncodeToString(suffix)+".jsonl")
+	if err := c.Backend.Put(ctx, name, &buf); err != nil {
+		return fmt.Errorf("write catalog segment: %w", err)
+	}
+	c.pending = c.pending[:0]
+	return nil
+}
+
+// Entries returns every entry, in the order they were appended, including
+// buffered ones.
+func (c *Catalog) Entries(ctx context.Context) ([]Entry, error) {
+	names, err := c.Backend.List(ctx, c.dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	sort.Strings(names)
+	var entries []Entry
+	for _, name := range names {
+		if entries, err = c.readSegment(ctx, name, entries); err != nil {
+			return nil, fmt.Errorf("read catalog segment %q: %w", name, err)
+		}
+	}
+	c.mu.Lock()
+	entries = append(entries, c.pending...)
+	c.mu.Unlock()
+	// Segments from concurrent writers may interleave.
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	return entries, nil
+}
+
+func (c *Catalog) readSegment(ctx context.Context, name string, entries []Entry) ([]Entry, error) {
+	rc, err := c.Backend.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	sc := bufio.NewScanner(rc)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
+
+// History returns every entry for p, oldest first.
+func (c *Catalog) History(ctx context.Context, p string) ([]Entry, error) {
+	entries, err := c.Entries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := entries[:0]
+	for _, e := range entries {
+		if e.Path == p {
+			result = append(result, e)
+		}
+	}
+	return result, nil
+}
+
+// Latest returns the last put of p; ok is false if p was never written or
+// has been deleted since.
+func (c *Catalog) Latest(ctx context.Context, p string) (e Entry, ok bool, err error) {
+	history, err := c.History(ctx, p)
+	if err != nil || len(history) == 0 {
+		return Entry{}, false, err
+	}
+	last := history[len(history)-1]
+	return last, last.Op == OpPut, nil
+}
+
+// Query selects objects from the catalog.
+type Query struct {
+	Prefix string    // only paths under this directory
+	Since  time.Time // only objects written at or after Since, if set
+	Until  time.Time // only objects written before Until, if set
+	KeyID  string    // only objects encrypted with this key, if set
+}
+
+// Query returns the latest put of every object matching q that has not
+// been deleted, sorted by path.
+func (c *Catalog) Query(ctx context.Context, q Query) ([]Entry, error) {
+	entries, err := c.Entries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	latest := make(map[string]Entry)
+	for _, e := range entries {
+		latest[e.Path] = e
+	}
+	var result []Entry
+	for _, e := range latest {
+		switch {
+		case e.Op != OpPut,
+			!inDir(e.Path, q.Prefix),
+			!q.Since.IsZero() && e.Time.Before(q.Since),
+			!q.Until.IsZero() && !e.Time.Before(q.Until),
+			q.KeyID != "" && e.KeyID != q.KeyID:
+			continue
+		}
+		result = append(result, e)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result, nil
+}
+
+// Manifest returns the objects under prefix as they were at time at
+// (the latest put of every object not deleted by then), in the form of a
+// snapshot manifest. A zero at means now. Two manifests can be compared
+// with storage.DiffSnapshots.
+func (c *Catalog) Manifest(ctx context.Context, prefix string, at time.Time) (*storage.SnapshotManifest, error) {
+	entries, err := c.Entries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if at.IsZero() {
+		at = c.now()
+	}
+	state := make(map[string]Entry)
+	for _, e := range entries {
+		if e.Time.After(at) {
+			break
+		}
+		if !inDir(e.Path, prefix) {
+			continue
+		}
+		if e.Op == OpPut {
+			state[e.Path] = e
+		} else {
+			delete(state, e.Path)
+		}
+	}
+	m := &storage.SnapshotManifest{
+		Name:      at.UTC().Format(time.RFC3339Nano),
+		Prefix:    strings.Trim(prefix, "/"),
+		CreatedAt: at.UTC(),
+	}
+	for _, e := range state {
+		m.Entries = append(m.Entries, storage.SnapshotEntry{Path: e.Path, Size: e.Size, ModTime: e.Time, SHA256: e.SHA256})
+	}
+	sort.Slice(m.Entries, func(i, j int) bool { return m.Entries[i].Path < m.Entries[j].Path })
+	return m, nil
+}
+
+// inDir reports whether p is dir or lies under it.
+func inDir(p, dir string) bool {
+	dir = strings.Trim(dir, "/")
+	return dir == "" || p == dir || strings.HasPrefix(p, dir+"/")
+}
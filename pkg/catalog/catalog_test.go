@@ -0,0 +1,173 @@
+package catalog
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// keyedStorage reports a key id, like a TransformingStorage with an
+// Envelope crypter.
+type keyedStorage struct {
+	*storage.InMemoryStorage
+	id string
+}
+
+func (k *keyedStorage) KeyID() string { return k.id }
+
+func newTestStorage(t *testing.T) (*Storage, *keyedStorage, *time.Time) {
+	t.Helper()
+	backend := &keyedStorage{InMemoryStorage: storage.NewInMemoryStorage(), id: "k1"}
+	cat := New(backend)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cat.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	return NewStorage(backend, cat), backend, &now
+}
+
+func sum(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func TestStorage_RecordsPutsAndDeletes(t *testing.T) {
+	ctx := context.Background()
+	s, backend, _ := newTestStorage(t)
+
+	require.NoError(t, s.Put(ctx, "base/0001", bytes.NewReader([]byte("one"))))
+	require.NoError(t, s.Put(ctx, "base/0002", bytes.NewReader([]byte("two!"))))
+	backend.id = "k2"
+	require.NoError(t, s.Put(ctx, "wal/0001", bytes.NewReader([]byte("wal"))))
+	require.NoError(t, s.Delete(ctx, "base/0001"))
+
+	e, ok, err := s.Catalog.Latest(ctx, "base/0002")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, int64(4), e.Size)
+	assert.Equal(t, sum("two!"), e.SHA256)
+	assert.Equal(t, "k1", e.KeyID)
+
+	_, ok, err = s.Catalog.Latest(ctx, "base/0001")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	history, err := s.Catalog.History(ctx, "base/0001")
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, OpPut, history[0].Op)
+	assert.Equal(t, OpDelete, history[1].Op)
+
+	all, err := s.Catalog.Query(ctx, Query{})
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	assert.Equal(t, "base/0002", all[0].Path)
+	assert.Equal(t, "wal/0001", all[1].Path)
+
+	byKey, err := s.Catalog.Query(ctx, Query{KeyID: "k2"})
+	require.NoError(t, err)
+	require.Len(t, byKey, 1)
+	assert.Equal(t, "wal/0001", byKey[0].Path)
+
+	byPrefix, err := s.Catalog.Query(ctx, Query{Prefix: "base"})
+	require.NoError(t, err)
+	require.Len(t, byPrefix, 1)
+	assert.Equal(t, "base/0002", byPrefix[0].Path)
+
+	since, err := s.Catalog.Query(ctx, Query{Since: byKey[0].Time})
+	require.NoError(t, err)
+	require.Len(t, since, 1)
+	assert.Equal(t, "wal/0001", since[0].Path)
+
+	// The catalog is not part of the data.
+	files, err := s.List(ctx, ".catalog")
+	require.NoError(t, err)
+	assert.Empty(t, files)
+	segments, err := backend.List(ctx, ".catalog")
+	require.NoError(t, err)
+	assert.Len(t, segments, 4)
+}
+
+func TestStorage_RenameAndDeleteAll(t *testing.T) {
+	ctx := context.Background()
+	s, _, _ := newTestStorage(t)
+
+	require.NoError(t, s.Put(ctx, "tmp/a", bytes.NewReader([]byte("a"))))
+	require.NoError(t, s.Put(ctx, "tmp/b", bytes.NewReader([]byte("b"))))
+	require.NoError(t, s.Rename(ctx, "tmp/a", "done/a"))
+
+	e, ok, err := s.Catalog.Latest(ctx, "done/a")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, sum("a"), e.SHA256)
+	_, ok, err = s.Catalog.Latest(ctx, "tmp/a")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, s.DeleteAll(ctx, "tmp"))
+	left, err := s.Catalog.Query(ctx, Query{})
+	require.NoError(t, err)
+	require.Len(t, left, 1)
+	assert.Equal(t, "done/a", left[0].Path)
+}
+
+func TestCatalog_FlushEvery(t *testing.T) {
+	ctx := context.Background()
+	s, backend, _ := newTestStorage(t)
+	s.Catalog.SetFlushEvery(3)
+
+	for _, p := range []string{"a/1", "a/2", "a/3", "a/4"} {
+		require.NoError(t, s.Put(ctx, p, bytes.NewReader([]byte(p))))
+	}
+	segments, err := backend.List(ctx, ".catalog")
+	require.NoError(t, err)
+	assert.Len(t, segments, 1)
+
+	// Buffered entries are visible before they are flushed.
+	got, err := s.Catalog.Query(ctx, Query{Prefix: "a"})
+	require.NoError(t, err)
+	assert.Len(t, got, 4)
+
+	require.NoError(t, s.Catalog.Flush(ctx))
+	segments, err = backend.List(ctx, ".catalog")
+	require.NoError(t, err)
+	assert.Len(t, segments, 2)
+
+	reopened := New(backend)
+	got, err = reopened.Query(ctx, Query{Prefix: "a"})
+	require.NoError(t, err)
+	assert.Len(t, got, 4)
+}
+
+func TestCatalog_ManifestDiff(t *testing.T) {
+	ctx := context.Background()
+	s, _, now := newTestStorage(t)
+
+	require.NoError(t, s.Put(ctx, "wal/1", bytes.NewReader([]byte("one"))))
+	require.NoError(t, s.Put(ctx, "wal/2", bytes.NewReader([]byte("two"))))
+	gen1 := *now
+	require.NoError(t, s.Put(ctx, "wal/1", bytes.NewReader([]byte("uno"))))
+	require.NoError(t, s.Delete(ctx, "wal/2"))
+	require.NoError(t, s.Put(ctx, "wal/3", bytes.NewReader([]byte("three"))))
+
+	from, err := s.Catalog.Manifest(ctx, "wal", gen1)
+	require.NoError(t, err)
+	require.Len(t, from.Entries, 2)
+	to, err := s.Catalog.Manifest(ctx, "wal", time.Time{})
+	require.NoError(t, err)
+
+	d := storage.DiffSnapshots(from, to)
+	require.Len(t, d.Added, 1)
+	assert.Equal(t, "wal/3", d.Added[0].Path)
+	require.Len(t, d.Removed, 1)
+	assert.Equal(t, "wal/2", d.Removed[0].Path)
+	require.Len(t, d.Changed, 1)
+	assert.Equal(t, sum("uno"), d.Changed[0].To.SHA256)
+}
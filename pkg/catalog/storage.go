@@ -0,0 +1,199 @@
+package catalog
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+)
+
+// Storage is a storage.Storage decorator that records every Put, delete
+// and rename of Backend in Catalog. If Backend implements
+// storage.KeyIdentifier (TransformingStorage does), the key id is recorded
+// with every put.
+//
+// The catalog directory is hidden from List, ListInfo and
+// ListTopLevelDirs.
+type Storage struct {
+	Backend storage.Storage
+	Catalog *Catalog
+}
+
+var _ storage.Storage = (*Storage)(nil)
+
+// NewStorage creates a cataloguing decorator around backend.
+func NewStorage(backend storage.Storage, cat *Catalog) *Storage {
+	return &Storage{Backend: backend, Catalog: cat}
+}
+
+func (s *Storage) isInternal(p string) bool {
+	return inDir(p, s.Catalog.Dir())
+}
+
+func (s *Storage) keyID() string {
+	if k, ok := s.Backend.(storage.KeyIdentifier); ok {
+		return k.KeyID()
+	}
+	return ""
+}
+
+type hashingReader struct {
+	r io.Reader
+	h io.Writer
+	n int64
+}
+
+func (hr *hashingReader) Read(p []byte) (int, error) {
+	n, err := hr.r.Read(p)
+	hr.h.Write(p[:n])
+	hr.n += int64(n)
+	return n, err
+}
+
+// Put stores the object and records its size and SHA-256.
+func (s *Storage) Put(ctx context.Context, remotePath string, r io.Reader) error {
+	h := sha256.New()
+	hr := &hashingReader{r: r, h: h}
+	if err := s.Backend.Put(ctx, remotePath, hr); err != nil {
+		return err
+	}
+	return s.Catalog.Append(ctx, Entry{
+		Op:     OpPut,
+		Path:   remotePath,
+		Size:   hr.n,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+		KeyID:  s.keyID(),
+	})
+}
+
+func (s *Storage) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	return s.Backend.Get(ctx, remotePath)
+}
+
+func (s *Storage) List(ctx context.Context, remotePath string) ([]string, error) {
+	files, err := s.Backend.List(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	result := files[:0]
+	for _, f := range files {
+		if !s.isInternal(f) {
+			result = append(result, f)
+		}
+	}
+	return result, nil
+}
+
+func (s *Storage) ListInfo(ctx context.Context, remotePath string) ([]storage.FileInfo, error) {
+	files, err := s.Backend.ListInfo(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	result := files[:0]
+	for _, f := range files {
+		if !s.isInternal(f.Path) {
+			result = append(result, f)
+		}
+	}
+	return result, nil
+}
+
+func (s *Storage) Delete(ctx context.Context, remotePath string) error {
+	if err := s.Backend.Delete(ctx, remotePath); err != nil {
+		return err
+	}
+	return s.recordDeletes(ctx, []string{remotePath})
+}
+
+func (s *Storage) DeleteAll(ctx context.Context, remotePath string) error {
+	files, err := s.listUnder(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+	if err := s.Backend.DeleteAll(ctx, remotePath); err != nil {
+		return err
+	}
+	return s.recordDeletes(ctx, files)
+}
+
+func (s *Storage) DeleteDir(ctx context.Context, remotePath string) error {
+	files, err := s.listUnder(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+	if err := s.Backend.DeleteDir(ctx, remotePath); err != nil {
+		return err
+	}
+	return s.recordDeletes(ctx, files)
+}
+
+func (s *Storage) DeleteAllBulk(ctx context.Context, paths []string) error {
+	var all []string
+	for _, p := range paths {
+		files, err := s.listUnder(ctx, p)
+		if err != nil {
+			return err
+		}
+		all = append(append(all, p), files...)
+	}
+	if err := s.Backend.DeleteAllBulk(ctx, paths); err != nil {
+		return err
+	}
+	return s.recordDeletes(ctx, all)
+}
+
+func (s *Storage) Exists(ctx context.Context, remotePath string) (bool, error) {
+	return s.Backend.Exists(ctx, remotePath)
+}
+
+func (s *Storage) ListTopLevelDirs(ctx context.Context, prefix string) (map[string]bool, error) {
+	dirs, err := s.Backend.ListTopLevelDirs(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	for d := range dirs {
+		if s.isInternal(d) {
+			delete(dirs, d)
+		}
+	}
+	return dirs, nil
+}
+
+// Rename moves the object and its catalog record.
+func (s *Storage) Rename(ctx context.Context, oldRemotePath, newRemotePath string) error {
+	if err := s.Backend.Rename(ctx, oldRemotePath, newRemotePath); err != nil {
+		return err
+	}
+	moved, ok, err := s.Catalog.Latest(ctx, oldRemotePath)
+	if err != nil {
+		return fmt.Errorf("catalog rename %q: %w", oldRemotePath, err)
+	}
+	if !ok {
+		moved = Entry{Op: OpPut, KeyID: s.keyID()}
+	}
+	moved.Path, moved.Time = newRemotePath, s.Catalog.now().UTC()
+	return s.Catalog.Append(ctx, Entry{Op: OpDelete, Path: oldRemotePath, Time: moved.Time}, moved)
+}
+
+func (s *Storage) listUnder(ctx context.Context, prefix string) ([]string, error) {
+	files, err := s.List(ctx, prefix)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (s *Storage) recordDeletes(ctx context.Context, paths []string) error {
+	entries := make([]Entry, 0, len(paths))
+	for _, p := range paths {
+		if !s.isInternal(p) {
+			entries = append(entries, Entry{Op: OpDelete, Path: p})
+		}
+	}
+	return s.Catalog.Append(ctx, entries...)
+}
@@ -0,0 +1,356 @@
+package clients
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// AzureStorageVersion is the Blob service REST API version requests
+	// are made with.
+	AzureStorageVersion = "2021-08-06"
+
+	azureStorageResource = "https://storage.azure.com/"
+	azureIMDSEndpoint    = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+	// Well-known account of the Azurite emulator.
+	azuriteAccount  = "devstoreaccount1"
+	azuriteKey      = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+	azuriteEndpoint = "http://127.0.0.1:10000/" + azuriteAccount
+)
+
+// AzureConfig configures NewAzureClient. Exactly one way to authenticate
+// is used, in this order: ConnectionString, SASToken, AccountKey, then
+// managed identity.
+type AzureConfig struct {
+	AccountName string
+	Container   string
+
+	// ConnectionString is the storage account connection string of the
+	// portal, with an account key or a SharedAccessSignature.
+	// "UseDevelopmentStorage=true" selects Azurite.
+	ConnectionString string
+
+	// SASToken is a shared access signature query string, with or
+	// without the leading "?".
+	SASToken string
+
+	AccountKey string
+
+	// ManagedIdentityClientID selects a user-assigned managed identity;
+	// empty uses the system-assigned one.
+	ManagedIdentityClientID string
+
+	// EndpointURL overrides https://<account>.blob.core.windows.net, e.g.
+	// for Azurite or sovereign clouds.
+	EndpointURL string
+	DisableSSL  bool
+}
+
+// AzureClient is an HTTP client authorized for the Blob service of one
+// storage account, to be used with its REST API under Endpoint.
+type AzureClient struct {
+	client    *http.Client
+	endpoint  string
+	container string
+}
+
+// NewAzureClient initializes the Azure client and sets up the container
+// name.
+func NewAzureClient(azureConfig *AzureConfig) (*AzureClient, error) {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.TLSClientConfig = &tls.Config{
+		//nolint:gosec
+		InsecureSkipVerify: azureConfig.DisableSSL,
+	}
+
+	account := azureConfig.AccountName
+	endpoint := azureConfig.EndpointURL
+	var rt http.RoundTripper
+	switch {
+	case azureConfig.ConnectionString != "":
+		cs, err := parseAzureConnectionString(azureConfig.ConnectionString)
+		if err != nil {
+			return nil, err
+		}
+		account = cs.account
+		if endpoint == "" {
+			endpoint = cs.endpoint
+		}
+		if cs.sas != "" {
+			if rt, err = newSASTransport(base, cs.sas); err != nil {
+				return nil, err
+			}
+		} else if rt, err = newSharedKeyTransport(base, cs.account, cs.key); err != nil {
+			return nil, err
+		}
+	case azureConfig.SASToken != "":
+		var err error
+		if rt, err = newSASTransport(base, azureConfig.SASToken); err != nil {
+			return nil, err
+		}
+	case azureConfig.AccountKey != "":
+		var err error
+		if rt, err = newSharedKeyTransport(base, account, azureConfig.AccountKey); err != nil {
+			return nil, err
+		}
+	default:
+		rt = &bearerTransport{
+			base: base,
+			src:  newManagedIdentityTokenSource(azureConfig.ManagedIdentityClientID),
+		}
+	}
+	if endpoint == "" {
+		if account == "" {
+			return nil, errors.New("azure: no account name or endpoint")
+		}
+		endpoint = "https://" + account + ".blob.core.windows.net"
+	}
+
+	return &AzureClient{
+		client:    &http.Client{Transport: &azureVersionTransport{base: rt}},
+		endpoint:  strings.TrimSuffix(endpoint, "/"),
+		container: azureConfig.Container,
+	}, nil
+}
+
+func (c *AzureClient) Client() *http.Client {
+	return c.client
+}
+
+// Endpoint returns the URL of the account's Blob service, without a
+// trailing slash.
+func (c *AzureClient) Endpoint() string {
+	return c.endpoint
+}
+
+func (c *AzureClient) Container() string {
+	return c.container
+}
+
+type azureConnectionString struct {
+	account  string
+	key      string
+	sas      string
+	endpoint string
+}
+
+func parseAzureConnectionString(s string) (*azureConnectionString, error) {
+	fields := make(map[string]string)
+	for _, part := range strings.Split(s, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("azure: malformed connection string field %q", k)
+		}
+		fields[strings.ToLower(strings.TrimSpace(k))] = strings.TrimSpace(v)
+	}
+	if strings.EqualFold(fields["usedevelopmentstorage"], "true") {
+		return &azureConnectionString{account: azuriteAccount, key: azuriteKey, endpoint: azuriteEndpoint}, nil
+	}
+
+	cs := &azureConnectionString{
+		account:  fields["accountname"],
+		key:      fields["accountkey"],
+		sas:      fields["sharedaccesssignature"],
+		endpoint: fields["blobendpoint"],
+	}
+	if cs.key == "" && cs.sas == "" {
+		return nil, errors.New("azure: connection string has neither AccountKey nor SharedAccessSignature")
+	}
+	if cs.endpoint == "" {
+		if cs.account == "" {
+			return nil, errors.New("azure: connection string has neither AccountName nor BlobEndpoint")
+		}
+		protocol := fields["defaultendpointsprotocol"]
+		if protocol == "" {
+			protocol = "https"
+		}
+		suffix := fields["endpointsuffix"]
+		if suffix == "" {
+			suffix = "core.windows.net"
+		}
+		cs.endpoint = protocol + "://" + cs.account + ".blob." + suffix
+	}
+	return cs, nil
+}
+
+// azureVersionTransport sets the API version header, which the service
+// requires for bearer authentication and which shared keys sign.
+type azureVersionTransport struct {
+	base http.RoundTripper
+}
+
+func (t *azureVersionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("x-ms-version") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("x-ms-version", AzureStorageVersion)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// sasTransport adds the parameters of a shared access signature to the
+// query of each request.
+type sasTransport struct {
+	base http.RoundTripper
+	sas  url.Values
+}
+
+func newSASTransport(base http.RoundTripper, token string) (*sasTransport, error) {
+	sas, err := url.ParseQuery(strings.TrimPrefix(token, "?"))
+	if err != nil {
+		return nil, fmt.Errorf("azure: parse SAS token: %w", err)
+	}
+	return &sasTransport{base: base, sas: sas}, nil
+}
+
+func (t *sasTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	q := req.URL.Query()
+	for k, vs := range t.sas {
+		q[k] = vs
+	}
+	req.URL.RawQuery = q.Encode()
+	return t.base.RoundTrip(req)
+}
+
+// sharedKeyTransport signs requests with the account key ("SharedKey"
+// authorization).
+type sharedKeyTransport struct {
+	base    http.RoundTripper
+	account string
+	key     []byte
+	now     func() time.Time
+}
+
+func newSharedKeyTransport(base http.RoundTripper, account, key string) (*sharedKeyTransport, error) {
+	if account == "" {
+		return nil, errors.New("azure: shared key authentication needs the account name")
+	}
+	k, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("azure: decode account key: %w", err)
+	}
+	return &sharedKeyTransport{base: base, account: account, key: k, now: time.Now}, nil
+}
+
+func (t *sharedKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("x-ms-date", t.now().UTC().Format(http.TimeFormat))
+	mac := hmac.New(sha256.New, t.key)
+	mac.Write([]byte(t.stringToSign(req)))
+	req.Header.Set("Authorization", "SharedKey "+t.account+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return t.base.RoundTrip(req)
+}
+
+// stringToSign builds the string signed by shared key authorization (see
+// "Authorize with Shared Key" in the Azure Storage REST reference).
+func (t *sharedKeyTransport) stringToSign(req *http.Request) string {
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+	h := req.Header
+	lines := []string{
+		req.Method,
+		h.Get("Content-Encoding"),
+		h.Get("Content-Language"),
+		contentLength,
+		h.Get("Content-MD5"),
+		h.Get("Content-Type"),
+		"", // Date: x-ms-date is signed instead
+		h.Get("If-Modified-Since"),
+		h.Get("If-Match"),
+		h.Get("If-None-Match"),
+		h.Get("If-Unmodified-Since"),
+		h.Get("Range"),
+	}
+
+	var msHeaders []string
+	for k := range h {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-ms-") {
+			msHeaders = append(msHeaders, lk)
+		}
+	}
+	sort.Strings(msHeaders)
+	for _, k := range msHeaders {
+		lines = append(lines, k+":"+strings.TrimSpace(strings.Join(h.Values(k), ",")))
+	}
+
+	// The account root (e.g. List Containers) is "/<account>/".
+	resource := "/" + t.account + req.URL.EscapedPath()
+	if req.URL.EscapedPath() == "" {
+		resource += "/"
+	}
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for k := range query {
+		params = append(params, k)
+	}
+	sort.Slice(params, func(i, j int) bool { return strings.ToLower(params[i]) < strings.ToLower(params[j]) })
+	for _, k := range params {
+		vs := append([]string(nil), query[k]...)
+		sort.Strings(vs)
+		resource += "\n" + strings.ToLower(k) + ":" + strings.Join(vs, ",")
+	}
+	return strings.Join(lines, "\n") + "\n" + resource
+}
+
+// managedIdentityTokenSource fetches tokens of the managed identity of the
+// host: from the App Service / Functions identity endpoint if present,
+// otherwise from the instance metadata service (VMs, AKS, Container Apps).
+type managedIdentityTokenSource struct {
+	clientID string
+}
+
+func newManagedIdentityTokenSource(clientID string) *managedIdentityTokenSource {
+	return &managedIdentityTokenSource{clientID: clientID}
+}
+
+func (s *managedIdentityTokenSource) token(ctx context.Context) (string, time.Time, error) {
+	q := url.Values{"resource": {azureStorageResource}}
+	if s.clientID != "" {
+		q.Set("client_id", s.clientID)
+	}
+	endpoint := azureIMDSEndpoint
+	header, secret := "Metadata", "true"
+	if ep := os.Getenv("IDENTITY_ENDPOINT"); ep != "" && os.Getenv("IDENTITY_HEADER") != "" {
+		endpoint = ep
+		header, secret = "X-IDENTITY-HEADER", os.Getenv("IDENTITY_HEADER")
+		q.Set("api-version", "2019-08-01")
+	} else {
+		q.Set("api-version", "2018-02-01")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+q.Encode(), nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set(header, secret)
+	// Identity endpoints are local to the host; bypass any proxy.
+	hc := &http.Client{Transport: &http.Transport{}, Timeout: 10 * time.Second}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("no credentials configured and no managed identity endpoint is reachable: %w", err)
+	}
+	return decodeTokenResponse(resp)
+}
+
+// Ping fetches the properties of the container, which checks both the
+// credentials and the container.
+func (c *AzureClient) Ping(ctx context.Context) error {
+	return pingHTTP(ctx, c.client, c.endpoint+"/"+url.PathEscape(c.container)+"?restype=container")
+}
@@ -0,0 +1,192 @@
+package clients
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordTransport answers every request with 200, keeping the last one.
+type recordTransport struct{ req *http.Request }
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.req = req
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+}
+
+func newTestSharedKeyTransport(t *testing.T, account string) (*sharedKeyTransport, *recordTransport) {
+	t.Helper()
+	rec := &recordTransport{}
+	sk, err := newSharedKeyTransport(rec, account, azuriteKey)
+	require.NoError(t, err)
+	sk.now = func() time.Time { return time.Date(2009, 10, 11, 21, 49, 13, 0, time.UTC) }
+	return sk, rec
+}
+
+func TestSharedKeyTransport_StringToSign(t *testing.T) {
+	sk, _ := newTestSharedKeyTransport(t, "myaccount")
+	for name, tc := range map[string]struct {
+		method, url string
+		body        string
+		headers     map[string][]string
+		want        string
+	}{
+		// The example of "Authorize with Shared Key" in the REST reference.
+		"container metadata": {
+			method:  http.MethodGet,
+			url:     "https://myaccount.blob.core.windows.net/mycontainer?restype=container&comp=metadata&timeout=20",
+			headers: map[string][]string{"x-ms-date": {"Sun, 11 Oct 2009 21:49:13 GMT"}, "x-ms-version": {"2009-09-19"}},
+			want: "GET\n\n\n\n\n\n\n\n\n\n\n\n" +
+				"x-ms-date:Sun, 11 Oct 2009 21:49:13 GMT\nx-ms-version:2009-09-19\n" +
+				"/myaccount/mycontainer\ncomp:metadata\nrestype:container\ntimeout:20",
+		},
+		"put blob": {
+			method: http.MethodPut,
+			url:    "https://myaccount.blob.core.windows.net/backups/wal%2F0001%20a",
+			body:   "hello world",
+			headers: map[string][]string{
+				"Content-Type":   {"text/plain"},
+				"If-None-Match":  {"*"},
+				"X-Ms-Blob-Type": {"BlockBlob"},
+				"X-Ms-Meta-Tags": {"a", "b"},
+				"x-ms-version":   {"2021-08-06"},
+			},
+			want: "PUT\n\n\n11\n\ntext/plain\n\n\n\n*\n\n\n" +
+				"x-ms-blob-type:BlockBlob\nx-ms-meta-tags:a,b\nx-ms-version:2021-08-06\n" +
+				"/myaccount/backups/wal%2F0001%20a",
+		},
+		"empty body": {
+			method: http.MethodDelete,
+			url:    "https://myaccount.blob.core.windows.net/backups/old",
+			want:   "DELETE\n\n\n\n\n\n\n\n\n\n\n\n/myaccount/backups/old",
+		},
+		"account root": {
+			method: http.MethodGet,
+			url:    "https://myaccount.blob.core.windows.net?comp=list&include=metadata&include=deleted",
+			want:   "GET\n\n\n\n\n\n\n\n\n\n\n\n/myaccount/\ncomp:list\ninclude:deleted,metadata",
+		},
+		"range": {
+			method:  http.MethodGet,
+			url:     "https://myaccount.blob.core.windows.net/backups/base.tar",
+			headers: map[string][]string{"Range": {"bytes=0-1023"}},
+			want:    "GET\n\n\n\n\n\n\n\n\n\n\nbytes=0-1023\n/myaccount/backups/base.tar",
+		},
+	} {
+		req, err := http.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+		require.NoError(t, err, name)
+		if tc.body == "" {
+			req.ContentLength = 0
+		}
+		for k, vs := range tc.headers {
+			for _, v := range vs {
+				req.Header.Add(k, v)
+			}
+		}
+		assert.Equal(t, tc.want, sk.stringToSign(req), name)
+	}
+}
+
+// The expected signatures are HMAC-SHA256 of the string to sign with the
+// Azurite account key, computed independently.
+func TestSharedKeyTransport_Signature(t *testing.T) {
+	sk, rec := newTestSharedKeyTransport(t, azuriteAccount)
+
+	req, err := http.NewRequest(http.MethodGet, "http://127.0.0.1:10000/mycontainer?restype=container&comp=metadata&timeout=20", nil)
+	require.NoError(t, err)
+	req.Header.Set("x-ms-version", "2009-09-19")
+	_, err = sk.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, "Sun, 11 Oct 2009 21:49:13 GMT", rec.req.Header.Get("x-ms-date"))
+	assert.Equal(t, "SharedKey devstoreaccount1:yJYKlE9aS8Ao896LydJta2Efl/Hgw6SJG5GVDJv/Jqk=", rec.req.Header.Get("Authorization"))
+	assert.Empty(t, req.Header.Get("Authorization"), "the request of the caller is not modified")
+
+	// The version header is set before signing.
+	req, err = http.NewRequest(http.MethodPut, "http://127.0.0.1:10000/backups/wal/0001", strings.NewReader("hello world"))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+	_, err = (&azureVersionTransport{base: sk}).RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, "SharedKey devstoreaccount1:XGZrWGClEybBguTURtIz4RqoneHuwrf14uG4AJ4Evqw=", rec.req.Header.Get("Authorization"))
+}
+
+func TestNewSharedKeyTransport_Errors(t *testing.T) {
+	_, err := newSharedKeyTransport(http.DefaultTransport, "", azuriteKey)
+	assert.ErrorContains(t, err, "account name")
+	_, err = newSharedKeyTransport(http.DefaultTransport, "acct", "not base64!")
+	assert.ErrorContains(t, err, "decode account key")
+}
+
+func TestParseAzureConnectionString(t *testing.T) {
+	for name, tc := range map[string]struct {
+		in   string
+		want azureConnectionString
+	}{
+		"account key": {
+			in:   "DefaultEndpointsProtocol=https;AccountName=acct;AccountKey=a2V5==;EndpointSuffix=core.windows.net",
+			want: azureConnectionString{account: "acct", key: "a2V5==", endpoint: "https://acct.blob.core.windows.net"},
+		},
+		"defaults": {
+			in:   "AccountName=acct;AccountKey=a2V5",
+			want: azureConnectionString{account: "acct", key: "a2V5", endpoint: "https://acct.blob.core.windows.net"},
+		},
+		"sovereign cloud": {
+			in:   "DefaultEndpointsProtocol=http;AccountName=acct;AccountKey=a2V5;EndpointSuffix=core.chinacloudapi.cn;",
+			want: azureConnectionString{account: "acct", key: "a2V5", endpoint: "http://acct.blob.core.chinacloudapi.cn"},
+		},
+		"case and spaces": {
+			in:   " accountname = acct ; ACCOUNTKEY=a2V5 ",
+			want: azureConnectionString{account: "acct", key: "a2V5", endpoint: "https://acct.blob.core.windows.net"},
+		},
+		"blob endpoint": {
+			in:   "BlobEndpoint=https://blobs.example.com/acct;AccountName=acct;AccountKey=a2V5",
+			want: azureConnectionString{account: "acct", key: "a2V5", endpoint: "https://blobs.example.com/acct"},
+		},
+		"sas": {
+			in:   "BlobEndpoint=https://acct.blob.core.windows.net/;SharedAccessSignature=sv=2021-08-06&ss=b&sig=abc%3D",
+			want: azureConnectionString{sas: "sv=2021-08-06&ss=b&sig=abc%3D", endpoint: "https://acct.blob.core.windows.net/"},
+		},
+		"azurite": {
+			in:   "UseDevelopmentStorage=true",
+			want: azureConnectionString{account: azuriteAccount, key: azuriteKey, endpoint: azuriteEndpoint},
+		},
+	} {
+		cs, err := parseAzureConnectionString(tc.in)
+		require.NoError(t, err, name)
+		assert.Equal(t, tc.want, *cs, name)
+	}
+
+	for name, in := range map[string]string{
+		"malformed":   "AccountName=acct;AccountKey",
+		"no secret":   "AccountName=acct;EndpointSuffix=core.windows.net",
+		"no endpoint": "AccountKey=a2V5",
+		"empty":       "",
+	} {
+		_, err := parseAzureConnectionString(in)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestNewAzureClient_ConnectionString(t *testing.T) {
+	c, err := NewAzureClient(&AzureConfig{
+		ConnectionString: "UseDevelopmentStorage=true",
+		Container:        "backups",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, azuriteEndpoint, c.Endpoint())
+	assert.Equal(t, "backups", c.Container())
+
+	c, err = NewAzureClient(&AzureConfig{
+		ConnectionString: "AccountName=acct;SharedAccessSignature=sv=1&sig=x",
+		EndpointURL:      "http://localhost:10000/acct/",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:10000/acct", c.Endpoint(), "EndpointURL wins, without the trailing slash")
+
+	_, err = NewAzureClient(&AzureConfig{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,297 @@
+package clients
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultGCSEndpoint is the endpoint of the Cloud Storage JSON and XML
+	// APIs.
+	DefaultGCSEndpoint = "https://storage.googleapis.com"
+
+	gcsScope       = "https://www.googleapis.com/auth/devstorage.read_write"
+	googleTokenURL = "https://oauth2.googleapis.com/token"
+	metadataHost   = "metadata.google.internal"
+)
+
+// GCSConfig configures NewGCSClient.
+type GCSConfig struct {
+	Bucket string
+
+	// CredentialsJSON is a service account key or an authorized user
+	// ("gcloud auth application-default login") credentials file, given
+	// inline or by path. With neither, Application Default Credentials
+	// are used: $GOOGLE_APPLICATION_CREDENTIALS, the gcloud well-known
+	// file, then the metadata server.
+	CredentialsJSON []byte
+	CredentialsFile string
+
+	// EndpointURL overrides DefaultGCSEndpoint. If it is empty and
+	// $STORAGE_EMULATOR_HOST is set (e.g. fake-gcs-server), the emulator is
+	// used without authentication, as the Google SDKs do.
+	EndpointURL string
+
+	// WithoutAuthentication sends anonymous requests, for public buckets
+	// and emulators.
+	WithoutAuthentication bool
+	DisableSSL            bool
+}
+
+// GCSClient is an HTTP client authorized for Cloud Storage, to be used
+// with its JSON API under Endpoint.
+type GCSClient struct {
+	client   *http.Client
+	endpoint string
+	bucket   string
+}
+
+// NewGCSClient initializes the GCS client and sets up the bucket name.
+// Credentials are parsed here; tokens are fetched on the first request and
+// refreshed before they expire.
+func NewGCSClient(gcsConfig *GCSConfig) (*GCSClient, error) {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.TLSClientConfig = &tls.Config{
+		//nolint:gosec
+		InsecureSkipVerify: gcsConfig.DisableSSL,
+	}
+
+	endpoint := gcsConfig.EndpointURL
+	anonymous := gcsConfig.WithoutAuthentication
+	if endpoint == "" {
+		if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+			endpoint = host
+			if !strings.Contains(host, "://") {
+				endpoint = "http://" + host
+			}
+			anonymous = true
+		} else {
+			endpoint = DefaultGCSEndpoint
+		}
+	}
+
+	client := &http.Client{Transport: base}
+	if !anonymous {
+		src, err := gcsTokenSource(gcsConfig, &http.Client{Transport: base})
+		if err != nil {
+			return nil, err
+		}
+		client.Transport = &bearerTransport{base: base, src: src}
+	}
+
+	return &GCSClient{
+		client:   client,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		bucket:   gcsConfig.Bucket,
+	}, nil
+}
+
+func (c *GCSClient) Client() *http.Client {
+	return c.client
+}
+
+// Endpoint returns the base URL of the API, without a trailing slash.
+func (c *GCSClient) Endpoint() string {
+	return c.endpoint
+}
+
+func (c *GCSClient) Bucket() string {
+	return c.bucket
+}
+
+// gcsTokenSource returns the token source of the configured credentials,
+// or of the Application Default Credentials.
+func gcsTokenSource(cfg *GCSConfig, hc *http.Client) (tokenSource, error) {
+	data := cfg.CredentialsJSON
+	path := cfg.CredentialsFile
+	if data == nil && path == "" {
+		path = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if data == nil && path == "" {
+		if p := wellKnownCredentialsFile(); p != "" {
+			if _, err := os.Stat(p); err == nil {
+				path = p
+			}
+		}
+	}
+	if data == nil && path == "" {
+		return &metadataTokenSource{}, nil
+	}
+	if data == nil {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("gcs: read credentials: %w", err)
+		}
+	}
+	return parseGoogleCredentials(data, hc)
+}
+
+// wellKnownCredentialsFile returns where "gcloud auth
+// application-default login" stores its credentials.
+func wellKnownCredentialsFile() string {
+	if runtime.GOOS == "windows" {
+		if dir := os.Getenv("APPDATA"); dir != "" {
+			return filepath.Join(dir, "gcloud", "application_default_credentials.json")
+		}
+		return ""
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+}
+
+type googleCredentials struct {
+	Type string `json:"type"`
+
+	// service_account
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+
+	// authorized_user
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func parseGoogleCredentials(data []byte, hc *http.Client) (tokenSource, error) {
+	var creds googleCredentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("gcs: parse credentials: %w", err)
+	}
+	tokenURL := creds.TokenURI
+	if tokenURL == "" {
+		tokenURL = googleTokenURL
+	}
+	switch creds.Type {
+	case "service_account":
+		block, _ := pem.Decode([]byte(creds.PrivateKey))
+		if block == nil {
+			return nil, errors.New("gcs: service account: no PEM private key")
+		}
+		key, err := parseRSAKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("gcs: service account: %w", err)
+		}
+		return &serviceAccountTokenSource{hc: hc, tokenURL: tokenURL, email: creds.ClientEmail, keyID: creds.PrivateKeyID, key: key}, nil
+	case "authorized_user":
+		return &refreshTokenSource{hc: hc, tokenURL: tokenURL, form: url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {creds.ClientID},
+			"client_secret": {creds.ClientSecret},
+			"refresh_token": {creds.RefreshToken},
+		}}, nil
+	}
+	return nil, fmt.Errorf("gcs: unsupported credentials type %q", creds.Type)
+}
+
+func parseRSAKey(der []byte) (*rsa.PrivateKey, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("private key is not RSA")
+		}
+		return rsaKey, nil
+	}
+	return x509.ParsePKCS1PrivateKey(der)
+}
+
+// serviceAccountTokenSource exchanges a self-signed JWT for an access
+// token (RFC 7523).
+type serviceAccountTokenSource struct {
+	hc       *http.Client
+	tokenURL string
+	email    string
+	keyID    string
+	key      *rsa.PrivateKey
+}
+
+func (s *serviceAccountTokenSource) token(ctx context.Context) (string, time.Time, error) {
+	enc := base64.RawURLEncoding
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": s.keyID})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now()
+	claims, err := json.Marshal(map[string]any{
+		"iss":   s.email,
+		"scope": gcsScope,
+		"aud":   s.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(nil, s.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return postTokenForm(ctx, s.hc, s.tokenURL, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + enc.EncodeToString(sig)},
+	})
+}
+
+// refreshTokenSource redeems the refresh token of user credentials.
+type refreshTokenSource struct {
+	hc       *http.Client
+	tokenURL string
+	form     url.Values
+}
+
+func (s *refreshTokenSource) token(ctx context.Context) (string, time.Time, error) {
+	return postTokenForm(ctx, s.hc, s.tokenURL, s.form)
+}
+
+// metadataTokenSource asks the metadata server of the instance (GCE, GKE,
+// Cloud Run) for a token of its service account.
+type metadataTokenSource struct{}
+
+func (s *metadataTokenSource) token(ctx context.Context) (string, time.Time, error) {
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = metadataHost
+	}
+	u := "http://" + host + "/computeMetadata/v1/instance/service-accounts/default/token?scopes=" + url.QueryEscape(gcsScope)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	// The metadata server is plain HTTP on the link-local network; a
+	// transport with a proxy configured would not reach it.
+	hc := &http.Client{Transport: &http.Transport{}, Timeout: 10 * time.Second}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("no credentials configured and the metadata server is unreachable: %w", err)
+	}
+	return decodeTokenResponse(resp)
+}
+
+// Ping fetches the metadata of the bucket, which checks both the
+// credentials and the bucket.
+func (c *GCSClient) Ping(ctx context.Context) error {
+	return pingHTTP(ctx, c.client, c.endpoint+"/storage/v1/b/"+url.PathEscape(c.bucket)+"?fields=name")
+}
@@ -0,0 +1,72 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/hashmap-kz/storecrypt/pkg/crypters"
+)
+
+// AWSKMSClient calls AWS KMS with the SDK client. It implements
+// crypters.KMSClient.
+type AWSKMSClient struct {
+	client *kms.Client
+}
+
+var _ crypters.KMSClient = (*AWSKMSClient)(nil)
+
+// NewAWSKMSClient creates a KMS client using the region, credentials and
+// HTTP client of cfg (see config.LoadDefaultConfig). The endpoint is
+// resolved by the SDK (FIPS, dual-stack and partitions included); endpoint
+// overrides it, e.g. for LocalStack, and is left empty otherwise.
+func NewAWSKMSClient(cfg aws.Config, endpoint string) *AWSKMSClient {
+	return &AWSKMSClient{client: kms.NewFromConfig(cfg, func(o *kms.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})}
+}
+
+// Client returns the underlying SDK client.
+func (c *AWSKMSClient) Client() *kms.Client {
+	return c.client
+}
+
+func (c *AWSKMSClient) GenerateDataKey(ctx context.Context, keyID string, encryptionContext map[string]string) ([]byte, []byte, error) {
+	out, err := c.client.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:             aws.String(keyID),
+		KeySpec:           types.DataKeySpecAes256,
+		EncryptionContext: encryptionContext,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("kms GenerateDataKey: %w", err)
+	}
+	return out.Plaintext, out.CiphertextBlob, nil
+}
+
+func (c *AWSKMSClient) Encrypt(ctx context.Context, keyID string, plaintext []byte, encryptionContext map[string]string) ([]byte, error) {
+	out, err := c.client.Encrypt(ctx, &kms.EncryptInput{
+		KeyId:             aws.String(keyID),
+		Plaintext:         plaintext,
+		EncryptionContext: encryptionContext,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("kms Encrypt: %w", err)
+	}
+	return out.CiphertextBlob, nil
+}
+
+func (c *AWSKMSClient) Decrypt(ctx context.Context, keyID string, ciphertext []byte, encryptionContext map[string]string) ([]byte, error) {
+	out, err := c.client.Decrypt(ctx, &kms.DecryptInput{
+		KeyId:             aws.String(keyID),
+		CiphertextBlob:    ciphertext,
+		EncryptionContext: encryptionContext,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("kms Decrypt: %w", err)
+	}
+	return out.Plaintext, nil
+}
@@ -0,0 +1,115 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func kmsTestConfig(region string) aws.Config {
+	return aws.Config{
+		Region:      region,
+		Credentials: credentials.NewStaticCredentialsProvider("AKIDEXAMPLE", "secret", ""),
+	}
+}
+
+func TestAWSKMSClient_Requests(t *testing.T) {
+	var targets []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targets = append(targets, r.Header.Get("X-Amz-Target"))
+		assert.Equal(t, "application/x-amz-json-1.1", r.Header.Get("Content-Type"))
+		assert.Contains(t, r.Header.Get("Authorization"), "Credential=AKIDEXAMPLE/")
+		assert.Contains(t, r.Header.Get("Authorization"), "/eu-west-1/kms/aws4_request")
+
+		var in map[string]any
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&in))
+		assert.Equal(t, "alias/backups", in["KeyId"])
+		assert.Equal(t, map[string]any{"app": "wal"}, in["EncryptionContext"])
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.GenerateDataKey":
+			assert.Equal(t, "AES_256", in["KeySpec"])
+			_, _ = io.WriteString(w, `{"KeyId":"k","Plaintext":"cGxhaW4=","CiphertextBlob":"c2VhbGVk"}`)
+		case "TrentService.Encrypt":
+			assert.Equal(t, "cGxhaW4=", in["Plaintext"])
+			_, _ = io.WriteString(w, `{"KeyId":"k","CiphertextBlob":"c2VhbGVk"}`)
+		case "TrentService.Decrypt":
+			assert.Equal(t, "c2VhbGVk", in["CiphertextBlob"])
+			_, _ = io.WriteString(w, `{"KeyId":"k","Plaintext":"cGxhaW4="}`)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	encCtx := map[string]string{"app": "wal"}
+	c := NewAWSKMSClient(kmsTestConfig("eu-west-1"), srv.URL)
+
+	plain, sealed, err := c.GenerateDataKey(ctx, "alias/backups", encCtx)
+	require.NoError(t, err)
+	assert.Equal(t, "plain", string(plain))
+	assert.Equal(t, "sealed", string(sealed))
+
+	sealed, err = c.Encrypt(ctx, "alias/backups", []byte("plain"), encCtx)
+	require.NoError(t, err)
+	assert.Equal(t, "sealed", string(sealed))
+
+	plain, err = c.Decrypt(ctx, "alias/backups", []byte("sealed"), encCtx)
+	require.NoError(t, err)
+	assert.Equal(t, "plain", string(plain))
+
+	assert.Equal(t, []string{"TrentService.GenerateDataKey", "TrentService.Encrypt", "TrentService.Decrypt"}, targets)
+}
+
+func TestAWSKMSClient_Error(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = io.WriteString(w, `{"__type":"NotFoundException","message":"Alias arn:aws:kms:eu-west-1:1:alias/nope is not found."}`)
+	}))
+	defer srv.Close()
+
+	c := NewAWSKMSClient(kmsTestConfig("eu-west-1"), srv.URL)
+	_, err := c.Encrypt(context.Background(), "alias/nope", []byte("x"), nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "NotFoundException")
+}
+
+// endpointRecorder fails every request, recording its URL.
+type endpointRecorder struct{ urls []string }
+
+func (r *endpointRecorder) Do(req *http.Request) (*http.Response, error) {
+	r.urls = append(r.urls, req.URL.String())
+	return &http.Response{
+		StatusCode: http.StatusBadRequest,
+		Header:     http.Header{"Content-Type": {"application/x-amz-json-1.1"}},
+		Body:       io.NopCloser(strings.NewReader(`{"__type":"InvalidRequestException"}`)),
+		Request:    req,
+	}, nil
+}
+
+func TestAWSKMSClient_EndpointResolution(t *testing.T) {
+	for region, want := range map[string]string{
+		"eu-west-1":     "https://kms.eu-west-1.amazonaws.com/",
+		"cn-north-1":    "https://kms.cn-north-1.amazonaws.com.cn/",
+		"us-gov-west-1": "https://kms.us-gov-west-1.amazonaws.com/",
+	} {
+		rec := &endpointRecorder{}
+		cfg := kmsTestConfig(region)
+		cfg.HTTPClient = rec
+		cfg.RetryMaxAttempts = 1
+		_, err := NewAWSKMSClient(cfg, "").Decrypt(context.Background(), "k", []byte("x"), nil)
+		require.Error(t, err)
+		require.Len(t, rec.urls, 1, region)
+		assert.Equal(t, want, rec.urls[0], region)
+	}
+}
@@ -0,0 +1,320 @@
+package clients
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRedisDialTimeout bounds connecting to Redis when no timeout
+	// is configured.
+	DefaultRedisDialTimeout = 10 * time.Second
+
+	// DefaultRedisPoolSize is the number of idle connections kept when no
+	// pool size is configured.
+	DefaultRedisPoolSize = 4
+)
+
+type RedisConfig struct {
+	// Required, host:port
+	Addr string
+
+	// Optional, for servers with AUTH. Username selects an ACL user
+	// (Redis 6); Password alone authenticates the default user.
+	Username string
+	Password string
+
+	// Optional, the logical database to SELECT
+	DB int
+
+	// Optional, connect with TLS; InsecureSkipVerify accepts any server
+	// certificate.
+	TLS                bool
+	InsecureSkipVerify bool
+
+	// Optional, DefaultRedisDialTimeout and DefaultRedisPoolSize if zero
+	DialTimeout time.Duration
+	PoolSize    int
+}
+
+// RedisError is an error reply of the server, e.g. "WRONGTYPE ...".
+type RedisError string
+
+func (e RedisError) Error() string { return "redis: " + string(e) }
+
+// RedisClient sends commands to a Redis server over a small pool of
+// connections, speaking RESP2. Replies are decoded to string (status),
+// int64, []byte (bulk strings, nil if missing) and []any (arrays).
+type RedisClient struct {
+	cfg RedisConfig
+
+	mu     sync.Mutex
+	idle   []*redisConn
+	closed bool
+}
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// NewRedisClient connects to the server once, to fail early on a wrong
+// address or credentials.
+func NewRedisClient(redisConfig *RedisConfig) (*RedisClient, error) {
+	if redisConfig.Addr == "" {
+		return nil, errors.New("redis: no address")
+	}
+	c := &RedisClient{cfg: *redisConfig}
+	if c.cfg.DialTimeout <= 0 {
+		c.cfg.DialTimeout = DefaultRedisDialTimeout
+	}
+	if c.cfg.PoolSize <= 0 {
+		c.cfg.PoolSize = DefaultRedisPoolSize
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.cfg.DialTimeout)
+	defer cancel()
+	rc, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.put(rc)
+	return c, nil
+}
+
+func (c *RedisClient) dial(ctx context.Context) (*redisConn, error) {
+	d := &net.Dialer{Timeout: c.cfg.DialTimeout}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if c.cfg.TLS {
+		host, _, _ := net.SplitHostPort(c.cfg.Addr)
+		td := &tls.Dialer{NetDialer: d, Config: &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: c.cfg.InsecureSkipVerify, //nolint:gosec
+			MinVersion:         tls.VersionTLS12,
+		}}
+		conn, err = td.DialContext(ctx, "tcp", c.cfg.Addr)
+	} else {
+		conn, err = d.DialContext(ctx, "tcp", c.cfg.Addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis: dial %s: %w", c.cfg.Addr, err)
+	}
+	rc := &redisConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+
+	var setup [][]any
+	switch {
+	case c.cfg.Username != "":
+		setup = append(setup, []any{"AUTH", c.cfg.Username, c.cfg.Password})
+	case c.cfg.Password != "":
+		setup = append(setup, []any{"AUTH", c.cfg.Password})
+	}
+	if c.cfg.DB != 0 {
+		setup = append(setup, []any{"SELECT", c.cfg.DB})
+	}
+	if len(setup) > 0 {
+		replies, err := rc.roundTrip(ctx, setup)
+		if err == nil {
+			for _, reply := range replies {
+				if e, ok := reply.(RedisError); ok {
+					err = e
+					break
+				}
+			}
+		}
+		if err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("redis: set up connection to %s: %w", c.cfg.Addr, err)
+		}
+	}
+	return rc, nil
+}
+
+func (c *RedisClient) get(ctx context.Context) (*redisConn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, errors.New("redis: client closed")
+	}
+	if n := len(c.idle); n > 0 {
+		rc := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return rc, nil
+	}
+	c.mu.Unlock()
+	return c.dial(ctx)
+}
+
+func (c *RedisClient) put(rc *redisConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || len(c.idle) >= c.cfg.PoolSize {
+		_ = rc.conn.Close()
+		return
+	}
+	c.idle = append(c.idle, rc)
+}
+
+// Do sends one command and returns its reply; an error reply is returned
+// as a RedisError.
+func (c *RedisClient) Do(ctx context.Context, args ...any) (any, error) {
+	replies, err := c.Pipeline(ctx, [][]any{args})
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := replies[0].(RedisError); ok {
+		return nil, e
+	}
+	return replies[0], nil
+}
+
+// Pipeline sends the commands at once and returns their replies in order.
+// Error replies are RedisError values in the replies, not errors.
+func (c *RedisClient) Pipeline(ctx context.Context, cmds [][]any) ([]any, error) {
+	rc, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	replies, err := rc.roundTrip(ctx, cmds)
+	if err != nil {
+		// The connection is in an unknown state.
+		_ = rc.conn.Close()
+		return nil, err
+	}
+	c.put(rc)
+	return replies, nil
+}
+
+// Ping sends PING.
+func (c *RedisClient) Ping(ctx context.Context) error {
+	_, err := c.Do(ctx, "PING")
+	return err
+}
+
+// Close closes the idle connections; connections in use are closed when
+// their command is done.
+func (c *RedisClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	var errs []error
+	for _, rc := range c.idle {
+		errs = append(errs, rc.conn.Close())
+	}
+	c.idle = nil
+	return errors.Join(errs...)
+}
+
+func (rc *redisConn) roundTrip(ctx context.Context, cmds [][]any) ([]any, error) {
+	// A done ctx interrupts blocked reads and writes.
+	stop := context.AfterFunc(ctx, func() { _ = rc.conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+	deadline, _ := ctx.Deadline()
+	if err := rc.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	for _, args := range cmds {
+		writeRedisCommand(rc.w, args)
+	}
+	if err := rc.w.Flush(); err != nil {
+		return nil, redisIOError(ctx, err)
+	}
+	replies := make([]any, len(cmds))
+	for i := range replies {
+		reply, err := readRedisReply(rc.r)
+		if err != nil {
+			return nil, redisIOError(ctx, err)
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+func redisIOError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	// The connection deadline is the ctx deadline and may fire a moment
+	// before ctx itself reports it.
+	if deadline, ok := ctx.Deadline(); ok && errors.Is(err, os.ErrDeadlineExceeded) && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return fmt.Errorf("redis: %w", err)
+}
+
+func writeRedisCommand(w *bufio.Writer, args []any) {
+	w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		var b []byte
+		switch v := arg.(type) {
+		case []byte:
+			b = v
+		case string:
+			b = []byte(v)
+		case int:
+			b = strconv.AppendInt(nil, int64(v), 10)
+		case int64:
+			b = strconv.AppendInt(nil, v, 10)
+		default:
+			b = fmt.Append(nil, v)
+		}
+		w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+		w.Write(b)
+		w.WriteString("\r\n")
+	}
+}
+
+func readRedisReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return RedisError(body), nil
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err // a nil bulk string
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err // a nil array
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readRedisReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("malformed reply %q", line)
+}
@@ -0,0 +1,148 @@
+package clients
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scriptedRedis accepts connections and, for each, expects the requests
+// in order, byte for byte, answering each with its reply. A connection
+// beyond the script is closed at once.
+func scriptedRedis(t *testing.T, conns ...[][2]string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if i >= len(conns) {
+				_ = conn.Close()
+				continue
+			}
+			go func(conn net.Conn, script [][2]string) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for _, step := range script {
+					got := make([]byte, len(step[0]))
+					if _, err := io.ReadFull(r, got); err != nil {
+						return
+					}
+					if !assert.Equal(t, step[0], string(got)) {
+						return
+					}
+					if _, err := conn.Write([]byte(step[1])); err != nil {
+						return
+					}
+				}
+				_, _ = io.Copy(io.Discard, r)
+			}(conn, conns[i])
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestRedisClient_SetupAndPipeline(t *testing.T) {
+	addr := scriptedRedis(t, [][2]string{
+		{
+			"*3\r\n$4\r\nAUTH\r\n$3\r\nbob\r\n$6\r\nsecret\r\n" +
+				"*2\r\n$6\r\nSELECT\r\n$1\r\n3\r\n",
+			"+OK\r\n+OK\r\n",
+		},
+		{
+			"*4\r\n$4\r\nHSET\r\n$1\r\nk\r\n$4\r\ndata\r\n$5\r\na\r\nb\x00\r\n" +
+				"*3\r\n$4\r\nHGET\r\n$1\r\nk\r\n$4\r\ndata\r\n" +
+				"*3\r\n$4\r\nHGET\r\n$1\r\nk\r\n$5\r\nmtime\r\n" +
+				"*3\r\n$4\r\nSCAN\r\n$1\r\n0\r\n$5\r\nCOUNT\r\n" +
+				"*1\r\n$4\r\nINCR\r\n",
+			":1\r\n$5\r\na\r\nb\x00\r\n$-1\r\n*2\r\n$2\r\n17\r\n*2\r\n$1\r\na\r\n$0\r\n\r\n" +
+				"-ERR wrong number of arguments for 'incr' command\r\n",
+		},
+	})
+	c, err := NewRedisClient(&RedisConfig{Addr: addr, Username: "bob", Password: "secret", DB: 3})
+	require.NoError(t, err)
+	defer c.Close()
+
+	replies, err := c.Pipeline(context.Background(), [][]any{
+		{"HSET", "k", "data", []byte("a\r\nb\x00")},
+		{"HGET", "k", "data"},
+		{"HGET", "k", "mtime"},
+		{"SCAN", 0, "COUNT"},
+		{"INCR"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []any{
+		int64(1),
+		[]byte("a\r\nb\x00"),
+		nil,
+		[]any{[]byte("17"), []any{[]byte("a"), []byte{}}},
+		RedisError("ERR wrong number of arguments for 'incr' command"),
+	}, replies)
+}
+
+func TestRedisClient_Do(t *testing.T) {
+	addr := scriptedRedis(t, [][2]string{
+		{"*1\r\n$4\r\nPING\r\n", "+PONG\r\n"},
+		{"*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	})
+	c, err := NewRedisClient(&RedisConfig{Addr: addr})
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.NoError(t, c.Ping(context.Background()))
+	_, err = c.Do(context.Background(), "GET", "k")
+	var re RedisError
+	require.ErrorAs(t, err, &re)
+	assert.EqualError(t, err, "redis: WRONGTYPE Operation against a key holding the wrong kind of value")
+}
+
+func TestRedisClient_AuthFailure(t *testing.T) {
+	addr := scriptedRedis(t, [][2]string{
+		{"*2\r\n$4\r\nAUTH\r\n$5\r\nwrong\r\n", "-WRONGPASS invalid username-password pair\r\n"},
+	})
+	_, err := NewRedisClient(&RedisConfig{Addr: addr, Password: "wrong"})
+	var re RedisError
+	require.ErrorAs(t, err, &re)
+	assert.Equal(t, RedisError("WRONGPASS invalid username-password pair"), re)
+}
+
+func TestRedisClient_MalformedReply(t *testing.T) {
+	addr := scriptedRedis(t,
+		[][2]string{{"*1\r\n$4\r\nPING\r\n", "PONG\r\n"}},
+		[][2]string{{"*1\r\n$4\r\nPING\r\n", "+PONG\r\n"}},
+	)
+	c, err := NewRedisClient(&RedisConfig{Addr: addr})
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.ErrorContains(t, c.Ping(context.Background()), "malformed reply")
+	// The broken connection is dropped, the next command dials again.
+	require.NoError(t, c.Ping(context.Background()))
+}
+
+func TestRedisClient_ContextInterruptsRead(t *testing.T) {
+	addr := scriptedRedis(t, [][2]string{{"*1\r\n$4\r\nPING\r\n", ""}})
+	c, err := NewRedisClient(&RedisConfig{Addr: addr})
+	require.NoError(t, err)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = c.Ping(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestNewRedisClient_NoAddr(t *testing.T) {
+	_, err := NewRedisClient(&RedisConfig{})
+	assert.Error(t, err)
+}
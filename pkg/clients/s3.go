@@ -3,7 +3,9 @@ package clients
 import (
 	"context"
 	"crypto/tls"
+	"net"
 	"net/http"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -35,11 +37,38 @@ type S3Config struct {
 	UsePathStyle               bool
 	DisableSSL                 bool
 	RequestChecksumCalculation RequestChecksumCalculation
+
+	// AutoRegion resolves the region of each bucket instead of trusting
+	// Region, so one configuration can address buckets in several regions
+	// (see S3Client.ClientFor). Region is then only the starting point of
+	// the lookups.
+	AutoRegion bool
+
+	// HTTP transport tuning; zero values select the defaults below. Raise
+	// the idle connection limits when many Get/Put streams run at once
+	// (parallel restores), or connections are closed and re-dialed on
+	// every request.
+	MaxIdleConns          int           // DefaultS3MaxIdleConns
+	MaxIdleConnsPerHost   int           // DefaultS3MaxIdleConnsPerHost
+	IdleConnTimeout       time.Duration // DefaultS3IdleConnTimeout
+	ResponseHeaderTimeout time.Duration // none
+	DialTimeout           time.Duration // DefaultS3DialTimeout
 }
 
+// Defaults of the S3 HTTP transport.
+const (
+	DefaultS3MaxIdleConns        = 256
+	DefaultS3MaxIdleConnsPerHost = 256
+	DefaultS3IdleConnTimeout     = 90 * time.Second
+	DefaultS3DialTimeout         = 30 * time.Second
+)
+
 type S3Client struct {
 	client *s3.Client
 	bucket string
+
+	autoRegion bool
+	regions    *s3RegionCache
 }
 
 // NewS3Client initializes the S3 client and sets up the bucket name
@@ -51,12 +80,7 @@ func NewS3Client(s3Config *S3Config) (*S3Client, error) {
 		config.WithRegion(s3Config.Region),
 		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(s3Config.AccessKeyID, s3Config.SecretAccessKey, "")),
 		config.WithHTTPClient(&http.Client{
-			Transport: &http.Transport{ // <--- here
-				TLSClientConfig: &tls.Config{
-					//nolint:gosec
-					InsecureSkipVerify: s3Config.DisableSSL,
-				},
-			},
+			Transport: newS3Transport(s3Config),
 		}),
 	)
 	if err != nil {
@@ -72,10 +96,58 @@ func NewS3Client(s3Config *S3Config) (*S3Client, error) {
 		o.RequestChecksumCalculation = aws.RequestChecksumCalculation(s3Config.RequestChecksumCalculation)
 	})
 
-	return &S3Client{
-		client: client,
-		bucket: s3Config.Bucket,
-	}, nil
+	c := &S3Client{
+		client:     client,
+		bucket:     s3Config.Bucket,
+		autoRegion: s3Config.AutoRegion,
+		regions:    newS3RegionCache(),
+	}
+	if c.autoRegion && c.bucket != "" {
+		// Make Client address the bucket's region.
+		ctx, cancel := context.WithTimeout(context.Background(), regionLookupTimeout)
+		defer cancel()
+		if c.client, err = c.ClientFor(ctx, c.bucket); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// newS3Transport builds the HTTP transport of the client from the tuning
+// fields of s3Config.
+func newS3Transport(s3Config *S3Config) *http.Transport {
+	orDefault := func(v, def int) int {
+		if v > 0 {
+			return v
+		}
+		return def
+	}
+	idleTimeout := s3Config.IdleConnTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultS3IdleConnTimeout
+	}
+	dialTimeout := s3Config.DialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = DefaultS3DialTimeout
+	}
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   dialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          orDefault(s3Config.MaxIdleConns, DefaultS3MaxIdleConns),
+		MaxIdleConnsPerHost:   orDefault(s3Config.MaxIdleConnsPerHost, DefaultS3MaxIdleConnsPerHost),
+		IdleConnTimeout:       idleTimeout,
+		ResponseHeaderTimeout: s3Config.ResponseHeaderTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		TLSClientConfig: &tls.Config{
+			//nolint:gosec
+			InsecureSkipVerify: s3Config.DisableSSL,
+		},
+	}
 }
 
 func (c *S3Client) Client() *s3.Client {
@@ -85,3 +157,11 @@ func (c *S3Client) Client() *s3.Client {
 func (c *S3Client) Bucket() string {
 	return c.bucket
 }
+
+// Ping checks that the bucket exists and is accessible.
+func (c *S3Client) Ping(ctx context.Context) error {
+	_, err := c.client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(c.bucket),
+	})
+	return err
+}
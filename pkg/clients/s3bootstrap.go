@@ -0,0 +1,120 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3BucketSetup is the state EnsureBucket brings a bucket to. It covers
+// what integration tests and demos need of a fresh MinIO (or S3) bucket.
+type S3BucketSetup struct {
+	// Versioning enables object versioning.
+	Versioning bool
+
+	// Lifecycle (ILM on MinIO): a single rule over the objects under
+	// LifecyclePrefix (all if empty). Days of zero leave an action out;
+	// with all three zero the bucket's lifecycle is left alone.
+	LifecyclePrefix                 string
+	ExpireAfterDays                 int32
+	NoncurrentExpireAfterDays       int32
+	AbortIncompleteUploadsAfterDays int32
+}
+
+// lifecycleRuleID names the rule EnsureBucket manages.
+const lifecycleRuleID = "storecrypt"
+
+// EnsureBucket creates the client's bucket if it does not exist and
+// applies setup to it. It is idempotent, so tests can call it on every
+// run instead of relying on environment setup scripts.
+func (c *S3Client) EnsureBucket(ctx context.Context, setup S3BucketSetup) error {
+	if c.bucket == "" {
+		return errors.New("ensure bucket: no bucket configured")
+	}
+	if err := c.createBucket(ctx); err != nil {
+		return fmt.Errorf("create bucket %q: %w", c.bucket, err)
+	}
+
+	if setup.Versioning {
+		_, err := c.client.PutBucketVersioning(ctx, &s3.PutBucketVersioningInput{
+			Bucket: aws.String(c.bucket),
+			VersioningConfiguration: &s3types.VersioningConfiguration{
+				Status: s3types.BucketVersioningStatusEnabled,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("enable versioning of %q: %w", c.bucket, err)
+		}
+	}
+
+	rule, ok := setup.lifecycleRule()
+	if !ok {
+		return nil
+	}
+	_, err := c.client.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket: aws.String(c.bucket),
+		LifecycleConfiguration: &s3types.BucketLifecycleConfiguration{
+			Rules: []s3types.LifecycleRule{rule},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("set lifecycle of %q: %w", c.bucket, err)
+	}
+	return nil
+}
+
+func (c *S3Client) createBucket(ctx context.Context) error {
+	_, err := c.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(c.bucket)})
+	if err == nil {
+		return nil
+	}
+	var nf *s3types.NotFound
+	if !errors.As(err, &nf) {
+		return err
+	}
+
+	in := &s3.CreateBucketInput{Bucket: aws.String(c.bucket)}
+	// us-east-1 takes no location constraint.
+	if region := c.client.Options().Region; region != "" && region != "us-east-1" {
+		in.CreateBucketConfiguration = &s3types.CreateBucketConfiguration{
+			LocationConstraint: s3types.BucketLocationConstraint(region),
+		}
+	}
+	_, err = c.client.CreateBucket(ctx, in)
+	var owned *s3types.BucketAlreadyOwnedByYou
+	if errors.As(err, &owned) {
+		// Created concurrently.
+		return nil
+	}
+	return err
+}
+
+func (s S3BucketSetup) lifecycleRule() (s3types.LifecycleRule, bool) {
+	rule := s3types.LifecycleRule{
+		ID:     aws.String(lifecycleRuleID),
+		Status: s3types.ExpirationStatusEnabled,
+		Filter: &s3types.LifecycleRuleFilter{Prefix: aws.String(s.LifecyclePrefix)},
+	}
+	ok := false
+	if s.ExpireAfterDays > 0 {
+		rule.Expiration = &s3types.LifecycleExpiration{Days: aws.Int32(s.ExpireAfterDays)}
+		ok = true
+	}
+	if s.NoncurrentExpireAfterDays > 0 {
+		rule.NoncurrentVersionExpiration = &s3types.NoncurrentVersionExpiration{
+			NoncurrentDays: aws.Int32(s.NoncurrentExpireAfterDays),
+		}
+		ok = true
+	}
+	if s.AbortIncompleteUploadsAfterDays > 0 {
+		rule.AbortIncompleteMultipartUpload = &s3types.AbortIncompleteMultipartUpload{
+			DaysAfterInitiation: aws.Int32(s.AbortIncompleteUploadsAfterDays),
+		}
+		ok = true
+	}
+	return rule, ok
+}
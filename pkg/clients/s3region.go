@@ -0,0 +1,106 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// regionLookupTimeout bounds the region lookup of NewS3Client.
+const regionLookupTimeout = 30 * time.Second
+
+// s3RegionCache remembers the region of buckets and a client per region.
+type s3RegionCache struct {
+	mu      sync.Mutex
+	buckets map[string]string
+	clients map[string]*s3.Client
+}
+
+func newS3RegionCache() *s3RegionCache {
+	return &s3RegionCache{
+		buckets: make(map[string]string),
+		clients: make(map[string]*s3.Client),
+	}
+}
+
+// ClientFor returns a client for bucket. With AutoRegion, it is configured
+// for the bucket's region, which is looked up once and cached along with
+// the client; otherwise it is Client.
+func (c *S3Client) ClientFor(ctx context.Context, bucket string) (*s3.Client, error) {
+	if !c.autoRegion {
+		return c.client, nil
+	}
+	region, err := c.BucketRegion(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	c.regions.mu.Lock()
+	defer c.regions.mu.Unlock()
+	if client, ok := c.regions.clients[region]; ok {
+		return client, nil
+	}
+	client := c.client
+	if client.Options().Region != region {
+		client = s3.New(c.client.Options(), func(o *s3.Options) {
+			o.Region = region
+		})
+	}
+	c.regions.clients[region] = client
+	return client, nil
+}
+
+// BucketRegion returns the region of bucket, from the cache or from S3:
+// HeadBucket reports it in the x-amz-bucket-region header, even when it
+// fails with a redirect because the region is wrong, and
+// GetBucketLocation covers the rest.
+func (c *S3Client) BucketRegion(ctx context.Context, bucket string) (string, error) {
+	c.regions.mu.Lock()
+	region, ok := c.regions.buckets[bucket]
+	c.regions.mu.Unlock()
+	if ok {
+		return region, nil
+	}
+
+	region, err := c.lookupBucketRegion(ctx, bucket)
+	if err != nil {
+		return "", fmt.Errorf("resolve region of bucket %q: %w", bucket, err)
+	}
+	c.regions.mu.Lock()
+	c.regions.buckets[bucket] = region
+	c.regions.mu.Unlock()
+	return region, nil
+}
+
+func (c *S3Client) lookupBucketRegion(ctx context.Context, bucket string) (string, error) {
+	out, err := c.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+	if err == nil && aws.ToString(out.BucketRegion) != "" {
+		return aws.ToString(out.BucketRegion), nil
+	}
+	var re *awshttp.ResponseError
+	if errors.As(err, &re) && re.Response != nil {
+		if region := re.Response.Header.Get("X-Amz-Bucket-Region"); region != "" {
+			return region, nil
+		}
+	}
+
+	loc, locErr := c.client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{Bucket: aws.String(bucket)})
+	if locErr != nil {
+		return "", errors.Join(err, locErr)
+	}
+	switch region := string(loc.LocationConstraint); region {
+	case "":
+		// Buckets of us-east-1 have no location constraint.
+		return "us-east-1", nil
+	case "EU":
+		return "eu-west-1", nil
+	default:
+		return region, nil
+	}
+}
@@ -1,85 +1,270 @@
 package clients
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"time"
 
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
 type SFTPConfig struct {
 	// Required
-	Host     string
-	Port     string
-	User     string
+	Host string
+	Port string
+	User string
+
+	// Required unless Auth or ssh_config provide the keys
 	PkeyPath string
 
 	// Optional, it private key is created with a passphrase
 	Passphrase string
+
+	// Optional, resolve Host as an alias of the ssh_config file at
+	// SSHConfigPath (~/.ssh/config if empty): its HostName, Port, User,
+	// IdentityFile and ProxyJump fill in the fields left empty, so that
+	// Host alone is enough for hosts ssh already knows.
+	UseSSHConfig  bool
+	SSHConfigPath string
+
+	// Optional, the authentication methods to try, in order, on the server
+	// and the jump hosts. Methods that cannot be set up (an unreadable key,
+	// no agent running) are skipped. When empty, the private key of
+	// PkeyPath (or of ssh_config) is used.
+	Auth []SFTPAuthMethod
+}
+
+// PasswordPrompt returns the password of user on the server at addr,
+// e.g. by asking on a terminal.
+type PasswordPrompt func(user, addr string) (string, error)
+
+// SFTPAuthMethod is an entry of SFTPConfig.Auth, built with KeyFileAuth,
+// AgentAuth or PasswordAuth.
+type SFTPAuthMethod struct {
+	keyFile    string
+	passphrase string
+	agent      bool
+	prompt     PasswordPrompt
+}
+
+// KeyFileAuth authenticates with the private key in path, decrypted with
+// passphrase if it is set. An empty path stands for the keys of PkeyPath
+// or ssh_config.
+func KeyFileAuth(path, passphrase string) SFTPAuthMethod {
+	return SFTPAuthMethod{keyFile: path, passphrase: passphrase}
+}
+
+// AgentAuth authenticates with the keys of the ssh-agent at
+// $SSH_AUTH_SOCK.
+func AgentAuth() SFTPAuthMethod {
+	return SFTPAuthMethod{agent: true}
+}
+
+// PasswordAuth authenticates with the password prompt returns, offered as
+// both "password" and "keyboard-interactive" authentication.
+func PasswordAuth(prompt PasswordPrompt) SFTPAuthMethod {
+	return SFTPAuthMethod{prompt: prompt}
 }
 
 type SFTPClient struct {
 	sshClient  *ssh.Client
 	sftpClient *sftp.Client
 
+	// jumpClients are the connections to the ProxyJump hosts, innermost
+	// last.
+	jumpClients []*ssh.Client
+
 	config *SFTPConfig
 }
 
 // NewSFTPClient creates an SFTP client using passphrase-protected private key authentication
 func NewSFTPClient(sftpConfig *SFTPConfig) (*SFTPClient, error) {
-	var err error
-
-	// Load the private key from file, or read from the property as a string
-	key, err := os.ReadFile(sftpConfig.PkeyPath)
+	route, err := sshRoute(sftpConfig)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read private key: %w", err)
+		return nil, fmt.Errorf("unable to resolve SFTP server: %w", err)
 	}
 
-	// Parse the private key with passphrase
-	var signer ssh.Signer
-	if sftpConfig.Passphrase != "" {
-		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(sftpConfig.Passphrase))
-		if err != nil {
-			return nil, fmt.Errorf("unable to parse private key with passphrase: %w", err)
+	// Connect through the jump hosts, if any, to the server
+	var conns []*ssh.Client
+	closeAll := func() {
+		for i := len(conns) - 1; i >= 0; i-- {
+			_ = conns[i].Close()
 		}
-	} else {
-		signer, err = ssh.ParsePrivateKey(key)
+	}
+	for _, hop := range route {
+		conn, err := dialSSHHop(conns, hop, sftpConfig)
 		if err != nil {
-			return nil, fmt.Errorf("unable to parse private key: %w", err)
+			closeAll()
+			return nil, err
 		}
+		conns = append(conns, conn)
+	}
+	conn := conns[len(conns)-1]
+
+	// Create an SFTP sftpClient over the SSH connection
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		closeAll()
+		return nil, fmt.Errorf("unable to create SFTP sftpClient: %w", err)
+	}
+
+	return &SFTPClient{
+		sshClient:   conn,
+		sftpClient:  client,
+		jumpClients: conns[:len(conns)-1],
+		config:      sftpConfig,
+	}, nil
+}
+
+// dialSSHHop opens an SSH connection to hop, through the last of via if
+// there is one.
+func dialSSHHop(via []*ssh.Client, hop sshHop, sftpConfig *SFTPConfig) (*ssh.Client, error) {
+	auth, cleanup, err := authMethods(hop, sftpConfig)
+	if err != nil {
+		return nil, err
 	}
+	// The agent is only needed during the handshake
+	defer cleanup()
 
 	// Setup SSH configuration
 	sshConfig := &ssh.ClientConfig{
-		User: sftpConfig.User,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
+		User: hop.user,
+		Auth: auth,
 		//nolint:gosec
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 		Timeout:         5 * time.Second,
 	}
 
 	// Establish the SSH connection
-	addr := fmt.Sprintf("%s:%s", sftpConfig.Host, sftpConfig.Port)
-	conn, err := ssh.Dial("tcp", addr, sshConfig)
+	if len(via) == 0 {
+		conn, err := ssh.Dial("tcp", hop.addr, sshConfig)
+		if err != nil {
+			return nil, fmt.Errorf("unable to connect to SFTP server: %w", err)
+		}
+		return conn, nil
+	}
+	netConn, err := via[len(via)-1].Dial("tcp", hop.addr)
 	if err != nil {
-		return nil, fmt.Errorf("unable to connect to SFTP server: %w", err)
+		return nil, fmt.Errorf("unable to connect to %s through jump host: %w", hop.addr, err)
 	}
-
-	// Create an SFTP sftpClient over the SSH connection
-	client, err := sftp.NewClient(conn)
+	c, chans, reqs, err := ssh.NewClientConn(netConn, hop.addr, sshConfig)
 	if err != nil {
-		return nil, fmt.Errorf("unable to create SFTP sftpClient: %w", err)
+		_ = netConn.Close()
+		return nil, fmt.Errorf("unable to connect to %s through jump host: %w", hop.addr, err)
 	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
 
-	return &SFTPClient{
-		sshClient:  conn,
-		sftpClient: client,
-		config:     sftpConfig,
-	}, nil
+// authMethods builds the SSH authentication methods for hop from
+// sftpConfig.Auth, skipping those that cannot be set up. It fails only if
+// none can. cleanup releases the agent connection.
+func authMethods(hop sshHop, sftpConfig *SFTPConfig) ([]ssh.AuthMethod, func(), error) {
+	chain := sftpConfig.Auth
+	if len(chain) == 0 {
+		chain = []SFTPAuthMethod{KeyFileAuth("", sftpConfig.Passphrase)}
+	}
+
+	var (
+		auth    []ssh.AuthMethod
+		errs    []error
+		closers []func() error
+	)
+	cleanup := func() {
+		for _, c := range closers {
+			_ = c()
+		}
+	}
+	for _, m := range chain {
+		switch {
+		case m.agent:
+			sock := os.Getenv("SSH_AUTH_SOCK")
+			if sock == "" {
+				errs = append(errs, errors.New("ssh-agent: SSH_AUTH_SOCK is not set"))
+				continue
+			}
+			conn, err := net.Dial("unix", sock)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("ssh-agent: %w", err))
+				continue
+			}
+			closers = append(closers, conn.Close)
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		case m.prompt != nil:
+			prompt, user, addr := m.prompt, hop.user, hop.addr
+			auth = append(auth,
+				ssh.PasswordCallback(func() (string, error) {
+					return prompt(user, addr)
+				}),
+				ssh.KeyboardInteractive(func(_, _ string, questions []string, _ []bool) ([]string, error) {
+					// Answer the password with the prompt; servers ask
+					// nothing else of password logins.
+					answers := make([]string, len(questions))
+					for i := range questions {
+						pw, err := prompt(user, addr)
+						if err != nil {
+							return nil, err
+						}
+						answers[i] = pw
+					}
+					return answers, nil
+				}),
+			)
+		default:
+			files := hop.keyFiles
+			if m.keyFile != "" {
+				files = []string{m.keyFile}
+			}
+			if len(files) == 0 {
+				errs = append(errs, fmt.Errorf("no private key for %s", hop.addr))
+				continue
+			}
+			signers, err := loadSigners(files, m.passphrase)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			auth = append(auth, ssh.PublicKeys(signers...))
+		}
+	}
+	if len(auth) == 0 {
+		cleanup()
+		return nil, nil, fmt.Errorf("no usable authentication method for %s: %w", hop.addr, errors.Join(errs...))
+	}
+	return auth, cleanup, nil
+}
+
+// loadSigners parses the private keys of files, with passphrase if it is
+// set.
+func loadSigners(files []string, passphrase string) ([]ssh.Signer, error) {
+	signers := make([]ssh.Signer, 0, len(files))
+	for _, file := range files {
+		// Load the private key from file, or read from the property as a string
+		key, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read private key: %w", err)
+		}
+
+		// Parse the private key with passphrase
+		var signer ssh.Signer
+		if passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse private key with passphrase: %w", err)
+			}
+		} else {
+			signer, err = ssh.ParsePrivateKey(key)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse private key: %w", err)
+			}
+		}
+		signers = append(signers, signer)
+	}
+	return signers, nil
 }
 
 func (s *SFTPClient) SFTPClient() *sftp.Client {
@@ -94,5 +279,14 @@ func (s *SFTPClient) Close() error {
 	if s.sshClient != nil {
 		err = s.sshClient.Close()
 	}
+	for i := len(s.jumpClients) - 1; i >= 0; i-- {
+		_ = s.jumpClients[i].Close()
+	}
+	return err
+}
+
+// Ping makes a round trip to the server.
+func (s *SFTPClient) Ping(_ context.Context) error {
+	_, err := s.sftpClient.Getwd()
 	return err
 }
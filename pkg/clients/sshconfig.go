@@ -0,0 +1,306 @@
+package clients
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// maxSSHConfigDepth bounds Include nesting and ProxyJump chains.
+const maxSSHConfigDepth = 8
+
+// DefaultSSHConfigPath returns ~/.ssh/config.
+func DefaultSSHConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".ssh", "config")
+}
+
+// sshHostConfig holds the ssh_config(5) settings of a host alias that
+// NewSFTPClient uses.
+type sshHostConfig struct {
+	HostName      string
+	Port          string
+	User          string
+	IdentityFiles []string
+	ProxyJump     string
+}
+
+// lookupSSHConfig returns the settings of alias in the ssh_config file at
+// file. As with ssh, the first value obtained for a keyword wins, except
+// for IdentityFile, which accumulates. Match blocks are skipped. A missing
+// file yields no settings.
+func lookupSSHConfig(file, alias string) (*sshHostConfig, error) {
+	hc := &sshHostConfig{}
+	if err := hc.parse(file, alias, true, 0); err != nil {
+		return nil, err
+	}
+	return hc, nil
+}
+
+func (hc *sshHostConfig) parse(file, alias string, active bool, depth int) error {
+	if depth > maxSSHConfigDepth {
+		return fmt.Errorf("ssh config: Include nested too deeply in %s", file)
+	}
+	f, err := os.Open(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("ssh config: %w", err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		keyword, args := splitSSHConfigLine(sc.Text())
+		if keyword == "" {
+			continue
+		}
+		if len(args) == 0 {
+			return fmt.Errorf("ssh config: %s:%d: %s without a value", file, lineNo, keyword)
+		}
+		switch keyword {
+		case "host":
+			active = matchSSHHost(args, alias)
+			continue
+		case "match":
+			active = false
+			continue
+		}
+		if !active {
+			continue
+		}
+		switch keyword {
+		case "include":
+			for _, pattern := range args {
+				if err := hc.include(pattern, alias, depth); err != nil {
+					return err
+				}
+			}
+		case "hostname":
+			setOnce(&hc.HostName, args[0])
+		case "port":
+			setOnce(&hc.Port, args[0])
+		case "user":
+			setOnce(&hc.User, args[0])
+		case "proxyjump":
+			setOnce(&hc.ProxyJump, args[0])
+		case "identityfile":
+			hc.IdentityFiles = append(hc.IdentityFiles, expandHome(args[0]))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("ssh config: %w", err)
+	}
+	return nil
+}
+
+// include parses the files matching pattern, relative to ~/.ssh unless
+// absolute.
+func (hc *sshHostConfig) include(pattern, alias string, depth int) error {
+	pattern = expandHome(pattern)
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(filepath.Dir(DefaultSSHConfigPath()), pattern)
+	}
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("ssh config: Include %s: %w", pattern, err)
+	}
+	for _, file := range files {
+		if err := hc.parse(file, alias, true, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitSSHConfigLine returns the lower-cased keyword of a line and its
+// arguments, with double quotes removed. Comments and blank lines yield
+// an empty keyword.
+func splitSSHConfigLine(line string) (string, []string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil
+	}
+	i := strings.IndexAny(line, " \t=")
+	if i < 0 {
+		return strings.ToLower(line), nil
+	}
+	keyword := strings.ToLower(line[:i])
+	rest := strings.TrimLeft(line[i:], " \t")
+	rest = strings.TrimLeft(strings.TrimPrefix(rest, "="), " \t")
+
+	var args []string
+	for rest != "" {
+		var arg string
+		if rest[0] == '"' {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				arg, rest = rest[1:], ""
+			} else {
+				arg, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if end := strings.IndexAny(rest, " \t"); end >= 0 {
+			arg, rest = rest[:end], rest[end:]
+		} else {
+			arg, rest = rest, ""
+		}
+		args = append(args, arg)
+		rest = strings.TrimLeft(rest, " \t")
+	}
+	return keyword, args
+}
+
+// matchSSHHost reports whether alias matches the patterns of a Host line:
+// any positive pattern and no negated ("!") one.
+func matchSSHHost(patterns []string, alias string) bool {
+	matched := false
+	for _, p := range patterns {
+		negated := strings.HasPrefix(p, "!")
+		ok, err := path.Match(strings.TrimPrefix(p, "!"), alias)
+		if err != nil || !ok {
+			continue
+		}
+		if negated {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+func setOnce(dst *string, v string) {
+	if *dst == "" {
+		*dst = v
+	}
+}
+
+func expandHome(p string) string {
+	if p != "~" && !strings.HasPrefix(p, "~/") {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+	return filepath.Join(home, p[1:])
+}
+
+// sshHop is one SSH connection on the way to the server.
+type sshHop struct {
+	addr     string
+	user     string
+	keyFiles []string
+}
+
+// sshRoute returns the hops to the server of cfg, jump hosts first. With
+// UseSSHConfig, Host is looked up as an alias and the settings found fill
+// in the fields left empty; jump hosts are resolved the same way.
+func sshRoute(cfg *SFTPConfig) ([]sshHop, error) {
+	if !cfg.UseSSHConfig {
+		hop := sshHop{addr: net.JoinHostPort(cfg.Host, cfg.Port), user: cfg.User}
+		if cfg.PkeyPath != "" {
+			hop.keyFiles = []string{cfg.PkeyPath}
+		}
+		return []sshHop{hop}, nil
+	}
+
+	file := cfg.SSHConfigPath
+	if file == "" {
+		file = DefaultSSHConfigPath()
+	}
+	var keyFiles []string
+	if cfg.PkeyPath != "" {
+		keyFiles = []string{cfg.PkeyPath}
+	}
+	target, proxyJump, err := resolveSSHHop(file, cfg.Host, cfg.Port, cfg.User, "", keyFiles)
+	if err != nil {
+		return nil, err
+	}
+	var route []sshHop
+	if proxyJump != "" && !strings.EqualFold(proxyJump, "none") {
+		jumps := strings.Split(proxyJump, ",")
+		if len(jumps) > maxSSHConfigDepth {
+			return nil, fmt.Errorf("ssh config: ProxyJump of %s has too many hops", cfg.Host)
+		}
+		for _, jump := range jumps {
+			user, host, port := parseJumpSpec(strings.TrimSpace(jump))
+			hop, _, err := resolveSSHHop(file, host, port, user, target.user, nil)
+			if err != nil {
+				return nil, fmt.Errorf("ProxyJump %s: %w", jump, err)
+			}
+			route = append(route, hop)
+		}
+	}
+	return append(route, target), nil
+}
+
+// resolveSSHHop completes host, port, user and key files from the
+// ssh_config entry of host, and returns the entry's ProxyJump. Jump hosts
+// without a user of their own connect as the user of the server.
+func resolveSSHHop(file, host, port, user, defaultUser string, keyFiles []string) (sshHop, string, error) {
+	hc, err := lookupSSHConfig(file, host)
+	if err != nil {
+		return sshHop{}, "", err
+	}
+	hostName := host
+	if hc.HostName != "" {
+		hostName = strings.ReplaceAll(hc.HostName, "%h", host)
+	}
+	setOnce(&port, hc.Port)
+	setOnce(&port, "22")
+	setOnce(&user, hc.User)
+	setOnce(&user, defaultUser)
+	if user == "" {
+		return sshHop{}, "", fmt.Errorf("no user for %s", host)
+	}
+	if len(keyFiles) == 0 {
+		// Like ssh, skip identity files that do not exist.
+		keyFiles = existingFiles(hc.IdentityFiles)
+	}
+	if len(keyFiles) == 0 {
+		keyFiles = existingFiles([]string{
+			expandHome("~/.ssh/id_ed25519"),
+			expandHome("~/.ssh/id_ecdsa"),
+			expandHome("~/.ssh/id_rsa"),
+		})
+	}
+	return sshHop{addr: net.JoinHostPort(hostName, port), user: user, keyFiles: keyFiles}, hc.ProxyJump, nil
+}
+
+// parseJumpSpec splits a ProxyJump entry, [user@]host[:port].
+func parseJumpSpec(spec string) (user, host, port string) {
+	if i := strings.LastIndex(spec, "@"); i >= 0 {
+		user, spec = spec[:i], spec[i+1:]
+	}
+	host = spec
+	if strings.HasPrefix(spec, "[") {
+		// [v6addr]:port
+		if end := strings.Index(spec, "]"); end > 0 {
+			host = spec[1:end]
+			port = strings.TrimPrefix(spec[end+1:], ":")
+		}
+	} else if i := strings.LastIndex(spec, ":"); i >= 0 && strings.Count(spec, ":") == 1 {
+		host, port = spec[:i], spec[i+1:]
+	}
+	return user, host, port
+}
+
+func existingFiles(paths []string) []string {
+	var files []string
+	for _, p := range paths {
+		if _, err := os.Stat(p); err == nil {
+			files = append(files, p)
+		}
+	}
+	return files
+}
@@ -0,0 +1,213 @@
+package clients
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sshHome makes a temporary home directory with the given files below
+// ~/.ssh and returns the path of ~/.ssh.
+func sshHome(t *testing.T, files map[string]string) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	dir := filepath.Join(home, ".ssh")
+	for name, data := range files {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o700))
+		require.NoError(t, os.WriteFile(p, []byte(data), 0o600))
+	}
+	return dir
+}
+
+func TestSplitSSHConfigLine(t *testing.T) {
+	for line, want := range map[string][]string{
+		"":                             nil,
+		"   # comment":                 nil,
+		"Host db":                      {"host", "db"},
+		"  HostName\tdb.internal  ":    {"hostname", "db.internal"},
+		"Port=2222":                    {"port", "2222"},
+		"Port = 2222":                  {"port", "2222"},
+		`IdentityFile "~/.ssh/my key"`: {"identityfile", "~/.ssh/my key"},
+		"Host a b  !c":                 {"host", "a", "b", "!c"},
+		`ProxyCommand "unterminated`:   {"proxycommand", "unterminated"},
+		"ForwardAgent":                 {"forwardagent"},
+	} {
+		keyword, args := splitSSHConfigLine(line)
+		if want == nil {
+			assert.Empty(t, keyword, line)
+			continue
+		}
+		assert.Equal(t, want[0], keyword, line)
+		assert.Equal(t, want[1:], append([]string{}, args...), line)
+	}
+}
+
+func TestMatchSSHHost(t *testing.T) {
+	for _, tc := range []struct {
+		patterns []string
+		alias    string
+		want     bool
+	}{
+		{[]string{"db"}, "db", true},
+		{[]string{"db"}, "db2", false},
+		{[]string{"*"}, "anything", true},
+		{[]string{"db?"}, "db2", true},
+		{[]string{"*.example.com"}, "a.example.com", true},
+		{[]string{"*.example.com"}, "example.com", false},
+		{[]string{"web", "db"}, "db", true},
+		{[]string{"*.example.com", "!bastion.example.com"}, "bastion.example.com", false},
+		{[]string{"!bastion.example.com", "*.example.com"}, "bastion.example.com", false},
+		{[]string{"!bastion"}, "db", false}, // a negation alone matches nothing
+		{[]string{"[ab]*"}, "alpha", true},
+	} {
+		assert.Equal(t, tc.want, matchSSHHost(tc.patterns, tc.alias), "%v %s", tc.patterns, tc.alias)
+	}
+}
+
+func TestLookupSSHConfig(t *testing.T) {
+	dir := sshHome(t, map[string]string{
+		"config": `# Settings come first-match-wins, as in ssh.
+Host db
+  HostName db.internal
+  User postgres
+  IdentityFile ~/.ssh/db_key
+
+Host db *.internal
+  Port 2222
+  User ignored
+  IdentityFile ~/.ssh/shared
+
+Match host db
+  User matched
+
+Host *
+  Include conf.d/*.conf
+  User fallback
+  Port 22
+`,
+		"conf.d/a.conf": `Host other
+  HostName other.example.com
+  ProxyJump bastion
+`,
+		"conf.d/b.conf": `User included
+`,
+	})
+	file := filepath.Join(dir, "config")
+
+	for alias, want := range map[string]sshHostConfig{
+		"db": {
+			HostName:      "db.internal",
+			Port:          "2222",
+			User:          "postgres",
+			IdentityFiles: []string{filepath.Join(dir, "db_key"), filepath.Join(dir, "shared")},
+		},
+		"cache.internal": {
+			Port:          "2222",
+			User:          "ignored",
+			IdentityFiles: []string{filepath.Join(dir, "shared")},
+		},
+		"other": {
+			HostName:  "other.example.com",
+			ProxyJump: "bastion",
+			User:      "included",
+			Port:      "22",
+		},
+		"web": {User: "included", Port: "22"},
+	} {
+		hc, err := lookupSSHConfig(file, alias)
+		require.NoError(t, err, alias)
+		assert.Equal(t, want, *hc, alias)
+	}
+
+	hc, err := lookupSSHConfig(filepath.Join(dir, "missing"), "db")
+	require.NoError(t, err)
+	assert.Equal(t, sshHostConfig{}, *hc)
+}
+
+func TestLookupSSHConfig_Errors(t *testing.T) {
+	dir := sshHome(t, map[string]string{
+		"loop":    "Include loop\n",
+		"novalue": "Host db\n  Port\n",
+		"abs":     "Include " + "/nonexistent/*.conf\n",
+		"badglob": "Include [\n",
+	})
+
+	_, err := lookupSSHConfig(filepath.Join(dir, "loop"), "db")
+	assert.ErrorContains(t, err, "nested too deeply")
+	_, err = lookupSSHConfig(filepath.Join(dir, "novalue"), "db")
+	assert.ErrorContains(t, err, "novalue:2: port without a value")
+	_, err = lookupSSHConfig(filepath.Join(dir, "badglob"), "db")
+	assert.Error(t, err)
+
+	// An Include matching nothing is fine.
+	_, err = lookupSSHConfig(filepath.Join(dir, "abs"), "db")
+	assert.NoError(t, err)
+}
+
+func TestParseJumpSpec(t *testing.T) {
+	for spec, want := range map[string][3]string{
+		"bastion":                {"", "bastion", ""},
+		"admin@bastion":          {"admin", "bastion", ""},
+		"admin@bastion:2200":     {"admin", "bastion", "2200"},
+		"bastion.example.com:22": {"", "bastion.example.com", "22"},
+		"[fe80::1]:2200":         {"", "fe80::1", "2200"},
+		"me@[fe80::1]":           {"me", "fe80::1", ""},
+		"fe80::1":                {"", "fe80::1", ""},
+		"a@b@bastion":            {"a@b", "bastion", ""},
+	} {
+		user, host, port := parseJumpSpec(spec)
+		assert.Equal(t, want, [3]string{user, host, port}, spec)
+	}
+}
+
+func TestSSHRoute_ProxyJump(t *testing.T) {
+	dir := sshHome(t, map[string]string{
+		"config": `Host target
+  HostName 10.0.0.5
+  User app
+  IdentityFile ~/.ssh/target_key
+  ProxyJump bastion,admin@[fe80::1]:2200
+
+Host bastion
+  HostName %h.example.com
+  Port 2022
+
+Host direct
+  HostName direct.example.com
+  User app
+  ProxyJump none
+`,
+		"target_key": "key",
+		"id_ed25519": "key",
+	})
+	cfg := &SFTPConfig{Host: "target", UseSSHConfig: true, SSHConfigPath: filepath.Join(dir, "config")}
+
+	route, err := sshRoute(cfg)
+	require.NoError(t, err)
+	defaultKey := []string{filepath.Join(dir, "id_ed25519")}
+	assert.Equal(t, []sshHop{
+		{addr: "bastion.example.com:2022", user: "app", keyFiles: defaultKey},
+		{addr: "[fe80::1]:2200", user: "admin", keyFiles: defaultKey},
+		{addr: "10.0.0.5:22", user: "app", keyFiles: []string{filepath.Join(dir, "target_key")}},
+	}, route)
+
+	// Explicit settings win over the config file.
+	cfg = &SFTPConfig{Host: "direct", Port: "2200", User: "ops", PkeyPath: "/keys/ops", UseSSHConfig: true, SSHConfigPath: cfg.SSHConfigPath}
+	route, err = sshRoute(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []sshHop{{addr: "direct.example.com:2200", user: "ops", keyFiles: []string{"/keys/ops"}}}, route)
+
+	// Without a user anywhere the route cannot be built.
+	_, err = sshRoute(&SFTPConfig{Host: "unknown", UseSSHConfig: true, SSHConfigPath: cfg.SSHConfigPath})
+	assert.ErrorContains(t, err, "no user for unknown")
+
+	// Without UseSSHConfig the fields are taken as they are.
+	route, err = sshRoute(&SFTPConfig{Host: "target", Port: "22", User: "u", PkeyPath: "k"})
+	require.NoError(t, err)
+	assert.Equal(t, []sshHop{{addr: "target:22", user: "u", keyFiles: []string{"k"}}}, route)
+}
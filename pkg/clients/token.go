@@ -0,0 +1,123 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenExpirySkew is how long before its expiry a cached token is
+// refreshed.
+const tokenExpirySkew = time.Minute
+
+// tokenSource fetches OAuth2 access tokens.
+type tokenSource interface {
+	token(ctx context.Context) (string, time.Time, error)
+}
+
+// bearerTransport authorizes requests with a cached access token.
+type bearerTransport struct {
+	base http.RoundTripper
+	src  tokenSource
+
+	mu     sync.Mutex
+	tok    string
+	expiry time.Time
+}
+
+func (t *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tok, err := t.token(req.Context())
+	if err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+tok)
+	return t.base.RoundTrip(req)
+}
+
+func (t *bearerTransport) token(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tok != "" && time.Until(t.expiry) > tokenExpirySkew {
+		return t.tok, nil
+	}
+	tok, expiry, err := t.src.token(ctx)
+	if err != nil {
+		return "", fmt.Errorf("fetch access token: %w", err)
+	}
+	t.tok, t.expiry = tok, expiry
+	return tok, nil
+}
+
+func postTokenForm(ctx context.Context, hc *http.Client, tokenURL string, form url.Values) (string, time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := hc.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return decodeTokenResponse(resp)
+}
+
+func decodeTokenResponse(resp *http.Response) (string, time.Time, error) {
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		_ = json.Unmarshal(data, &e)
+		return "", time.Time{}, fmt.Errorf("%s: %s (HTTP %d)", e.Error, e.Description, resp.StatusCode)
+	}
+	var out struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return "", time.Time{}, fmt.Errorf("decode token response: %w", err)
+	}
+	if out.AccessToken == "" {
+		return "", time.Time{}, errors.New("token response without access_token")
+	}
+	// Some token endpoints (Azure IMDS) send the lifetime as a string.
+	expiresIn, err := out.ExpiresIn.Int64()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("decode token response: expires_in: %w", err)
+	}
+	return out.AccessToken, time.Now().Add(time.Duration(expiresIn) * time.Second), nil
+}
+
+// pingHTTP makes a GET request and fails unless it succeeds.
+func pingHTTP(ctx context.Context, hc *http.Client, u string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return fmt.Errorf("ping: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("ping %s: %s", req.URL.Redacted(), resp.Status)
+	}
+	return nil
+}
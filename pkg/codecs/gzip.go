@@ -0,0 +1,63 @@
+package codecs
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+)
+
+// GzipCompressor is a single-threaded gzip compressor with a configurable
+// level. Pair it with codec.GzipDecompressor.
+type GzipCompressor struct {
+	// Level is a compress/flate level (1-9 or flate.HuffmanOnly); 0
+	// means flate.DefaultCompression.
+	Level int
+}
+
+var _ codec.Compressor = GzipCompressor{}
+
+// NewWriter implements codec.Compressor.
+func (c GzipCompressor) NewWriter(w io.Writer) (codec.WriteFlushCloser, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return gzip.NewWriterLevel(w, gzipLevel(c.Level))
+}
+
+// FileExtension implements codec.Compressor.
+func (c GzipCompressor) FileExtension() string {
+	return codec.GzipFileExt
+}
+
+// Name implements codec.Compressor.
+func (c GzipCompressor) Name() string {
+	return codec.GzipCompName
+}
+
+// WithLevel implements storage.LeveledCompressor.
+func (c GzipCompressor) WithLevel(level int) (codec.Compressor, error) {
+	c.Level = level
+	return c, c.Validate()
+}
+
+// Validate implements storage.CodecValidator.
+func (c GzipCompressor) Validate() error {
+	return validateGzipLevel(c.Level)
+}
+
+func gzipLevel(level int) int {
+	if level == 0 {
+		return flate.DefaultCompression
+	}
+	return level
+}
+
+func validateGzipLevel(level int) error {
+	if level = gzipLevel(level); level < flate.HuffmanOnly || level > flate.BestCompression {
+		return fmt.Errorf("gzip: invalid compression level %d", level)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package codecs
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGzipCompressor_Levels(t *testing.T) {
+	plain := bytes.Repeat([]byte("level "), 10000)
+	for _, level := range []int{0, 1, 9, -2} {
+		got := gunzip(t, pgzip(t, ParallelGzipCompressor{Level: level}, plain, len(plain)))
+		assert.Equal(t, plain, got)
+
+		var buf bytes.Buffer
+		w, err := GzipCompressor{Level: level}.NewWriter(&buf)
+		require.NoError(t, err)
+		_, err = w.Write(plain)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		assert.Equal(t, plain, gunzip(t, buf.Bytes()))
+	}
+	_, err := GzipCompressor{Level: 10}.NewWriter(io.Discard)
+	assert.Error(t, err)
+}
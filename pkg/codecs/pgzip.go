@@ -0,0 +1,269 @@
+package codecs
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+)
+
+// DefaultGzipBlockSize is the block size of ParallelGzipCompressor when
+// BlockSize is zero.
+const DefaultGzipBlockSize = 1 << 20
+
+// gzipWindow is the deflate window; every block is compressed with the
+// previous block's tail as preset dictionary, so splitting costs little
+// ratio.
+const gzipWindow = 32 * 1024
+
+// ParallelGzipCompressor is a gzip compressor that deflates blocks of the
+// input on several cores at once, pgzip-style. Its output is a single
+// standard gzip member, readable by any gzip decoder (e.g.
+// codec.GzipDecompressor), so it can replace the Gzip compressor of an
+// existing archive:
+//
+//	alg.Gzip = &storage.CodecPair{
+//		Compressor:   codecs.ParallelGzipCompressor{BlockSize: 1 << 20, Workers: 4},
+//		Decompressor: codec.GzipDecompressor{},
+//	}
+//
+// Memory use is about BlockSize times (Workers+1) per writer.
+type ParallelGzipCompressor struct {
+	// Level is a compress/flate level (1-9 or flate.HuffmanOnly); 0
+	// means flate.DefaultCompression.
+	Level int
+
+	// BlockSize is the amount of input compressed per task; values below
+	// 64 KiB are raised to it. 0 means DefaultGzipBlockSize.
+	BlockSize int
+
+	// Workers bounds the blocks compressed concurrently; 0 means
+	// GOMAXPROCS.
+	Workers int
+}
+
+var _ codec.Compressor = ParallelGzipCompressor{}
+
+// FileExtension implements codec.Compressor.
+func (c ParallelGzipCompressor) FileExtension() string {
+	return codec.GzipFileExt
+}
+
+// Name implements codec.Compressor.
+func (c ParallelGzipCompressor) Name() string {
+	return codec.GzipCompName
+}
+
+// WithLevel implements storage.LeveledCompressor.
+func (c ParallelGzipCompressor) WithLevel(level int) (codec.Compressor, error) {
+	c.Level = level
+	return c, c.Validate()
+}
+
+// Validate implements storage.CodecValidator.
+func (c ParallelGzipCompressor) Validate() error {
+	if c.BlockSize < 0 {
+		return fmt.Errorf("gzip: invalid block size %d", c.BlockSize)
+	}
+	return validateGzipLevel(c.Level)
+}
+
+// NewWriter implements codec.Compressor. Flush compresses the pending
+// input and waits until it is written; Close flushes the last block and
+// the gzip trailer. Neither closes w.
+func (c ParallelGzipCompressor) NewWriter(w io.Writer) (codec.WriteFlushCloser, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	blockSize := c.BlockSize
+	if blockSize == 0 {
+		blockSize = DefaultGzipBlockSize
+	}
+	blockSize = max(blockSize, 2*gzipWindow)
+	workers := c.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	z := &pgzipWriter{
+		w:         w,
+		level:     gzipLevel(c.Level),
+		blockSize: blockSize,
+		queue:     make(chan chan pgzipBlock, workers),
+		done:      make(chan struct{}),
+	}
+	go z.run()
+	return z, nil
+}
+
+type pgzipBlock struct {
+	data []byte
+	err  error
+	ack  chan struct{} // closed once written, for Flush
+}
+
+type pgzipWriter struct {
+	w         io.Writer
+	level     int
+	blockSize int
+
+	buf  []byte // input of the block being filled
+	dict []byte // last gzipWindow bytes before buf
+	crc  uint32
+	size uint32 // input size mod 2^32, as in the gzip trailer
+
+	queue  chan chan pgzipBlock // compressed blocks, in order
+	done   chan struct{}        // closed when run returns
+	closed bool
+
+	mu  sync.Mutex
+	err error // first write or compression error
+}
+
+func (z *pgzipWriter) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, errors.New("gzip: write to closed writer")
+	}
+	if err := z.error(); err != nil {
+		return 0, err
+	}
+	z.crc = crc32.Update(z.crc, crc32.IEEETable, p)
+	z.size += uint32(len(p))
+	n := len(p)
+	for len(p) > 0 {
+		if z.buf == nil {
+			z.buf = make([]byte, 0, z.blockSize)
+		}
+		k := min(len(p), z.blockSize-len(z.buf))
+		z.buf = append(z.buf, p[:k]...)
+		p = p[k:]
+		if len(z.buf) == z.blockSize {
+			z.dispatch(false)
+		}
+	}
+	return n, nil
+}
+
+// dispatch hands the current block to a compression goroutine. It blocks
+// while Workers blocks are already pending.
+func (z *pgzipWriter) dispatch(last bool) {
+	block, dict := z.buf, z.dict
+	res := make(chan pgzipBlock, 1)
+	z.queue <- res
+	go func() {
+		res <- compressBlock(block, dict, z.level, last)
+	}()
+
+	tail := block
+	if len(tail) < gzipWindow {
+		tail = append(append([]byte(nil), dict...), block...)
+	}
+	z.dict = tail[max(0, len(tail)-gzipWindow):]
+	z.buf = nil
+}
+
+func compressBlock(block, dict []byte, level int, last bool) pgzipBlock {
+	var out bytes.Buffer
+	fw, err := flate.NewWriterDict(&out, level, dict)
+	if err != nil {
+		return pgzipBlock{err: err}
+	}
+	if _, err := fw.Write(block); err != nil {
+		return pgzipBlock{err: err}
+	}
+	// A sync flush ends non-final blocks on a byte boundary, so the
+	// deflate streams can be concatenated.
+	if last {
+		err = fw.Close()
+	} else {
+		err = fw.Flush()
+	}
+	return pgzipBlock{data: out.Bytes(), err: err}
+}
+
+// run writes the header and the compressed blocks in order. After an
+// error it keeps draining the queue so that Write never blocks forever.
+func (z *pgzipWriter) run() {
+	defer close(z.done)
+	// Header: magic, deflate, no flags, no mtime, no extra flags, unknown OS.
+	_, err := z.w.Write([]byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255})
+	if err != nil {
+		z.setError(err)
+	}
+	for res := range z.queue {
+		b := <-res
+		if err == nil {
+			err = b.err
+		}
+		if err == nil {
+			_, err = z.w.Write(b.data)
+		}
+		if err != nil {
+			z.setError(err)
+		}
+		if b.ack != nil {
+			close(b.ack)
+		}
+	}
+}
+
+// Flush compresses the input written so far with a sync flush, so a
+// reader can decode all of it, and waits for it to reach w.
+func (z *pgzipWriter) Flush() error {
+	if z.closed {
+		return errors.New("gzip: flush of closed writer")
+	}
+	if len(z.buf) > 0 {
+		z.dispatch(false)
+	}
+	res := make(chan pgzipBlock, 1)
+	ack := make(chan struct{})
+	res <- pgzipBlock{ack: ack}
+	z.queue <- res
+	<-ack
+	return z.error()
+}
+
+// Close compresses the last block, waits for all blocks to be written and
+// writes the gzip trailer.
+func (z *pgzipWriter) Close() error {
+	if z.closed {
+		return z.error()
+	}
+	z.closed = true
+	z.dispatch(true)
+	close(z.queue)
+	<-z.done
+	if err := z.error(); err != nil {
+		return err
+	}
+	var trailer [8]byte
+	binary.LittleEndian.PutUint32(trailer[:4], z.crc)
+	binary.LittleEndian.PutUint32(trailer[4:], z.size)
+	if _, err := z.w.Write(trailer[:]); err != nil {
+		z.setError(err)
+		return err
+	}
+	return nil
+}
+
+func (z *pgzipWriter) setError(err error) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if z.err == nil {
+		z.err = err
+	}
+}
+
+func (z *pgzipWriter) error() error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.err
+}
@@ -0,0 +1,130 @@
+package codecs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pgzip(t *testing.T, c ParallelGzipCompressor, plain []byte, chunk int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := c.NewWriter(&buf)
+	require.NoError(t, err)
+	for len(plain) > 0 {
+		n := min(chunk, len(plain))
+		_, err := w.Write(plain[:n])
+		require.NoError(t, err)
+		plain = plain[n:]
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func gunzip(t *testing.T, b []byte) []byte {
+	t.Helper()
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return got
+}
+
+func TestParallelGzip_RoundTrip(t *testing.T) {
+	text := bytes.Repeat([]byte("SELECT * FROM pg_stat_activity WHERE state = 'active';\n"), 20000)
+	random := make([]byte, 300*1024)
+	_, _ = rand.Read(random)
+
+	for _, tc := range []struct {
+		name  string
+		c     ParallelGzipCompressor
+		plain []byte
+	}{
+		{"empty", ParallelGzipCompressor{}, nil},
+		{"small", ParallelGzipCompressor{}, []byte("hello")},
+		{"text", ParallelGzipCompressor{BlockSize: 64 * 1024, Workers: 4}, text},
+		{"exact blocks", ParallelGzipCompressor{BlockSize: 64 * 1024, Workers: 2}, random[:4*64*1024]},
+		{"random", ParallelGzipCompressor{Level: 9, BlockSize: 100 * 1024}, random},
+		{"huffman", ParallelGzipCompressor{Level: -2, BlockSize: 64 * 1024}, text},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, chunk := range []int{7, 4096, 1 << 20} {
+				got := gunzip(t, pgzip(t, tc.c, tc.plain, chunk))
+				assert.Equal(t, len(tc.plain), len(got))
+				assert.True(t, bytes.Equal(tc.plain, got))
+			}
+		})
+	}
+}
+
+func TestParallelGzip_Flush(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := ParallelGzipCompressor{BlockSize: 64 * 1024, Workers: 2}.NewWriter(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, "gzip", ParallelGzipCompressor{}.Name())
+
+	_, err = w.Write([]byte("first record\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	require.NoError(t, w.Flush())
+
+	// Everything written before Flush decodes from what reached buf.
+	r, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	got := make([]byte, len("first record\n"))
+	_, err = io.ReadFull(r, got)
+	require.NoError(t, err)
+	assert.Equal(t, "first record\n", string(got))
+
+	_, err = w.Write([]byte("second record\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.Equal(t, "first record\nsecond record\n", string(gunzip(t, buf.Bytes())))
+	assert.Error(t, w.Flush())
+}
+
+func TestParallelGzip_RatioCloseToSequential(t *testing.T) {
+	var plain []byte
+	for i := 0; len(plain) < 2<<20; i++ {
+		plain = append(plain, []byte("wal record "+string(rune('a'+i%26))+" payload payload payload\n")...)
+	}
+	var seq bytes.Buffer
+	gw := gzip.NewWriter(&seq)
+	_, _ = gw.Write(plain)
+	require.NoError(t, gw.Close())
+
+	par := pgzip(t, ParallelGzipCompressor{BlockSize: 64 * 1024, Workers: 8}, plain, 1<<20)
+	assert.Less(t, len(par), seq.Len()*5/4)
+}
+
+type failingWriter struct{ n int }
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	if f.n <= 0 {
+		return 0, errors.New("disk full")
+	}
+	f.n--
+	return len(p), nil
+}
+
+func TestParallelGzip_Errors(t *testing.T) {
+	_, err := ParallelGzipCompressor{Level: 42}.NewWriter(io.Discard)
+	assert.Error(t, err)
+
+	w, err := ParallelGzipCompressor{BlockSize: 64 * 1024, Workers: 1}.NewWriter(&failingWriter{n: 1})
+	require.NoError(t, err)
+	random := make([]byte, 64*1024)
+	var werr error
+	for i := 0; i < 50 && werr == nil; i++ {
+		_, _ = rand.Read(random)
+		_, werr = w.Write(random)
+	}
+	err = w.Close()
+	assert.ErrorContains(t, errors.Join(werr, err), "disk full")
+}
@@ -0,0 +1,76 @@
+package codecs_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/hashmap-kz/storecrypt/pkg/codecs"
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodecLevels_NewVariadicStorage(t *testing.T) {
+	ctx := context.Background()
+	newVS := func(alg storage.Algorithms, ext string) (*storage.VariadicStorage, error) {
+		return storage.NewVariadicStorage(storage.NewInMemoryStorage(), alg, ext)
+	}
+
+	vs, err := newVS(storage.Algorithms{
+		Gzip: &storage.CodecPair{Compressor: codecs.GzipCompressor{}, Decompressor: codec.GzipDecompressor{}, Level: 9},
+		Zstd: &storage.CodecPair{
+			Compressor:   codecs.ZstdCompressor{WindowSize: 1 << 20, Concurrency: 2},
+			Decompressor: codecs.ZstdDecompressor{MaxWindow: 1 << 20},
+			Level:        19,
+		},
+	}, ".zst")
+	require.NoError(t, err)
+	require.NoError(t, vs.Put(ctx, "a", bytes.NewReader([]byte("tuned"))))
+	rc, err := vs.Get(ctx, "a")
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, "tuned", string(got))
+
+	for name, alg := range map[string]storage.Algorithms{
+		"gzip level":   {Gzip: &storage.CodecPair{Compressor: codecs.GzipCompressor{}, Decompressor: codec.GzipDecompressor{}, Level: 12}},
+		"pgzip level":  {Gzip: &storage.CodecPair{Compressor: codecs.ParallelGzipCompressor{}, Decompressor: codec.GzipDecompressor{}, Level: -3}},
+		"zstd level":   {Zstd: &storage.CodecPair{Compressor: codecs.ZstdCompressor{}, Decompressor: codecs.ZstdDecompressor{}, Level: 23}},
+		"zstd window":  {Zstd: &storage.CodecPair{Compressor: codecs.ZstdCompressor{WindowSize: 3000}, Decompressor: codecs.ZstdDecompressor{}}},
+		"zstd decoder": {Zstd: &storage.CodecPair{Compressor: codecs.ZstdCompressor{}, Decompressor: codecs.ZstdDecompressor{Concurrency: -1}}},
+	} {
+		_, err := newVS(alg, "")
+		assert.Error(t, err, name)
+	}
+}
+
+func TestZstdDict_VariadicStorage(t *testing.T) {
+	ctx := context.Background()
+	samples := make([][]byte, 10)
+	for i := range samples {
+		for j := range 64 {
+			samples[i] = fmt.Appendf(samples[i], "rmgr: Heap len (rec/tot): 54/54, tx: %d, lsn: 0/%08X\n", 1000+i, i*4096+j)
+		}
+	}
+	dict, err := codecs.TrainZstdDict(0, samples, 0)
+	require.NoError(t, err)
+
+	c, d := codecs.NewZstdDictPair(0, dict)
+	mem := storage.NewInMemoryStorage()
+	vs, err := storage.NewVariadicStorage(mem, storage.Algorithms{Zstd: &storage.CodecPair{Compressor: c, Decompressor: d}}, ".zst")
+	require.NoError(t, err)
+	require.NoError(t, vs.Put(ctx, "wal/000000010000000000000001", bytes.NewReader(samples[3])))
+	assert.Contains(t, mem.Files, "wal/000000010000000000000001.zst")
+
+	rc, err := vs.Get(ctx, "wal/000000010000000000000001")
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, samples[3], got)
+}
@@ -0,0 +1,223 @@
+// Package codecs provides compression codecs beyond the ones shipped with
+// streamcrypt, for use in storage.CodecPair. The storage package applies
+// CodecPair.Level to the stock streamcrypt codecs through the ones here,
+// so this package does not import it.
+package codecs
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultZstdDictSize is the dictionary size TrainZstdDict aims for when
+// maxSize is zero; it matches the zstd CLI default.
+const DefaultZstdDictSize = 110 * 1024
+
+// ZstdCompressor compresses with zstd, optionally against a shared
+// dictionary. Dictionaries pay off for many small, similar objects (WAL
+// segments, JSON manifests): each object can refer to content in the
+// dictionary instead of repeating it.
+//
+// Objects keep the ".zst" extension. Frames record the dictionary ID, so
+// readers need the same dictionary (see ZstdDecompressor.Dicts).
+type ZstdCompressor struct {
+	// Level is a zstd level (1-22); 0 means the encoder default.
+	Level int
+
+	// WindowSize is the match window in bytes, a power of two between
+	// 1 KiB and 512 MiB; 0 means the level's default. Readers need at
+	// least as much memory per stream.
+	WindowSize int
+
+	// Concurrency is the number of goroutines compressing one stream; 0
+	// means GOMAXPROCS.
+	Concurrency int
+
+	// Dict is a dictionary produced by TrainZstdDict or `zstd --train`;
+	// nil compresses without one.
+	Dict []byte
+}
+
+var _ codec.Compressor = ZstdCompressor{}
+
+// NewWriter implements codec.Compressor.
+func (c ZstdCompressor) NewWriter(w io.Writer) (codec.WriteFlushCloser, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	var opts []zstd.EOption
+	if c.Level != 0 {
+		opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.Level)))
+	}
+	if c.WindowSize != 0 {
+		opts = append(opts, zstd.WithWindowSize(c.WindowSize))
+	}
+	if c.Concurrency != 0 {
+		opts = append(opts, zstd.WithEncoderConcurrency(c.Concurrency))
+	}
+	if c.Dict != nil {
+		opts = append(opts, zstd.WithEncoderDict(c.Dict))
+	}
+	enc, err := zstd.NewWriter(w, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("zstd writer: %w", err)
+	}
+	return enc, nil
+}
+
+// FileExtension implements codec.Compressor.
+func (c ZstdCompressor) FileExtension() string {
+	return codec.ZstdFileExt
+}
+
+// Name implements codec.Compressor.
+func (c ZstdCompressor) Name() string {
+	return codec.ZstdCompName
+}
+
+// WithLevel implements storage.LeveledCompressor.
+func (c ZstdCompressor) WithLevel(level int) (codec.Compressor, error) {
+	c.Level = level
+	return c, c.Validate()
+}
+
+// Validate implements storage.CodecValidator.
+func (c ZstdCompressor) Validate() error {
+	if c.Level < 0 || c.Level > 22 {
+		return fmt.Errorf("zstd: invalid compression level %d", c.Level)
+	}
+	if c.WindowSize != 0 && (c.WindowSize < zstd.MinWindowSize || c.WindowSize > zstd.MaxWindowSize || c.WindowSize&(c.WindowSize-1) != 0) {
+		return fmt.Errorf("zstd: invalid window size %d", c.WindowSize)
+	}
+	if c.Concurrency < 0 {
+		return fmt.Errorf("zstd: invalid concurrency %d", c.Concurrency)
+	}
+	return nil
+}
+
+// ZstdDecompressor decompresses zstd streams, with or without a
+// dictionary. Keep every dictionary that was ever used for writing in
+// Dicts; the frame's dictionary ID selects the right one, and frames
+// written without a dictionary always decode.
+type ZstdDecompressor struct {
+	Dicts [][]byte
+
+	// Concurrency is the number of goroutines decoding one stream; 0
+	// means the decoder default.
+	Concurrency int
+
+	// MaxWindow caps the window a stream may require, bounding memory
+	// per reader; 0 means the decoder default.
+	MaxWindow uint64
+}
+
+var _ codec.Decompressor = ZstdDecompressor{}
+
+// Validate implements storage.CodecValidator.
+func (d ZstdDecompressor) Validate() error {
+	if d.Concurrency < 0 {
+		return fmt.Errorf("zstd: invalid concurrency %d", d.Concurrency)
+	}
+	if d.MaxWindow != 0 && (d.MaxWindow < zstd.MinWindowSize || d.MaxWindow > 1<<41) {
+		return fmt.Errorf("zstd: invalid max window %d", d.MaxWindow)
+	}
+	return nil
+}
+
+// FileExtension implements codec.Decompressor.
+func (d ZstdDecompressor) FileExtension() string {
+	return codec.ZstdFileExt
+}
+
+// Decompress implements codec.Decompressor.
+func (d ZstdDecompressor) Decompress(r io.Reader) (io.ReadCloser, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	var opts []zstd.DOption
+	if len(d.Dicts) > 0 {
+		opts = append(opts, zstd.WithDecoderDicts(d.Dicts...))
+	}
+	if d.Concurrency != 0 {
+		opts = append(opts, zstd.WithDecoderConcurrency(d.Concurrency))
+	}
+	if d.MaxWindow != 0 {
+		opts = append(opts, zstd.WithDecoderMaxWindow(d.MaxWindow))
+	}
+	dec, err := zstd.NewReader(r, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("zstd reader: %w", err)
+	}
+	return dec.IOReadCloser(), nil
+}
+
+// NewZstdDictPair returns the two halves of a storage.CodecPair (for
+// Algorithms.Zstd or TransformingStorage) that writes with dict and reads
+// objects compressed with dict, any of previous, or no dictionary at all.
+func NewZstdDictPair(level int, dict []byte, previous ...[]byte) (ZstdCompressor, ZstdDecompressor) {
+	dicts := append([][]byte{dict}, previous...)
+	return ZstdCompressor{Level: level, Dict: dict}, ZstdDecompressor{Dicts: dicts}
+}
+
+// TrainZstdDict builds a dictionary of at most maxSize bytes from sample
+// objects, which should be representative of what will be compressed
+// (a few hundred recent objects is typical). id identifies the dictionary
+// in compressed frames; zero picks a random one.
+func TrainZstdDict(id uint32, samples [][]byte, maxSize int) (dict []byte, err error) {
+	if len(samples) == 0 {
+		return nil, errors.New("codecs: no samples to train a zstd dictionary")
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultZstdDictSize
+	}
+	if id == 0 {
+		var b [4]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return nil, err
+		}
+		// IDs below 32768 are reserved by the zstd format.
+		id = 32768 + binary.BigEndian.Uint32(b[:])%(1<<31-32768)
+	}
+	// BuildDict divides by the literal count, which is zero when the
+	// history covers the samples entirely (e.g. one line repeated).
+	defer func() {
+		if recover() != nil {
+			dict, err = nil, errors.New("codecs: train zstd dictionary: samples are too repetitive")
+		}
+	}()
+	dict, err = zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       id,
+		Contents: samples,
+		History:  dictHistory(samples, maxSize),
+		Offsets:  [3]int{1, 4, 8},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("codecs: train zstd dictionary: %w", err)
+	}
+	return dict, nil
+}
+
+// dictHistory picks the raw content of the dictionary: an equal share of
+// the head of every sample, which is where similar objects (headers,
+// common prefixes) tend to repeat. Later samples are placed last, closest
+// to the data, as zstd favours near matches. At most half of a sample is
+// taken, so the samples still leave literals to build the entropy tables
+// from.
+func dictHistory(samples [][]byte, maxSize int) []byte {
+	share := max(maxSize/len(samples), 64)
+	history := make([]byte, 0, maxSize)
+	for _, s := range samples {
+		if len(history) == maxSize {
+			break
+		}
+		n := min(len(s)/2, share, maxSize-len(history))
+		history = append(history, s[:n]...)
+	}
+	return history
+}
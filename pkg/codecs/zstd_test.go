@@ -0,0 +1,97 @@
+package codecs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func walLikeSamples(n int) [][]byte {
+	samples := make([][]byte, n)
+	for i := range samples {
+		var buf bytes.Buffer
+		for j := 0; j < 64; j++ {
+			fmt.Fprintf(&buf, "rmgr: Heap len (rec/tot): 54/54, tx: %d, lsn: 0/%08X, desc: INSERT off %d\n", 1000+i, i*4096+j, j)
+		}
+		samples[i] = buf.Bytes()
+	}
+	return samples
+}
+
+func roundTrip(t *testing.T, c codec.Compressor, d codec.Decompressor, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := c.NewWriter(&buf)
+	require.NoError(t, err)
+	_, err = w.Write(plain)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	compressed := append([]byte(nil), buf.Bytes()...)
+
+	r, err := d.Decompress(&buf)
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, plain, got)
+	return compressed
+}
+
+func TestZstdDict_RoundTrip(t *testing.T) {
+	samples := walLikeSamples(50)
+	dict, err := TrainZstdDict(0, samples[:40], 16*1024)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(dict), 16*1024+4096) // history plus entropy tables
+
+	c, d := NewZstdDictPair(3, dict)
+	assert.Equal(t, "zstd", c.Name())
+	assert.Equal(t, ".zst", d.FileExtension())
+	for _, s := range samples[40:] {
+		roundTrip(t, c, d, s)
+	}
+
+	// Objects written without a dictionary still decode.
+	compressed := roundTrip(t, ZstdCompressor{}, ZstdDecompressor{}, samples[0])
+	r, err := d.Decompress(bytes.NewReader(compressed))
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, samples[0], got)
+
+	// Dictionary objects need the dictionary.
+	compressed = roundTrip(t, c, d, samples[41])
+	r, err = ZstdDecompressor{}.Decompress(bytes.NewReader(compressed))
+	if err == nil {
+		_, err = io.ReadAll(r)
+	}
+	assert.Error(t, err)
+}
+
+func TestZstdDict_Rotation(t *testing.T) {
+	samples := walLikeSamples(20)
+	oldDict, err := TrainZstdDict(40001, samples[:10], 0)
+	require.NoError(t, err)
+	newDict, err := TrainZstdDict(40002, samples[10:], 0)
+	require.NoError(t, err)
+
+	c, d := NewZstdDictPair(0, oldDict)
+	compressed := roundTrip(t, c, d, samples[0])
+	_, d = NewZstdDictPair(0, newDict, oldDict)
+	r, err := d.Decompress(bytes.NewReader(compressed))
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, samples[0], got)
+}
+
+func TestTrainZstdDict_BadSamples(t *testing.T) {
+	_, err := TrainZstdDict(0, nil, 0)
+	assert.Error(t, err)
+	_, err = TrainZstdDict(0, [][]byte{bytes.Repeat([]byte("same line\n"), 100)}, 0)
+	assert.ErrorContains(t, err, "too repetitive")
+}
@@ -0,0 +1,161 @@
+package crypters
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"filippo.io/age"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+)
+
+// The age format (https://age-encryption.org/v1) is implemented by
+// filippo.io/age; the types below are aliases of its recipients and
+// identities, so third-party ones (plugins, SSH keys) plug in as well.
+
+// DefaultAgeScryptWorkFactor is the log2 of the scrypt N parameter used for
+// passphrase-encrypted files, as in the age CLI.
+const DefaultAgeScryptWorkFactor = 18
+
+// ErrAgeNoIdentityMatch is returned when none of the identities can unwrap
+// the file key of an age file.
+var ErrAgeNoIdentityMatch = errors.New("crypters: no age identity matched any of the recipients")
+
+type (
+	// AgeStanza is a recipient stanza of an age header.
+	AgeStanza = age.Stanza
+	// AgeRecipient wraps a file key for one recipient.
+	AgeRecipient = age.Recipient
+	// AgeIdentity unwraps the file key from the stanzas of an age header.
+	AgeIdentity = age.Identity
+
+	// AgeX25519Recipient encrypts to an age public key ("age1...").
+	AgeX25519Recipient = age.X25519Recipient
+	// AgeX25519Identity is an age secret key ("AGE-SECRET-KEY-1...").
+	AgeX25519Identity = age.X25519Identity
+
+	// AgeScryptRecipient encrypts to a passphrase.
+	AgeScryptRecipient = age.ScryptRecipient
+	// AgeScryptIdentity decrypts files encrypted to a passphrase.
+	AgeScryptIdentity = age.ScryptIdentity
+)
+
+// Age is a crypt.Crypter producing age files, readable with the age CLI
+// and library. Files are encrypted to X25519 public keys or to a
+// passphrase and decrypted with the matching identities, so archives can
+// be written with public keys only and restored where the secret key is.
+type Age struct {
+	recipients []AgeRecipient
+	identities []AgeIdentity
+}
+
+var _ crypt.Crypter = (*Age)(nil)
+
+// NewAge creates an Age crypter encrypting to recipients and decrypting
+// with identities. Either may be empty for a write-only or read-only
+// crypter.
+func NewAge(recipients []AgeRecipient, identities []AgeIdentity) *Age {
+	return &Age{recipients: recipients, identities: identities}
+}
+
+// NewAgeWithPassphrase creates an Age crypter that encrypts and decrypts
+// with a passphrase.
+func NewAgeWithPassphrase(passphrase string) (*Age, error) {
+	r, err := NewAgeScryptRecipient(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	id, err := NewAgeScryptIdentity(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return NewAge([]AgeRecipient{r}, []AgeIdentity{id}), nil
+}
+
+// FileExtension implements crypt.Crypter.
+func (a *Age) FileExtension() string {
+	return ".age"
+}
+
+// Name implements crypt.Crypter.
+func (a *Age) Name() string {
+	return "age"
+}
+
+// Encrypt implements crypt.Crypter.
+func (a *Age) Encrypt(out io.Writer) (io.WriteCloser, error) {
+	if len(a.recipients) == 0 {
+		return nil, errors.New("crypters: no age recipients")
+	}
+	w, err := age.Encrypt(out, a.recipients...)
+	if err != nil {
+		return nil, fmt.Errorf("crypters: age: %w", err)
+	}
+	return w, nil
+}
+
+// Decrypt implements crypt.Crypter.
+func (a *Age) Decrypt(in io.Reader) (io.Reader, error) {
+	if len(a.identities) == 0 {
+		return nil, ErrAgeNoIdentityMatch
+	}
+	r, err := age.Decrypt(in, a.identities...)
+	if err != nil {
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			return nil, ErrAgeNoIdentityMatch
+		}
+		return nil, fmt.Errorf("crypters: age: %w", err)
+	}
+	return r, nil
+}
+
+// GenerateAgeX25519Identity creates a new random age key pair.
+func GenerateAgeX25519Identity() (*AgeX25519Identity, error) {
+	return age.GenerateX25519Identity()
+}
+
+// ParseAgeX25519Recipient parses an "age1..." public key.
+func ParseAgeX25519Recipient(s string) (*AgeX25519Recipient, error) {
+	return age.ParseX25519Recipient(s)
+}
+
+// ParseAgeX25519Identity parses an "AGE-SECRET-KEY-1..." secret key.
+func ParseAgeX25519Identity(s string) (*AgeX25519Identity, error) {
+	return age.ParseX25519Identity(s)
+}
+
+// NewAgeScryptRecipient creates a passphrase recipient using
+// DefaultAgeScryptWorkFactor. Its SetWorkFactor changes the log2 of the
+// scrypt N parameter; every increment doubles the time and memory needed
+// to encrypt and decrypt.
+func NewAgeScryptRecipient(passphrase string) (*AgeScryptRecipient, error) {
+	r, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("crypters: age: %w", err)
+	}
+	r.SetWorkFactor(DefaultAgeScryptWorkFactor)
+	return r, nil
+}
+
+// NewAgeScryptIdentity creates a passphrase identity. Its SetMaxWorkFactor
+// limits the scrypt work factor a file may demand (default 22).
+func NewAgeScryptIdentity(passphrase string) (*AgeScryptIdentity, error) {
+	id, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("crypters: age: %w", err)
+	}
+	return id, nil
+}
+
+// ParseAgeIdentities reads age secret keys, one per line, as written by
+// age-keygen. Empty lines and lines starting with '#' are skipped.
+func ParseAgeIdentities(r io.Reader) ([]AgeIdentity, error) {
+	return age.ParseIdentities(r)
+}
+
+// ParseAgeRecipients reads age public keys, one per line. Empty lines and
+// lines starting with '#' are skipped.
+func ParseAgeRecipients(r io.Reader) ([]AgeRecipient, error) {
+	return age.ParseRecipients(r)
+}
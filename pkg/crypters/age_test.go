@@ -0,0 +1,145 @@
+package crypters
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ageExampleFile is testdata/example.age of filippo.io/age, encrypted by
+// the age reference implementation to ageExampleKey.
+const (
+	ageExampleKey  = "AGE-SECRET-KEY-184JMZMVQH3E6U0PSL869004Y3U2NYV7R30EU99CSEDNPH02YUVFSZW44VU"
+	ageExampleFile = "YWdlLWVuY3J5cHRpb24ub3JnL3YxCi0+IFgyNTUxOSA4aHJsTStaQkczRGQ0ZkYyK2E1ODN6ZFRJV0RrOC9SNDFrQ1lac3Z3VFc0CnlPNFBZZGxNV0RKK0N4Z1VOUnFZNVowVC9tK2czRkNoNWpJeEdMYkNWWGMKLS0tIEkvaW1ldlp6eTgxMjBKU3ptSm5tbi9LTWszcDVBMTFWODNOazQxbTlOUEUKcMXlNiShUgdT+Sxa0Q7KsnO6TWEXgHcT6DggQXod8soIGCJyyPhchXc0oTEaO3XpjQ6v"
+)
+
+func TestAge_ReferenceFile(t *testing.T) {
+	id, err := ParseAgeX25519Identity(ageExampleKey)
+	require.NoError(t, err)
+	sealed, err := base64.StdEncoding.DecodeString(ageExampleFile)
+	require.NoError(t, err)
+
+	got, err := decrypt(NewAge(nil, []AgeIdentity{id}), sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "Black lives matter.", string(got))
+}
+
+func TestAge_X25519(t *testing.T) {
+	alice, err := GenerateAgeX25519Identity()
+	require.NoError(t, err)
+	bob, err := GenerateAgeX25519Identity()
+	require.NoError(t, err)
+	eve, err := GenerateAgeX25519Identity()
+	require.NoError(t, err)
+
+	// Keys survive their text encoding.
+	assert.True(t, strings.HasPrefix(alice.String(), "AGE-SECRET-KEY-1"))
+	assert.True(t, strings.HasPrefix(alice.Recipient().String(), "age1"))
+	parsed, err := ParseAgeX25519Identity(alice.String())
+	require.NoError(t, err)
+	assert.Equal(t, alice.Recipient().String(), parsed.Recipient().String())
+
+	rcpt, err := ParseAgeX25519Recipient(bob.Recipient().String())
+	require.NoError(t, err)
+
+	// Writers only need the public keys.
+	writer := NewAge([]AgeRecipient{alice.Recipient(), rcpt}, nil)
+	assert.Equal(t, ".age", writer.FileExtension())
+	assert.Equal(t, "age", writer.Name())
+	plain := make([]byte, 2*ChunkSize+17)
+	_, _ = rand.Read(plain)
+	sealed := encrypt(t, writer, plain)
+	assert.True(t, bytes.HasPrefix(sealed, []byte("age-encryption.org/v1\n-> X25519 ")))
+
+	for _, id := range []*AgeX25519Identity{alice, bob} {
+		got, err := decrypt(NewAge(nil, []AgeIdentity{id}), sealed)
+		require.NoError(t, err)
+		assert.Equal(t, plain, got)
+	}
+	_, err = decrypt(NewAge(nil, []AgeIdentity{eve}), sealed)
+	assert.ErrorIs(t, err, ErrAgeNoIdentityMatch)
+
+	_, err = writer.Encrypt(&bytes.Buffer{})
+	require.NoError(t, err)
+	_, err = NewAge(nil, nil).Encrypt(&bytes.Buffer{})
+	assert.Error(t, err)
+}
+
+func TestAge_Tampering(t *testing.T) {
+	id, err := GenerateAgeX25519Identity()
+	require.NoError(t, err)
+	age := NewAge([]AgeRecipient{id.Recipient()}, []AgeIdentity{id})
+	sealed := encrypt(t, age, []byte("secret"))
+
+	// Header changes break the MAC.
+	hdrEnd := bytes.Index(sealed, []byte("\n---")) + 1
+	tampered := append([]byte(nil), sealed...)
+	tampered = append(tampered[:hdrEnd], append([]byte("-> grease x\n\n"), tampered[hdrEnd:]...)...)
+	_, err = decrypt(age, tampered)
+	assert.ErrorContains(t, err, "bad header MAC")
+
+	// Payload changes and truncation break the STREAM.
+	tampered = append([]byte(nil), sealed...)
+	tampered[len(tampered)-1] ^= 1
+	_, err = decrypt(age, tampered)
+	assert.ErrorContains(t, err, "authenticate")
+
+	_, err = decrypt(age, []byte("not age\n"))
+	assert.Error(t, err)
+}
+
+func TestAge_Passphrase(t *testing.T) {
+	rcpt, err := NewAgeScryptRecipient("correct horse")
+	require.NoError(t, err)
+	rcpt.SetWorkFactor(10)
+	writer := NewAge([]AgeRecipient{rcpt}, nil)
+	sealed := encrypt(t, writer, []byte("hello"))
+	assert.Contains(t, string(sealed), "-> scrypt ")
+
+	right, err := NewAgeScryptIdentity("correct horse")
+	require.NoError(t, err)
+	got, err := decrypt(NewAge(nil, []AgeIdentity{right}), sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(got))
+
+	wrong, err := NewAgeScryptIdentity("wrong")
+	require.NoError(t, err)
+	_, err = decrypt(NewAge(nil, []AgeIdentity{wrong}), sealed)
+	assert.ErrorIs(t, err, ErrAgeNoIdentityMatch)
+
+	strict, err := NewAgeScryptIdentity("correct horse")
+	require.NoError(t, err)
+	strict.SetMaxWorkFactor(8)
+	_, err = decrypt(NewAge(nil, []AgeIdentity{strict}), sealed)
+	assert.ErrorContains(t, err, "too large")
+
+	_, err = NewAgeScryptRecipient("")
+	assert.Error(t, err)
+
+	// A passphrase cannot be mixed with other recipients.
+	id, err := GenerateAgeX25519Identity()
+	require.NoError(t, err)
+	_, err = NewAge([]AgeRecipient{rcpt, id.Recipient()}, nil).Encrypt(&bytes.Buffer{})
+	assert.Error(t, err)
+}
+
+func TestParseAgeKeyFiles(t *testing.T) {
+	id, err := GenerateAgeX25519Identity()
+	require.NoError(t, err)
+	file := "# created: 2024-01-01\n# public key: " + id.Recipient().String() + "\n" + id.String() + "\n"
+	ids, err := ParseAgeIdentities(strings.NewReader(file))
+	require.NoError(t, err)
+	require.Len(t, ids, 1)
+
+	rs, err := ParseAgeRecipients(strings.NewReader("\n" + id.Recipient().String() + "\n"))
+	require.NoError(t, err)
+	require.Len(t, rs, 1)
+
+	_, err = ParseAgeRecipients(strings.NewReader("age1bogus\n"))
+	assert.ErrorContains(t, err, "line 1")
+}
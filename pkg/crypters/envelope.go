@@ -0,0 +1,282 @@
+package crypters
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+)
+
+// DataKeySize is the size of the per-object data keys (AES-256).
+const DataKeySize = 32
+
+var envelopeMagic = []byte("SCENV1")
+
+// ErrUnknownKey is returned when an object was wrapped by a master key
+// that none of the configured KeyWrappers owns.
+var ErrUnknownKey = errors.New("crypters: no key wrapper for key id")
+
+// KeyWrapper protects data keys with a master key. Implementations may
+// keep the master key locally or delegate to a KMS.
+type KeyWrapper interface {
+	// KeyID identifies the master key; it is stored in clear in the
+	// object header so the right wrapper can be picked on read.
+	KeyID() string
+	WrapKey(ctx context.Context, dataKey []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// DataKeyGenerator is implemented by KeyWrappers that can mint data keys
+// themselves (e.g. KMS GenerateDataKey), returning the key both in clear
+// and wrapped. Envelope prefers it over generating and wrapping locally.
+type DataKeyGenerator interface {
+	GenerateDataKey(ctx context.Context) (dataKey, wrapped []byte, err error)
+}
+
+// LocalKeyWrapper wraps data keys with a local AES-256-GCM master key.
+type LocalKeyWrapper struct {
+	id   string
+	aead cipher.AEAD
+}
+
+var _ KeyWrapper = (*LocalKeyWrapper)(nil)
+
+// NewLocalKeyWrapper creates a wrapper from a 32-byte master key. An empty
+// id defaults to a fingerprint of the key.
+func NewLocalKeyWrapper(id string, key []byte) (*LocalKeyWrapper, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("crypters: master key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		sum := sha256.Sum256(key)
+		id = "local:" + hex.EncodeToString(sum[:8])
+	}
+	return &LocalKeyWrapper{id: id, aead: aead}, nil
+}
+
+// NewLocalKeyWrapperFromFile reads a master key from a keyfile holding
+// either 32 raw bytes or 64 hex characters.
+func NewLocalKeyWrapperFromFile(id, path string) (*LocalKeyWrapper, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read keyfile: %w", err)
+	}
+	key := data
+	if s := strings.TrimSpace(string(data)); len(s) == 64 {
+		if decoded, err := hex.DecodeString(s); err == nil {
+			key = decoded
+		}
+	}
+	return NewLocalKeyWrapper(id, key)
+}
+
+func (l *LocalKeyWrapper) KeyID() string {
+	return l.id
+}
+
+func (l *LocalKeyWrapper) WrapKey(_ context.Context, dataKey []byte) ([]byte, error) {
+	return sealSmall(l.aead, dataKey, []byte(l.id))
+}
+
+func (l *LocalKeyWrapper) UnwrapKey(_ context.Context, wrapped []byte) ([]byte, error) {
+	return openSmall(l.aead, wrapped, []byte(l.id))
+}
+
+// EnvelopeHeader is the clear-text header preceding every envelope object.
+type EnvelopeHeader struct {
+	KeyID      string
+	WrappedKey []byte
+}
+
+// Envelope is a crypt.Crypter doing envelope encryption: every object gets
+// a fresh random data key, the payload is encrypted with it (chunked
+// AES-256-GCM), and the data key is stored in the object header wrapped by
+// the primary master key.
+//
+// Rotating the master key therefore only requires rewriting headers (see
+// Rewrap); the payload is never re-encrypted. Objects wrapped by older
+// master keys stay readable as long as their wrapper is passed in previous.
+type Envelope struct {
+	primary  KeyWrapper
+	wrappers map[string]KeyWrapper
+}
+
+var _ crypt.Crypter = (*Envelope)(nil)
+
+// NewEnvelope creates an Envelope writing with primary and able to read
+// objects wrapped by primary or any of previous.
+func NewEnvelope(primary KeyWrapper, previous ...KeyWrapper) *Envelope {
+	e := &Envelope{primary: primary, wrappers: make(map[string]KeyWrapper)}
+	for _, w := range previous {
+		e.wrappers[w.KeyID()] = w
+	}
+	e.wrappers[primary.KeyID()] = primary
+	return e
+}
+
+// FileExtension implements crypt.Crypter.
+func (e *Envelope) FileExtension() string {
+	return ".enc"
+}
+
+// Name implements crypt.Crypter.
+func (e *Envelope) Name() string {
+	return "envelope-aes-256-gcm"
+}
+
+// Encrypt implements crypt.Crypter.
+func (e *Envelope) Encrypt(out io.Writer) (io.WriteCloser, error) {
+	dataKey, wrapped, err := e.newDataKey(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteEnvelopeHeader(out, &EnvelopeHeader{KeyID: e.primary.KeyID(), WrappedKey: wrapped}); err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return NewStreamWriter(out, aead)
+}
+
+// newDataKey returns a fresh data key and its wrapped form.
+func (e *Envelope) newDataKey(ctx context.Context) ([]byte, []byte, error) {
+	if g, ok := e.primary.(DataKeyGenerator); ok {
+		dataKey, wrapped, err := g.GenerateDataKey(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("generate data key: %w", err)
+		}
+		if len(dataKey) != DataKeySize {
+			return nil, nil, fmt.Errorf("crypters: generated data key has %d bytes", len(dataKey))
+		}
+		return dataKey, wrapped, nil
+	}
+	dataKey := make([]byte, DataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := e.primary.WrapKey(ctx, dataKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("wrap data key: %w", err)
+	}
+	return dataKey, wrapped, nil
+}
+
+// Decrypt implements crypt.Crypter.
+func (e *Envelope) Decrypt(in io.Reader) (io.Reader, error) {
+	hdr, err := ReadEnvelopeHeader(in)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := e.unwrap(hdr)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return NewStreamReader(in, aead)
+}
+
+// Rewrap copies an envelope object from in to out, re-wrapping its data
+// key with the primary master key. The payload is copied unchanged.
+func (e *Envelope) Rewrap(ctx context.Context, in io.Reader, out io.Writer) error {
+	hdr, err := ReadEnvelopeHeader(in)
+	if err != nil {
+		return err
+	}
+	dataKey, err := e.unwrap(hdr)
+	if err != nil {
+		return err
+	}
+	wrapped, err := e.primary.WrapKey(ctx, dataKey)
+	if err != nil {
+		return fmt.Errorf("wrap data key: %w", err)
+	}
+	if err := WriteEnvelopeHeader(out, &EnvelopeHeader{KeyID: e.primary.KeyID(), WrappedKey: wrapped}); err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// PrimaryKeyID returns the id of the master key used for new objects.
+func (e *Envelope) PrimaryKeyID() string {
+	return e.primary.KeyID()
+}
+
+// KeyID is an alias of PrimaryKeyID, so that storage object headers can
+// record the master key.
+func (e *Envelope) KeyID() string {
+	return e.PrimaryKeyID()
+}
+
+func (e *Envelope) unwrap(hdr *EnvelopeHeader) ([]byte, error) {
+	w, ok := e.wrappers[hdr.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, hdr.KeyID)
+	}
+	dataKey, err := w.UnwrapKey(context.Background(), hdr.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key: %w", err)
+	}
+	if len(dataKey) != DataKeySize {
+		return nil, fmt.Errorf("crypters: unwrapped data key has %d bytes", len(dataKey))
+	}
+	return dataKey, nil
+}
+
+// WriteEnvelopeHeader writes hdr to w.
+func WriteEnvelopeHeader(w io.Writer, hdr *EnvelopeHeader) error {
+	if _, err := w.Write(envelopeMagic); err != nil {
+		return err
+	}
+	if err := writeField(w, []byte(hdr.KeyID)); err != nil {
+		return err
+	}
+	return writeField(w, hdr.WrappedKey)
+}
+
+// ReadEnvelopeHeader reads an envelope header from r, leaving r positioned
+// at the start of the payload.
+func ReadEnvelopeHeader(r io.Reader) (*EnvelopeHeader, error) {
+	if err := readMagic(r, envelopeMagic); err != nil {
+		return nil, fmt.Errorf("read envelope header: %w", err)
+	}
+	id, err := readField(r)
+	if err != nil {
+		return nil, fmt.Errorf("read envelope header: %w", err)
+	}
+	wrapped, err := readField(r)
+	if err != nil {
+		return nil, fmt.Errorf("read envelope header: %w", err)
+	}
+	return &EnvelopeHeader{KeyID: string(id), WrappedKey: wrapped}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
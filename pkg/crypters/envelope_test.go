@@ -0,0 +1,89 @@
+package crypters
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestWrapper(t *testing.T, id string) *LocalKeyWrapper {
+	t.Helper()
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	w, err := NewLocalKeyWrapper(id, key)
+	require.NoError(t, err)
+	return w
+}
+
+func encrypt(t *testing.T, e crypt.Crypter, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := e.Encrypt(&buf)
+	require.NoError(t, err)
+	_, err = w.Write(plain)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func decrypt(e crypt.Crypter, sealed []byte) ([]byte, error) {
+	r, err := e.Decrypt(bytes.NewReader(sealed))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestEnvelope_RotateWithoutReencrypting(t *testing.T) {
+	oldKey := newTestWrapper(t, "old")
+	newKey := newTestWrapper(t, "new")
+	plain := []byte("per-object data key")
+
+	sealed := encrypt(t, NewEnvelope(oldKey), plain)
+
+	rotated := NewEnvelope(newKey, oldKey)
+	got, err := decrypt(rotated, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, plain, got)
+
+	var out bytes.Buffer
+	require.NoError(t, rotated.Rewrap(context.Background(), bytes.NewReader(sealed), &out))
+	hdr, err := ReadEnvelopeHeader(bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, "new", hdr.KeyID)
+
+	// The old master key is no longer needed.
+	got, err = decrypt(NewEnvelope(newKey), out.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, plain, got)
+
+	_, err = decrypt(NewEnvelope(newKey), sealed)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestEnvelope_FreshDataKeyPerObject(t *testing.T) {
+	e := NewEnvelope(newTestWrapper(t, ""))
+	a := encrypt(t, e, []byte("same"))
+	b := encrypt(t, e, []byte("same"))
+	assert.NotEqual(t, a, b)
+}
+
+func TestNewLocalKeyWrapperFromFile(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "master.key")
+	require.NoError(t, os.WriteFile(p, []byte("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff\n"), 0o600))
+	w, err := NewLocalKeyWrapperFromFile("", p)
+	require.NoError(t, err)
+	assert.Contains(t, w.KeyID(), "local:")
+
+	require.NoError(t, os.WriteFile(p, []byte("short"), 0o600))
+	_, err = NewLocalKeyWrapperFromFile("", p)
+	assert.Error(t, err)
+}
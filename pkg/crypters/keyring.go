@@ -0,0 +1,129 @@
+package crypters
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+)
+
+// Keyring is a crypt.Crypter that encrypts with a primary crypter and
+// decrypts with whichever of its crypters authenticates the object. It is
+// meant for key rotation: put the new key first and keep the old ones
+// around until every archive has been re-encrypted.
+//
+// Candidates are tried in order; the first one that yields plaintext
+// without error wins. The bytes consumed by failed attempts are buffered
+// and replayed, so the source is read only once. Only crypters that
+// authenticate their input (e.g. AES-GCM) can be told apart this way.
+type Keyring struct {
+	crypters []crypt.Crypter
+}
+
+var _ crypt.Crypter = (*Keyring)(nil)
+
+// NewKeyring creates a Keyring writing with primary and reading with
+// primary or any of previous.
+func NewKeyring(primary crypt.Crypter, previous ...crypt.Crypter) *Keyring {
+	return &Keyring{crypters: append([]crypt.Crypter{primary}, previous...)}
+}
+
+// FileExtension implements crypt.Crypter; it is the primary's extension.
+func (k *Keyring) FileExtension() string {
+	return k.crypters[0].FileExtension()
+}
+
+// Name implements crypt.Crypter; it is the primary's name.
+func (k *Keyring) Name() string {
+	return k.crypters[0].Name()
+}
+
+// KeyID returns the primary crypter's key id, if it exposes one.
+func (k *Keyring) KeyID() string {
+	if id, ok := k.crypters[0].(interface{ KeyID() string }); ok {
+		return id.KeyID()
+	}
+	return ""
+}
+
+// Encrypt implements crypt.Crypter using the primary crypter.
+func (k *Keyring) Encrypt(out io.Writer) (io.WriteCloser, error) {
+	return k.crypters[0].Encrypt(out)
+}
+
+// Decrypt implements crypt.Crypter, trying every crypter in order.
+func (k *Keyring) Decrypt(in io.Reader) (io.Reader, error) {
+	rr := &replayReader{src: in, recording: true}
+	var errs []error
+	for i, c := range k.crypters {
+		rr.rewind()
+		dr, err := c.Decrypt(rr)
+		if err == nil {
+			var first []byte
+			first, err = readFirst(dr)
+			if err == nil {
+				rr.stopRecording()
+				return io.MultiReader(bytes.NewReader(first), dr), nil
+			}
+		}
+		errs = append(errs, fmt.Errorf("key %d: %w", i, err))
+	}
+	return nil, fmt.Errorf("no key could decrypt the object: %w", errors.Join(errs...))
+}
+
+// readFirst reads until the first plaintext byte, EOF or error, so that an
+// authentication failure of the first chunk surfaces here.
+func readFirst(r io.Reader) ([]byte, error) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			return buf[:n], nil
+		}
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// replayReader records what it reads from src so that it can be rewound
+// and read again, until recording is stopped.
+type replayReader struct {
+	src       io.Reader
+	buf       []byte
+	pos       int
+	recording bool
+}
+
+func (r *replayReader) Read(p []byte) (int, error) {
+	if r.pos < len(r.buf) {
+		n := copy(p, r.buf[r.pos:])
+		r.pos += n
+		if !r.recording && r.pos == len(r.buf) {
+			r.buf, r.pos = nil, 0
+		}
+		return n, nil
+	}
+	n, err := r.src.Read(p)
+	if r.recording && n > 0 {
+		r.buf = append(r.buf, p[:n]...)
+		r.pos += n
+	}
+	return n, err
+}
+
+func (r *replayReader) rewind() {
+	r.pos = 0
+}
+
+func (r *replayReader) stopRecording() {
+	r.recording = false
+	if r.pos == len(r.buf) {
+		r.buf, r.pos = nil, 0
+	}
+}
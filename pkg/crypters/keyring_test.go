@@ -0,0 +1,52 @@
+package crypters
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyring_DecryptsWithAnyKey(t *testing.T) {
+	// Same key id, different master keys: only authentication tells them
+	// apart.
+	oldKey := NewEnvelope(newTestWrapper(t, "k"))
+	newKey := NewEnvelope(newTestWrapper(t, "k"))
+
+	big := make([]byte, 3*ChunkSize)
+	_, _ = rand.Read(big)
+	oldObj := encrypt(t, oldKey, big)
+	newObj := encrypt(t, newKey, []byte("new"))
+
+	kr := NewKeyring(newKey, oldKey)
+	for _, tc := range []struct {
+		sealed []byte
+		want   []byte
+	}{
+		{oldObj, big},
+		{newObj, []byte("new")},
+		{encrypt(t, oldKey, nil), []byte{}},
+	} {
+		r, err := kr.Decrypt(bytes.NewReader(tc.sealed))
+		require.NoError(t, err)
+		got, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, got)
+	}
+
+	// Writes use the primary key.
+	var buf bytes.Buffer
+	w, err := kr.Encrypt(&buf)
+	require.NoError(t, err)
+	_, _ = w.Write([]byte("x"))
+	require.NoError(t, w.Close())
+	got, err := decrypt(newKey, buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, []byte("x"), got)
+
+	_, err = NewKeyring(newKey).Decrypt(bytes.NewReader(oldObj))
+	assert.ErrorIs(t, err, ErrAuthentication)
+}
@@ -0,0 +1,80 @@
+package crypters
+
+import (
+	"context"
+	"fmt"
+	"maps"
+)
+
+// KMSClient is the part of a key management service KMSKeyWrapper needs.
+// clients.NewAWSKMSClient implements it for AWS KMS.
+type KMSClient interface {
+	// GenerateDataKey returns a new 256-bit data key in clear and
+	// encrypted under keyID.
+	GenerateDataKey(ctx context.Context, keyID string, encryptionContext map[string]string) (plaintext, ciphertext []byte, err error)
+
+	// Encrypt encrypts a small plaintext under keyID.
+	Encrypt(ctx context.Context, keyID string, plaintext []byte, encryptionContext map[string]string) ([]byte, error)
+
+	// Decrypt decrypts a ciphertext produced under keyID.
+	Decrypt(ctx context.Context, keyID string, ciphertext []byte, encryptionContext map[string]string) ([]byte, error)
+}
+
+// KMSKeyWrapper is a KeyWrapper whose master key lives in a KMS: data keys
+// are generated and unwrapped by the service, so restoring an object
+// requires permission to call Decrypt on the key, and never exposes it.
+//
+// Use it as the primary wrapper of an Envelope:
+//
+//	env := crypters.NewEnvelope(crypters.NewKMSKeyWrapper(client, "alias/backups", nil))
+type KMSKeyWrapper struct {
+	client     KMSClient
+	keyID      string
+	encryption map[string]string
+}
+
+var (
+	_ KeyWrapper       = (*KMSKeyWrapper)(nil)
+	_ DataKeyGenerator = (*KMSKeyWrapper)(nil)
+)
+
+// NewKMSKeyWrapper creates a wrapper for the KMS key keyID (id, ARN or
+// alias). encryptionContext, if not empty, is bound to every data key and
+// must be presented again to unwrap it; it can be used in key policies.
+func NewKMSKeyWrapper(client KMSClient, keyID string, encryptionContext map[string]string) *KMSKeyWrapper {
+	return &KMSKeyWrapper{client: client, keyID: keyID, encryption: maps.Clone(encryptionContext)}
+}
+
+// KeyID implements KeyWrapper. It is the configured key id prefixed with
+// "kms:", so that an alias stays valid across key rotations in KMS.
+func (k *KMSKeyWrapper) KeyID() string {
+	return "kms:" + k.keyID
+}
+
+// GenerateDataKey implements DataKeyGenerator.
+func (k *KMSKeyWrapper) GenerateDataKey(ctx context.Context) ([]byte, []byte, error) {
+	plain, wrapped, err := k.client.GenerateDataKey(ctx, k.keyID, k.encryption)
+	if err != nil {
+		return nil, nil, fmt.Errorf("kms generate data key: %w", err)
+	}
+	return plain, wrapped, nil
+}
+
+// WrapKey implements KeyWrapper; it is used when re-wrapping existing
+// objects under this key.
+func (k *KMSKeyWrapper) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	wrapped, err := k.client.Encrypt(ctx, k.keyID, dataKey, k.encryption)
+	if err != nil {
+		return nil, fmt.Errorf("kms encrypt: %w", err)
+	}
+	return wrapped, nil
+}
+
+// UnwrapKey implements KeyWrapper.
+func (k *KMSKeyWrapper) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	dataKey, err := k.client.Decrypt(ctx, k.keyID, wrapped, k.encryption)
+	if err != nil {
+		return nil, fmt.Errorf("kms decrypt: %w", err)
+	}
+	return dataKey, nil
+}
@@ -0,0 +1,114 @@
+package crypters
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKMS seals data keys with a per-key-id AES-GCM key, binding the
+// encryption context as additional data, and counts calls.
+type fakeKMS struct {
+	keys  map[string]cipher.AEAD
+	calls map[string]int
+}
+
+func newFakeKMS(keyIDs ...string) *fakeKMS {
+	f := &fakeKMS{keys: map[string]cipher.AEAD{}, calls: map[string]int{}}
+	for _, id := range keyIDs {
+		k := make([]byte, 32)
+		_, _ = rand.Read(k)
+		aead, err := newGCM(k)
+		if err != nil {
+			panic(err)
+		}
+		f.keys[id] = aead
+	}
+	return f
+}
+
+func (f *fakeKMS) seal(keyID string, plain []byte, encCtx map[string]string) ([]byte, error) {
+	key, ok := f.keys[keyID]
+	if !ok {
+		return nil, errors.New("NotFoundException")
+	}
+	ad, _ := json.Marshal(encCtx)
+	return sealSmall(key, plain, ad)
+}
+
+func (f *fakeKMS) GenerateDataKey(_ context.Context, keyID string, encCtx map[string]string) ([]byte, []byte, error) {
+	f.calls["GenerateDataKey"]++
+	plain := make([]byte, DataKeySize)
+	_, _ = rand.Read(plain)
+	sealed, err := f.seal(keyID, plain, encCtx)
+	return plain, sealed, err
+}
+
+func (f *fakeKMS) Encrypt(_ context.Context, keyID string, plain []byte, encCtx map[string]string) ([]byte, error) {
+	f.calls["Encrypt"]++
+	return f.seal(keyID, plain, encCtx)
+}
+
+func (f *fakeKMS) Decrypt(_ context.Context, keyID string, sealed []byte, encCtx map[string]string) ([]byte, error) {
+	f.calls["Decrypt"]++
+	key, ok := f.keys[keyID]
+	if !ok {
+		return nil, errors.New("NotFoundException")
+	}
+	ad, _ := json.Marshal(encCtx)
+	return openSmall(key, sealed, ad)
+}
+
+func TestKMSKeyWrapper_Envelope(t *testing.T) {
+	kms := newFakeKMS("alias/backups")
+	encCtx := map[string]string{"app": "storecrypt"}
+	env := NewEnvelope(NewKMSKeyWrapper(kms, "alias/backups", encCtx))
+	plain := []byte("restores are gated by kms:Decrypt")
+
+	sealed := encrypt(t, env, plain)
+	assert.Equal(t, 1, kms.calls["GenerateDataKey"])
+	assert.Zero(t, kms.calls["Encrypt"])
+
+	hdr, err := ReadEnvelopeHeader(bytes.NewReader(sealed))
+	require.NoError(t, err)
+	assert.Equal(t, "kms:alias/backups", hdr.KeyID)
+
+	got, err := decrypt(env, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, plain, got)
+	assert.Equal(t, 1, kms.calls["Decrypt"])
+
+	// The encryption context must match.
+	other := NewEnvelope(NewKMSKeyWrapper(kms, "alias/backups", map[string]string{"app": "other"}))
+	_, err = decrypt(other, sealed)
+	assert.Error(t, err)
+}
+
+func TestKMSKeyWrapper_RewrapFromLocal(t *testing.T) {
+	local := newTestWrapper(t, "local")
+	sealed := encrypt(t, NewEnvelope(local), []byte("migrate to kms"))
+
+	kms := newFakeKMS("key-1")
+	env := NewEnvelope(NewKMSKeyWrapper(kms, "key-1", nil), local)
+
+	var out bytes.Buffer
+	require.NoError(t, env.Rewrap(context.Background(), bytes.NewReader(sealed), &out))
+	assert.Equal(t, 1, kms.calls["Encrypt"])
+
+	got, err := decrypt(NewEnvelope(NewKMSKeyWrapper(kms, "key-1", nil)), out.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, "migrate to kms", string(got))
+}
+
+func TestKMSKeyWrapper_Errors(t *testing.T) {
+	env := NewEnvelope(NewKMSKeyWrapper(newFakeKMS(), "missing", nil))
+	_, err := env.Encrypt(&bytes.Buffer{})
+	assert.ErrorContains(t, err, "NotFoundException")
+}
@@ -0,0 +1,296 @@
+package crypters
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+var passwordMagic = []byte("SCPW1")
+
+// KDFAlgorithm selects the password-to-key derivation function.
+type KDFAlgorithm uint8
+
+const (
+	KDFArgon2id KDFAlgorithm = 1
+	KDFScrypt   KDFAlgorithm = 2
+)
+
+func (a KDFAlgorithm) String() string {
+	switch a {
+	case KDFArgon2id:
+		return "argon2id"
+	case KDFScrypt:
+		return "scrypt"
+	default:
+		return fmt.Sprintf("kdf(%d)", uint8(a))
+	}
+}
+
+// KDFParams are the password derivation parameters of a PasswordAES.
+// They are recorded in every object, so they can be raised at any time:
+// old objects keep decrypting with the parameters they were written with.
+type KDFParams struct {
+	Algorithm KDFAlgorithm
+
+	// For Argon2id: Memory in KiB, Iterations passes over it and
+	// Parallelism lanes. For scrypt: N = 2^Iterations, r = Memory and
+	// p = Parallelism.
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+
+	// SaltSize is the length of the random salt, 16 bytes if zero.
+	SaltSize int
+
+	// SaltPerObject draws a fresh salt, and so runs the KDF, for every
+	// object. By default a salt is drawn once per crypter and the key is
+	// derived once, at construction.
+	SaltPerObject bool
+}
+
+// DefaultKDFParams are Argon2id with 64 MiB, 3 passes and 4 lanes
+// (RFC 9106, second recommended option).
+var DefaultKDFParams = KDFParams{
+	Algorithm:   KDFArgon2id,
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 4,
+}
+
+// Bounds on parameters accepted from object headers, so that a crafted
+// object cannot make decryption allocate without limit.
+const (
+	maxKDFMemory     = 4 << 20 // KiB, i.e. 4 GiB
+	maxKDFIterations = 64
+	maxKDFSaltSize   = 64
+)
+
+func (p KDFParams) String() string {
+	return fmt.Sprintf("%s:m=%d,t=%d,p=%d", p.Algorithm, p.Memory, p.Iterations, p.Parallelism)
+}
+
+func (p KDFParams) validate() error {
+	if p.Parallelism == 0 || p.Iterations == 0 || p.Memory == 0 {
+		return fmt.Errorf("crypters: invalid KDF parameters %s", p)
+	}
+	switch p.Algorithm {
+	case KDFArgon2id:
+		if p.Memory > maxKDFMemory || p.Iterations > maxKDFIterations {
+			return fmt.Errorf("crypters: KDF parameters %s exceed limits", p)
+		}
+	case KDFScrypt:
+		// scrypt needs 128*N*r bytes.
+		if p.Iterations < 2 || p.Iterations > 30 || p.Memory > 64 || uint64(128)<<p.Iterations*uint64(p.Memory) > maxKDFMemory*1024 {
+			return fmt.Errorf("crypters: KDF parameters %s exceed limits", p)
+		}
+	default:
+		return fmt.Errorf("crypters: unknown KDF algorithm %s", p.Algorithm)
+	}
+	return nil
+}
+
+func (p KDFParams) deriveKey(password, salt []byte) ([]byte, error) {
+	switch p.Algorithm {
+	case KDFArgon2id:
+		return argon2.IDKey(password, salt, p.Iterations, p.Memory, p.Parallelism, DataKeySize), nil
+	case KDFScrypt:
+		return scrypt.Key(password, salt, 1<<p.Iterations, int(p.Memory), int(p.Parallelism), DataKeySize)
+	default:
+		return nil, fmt.Errorf("crypters: unknown KDF algorithm %s", p.Algorithm)
+	}
+}
+
+// PasswordAES is a password-based AES-256-GCM crypt.Crypter with
+// configurable key derivation. Each object starts with
+//
+//	"SCPW1" | algorithm u8 | memory u32 | iterations u32 | parallelism u8 | salt
+//
+// (salt u16-length-prefixed, big endian) followed by the chunked stream
+// of NewStreamWriter. It uses the ".aes" extension and can take over from
+// aesgcm.NewChunkedGCMCrypter: set Legacy to that crypter and objects
+// without the header are handed to it.
+type PasswordAES struct {
+	// Legacy, if set, decrypts objects that lack the PasswordAES header.
+	Legacy crypt.Crypter
+
+	password []byte
+	params   KDFParams
+	header   []byte // encoded header for salt, nil with SaltPerObject
+	key      []byte // derived from salt, nil with SaltPerObject
+
+	mu   sync.Mutex
+	keys map[[sha256.Size]byte][]byte // decryption keys by header digest
+}
+
+var _ crypt.Crypter = (*PasswordAES)(nil)
+
+// maxCachedPasswordKeys bounds the decryption key cache; archives rarely
+// contain more than a handful of salt/parameter combinations.
+const maxCachedPasswordKeys = 64
+
+// NewPasswordAES creates the crypter. Unless params.SaltPerObject is set,
+// the key is derived here, once.
+func NewPasswordAES(password string, params KDFParams) (*PasswordAES, error) {
+	if password == "" {
+		return nil, errors.New("crypters: empty password")
+	}
+	if params.SaltSize == 0 {
+		params.SaltSize = 16
+	}
+	if params.SaltSize < 8 || params.SaltSize > maxKDFSaltSize {
+		return nil, fmt.Errorf("crypters: salt size %d out of range", params.SaltSize)
+	}
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	p := &PasswordAES{
+		password: []byte(password),
+		params:   params,
+		keys:     make(map[[sha256.Size]byte][]byte),
+	}
+	if !params.SaltPerObject {
+		salt, key, err := p.newKey()
+		if err != nil {
+			return nil, err
+		}
+		p.header, p.key = encodePasswordHeader(params, salt), key
+	}
+	return p, nil
+}
+
+// FileExtension implements crypt.Crypter.
+func (p *PasswordAES) FileExtension() string {
+	return ".aes"
+}
+
+// Name implements crypt.Crypter.
+func (p *PasswordAES) Name() string {
+	return "password-aes-256-gcm"
+}
+
+// KeyID reports the KDF parameters new objects are written with, so that
+// storage object headers record them.
+func (p *PasswordAES) KeyID() string {
+	return p.params.String()
+}
+
+func (p *PasswordAES) newKey() ([]byte, []byte, error) {
+	salt := make([]byte, p.params.SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, err
+	}
+	key, err := p.params.deriveKey(p.password, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	return salt, key, nil
+}
+
+// Encrypt implements crypt.Crypter.
+func (p *PasswordAES) Encrypt(out io.Writer) (io.WriteCloser, error) {
+	header, key := p.header, p.key
+	if key == nil {
+		salt, k, err := p.newKey()
+		if err != nil {
+			return nil, err
+		}
+		header, key = encodePasswordHeader(p.params, salt), k
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := out.Write(header); err != nil {
+		return nil, err
+	}
+	return NewStreamWriter(out, aead)
+}
+
+// Decrypt implements crypt.Crypter.
+func (p *PasswordAES) Decrypt(in io.Reader) (io.Reader, error) {
+	magic := make([]byte, len(passwordMagic))
+	n, err := io.ReadFull(in, magic)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if !bytes.Equal(magic[:n], passwordMagic) {
+		if p.Legacy == nil {
+			return nil, fmt.Errorf("crypters: bad magic %q, want %q", magic[:n], passwordMagic)
+		}
+		return p.Legacy.Decrypt(io.MultiReader(bytes.NewReader(magic[:n]), in))
+	}
+
+	var fixed [1 + 4 + 4 + 1]byte
+	if _, err := io.ReadFull(in, fixed[:]); err != nil {
+		return nil, fmt.Errorf("read password header: %w", noEOF(err))
+	}
+	params := KDFParams{
+		Algorithm:   KDFAlgorithm(fixed[0]),
+		Memory:      binary.BigEndian.Uint32(fixed[1:5]),
+		Iterations:  binary.BigEndian.Uint32(fixed[5:9]),
+		Parallelism: fixed[9],
+	}
+	salt, err := readField(in)
+	if err != nil {
+		return nil, fmt.Errorf("read password header: %w", err)
+	}
+	if len(salt) > maxKDFSaltSize {
+		return nil, fmt.Errorf("crypters: salt size %d out of range", len(salt))
+	}
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	key, err := p.decryptionKey(params, salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return NewStreamReader(in, aead)
+}
+
+// decryptionKey derives (or recalls) the key for an object header.
+func (p *PasswordAES) decryptionKey(params KDFParams, salt []byte) ([]byte, error) {
+	header := encodePasswordHeader(params, salt)
+	if p.key != nil && bytes.Equal(header, p.header) {
+		return p.key, nil
+	}
+	id := sha256.Sum256(header)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[id]; ok {
+		return key, nil
+	}
+	key, err := params.deriveKey(p.password, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.keys) >= maxCachedPasswordKeys {
+		clear(p.keys)
+	}
+	p.keys[id] = key
+	return key, nil
+}
+
+func encodePasswordHeader(params KDFParams, salt []byte) []byte {
+	b := make([]byte, 0, len(passwordMagic)+10+2+len(salt))
+	b = append(b, passwordMagic...)
+	b = append(b, byte(params.Algorithm))
+	b = binary.BigEndian.AppendUint32(b, params.Memory)
+	b = binary.BigEndian.AppendUint32(b, params.Iterations)
+	b = append(b, params.Parallelism)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(salt)))
+	return append(b, salt...)
+}
@@ -0,0 +1,95 @@
+package crypters
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/aesgcm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testKDFParams = KDFParams{Algorithm: KDFArgon2id, Memory: 1024, Iterations: 1, Parallelism: 1}
+
+func TestPasswordAES_RoundTrip(t *testing.T) {
+	for _, params := range []KDFParams{
+		testKDFParams,
+		{Algorithm: KDFScrypt, Memory: 8, Iterations: 10, Parallelism: 1},
+		{Algorithm: KDFArgon2id, Memory: 1024, Iterations: 1, Parallelism: 2, SaltSize: 32, SaltPerObject: true},
+	} {
+		t.Run(params.String(), func(t *testing.T) {
+			p, err := NewPasswordAES("correct horse", params)
+			require.NoError(t, err)
+			plain := bytes.Repeat([]byte("kdf "), 40000)
+			got, err := decrypt(p, encrypt(t, p, plain))
+			require.NoError(t, err)
+			assert.Equal(t, plain, got)
+
+			other, err := NewPasswordAES("wrong horse", params)
+			require.NoError(t, err)
+			_, err = decrypt(other, encrypt(t, p, plain))
+			assert.ErrorIs(t, err, ErrAuthentication)
+		})
+	}
+}
+
+func TestPasswordAES_SaltHandling(t *testing.T) {
+	perCrypter, err := NewPasswordAES("pw", testKDFParams)
+	require.NoError(t, err)
+	a, b := encrypt(t, perCrypter, []byte("x")), encrypt(t, perCrypter, []byte("x"))
+	hdrLen := len(passwordMagic) + 10 + 2 + 16
+	assert.Equal(t, a[:hdrLen], b[:hdrLen])
+
+	params := testKDFParams
+	params.SaltPerObject = true
+	perObject, err := NewPasswordAES("pw", params)
+	require.NoError(t, err)
+	a, b = encrypt(t, perObject, []byte("x")), encrypt(t, perObject, []byte("x"))
+	assert.NotEqual(t, a[:hdrLen], b[:hdrLen])
+}
+
+func TestPasswordAES_StrengthenedParams(t *testing.T) {
+	weak, err := NewPasswordAES("pw", testKDFParams)
+	require.NoError(t, err)
+	old := encrypt(t, weak, []byte("written years ago"))
+
+	stronger := testKDFParams
+	stronger.Memory, stronger.Iterations = 4096, 2
+	strong, err := NewPasswordAES("pw", stronger)
+	require.NoError(t, err)
+	assert.Equal(t, "argon2id:m=4096,t=2,p=1", strong.KeyID())
+
+	got, err := decrypt(strong, old)
+	require.NoError(t, err)
+	assert.Equal(t, "written years ago", string(got))
+}
+
+func TestPasswordAES_Legacy(t *testing.T) {
+	legacy := aesgcm.NewChunkedGCMCrypter("pw")
+	old := encrypt(t, legacy, []byte("streamcrypt object"))
+
+	p, err := NewPasswordAES("pw", testKDFParams)
+	require.NoError(t, err)
+	_, err = decrypt(p, old)
+	assert.Error(t, err)
+
+	p.Legacy = legacy
+	got, err := decrypt(p, old)
+	require.NoError(t, err)
+	assert.Equal(t, "streamcrypt object", string(got))
+}
+
+func TestPasswordAES_RejectsHostileParams(t *testing.T) {
+	p, err := NewPasswordAES("pw", testKDFParams)
+	require.NoError(t, err)
+	sealed := encrypt(t, p, []byte("x"))
+	// Raise the recorded memory to 2^32-1 KiB.
+	copy(sealed[len(passwordMagic)+1:], []byte{0xff, 0xff, 0xff, 0xff})
+	_, err = decrypt(p, sealed)
+	assert.ErrorContains(t, err, "exceed limits")
+
+	_, err = NewPasswordAES("pw", KDFParams{Algorithm: KDFScrypt, Memory: 8, Iterations: 1, Parallelism: 1})
+	assert.Error(t, err)
+	_, err = NewPasswordAES("", DefaultKDFParams)
+	assert.Error(t, err)
+}
@@ -0,0 +1,281 @@
+package crypters
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ChunkSize is the plaintext size of every chunk but the last one.
+const ChunkSize = 64 * 1024
+
+// ErrAuthentication is returned when a chunk fails to authenticate, or the
+// stream was truncated/extended.
+var ErrAuthentication = errors.New("crypters: message authentication failed")
+
+// The stream format is a STREAM-style chunked AEAD construction:
+//
+//	nonce prefix (NonceSize-5 random bytes)
+//	chunk_0 || chunk_1 || ... || chunk_n
+//
+// Every chunk is AEAD-sealed with nonce = prefix || uint32 counter || last
+// flag, so chunks cannot be reordered, dropped, or the stream cut short
+// without the reader noticing. The last chunk (possibly empty) carries the
+// flag 1; all others carry 0.
+
+// NewStreamWriter returns a writer that seals everything written to it
+// into w. Close must be called to write the final chunk; it does not close w.
+func NewStreamWriter(w io.Writer, aead cipher.AEAD) (io.WriteCloser, error) {
+	prefix := make([]byte, aead.NonceSize()-5)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(prefix); err != nil {
+		return nil, err
+	}
+	return newStreamWriter(w, aead, prefix), nil
+}
+
+// newStreamWriter seals into w with the given nonce prefix, which the
+// caller is responsible for transmitting (or deriving) itself.
+func newStreamWriter(w io.Writer, aead cipher.AEAD, prefix []byte) *streamWriter {
+	return &streamWriter{
+		w:      w,
+		aead:   aead,
+		prefix: prefix,
+		buf:    make([]byte, 0, ChunkSize),
+		out:    make([]byte, 0, ChunkSize+aead.Overhead()),
+	}
+}
+
+// NewStreamReader returns a reader that opens a stream produced by
+// NewStreamWriter. Authentication failures surface as ErrAuthentication.
+func NewStreamReader(r io.Reader, aead cipher.AEAD) (io.Reader, error) {
+	prefix := make([]byte, aead.NonceSize()-5)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, fmt.Errorf("read stream header: %w", noEOF(err))
+	}
+	return newStreamReader(r, aead, prefix), nil
+}
+
+// newStreamReader opens a stream sealed by newStreamWriter with prefix.
+func newStreamReader(r io.Reader, aead cipher.AEAD, prefix []byte) *streamReader {
+	return &streamReader{
+		r:      r,
+		aead:   aead,
+		prefix: prefix,
+		in:     make([]byte, ChunkSize+aead.Overhead()+1),
+	}
+}
+
+func streamNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, len(prefix)+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[len(prefix):], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+type streamWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	out     []byte
+	closed  bool
+	err     error
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	if s.closed {
+		return 0, errors.New("crypters: write to closed stream")
+	}
+	written := 0
+	for len(p) > 0 {
+		// Only seal a full chunk once we know more data follows, so that
+		// the final chunk is always the one sealed by Close.
+		if len(s.buf) == ChunkSize {
+			if err := s.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(s.buf[len(s.buf):ChunkSize], p)
+		s.buf = s.buf[:len(s.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (s *streamWriter) flush(last bool) error {
+	if !last && s.counter == ^uint32(0) {
+		s.err = errors.New("crypters: stream too long")
+		return s.err
+	}
+	s.out = s.aead.Seal(s.out[:0], streamNonce(s.prefix, s.counter, last), s.buf, nil)
+	if _, err := s.w.Write(s.out); err != nil {
+		s.err = err
+		return err
+	}
+	s.counter++
+	s.buf = s.buf[:0]
+	return nil
+}
+
+func (s *streamWriter) Close() error {
+	if s.closed {
+		return s.err
+	}
+	s.closed = true
+	if s.err != nil {
+		return s.err
+	}
+	return s.flush(true)
+}
+
+type streamReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	in      []byte // one sealed chunk plus one byte of lookahead
+	inLen   int
+	plain   []byte
+	done    bool
+	err     error
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	for len(s.plain) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.done {
+			return 0, io.EOF
+		}
+		if err := s.next(); err != nil {
+			s.err = err
+			return 0, err
+		}
+	}
+	n := copy(p, s.plain)
+	s.plain = s.plain[n:]
+	return n, nil
+}
+
+func (s *streamReader) next() error {
+	sealed := ChunkSize + s.aead.Overhead()
+
+	// Fill up to one sealed chunk plus one lookahead byte.
+	n, err := io.ReadFull(s.r, s.in[s.inLen:])
+	s.inLen += n
+	switch {
+	case err == nil:
+	case errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF):
+	default:
+		return err
+	}
+
+	last := s.inLen <= sealed
+	chunkLen := s.inLen
+	if !last {
+		chunkLen = sealed
+	}
+	if chunkLen < s.aead.Overhead() {
+		return ErrAuthentication
+	}
+
+	plain, err := s.aead.Open(nil, streamNonce(s.prefix, s.counter, last), s.in[:chunkLen], nil)
+	if err != nil {
+		return ErrAuthentication
+	}
+	if !last && len(plain) == 0 {
+		return ErrAuthentication
+	}
+	s.counter++
+	s.plain = plain
+	s.done = last
+
+	// Keep the lookahead byte for the next chunk.
+	s.inLen = copy(s.in, s.in[chunkLen:s.inLen])
+	return nil
+}
+
+func noEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// sealSmall seals a short message with a random nonce (nonce || ciphertext).
+// Used for wrapping data keys.
+func sealSmall(aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+// openSmall reverses sealSmall.
+func openSmall(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrAuthentication
+	}
+	nonce, ct := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ct, additional)
+	if err != nil {
+		return nil, ErrAuthentication
+	}
+	return plain, nil
+}
+
+// writeField writes a uint16 length-prefixed byte field.
+func writeField(w io.Writer, b []byte) error {
+	if len(b) > 0xffff {
+		return fmt.Errorf("crypters: header field too long (%d bytes)", len(b))
+	}
+	var l [2]byte
+	binary.BigEndian.PutUint16(l[:], uint16(len(b)))
+	if _, err := w.Write(l[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// readField reads a uint16 length-prefixed byte field.
+func readField(r io.Reader) ([]byte, error) {
+	var l [2]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, noEOF(err)
+	}
+	b := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, noEOF(err)
+	}
+	return b, nil
+}
+
+// readMagic consumes and checks a fixed magic prefix.
+func readMagic(r io.Reader, magic []byte) error {
+	got := make([]byte, len(magic))
+	if _, err := io.ReadFull(r, got); err != nil {
+		return noEOF(err)
+	}
+	if !bytes.Equal(got, magic) {
+		return fmt.Errorf("crypters: bad magic %q, want %q", got, magic)
+	}
+	return nil
+}
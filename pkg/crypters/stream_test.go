@@ -0,0 +1,68 @@
+package crypters
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func seal(t *testing.T, key, plain []byte) []byte {
+	t.Helper()
+	aead, err := newGCM(key)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	w, err := NewStreamWriter(&buf, aead)
+	require.NoError(t, err)
+	_, err = w.Write(plain)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func open(key, sealed []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewStreamReader(bytes.NewReader(sealed), aead)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestStream_RoundTrip(t *testing.T) {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+
+	for _, size := range []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3*ChunkSize + 17} {
+		plain := make([]byte, size)
+		_, _ = rand.Read(plain)
+		got, err := open(key, seal(t, key, plain))
+		require.NoError(t, err, "size %d", size)
+		assert.Equal(t, plain, got, "size %d", size)
+	}
+}
+
+func TestStream_DetectsTampering(t *testing.T) {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	plain := make([]byte, 2*ChunkSize+100)
+	sealed := seal(t, key, plain)
+
+	flipped := append([]byte(nil), sealed...)
+	flipped[len(flipped)/2] ^= 1
+	_, err := open(key, flipped)
+	assert.ErrorIs(t, err, ErrAuthentication)
+
+	// Cutting the stream at a chunk boundary must not look like a
+	// shorter, valid stream.
+	aead, _ := newGCM(key)
+	cut := sealed[:aead.NonceSize()-5+ChunkSize+aead.Overhead()]
+	_, err = open(key, cut)
+	assert.ErrorIs(t, err, ErrAuthentication)
+}
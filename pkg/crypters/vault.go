@@ -0,0 +1,266 @@
+package crypters
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VaultConfig configures a VaultTransit key wrapper.
+type VaultConfig struct {
+	// Address of the Vault server, e.g. "https://vault.example.com:8200".
+	Address string
+
+	// Token used to authenticate. It is renewed automatically while it
+	// is renewable, unless DisableRenewal is set.
+	Token string
+
+	// Namespace is the Vault Enterprise namespace, "" for the root one.
+	Namespace string
+
+	// Mount is the path the transit engine is mounted at ("transit" if
+	// empty) and Key the name of the transit key.
+	Mount string
+	Key   string
+
+	// Context is the key derivation context, required for transit keys
+	// created with derived=true.
+	Context []byte
+
+	// DisableRenewal turns off token renewal, e.g. when an agent
+	// renews the token on the caller's behalf.
+	DisableRenewal bool
+
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// VaultTransit is a KeyWrapper backed by a HashiCorp Vault transit key:
+// data keys are generated and unwrapped by Vault, so the master key never
+// leaves it and restores require the transit decrypt capability.
+//
+//	w, err := crypters.NewVaultTransit(crypters.VaultConfig{Address: addr, Token: token, Key: "backups"})
+//	env := crypters.NewEnvelope(w)
+type VaultTransit struct {
+	cfg    VaultConfig
+	client *http.Client
+	now    func() time.Time
+
+	mu        sync.Mutex
+	checked   bool // token lookup done
+	renewable bool // token can be renewed
+	ttl       time.Duration
+	expires   time.Time // zero for tokens that do not expire
+}
+
+var (
+	_ KeyWrapper       = (*VaultTransit)(nil)
+	_ DataKeyGenerator = (*VaultTransit)(nil)
+)
+
+// NewVaultTransit creates a key wrapper for the transit key cfg.Key.
+func NewVaultTransit(cfg VaultConfig) (*VaultTransit, error) {
+	if cfg.Address == "" || cfg.Token == "" || cfg.Key == "" {
+		return nil, errors.New("crypters: vault address, token and key are required")
+	}
+	if _, err := url.Parse(cfg.Address); err != nil {
+		return nil, fmt.Errorf("crypters: vault address: %w", err)
+	}
+	cfg.Address = strings.TrimSuffix(cfg.Address, "/")
+	cfg.Mount = strings.Trim(cfg.Mount, "/")
+	if cfg.Mount == "" {
+		cfg.Mount = "transit"
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &VaultTransit{cfg: cfg, client: client, now: time.Now}, nil
+}
+
+// KeyID implements KeyWrapper. It names the mount and key, prefixed with
+// "vault:"; Vault tracks key versions inside the ciphertext itself.
+func (v *VaultTransit) KeyID() string {
+	return "vault:" + v.cfg.Mount + "/" + v.cfg.Key
+}
+
+// GenerateDataKey implements DataKeyGenerator.
+func (v *VaultTransit) GenerateDataKey(ctx context.Context) ([]byte, []byte, error) {
+	var out struct {
+		Plaintext  string `json:"plaintext"`
+		Ciphertext string `json:"ciphertext"`
+	}
+	req := map[string]any{"bits": DataKeySize * 8}
+	v.addContext(req)
+	if err := v.transit(ctx, "datakey/plaintext", req, &out); err != nil {
+		return nil, nil, err
+	}
+	dataKey, err := base64.StdEncoding.DecodeString(out.Plaintext)
+	if err != nil {
+		return nil, nil, fmt.Errorf("vault datakey: %w", err)
+	}
+	return dataKey, []byte(out.Ciphertext), nil
+}
+
+// WrapKey implements KeyWrapper; it is used when re-wrapping existing
+// objects under this key.
+func (v *VaultTransit) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	var out struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	req := map[string]any{"plaintext": base64.StdEncoding.EncodeToString(dataKey)}
+	v.addContext(req)
+	if err := v.transit(ctx, "encrypt", req, &out); err != nil {
+		return nil, err
+	}
+	return []byte(out.Ciphertext), nil
+}
+
+// UnwrapKey implements KeyWrapper.
+func (v *VaultTransit) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	var out struct {
+		Plaintext string `json:"plaintext"`
+	}
+	req := map[string]any{"ciphertext": string(wrapped)}
+	v.addContext(req)
+	if err := v.transit(ctx, "decrypt", req, &out); err != nil {
+		return nil, err
+	}
+	dataKey, err := base64.StdEncoding.DecodeString(out.Plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("vault decrypt: %w", err)
+	}
+	return dataKey, nil
+}
+
+// RenewToken renews the token now and records its new expiry.
+func (v *VaultTransit) RenewToken(ctx context.Context) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.renewLocked(ctx)
+}
+
+func (v *VaultTransit) addContext(req map[string]any) {
+	if len(v.cfg.Context) > 0 {
+		req["context"] = base64.StdEncoding.EncodeToString(v.cfg.Context)
+	}
+}
+
+func (v *VaultTransit) transit(ctx context.Context, op string, in, out any) error {
+	if err := v.ensureToken(ctx); err != nil {
+		return err
+	}
+	var resp struct {
+		Data json.RawMessage `json:"data"`
+	}
+	p := v.cfg.Mount + "/" + op + "/" + url.PathEscape(v.cfg.Key)
+	if err := v.do(ctx, http.MethodPost, p, in, &resp); err != nil {
+		return fmt.Errorf("vault %s: %w", op, err)
+	}
+	if err := json.Unmarshal(resp.Data, out); err != nil {
+		return fmt.Errorf("vault %s: decode response: %w", op, err)
+	}
+	return nil
+}
+
+// ensureToken looks the token up on first use and renews it once less
+// than a third of its TTL is left.
+func (v *VaultTransit) ensureToken(ctx context.Context) error {
+	if v.cfg.DisableRenewal {
+		return nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !v.checked {
+		var resp struct {
+			Data struct {
+				TTL       int64 `json:"ttl"`
+				Renewable bool  `json:"renewable"`
+			} `json:"data"`
+		}
+		if err := v.do(ctx, http.MethodGet, "auth/token/lookup-self", nil, &resp); err != nil {
+			return fmt.Errorf("vault token lookup: %w", err)
+		}
+		v.checked = true
+		v.setLease(resp.Data.TTL, resp.Data.Renewable)
+	}
+	if !v.renewable || v.expires.IsZero() || v.expires.Sub(v.now()) > v.ttl/3 {
+		return nil
+	}
+	return v.renewLocked(ctx)
+}
+
+func (v *VaultTransit) renewLocked(ctx context.Context) error {
+	var resp struct {
+		Auth struct {
+			LeaseDuration int64 `json:"lease_duration"`
+			Renewable     bool  `json:"renewable"`
+		} `json:"auth"`
+	}
+	if err := v.do(ctx, http.MethodPost, "auth/token/renew-self", map[string]any{}, &resp); err != nil {
+		return fmt.Errorf("vault token renew: %w", err)
+	}
+	v.checked = true
+	v.setLease(resp.Auth.LeaseDuration, resp.Auth.Renewable)
+	return nil
+}
+
+func (v *VaultTransit) setLease(ttlSeconds int64, renewable bool) {
+	v.renewable = renewable
+	v.ttl = time.Duration(ttlSeconds) * time.Second
+	v.expires = time.Time{}
+	if v.ttl > 0 {
+		v.expires = v.now().Add(v.ttl)
+	}
+}
+
+// do sends a request to the Vault HTTP API and decodes the JSON response
+// into out. Vault errors are returned with the messages it reported.
+func (v *VaultTransit) do(ctx context.Context, method, p string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, v.cfg.Address+"/v1/"+p, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.cfg.Token)
+	req.Header.Set("X-Vault-Request", "true")
+	if v.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.cfg.Namespace)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.Unmarshal(data, &e)
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.Join(e.Errors, "; "))
+	}
+	return json.Unmarshal(data, out)
+}
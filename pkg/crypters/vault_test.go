@@ -0,0 +1,126 @@
+package crypters
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeVault emulates the transit endpoints with "ciphertext" being the
+// base64 plaintext behind a version prefix, plus token lookup and renewal.
+type fakeVault struct {
+	mu       sync.Mutex
+	renewals int
+	requests []*http.Request
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, r)
+	f.mu.Unlock()
+
+	if r.Header.Get("X-Vault-Token") != "s.token" {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+		return
+	}
+	var in map[string]any
+	_ = json.NewDecoder(r.Body).Decode(&in)
+	reply := func(v any) { _ = json.NewEncoder(w).Encode(v) }
+	switch r.URL.Path {
+	case "/v1/auth/token/lookup-self":
+		reply(map[string]any{"data": map[string]any{"ttl": 60, "renewable": true}})
+	case "/v1/auth/token/renew-self":
+		f.mu.Lock()
+		f.renewals++
+		f.mu.Unlock()
+		reply(map[string]any{"auth": map[string]any{"lease_duration": 60, "renewable": true}})
+	case "/v1/transit/datakey/plaintext/backups":
+		key := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", DataKeySize)))
+		reply(map[string]any{"data": map[string]any{"plaintext": key, "ciphertext": "vault:v1:" + key}})
+	case "/v1/transit/encrypt/backups":
+		reply(map[string]any{"data": map[string]any{"ciphertext": "vault:v1:" + in["plaintext"].(string)}})
+	case "/v1/transit/decrypt/backups":
+		ct, ok := strings.CutPrefix(in["ciphertext"].(string), "vault:v1:")
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errors":["invalid ciphertext"]}`))
+			return
+		}
+		reply(map[string]any{"data": map[string]any{"plaintext": ct}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestVault(t *testing.T, cfg VaultConfig) (*VaultTransit, *fakeVault) {
+	t.Helper()
+	fake := &fakeVault{}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	cfg.Address = srv.URL
+	if cfg.Token == "" {
+		cfg.Token = "s.token"
+	}
+	cfg.Key = "backups"
+	v, err := NewVaultTransit(cfg)
+	require.NoError(t, err)
+	return v, fake
+}
+
+func TestVaultTransit_Envelope(t *testing.T) {
+	v, fake := newTestVault(t, VaultConfig{Namespace: "team-a"})
+	env := NewEnvelope(v)
+	assert.Equal(t, "vault:transit/backups", env.KeyID())
+
+	sealed := encrypt(t, env, []byte("wrapped by transit"))
+	got, err := decrypt(env, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "wrapped by transit", string(got))
+
+	for _, r := range fake.requests {
+		assert.Equal(t, "team-a", r.Header.Get("X-Vault-Namespace"), r.URL.Path)
+	}
+	assert.Equal(t, "/v1/transit/datakey/plaintext/backups", fake.requests[1].URL.Path)
+}
+
+func TestVaultTransit_RenewsToken(t *testing.T) {
+	v, fake := newTestVault(t, VaultConfig{})
+	now := time.Now()
+	v.now = func() time.Time { return now }
+
+	_, err := v.WrapKey(t.Context(), make([]byte, DataKeySize))
+	require.NoError(t, err)
+	assert.Zero(t, fake.renewals)
+
+	// Less than a third of the TTL left.
+	now = now.Add(45 * time.Second)
+	_, err = v.WrapKey(t.Context(), make([]byte, DataKeySize))
+	require.NoError(t, err)
+	assert.Equal(t, 1, fake.renewals)
+
+	_, err = v.WrapKey(t.Context(), make([]byte, DataKeySize))
+	require.NoError(t, err)
+	assert.Equal(t, 1, fake.renewals)
+}
+
+func TestVaultTransit_Errors(t *testing.T) {
+	v, _ := newTestVault(t, VaultConfig{Token: "s.wrong"})
+	_, err := v.WrapKey(t.Context(), make([]byte, DataKeySize))
+	assert.ErrorContains(t, err, "permission denied")
+
+	v, _ = newTestVault(t, VaultConfig{DisableRenewal: true})
+	_, err = v.UnwrapKey(t.Context(), []byte("garbage"))
+	assert.ErrorContains(t, err, "invalid ciphertext")
+
+	_, err = NewVaultTransit(VaultConfig{Address: "http://vault"})
+	assert.Error(t, err)
+}
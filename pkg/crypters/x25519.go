@@ -0,0 +1,144 @@
+package crypters
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// ErrWriteOnly is returned when a key wrapper holding only a public key is
+// asked to unwrap a data key.
+var ErrWriteOnly = errors.New("crypters: write-only key wrapper cannot decrypt")
+
+const x25519WrapLabel = "storecrypt/x25519-key-wrap"
+
+// X25519KeyWrapper wraps data keys for an X25519 public key (ECIES-style:
+// ephemeral ECDH, HKDF-SHA256, AES-256-GCM). Built from the public key
+// alone it is write-only: an Envelope using it encrypts objects that only
+// the private key holder can decrypt, so an agent uploading backups
+// cannot read the archive back even when compromised.
+//
+//	// On the agent:
+//	w, _ := crypters.NewX25519PublicKeyWrapper(pub)
+//	// On the restore host:
+//	w, _ := crypters.NewX25519PrivateKeyWrapper(priv)
+//
+// Both sides derive the same key id from the public key.
+type X25519KeyWrapper struct {
+	id   string
+	pub  *ecdh.PublicKey
+	priv *ecdh.PrivateKey // nil when write-only
+}
+
+var _ KeyWrapper = (*X25519KeyWrapper)(nil)
+
+// GenerateX25519Key returns a new private key and its public key.
+func GenerateX25519Key() (priv, pub []byte, err error) {
+	k, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return k.Bytes(), k.PublicKey().Bytes(), nil
+}
+
+// NewX25519PublicKeyWrapper creates a write-only wrapper from a 32-byte
+// public key.
+func NewX25519PublicKeyWrapper(pub []byte) (*X25519KeyWrapper, error) {
+	k, err := ecdh.X25519().NewPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("crypters: X25519 public key: %w", err)
+	}
+	return &X25519KeyWrapper{id: x25519KeyID(k), pub: k}, nil
+}
+
+// NewX25519PrivateKeyWrapper creates a wrapper that can also unwrap, from
+// a 32-byte private key.
+func NewX25519PrivateKeyWrapper(priv []byte) (*X25519KeyWrapper, error) {
+	k, err := ecdh.X25519().NewPrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("crypters: X25519 private key: %w", err)
+	}
+	return &X25519KeyWrapper{id: x25519KeyID(k.PublicKey()), pub: k.PublicKey(), priv: k}, nil
+}
+
+func x25519KeyID(pub *ecdh.PublicKey) string {
+	sum := sha256.Sum256(pub.Bytes())
+	return "x25519:" + hex.EncodeToString(sum[:8])
+}
+
+// KeyID implements KeyWrapper.
+func (x *X25519KeyWrapper) KeyID() string {
+	return x.id
+}
+
+// PublicKey returns the public key, e.g. to hand to write-only agents.
+func (x *X25519KeyWrapper) PublicKey() []byte {
+	return x.pub.Bytes()
+}
+
+// WriteOnly reports whether the wrapper lacks the private key.
+func (x *X25519KeyWrapper) WriteOnly() bool {
+	return x.priv == nil
+}
+
+// WrapKey implements KeyWrapper. The result is the ephemeral public key
+// followed by the sealed data key.
+func (x *X25519KeyWrapper) WrapKey(_ context.Context, dataKey []byte) ([]byte, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	share := ephemeral.PublicKey().Bytes()
+	aead, err := x.kek(ephemeral, x.pub, share)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := sealSmall(aead, dataKey, []byte(x.id))
+	if err != nil {
+		return nil, err
+	}
+	return append(share, sealed...), nil
+}
+
+// UnwrapKey implements KeyWrapper; it fails with ErrWriteOnly without the
+// private key.
+func (x *X25519KeyWrapper) UnwrapKey(_ context.Context, wrapped []byte) ([]byte, error) {
+	if x.priv == nil {
+		return nil, ErrWriteOnly
+	}
+	if len(wrapped) < 32 {
+		return nil, ErrAuthentication
+	}
+	share := wrapped[:32]
+	ephemeral, err := ecdh.X25519().NewPublicKey(share)
+	if err != nil {
+		return nil, ErrAuthentication
+	}
+	aead, err := x.kek(x.priv, ephemeral, share)
+	if err != nil {
+		return nil, err
+	}
+	return openSmall(aead, wrapped[32:], []byte(x.id))
+}
+
+// kek derives the key-encryption key from the ECDH shared secret, bound to
+// the ephemeral share and the recipient's public key.
+func (x *X25519KeyWrapper) kek(priv *ecdh.PrivateKey, pub *ecdh.PublicKey, share []byte) (cipher.AEAD, error) {
+	// ECDH rejects low-order points, which would yield an all-zero secret.
+	shared, err := priv.ECDH(pub)
+	if err != nil {
+		return nil, err
+	}
+	salt := append(append([]byte(nil), share...), x.pub.Bytes()...)
+	key, err := hkdf.Key(sha256.New, shared, salt, x25519WrapLabel, 32)
+	if err != nil {
+		return nil, err
+	}
+	return newGCM(key)
+}
@@ -0,0 +1,56 @@
+package crypters
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestX25519KeyWrapper_WriteOnly(t *testing.T) {
+	priv, pub, err := GenerateX25519Key()
+	require.NoError(t, err)
+
+	agent, err := NewX25519PublicKeyWrapper(pub)
+	require.NoError(t, err)
+	restore, err := NewX25519PrivateKeyWrapper(priv)
+	require.NoError(t, err)
+	assert.True(t, agent.WriteOnly())
+	assert.False(t, restore.WriteOnly())
+	assert.Equal(t, restore.KeyID(), agent.KeyID())
+	assert.Equal(t, pub, restore.PublicKey())
+
+	sealed := encrypt(t, NewEnvelope(agent), []byte("nightly backup"))
+
+	// The agent cannot read back what it wrote.
+	_, err = decrypt(NewEnvelope(agent), sealed)
+	assert.ErrorIs(t, err, ErrWriteOnly)
+
+	got, err := decrypt(NewEnvelope(restore), sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "nightly backup", string(got))
+}
+
+func TestX25519KeyWrapper_WrongKey(t *testing.T) {
+	_, pub, err := GenerateX25519Key()
+	require.NoError(t, err)
+	otherPriv, _, err := GenerateX25519Key()
+	require.NoError(t, err)
+
+	w, err := NewX25519PublicKeyWrapper(pub)
+	require.NoError(t, err)
+	wrapped, err := w.WrapKey(context.Background(), bytes.Repeat([]byte{1}, DataKeySize))
+	require.NoError(t, err)
+
+	other, err := NewX25519PrivateKeyWrapper(otherPriv)
+	require.NoError(t, err)
+	_, err = other.UnwrapKey(context.Background(), wrapped)
+	assert.ErrorIs(t, err, ErrAuthentication)
+	_, err = other.UnwrapKey(context.Background(), wrapped[:10])
+	assert.ErrorIs(t, err, ErrAuthentication)
+
+	_, err = NewX25519PublicKeyWrapper([]byte("short"))
+	assert.Error(t, err)
+}
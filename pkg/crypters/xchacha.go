@@ -0,0 +1,79 @@
+package crypters
+
+import (
+	"crypto/cipher"
+	"fmt"
+	"io"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+var xchachaMagic = []byte("SCXC1")
+
+// xchachaPasswordSalt is the fixed salt used by NewXChaCha20FromPassword;
+// the per-object randomness comes from the 19-byte nonce prefix instead.
+const xchachaPasswordSalt = "storecrypt/xchacha20-poly1305"
+
+// XChaCha20 is a crypt.Crypter sealing objects with XChaCha20-Poly1305 in
+// the chunked stream format of NewStreamWriter. It is a software-only
+// alternative to AES-GCM for hosts without AES instructions, and is stored
+// under its own extension so both can live in one archive (register it in
+// Algorithms.Crypters under ".xchacha").
+//
+// The extended 24-byte nonce leaves room for a random 19-byte prefix per
+// object, so a single key can encrypt any number of objects.
+type XChaCha20 struct {
+	aead cipher.AEAD
+}
+
+var _ crypt.Crypter = (*XChaCha20)(nil)
+
+// NewXChaCha20 creates the crypter from a 32-byte key.
+func NewXChaCha20(key []byte) (*XChaCha20, error) {
+	if len(key) != chacha20poly1305.KeySize {
+		return nil, fmt.Errorf("crypters: XChaCha20 key must be %d bytes, got %d", chacha20poly1305.KeySize, len(key))
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	return &XChaCha20{aead: aead}, nil
+}
+
+// NewXChaCha20FromPassword derives the key from password with scrypt
+// (N=2^15, r=8, p=1). The derivation runs once, here.
+func NewXChaCha20FromPassword(password string) (*XChaCha20, error) {
+	key, err := scrypt.Key([]byte(password), []byte(xchachaPasswordSalt), 1<<15, 8, 1, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return NewXChaCha20(key)
+}
+
+// FileExtension implements crypt.Crypter.
+func (x *XChaCha20) FileExtension() string {
+	return ".xchacha"
+}
+
+// Name implements crypt.Crypter.
+func (x *XChaCha20) Name() string {
+	return "xchacha20-poly1305"
+}
+
+// Encrypt implements crypt.Crypter.
+func (x *XChaCha20) Encrypt(out io.Writer) (io.WriteCloser, error) {
+	if _, err := out.Write(xchachaMagic); err != nil {
+		return nil, err
+	}
+	return NewStreamWriter(out, x.aead)
+}
+
+// Decrypt implements crypt.Crypter.
+func (x *XChaCha20) Decrypt(in io.Reader) (io.Reader, error) {
+	if err := readMagic(in, xchachaMagic); err != nil {
+		return nil, fmt.Errorf("read xchacha header: %w", err)
+	}
+	return NewStreamReader(in, x.aead)
+}
@@ -0,0 +1,42 @@
+package crypters
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestXChaCha20_RoundTrip(t *testing.T) {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	x, err := NewXChaCha20(key)
+	require.NoError(t, err)
+	assert.Equal(t, ".xchacha", x.FileExtension())
+	assert.Equal(t, "xchacha20-poly1305", x.Name())
+
+	for _, size := range []int{0, 1, ChunkSize, 3*ChunkSize + 5} {
+		plain := make([]byte, size)
+		_, _ = rand.Read(plain)
+		sealed := encrypt(t, x, plain)
+		got, err := decrypt(x, sealed)
+		require.NoError(t, err)
+		assert.Equal(t, plain, got, "size %d", size)
+	}
+
+	// Every object gets its own nonce prefix.
+	assert.NotEqual(t, encrypt(t, x, []byte("same")), encrypt(t, x, []byte("same")))
+
+	other, err := NewXChaCha20FromPassword("password")
+	require.NoError(t, err)
+	_, err = decrypt(other, encrypt(t, x, []byte("data")))
+	assert.ErrorIs(t, err, ErrAuthentication)
+
+	sealed := encrypt(t, x, []byte("data"))
+	_, err = decrypt(x, sealed[:len(sealed)-1])
+	assert.ErrorIs(t, err, ErrAuthentication)
+
+	_, err = NewXChaCha20(key[:16])
+	assert.Error(t, err)
+}
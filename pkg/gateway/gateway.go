@@ -0,0 +1,306 @@
+// Package gateway serves a Storage read-only over HTTP, so that a restore
+// host can fetch decrypted objects with plain GET requests (curl, wget,
+// pg_basebackup-style fetch scripts) instead of linking the library.
+//
+// GET and HEAD are supported, with single byte ranges and optional basic
+// authentication:
+//
+//	st, _ := storage.NewVariadicStorage(backend, alg, ".gz.aes")
+//	h := gateway.New(st, gateway.Options{Username: "restore", Password: pw})
+//	http.ListenAndServe(":8080", h)
+package gateway
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+)
+
+// Options tune a Handler.
+type Options struct {
+	// Username and Password, if Username is set, are required from
+	// clients with HTTP basic authentication.
+	Username string
+	Password string
+
+	// Index makes GET on a path ending in "/" (or the root) list the
+	// objects under it, one path per line.
+	Index bool
+
+	// ErrorLog, if set, receives the errors that are answered with a 500
+	// or that abort a response.
+	ErrorLog func(r *http.Request, err error)
+}
+
+// Handler is the http.Handler of the gateway.
+type Handler struct {
+	st   storage.Storage
+	opts Options
+}
+
+var _ http.Handler = &Handler{}
+
+// New creates a Handler serving the objects of st.
+func New(st storage.Storage, opts Options) *Handler {
+	return &Handler{st: st, opts: opts}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.opts.Username != "" && !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="storecrypt", charset="UTF-8"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	if p == "" || strings.HasSuffix(p, "/") {
+		if !h.opts.Index {
+			http.NotFound(w, r)
+			return
+		}
+		h.serveIndex(w, r, strings.TrimSuffix(p, "/"))
+		return
+	}
+	if !validPath(p) {
+		http.NotFound(w, r)
+		return
+	}
+	h.serveObject(w, r, p)
+}
+
+func (h *Handler) authorized(r *http.Request) bool {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(h.opts.Username)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(h.opts.Password)) == 1
+	return userOK && passOK
+}
+
+// validPath rejects empty, "." and ".." elements, which no object has and
+// which backends would resolve outside of the archive.
+func validPath(p string) bool {
+	for _, elem := range strings.Split(p, "/") {
+		if elem == "" || elem == "." || elem == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *Handler) serveIndex(w http.ResponseWriter, r *http.Request, prefix string) {
+	if prefix != "" && !validPath(prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	names, err := h.st.List(r.Context(), prefix)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		h.fail(w, r, err)
+		return
+	}
+	sort.Strings(names)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	for _, name := range names {
+		fmt.Fprintln(w, name)
+	}
+}
+
+func (h *Handler) serveObject(w http.ResponseWriter, r *http.Request, p string) {
+	ctx := r.Context()
+	modTime, size, err := h.stat(ctx, p)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	hdr := w.Header()
+	hdr.Set("Accept-Ranges", "bytes")
+	if !modTime.IsZero() {
+		hdr.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	ctype := mime.TypeByExtension(path.Ext(p))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	hdr.Set("Content-Type", ctype)
+
+	offset, length := int64(0), int64(-1)
+	status := http.StatusOK
+	if spec := r.Header.Get("Range"); spec != "" && r.Method == http.MethodGet {
+		if size < 0 {
+			// A range response needs the total size to be exact; measure
+			// the object when the storage does not record it.
+			if size, err = h.measure(ctx, p); err != nil {
+				h.fail(w, r, err)
+				return
+			}
+		}
+		var ok bool
+		offset, length, ok, err = parseRange(spec, size)
+		switch {
+		case err != nil:
+			hdr.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			http.Error(w, http.StatusText(http.StatusRequestedRangeNotSatisfiable), http.StatusRequestedRangeNotSatisfiable)
+			return
+		case ok:
+			status = http.StatusPartialContent
+			hdr.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, size))
+		}
+	}
+	switch {
+	case length >= 0:
+		hdr.Set("Content-Length", strconv.FormatInt(length, 10))
+	case size >= 0:
+		hdr.Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+
+	var rc io.ReadCloser
+	if status == http.StatusPartialContent {
+		rc, err = storage.GetRange(ctx, h.st, p, offset, length)
+	} else {
+		rc, err = h.st.Get(ctx, p)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	w.WriteHeader(status)
+	_, err = storage.CopyObject(w, rc)
+	if err != nil && ctx.Err() == nil {
+		// The status is sent; abort the connection so that the client
+		// sees a truncated response rather than a complete one.
+		h.logError(r, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// stat returns the modification time and the logical size of an object,
+// or a size of -1 if the storage does not record it.
+func (h *Handler) stat(ctx context.Context, p string) (time.Time, int64, error) {
+	switch st := h.st.(type) {
+	case *storage.VariadicStorage:
+		s, err := st.Stat(ctx, p)
+		return s.ModTime, s.LogicalSize, err
+	case *storage.TransformingStorage:
+		fi, err := storage.StatObject(ctx, st, p)
+		if err != nil {
+			return time.Time{}, 0, err
+		}
+		if fi.StoredSize == 0 || fi.Size == fi.StoredSize {
+			// Without a recorded size, Size is the stored one.
+			return fi.ModTime, -1, nil
+		}
+		return fi.ModTime, fi.Size, nil
+	}
+	fi, err := storage.StatObject(ctx, h.st, p)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	if fi.StoredSize == 0 && fi.StoredPath != "" && fi.StoredPath != fi.Path {
+		return fi.ModTime, -1, nil
+	}
+	return fi.ModTime, fi.Size, nil
+}
+
+// measure reads an object through to count its bytes.
+func (h *Handler) measure(ctx context.Context, p string) (n int64, err error) {
+	rc, err := h.st.Get(ctx, p)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if closeErr := rc.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	return io.Copy(io.Discard, rc)
+}
+
+func (h *Handler) fail(w http.ResponseWriter, r *http.Request, err error) {
+	h.logError(r, err)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+func (h *Handler) logError(r *http.Request, err error) {
+	if h.opts.ErrorLog != nil {
+		h.opts.ErrorLog(r, err)
+	}
+}
+
+// errUnsatisfiable is returned by parseRange for ranges outside the object.
+var errUnsatisfiable = errors.New("gateway: range not satisfiable")
+
+// parseRange parses a Range header against an object of size bytes. ok is
+// false when the header is to be ignored (malformed, or several ranges,
+// which are served as the whole object as RFC 9110 allows).
+func parseRange(spec string, size int64) (offset, length int64, ok bool, err error) {
+	units, set, found := strings.Cut(spec, "=")
+	if !found || strings.TrimSpace(units) != "bytes" || strings.Contains(set, ",") {
+		return 0, 0, false, nil
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(set), "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+	if first == "" {
+		// Suffix range: the last n bytes.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false, nil
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, false, errUnsatisfiable
+		}
+		n = min(n, size)
+		return size - n, n, true, nil
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false, nil
+	}
+	if start >= size {
+		return 0, 0, false, errUnsatisfiable
+	}
+	end := size - 1
+	if last != "" {
+		e, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || e < start {
+			return 0, 0, false, nil
+		}
+		end = min(e, size-1)
+	}
+	return start, end - start + 1, true, nil
+}
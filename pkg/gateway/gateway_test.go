@@ -0,0 +1,181 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func do(t *testing.T, h http.Handler, method, target string, hdr map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, nil)
+	for k, v := range hdr {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func newGzipStorage(t *testing.T) (*storage.InMemoryStorage, *storage.VariadicStorage) {
+	t.Helper()
+	mem := storage.NewInMemoryStorage()
+	vs, err := storage.NewVariadicStorage(mem, storage.Algorithms{
+		Gzip: &storage.CodecPair{Compressor: codec.GzipCompressor{}, Decompressor: codec.GzipDecompressor{}},
+	}, ".gz")
+	require.NoError(t, err)
+	return mem, vs
+}
+
+func TestGateway_GetHead(t *testing.T) {
+	mem := storage.NewInMemoryStorage()
+	require.NoError(t, mem.Put(context.Background(), "base/backup_label", strings.NewReader("0123456789")))
+	h := New(mem, Options{})
+
+	rec := do(t, h, http.MethodGet, "/base/backup_label", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "0123456789", rec.Body.String())
+	assert.Equal(t, "10", rec.Header().Get("Content-Length"))
+	assert.Equal(t, "bytes", rec.Header().Get("Accept-Ranges"))
+	assert.Equal(t, "application/octet-stream", rec.Header().Get("Content-Type"))
+
+	rec = do(t, h, http.MethodHead, "/base/backup_label", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Body.String())
+	assert.Equal(t, "10", rec.Header().Get("Content-Length"))
+
+	rec = do(t, h, http.MethodGet, "/base/missing", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = do(t, h, http.MethodGet, "/base/../base/backup.tar", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = do(t, h, http.MethodPut, "/base/backup_label", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "GET, HEAD", rec.Header().Get("Allow"))
+}
+
+func TestGateway_Range(t *testing.T) {
+	ctx := context.Background()
+	_, vs := newGzipStorage(t)
+	require.NoError(t, vs.Put(ctx, "wal/seg", strings.NewReader("0123456789")))
+	h := New(vs, Options{})
+
+	// The logical size of a compressed object is unknown: no length on a
+	// full response, measured for a range.
+	rec := do(t, h, http.MethodGet, "/wal/seg", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "0123456789", rec.Body.String())
+	assert.Empty(t, rec.Header().Get("Content-Length"))
+
+	for spec, want := range map[string][2]string{
+		"bytes=2-4":   {"234", "bytes 2-4/10"},
+		"bytes=7-":    {"789", "bytes 7-9/10"},
+		"bytes=-2":    {"89", "bytes 8-9/10"},
+		"bytes=8-100": {"89", "bytes 8-9/10"},
+	} {
+		rec = do(t, h, http.MethodGet, "/wal/seg", map[string]string{"Range": spec})
+		assert.Equal(t, http.StatusPartialContent, rec.Code, spec)
+		assert.Equal(t, want[0], rec.Body.String(), spec)
+		assert.Equal(t, want[1], rec.Header().Get("Content-Range"), spec)
+	}
+
+	rec = do(t, h, http.MethodGet, "/wal/seg", map[string]string{"Range": "bytes=10-"})
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, rec.Code)
+	assert.Equal(t, "bytes */10", rec.Header().Get("Content-Range"))
+
+	// Several ranges are answered with the whole object.
+	rec = do(t, h, http.MethodGet, "/wal/seg", map[string]string{"Range": "bytes=0-1,4-5"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "0123456789", rec.Body.String())
+}
+
+func TestGateway_BasicAuth(t *testing.T) {
+	mem := storage.NewInMemoryStorage()
+	require.NoError(t, mem.Put(context.Background(), "a", strings.NewReader("data")))
+	h := New(mem, Options{Username: "restore", Password: "pw"})
+
+	rec := do(t, h, http.MethodGet, "/a", nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Header().Get("WWW-Authenticate"), "Basic")
+
+	req := httptest.NewRequest(http.MethodGet, "/a", nil)
+	req.SetBasicAuth("restore", "wrong")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req.SetBasicAuth("restore", "pw")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "data", rec.Body.String())
+}
+
+func TestGateway_Index(t *testing.T) {
+	ctx := context.Background()
+	_, vs := newGzipStorage(t)
+	for _, p := range []string{"wal/b", "wal/a", "base/x"} {
+		require.NoError(t, vs.Put(ctx, p, strings.NewReader(p)))
+	}
+
+	rec := do(t, New(vs, Options{}), http.MethodGet, "/wal/", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = do(t, New(vs, Options{Index: true}), http.MethodGet, "/wal/", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "wal/a\nwal/b\n", rec.Body.String())
+}
+
+func TestGateway_AbortsOnReadError(t *testing.T) {
+	ctx := context.Background()
+	mem, vs := newGzipStorage(t)
+	require.NoError(t, vs.Put(ctx, "obj", strings.NewReader(strings.Repeat("x", 1<<16))))
+	// Truncate the stored gzip stream.
+	rc, err := mem.Get(ctx, "obj.gz")
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, mem.Put(ctx, "obj.gz", strings.NewReader(string(data[:len(data)/2]))))
+
+	logged := make(chan error, 1)
+	srv := httptest.NewServer(New(vs, Options{ErrorLog: func(_ *http.Request, err error) { logged <- err }}))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/obj")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	_, err = io.ReadAll(resp.Body)
+	assert.Error(t, err)
+	assert.Error(t, <-logged)
+}
+
+func TestParseRange(t *testing.T) {
+	for _, tc := range []struct {
+		spec           string
+		offset, length int64
+		ok, unsat      bool
+	}{
+		{spec: "bytes=0-0", offset: 0, length: 1, ok: true},
+		{spec: "bytes=5-", offset: 5, length: 5, ok: true},
+		{spec: "bytes=-20", offset: 0, length: 10, ok: true},
+		{spec: "bytes=-0", unsat: true},
+		{spec: "bytes=12-13", unsat: true},
+		{spec: "bytes=4-2"},
+		{spec: "items=0-1"},
+		{spec: "bytes=x-1"},
+		{spec: "bytes=1-2,3-4"},
+	} {
+		offset, length, ok, err := parseRange(tc.spec, 10)
+		assert.Equal(t, tc.unsat, err != nil, tc.spec)
+		assert.Equal(t, tc.ok, ok, tc.spec)
+		if ok {
+			assert.Equal(t, [2]int64{tc.offset, tc.length}, [2]int64{offset, length}, tc.spec)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Package gc implements reference-counted garbage collection for chunked
+// (deduplicated) layouts stored through storecrypt.
+//
+// A chunked layout keeps content-addressed chunks under one prefix and
+// manifests that reference them under another. Collect scans every
+// manifest, counts chunk references, and removes chunks nothing points to.
+// Chunks younger than the grace period are never removed, so a writer that
+// uploads chunks before publishing the manifest referencing them is safe.
+package gc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+)
+
+// DefaultGracePeriod protects freshly written chunks from collection.
+const DefaultGracePeriod = 24 * time.Hour
+
+// ManifestParser extracts the chunk IDs referenced by a manifest.
+type ManifestParser func(r io.Reader) ([]string, error)
+
+// Options describe the chunked layout and the collection policy.
+type Options struct {
+	// ManifestPrefix is where manifests are stored.
+	ManifestPrefix string
+
+	// ChunkPrefix is where chunks are stored; a chunk with ID "ab12" lives
+	// at ChunkPrefix/ab12.
+	ChunkPrefix string
+
+	// Parse decodes a manifest; defaults to ParseJSONManifest.
+	Parse ManifestParser
+
+	// GracePeriod skips chunks modified more recently than this
+	// (default DefaultGracePeriod; negative disables the grace period).
+	GracePeriod time.Duration
+
+	// DryRun reports what would be deleted without deleting anything.
+	DryRun bool
+}
+
+// Report is the outcome of a collection run.
+type Report struct {
+	Manifests    int                // manifests scanned
+	Chunks       int                // chunks found under ChunkPrefix
+	Live         int                // chunks referenced by at least one manifest
+	RefCounts    map[string]int     // chunk ID -> number of references
+	Missing      []string           // referenced chunk IDs that do not exist
+	Young        []storage.FileInfo // unreferenced but within the grace period
+	Unreferenced []storage.FileInfo // unreferenced and old enough to delete
+	Deleted      int                // chunks actually removed (0 on dry run)
+	FreedBytes   int64              // stored bytes removed (or to be removed on dry run)
+	DryRun       bool
+}
+
+// ParseJSONManifest reads manifests of the form {"chunks": ["id", ...]}.
+func ParseJSONManifest(r io.Reader) ([]string, error) {
+	var m struct {
+		Chunks []string `json:"chunks"`
+	}
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, err
+	}
+	return m.Chunks, nil
+}
+
+// Collect scans manifests and removes unreferenced chunks.
+func Collect(ctx context.Context, st storage.Storage, opts Options) (*Report, error) {
+	return collect(ctx, st, opts, time.Now())
+}
+
+func collect(ctx context.Context, st storage.Storage, opts Options, now time.Time) (*Report, error) {
+	if opts.ManifestPrefix == "" || opts.ChunkPrefix == "" {
+		return nil, errors.New("gc: manifest and chunk prefixes are required")
+	}
+	if opts.Parse == nil {
+		opts.Parse = ParseJSONManifest
+	}
+	if opts.GracePeriod == 0 {
+		opts.GracePeriod = DefaultGracePeriod
+	}
+
+	report := &Report{RefCounts: make(map[string]int), DryRun: opts.DryRun}
+
+	// Manifests first: a chunk written after this point is protected by the
+	// grace period, a manifest written after this point can only reference
+	// chunks that are either listed below as live or still young.
+	manifests, err := listOrEmpty(ctx, st, opts.ManifestPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range manifests {
+		ids, err := readManifest(ctx, st, m, opts.Parse)
+		if err != nil {
+			return nil, fmt.Errorf("gc: manifest %q: %w", m, err)
+		}
+		report.Manifests++
+		for _, id := range ids {
+			report.RefCounts[id]++
+		}
+	}
+
+	chunks, err := listInfoOrEmpty(ctx, st, opts.ChunkPrefix)
+	if err != nil {
+		return nil, err
+	}
+	report.Chunks = len(chunks)
+
+	chunkPrefix := strings.Trim(opts.ChunkPrefix, "/") + "/"
+	present := make(map[string]bool, len(chunks))
+	cutoff := now.Add(-opts.GracePeriod)
+
+	for _, fi := range chunks {
+		id := strings.TrimPrefix(fi.Path, chunkPrefix)
+		present[id] = true
+		if report.RefCounts[id] > 0 {
+			report.Live++
+			continue
+		}
+		if opts.GracePeriod > 0 && fi.ModTime.After(cutoff) {
+			report.Young = append(report.Young, fi)
+			continue
+		}
+		report.Unreferenced = append(report.Unreferenced, fi)
+		report.FreedBytes += fi.Size
+	}
+
+	for id := range report.RefCounts {
+		if !present[id] {
+			report.Missing = append(report.Missing, id)
+		}
+	}
+	sort.Strings(report.Missing)
+
+	if opts.DryRun {
+		return report, nil
+	}
+
+	for _, fi := range report.Unreferenced {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if err := st.Delete(ctx, fi.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return report, fmt.Errorf("gc: delete %q: %w", fi.Path, err)
+		}
+		report.Deleted++
+	}
+	return report, nil
+}
+
+func readManifest(ctx context.Context, st storage.Storage, p string, parse ManifestParser) ([]string, error) {
+	rc, err := st.Get(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return parse(rc)
+}
+
+func listOrEmpty(ctx context.Context, st storage.Storage, prefix string) ([]string, error) {
+	files, err := st.List(ctx, prefix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return files, err
+}
+
+func listInfoOrEmpty(ctx context.Context, st storage.Storage, prefix string) ([]storage.FileInfo, error) {
+	files, err := st.ListInfo(ctx, prefix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return files, err
+}
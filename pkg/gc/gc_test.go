@@ -0,0 +1,59 @@
+package gc
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func put(t *testing.T, st storage.Storage, p, data string) {
+	t.Helper()
+	require.NoError(t, st.Put(context.Background(), p, bytes.NewReader([]byte(data))))
+}
+
+func TestCollect(t *testing.T) {
+	ctx := context.Background()
+	st := storage.NewInMemoryStorage()
+
+	put(t, st, "manifests/b1", `{"chunks":["c1","c2"]}`)
+	put(t, st, "manifests/b2", `{"chunks":["c2","c9"]}`)
+	put(t, st, "chunks/c1", "1")
+	put(t, st, "chunks/c2", "2")
+	put(t, st, "chunks/c3", "333")
+
+	opts := Options{ManifestPrefix: "manifests", ChunkPrefix: "chunks", GracePeriod: time.Hour}
+
+	// Inside the grace period nothing is collected.
+	report, err := collect(ctx, st, opts, time.Now())
+	require.NoError(t, err)
+	assert.Empty(t, report.Unreferenced)
+	assert.Len(t, report.Young, 1)
+
+	// Dry run after the grace period: reported, not deleted.
+	opts.DryRun = true
+	later := time.Now().Add(2 * time.Hour)
+	report, err = collect(ctx, st, opts, later)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Manifests)
+	assert.Equal(t, 3, report.Chunks)
+	assert.Equal(t, 2, report.Live)
+	assert.Equal(t, 2, report.RefCounts["c2"])
+	assert.Equal(t, []string{"c9"}, report.Missing)
+	require.Len(t, report.Unreferenced, 1)
+	assert.Equal(t, "chunks/c3", report.Unreferenced[0].Path)
+	assert.Equal(t, int64(3), report.FreedBytes)
+	assert.Equal(t, 0, report.Deleted)
+	assert.Contains(t, st.Files, "chunks/c3")
+
+	opts.DryRun = false
+	report, err = collect(ctx, st, opts, later)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Deleted)
+	assert.NotContains(t, st.Files, "chunks/c3")
+	assert.Contains(t, st.Files, "chunks/c1")
+}
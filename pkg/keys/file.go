@@ -0,0 +1,176 @@
+package keys
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashmap-kz/storecrypt/pkg/crypters"
+	"github.com/hashmap-kz/storecrypt/pkg/fsync"
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// Generate returns a new random 32-byte master key.
+func Generate() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("keys: %w", err)
+	}
+	return key, nil
+}
+
+// AddToFile adds key under id to the keyring file at path, creating the
+// file if it does not exist. The key becomes the primary if primary is
+// set or if it is the first one. Existing entries are kept as written
+// (e.g. "file:" references); the new key is stored base64 encoded. The
+// file is replaced atomically and keeps its format (JSON, or YAML for
+// ".yaml"/".yml").
+func AddToFile(path, id string, key []byte, primary bool) error {
+	var f keyringFile
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("keys: %w", err)
+	default:
+		if err := unmarshalFile(path, data, &f); err != nil {
+			return err
+		}
+	}
+	if _, ok := f.Keys[id]; ok {
+		return fmt.Errorf("keys: key %q already exists", id)
+	}
+	if f.Keys == nil {
+		f.Keys = make(map[string]string)
+	}
+	f.Keys[id] = "base64:" + base64.StdEncoding.EncodeToString(key)
+	if primary || f.Primary == "" {
+		f.Primary = id
+	}
+	// Refuse to write a ring that would not load.
+	if _, err := f.keyring(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	if isYAML(path) {
+		data, err = yaml.Marshal(&f)
+	} else {
+		data, err = json.MarshalIndent(&f, "", "  ")
+		data = append(data, '\n')
+	}
+	if err != nil {
+		return fmt.Errorf("keys: %w", err)
+	}
+	return writeFileAtomic(path, data)
+}
+
+func isYAML(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+func unmarshalFile(path string, data []byte, f *keyringFile) error {
+	var err error
+	if isYAML(path) {
+		err = yaml.Unmarshal(data, f)
+	} else {
+		err = json.Unmarshal(data, f)
+	}
+	if err != nil {
+		return fmt.Errorf("keys: parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place, so a crash never leaves a truncated keyring.
+func writeFileAtomic(path string, data []byte) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("keys: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	if err := tmp.Chmod(0o600); err != nil {
+		return fmt.Errorf("keys: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		return fmt.Errorf("keys: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("keys: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("keys: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("keys: %w", err)
+	}
+	return fsync.FsyncDir(filepath.Dir(path))
+}
+
+// EnvelopeStorage returns an EnvelopeStorage over backend with the keys
+// of the ring: the primary wraps new data keys, the others unwrap old
+// ones. Its RewrapAll moves the objects of a rotated ring to the new
+// primary key.
+func (k *Keyring) EnvelopeStorage(backend storage.Storage) (*storage.EnvelopeStorage, error) {
+	primary, previous, err := k.wrappers()
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewEnvelopeStorage(backend, primary, previous...), nil
+}
+
+// Usage counts the envelope-encrypted (".enc") objects under prefix in
+// backend by the ID of the key that wraps their data key. Only object
+// headers are read.
+func Usage(ctx context.Context, backend storage.Storage, prefix string) (map[string]int, error) {
+	files, err := backend.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	ext := (&crypters.Envelope{}).FileExtension()
+	usage := make(map[string]int)
+	for _, f := range files {
+		if !strings.HasSuffix(f, ext) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		id, err := headerKeyID(ctx, backend, f)
+		if err != nil {
+			return nil, fmt.Errorf("keys: %s: %w", f, err)
+		}
+		usage[id]++
+	}
+	return usage, nil
+}
+
+func headerKeyID(ctx context.Context, backend storage.Storage, p string) (string, error) {
+	rc, err := backend.Get(ctx, p)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	hdr, err := crypters.ReadEnvelopeHeader(rc)
+	if err != nil {
+		return "", err
+	}
+	return hdr.KeyID, nil
+}
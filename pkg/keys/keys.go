@@ -0,0 +1,219 @@
+// Package keys loads keyrings: files mapping key IDs to master keys.
+//
+// A keyring becomes a crypters.Envelope whose primary key wraps the data
+// key of every new object, with the key ID written into the object's
+// envelope header. Reads look the ID up and unwrap with the matching key,
+// so objects written under any key in the ring stay readable after the
+// primary moves on. The same crypter plugs into TransformingStorage
+// (Crypter) and VariadicStorage (Register).
+//
+// Keyring files are JSON or YAML:
+//
+//	primary: "2026-01"
+//	keys:
+//	  "2025-06": "base64:3q2+7w...="
+//	  "2026-01": "hex:9f86d081884c7d65..."
+//	  "offsite": "file:/etc/storecrypt/offsite.key"
+//
+// Secrets are 32-byte keys, base64 encoded (the default when there is no
+// prefix), hex encoded, or read from a keyfile (see
+// crypters.NewLocalKeyWrapperFromFile). Relative file paths are resolved
+// against the keyring file's directory.
+package keys
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashmap-kz/storecrypt/pkg/crypters"
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"gopkg.in/yaml.v3"
+)
+
+// maxKeyIDLen matches the key id field of storage object headers.
+const maxKeyIDLen = 255
+
+// Keyring holds master keys by ID, one of which is the primary.
+type Keyring struct {
+	primary string
+	keys    map[string][]byte
+}
+
+type keyringFile struct {
+	Primary string            `json:"primary" yaml:"primary"`
+	Keys    map[string]string `json:"keys" yaml:"keys"`
+}
+
+// New creates a keyring. primary may be empty if keys holds a single key.
+func New(primary string, keys map[string][]byte) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("keys: keyring is empty")
+	}
+	k := &Keyring{primary: primary, keys: make(map[string][]byte, len(keys))}
+	for id, key := range keys {
+		if id == "" || len(id) > maxKeyIDLen {
+			return nil, fmt.Errorf("keys: invalid key id %q", id)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("keys: key %q must be 32 bytes, got %d", id, len(key))
+		}
+		k.keys[id] = append([]byte(nil), key...)
+	}
+	if k.primary == "" {
+		if len(k.keys) > 1 {
+			return nil, errors.New("keys: primary key id is required with more than one key")
+		}
+		for id := range k.keys {
+			k.primary = id
+		}
+	}
+	if _, ok := k.keys[k.primary]; !ok {
+		return nil, fmt.Errorf("keys: primary key %q is not in the keyring", k.primary)
+	}
+	return k, nil
+}
+
+// Load reads a keyring file. Files ending in ".yaml" or ".yml" are parsed
+// as YAML, everything else as JSON.
+func Load(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("keys: %w", err)
+	}
+	var f keyringFile
+	if err := unmarshalFile(path, data, &f); err != nil {
+		return nil, err
+	}
+	return f.keyring(filepath.Dir(path))
+}
+
+// ParseJSON parses a JSON keyring. Relative keyfile paths are resolved
+// against the working directory.
+func ParseJSON(data []byte) (*Keyring, error) {
+	var f keyringFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("keys: %w", err)
+	}
+	return f.keyring("")
+}
+
+// ParseYAML parses a YAML keyring. Relative keyfile paths are resolved
+// against the working directory.
+func ParseYAML(data []byte) (*Keyring, error) {
+	var f keyringFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("keys: %w", err)
+	}
+	return f.keyring("")
+}
+
+func (f *keyringFile) keyring(dir string) (*Keyring, error) {
+	keys := make(map[string][]byte, len(f.Keys))
+	for id, secret := range f.Keys {
+		key, err := decodeSecret(secret, dir)
+		if err != nil {
+			return nil, fmt.Errorf("keys: key %q: %w", id, err)
+		}
+		keys[id] = key
+	}
+	return New(f.Primary, keys)
+}
+
+func decodeSecret(s, dir string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(s, "hex:"):
+		return hex.DecodeString(strings.TrimPrefix(s, "hex:"))
+	case strings.HasPrefix(s, "file:"):
+		p := strings.TrimPrefix(s, "file:")
+		if !filepath.IsAbs(p) && dir != "" {
+			p = filepath.Join(dir, p)
+		}
+		return readKeyfile(p)
+	default:
+		return base64.StdEncoding.DecodeString(strings.TrimPrefix(s, "base64:"))
+	}
+}
+
+// readKeyfile reads 32 raw bytes or 64 hex characters, like
+// crypters.NewLocalKeyWrapperFromFile.
+func readKeyfile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if s := strings.TrimSpace(string(data)); len(s) == 64 {
+		if decoded, err := hex.DecodeString(s); err == nil {
+			return decoded, nil
+		}
+	}
+	return data, nil
+}
+
+// Primary returns the ID of the key new objects are written with.
+func (k *Keyring) Primary() string {
+	return k.primary
+}
+
+// IDs returns the key IDs in the keyring, sorted.
+func (k *Keyring) IDs() []string {
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Envelope returns an envelope crypter that writes with the primary key
+// and reads objects written under any key in the ring.
+func (k *Keyring) Envelope() (*crypters.Envelope, error) {
+	primary, previous, err := k.wrappers()
+	if err != nil {
+		return nil, err
+	}
+	return crypters.NewEnvelope(primary, previous...), nil
+}
+
+// wrappers returns the key wrapper of the primary key and those of the
+// others.
+func (k *Keyring) wrappers() (crypters.KeyWrapper, []crypters.KeyWrapper, error) {
+	primary, err := crypters.NewLocalKeyWrapper(k.primary, k.keys[k.primary])
+	if err != nil {
+		return nil, nil, err
+	}
+	var previous []crypters.KeyWrapper
+	for _, id := range k.IDs() {
+		if id == k.primary {
+			continue
+		}
+		w, err := crypters.NewLocalKeyWrapper(id, k.keys[id])
+		if err != nil {
+			return nil, nil, err
+		}
+		previous = append(previous, w)
+	}
+	return primary, previous, nil
+}
+
+// Register adds the keyring's envelope crypter to alg under its ".enc"
+// extension, so that a VariadicStorage can write and read ".enc" objects
+// (optionally compressed, e.g. ".zst.enc").
+func (k *Keyring) Register(alg *storage.Algorithms) error {
+	env, err := k.Envelope()
+	if err != nil {
+		return err
+	}
+	if alg.Crypters == nil {
+		alg.Crypters = make(map[string]crypt.Crypter)
+	}
+	alg.Crypters[env.FileExtension()] = env
+	return nil
+}
@@ -0,0 +1,186 @@
+package keys
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashmap-kz/storecrypt/pkg/crypters"
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newKey(t *testing.T) []byte {
+	t.Helper()
+	k := make([]byte, 32)
+	_, err := rand.Read(k)
+	require.NoError(t, err)
+	return k
+}
+
+func writeFile(t *testing.T, p, data string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(p, []byte(data), 0o600))
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	k1, k2, k3 := newKey(t), newKey(t), newKey(t)
+	writeFile(t, filepath.Join(dir, "offsite.key"), hex.EncodeToString(k3))
+
+	writeFile(t, filepath.Join(dir, "ring.json"), `{
+  "primary": "2026-01",
+  "keys": {
+    "2025-06": "base64:`+base64.StdEncoding.EncodeToString(k1)+`",
+    "2026-01": "hex:`+hex.EncodeToString(k2)+`",
+    "offsite": "file:offsite.key"
+  }
+}`)
+	writeFile(t, filepath.Join(dir, "ring.yaml"), `
+primary: "2026-01"
+keys:
+  "2025-06": "`+base64.StdEncoding.EncodeToString(k1)+`"
+  "2026-01": "hex:`+hex.EncodeToString(k2)+`"
+  offsite: "file:offsite.key"
+`)
+
+	for _, name := range []string{"ring.json", "ring.yaml"} {
+		kr, err := Load(filepath.Join(dir, name))
+		require.NoError(t, err, name)
+		assert.Equal(t, "2026-01", kr.Primary())
+		assert.Equal(t, []string{"2025-06", "2026-01", "offsite"}, kr.IDs())
+		assert.Equal(t, k3, kr.keys["offsite"])
+	}
+}
+
+func TestNew_Errors(t *testing.T) {
+	_, err := New("", nil)
+	assert.Error(t, err)
+	_, err = New("", map[string][]byte{"a": newKey(t), "b": newKey(t)})
+	assert.ErrorContains(t, err, "primary")
+	_, err = New("c", map[string][]byte{"a": newKey(t)})
+	assert.ErrorContains(t, err, "not in the keyring")
+	_, err = New("a", map[string][]byte{"a": []byte("short")})
+	assert.ErrorContains(t, err, "32 bytes")
+	_, err = ParseJSON([]byte(`{"keys": {"a": "hex:zz"}}`))
+	assert.Error(t, err)
+
+	kr, err := New("", map[string][]byte{"only": newKey(t)})
+	require.NoError(t, err)
+	assert.Equal(t, "only", kr.Primary())
+}
+
+func readAll(t *testing.T, st storage.Storage, p string) string {
+	t.Helper()
+	rc, err := st.Get(context.Background(), p)
+	require.NoError(t, err)
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	ctx := context.Background()
+	mem := storage.NewInMemoryStorage()
+	oldSecret, newSecret := newKey(t), newKey(t)
+
+	before, err := New("old", map[string][]byte{"old": oldSecret})
+	require.NoError(t, err)
+	env, err := before.Envelope()
+	require.NoError(t, err)
+	ts := &storage.TransformingStorage{Backend: mem, Crypter: env}
+	require.NoError(t, ts.Put(ctx, "a", bytes.NewReader([]byte("under old"))))
+
+	after, err := New("new", map[string][]byte{"old": oldSecret, "new": newSecret})
+	require.NoError(t, err)
+
+	// VariadicStorage reads the object written by TransformingStorage
+	// and tags new objects with the new primary.
+	var alg storage.Algorithms
+	require.NoError(t, after.Register(&alg))
+	vs, err := storage.NewVariadicStorage(mem, alg, ".enc")
+	require.NoError(t, err)
+	assert.Equal(t, "under old", readAll(t, vs, "a"))
+
+	require.NoError(t, vs.Put(ctx, "b", bytes.NewReader([]byte("under new"))))
+	for p, want := range map[string]string{"a.enc": "old", "b.enc": "new"} {
+		hdr, err := crypters.ReadEnvelopeHeader(bytes.NewReader(mem.Files[p]))
+		require.NoError(t, err)
+		assert.Equal(t, want, hdr.KeyID, p)
+	}
+
+	// The old keyring cannot read objects under the new key.
+	_, err = ts.Get(ctx, "b")
+	assert.ErrorIs(t, err, crypters.ErrUnknownKey)
+}
+
+func TestAddToFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"ring.json", "ring.yaml"} {
+		p := filepath.Join(dir, name)
+		k1, k2 := newKey(t), newKey(t)
+		require.NoError(t, AddToFile(p, "a", k1, false))
+		require.NoError(t, AddToFile(p, "b", k2, false))
+
+		kr, err := Load(p)
+		require.NoError(t, err, name)
+		assert.Equal(t, "a", kr.Primary(), "the first key becomes the primary")
+		assert.Equal(t, k2, kr.keys["b"])
+
+		require.NoError(t, AddToFile(p, "c", newKey(t), true))
+		kr, err = Load(p)
+		require.NoError(t, err, name)
+		assert.Equal(t, "c", kr.Primary())
+		assert.Equal(t, []string{"a", "b", "c"}, kr.IDs())
+
+		assert.ErrorContains(t, AddToFile(p, "a", newKey(t), false), "already exists")
+		assert.Error(t, AddToFile(p, "d", []byte("short"), false))
+
+		fi, err := os.Stat(p)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+	}
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "no temporary files left behind")
+}
+
+func TestUsage_Rotation(t *testing.T) {
+	ctx := context.Background()
+	mem := storage.NewInMemoryStorage()
+	oldSecret := newKey(t)
+
+	before, err := New("old", map[string][]byte{"old": oldSecret})
+	require.NoError(t, err)
+	es, err := before.EnvelopeStorage(mem)
+	require.NoError(t, err)
+	for _, p := range []string{"d/a", "d/b"} {
+		require.NoError(t, es.Put(ctx, p, bytes.NewReader([]byte(p))))
+	}
+	require.NoError(t, mem.Put(ctx, "d/plain", bytes.NewReader([]byte("not encrypted"))))
+
+	usage, err := Usage(ctx, mem, "d")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"old": 2}, usage)
+
+	after, err := New("new", map[string][]byte{"old": oldSecret, "new": newKey(t)})
+	require.NoError(t, err)
+	es, err = after.EnvelopeStorage(mem)
+	require.NoError(t, err)
+	n, err := es.RewrapAll(ctx, "d")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	usage, err = Usage(ctx, mem, "d")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"new": 2}, usage)
+	assert.Equal(t, "d/a", readAll(t, es, "d/a"))
+}
@@ -0,0 +1,298 @@
+// Package lock implements cooperative, advisory leases on top of any
+// storage.Storage.
+//
+// A lease is a small JSON object holding the owner, a random token, the TTL
+// and the time of the last heartbeat. A lease whose heartbeat is older than
+// its TTL is considered abandoned and may be taken over.
+//
+// Leases are never overwritten. Every Acquire, Renew and Release writes the
+// next generation (<prefix>/<name>/<generation>.lock) with
+// storage.PutIfAbsent, so of two owners racing for the same generation
+// exactly one wins, and a Renew or Release by an owner that was taken over
+// collides with its successor instead of clobbering it. Older generations
+// are removed once superseded. This needs a storage.ExclusivePutter
+// backend; on others Acquire fails with errors.ErrUnsupported.
+//
+// The scheme is advisory and intended for coordinating well-behaved
+// archivers, not as a replacement for a consensus-backed lock service.
+package lock
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+)
+
+const (
+	DefaultPrefix = ".locks"
+	DefaultTTL    = 60 * time.Second
+)
+
+var (
+	// ErrLocked is returned by Acquire when a live lease is held by someone else.
+	ErrLocked = errors.New("lock is held by another owner")
+
+	// ErrLeaseLost is returned by Renew/Release when the lease was taken over
+	// (or removed) since it was acquired.
+	ErrLeaseLost = errors.New("lease lost")
+)
+
+// Options configure a Locker.
+type Options struct {
+	// Prefix is the directory holding lease objects (default ".locks").
+	Prefix string
+
+	// TTL is how long a lease stays valid without a heartbeat (default 60s).
+	TTL time.Duration
+
+	// Owner identifies this process in lease objects (default hostname:pid).
+	Owner string
+}
+
+// Lease is the persisted state of an acquired lock.
+type Lease struct {
+	Name       string        `json:"name"`
+	Generation int64         `json:"generation"`
+	Owner      string        `json:"owner"`
+	Token      string        `json:"token"`
+	TTL        time.Duration `json:"ttl"`
+	Acquired   time.Time     `json:"acquired"`
+	Heartbeat  time.Time     `json:"heartbeat"`
+
+	// Released marks the generation written by Release.
+	Released bool `json:"released,omitempty"`
+}
+
+// ExpiresAt returns the time at which the lease becomes abandoned.
+func (l *Lease) ExpiresAt() time.Time {
+	return l.Heartbeat.Add(l.TTL)
+}
+
+// Expired reports whether the lease is abandoned at the given time.
+func (l *Lease) Expired(now time.Time) bool {
+	return !now.Before(l.ExpiresAt())
+}
+
+// Locker acquires and maintains leases stored in a Storage.
+type Locker struct {
+	st     storage.Storage
+	prefix string
+	ttl    time.Duration
+	owner  string
+	now    func() time.Time
+}
+
+// New creates a Locker over st.
+func New(st storage.Storage, opts Options) *Locker {
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultPrefix
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+	if opts.Owner == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			host = "unknown"
+		}
+		opts.Owner = fmt.Sprintf("%s:%d", host, os.Getpid())
+	}
+	return &Locker{
+		st:     st,
+		prefix: opts.Prefix,
+		ttl:    opts.TTL,
+		owner:  opts.Owner,
+		now:    time.Now,
+	}
+}
+
+func (lk *Locker) leaseDir(name string) string {
+	return path.Join(lk.prefix, name)
+}
+
+func (lk *Locker) leasePath(name string, gen int64) string {
+	return path.Join(lk.leaseDir(name), fmt.Sprintf("%020d.lock", gen))
+}
+
+// Acquire takes the named lease. It fails with ErrLocked if another owner
+// holds a lease that has not expired yet, or won a concurrent Acquire.
+func (lk *Locker) Acquire(ctx context.Context, name string) (*Lease, error) {
+	if name == "" {
+		return nil, errors.New("lock name is empty")
+	}
+	if _, ok := lk.st.(storage.ExclusivePutter); !ok {
+		return nil, fmt.Errorf("lock %q: %T cannot create objects exclusively: %w", name, lk.st, errors.ErrUnsupported)
+	}
+
+	current, err := lk.head(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	var gen int64 = 1
+	if current != nil {
+		if !current.Released && !current.Expired(lk.now()) {
+			return nil, fmt.Errorf("%w: %q held by %s until %s",
+				ErrLocked, name, current.Owner, current.ExpiresAt().Format(time.RFC3339))
+		}
+		gen = current.Generation + 1
+	}
+
+	now := lk.now().UTC()
+	lease := &Lease{
+		Name:       name,
+		Generation: gen,
+		Owner:      lk.owner,
+		Token:      newToken(),
+		TTL:        lk.ttl,
+		Acquired:   now,
+		Heartbeat:  now,
+	}
+	if err := lk.advance(ctx, lease); err != nil {
+		if errors.Is(err, ErrLeaseLost) {
+			return nil, fmt.Errorf("%w: %q acquired concurrently", ErrLocked, name)
+		}
+		return nil, err
+	}
+	return lease, nil
+}
+
+// Renew refreshes the heartbeat of a lease. It fails with ErrLeaseLost if
+// the lease no longer belongs to the caller.
+func (lk *Locker) Renew(ctx context.Context, lease *Lease) error {
+	renewed := *lease
+	renewed.Generation++
+	renewed.Heartbeat = lk.now().UTC()
+	if err := lk.advance(ctx, &renewed); err != nil {
+		return err
+	}
+	*lease = renewed
+	return nil
+}
+
+// Release gives up a lease. It fails with ErrLeaseLost if the lease no
+// longer belongs to the caller; in that case the successor's lease is left
+// untouched.
+func (lk *Locker) Release(ctx context.Context, lease *Lease) error {
+	released := *lease
+	released.Generation++
+	released.Heartbeat = lk.now().UTC()
+	released.Released = true
+	if err := lk.advance(ctx, &released); err != nil {
+		return err
+	}
+	*lease = released
+	return nil
+}
+
+// Inspect returns the current lease for name, or nil if there is none or
+// it was released.
+func (lk *Locker) Inspect(ctx context.Context, name string) (*Lease, error) {
+	lease, err := lk.head(ctx, name)
+	if err != nil || lease == nil || lease.Released {
+		return nil, err
+	}
+	return lease, nil
+}
+
+// advance writes lease as the next generation. It fails with ErrLeaseLost
+// if that generation exists, or if a later one does: generations removed
+// by the current holder can be recreated by a stale owner, and the write
+// is then undone.
+func (lk *Locker) advance(ctx context.Context, lease *Lease) error {
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+	p := lk.leasePath(lease.Name, lease.Generation)
+	if err := storage.PutIfAbsent(ctx, lk.st, p, bytes.NewReader(data)); err != nil {
+		if errors.Is(err, storage.ErrAlreadyExists) {
+			return fmt.Errorf("%w: %q", ErrLeaseLost, lease.Name)
+		}
+		return err
+	}
+
+	gens, err := lk.generations(ctx, lease.Name)
+	if err != nil {
+		return err
+	}
+	if len(gens) > 0 && gens[len(gens)-1] > lease.Generation {
+		_ = lk.st.Delete(ctx, p)
+		return fmt.Errorf("%w: %q", ErrLeaseLost, lease.Name)
+	}
+
+	// Superseded generations are only kept for the race above; removing
+	// them is best effort.
+	for _, gen := range gens {
+		if gen < lease.Generation {
+			_ = lk.st.Delete(ctx, lk.leasePath(lease.Name, gen))
+		}
+	}
+	return nil
+}
+
+// generations lists the stored generations of a lease in ascending order.
+func (lk *Locker) generations(ctx context.Context, name string) ([]int64, error) {
+	dir := lk.leaseDir(name)
+	files, err := lk.st.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	var gens []int64
+	for _, f := range files {
+		if path.Dir(f) != dir {
+			continue
+		}
+		base, ok := strings.CutSuffix(path.Base(f), ".lock")
+		if !ok {
+			continue
+		}
+		gen, err := strconv.ParseInt(base, 10, 64)
+		if err != nil || gen <= 0 {
+			continue
+		}
+		gens = append(gens, gen)
+	}
+	slices.Sort(gens)
+	return gens, nil
+}
+
+// head reads the latest generation of a lease, or returns nil if there is
+// none.
+func (lk *Locker) head(ctx context.Context, name string) (*Lease, error) {
+	gens, err := lk.generations(ctx, name)
+	if err != nil || len(gens) == 0 {
+		return nil, err
+	}
+	p := lk.leasePath(name, gens[len(gens)-1])
+	rc, err := lk.st.Get(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var lease Lease
+	if err := json.NewDecoder(rc).Decode(&lease); err != nil {
+		return nil, fmt.Errorf("decode lease %q: %w", p, err)
+	}
+	return &lease, nil
+}
+
+func newToken() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,143 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocker_AcquireRenewRelease(t *testing.T) {
+	ctx := context.Background()
+	st := storage.NewInMemoryStorage()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	a := New(st, Options{Owner: "a", TTL: time.Minute})
+	b := New(st, Options{Owner: "b", TTL: time.Minute})
+	a.now, b.now = clock, clock
+
+	lease, err := a.Acquire(ctx, "wal")
+	require.NoError(t, err)
+	assert.Equal(t, "a", lease.Owner)
+
+	_, err = b.Acquire(ctx, "wal")
+	require.ErrorIs(t, err, ErrLocked)
+
+	// Heartbeat keeps the lease alive past the original TTL.
+	now = now.Add(50 * time.Second)
+	require.NoError(t, a.Renew(ctx, lease))
+	now = now.Add(50 * time.Second)
+	_, err = b.Acquire(ctx, "wal")
+	require.ErrorIs(t, err, ErrLocked)
+
+	require.NoError(t, a.Release(ctx, lease))
+	lb, err := b.Acquire(ctx, "wal")
+	require.NoError(t, err)
+	assert.Equal(t, "b", lb.Owner)
+}
+
+func TestLocker_ExpiredLeaseIsTakenOver(t *testing.T) {
+	ctx := context.Background()
+	st := storage.NewInMemoryStorage()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	a := New(st, Options{Owner: "a", TTL: time.Minute})
+	b := New(st, Options{Owner: "b", TTL: time.Minute})
+	a.now, b.now = clock, clock
+
+	la, err := a.Acquire(ctx, "wal")
+	require.NoError(t, err)
+
+	now = now.Add(2 * time.Minute)
+	_, err = b.Acquire(ctx, "wal")
+	require.NoError(t, err)
+
+	require.ErrorIs(t, a.Renew(ctx, la), ErrLeaseLost)
+	require.ErrorIs(t, a.Release(ctx, la), ErrLeaseLost)
+}
+
+func TestLocker_StaleReleaseKeepsSuccessor(t *testing.T) {
+	ctx := context.Background()
+	st := storage.NewInMemoryStorage()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	a := New(st, Options{Owner: "a", TTL: time.Minute})
+	b := New(st, Options{Owner: "b", TTL: time.Minute})
+	a.now, b.now = clock, clock
+
+	la, err := a.Acquire(ctx, "wal")
+	require.NoError(t, err)
+	now = now.Add(2 * time.Minute)
+	lb, err := b.Acquire(ctx, "wal")
+	require.NoError(t, err)
+	// b renews twice, removing the generations a knew about.
+	require.NoError(t, b.Renew(ctx, lb))
+	require.NoError(t, b.Renew(ctx, lb))
+
+	require.ErrorIs(t, a.Renew(ctx, la), ErrLeaseLost)
+	require.ErrorIs(t, a.Release(ctx, la), ErrLeaseLost)
+
+	held, err := b.Inspect(ctx, "wal")
+	require.NoError(t, err)
+	require.NotNil(t, held)
+	assert.Equal(t, lb.Token, held.Token)
+	assert.Equal(t, []string{".locks/wal/00000000000000000004.lock"}, mustList(t, st, ".locks"))
+
+	require.NoError(t, b.Release(ctx, lb))
+	held, err = b.Inspect(ctx, "wal")
+	require.NoError(t, err)
+	assert.Nil(t, held)
+}
+
+func TestLocker_ConcurrentAcquire(t *testing.T) {
+	ctx := context.Background()
+	st, err := storage.NewLocal(&storage.LocalStorageOpts{BaseDir: t.TempDir()})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = New(st, Options{Owner: fmt.Sprint(i)}).Acquire(ctx, "wal")
+		}()
+	}
+	wg.Wait()
+
+	won := 0
+	for _, err := range errs {
+		if err == nil {
+			won++
+		} else {
+			require.ErrorIs(t, err, ErrLocked)
+		}
+	}
+	assert.Equal(t, 1, won)
+}
+
+func TestLocker_Unsupported(t *testing.T) {
+	st := storage.NewFSStorage(fstest.MapFS{})
+	_, err := New(st, Options{}).Acquire(context.Background(), "wal")
+	require.ErrorIs(t, err, errors.ErrUnsupported)
+}
+
+func mustList(t *testing.T, st storage.Storage, prefix string) []string {
+	t.Helper()
+	files, err := st.List(context.Background(), prefix)
+	require.NoError(t, err)
+	return files
+}
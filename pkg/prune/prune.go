@@ -0,0 +1,201 @@
+// Package prune runs the housekeeping of a storecrypt archive in the
+// background: expiring objects past their retention (TTLStorage.Sweep),
+// purging the trash (TrashStorage.Purge) and collecting unreferenced
+// chunks (gc.Collect), on an interval with jitter.
+//
+// Embedding applications create a Scheduler with the jobs they need and
+// run it in a goroutine:
+//
+//	s := prune.New(prune.Options{Interval: time.Hour, Jitter: 5 * time.Minute},
+//		prune.RetentionJob(ttl), prune.TrashJob(trash), prune.GCJob(st, gcOpts))
+//	go s.Run(ctx)
+package prune
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/hashmap-kz/storecrypt/pkg/gc"
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+)
+
+// DefaultInterval is the time between runs when Options.Interval is zero.
+const DefaultInterval = time.Hour
+
+// Job is one pruning step. Run removes what is due or, with dryRun, only
+// finds it; it returns the number of objects concerned.
+type Job struct {
+	Name string
+	Run  func(ctx context.Context, dryRun bool) (int, error)
+}
+
+// RetentionJob sweeps the objects of t past their expiry.
+func RetentionJob(t *storage.TTLStorage) Job {
+	return Job{Name: "retention", Run: func(ctx context.Context, dryRun bool) (int, error) {
+		if dryRun {
+			due, err := t.Expired(ctx)
+			return len(due), err
+		}
+		return t.Sweep(ctx)
+	}}
+}
+
+// TrashJob purges the trash generations of t older than its retention.
+func TrashJob(t *storage.TrashStorage) Job {
+	return Job{Name: "trash", Run: func(ctx context.Context, dryRun bool) (int, error) {
+		if dryRun {
+			due, err := t.Expired(ctx)
+			return len(due), err
+		}
+		return t.Purge(ctx)
+	}}
+}
+
+// GCJob collects the unreferenced chunks of a chunked layout in st.
+func GCJob(st storage.Storage, opts gc.Options) Job {
+	return Job{Name: "gc", Run: func(ctx context.Context, dryRun bool) (int, error) {
+		opts.DryRun = opts.DryRun || dryRun
+		report, err := gc.Collect(ctx, st, opts)
+		if err != nil {
+			return 0, err
+		}
+		if report.DryRun {
+			return len(report.Unreferenced), nil
+		}
+		return report.Deleted, nil
+	}}
+}
+
+// Options tune a Scheduler.
+type Options struct {
+	// Interval is the time between runs (default DefaultInterval).
+	Interval time.Duration
+
+	// Jitter adds a random delay of up to Jitter before every run, so
+	// that many processes sharing a backend do not prune in lockstep.
+	Jitter time.Duration
+
+	// DryRun runs every job without removing anything.
+	DryRun bool
+
+	// OnRun, if set, is called after every run with its report.
+	OnRun func(RunReport)
+}
+
+// RunReport is the outcome of one run of every job.
+type RunReport struct {
+	Started  time.Time
+	Duration time.Duration
+	DryRun   bool
+	Removed  map[string]int // job name -> objects removed (or due, on dry run)
+	Err      error          // failures of the jobs, joined
+}
+
+// Metrics are the counters of a Scheduler since it was created.
+type Metrics struct {
+	Runs         int64
+	FailedRuns   int64            // runs in which a job failed
+	Removed      map[string]int64 // job name -> objects removed (not counting dry runs)
+	LastRun      time.Time
+	LastDuration time.Duration
+	LastError    error
+}
+
+// Scheduler runs pruning jobs periodically.
+type Scheduler struct {
+	jobs  []Job
+	opts  Options
+	now   func() time.Time
+	after func(time.Duration) <-chan time.Time
+
+	mu      sync.Mutex
+	metrics Metrics
+}
+
+// New creates a Scheduler running jobs, in order, on every run.
+func New(opts Options, jobs ...Job) *Scheduler {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+	return &Scheduler{
+		jobs:    jobs,
+		opts:    opts,
+		now:     time.Now,
+		after:   time.After,
+		metrics: Metrics{Removed: make(map[string]int64)},
+	}
+}
+
+// Run runs the jobs right away and then every interval (plus jitter)
+// until ctx is cancelled, which it returns. Failed runs are reported
+// through OnRun and Metrics; they do not stop the scheduler.
+func (s *Scheduler) Run(ctx context.Context) error {
+	for {
+		s.RunOnce(ctx)
+		delay := s.opts.Interval
+		if s.opts.Jitter > 0 {
+			delay += rand.N(s.opts.Jitter)
+		}
+		select {
+		case <-s.after(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// RunOnce runs every job once. A failing job does not prevent the next
+// ones from running.
+func (s *Scheduler) RunOnce(ctx context.Context) RunReport {
+	report := RunReport{Started: s.now(), DryRun: s.opts.DryRun, Removed: make(map[string]int)}
+	var errs []error
+	for _, job := range s.jobs {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		n, err := job.Run(ctx, s.opts.DryRun)
+		report.Removed[job.Name] += n
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", job.Name, err))
+		}
+	}
+	report.Err = errors.Join(errs...)
+	report.Duration = s.now().Sub(report.Started)
+
+	s.mu.Lock()
+	s.metrics.Runs++
+	if report.Err != nil {
+		s.metrics.FailedRuns++
+	}
+	if !report.DryRun {
+		for name, n := range report.Removed {
+			s.metrics.Removed[name] += int64(n)
+		}
+	}
+	s.metrics.LastRun = report.Started
+	s.metrics.LastDuration = report.Duration
+	s.metrics.LastError = report.Err
+	s.mu.Unlock()
+
+	if s.opts.OnRun != nil {
+		s.opts.OnRun(report)
+	}
+	return report
+}
+
+// Metrics returns a snapshot of the counters.
+func (s *Scheduler) Metrics() Metrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.metrics
+	m.Removed = make(map[string]int64, len(s.metrics.Removed))
+	for name, n := range s.metrics.Removed {
+		m.Removed[name] = n
+	}
+	return m
+}
@@ -0,0 +1,81 @@
+package prune
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduler_RunOnce(t *testing.T) {
+	ctx := context.Background()
+	mem := storage.NewInMemoryStorage()
+
+	ttl := storage.NewTTLStorage(mem, time.Nanosecond)
+	require.NoError(t, ttl.Put(ctx, "tmp/1", strings.NewReader("one")))
+	require.NoError(t, ttl.Put(ctx, "tmp/2", strings.NewReader("two")))
+
+	trash := storage.NewTrashStorage(mem, 0)
+	require.NoError(t, trash.Put(ctx, "wal/1", strings.NewReader("one")))
+	require.NoError(t, trash.Delete(ctx, "wal/1"))
+	time.Sleep(time.Millisecond)
+
+	failing := Job{Name: "failing", Run: func(context.Context, bool) (int, error) { return 0, errors.New("boom") }}
+
+	dry := New(Options{DryRun: true}, RetentionJob(ttl), failing, TrashJob(trash))
+	report := dry.RunOnce(ctx)
+	assert.ErrorContains(t, report.Err, "failing: boom")
+	assert.Equal(t, map[string]int{"retention": 2, "failing": 0, "trash": 1}, report.Removed)
+	assert.Len(t, mem.Files, 4, "a dry run removes nothing")
+
+	var reports []RunReport
+	s := New(Options{OnRun: func(r RunReport) { reports = append(reports, r) }}, RetentionJob(ttl), TrashJob(trash))
+	report = s.RunOnce(ctx)
+	require.NoError(t, report.Err)
+	assert.Equal(t, map[string]int{"retention": 2, "trash": 1}, report.Removed)
+	require.Len(t, reports, 1)
+
+	m := s.Metrics()
+	assert.Equal(t, int64(1), m.Runs)
+	assert.Equal(t, int64(0), m.FailedRuns)
+	assert.Equal(t, int64(2), m.Removed["retention"])
+	assert.Equal(t, int64(1), dry.Metrics().FailedRuns)
+	assert.Empty(t, dry.Metrics().Removed)
+}
+
+func TestScheduler_Run(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runs := make(chan struct{}, 10)
+	job := Job{Name: "count", Run: func(context.Context, bool) (int, error) {
+		runs <- struct{}{}
+		return 1, nil
+	}}
+	s := New(Options{Interval: time.Hour, Jitter: time.Minute}, job)
+	ticks := make(chan time.Time)
+	var delays []time.Duration
+	s.after = func(d time.Duration) <-chan time.Time {
+		delays = append(delays, d)
+		return ticks
+	}
+
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+	<-runs // runs right away
+	ticks <- time.Now()
+	<-runs
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+
+	assert.Equal(t, int64(2), s.Metrics().Runs)
+	for _, d := range delays {
+		assert.GreaterOrEqual(t, d, time.Hour)
+		assert.Less(t, d, time.Hour+time.Minute)
+	}
+}
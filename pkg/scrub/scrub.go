@@ -0,0 +1,218 @@
+// Package scrub reads stored objects back to detect bit rot: objects the
+// backend can no longer return, objects that no longer decrypt or
+// authenticate, and objects whose content no longer matches the digests
+// recorded when they were written.
+//
+// A scrub reads every object in full, so it costs one download of the
+// prefix (two with a decrypting view). Run it from a periodic job and act
+// on the report, e.g. by restoring the objects it lists from a replica.
+package scrub
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/hashmap-kz/storecrypt/pkg/catalog"
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+)
+
+// DefaultConcurrency is the number of objects read at once when Options
+// does not say.
+const DefaultConcurrency = 4
+
+// Digests are the checksums recorded for an object when it was written.
+// Empty fields are not checked.
+type Digests struct {
+	StoredSHA256 string // hex SHA-256 of the bytes in the backend
+	SHA256       string // hex SHA-256 of the plaintext
+}
+
+// Options configure a scrub.
+type Options struct {
+	// Plain is a decrypting view of the scrubbed storage, such as the
+	// TransformingStorage or VariadicStorage wrapping it. When set, the
+	// objects are listed through it and read through it as well, which
+	// authenticates the ciphertext and checks integrity trailers, and
+	// their plaintext digests can be compared.
+	Plain storage.Storage
+
+	// Recorded maps logical paths to their recorded digests, e.g. from
+	// CatalogDigests. Objects without an entry are only read.
+	Recorded map[string]Digests
+
+	// Concurrency bounds the objects read at once; zero means
+	// DefaultConcurrency.
+	Concurrency int
+}
+
+// Finding is an object the scrub flagged.
+type Finding struct {
+	Path       string `json:"path"`                  // logical path
+	StoredPath string `json:"stored_path,omitempty"` // physical name, if it differs
+	Reason     string `json:"reason"`
+	Err        error  `json:"-"`
+}
+
+// Report is the outcome of a scrub. It marshals to JSON for monitoring
+// and tooling. Findings are sorted by path.
+type Report struct {
+	Scanned int   `json:"scanned"`
+	OK      int   `json:"ok"`
+	Bytes   int64 `json:"bytes"` // stored bytes read
+
+	// Corrupt objects were read but do not match their recorded digests,
+	// or their stored bytes do not decrypt or authenticate.
+	Corrupt []Finding `json:"corrupt"`
+
+	// Unreadable objects could not be read from the backend at all.
+	Unreadable []Finding `json:"unreadable"`
+}
+
+// Clean reports whether every scanned object was readable and intact.
+func (r *Report) Clean() bool {
+	return len(r.Corrupt) == 0 && len(r.Unreadable) == 0
+}
+
+// Scrub reads every object under prefix of st back, recomputes its
+// SHA-256 and that of its plaintext when opts.Plain is set, and compares
+// them with opts.Recorded.
+//
+// The returned error covers listing failures and cancellation only; the
+// objects found damaged are in the report, which is always set.
+func Scrub(ctx context.Context, st storage.Storage, prefix string, opts Options) (*Report, error) {
+	report := &Report{Corrupt: []Finding{}, Unreadable: []Finding{}}
+	workers := opts.Concurrency
+	if workers <= 0 {
+		workers = DefaultConcurrency
+	}
+	lister := st
+	if opts.Plain != nil {
+		lister = opts.Plain
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	jobs := make(chan storage.FileInfo)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fi := range jobs {
+				n, finding, corrupt := scrubObject(ctx, st, fi, opts)
+				mu.Lock()
+				report.Scanned++
+				report.Bytes += n
+				switch {
+				case finding == nil:
+					report.OK++
+				case corrupt:
+					report.Corrupt = append(report.Corrupt, *finding)
+				default:
+					report.Unreadable = append(report.Unreadable, *finding)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	err := storage.WalkInfo(ctx, lister, prefix, func(fi storage.FileInfo) error {
+		if fi.IsDir {
+			return nil
+		}
+		select {
+		case jobs <- fi:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(jobs)
+	wg.Wait()
+
+	byPath := func(f []Finding) func(i, j int) bool {
+		return func(i, j int) bool { return f[i].Path < f[j].Path }
+	}
+	sort.Slice(report.Corrupt, byPath(report.Corrupt))
+	sort.Slice(report.Unreadable, byPath(report.Unreadable))
+	if err != nil {
+		return report, fmt.Errorf("list %q: %w", prefix, err)
+	}
+	return report, ctx.Err()
+}
+
+// scrubObject checks one object. It returns the stored bytes read and, if
+// the object is damaged, why and whether it is corrupt rather than
+// unreadable.
+func scrubObject(ctx context.Context, st storage.Storage, fi storage.FileInfo, opts Options) (int64, *Finding, bool) {
+	stored := fi.StoredPath
+	if stored == "" {
+		stored = fi.Path
+	}
+	finding := func(reason string, err error) *Finding {
+		f := &Finding{Path: fi.Path, Reason: reason, Err: err}
+		if stored != fi.Path {
+			f.StoredPath = stored
+		}
+		return f
+	}
+	want := opts.Recorded[fi.Path]
+
+	n, digest, err := digestObject(ctx, st, stored)
+	if err != nil {
+		return n, finding(fmt.Sprintf("read: %v", err), err), false
+	}
+	if want.StoredSHA256 != "" && digest != want.StoredSHA256 {
+		return n, finding("stored digest mismatch", nil), true
+	}
+
+	// Without a decrypting view the stored bytes are the plaintext.
+	if opts.Plain != nil {
+		if _, digest, err = digestObject(ctx, opts.Plain, fi.Path); err != nil {
+			// The stored bytes were readable a moment ago, so this is
+			// the decryption or integrity check failing.
+			return n, finding(fmt.Sprintf("decode: %v", err), err), true
+		}
+	}
+	if want.SHA256 != "" && digest != want.SHA256 {
+		return n, finding("plaintext digest mismatch", nil), true
+	}
+	return n, nil, false
+}
+
+// digestObject reads an object and returns its size and hex SHA-256.
+func digestObject(ctx context.Context, st storage.Storage, p string) (int64, string, error) {
+	rc, err := st.Get(ctx, p)
+	if err != nil {
+		return 0, "", err
+	}
+	h := sha256.New()
+	n, err := io.Copy(h, rc)
+	if closeErr := rc.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return n, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// CatalogDigests returns the plaintext digests the catalog recorded for
+// the objects under prefix, for Options.Recorded. Use it with Plain set:
+// the catalog records what was written through the encrypting stack.
+func CatalogDigests(ctx context.Context, cat *catalog.Catalog, prefix string) (map[string]Digests, error) {
+	entries, err := cat.Query(ctx, catalog.Query{Prefix: prefix})
+	if err != nil {
+		return nil, err
+	}
+	recorded := make(map[string]Digests, len(entries))
+	for _, e := range entries {
+		recorded[e.Path] = Digests{SHA256: e.SHA256}
+	}
+	return recorded, nil
+}
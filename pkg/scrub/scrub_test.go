@@ -0,0 +1,106 @@
+package scrub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/hashmap-kz/storecrypt/pkg/catalog"
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// getFailer fails Get for the configured stored names.
+type getFailer struct {
+	storage.Storage
+	fail map[string]bool
+}
+
+func (g *getFailer) Get(ctx context.Context, p string) (io.ReadCloser, error) {
+	if g.fail[p] {
+		return nil, errors.New("input/output error")
+	}
+	return g.Storage.Get(ctx, p)
+}
+
+func put(t *testing.T, st storage.Storage, p, data string) {
+	t.Helper()
+	require.NoError(t, st.Put(context.Background(), p, strings.NewReader(data)))
+}
+
+func TestScrub(t *testing.T) {
+	ctx := context.Background()
+	mem := storage.NewInMemoryStorage()
+	raw := &getFailer{Storage: mem, fail: map[string]bool{}}
+	plain, err := storage.NewVariadicStorage(raw, storage.Algorithms{Gzip: &storage.CodecPair{
+		Compressor:   codec.GzipCompressor{},
+		Decompressor: codec.GzipDecompressor{},
+	}}, ".gz")
+	require.NoError(t, err)
+	cat := catalog.New(mem)
+	for _, p := range []string{"wal/1", "wal/2", "wal/3", "wal/4"} {
+		put(t, catalog.NewStorage(plain, cat), p, strings.Repeat(p, 100))
+	}
+
+	recorded, err := CatalogDigests(ctx, cat, "wal")
+	require.NoError(t, err)
+	require.Len(t, recorded, 4)
+
+	report, err := Scrub(ctx, raw, "wal", Options{Plain: plain, Recorded: recorded})
+	require.NoError(t, err)
+	assert.True(t, report.Clean())
+	assert.Equal(t, 4, report.OK)
+
+	// Bit rot in the stored bytes, content replaced behind the catalog's
+	// back, and an object the backend cannot return.
+	rotten := mem.Files["wal/2.gz"]
+	rotten[len(rotten)/2] ^= 0xff
+	put(t, plain, "wal/3", "something else")
+	raw.fail["wal/4.gz"] = true
+
+	report, err = Scrub(ctx, raw, "wal", Options{Plain: plain, Recorded: recorded, Concurrency: 2})
+	require.NoError(t, err)
+	assert.False(t, report.Clean())
+	assert.Equal(t, 4, report.Scanned)
+	assert.Equal(t, 1, report.OK)
+	require.Len(t, report.Corrupt, 2)
+	assert.Equal(t, "wal/2", report.Corrupt[0].Path)
+	assert.Equal(t, "wal/2.gz", report.Corrupt[0].StoredPath)
+	assert.Error(t, report.Corrupt[0].Err)
+	assert.Equal(t, "wal/3", report.Corrupt[1].Path)
+	assert.Equal(t, "plaintext digest mismatch", report.Corrupt[1].Reason)
+	require.Len(t, report.Unreadable, 1)
+	assert.Equal(t, "wal/4", report.Unreadable[0].Path)
+
+	var decoded map[string]any
+	var buf bytes.Buffer
+	require.NoError(t, json.NewEncoder(&buf).Encode(report))
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.EqualValues(t, 4, decoded["scanned"])
+	assert.Len(t, decoded["corrupt"], 2)
+}
+
+func TestScrub_StoredDigests(t *testing.T) {
+	ctx := context.Background()
+	mem := storage.NewInMemoryStorage()
+	put(t, mem, "a", "aaa")
+	put(t, mem, "b", "bbb")
+
+	recorded := map[string]Digests{
+		// sha256("aaa")
+		"a": {StoredSHA256: "9834876dcfb05cb167a5c24953eba58c4ac89b1adf57f28f2f9d09af107ee8f0"},
+		"b": {StoredSHA256: "0000000000000000000000000000000000000000000000000000000000000000"},
+	}
+	report, err := Scrub(ctx, mem, "", Options{Recorded: recorded})
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.OK)
+	require.Len(t, report.Corrupt, 1)
+	assert.Equal(t, Finding{Path: "b", Reason: "stored digest mismatch"}, report.Corrupt[0])
+	assert.Equal(t, int64(6), report.Bytes)
+}
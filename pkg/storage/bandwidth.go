@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TransferUsage is the traffic accounted to a single prefix.
+type TransferUsage struct {
+	UploadedBytes   int64 `json:"uploaded_bytes"`
+	DownloadedBytes int64 `json:"downloaded_bytes"`
+	Puts            int64 `json:"puts"`
+	Gets            int64 `json:"gets"`
+}
+
+// BandwidthReport is the document written by BandwidthStorage.Flush.
+type BandwidthReport struct {
+	Since    time.Time                `json:"since"`
+	Updated  time.Time                `json:"updated"`
+	Prefixes map[string]TransferUsage `json:"prefixes"`
+}
+
+// BandwidthStorage tracks bytes uploaded and downloaded per prefix.
+//
+// A prefix is the first Depth directory components of the object path,
+// e.g. with Depth 1 "tenant-a/wal/0001" is accounted to "tenant-a".
+// Objects at the storage root are accounted to "". Only bytes actually
+// streamed are counted, so aborted transfers are billed for what moved.
+type BandwidthStorage struct {
+	Backend Storage
+	depth   int
+
+	mu    sync.Mutex
+	since time.Time
+	usage map[string]*TransferUsage
+
+	flushMu sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+var (
+	_ Storage = (*BandwidthStorage)(nil)
+	_ Walker  = (*BandwidthStorage)(nil)
+)
+
+// NewBandwidthStorage creates a BandwidthStorage grouping traffic by the
+// first depth path components (depth < 1 is treated as 1).
+func NewBandwidthStorage(backend Storage, depth int) *BandwidthStorage {
+	if depth < 1 {
+		depth = 1
+	}
+	return &BandwidthStorage{
+		Backend: backend,
+		depth:   depth,
+		since:   time.Now().UTC(),
+		usage:   make(map[string]*TransferUsage),
+	}
+}
+
+func (b *BandwidthStorage) prefixOf(p string) string {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	// drop the object name itself
+	parts = parts[:len(parts)-1]
+	if len(parts) > b.depth {
+		parts = parts[:b.depth]
+	}
+	return strings.Join(parts, "/")
+}
+
+func (b *BandwidthStorage) account(prefix string, fn func(u *TransferUsage)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	u, ok := b.usage[prefix]
+	if !ok {
+		u = &TransferUsage{}
+		b.usage[prefix] = u
+	}
+	fn(u)
+}
+
+// Usage returns a snapshot of the tallies per prefix.
+func (b *BandwidthStorage) Usage() map[string]TransferUsage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	result := make(map[string]TransferUsage, len(b.usage))
+	for k, v := range b.usage {
+		result[k] = *v
+	}
+	return result
+}
+
+// Reset returns the current tallies and starts counting from zero.
+func (b *BandwidthStorage) Reset() map[string]TransferUsage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	result := make(map[string]TransferUsage, len(b.usage))
+	for k, v := range b.usage {
+		result[k] = *v
+	}
+	b.usage = make(map[string]*TransferUsage)
+	b.since = time.Now().UTC()
+	return result
+}
+
+// Flush writes the current tallies as a JSON BandwidthReport to statsPath
+// in the backend. The write itself is not accounted.
+func (b *BandwidthStorage) Flush(ctx context.Context, statsPath string) error {
+	b.mu.Lock()
+	since := b.since
+	b.mu.Unlock()
+
+	data, err := json.Marshal(&BandwidthReport{
+		Since:    since,
+		Updated:  time.Now().UTC(),
+		Prefixes: b.Usage(),
+	})
+	if err != nil {
+		return err
+	}
+	return b.Backend.Put(ctx, statsPath, bytes.NewReader(data))
+}
+
+// StartFlush calls Flush every interval until StopFlush is called or ctx
+// is canceled. Errors are reported to onError, which may be nil.
+func (b *BandwidthStorage) StartFlush(ctx context.Context, statsPath string, interval time.Duration, onError func(error)) {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	if b.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	b.cancel = cancel
+	b.done = done
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := b.Flush(ctx, statsPath); err != nil && onError != nil && ctx.Err() == nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
+
+// StopFlush terminates the periodic flush and waits for it to exit.
+func (b *BandwidthStorage) StopFlush() {
+	b.flushMu.Lock()
+	cancel, done := b.cancel, b.done
+	b.cancel, b.done = nil, nil
+	b.flushMu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+func (b *BandwidthStorage) Put(ctx context.Context, remotePath string, r io.Reader) error {
+	prefix := b.prefixOf(remotePath)
+	b.account(prefix, func(u *TransferUsage) { u.Puts++ })
+	return b.Backend.Put(ctx, remotePath, &countingReader{r: r, add: func(n int64) {
+		b.account(prefix, func(u *TransferUsage) { u.UploadedBytes += n })
+	}})
+}
+
+func (b *BandwidthStorage) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	rc, err := b.Backend.Get(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	prefix := b.prefixOf(remotePath)
+	b.account(prefix, func(u *TransferUsage) { u.Gets++ })
+	return &countingReadCloser{
+		countingReader: countingReader{r: rc, add: func(n int64) {
+			b.account(prefix, func(u *TransferUsage) { u.DownloadedBytes += n })
+		}},
+		c: rc,
+	}, nil
+}
+
+func (b *BandwidthStorage) List(ctx context.Context, remotePath string) ([]string, error) {
+	return b.Backend.List(ctx, remotePath)
+}
+
+func (b *BandwidthStorage) ListInfo(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	return b.Backend.ListInfo(ctx, remotePath)
+}
+
+func (b *BandwidthStorage) WalkInfo(ctx context.Context, remotePath string, fn func(fi FileInfo) error) error {
+	return WalkInfo(ctx, b.Backend, remotePath, fn)
+}
+
+func (b *BandwidthStorage) Delete(ctx context.Context, remotePath string) error {
+	return b.Backend.Delete(ctx, remotePath)
+}
+
+func (b *BandwidthStorage) DeleteAll(ctx context.Context, remotePath string) error {
+	return b.Backend.DeleteAll(ctx, remotePath)
+}
+
+func (b *BandwidthStorage) DeleteDir(ctx context.Context, remotePath string) error {
+	return b.Backend.DeleteDir(ctx, remotePath)
+}
+
+func (b *BandwidthStorage) DeleteAllBulk(ctx context.Context, paths []string) error {
+	return b.Backend.DeleteAllBulk(ctx, paths)
+}
+
+func (b *BandwidthStorage) Exists(ctx context.Context, remotePath string) (bool, error) {
+	return b.Backend.Exists(ctx, remotePath)
+}
+
+func (b *BandwidthStorage) ListTopLevelDirs(ctx context.Context, prefix string) (map[string]bool, error) {
+	return b.Backend.ListTopLevelDirs(ctx, prefix)
+}
+
+func (b *BandwidthStorage) Rename(ctx context.Context, oldRemotePath, newRemotePath string) error {
+	return b.Backend.Rename(ctx, oldRemotePath, newRemotePath)
+}
+
+// countingReader reports every chunk of bytes read through add.
+type countingReader struct {
+	r   io.Reader
+	add func(n int64)
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.add(int64(n))
+	}
+	return n, err
+}
+
+type countingReadCloser struct {
+	countingReader
+	c io.Closer
+}
+
+func (c *countingReadCloser) Close() error {
+	return c.c.Close()
+}
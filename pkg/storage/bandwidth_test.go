@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBandwidthStorage_AccountsPerPrefix(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	b := NewBandwidthStorage(mem, 1)
+
+	require.NoError(t, b.Put(ctx, "tenant-a/wal/0001", bytes.NewReader([]byte("12345"))))
+	require.NoError(t, b.Put(ctx, "tenant-b/wal/0001", bytes.NewReader([]byte("12"))))
+	require.NoError(t, b.Put(ctx, "root-object", bytes.NewReader([]byte("1"))))
+
+	rc, err := b.Get(ctx, "tenant-a/wal/0001")
+	require.NoError(t, err)
+	_, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+
+	usage := b.Usage()
+	assert.Equal(t, TransferUsage{UploadedBytes: 5, DownloadedBytes: 5, Puts: 1, Gets: 1}, usage["tenant-a"])
+	assert.Equal(t, TransferUsage{UploadedBytes: 2, Puts: 1}, usage["tenant-b"])
+	assert.Equal(t, int64(1), usage[""].UploadedBytes)
+
+	require.NoError(t, b.Flush(ctx, "stats/bandwidth.json"))
+	assert.Contains(t, mem.Files, "stats/bandwidth.json")
+	assert.Equal(t, usage, b.Usage(), "flush must not be accounted")
+
+	prev := b.Reset()
+	assert.Len(t, prev, 3)
+	assert.Empty(t, b.Usage())
+}
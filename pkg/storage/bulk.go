@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	// DefaultDeleteConcurrency is the number of deletions DeleteAllBulk
+	// runs at once when no concurrency is configured.
+	DefaultDeleteConcurrency = 8
+
+	// DefaultPutConcurrency is the number of uploads PutBatch runs at once
+	// when no concurrency is given.
+	DefaultPutConcurrency = 8
+
+	// DefaultRenameConcurrency is the number of renames RenameBulk runs at
+	// once when no concurrency is given.
+	DefaultRenameConcurrency = 8
+)
+
+// PutItem is one object of a PutBatch. Either Reader is set, or Open,
+// which is called when the upload starts so that a large batch does not
+// hold every source open; what it returns is closed afterwards.
+type PutItem struct {
+	Path   string
+	Reader io.Reader
+	Open   func() (io.ReadCloser, error)
+}
+
+// PutResult is the outcome of one PutItem.
+type PutResult struct {
+	Path  string
+	Bytes int64 // read from the source, also when the upload failed
+	Err   error
+}
+
+// PutBatch uploads items with at most concurrency Puts in flight
+// (DefaultPutConcurrency if concurrency <= 0). Every item is tried and its
+// outcome is at the same index of the results; the returned error joins
+// the failures. Items not started before ctx is done fail with ctx.Err().
+func PutBatch(ctx context.Context, st Storage, items []PutItem, concurrency int) ([]PutResult, error) {
+	if concurrency <= 0 {
+		concurrency = DefaultPutConcurrency
+	}
+	results := make([]PutResult, len(items))
+	for i, item := range items {
+		results[i].Path = item.Path
+	}
+	notStarted := runConcurrently(ctx, len(items), concurrency, func(i int) {
+		results[i].Bytes, results[i].Err = putItem(ctx, st, items[i])
+	})
+	for _, i := range notStarted {
+		results[i].Err = ctx.Err()
+	}
+
+	var errs []error
+	for _, res := range results {
+		if res.Err != nil {
+			errs = append(errs, fmt.Errorf("put %q: %w", res.Path, res.Err))
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+func putItem(ctx context.Context, st Storage, item PutItem) (int64, error) {
+	r := item.Reader
+	if item.Open != nil {
+		rc, err := item.Open()
+		if err != nil {
+			return 0, err
+		}
+		defer rc.Close()
+		r = rc
+	}
+	if r == nil {
+		return 0, errors.New("no reader")
+	}
+	var n int64
+	err := st.Put(ctx, item.Path, &countingReader{r: r, add: func(k int64) { n += k }})
+	return n, err
+}
+
+// RenamePair is one rename of a RenameBulk.
+type RenamePair struct {
+	From string
+	To   string
+}
+
+// RenameResult is the outcome of one RenamePair.
+type RenameResult struct {
+	From string
+	To   string
+	Err  error
+}
+
+// BulkRenamer is implemented by backends that rename many objects at once
+// faster than one Rename at a time (S3 batches the deletes of the copied
+// sources).
+type BulkRenamer interface {
+	RenameBulk(ctx context.Context, pairs []RenamePair, concurrency int) ([]RenameResult, error)
+}
+
+// RenameBulk renames the pairs with at most concurrency renames in flight
+// (DefaultRenameConcurrency if concurrency <= 0), e.g. to promote a backup
+// generation. It uses BulkRenamer when st implements it and otherwise
+// calls st.Rename for every pair. Every pair is tried and its outcome is
+// at the same index of the results; the returned error joins the
+// failures. Pairs not started before ctx is done fail with ctx.Err().
+//
+// The pairs run in no particular order, so no path may be the source of
+// one pair and the destination of another.
+func RenameBulk(ctx context.Context, st Storage, pairs []RenamePair, concurrency int) ([]RenameResult, error) {
+	if r, ok := st.(BulkRenamer); ok {
+		return r.RenameBulk(ctx, pairs, concurrency)
+	}
+	if concurrency <= 0 {
+		concurrency = DefaultRenameConcurrency
+	}
+	results := newRenameResults(pairs)
+	notStarted := runConcurrently(ctx, len(pairs), concurrency, func(i int) {
+		results[i].Err = st.Rename(ctx, pairs[i].From, pairs[i].To)
+	})
+	for _, i := range notStarted {
+		results[i].Err = ctx.Err()
+	}
+	return results, renameErr(results)
+}
+
+func newRenameResults(pairs []RenamePair) []RenameResult {
+	results := make([]RenameResult, len(pairs))
+	for i, p := range pairs {
+		results[i] = RenameResult{From: p.From, To: p.To}
+	}
+	return results
+}
+
+// renameErr joins the failures of results.
+func renameErr(results []RenameResult) error {
+	var errs []error
+	for _, res := range results {
+		if res.Err != nil {
+			errs = append(errs, fmt.Errorf("rename %q -> %q: %w", res.From, res.To, res.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// deleteConcurrently calls del for every path with at most workers calls in
+// flight (DefaultDeleteConcurrency if workers <= 0). All paths are tried;
+// the failures are joined. Once ctx is done no further deletion starts and
+// ctx.Err() is part of the result.
+func deleteConcurrently(ctx context.Context, paths []string, workers int, del func(p string) error) error {
+	if workers <= 0 {
+		workers = DefaultDeleteConcurrency
+	}
+	var (
+		mu   sync.Mutex
+		errs []error
+	)
+	runConcurrently(ctx, len(paths), workers, func(i int) {
+		if err := del(paths[i]); err != nil {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// runConcurrently calls fn for the indexes 0..n-1 with at most workers
+// calls in flight, and returns once they are done. After ctx is done no
+// further call starts; the indexes not started are returned.
+func runConcurrently(ctx context.Context, n, workers int, fn func(i int)) (notStarted []int) {
+	if n == 0 {
+		return nil
+	}
+	workers = min(max(workers, 1), n)
+
+	var wg sync.WaitGroup
+	next := make(chan int)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i)
+			}
+		}()
+	}
+
+	i := 0
+feed:
+	for ; i < n; i++ {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	for ; i < n; i++ {
+		notStarted = append(notStarted, i)
+	}
+	return notStarted
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteConcurrently_Bounded(t *testing.T) {
+	paths := make([]string, 100)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("wal/%04d", i)
+	}
+	var (
+		inFlight, peak atomic.Int32
+		mu             sync.Mutex
+		deleted        []string
+	)
+	err := deleteConcurrently(context.Background(), paths, 4, func(p string) error {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		deleted = append(deleted, p)
+		mu.Unlock()
+		return nil
+	})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, paths, deleted)
+	assert.LessOrEqual(t, peak.Load(), int32(4))
+	assert.Greater(t, peak.Load(), int32(1))
+}
+
+func TestDeleteConcurrently_JoinsErrors(t *testing.T) {
+	errA, errB := errors.New("a failed"), errors.New("b failed")
+	var calls atomic.Int32
+	err := deleteConcurrently(context.Background(), []string{"a", "b", "c"}, 0, func(p string) error {
+		calls.Add(1)
+		switch p {
+		case "a":
+			return errA
+		case "b":
+			return errB
+		}
+		return nil
+	})
+	assert.ErrorIs(t, err, errA)
+	assert.ErrorIs(t, err, errB)
+	assert.Equal(t, int32(3), calls.Load(), "all paths are tried")
+}
+
+func TestDeleteConcurrently_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls atomic.Int32
+	err := deleteConcurrently(ctx, []string{"a", "b", "c", "d"}, 1, func(string) error {
+		calls.Add(1)
+		cancel()
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, calls.Load(), int32(4))
+}
+
+func TestLocal_DeleteAllBulk(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	st, err := NewLocal(&LocalStorageOpts{BaseDir: dir, DeleteConcurrency: 3})
+	require.NoError(t, err)
+	var paths []string
+	for i := 0; i < 20; i++ {
+		p := fmt.Sprintf("wal/%04d", i)
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "wal"), 0o750))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, p), []byte("x"), 0o600))
+		paths = append(paths, p)
+	}
+	require.NoError(t, st.DeleteAllBulk(ctx, paths))
+	left, err := st.List(ctx, "wal")
+	require.NoError(t, err)
+	assert.Empty(t, left)
+}
+
+func TestPutBatch(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	var opened, closed atomic.Int32
+	items := []PutItem{
+		{Path: "wal/0001", Reader: strings.NewReader("one")},
+		{Path: "wal/0002", Open: func() (io.ReadCloser, error) {
+			opened.Add(1)
+			return readCloser{Reader: strings.NewReader("two!"), Closer: closerFunc(func() error {
+				closed.Add(1)
+				return nil
+			})}, nil
+		}},
+		{Path: "wal/0003", Open: func() (io.ReadCloser, error) { return nil, os.ErrPermission }},
+		{Path: "wal/0004"},
+	}
+	results, err := PutBatch(ctx, mem, items, 2)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, os.ErrPermission)
+
+	require.Len(t, results, 4)
+	assert.Equal(t, PutResult{Path: "wal/0001", Bytes: 3}, results[0])
+	assert.Equal(t, PutResult{Path: "wal/0002", Bytes: 4}, results[1])
+	assert.ErrorIs(t, results[2].Err, os.ErrPermission)
+	assert.Error(t, results[3].Err)
+	assert.Equal(t, int32(1), opened.Load())
+	assert.Equal(t, int32(1), closed.Load())
+	assert.Equal(t, []byte("two!"), mem.Files["wal/0002"])
+}
+
+func TestPutBatch_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	items := make([]PutItem, 10)
+	for i := range items {
+		items[i] = PutItem{Path: fmt.Sprintf("wal/%04d", i), Open: func() (io.ReadCloser, error) {
+			cancel()
+			return io.NopCloser(strings.NewReader("x")), nil
+		}}
+	}
+	results, err := PutBatch(ctx, NewInMemoryStorage(), items, 1)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NoError(t, results[0].Err)
+	assert.ErrorIs(t, results[9].Err, context.Canceled)
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+func TestRenameBulk(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	paths := putWAL(t, mem, 20)
+	pairs := make([]RenamePair, 0, len(paths)+1)
+	for _, p := range paths {
+		pairs = append(pairs, RenamePair{From: p, To: "promoted/" + p})
+	}
+	pairs = append(pairs, RenamePair{From: "wal/missing", To: "promoted/wal/missing"})
+
+	results, err := RenameBulk(ctx, mem, pairs, 4)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	require.Len(t, results, len(pairs))
+	for i, res := range results[:len(paths)] {
+		assert.Equal(t, RenameResult{From: paths[i], To: "promoted/" + paths[i]}, res)
+		assert.Equal(t, []byte(paths[i]), mem.Files["promoted/"+paths[i]])
+	}
+	assert.ErrorIs(t, results[len(paths)].Err, os.ErrNotExist)
+
+	left, err := mem.List(ctx, "wal")
+	require.NoError(t, err)
+	assert.Empty(t, left)
+}
@@ -0,0 +1,441 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+)
+
+// DefaultChunkDir is the backend directory where ChunkingStorage keeps
+// chunks.
+const DefaultChunkDir = ".chunks"
+
+// chunkMagic starts every stored chunk, followed by a version and a flags
+// byte.
+var chunkMagic = []byte("SCCK")
+
+const (
+	chunkVersion        = 1
+	chunkFlagCompressed = 1 << 0
+	chunkHeaderLen      = 4 + 2
+)
+
+// ErrChunkCorrupt is returned when a chunk or a chunk manifest fails to
+// authenticate, or a chunk is not the one its manifest names.
+var ErrChunkCorrupt = errors.New("chunk corrupt")
+
+// ChunkingStorage stores objects as content-defined chunks (see Chunker),
+// each encrypted once under a key derived from the master key and its
+// content. Identical regions of two objects, or of two versions of a large
+// backup, produce identical chunks with identical names and ciphertext, so
+// they are stored once and an incremental sync of the backend copies only
+// the chunks that changed.
+//
+// An object is stored at its logical path as a manifest: JSON with the
+// plaintext size and the ordered chunk IDs, authenticated with a key
+// derived from the master key. Chunks live under ChunkDir as
+// <dir>/<id>, where the ID is a keyed SHA-256 of the plaintext, and are
+// hidden from listings. The layout is the one package gc collects: run it
+// with ManifestPrefix set to a directory of objects and ChunkPrefix to
+// ChunkDir() to remove the chunks no manifest references.
+//
+// Deleting an object removes its manifest only. Chunks an object shares
+// with others are kept, and chunks nothing references are left for gc;
+// Put reuses an existing chunk without rewriting it, so give gc a grace
+// period longer than any Put.
+//
+// The manifest authenticates the content and order of an object, not its
+// name. Listings report the manifest's size, not the object's; use Stat
+// for the plaintext size.
+type ChunkingStorage struct {
+	Backend Storage
+
+	// Compressor, if set, compresses each chunk before it is encrypted;
+	// Decompressor must then be set as well.
+	Compressor   codec.Compressor
+	Decompressor codec.Decompressor
+
+	// Chunker bounds the chunk sizes. Changing it moves every boundary,
+	// so objects written before and after share no chunks.
+	Chunker ChunkerOptions
+
+	dir         string
+	idKey       []byte
+	chunkKey    []byte
+	manifestKey []byte
+}
+
+var (
+	_ Storage = &ChunkingStorage{}
+	_ Stater  = &ChunkingStorage{}
+	_ Walker  = &ChunkingStorage{}
+	_ Pinger  = &ChunkingStorage{}
+)
+
+// NewChunkingStorage creates a ChunkingStorage deriving its keys from
+// masterKey, which must be at least 32 bytes of secret key material.
+func NewChunkingStorage(backend Storage, masterKey []byte) (*ChunkingStorage, error) {
+	if len(masterKey) < 32 {
+		return nil, errors.New("chunking storage: master key must be at least 32 bytes")
+	}
+	cs := &ChunkingStorage{Backend: backend, dir: DefaultChunkDir}
+	for _, k := range []struct {
+		dst   *[]byte
+		label string
+	}{
+		{&cs.idKey, "storecrypt cdc chunk id"},
+		{&cs.chunkKey, "storecrypt cdc chunk key"},
+		{&cs.manifestKey, "storecrypt cdc manifest"},
+	} {
+		key, err := hkdf.Key(sha256.New, masterKey, nil, k.label, 32)
+		if err != nil {
+			return nil, err
+		}
+		*k.dst = key
+	}
+	return cs, nil
+}
+
+// SetChunkDir changes the backend directory holding the chunks.
+func (cs *ChunkingStorage) SetChunkDir(dir string) {
+	cs.dir = strings.Trim(dir, "/")
+}
+
+// ChunkDir returns the backend directory holding the chunks.
+func (cs *ChunkingStorage) ChunkDir() string {
+	return cs.dir
+}
+
+func (cs *ChunkingStorage) isInternal(p string) bool {
+	return hasPathPrefix(p, cs.dir)
+}
+
+// chunkManifest is what an object's path holds. Chunks is named as package
+// gc expects.
+type chunkManifest struct {
+	Version int      `json:"version"`
+	Size    int64    `json:"size"`
+	Chunks  []string `json:"chunks"`
+	MAC     string   `json:"mac"`
+}
+
+func (cs *ChunkingStorage) manifestMAC(m *chunkManifest) string {
+	mac := hmac.New(sha256.New, cs.manifestKey)
+	mac.Write([]byte(strconv.Itoa(m.Version) + "\n" + strconv.FormatInt(m.Size, 10) + "\n"))
+	for _, id := range m.Chunks {
+		mac.Write([]byte(id + "\n"))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// chunkID names a chunk by a keyed digest of its plaintext, so that names
+// reveal nothing to whoever lacks the master key.
+func (cs *ChunkingStorage) chunkID(data []byte) string {
+	mac := hmac.New(sha256.New, cs.idKey)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// chunkAEAD returns the cipher of one chunk. Its key is derived from the
+// chunk ID and so used for that one plaintext only, which is what makes a
+// fixed nonce safe and the ciphertext deterministic.
+func (cs *ChunkingStorage) chunkAEAD(id string) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, cs.chunkKey)
+	mac.Write([]byte(id))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (cs *ChunkingStorage) chunkPath(id string) string {
+	return path.Join(cs.dir, id)
+}
+
+// Put splits r into chunks, stores the ones the backend does not have yet
+// and writes the manifest at remotePath.
+func (cs *ChunkingStorage) Put(ctx context.Context, remotePath string, r io.Reader) error {
+	ch, err := NewChunker(r, cs.Chunker)
+	if err != nil {
+		return err
+	}
+	m := &chunkManifest{Version: chunkVersion, Chunks: []string{}}
+	for {
+		data, err := ch.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		id := cs.chunkID(data)
+		if err := cs.putChunk(ctx, id, data); err != nil {
+			return fmt.Errorf("put chunk %s of %q: %w", id, remotePath, err)
+		}
+		m.Chunks = append(m.Chunks, id)
+		m.Size += int64(len(data))
+	}
+	m.MAC = cs.manifestMAC(m)
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return cs.Backend.Put(withoutPutSizeHint(ctx), remotePath, bytes.NewReader(body))
+}
+
+func (cs *ChunkingStorage) putChunk(ctx context.Context, id string, data []byte) error {
+	ok, err := cs.Backend.Exists(ctx, cs.chunkPath(id))
+	if err != nil || ok {
+		return err
+	}
+	hdr := append(append([]byte(nil), chunkMagic...), chunkVersion, 0)
+	if cs.Compressor != nil {
+		var buf bytes.Buffer
+		w, err := cs.Compressor.NewWriter(&buf)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+		hdr[len(hdr)-1] |= chunkFlagCompressed
+	}
+	aead, err := cs.chunkAEAD(id)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	sealed := aead.Seal(hdr, nonce, data, append(hdr[:chunkHeaderLen:chunkHeaderLen], id...))
+	return cs.Backend.Put(withoutPutSizeHint(ctx), cs.chunkPath(id), bytes.NewReader(sealed))
+}
+
+// getChunk reads, decrypts and checks one chunk.
+func (cs *ChunkingStorage) getChunk(ctx context.Context, id string) ([]byte, error) {
+	rc, err := cs.Backend.Get(ctx, cs.chunkPath(id))
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := io.ReadAll(rc)
+	if closeErr := rc.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < chunkHeaderLen || !bytes.HasPrefix(sealed, chunkMagic) || sealed[4] != chunkVersion {
+		return nil, fmt.Errorf("%w: chunk %s: bad header", ErrChunkCorrupt, id)
+	}
+	hdr := sealed[:chunkHeaderLen]
+	aead, err := cs.chunkAEAD(id)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	data, err := aead.Open(nil, nonce, sealed[chunkHeaderLen:], append(hdr[:chunkHeaderLen:chunkHeaderLen], id...))
+	if err != nil {
+		return nil, fmt.Errorf("%w: chunk %s: %v", ErrChunkCorrupt, id, err)
+	}
+	if hdr[5]&chunkFlagCompressed != 0 {
+		if cs.Decompressor == nil {
+			return nil, fmt.Errorf("chunk %s is compressed and no decompressor is configured", id)
+		}
+		zr, err := cs.Decompressor.Decompress(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		data, err = io.ReadAll(zr)
+		if closeErr := zr.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cs.chunkID(data) != id {
+		return nil, fmt.Errorf("%w: chunk %s: content does not match its id", ErrChunkCorrupt, id)
+	}
+	return data, nil
+}
+
+// readManifest reads and authenticates the manifest at remotePath.
+func (cs *ChunkingStorage) readManifest(ctx context.Context, remotePath string) (*chunkManifest, error) {
+	rc, err := cs.Backend.Get(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var m chunkManifest
+	if err := json.NewDecoder(rc).Decode(&m); err != nil {
+		return nil, fmt.Errorf("%w: manifest %q: %v", ErrChunkCorrupt, remotePath, err)
+	}
+	if m.Version != chunkVersion || !hmac.Equal([]byte(m.MAC), []byte(cs.manifestMAC(&m))) {
+		return nil, fmt.Errorf("%w: manifest %q does not authenticate", ErrChunkCorrupt, remotePath)
+	}
+	return &m, nil
+}
+
+// Chunks returns the IDs of the chunks remotePath is made of, in order.
+// Two objects, or two versions of one, share content exactly where they
+// share chunk IDs.
+func (cs *ChunkingStorage) Chunks(ctx context.Context, remotePath string) ([]string, error) {
+	m, err := cs.readManifest(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	return m.Chunks, nil
+}
+
+// Get streams the object, fetching one chunk at a time.
+func (cs *ChunkingStorage) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	m, err := cs.readManifest(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkReader{ctx: ctx, cs: cs, path: remotePath, m: m}, nil
+}
+
+// chunkReader concatenates the chunks of a manifest.
+type chunkReader struct {
+	ctx  context.Context
+	cs   *ChunkingStorage
+	path string
+	m    *chunkManifest
+	next int    // index of the next chunk to fetch
+	cur  []byte // unread part of the current chunk
+	read int64
+	err  error
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	for len(cr.cur) == 0 {
+		if cr.err != nil {
+			return 0, cr.err
+		}
+		if cr.next == len(cr.m.Chunks) {
+			cr.err = io.EOF
+			if cr.read != cr.m.Size {
+				cr.err = fmt.Errorf("%w: %q: read %d bytes, manifest says %d", ErrChunkCorrupt, cr.path, cr.read, cr.m.Size)
+			}
+			continue
+		}
+		data, err := cr.cs.getChunk(cr.ctx, cr.m.Chunks[cr.next])
+		if err != nil {
+			cr.err = fmt.Errorf("read %q: %w", cr.path, err)
+			continue
+		}
+		cr.next++
+		cr.cur = data
+	}
+	n := copy(p, cr.cur)
+	cr.cur = cr.cur[n:]
+	cr.read += int64(n)
+	return n, nil
+}
+
+func (cr *chunkReader) Close() error {
+	cr.cur, cr.err = nil, errors.New("read of closed chunk reader")
+	return nil
+}
+
+func (cs *ChunkingStorage) List(ctx context.Context, remotePath string) ([]string, error) {
+	files, err := cs.Backend.List(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	result := files[:0]
+	for _, f := range files {
+		if !cs.isInternal(f) {
+			result = append(result, f)
+		}
+	}
+	return result, nil
+}
+
+func (cs *ChunkingStorage) ListInfo(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	return collectInfo(ctx, cs, remotePath)
+}
+
+func (cs *ChunkingStorage) WalkInfo(ctx context.Context, remotePath string, fn func(fi FileInfo) error) error {
+	return WalkInfo(ctx, cs.Backend, remotePath, func(fi FileInfo) error {
+		if cs.isInternal(fi.Path) {
+			return nil
+		}
+		return fn(fi)
+	})
+}
+
+// Stat reports the plaintext size recorded in the manifest.
+func (cs *ChunkingStorage) Stat(ctx context.Context, remotePath string) (FileInfo, error) {
+	fi, err := StatObject(ctx, cs.Backend, remotePath)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	m, err := cs.readManifest(ctx, remotePath)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	fi.StoredSize = fi.Size
+	fi.Size = m.Size
+	return fi, nil
+}
+
+func (cs *ChunkingStorage) Delete(ctx context.Context, remotePath string) error {
+	return cs.Backend.Delete(ctx, remotePath)
+}
+
+func (cs *ChunkingStorage) DeleteAll(ctx context.Context, remotePath string) error {
+	return cs.Backend.DeleteAll(ctx, remotePath)
+}
+
+func (cs *ChunkingStorage) DeleteDir(ctx context.Context, remotePath string) error {
+	return cs.Backend.DeleteDir(ctx, remotePath)
+}
+
+func (cs *ChunkingStorage) DeleteAllBulk(ctx context.Context, paths []string) error {
+	return cs.Backend.DeleteAllBulk(ctx, paths)
+}
+
+func (cs *ChunkingStorage) Exists(ctx context.Context, remotePath string) (bool, error) {
+	return cs.Backend.Exists(ctx, remotePath)
+}
+
+func (cs *ChunkingStorage) ListTopLevelDirs(ctx context.Context, prefix string) (map[string]bool, error) {
+	dirs, err := cs.Backend.ListTopLevelDirs(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	for d := range dirs {
+		if cs.isInternal(d) {
+			delete(dirs, d)
+		}
+	}
+	return dirs, nil
+}
+
+// Rename renames the manifest; the chunks stay where they are.
+func (cs *ChunkingStorage) Rename(ctx context.Context, oldRemotePath, newRemotePath string) error {
+	return cs.Backend.Rename(ctx, oldRemotePath, newRemotePath)
+}
+
+func (cs *ChunkingStorage) Ping(ctx context.Context) error {
+	return Ping(ctx, cs.Backend)
+}
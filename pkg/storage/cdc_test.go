@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testMasterKey = bytes.Repeat([]byte{0x42}, 32)
+
+func newTestChunking(t *testing.T, backend Storage) *ChunkingStorage {
+	t.Helper()
+	cs, err := NewChunkingStorage(backend, testMasterKey)
+	require.NoError(t, err)
+	cs.Chunker = smallChunks
+	return cs
+}
+
+func chunkKeys(mem *InMemoryStorage) []string {
+	var ks []string
+	for k := range mem.Files {
+		if strings.HasPrefix(k, DefaultChunkDir+"/") {
+			ks = append(ks, k)
+		}
+	}
+	return ks
+}
+
+func TestChunkingStorage_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	cs := newTestChunking(t, mem)
+
+	for _, size := range []int{0, 10, 100 << 10} {
+		data := randomBytes(int64(size), size)
+		require.NoError(t, cs.Put(ctx, "obj", bytes.NewReader(data)))
+
+		rc, err := cs.Get(ctx, "obj")
+		require.NoError(t, err)
+		got, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		assert.Equal(t, data, got, "size %d", size)
+
+		fi, err := cs.Stat(ctx, "obj")
+		require.NoError(t, err)
+		assert.Equal(t, int64(size), fi.Size)
+	}
+
+	names, err := cs.List(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"obj"}, names, "chunks are hidden")
+	dirs, err := cs.ListTopLevelDirs(ctx, "")
+	require.NoError(t, err)
+	assert.Empty(t, dirs)
+}
+
+func TestChunkingStorage_Dedup(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	cs := newTestChunking(t, mem)
+
+	v1 := randomBytes(3, 512<<10)
+	v2 := append(append(append([]byte(nil), v1[:200_000]...), "a small edit"...), v1[200_000:]...)
+	require.NoError(t, cs.Put(ctx, "backup/v1", bytes.NewReader(v1)))
+	stored := len(chunkKeys(mem))
+	require.NoError(t, cs.Put(ctx, "backup/v2", bytes.NewReader(v2)))
+	added := len(chunkKeys(mem)) - stored
+	assert.LessOrEqual(t, added, 3, "only the chunks around the edit are new")
+
+	ids1, err := cs.Chunks(ctx, "backup/v1")
+	require.NoError(t, err)
+	ids2, err := cs.Chunks(ctx, "backup/v2")
+	require.NoError(t, err)
+	assert.Equal(t, ids1[0], ids2[0])
+	assert.Equal(t, ids1[len(ids1)-1], ids2[len(ids2)-1])
+
+	// Deterministic: another storage with the same key stores the same bytes.
+	other := NewInMemoryStorage()
+	require.NoError(t, newTestChunking(t, other).Put(ctx, "x", bytes.NewReader(v1)))
+	for _, id := range ids1 {
+		p := path.Join(DefaultChunkDir, id)
+		assert.Equal(t, mem.Files[p], other.Files[p])
+	}
+
+	// The manifest is what package gc parses.
+	var m struct {
+		Chunks []string `json:"chunks"`
+	}
+	require.NoError(t, json.Unmarshal(mem.Files["backup/v1"], &m))
+	assert.Equal(t, ids1, m.Chunks)
+}
+
+func TestChunkingStorage_Compression(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	cs := newTestChunking(t, mem)
+	cs.Compressor = codec.GzipCompressor{}
+	cs.Decompressor = codec.GzipDecompressor{}
+
+	data := bytes.Repeat([]byte("compressible "), 10_000)
+	require.NoError(t, cs.Put(ctx, "obj", bytes.NewReader(data)))
+	assert.Equal(t, string(data), readAll(t, cs, "obj"))
+	var stored int
+	for _, k := range chunkKeys(mem) {
+		stored += len(mem.Files[k])
+	}
+	assert.Less(t, stored, len(data)/10)
+}
+
+func TestChunkingStorage_Tampering(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	cs := newTestChunking(t, mem)
+	require.NoError(t, cs.Put(ctx, "obj", bytes.NewReader(randomBytes(4, 64<<10))))
+
+	ids, err := cs.Chunks(ctx, "obj")
+	require.NoError(t, err)
+	sealed := mem.Files[path.Join(DefaultChunkDir, ids[1])]
+	sealed[len(sealed)/2] ^= 1
+	rc, err := cs.Get(ctx, "obj")
+	require.NoError(t, err)
+	_, err = io.ReadAll(rc)
+	assert.ErrorIs(t, err, ErrChunkCorrupt)
+
+	mem.Files["obj"] = bytes.Replace(mem.Files["obj"], []byte(`"size":`), []byte(`"size":1`), 1)
+	_, err = cs.Get(ctx, "obj")
+	assert.ErrorIs(t, err, ErrChunkCorrupt)
+
+	wrongKey := bytes.Repeat([]byte{0x43}, 32)
+	other, err := NewChunkingStorage(mem, wrongKey)
+	require.NoError(t, err)
+	require.NoError(t, cs.Put(ctx, "obj", bytes.NewReader([]byte("data"))))
+	_, err = other.Get(ctx, "obj")
+	assert.ErrorIs(t, err, ErrChunkCorrupt)
+
+	_, err = NewChunkingStorage(mem, []byte("short"))
+	assert.Error(t, err)
+}
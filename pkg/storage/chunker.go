@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+const (
+	DefaultChunkMinSize = 256 << 10
+	DefaultChunkAvgSize = 1 << 20
+	DefaultChunkMaxSize = 4 << 20
+)
+
+// ChunkerOptions bound the chunks a Chunker cuts. Zero fields take the
+// defaults above. AvgSize must be a power of two.
+type ChunkerOptions struct {
+	MinSize int
+	AvgSize int
+	MaxSize int
+}
+
+func (o ChunkerOptions) withDefaults() (ChunkerOptions, error) {
+	if o.MinSize == 0 {
+		o.MinSize = DefaultChunkMinSize
+	}
+	if o.AvgSize == 0 {
+		o.AvgSize = DefaultChunkAvgSize
+	}
+	if o.MaxSize == 0 {
+		o.MaxSize = DefaultChunkMaxSize
+	}
+	switch {
+	case o.AvgSize < 64 || o.AvgSize&(o.AvgSize-1) != 0:
+		return o, fmt.Errorf("chunker: average size %d is not a power of two >= 64", o.AvgSize)
+	case o.MinSize < 0 || o.MinSize > o.AvgSize || o.AvgSize > o.MaxSize:
+		return o, fmt.Errorf("chunker: sizes must satisfy 0 <= min (%d) <= avg (%d) <= max (%d)",
+			o.MinSize, o.AvgSize, o.MaxSize)
+	}
+	return o, nil
+}
+
+// gearTable holds the random values of the gear rolling hash. Chunk
+// boundaries, and so deduplication across versions, depend on it: it is
+// part of the stored format and must never change.
+var gearTable = func() (t [256]uint64) {
+	for i := range t {
+		sum := sha256.Sum256([]byte{'s', 'c', 'c', 'd', 'c', byte(i)})
+		t[i] = binary.LittleEndian.Uint64(sum[:8])
+	}
+	return t
+}()
+
+// Chunker splits a stream at content-defined boundaries (FastCDC with
+// normalized chunking): a boundary is where a rolling hash of the last 64
+// bytes matches a mask, so inserting or removing bytes only moves the
+// boundaries next to the edit, and the chunks of the unchanged regions of
+// two versions of a file come out identical.
+type Chunker struct {
+	r            io.Reader
+	opts         ChunkerOptions
+	maskS, maskL uint64 // before and after AvgSize
+	buf          []byte
+	start, end   int
+	eof          bool
+}
+
+// NewChunker creates a Chunker reading from r.
+func NewChunker(r io.Reader, opts ChunkerOptions) (*Chunker, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	// Normalized chunking: a harder mask below the average size and an
+	// easier one above it keep chunk sizes close to the average.
+	avgBits := bits.Len(uint(opts.AvgSize)) - 1
+	return &Chunker{
+		r:     r,
+		opts:  opts,
+		maskS: ^uint64(0) << (64 - (avgBits + 1)),
+		maskL: ^uint64(0) << (64 - (avgBits - 1)),
+		buf:   make([]byte, opts.MaxSize),
+	}, nil
+}
+
+// Next returns the next chunk, or io.EOF after the last one. The chunk is
+// only valid until the next call.
+func (c *Chunker) Next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+	n := c.cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, nil
+}
+
+// fill tops the buffer up to MaxSize bytes, or to the end of the stream.
+func (c *Chunker) fill() error {
+	if c.eof || c.end-c.start == len(c.buf) {
+		return nil
+	}
+	c.end = copy(c.buf, c.buf[c.start:c.end])
+	c.start = 0
+	for c.end < len(c.buf) {
+		n, err := c.r.Read(c.buf[c.end:])
+		c.end += n
+		if errors.Is(err, io.EOF) {
+			c.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cut returns the length of the chunk at the start of data.
+func (c *Chunker) cut(data []byte) int {
+	n := len(data)
+	if n <= c.opts.MinSize {
+		return n
+	}
+	normal := min(c.opts.AvgSize, n)
+	var h uint64
+	i := c.opts.MinSize
+	for ; i < normal; i++ {
+		h = h<<1 + gearTable[data[i]]
+		if h&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		h = h<<1 + gearTable[data[i]]
+		if h&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
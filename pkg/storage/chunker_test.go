@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var smallChunks = ChunkerOptions{MinSize: 1 << 10, AvgSize: 4 << 10, MaxSize: 16 << 10}
+
+func randomBytes(seed int64, n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(b)
+	return b
+}
+
+func chunksOf(t *testing.T, data []byte, opts ChunkerOptions) [][]byte {
+	t.Helper()
+	ch, err := NewChunker(bytes.NewReader(data), opts)
+	require.NoError(t, err)
+	var chunks [][]byte
+	for {
+		c, err := ch.Next()
+		if errors.Is(err, io.EOF) {
+			return chunks
+		}
+		require.NoError(t, err)
+		chunks = append(chunks, bytes.Clone(c))
+	}
+}
+
+func TestChunker_Bounds(t *testing.T) {
+	data := randomBytes(1, 1<<20)
+	chunks := chunksOf(t, data, smallChunks)
+	assert.Equal(t, data, bytes.Join(chunks, nil))
+	for i, c := range chunks {
+		assert.LessOrEqual(t, len(c), smallChunks.MaxSize)
+		if i < len(chunks)-1 {
+			assert.Greater(t, len(c), smallChunks.MinSize)
+		}
+	}
+	avg := len(data) / len(chunks)
+	assert.Greater(t, avg, smallChunks.AvgSize/2, "average chunk size")
+	assert.Less(t, avg, smallChunks.AvgSize*2, "average chunk size")
+
+	assert.Empty(t, chunksOf(t, nil, smallChunks))
+}
+
+func TestChunker_BoundariesFollowContent(t *testing.T) {
+	data := randomBytes(2, 1<<20)
+	edited := append(append(append([]byte(nil), data[:300_000]...), "inserted bytes"...), data[300_000:]...)
+
+	before := map[string]bool{}
+	for _, c := range chunksOf(t, data, smallChunks) {
+		before[string(c)] = true
+	}
+	after := chunksOf(t, edited, smallChunks)
+	shared := 0
+	for _, c := range after {
+		if before[string(c)] {
+			shared++
+		}
+	}
+	assert.GreaterOrEqual(t, shared, len(after)-3, "only the chunks around the edit change")
+}
+
+func TestChunker_Options(t *testing.T) {
+	for _, opts := range []ChunkerOptions{
+		{AvgSize: 3000},
+		{MinSize: 8 << 10, AvgSize: 4 << 10, MaxSize: 16 << 10},
+		{MinSize: 1 << 10, AvgSize: 4 << 10, MaxSize: 2 << 10},
+	} {
+		_, err := NewChunker(bytes.NewReader(nil), opts)
+		assert.Error(t, err, "%+v", opts)
+	}
+}
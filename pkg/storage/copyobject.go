@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"io"
+)
+
+// CopyObject copies rc, as returned by Get, to dst and closes it. The
+// error of Close is returned when the copy succeeded: it is how integrity
+// failures detected at the end of the stream (a digest trailer, a last
+// authentication tag) are reported.
+func CopyObject(dst io.Writer, rc io.ReadCloser) (int64, error) {
+	var n int64
+	err := ConsumeObject(rc, func(r io.Reader) error {
+		var err error
+		n, err = io.Copy(dst, r)
+		return err
+	})
+	return n, err
+}
+
+// ConsumeObject passes rc, as returned by Get, to fn and closes it,
+// returning the error of Close like CopyObject when fn succeeded. It is
+// for consumers that are not an io.Writer, such as Put on another
+// storage.
+func ConsumeObject(rc io.ReadCloser, fn func(r io.Reader) error) error {
+	err := fn(rc)
+	if closeErr := rc.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// closeErrReader fails on Close, like a stream whose trailer does not
+// verify.
+type closeErrReader struct {
+	io.Reader
+	err    error
+	closed bool
+}
+
+func (r *closeErrReader) Close() error {
+	r.closed = true
+	return r.err
+}
+
+func TestCopyObject(t *testing.T) {
+	errTrailer := errors.New("trailer mismatch")
+
+	rc := &closeErrReader{Reader: strings.NewReader("data")}
+	var buf bytes.Buffer
+	n, err := CopyObject(&buf, rc)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), n)
+	assert.Equal(t, "data", buf.String())
+	assert.True(t, rc.closed)
+
+	rc = &closeErrReader{Reader: strings.NewReader("data"), err: errTrailer}
+	_, err = CopyObject(io.Discard, rc)
+	require.ErrorIs(t, err, errTrailer)
+
+	// The error of the consumer takes precedence.
+	errPut := errors.New("put failed")
+	rc = &closeErrReader{Reader: strings.NewReader("data"), err: errTrailer}
+	err = ConsumeObject(rc, func(io.Reader) error { return errPut })
+	require.ErrorIs(t, err, errPut)
+	assert.True(t, rc.closed)
+}
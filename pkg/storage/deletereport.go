@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// DeleteFailure is an object, or a directory, DeleteAll left behind.
+type DeleteFailure struct {
+	Path string
+	Err  error
+}
+
+// DeleteReport is the outcome of DeleteAllReport.
+type DeleteReport struct {
+	// Deleted counts the objects removed. On a versioned S3 bucket every
+	// object version and delete marker counts.
+	Deleted int
+	Failed  []DeleteFailure
+
+	// Held lists the objects kept because they are under legal hold (see
+	// HoldStorage). They are not failures.
+	Held []string
+}
+
+func (r *DeleteReport) fail(path string, err error) {
+	r.Failed = append(r.Failed, DeleteFailure{Path: path, Err: err})
+}
+
+// Err joins the failures, or returns nil if there were none.
+func (r DeleteReport) Err() error {
+	errs := make([]error, len(r.Failed))
+	for i, f := range r.Failed {
+		errs[i] = fmt.Errorf("delete %q: %w", f.Path, f.Err)
+	}
+	return errors.Join(errs...)
+}
+
+// DeleteAllReporter is implemented by backends (and wrappers) that can
+// remove what lies below a path one object at a time and tell what they
+// removed.
+type DeleteAllReporter interface {
+	// DeleteAllReport removes everything below remotePath, like
+	// DeleteAll, but goes on past objects that fail and reports them.
+	// The error joins the failures and, if the run stopped early (ctx
+	// done, the listing failed), what stopped it.
+	DeleteAllReport(ctx context.Context, remotePath string) (DeleteReport, error)
+}
+
+// DeleteAllReport removes everything below remotePath and reports what
+// was removed and what was left behind, so that a retention job can retry
+// exactly the failures. It uses DeleteAllReporter when st implements it
+// and otherwise walks st and deletes each object with st.Delete, then
+// calls st.DeleteAll to remove what is left once every object is gone.
+func DeleteAllReport(ctx context.Context, st Storage, remotePath string) (DeleteReport, error) {
+	if r, ok := st.(DeleteAllReporter); ok {
+		return r.DeleteAllReport(ctx, remotePath)
+	}
+
+	var report DeleteReport
+	var paths []string
+	err := WalkInfo(ctx, st, remotePath, func(fi FileInfo) error {
+		if !fi.IsDir {
+			paths = append(paths, fi.Path)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	for _, p := range paths {
+		if err := ctx.Err(); err != nil {
+			return report, errors.Join(err, report.Err())
+		}
+		err := st.Delete(ctx, p)
+		switch {
+		case err == nil:
+			report.Deleted++
+		case !errors.Is(err, fs.ErrNotExist):
+			report.fail(p, err)
+		}
+	}
+	if len(report.Failed) == 0 {
+		if err := st.DeleteAll(ctx, remotePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			report.fail(remotePath, err)
+		}
+	}
+	return report, report.Err()
+}
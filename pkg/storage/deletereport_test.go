@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deleteFailer fails Delete for the configured paths. Embedding Storage
+// hides the optional interfaces of the backend, so DeleteAllReport takes
+// its fallback.
+type deleteFailer struct {
+	Storage
+	fail map[string]bool
+}
+
+func (f *deleteFailer) Delete(ctx context.Context, path string) error {
+	if f.fail[path] {
+		return errors.New("boom")
+	}
+	return f.Storage.Delete(ctx, path)
+}
+
+func TestDeleteAllReport_ContinuesPastFailures(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	for _, name := range []string{"wal/1", "wal/2", "wal/sub/3", "keep"} {
+		require.NoError(t, mem.Put(ctx, name, strings.NewReader(name)))
+	}
+	st := &deleteFailer{Storage: mem, fail: map[string]bool{"wal/2": true}}
+
+	report, err := DeleteAllReport(ctx, st, "wal")
+	require.Error(t, err)
+	assert.Equal(t, 2, report.Deleted)
+	require.Len(t, report.Failed, 1)
+	assert.Equal(t, "wal/2", report.Failed[0].Path)
+	assert.ElementsMatch(t, []string{"keep", "wal/2"}, keys(mem.Files))
+
+	st.fail = nil
+	report, err = DeleteAllReport(ctx, st, "wal")
+	require.NoError(t, err)
+	assert.Equal(t, DeleteReport{Deleted: 1}, report)
+	assert.Equal(t, []string{"keep"}, keys(mem.Files))
+}
+
+func TestDeleteAllReport_Prefix(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	p := NewPrefixStorage(&deleteFailer{Storage: mem, fail: map[string]bool{"t/wal/2": true}}, "t")
+	for _, name := range []string{"wal/1", "wal/2"} {
+		require.NoError(t, p.Put(ctx, name, strings.NewReader(name)))
+	}
+
+	report, err := DeleteAllReport(ctx, p, "wal")
+	require.Error(t, err)
+	assert.Equal(t, 1, report.Deleted)
+	require.Len(t, report.Failed, 1)
+	assert.Equal(t, "wal/2", report.Failed[0].Path, "failures are reported in the caller's paths")
+}
+
+func TestLocal_DeleteAllReport(t *testing.T) {
+	ctx := context.Background()
+	base := t.TempDir()
+	st, err := NewLocal(&LocalStorageOpts{BaseDir: base})
+	require.NoError(t, err)
+	for _, name := range []string{"wal/1", "wal/a/2", "wal/a/b/3", "keep"} {
+		require.NoError(t, st.Put(ctx, name, strings.NewReader(name)))
+	}
+
+	report, err := DeleteAllReport(ctx, st, "wal")
+	require.NoError(t, err)
+	assert.Equal(t, DeleteReport{Deleted: 3}, report)
+
+	entries, err := os.ReadDir(filepath.Join(base, "wal"))
+	require.NoError(t, err)
+	assert.Empty(t, entries, "emptied directories are removed, the directory itself is kept")
+	ok, err := st.Exists(ctx, "keep")
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
@@ -3,11 +3,16 @@ package storage
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/hashmap-kz/storecrypt/pkg/codecs"
 	"github.com/hashmap-kz/streamcrypt/pkg/codec"
 	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
 	"github.com/hashmap-kz/streamcrypt/pkg/pipe"
@@ -17,32 +22,293 @@ import (
 type CodecPair struct {
 	Compressor   codec.Compressor
 	Decompressor codec.Decompressor
+
+	// Level, if not zero, is the compression level for writes. The
+	// Compressor must implement LeveledCompressor (the codecs in
+	// pkg/codecs do) or be the stock streamcrypt gzip or zstd compressor,
+	// which is replaced by its pkg/codecs equivalent; NewVariadicStorage
+	// applies and validates it.
+	Level int
+}
+
+// LeveledCompressor is implemented by compressors with a tunable level.
+type LeveledCompressor interface {
+	codec.Compressor
+	WithLevel(level int) (codec.Compressor, error)
+}
+
+// CodecValidator is implemented by compressors and decompressors that can
+// check their settings up front, so that a bad level or window size fails
+// NewVariadicStorage instead of the first Put.
+type CodecValidator interface {
+	Validate() error
+}
+
+// resolve returns a copy of the pair with Level applied, after validating
+// both halves.
+func (p *CodecPair) resolve() (*CodecPair, error) {
+	out := *p
+	if out.Compressor == nil || out.Decompressor == nil {
+		return nil, errors.New("compressor and decompressor are required")
+	}
+	if out.Level != 0 {
+		c, err := withLevel(out.Compressor, out.Level)
+		if err != nil {
+			return nil, err
+		}
+		out.Compressor = c
+	}
+	for _, v := range []any{out.Compressor, out.Decompressor} {
+		if cv, ok := v.(CodecValidator); ok {
+			if err := cv.Validate(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &out, nil
+}
+
+// withLevel returns c set to compress at level. The stock streamcrypt
+// compressors have no level; they write the same format as the pkg/codecs
+// ones, which do.
+func withLevel(c codec.Compressor, level int) (codec.Compressor, error) {
+	switch c.(type) {
+	case codec.GzipCompressor, *codec.GzipCompressor:
+		c = codecs.GzipCompressor{}
+	case codec.ZstdCompressor, *codec.ZstdCompressor:
+		c = codecs.ZstdCompressor{}
+	}
+	lc, ok := c.(LeveledCompressor)
+	if !ok {
+		return nil, fmt.Errorf("compressor %T does not support levels", c)
+	}
+	return lc.WithLevel(level)
 }
 
 // Algorithms are where you plug in concrete implementations.
-// The variants (plain, .gz, .zst, .gz.aes, .zst.aes, .aes) are
-// defined statically in this file.
+// The variants (plain, .gz, .zst, .lz4, .xz, .br, each optionally
+// followed by .aes, and .aes alone) are defined statically in this file.
 type Algorithms struct {
-	Gzip *CodecPair    // nil if gzip is not configured
-	Zstd *CodecPair    // nil if zstd is not configured
-	AES  crypt.Crypter // nil if AES is not configured
+	Gzip   *CodecPair    // nil if gzip is not configured
+	Zstd   *CodecPair    // nil if zstd is not configured
+	Lz4    *CodecPair    // nil if lz4 is not configured
+	Xz     *CodecPair    // nil if xz is not configured
+	Brotli *CodecPair    // nil if brotli is not configured
+	AES    crypt.Crypter // nil if AES is not configured
+
+	// Crypters holds additional crypters keyed by the extension they are
+	// stored under, e.g. {".age": ageCrypter}. Each extension may follow
+	// any compression extension exactly like ".aes", so archives written
+	// by different tools can be read through a single VariadicStorage.
+	Crypters map[string]crypt.Crypter
+}
+
+// resolve validates every configured codec pair and applies its Level.
+// The caller's pairs are not modified.
+func (a *Algorithms) resolve() error {
+	for _, c := range []struct {
+		ext  string
+		pair **CodecPair
+	}{
+		{".gz", &a.Gzip},
+		{".zst", &a.Zstd},
+		{".lz4", &a.Lz4},
+		{".xz", &a.Xz},
+		{".br", &a.Brotli},
+	} {
+		if *c.pair == nil {
+			continue
+		}
+		resolved, err := (*c.pair).resolve()
+		if err != nil {
+			return fmt.Errorf("%s codec: %w", c.ext, err)
+		}
+		*c.pair = resolved
+	}
+	return nil
+}
+
+// namedCrypter binds an encryption extension to its crypter.
+type namedCrypter struct {
+	ext     string
+	crypter crypt.Crypter
+}
+
+// crypters returns the configured crypters in lookup priority order:
+// ".aes" first, then the additional ones sorted by extension.
+func (a *Algorithms) crypters() []namedCrypter {
+	var result []namedCrypter
+	if a.AES != nil {
+		result = append(result, namedCrypter{".aes", a.AES})
+	}
+	exts := make([]string, 0, len(a.Crypters))
+	for ext, c := range a.Crypters {
+		if c != nil && ext != "" && ext != ".aes" {
+			exts = append(exts, ext)
+		}
+	}
+	sort.Strings(exts)
+	for _, ext := range exts {
+		result = append(result, namedCrypter{ext, a.Crypters[ext]})
+	}
+	return result
+}
+
+// namedCodec binds a compression extension to its configured pair.
+type namedCodec struct {
+	ext  string
+	pair *CodecPair
+}
+
+// codecs returns the configured compression codecs in lookup priority
+// order.
+func (a *Algorithms) codecs() []namedCodec {
+	all := []namedCodec{
+		{".gz", a.Gzip},
+		{".zst", a.Zstd},
+		{".lz4", a.Lz4},
+		{".xz", a.Xz},
+		{".br", a.Brotli},
+	}
+	result := all[:0]
+	for _, c := range all {
+		if c.pair != nil {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// codec returns the pair configured for name, an extension without the
+// dot as recorded in object headers ("gz"), or nil.
+func (a *Algorithms) codec(name string) *CodecPair {
+	if a == nil {
+		return nil
+	}
+	for _, c := range a.codecs() {
+		if c.ext == "."+name {
+			return c.pair
+		}
+	}
+	return nil
+}
+
+// crypter returns the crypter configured for name, an extension without
+// the dot as recorded in object headers ("aes"), or nil.
+func (a *Algorithms) crypter(name string) crypt.Crypter {
+	if a == nil {
+		return nil
+	}
+	for _, c := range a.crypters() {
+		if c.ext == "."+name {
+			return c.crypter
+		}
+	}
+	return nil
+}
+
+// ErrAmbiguousVariant is matched (via errors.Is) by *AmbiguousVariantError.
+var ErrAmbiguousVariant = errors.New("ambiguous variant")
+
+// AmbiguousVariantError is returned in strict mode when more than one
+// physical variant exists for a logical path.
+type AmbiguousVariantError struct {
+	Path     string
+	Variants []string // stored names, in priority order
+}
+
+func (e *AmbiguousVariantError) Error() string {
+	return fmt.Sprintf("ambiguous variant for %q: %s", e.Path, strings.Join(e.Variants, ", "))
+}
+
+func (e *AmbiguousVariantError) Unwrap() error {
+	return ErrAmbiguousVariant
+}
+
+// VariantError reports an operation that failed on one physical variant of
+// a logical path. Delete, Rename, DeleteAll, DeleteDir and DeleteAllBulk
+// keep going after a failure and return one VariantError per failed
+// variant, joined with errors.Join; use errors.As on each joined error, or
+// VariantErrors, to retry exactly the variants that were left behind.
+type VariantError struct {
+	Op     string // "delete" or "rename"
+	Path   string // logical path
+	Stored string // physical name, including extensions
+	Err    error
+}
+
+func (e *VariantError) Error() string {
+	return fmt.Sprintf("%s variant %q of %q: %v", e.Op, e.Stored, e.Path, e.Err)
+}
+
+func (e *VariantError) Unwrap() error {
+	return e.Err
+}
+
+// VariantErrors returns the VariantErrors in err, which may be one joined
+// by errors.Join or wrapped by fmt.Errorf, in order.
+func VariantErrors(err error) []*VariantError {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case *VariantError:
+		return []*VariantError{e}
+	case interface{ Unwrap() []error }:
+		var out []*VariantError
+		for _, inner := range e.Unwrap() {
+			out = append(out, VariantErrors(inner)...)
+		}
+		return out
+	default:
+		return VariantErrors(errors.Unwrap(err))
+	}
 }
 
 // VariadicStorage is a storage wrapper that:
 //
-//   - Writes objects using a single configured extension (writeExt).
+//   - Writes objects using a single configured extension (writeExt),
+//     optionally overridden per path pattern (see SetWriteRules).
 //   - Reads objects by trying all known variants (extensions) for a
 //     given base path and decoding based solely on the found extension.
 //
 // Callers always work with *logical* names (no transform extensions),
 // e.g. "000000010000000000000001".
 type VariadicStorage struct {
-	Backend  Storage
+	Backend Storage
+
+	// Strict makes Get and Exists fail with *AmbiguousVariantError when
+	// several variants of a logical path exist, instead of silently
+	// picking the highest-priority one (which may be stale). Migrate
+	// collapses duplicates onto an existing writeExt variant.
+	Strict bool
+
+	// Resolve selects how stored variants are looked up (see
+	// ResolveStrategy); the zero value probes extensions one by one.
+	Resolve ResolveStrategy
+
+	// DedupListInfo makes ListInfo return a single entry per logical path,
+	// backed by the variant Get would pick, instead of one per variant.
+	DedupListInfo bool
+
+	// WriteHeader makes Put write a self-describing ObjectHeader in front
+	// of every object, so it can be decoded even if renamed to a wrong or
+	// missing extension. Get always honours a header when one is present.
+	WriteHeader bool
+
+	// DeleteConcurrency bounds the logical paths DeleteAllBulk deletes at
+	// once; zero means DefaultDeleteConcurrency.
+	DeleteConcurrency int
+
+	cache    *resolveCache // nil unless SetResolveCache was called
 	alg      Algorithms
-	writeExt string // "", ".gz", ".zst", ".gz.aes", ".zst.aes", ".aes"
+	writeExt string // "", ".gz", ".zst", ".gz.aes", ".zst.aes", ".aes", ...
+	rules    []WriteRule
 }
 
-var _ Storage = (*VariadicStorage)(nil)
+var (
+	_ Storage = (*VariadicStorage)(nil)
+	_ Walker  = (*VariadicStorage)(nil)
+)
 
 // NewVariadicStorage creates a new VariadicStorage. writeExt is the
 // extension used for *new writes*. It must be one of the supported
@@ -53,7 +319,12 @@ var _ Storage = (*VariadicStorage)(nil)
 //	".zst"     -> zstd
 //	".gz.aes"  -> gzip + AES
 //	".zst.aes" -> zstd + AES
+//	".lz4", ".xz", ".br" and their ".aes" combinations likewise
+//	".age", ".gz.age", ...  -> any extension from Algorithms.Crypters
 func NewVariadicStorage(backend Storage, alg Algorithms, writeExt string) (*VariadicStorage, error) {
+	if err := alg.resolve(); err != nil {
+		return nil, err
+	}
 	vs := &VariadicStorage{
 		Backend:  backend,
 		alg:      alg,
@@ -68,44 +339,32 @@ func NewVariadicStorage(backend Storage, alg Algorithms, writeExt string) (*Vari
 // isSupportedWriteExt validates that the chosen writeExt is compatible
 // with the configured algorithms.
 func (vs *VariadicStorage) isSupportedWriteExt(ext string) bool {
-	switch ext {
-	case "":
-		return true
-	case ".gz":
-		return vs.alg.Gzip != nil
-	case ".zst":
-		return vs.alg.Zstd != nil
-	case ".gz.aes":
-		return vs.alg.Gzip != nil && vs.alg.AES != nil
-	case ".zst.aes":
-		return vs.alg.Zstd != nil && vs.alg.AES != nil
-	case ".aes": // <-- NEW: AES-only is allowed if we have AES
-		return vs.alg.AES != nil
-	default:
-		return false
+	for _, supported := range vs.supportedExts() {
+		if ext == supported {
+			return true
+		}
 	}
+	return false
 }
 
 // supportedExts returns the list of extensions this storage knows about,
 // in priority order for lookup. You can tweak this order if needed.
 func (vs *VariadicStorage) supportedExts() []string {
 	var exts []string
+	codecs := vs.alg.codecs()
+	crypters := vs.alg.crypters()
 
 	// Prefer more "advanced" variants first.
-	if vs.alg.Gzip != nil && vs.alg.AES != nil {
-		exts = append(exts, ".gz.aes")
-	}
-	if vs.alg.Zstd != nil && vs.alg.AES != nil {
-		exts = append(exts, ".zst.aes")
-	}
-	if vs.alg.Gzip != nil {
-		exts = append(exts, ".gz")
+	for _, cr := range crypters {
+		for _, c := range codecs {
+			exts = append(exts, c.ext+cr.ext)
+		}
 	}
-	if vs.alg.Zstd != nil {
-		exts = append(exts, ".zst")
+	for _, c := range codecs {
+		exts = append(exts, c.ext)
 	}
-	if vs.alg.AES != nil {
-		exts = append(exts, ".aes")
+	for _, cr := range crypters {
+		exts = append(exts, cr.ext)
 	}
 	// plain always last
 	exts = append(exts, "")
@@ -119,6 +378,7 @@ type transforms struct {
 	compressor   codec.Compressor
 	decompressor codec.Decompressor
 	crypter      crypt.Crypter
+	crypterExt   string // extension of crypter, e.g. ".aes"
 }
 
 // transformsFromName inspects the name's extension chain and decides
@@ -127,30 +387,26 @@ type transforms struct {
 //
 // The logic is:
 //
-//	[".gz" | ".zst"] [".aes"]?
-//
-// Currently ".aes" is only used in combination with compression, but
-// this can be expanded if needed.
+//	[".gz" | ".zst" | ".lz4" | ".xz" | ".br"]? [".aes" | <Crypters ext>]?
 func (vs *VariadicStorage) transformsFromName(name string) transforms {
 	t := transforms{}
 
-	// Handle AES as the outermost suffix if configured.
-	if vs.alg.AES != nil && strings.HasSuffix(name, ".aes") {
-		t.crypter = vs.alg.AES
-		name = strings.TrimSuffix(name, ".aes")
+	// Handle encryption as the outermost suffix if configured.
+	for _, cr := range vs.alg.crypters() {
+		if strings.HasSuffix(name, cr.ext) {
+			t.crypter, t.crypterExt = cr.crypter, cr.ext
+			name = strings.TrimSuffix(name, cr.ext)
+			break
+		}
 	}
 
 	// Compression suffix.
-	if vs.alg.Gzip != nil && strings.HasSuffix(name, ".gz") {
-		t.compressor = vs.alg.Gzip.Compressor
-		t.decompressor = vs.alg.Gzip.Decompressor
-		return t
-	}
-
-	if vs.alg.Zstd != nil && strings.HasSuffix(name, ".zst") {
-		t.compressor = vs.alg.Zstd.Compressor
-		t.decompressor = vs.alg.Zstd.Decompressor
-		return t
+	for _, c := range vs.alg.codecs() {
+		if strings.HasSuffix(name, c.ext) {
+			t.compressor = c.pair.Compressor
+			t.decompressor = c.pair.Decompressor
+			return t
+		}
 	}
 
 	// No known compression suffix: plain (maybe AES only in future).
@@ -158,9 +414,11 @@ func (vs *VariadicStorage) transformsFromName(name string) transforms {
 }
 
 // encodePath is used for Put/Delete/DeleteBulk to map a logical
-// name to the stored object key using the configured writeExt.
+// name to the stored object key using the configured writeExt (or the
+// matching write rule).
 func (vs *VariadicStorage) encodePath(base string) string {
-	return filepath.ToSlash(base + vs.writeExt)
+	base = filepath.ToSlash(base)
+	return base + vs.writeExtFor(base)
 }
 
 // decodePath strips any known extension combination from the stored
@@ -180,19 +438,29 @@ func (vs *VariadicStorage) decodePath(encoded string) string {
 
 // findExistingName tries all known extensions for the given logical base
 // name and returns the first existing stored name, or fs.ErrNotExist.
+// In strict mode every extension is probed and more than one hit is an
+// *AmbiguousVariantError.
 func (vs *VariadicStorage) findExistingName(ctx context.Context, base string) (string, error) {
 	base = filepath.ToSlash(base)
-	for _, ext := range vs.supportedExts() {
-		candidate := base + ext
-		ok, err := vs.Backend.Exists(ctx, candidate)
-		if err != nil {
-			return "", err
-		}
-		if ok {
-			return candidate, nil
+	if vs.cache != nil {
+		if stored, ok := vs.cache.get(base); ok {
+			return stored, nil
 		}
 	}
-	return "", fs.ErrNotExist
+	found, err := vs.lookupVariants(ctx, base, vs.Strict)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case len(found) == 0:
+		return "", fs.ErrNotExist
+	case len(found) > 1 && vs.Strict:
+		return "", &AmbiguousVariantError{Path: base, Variants: found}
+	}
+	if vs.cache != nil {
+		vs.cache.put(base, found[0])
+	}
+	return found[0], nil
 }
 
 // Put writes the given reader using the configured writeExt. Callers
@@ -201,15 +469,17 @@ func (vs *VariadicStorage) Put(ctx context.Context, path string, r io.Reader) er
 	path = filepath.ToSlash(path)
 	stored := vs.encodePath(path)
 
-	t := vs.transformsFromName(stored)
+	// Progress is reported on the logical (plaintext) stream.
+	ctx, r = trackPutProgress(ctx, path, r)
 
 	// Compress + encrypt according to the chosen extension.
-	transformed, err := pipe.CompressAndEncryptOptional(r, t.compressor, t.crypter)
+	transformed, err := vs.encode(ctx, stored, r)
 	if err != nil {
 		return err
 	}
 
-	return vs.Backend.Put(ctx, stored, transformed)
+	defer vs.forgetResolved(path)
+	return vs.Backend.Put(withoutPutSizeHint(ctx), stored, transformed)
 }
 
 // Get returns a reader for the object. Callers pass the logical name;
@@ -217,36 +487,95 @@ func (vs *VariadicStorage) Put(ctx context.Context, path string, r io.Reader) er
 // actually exists and decode based only on its extension.
 func (vs *VariadicStorage) Get(ctx context.Context, path string) (io.ReadCloser, error) {
 	path = filepath.ToSlash(path)
+	rc, err := vs.get(withoutGetProgress(ctx), path)
+	if err != nil {
+		return nil, err
+	}
+	return trackGetProgress(ctx, path, rc, -1), nil
+}
+
+func (vs *VariadicStorage) get(ctx context.Context, path string) (io.ReadCloser, error) {
+	stored := ""
 
 	// First, see if caller already included a known extension.
 	for _, ext := range vs.supportedExts() {
-		if ext == "" {
-			continue
-		}
-		if strings.HasSuffix(path, ext) {
+		if ext != "" && strings.HasSuffix(path, ext) {
 			// Treat as a fully encoded path.
-			rc, err := vs.Backend.Get(ctx, path)
-			if err != nil {
-				return nil, err
-			}
-			t := vs.transformsFromName(path)
-			return pipe.DecryptAndDecompressOptional(rc, t.crypter, t.decompressor)
+			stored = path
+			break
 		}
 	}
 
 	// Otherwise, treat as base and search for existing variant.
-	stored, err := vs.findExistingName(ctx, path)
-	if err != nil {
-		return nil, err
+	if stored == "" {
+		var err error
+		if stored, err = vs.findExistingName(ctx, path); err != nil {
+			return nil, err
+		}
 	}
 
 	rc, err := vs.Backend.Get(ctx, stored)
 	if err != nil {
 		return nil, err
 	}
+	decoded, err := vs.decode(rc, stored)
+	if err != nil {
+		_ = rc.Close()
+		return nil, err
+	}
+	return decoded, nil
+}
 
+// encode compresses and encrypts r according to the stored name's
+// extension, prepending an ObjectHeader if configured.
+func (vs *VariadicStorage) encode(ctx context.Context, stored string, r io.Reader) (io.Reader, error) {
 	t := vs.transformsFromName(stored)
-	return pipe.DecryptAndDecompressOptional(rc, t.crypter, t.decompressor)
+	transformed, err := pipe.CompressAndEncryptOptional(r, t.compressor, t.crypter)
+	if err != nil || !vs.WriteHeader {
+		return transformed, err
+	}
+	hdr := newObjectHeader(t.compressor, t.crypter, PutOptionsFromContext(ctx).Size)
+	return prependObjectHeader(hdr, transformed)
+}
+
+// decode wraps rc with the transforms named by its ObjectHeader, falling
+// back to the stored name's extension for objects without one.
+func (vs *VariadicStorage) decode(rc io.ReadCloser, stored string) (io.ReadCloser, error) {
+	hdr, body, err := readObjectHeader(rc)
+	if err != nil {
+		return nil, err
+	}
+	t := vs.transformsFromName(stored)
+	if hdr != nil {
+		named := t
+		if t, err = vs.transformsFromHeader(hdr); err != nil {
+			return nil, err
+		}
+		// The header may add encryption an extension lost, but never drop
+		// or swap the crypter the extension calls for.
+		if named.crypter != nil && t.crypterExt != named.crypterExt {
+			return nil, crypterMismatch(hdr.Crypter, strings.TrimPrefix(named.crypterExt, "."))
+		}
+	}
+	return pipe.DecryptAndDecompressOptional(body, t.crypter, t.decompressor)
+}
+
+func (vs *VariadicStorage) transformsFromHeader(hdr *ObjectHeader) (transforms, error) {
+	t := transforms{}
+	if hdr.Codec != "" {
+		pair := vs.alg.codec(hdr.Codec)
+		if pair == nil {
+			return t, &UnknownTransformError{Kind: "codec", Name: hdr.Codec}
+		}
+		t.compressor, t.decompressor = pair.Compressor, pair.Decompressor
+	}
+	if hdr.Crypter != "" {
+		if t.crypter = vs.alg.crypter(hdr.Crypter); t.crypter == nil {
+			return t, &UnknownTransformError{Kind: "crypter", Name: hdr.Crypter}
+		}
+		t.crypterExt = "." + hdr.Crypter
+	}
+	return t, nil
 }
 
 // List lists logical paths (without transform extensions).
@@ -263,55 +592,165 @@ func (vs *VariadicStorage) List(ctx context.Context, prefix string) ([]string, e
 }
 
 // ListInfo lists FileInfo entries but rewrites the Path field to the
-// logical name (without extensions).
+// logical name (without extensions). StoredPath keeps the physical name,
+// and Size is the stored size. With DedupListInfo set, only the
+// highest-priority variant of each logical path is returned.
+//
+// The backend applies the time filters, names and sizes are filtered once
+// they are logical. With DedupListInfo every filter waits for the winning
+// variant, so that a filtered-out one cannot make another variant win.
 func (vs *VariadicStorage) ListInfo(ctx context.Context, prefix string) ([]FileInfo, error) {
 	prefix = filepath.ToSlash(prefix)
-	files, err := vs.Backend.ListInfo(ctx, prefix)
+	backendCtx := withoutEntryFilters(ctx)
+	if vs.DedupListInfo {
+		backendCtx = withoutListFilters(ctx)
+	}
+	files, err := vs.Backend.ListInfo(backendCtx, prefix)
 	if err != nil {
 		return nil, err
 	}
 	for i := range files {
-		files[i].Path = vs.decodePath(files[i].Path)
+		if files[i].IsDir {
+			continue
+		}
+		stored := filepath.ToSlash(files[i].Path)
+		files[i].StoredPath = stored
+		files[i].Path = vs.decodePath(stored)
 	}
-	return files, nil
+	if !vs.DedupListInfo {
+		return filterInfos(ctx, files), nil
+	}
+
+	rank := make(map[string]int)
+	for i, ext := range vs.supportedExts() {
+		rank[ext] = i
+	}
+	best := make(map[string]int, len(files)) // logical path -> index in files
+	result := files[:0:0]
+	for _, fi := range files {
+		if fi.IsDir {
+			result = append(result, fi)
+			continue
+		}
+		r := rank[strings.TrimPrefix(fi.StoredPath, fi.Path)]
+		idx, seen := best[fi.Path]
+		if !seen {
+			best[fi.Path] = len(result)
+			result = append(result, fi)
+			continue
+		}
+		if r < rank[strings.TrimPrefix(result[idx].StoredPath, result[idx].Path)] {
+			result[idx] = fi
+		}
+	}
+	return filterInfos(ctx, result), nil
+}
+
+// WalkInfo streams what ListInfo returns. DedupListInfo needs to see
+// every variant of a path first, so then the listing is collected.
+func (vs *VariadicStorage) WalkInfo(ctx context.Context, prefix string, fn func(fi FileInfo) error) error {
+	if vs.DedupListInfo {
+		infos, err := vs.ListInfo(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		return walkSlice(ctx, infos, fn)
+	}
+	opts := ListOptionsFromContext(ctx)
+	return WalkInfo(withoutEntryFilters(ctx), vs.Backend, filepath.ToSlash(prefix), func(fi FileInfo) error {
+		if fi.IsDir {
+			return fn(fi)
+		}
+		stored := filepath.ToSlash(fi.Path)
+		fi.StoredPath = stored
+		fi.Path = vs.decodePath(stored)
+		if !opts.Match(fi) {
+			return nil
+		}
+		return fn(fi)
+	})
 }
 
 // Delete deletes all known variants for the given logical path.
 // If you want "only current writeExt" semantics, you can change
-// this to use vs.encodePath() instead.
+// this to use vs.encodePath() instead. A failure on one variant does not
+// stop the others; see VariantError.
 func (vs *VariadicStorage) Delete(ctx context.Context, path string) error {
 	path = filepath.ToSlash(path)
+	defer vs.forgetResolved(path)
 
-	var lastErr error
+	var errs []error
 	for _, ext := range vs.supportedExts() {
 		candidate := path + ext
 		if err := vs.Backend.Delete(ctx, candidate); err != nil && !errors.Is(err, fs.ErrNotExist) {
-			lastErr = err
+			errs = append(errs, &VariantError{Op: "delete", Path: path, Stored: candidate, Err: err})
 		}
 	}
-	return lastErr
+	return errors.Join(errs...)
 }
 
+// DeleteDir removes the logical directory path: every stored variant of
+// every logical object under it, then the directory itself. A stored
+// object whose raw name equals path (e.g. "x.gz" for DeleteDir("x.gz"))
+// is a different logical object ("x") and is left alone.
 func (vs *VariadicStorage) DeleteDir(ctx context.Context, path string) error {
-	path = filepath.ToSlash(path)
+	path = strings.TrimSuffix(filepath.ToSlash(path), "/")
+	defer vs.forgetResolvedUnder(path)
+	if err := vs.deleteUnder(ctx, path); err != nil {
+		return err
+	}
+	isObject, err := vs.Backend.Exists(ctx, path)
+	if err != nil || isObject {
+		return err
+	}
 	return vs.Backend.DeleteDir(ctx, path)
 }
 
+// DeleteAll removes everything below the logical directory path, keeping
+// the directory itself. See DeleteDir for the logical-path semantics.
 func (vs *VariadicStorage) DeleteAll(ctx context.Context, path string) error {
-	path = filepath.ToSlash(path)
+	path = strings.TrimSuffix(filepath.ToSlash(path), "/")
+	defer vs.forgetResolvedUnder(path)
+	if err := vs.deleteUnder(ctx, path); err != nil {
+		return err
+	}
+	isObject, err := vs.Backend.Exists(ctx, path)
+	if err != nil || isObject {
+		return err
+	}
+	// Clean up whatever is left (empty subdirectories, foreign files).
 	return vs.Backend.DeleteAll(ctx, path)
 }
 
+// deleteUnder deletes every stored object whose logical path lies strictly
+// below dir. Failures are aggregated, one entry per stored variant.
+func (vs *VariadicStorage) deleteUnder(ctx context.Context, dir string) error {
+	files, err := vs.Backend.List(ctx, dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	var errs []error
+	for _, f := range files {
+		logical := vs.decodePath(f)
+		if logical == dir || !hasPathPrefix(logical, dir) {
+			continue
+		}
+		if err := vs.Backend.Delete(ctx, f); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, &VariantError{Op: "delete", Path: logical, Stored: f, Err: err})
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // DeleteAllBulk deletes all known variants for each logical path. This
 // delegates to Delete to keep the "multi-variant" semantics consistent.
 func (vs *VariadicStorage) DeleteAllBulk(ctx context.Context, paths []string) error {
-	var lastErr error
-	for _, p := range paths {
-		if err := vs.Delete(ctx, p); err != nil {
-			lastErr = err
-		}
-	}
-	return lastErr
+	return deleteConcurrently(ctx, paths, vs.DeleteConcurrency, func(p string) error {
+		return vs.Delete(ctx, p)
+	})
 }
 
 // Exists returns true if any variant for the logical path exists.
@@ -327,6 +766,52 @@ func (vs *VariadicStorage) Exists(ctx context.Context, path string) (bool, error
 	return true, nil
 }
 
+// VariantStat describes the stored variant backing a logical path.
+type VariantStat struct {
+	Path        string // logical path
+	StoredPath  string // physical name, including extensions
+	StoredSize  int64  // bytes in the backend
+	LogicalSize int64  // decoded size, -1 if unknown
+	ModTime     time.Time
+}
+
+// Stat resolves the stored variant of a logical path (honoring Strict)
+// and reports its stored size. The logical size is the one recorded in the
+// ObjectHeader, if the object has one, or the stored size of a plain
+// variant; otherwise it is -1.
+func (vs *VariadicStorage) Stat(ctx context.Context, path string) (VariantStat, error) {
+	path = filepath.ToSlash(path)
+	stored, err := vs.findExistingName(ctx, path)
+	if err != nil {
+		return VariantStat{}, err
+	}
+	fi, err := StatObject(ctx, vs.Backend, stored)
+	var hdr *ObjectHeader
+	if err == nil {
+		hdr, err = storedObjectHeader(ctx, vs.Backend, stored)
+	}
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			vs.forgetResolved(path)
+		}
+		return VariantStat{}, err
+	}
+	st := VariantStat{
+		Path:        path,
+		StoredPath:  stored,
+		StoredSize:  fi.Size,
+		LogicalSize: -1,
+		ModTime:     fi.ModTime,
+	}
+	switch {
+	case hdr != nil:
+		st.LogicalSize = hdr.Size
+	case stored == path:
+		st.LogicalSize = fi.Size
+	}
+	return st, nil
+}
+
 // ListTopLevelDirs just delegates to the backend; directory names
 // usually don't contain transform suffixes.
 func (vs *VariadicStorage) ListTopLevelDirs(ctx context.Context, prefix string) (map[string]bool, error) {
@@ -334,6 +819,8 @@ func (vs *VariadicStorage) ListTopLevelDirs(ctx context.Context, prefix string)
 	return vs.Backend.ListTopLevelDirs(ctx, prefix)
 }
 
+// Rename renames every stored variant of the logical path. A failure on one
+// variant does not stop the others; see VariantError.
 func (vs *VariadicStorage) Rename(ctx context.Context, oldRemotePath, newRemotePath string) error {
 	// Normalize and strip transform extensions to get logical names
 	oldBase := vs.decodePath(filepath.ToSlash(oldRemotePath))
@@ -342,8 +829,10 @@ func (vs *VariadicStorage) Rename(ctx context.Context, oldRemotePath, newRemoteP
 	if oldBase == newBase {
 		return nil
 	}
+	defer vs.forgetResolved(oldBase)
+	defer vs.forgetResolved(newBase)
 
-	var lastErr error
+	var errs []error
 
 	for _, ext := range vs.supportedExts() {
 		oldPhys := oldBase + ext
@@ -352,7 +841,7 @@ func (vs *VariadicStorage) Rename(ctx context.Context, oldRemotePath, newRemoteP
 		// Check if this physical variant exists
 		ok, err := vs.Backend.Exists(ctx, oldPhys)
 		if err != nil {
-			lastErr = err
+			errs = append(errs, &VariantError{Op: "rename", Path: oldBase, Stored: oldPhys, Err: err})
 			continue
 		}
 		if !ok {
@@ -360,9 +849,126 @@ func (vs *VariadicStorage) Rename(ctx context.Context, oldRemotePath, newRemoteP
 		}
 
 		if err := vs.Backend.Rename(ctx, oldPhys, newPhys); err != nil {
-			lastErr = err
+			errs = append(errs, &VariantError{Op: "rename", Path: oldBase, Stored: oldPhys, Err: err})
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// migrateTempMarker tags the temporary objects written by Migrate; they are
+// renamed into place once fully written.
+const migrateTempMarker = ".migrate-"
+
+// MigratePath re-encodes a single object so that it is stored with writeExt.
+// A lone variant is decoded, re-encoded into a temporary object and renamed
+// into place. If the writeExt variant already exists it is kept as is: it
+// is the one the last Put wrote. The other variants are deleted in both
+// cases. Several variants without a writeExt one cannot be told apart by
+// age, so that case fails with *AmbiguousVariantError and nothing is
+// changed. It reports whether anything was rewritten or deleted.
+//
+// Put progress options attached to ctx (see WithPutProgress) receive the
+// progress of the re-encode.
+func (vs *VariadicStorage) MigratePath(ctx context.Context, path string) (bool, error) {
+	base := vs.decodePath(filepath.ToSlash(path))
+	target := vs.encodePath(base)
+
+	defer vs.forgetResolved(base)
+
+	variants, err := vs.lookupVariants(ctx, base, true)
+	if err != nil {
+		return false, err
+	}
+	if len(variants) == 0 {
+		return false, fs.ErrNotExist
+	}
+	if !slices.Contains(variants, target) {
+		if len(variants) > 1 {
+			return false, &AmbiguousVariantError{Path: base, Variants: variants}
+		}
+		if err := vs.reencode(ctx, base, variants[0], target); err != nil {
+			return false, fmt.Errorf("migrate %q: %w", variants[0], err)
+		}
+	} else if len(variants) == 1 {
+		return false, nil
+	}
+	for _, v := range variants {
+		if v == target {
+			continue
+		}
+		if err := vs.Backend.Delete(ctx, v); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return true, fmt.Errorf("delete old variant %q: %w", v, err)
 		}
 	}
+	return true, nil
+}
+
+// Migrate re-encodes every object under prefix whose stored extension
+// differs from writeExt (see MigratePath), e.g. to convert an archive from
+// .gz to .zst.aes in place. It returns the number of objects migrated.
+func (vs *VariadicStorage) Migrate(ctx context.Context, prefix string) (int, error) {
+	files, err := vs.Backend.List(ctx, filepath.ToSlash(prefix))
+	if err != nil {
+		return 0, err
+	}
+
+	seen := make(map[string]bool)
+	var bases []string
+	for _, f := range files {
+		if strings.Contains(f, migrateTempMarker) {
+			continue
+		}
+		base := vs.decodePath(f)
+		if f == vs.encodePath(base) || seen[base] {
+			continue
+		}
+		seen[base] = true
+		bases = append(bases, base)
+	}
+	sort.Strings(bases)
+
+	count := 0
+	var errs []error
+	for _, base := range bases {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		changed, err := vs.MigratePath(ctx, base)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if changed {
+			count++
+		}
+	}
+	return count, errors.Join(errs...)
+}
+
+func (vs *VariadicStorage) reencode(ctx context.Context, base, source, target string) error {
+	rc, err := vs.get(withoutGetProgress(ctx), source)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
 
-	return lastErr
+	ctx, r := trackPutProgress(ctx, base, rc)
+	transformed, err := vs.encode(ctx, target, r)
+	if err != nil {
+		return err
+	}
+
+	tmp := target + migrateTempMarker + randomSuffix()
+	if err := vs.Backend.Put(withoutPutSizeHint(ctx), tmp, transformed); err != nil {
+		_ = vs.Backend.Delete(ctx, tmp)
+		return err
+	}
+	if err := replaceObject(ctx, vs.Backend, tmp, target); err != nil {
+		if !errors.Is(err, errReplaceIncomplete) {
+			_ = vs.Backend.Delete(ctx, tmp)
+		}
+		return err
+	}
+	return nil
 }
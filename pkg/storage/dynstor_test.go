@@ -3,12 +3,17 @@ package storage
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"strings"
 	"testing"
 
+	"github.com/hashmap-kz/storecrypt/pkg/codecs"
+	"github.com/hashmap-kz/storecrypt/pkg/crypters"
 	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
 	"github.com/hashmap-kz/streamcrypt/pkg/crypt/aesgcm"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -67,6 +72,65 @@ func TestNewVariadicStorage_ValidationMatrix(t *testing.T) {
 	}
 }
 
+// leveledGzip records the level NewVariadicStorage applied.
+type leveledGzip struct {
+	codec.GzipCompressor
+	level int
+}
+
+func (c leveledGzip) WithLevel(level int) (codec.Compressor, error) {
+	c.level = level
+	return c, c.Validate()
+}
+
+func (c leveledGzip) Validate() error {
+	if c.level < 0 || c.level > 9 {
+		return fmt.Errorf("invalid level %d", c.level)
+	}
+	return nil
+}
+
+func TestNewVariadicStorage_CodecLevels(t *testing.T) {
+	pair := &CodecPair{Compressor: leveledGzip{}, Decompressor: codec.GzipDecompressor{}, Level: 7}
+	vs, err := NewVariadicStorage(NewInMemoryStorage(), Algorithms{Gzip: pair}, ".gz")
+	require.NoError(t, err)
+	assert.Equal(t, 7, vs.alg.Gzip.Compressor.(leveledGzip).level)
+	assert.Equal(t, 0, pair.Compressor.(leveledGzip).level, "caller's pair is not modified")
+
+	pair.Level = 42
+	_, err = NewVariadicStorage(NewInMemoryStorage(), Algorithms{Gzip: pair}, ".gz")
+	assert.ErrorContains(t, err, ".gz codec: invalid level 42")
+
+	// Settings are validated even without a level.
+	_, err = NewVariadicStorage(NewInMemoryStorage(), Algorithms{Gzip: &CodecPair{
+		Compressor: leveledGzip{level: -1}, Decompressor: codec.GzipDecompressor{},
+	}}, "")
+	assert.Error(t, err)
+
+	// The stock codecs get their level from pkg/codecs.
+	vs, err = NewVariadicStorage(NewInMemoryStorage(), Algorithms{
+		Gzip: &CodecPair{Compressor: codec.GzipCompressor{}, Decompressor: codec.GzipDecompressor{}, Level: 1},
+		Zstd: &CodecPair{Compressor: codec.ZstdCompressor{}, Decompressor: codec.ZstdDecompressor{}, Level: 3},
+	}, ".zst")
+	require.NoError(t, err)
+	assert.Equal(t, codecs.GzipCompressor{Level: 1}, vs.alg.Gzip.Compressor)
+	assert.Equal(t, codecs.ZstdCompressor{Level: 3}, vs.alg.Zstd.Compressor)
+	_, err = NewVariadicStorage(NewInMemoryStorage(), Algorithms{Zstd: &CodecPair{
+		Compressor: codec.ZstdCompressor{}, Decompressor: codec.ZstdDecompressor{}, Level: 30,
+	}}, ".zst")
+	assert.ErrorContains(t, err, "invalid compression level 30")
+
+	_, err = NewVariadicStorage(NewInMemoryStorage(), Algorithms{Gzip: &CodecPair{
+		Compressor: plainGzip{}, Decompressor: codec.GzipDecompressor{}, Level: 3,
+	}}, ".gz")
+	assert.ErrorContains(t, err, "does not support levels")
+}
+
+// plainGzip is a compressor without levels.
+type plainGzip struct {
+	codec.GzipCompressor
+}
+
 // -----------------------------------------------------------------------------
 // supportedExts
 // -----------------------------------------------------------------------------
@@ -122,6 +186,14 @@ func TestSupportedExts_OrderAndContent(t *testing.T) {
 			alg:  Algorithms{Gzip: gzipPair, Zstd: zstdPair, AES: aes},
 			want: []string{".gz.aes", ".zst.aes", ".gz", ".zst", ".aes", ""},
 		},
+		{
+			name: "all-codecs-aes",
+			alg:  Algorithms{Gzip: gzipPair, Zstd: zstdPair, Lz4: gzipPair, Xz: gzipPair, Brotli: gzipPair, AES: aes},
+			want: []string{
+				".gz.aes", ".zst.aes", ".lz4.aes", ".xz.aes", ".br.aes",
+				".gz", ".zst", ".lz4", ".xz", ".br", ".aes", "",
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -523,3 +595,520 @@ func TestVariadicStorage_ListInfo_RewritesPath(t *testing.T) {
 
 	assert.ElementsMatch(t, []string{"p/a", "p/c"}, paths)
 }
+
+func TestVariadicStorage_Migrate_ReencodesOldVariants(t *testing.T) {
+	ctx := context.Background()
+
+	aes := aesgcm.NewChunkedGCMCrypter("password")
+	gzipPair := &CodecPair{
+		Compressor:   codec.GzipCompressor{},
+		Decompressor: codec.GzipDecompressor{},
+	}
+	zstdPair := &CodecPair{
+		Compressor:   codec.ZstdCompressor{},
+		Decompressor: codec.ZstdDecompressor{},
+	}
+	alg := Algorithms{Gzip: gzipPair, Zstd: zstdPair, AES: aes}
+
+	mem := NewInMemoryStorage()
+	old, err := NewVariadicStorage(mem, alg, ".gz")
+	require.NoError(t, err)
+	require.NoError(t, old.Put(ctx, "wal/0001", bytes.NewReader([]byte("one"))))
+	require.NoError(t, old.Put(ctx, "wal/0002", bytes.NewReader([]byte("two"))))
+	mem.Files["wal/0003"] = []byte("three")
+
+	vs, err := NewVariadicStorage(mem, alg, ".zst.aes")
+	require.NoError(t, err)
+	require.NoError(t, vs.Put(ctx, "wal/0004", bytes.NewReader([]byte("four"))))
+
+	var done []string
+	pctx := ContextWithPutOptions(ctx, WithPutProgress(func(p Progress) {
+		if p.Done {
+			done = append(done, p.Path)
+		}
+	}))
+	n, err := vs.Migrate(pctx, "wal")
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.ElementsMatch(t, []string{"wal/0001", "wal/0002", "wal/0003"}, done)
+
+	files, err := mem.List(ctx, "wal")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"wal/0001.zst.aes", "wal/0002.zst.aes", "wal/0003.zst.aes", "wal/0004.zst.aes"}, files)
+
+	for name, want := range map[string]string{"wal/0001": "one", "wal/0003": "three", "wal/0004": "four"} {
+		rc, err := vs.Get(ctx, name)
+		require.NoError(t, err)
+		got, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		assert.Equal(t, want, string(got))
+	}
+
+	changed, err := vs.MigratePath(ctx, "wal/0001")
+	require.NoError(t, err)
+	assert.False(t, changed)
+}
+
+func TestVariadicStorage_MigratePath_SeveralVariants(t *testing.T) {
+	ctx := context.Background()
+
+	gzipPair := &CodecPair{
+		Compressor:   codec.GzipCompressor{},
+		Decompressor: codec.GzipDecompressor{},
+	}
+	zstdPair := &CodecPair{
+		Compressor:   codec.ZstdCompressor{},
+		Decompressor: codec.ZstdDecompressor{},
+	}
+	alg := Algorithms{Gzip: gzipPair, Zstd: zstdPair}
+
+	mem := NewInMemoryStorage()
+	old, err := NewVariadicStorage(mem, alg, ".gz")
+	require.NoError(t, err)
+	require.NoError(t, old.Put(ctx, "wal/0001", bytes.NewReader([]byte("stale"))))
+	require.NoError(t, old.Put(ctx, "wal/0002", bytes.NewReader([]byte("stale"))))
+	mem.Files["wal/0002"] = []byte("plain")
+
+	vs, err := NewVariadicStorage(mem, alg, ".zst")
+	require.NoError(t, err)
+	require.NoError(t, vs.Put(ctx, "wal/0001", bytes.NewReader([]byte("fresh"))))
+
+	// The writeExt variant is what the last Put wrote: it is kept even
+	// though .gz has a higher priority.
+	changed, err := vs.MigratePath(ctx, "wal/0001")
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.NotContains(t, mem.Files, "wal/0001.gz")
+	rc, err := vs.Get(ctx, "wal/0001")
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, "fresh", string(got))
+
+	// Without a writeExt variant there is no telling which one is newest.
+	_, err = vs.MigratePath(ctx, "wal/0002")
+	var amb *AmbiguousVariantError
+	require.ErrorAs(t, err, &amb)
+	assert.ElementsMatch(t, []string{"wal/0002.gz", "wal/0002"}, amb.Variants)
+	assert.Contains(t, mem.Files, "wal/0002.gz")
+	assert.Contains(t, mem.Files, "wal/0002")
+	assert.NotContains(t, mem.Files, "wal/0002.zst")
+}
+
+func TestVariadicStorage_ExtraCodecSlots(t *testing.T) {
+	ctx := context.Background()
+
+	aes := aesgcm.NewChunkedGCMCrypter("password")
+	// Any pair will do to exercise the extension plumbing.
+	pair := &CodecPair{
+		Compressor:   codec.GzipCompressor{},
+		Decompressor: codec.GzipDecompressor{},
+	}
+
+	for _, tc := range []struct {
+		alg Algorithms
+		ext string
+	}{
+		{Algorithms{Lz4: pair}, ".lz4"},
+		{Algorithms{Lz4: pair, AES: aes}, ".lz4.aes"},
+		{Algorithms{Xz: pair}, ".xz"},
+		{Algorithms{Xz: pair, AES: aes}, ".xz.aes"},
+		{Algorithms{Brotli: pair}, ".br"},
+		{Algorithms{Brotli: pair, AES: aes}, ".br.aes"},
+	} {
+		t.Run(tc.ext, func(t *testing.T) {
+			mem := NewInMemoryStorage()
+			vs, err := NewVariadicStorage(mem, tc.alg, tc.ext)
+			require.NoError(t, err)
+			require.NoError(t, vs.Put(ctx, "wal/0001", bytes.NewReader([]byte("payload"))))
+			assert.Contains(t, mem.Files, "wal/0001"+tc.ext)
+
+			rc, err := vs.Get(ctx, "wal/0001")
+			require.NoError(t, err)
+			got, err := io.ReadAll(rc)
+			require.NoError(t, err)
+			require.NoError(t, rc.Close())
+			assert.Equal(t, "payload", string(got))
+
+			list, err := vs.List(ctx, "wal")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"wal/0001"}, list)
+		})
+	}
+
+	_, err := NewVariadicStorage(NewInMemoryStorage(), Algorithms{Lz4: pair}, ".xz")
+	assert.Error(t, err)
+}
+
+func TestVariadicStorage_MultipleCrypters(t *testing.T) {
+	ctx := context.Background()
+
+	key := make([]byte, 32)
+	kw, err := crypters.NewLocalKeyWrapper("k", key)
+	require.NoError(t, err)
+
+	aes := aesgcm.NewChunkedGCMCrypter("password")
+	gzipPair := &CodecPair{
+		Compressor:   codec.GzipCompressor{},
+		Decompressor: codec.GzipDecompressor{},
+	}
+	alg := Algorithms{
+		Gzip:     gzipPair,
+		AES:      aes,
+		Crypters: map[string]crypt.Crypter{".enc": crypters.NewEnvelope(kw)},
+	}
+
+	mem := NewInMemoryStorage()
+	oldTool, err := NewVariadicStorage(mem, alg, ".gz.aes")
+	require.NoError(t, err)
+	newTool, err := NewVariadicStorage(mem, alg, ".gz.enc")
+	require.NoError(t, err)
+
+	require.NoError(t, oldTool.Put(ctx, "wal/0001", bytes.NewReader([]byte("old era"))))
+	require.NoError(t, newTool.Put(ctx, "wal/0002", bytes.NewReader([]byte("new era"))))
+	assert.Contains(t, mem.Files, "wal/0001.gz.aes")
+	assert.Contains(t, mem.Files, "wal/0002.gz.enc")
+
+	for name, want := range map[string]string{"wal/0001": "old era", "wal/0002": "new era"} {
+		rc, err := newTool.Get(ctx, name)
+		require.NoError(t, err)
+		got, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		assert.Equal(t, want, string(got))
+	}
+
+	list, err := newTool.List(ctx, "wal")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"wal/0001", "wal/0002"}, list)
+
+	_, err = NewVariadicStorage(mem, alg, ".age")
+	assert.Error(t, err)
+}
+
+func TestVariadicStorage_AESAndXChaCha20(t *testing.T) {
+	ctx := context.Background()
+
+	xc, err := crypters.NewXChaCha20FromPassword("password")
+	require.NoError(t, err)
+	alg := Algorithms{
+		AES:      aesgcm.NewChunkedGCMCrypter("password"),
+		Crypters: map[string]crypt.Crypter{".xchacha": xc},
+	}
+	mem := NewInMemoryStorage()
+	withAES, err := NewVariadicStorage(mem, alg, ".aes")
+	require.NoError(t, err)
+	withXC, err := NewVariadicStorage(mem, alg, ".xchacha")
+	require.NoError(t, err)
+
+	require.NoError(t, withAES.Put(ctx, "a", bytes.NewReader([]byte("aes"))))
+	require.NoError(t, withXC.Put(ctx, "b", bytes.NewReader([]byte("xchacha"))))
+	assert.Contains(t, mem.Files, "a.aes")
+	assert.Contains(t, mem.Files, "b.xchacha")
+
+	for name, want := range map[string]string{"a": "aes", "b": "xchacha"} {
+		rc, err := withAES.Get(ctx, name)
+		require.NoError(t, err)
+		got, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		assert.Equal(t, want, string(got))
+	}
+}
+
+func TestVariadicStorage_Age(t *testing.T) {
+	ctx := context.Background()
+
+	id, err := crypters.GenerateAgeX25519Identity()
+	require.NoError(t, err)
+	// The archiver only holds the public key; restores need the identity.
+	writeOnly := crypters.NewAge([]crypters.AgeRecipient{id.Recipient()}, nil)
+	readWrite := crypters.NewAge([]crypters.AgeRecipient{id.Recipient()}, []crypters.AgeIdentity{id})
+
+	zstdPair := &CodecPair{
+		Compressor:   codec.ZstdCompressor{},
+		Decompressor: codec.ZstdDecompressor{},
+	}
+	mem := NewInMemoryStorage()
+	archiver, err := NewVariadicStorage(mem, Algorithms{
+		Zstd:     zstdPair,
+		Crypters: map[string]crypt.Crypter{".age": writeOnly},
+	}, ".zst.age")
+	require.NoError(t, err)
+	require.NoError(t, archiver.Put(ctx, "wal/0001", bytes.NewReader([]byte("to a public key"))))
+	assert.Contains(t, mem.Files, "wal/0001.zst.age")
+
+	_, err = archiver.Get(ctx, "wal/0001")
+	assert.ErrorIs(t, err, crypters.ErrAgeNoIdentityMatch)
+
+	restorer := &TransformingStorage{
+		Backend:      mem,
+		Crypter:      readWrite,
+		Compressor:   codec.ZstdCompressor{},
+		Decompressor: codec.ZstdDecompressor{},
+	}
+	rc, err := restorer.Get(ctx, "wal/0001")
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, "to a public key", string(got))
+}
+
+func TestVariadicStorage_StrictMode_AmbiguousVariant(t *testing.T) {
+	ctx := context.Background()
+
+	gzipPair := &CodecPair{
+		Compressor:   codec.GzipCompressor{},
+		Decompressor: codec.GzipDecompressor{},
+	}
+	mem := NewInMemoryStorage()
+	mem.Files["wal/0001"] = []byte("stale")
+	mem.Files["wal/0002"] = []byte("only")
+
+	vs, err := NewVariadicStorage(mem, Algorithms{Gzip: gzipPair}, ".gz")
+	require.NoError(t, err)
+	require.NoError(t, vs.Put(ctx, "wal/0001", bytes.NewReader([]byte("fresh"))))
+
+	// Default: the highest-priority variant silently wins.
+	_, err = vs.Get(ctx, "wal/0001")
+	require.NoError(t, err)
+
+	vs.Strict = true
+	_, err = vs.Get(ctx, "wal/0001")
+	require.ErrorIs(t, err, ErrAmbiguousVariant)
+	var amb *AmbiguousVariantError
+	require.ErrorAs(t, err, &amb)
+	assert.Equal(t, []string{"wal/0001.gz", "wal/0001"}, amb.Variants)
+
+	_, err = vs.Exists(ctx, "wal/0001")
+	assert.ErrorIs(t, err, ErrAmbiguousVariant)
+
+	ok, err := vs.Exists(ctx, "wal/0002")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = vs.Exists(ctx, "wal/missing")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+// failingDeleteStorage fails Delete for the configured stored names.
+type failingDeleteStorage struct {
+	*InMemoryStorage
+	fail map[string]bool
+}
+
+func (f *failingDeleteStorage) Delete(ctx context.Context, path string) error {
+	if f.fail[path] {
+		return errors.New("boom")
+	}
+	return f.InMemoryStorage.Delete(ctx, path)
+}
+
+func TestVariadicStorage_DeleteDir_LogicalPaths(t *testing.T) {
+	ctx := context.Background()
+
+	gzipPair := &CodecPair{
+		Compressor:   codec.GzipCompressor{},
+		Decompressor: codec.GzipDecompressor{},
+	}
+	mem := NewInMemoryStorage()
+	mem.Files["wal/0001.gz"] = []byte("1")
+	mem.Files["wal/sub/0002"] = []byte("2")
+	mem.Files["wal.gz"] = []byte("sibling object")
+	mem.Files["arch/x.gz"] = []byte("object x")
+	mem.Files["arch/x.gz/y.gz"] = []byte("object under dir x.gz")
+
+	vs, err := NewVariadicStorage(mem, Algorithms{Gzip: gzipPair}, ".gz")
+	require.NoError(t, err)
+
+	require.NoError(t, vs.DeleteDir(ctx, "wal/"))
+	assert.NotContains(t, mem.Files, "wal/0001.gz")
+	assert.NotContains(t, mem.Files, "wal/sub/0002")
+	assert.Contains(t, mem.Files, "wal.gz")
+
+	// "arch/x.gz" is the stored form of logical object "arch/x", not part
+	// of the logical directory "arch/x.gz".
+	require.NoError(t, vs.DeleteAll(ctx, "arch/x.gz"))
+	assert.Contains(t, mem.Files, "arch/x.gz")
+	assert.NotContains(t, mem.Files, "arch/x.gz/y.gz")
+}
+
+func TestVariadicStorage_DeleteAll_AggregatesVariantErrors(t *testing.T) {
+	ctx := context.Background()
+
+	gzipPair := &CodecPair{
+		Compressor:   codec.GzipCompressor{},
+		Decompressor: codec.GzipDecompressor{},
+	}
+	backend := &failingDeleteStorage{
+		InMemoryStorage: NewInMemoryStorage(),
+		fail:            map[string]bool{"p/a.gz": true, "p/b": true},
+	}
+	backend.Files["p/a.gz"] = []byte("1")
+	backend.Files["p/b"] = []byte("2")
+	backend.Files["p/c.gz"] = []byte("3")
+
+	vs, err := NewVariadicStorage(backend, Algorithms{Gzip: gzipPair}, ".gz")
+	require.NoError(t, err)
+
+	err = vs.DeleteAll(ctx, "p")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `"p/a.gz"`)
+	assert.Contains(t, err.Error(), `"p/b"`)
+	assert.NotContains(t, backend.Files, "p/c.gz")
+	assert.Contains(t, backend.Files, "p/a.gz")
+}
+
+func TestVariadicStorage_Stat(t *testing.T) {
+	ctx := context.Background()
+
+	gzipPair := &CodecPair{
+		Compressor:   codec.GzipCompressor{},
+		Decompressor: codec.GzipDecompressor{},
+	}
+	mem := NewInMemoryStorage()
+	mem.Files["wal/plain"] = []byte("12345")
+
+	vs, err := NewVariadicStorage(mem, Algorithms{Gzip: gzipPair}, ".gz")
+	require.NoError(t, err)
+	require.NoError(t, vs.Put(ctx, "wal/0001", bytes.NewReader([]byte("payload"))))
+
+	st, err := vs.Stat(ctx, "wal/0001")
+	require.NoError(t, err)
+	assert.Equal(t, "wal/0001", st.Path)
+	assert.Equal(t, "wal/0001.gz", st.StoredPath)
+	assert.Equal(t, int64(len(mem.Files["wal/0001.gz"])), st.StoredSize)
+	assert.Equal(t, int64(-1), st.LogicalSize)
+
+	st, err = vs.Stat(ctx, "wal/plain")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), st.StoredSize)
+	assert.Equal(t, int64(5), st.LogicalSize)
+
+	_, err = vs.Stat(ctx, "wal/missing")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	// The header records the logical size of encoded variants.
+	vs.WriteHeader = true
+	require.NoError(t, PutWithOptions(ctx, vs, "wal/0002", bytes.NewReader([]byte("payload")), WithSizeHint(7)))
+	st, err = vs.Stat(ctx, "wal/0002")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(mem.Files["wal/0002.gz"])), st.StoredSize)
+	assert.Equal(t, int64(7), st.LogicalSize)
+
+	require.NoError(t, vs.Put(ctx, "wal/0003", bytes.NewReader([]byte("payload"))))
+	st, err = vs.Stat(ctx, "wal/0003")
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), st.LogicalSize, "written without a size hint")
+}
+
+func TestVariadicStorage_ListInfo_Dedup(t *testing.T) {
+	ctx := context.Background()
+
+	aes := aesgcm.NewChunkedGCMCrypter("password")
+	gzipPair := &CodecPair{
+		Compressor:   codec.GzipCompressor{},
+		Decompressor: codec.GzipDecompressor{},
+	}
+	mem := NewInMemoryStorage()
+	mem.Files["p/a"] = []byte("1")
+	mem.Files["p/a.gz"] = []byte("22")
+	mem.Files["p/a.gz.aes"] = []byte("333")
+	mem.Files["p/b.aes"] = []byte("4444")
+
+	vs, err := NewVariadicStorage(mem, Algorithms{Gzip: gzipPair, AES: aes}, ".gz.aes")
+	require.NoError(t, err)
+
+	info, err := vs.ListInfo(ctx, "p")
+	require.NoError(t, err)
+	assert.Len(t, info, 4)
+
+	vs.DedupListInfo = true
+	info, err = vs.ListInfo(ctx, "p")
+	require.NoError(t, err)
+
+	got := make(map[string]FileInfo)
+	for _, fi := range info {
+		got[fi.Path] = fi
+	}
+	require.Len(t, got, 2)
+	assert.Len(t, info, 2)
+	assert.Equal(t, "p/a.gz.aes", got["p/a"].StoredPath)
+	assert.Equal(t, int64(3), got["p/a"].Size)
+	assert.Equal(t, "p/b.aes", got["p/b"].StoredPath)
+}
+
+// failingRenameStorage fails Rename for the configured stored names.
+type failingRenameStorage struct {
+	*InMemoryStorage
+	fail map[string]bool
+}
+
+func (f *failingRenameStorage) Rename(ctx context.Context, oldPath, newPath string) error {
+	if f.fail[oldPath] {
+		return errors.New("boom")
+	}
+	return f.InMemoryStorage.Rename(ctx, oldPath, newPath)
+}
+
+func TestVariadicStorage_Delete_ReportsEveryFailedVariant(t *testing.T) {
+	ctx := context.Background()
+
+	gzipPair := &CodecPair{
+		Compressor:   codec.GzipCompressor{},
+		Decompressor: codec.GzipDecompressor{},
+	}
+	backend := &failingDeleteStorage{
+		InMemoryStorage: NewInMemoryStorage(),
+		fail:            map[string]bool{"x": true, "x.gz": true},
+	}
+	backend.Files["x"] = []byte("1")
+	backend.Files["x.gz"] = []byte("2")
+	backend.Files["y.gz"] = []byte("3")
+
+	vs, err := NewVariadicStorage(backend, Algorithms{Gzip: gzipPair}, ".gz")
+	require.NoError(t, err)
+
+	err = vs.DeleteAllBulk(ctx, []string{"x", "y"})
+	var stored []string
+	for _, ve := range VariantErrors(err) {
+		assert.Equal(t, "delete", ve.Op)
+		assert.Equal(t, "x", ve.Path)
+		stored = append(stored, ve.Stored)
+	}
+	assert.ElementsMatch(t, []string{"x", "x.gz"}, stored)
+	assert.NotContains(t, backend.Files, "y.gz")
+
+	var ve *VariantError
+	require.ErrorAs(t, err, &ve)
+	backend.fail = nil
+	require.NoError(t, backend.Delete(ctx, ve.Stored), "the reported name can be retried")
+}
+
+func TestVariadicStorage_Rename_ReportsEveryFailedVariant(t *testing.T) {
+	ctx := context.Background()
+
+	gzipPair := &CodecPair{
+		Compressor:   codec.GzipCompressor{},
+		Decompressor: codec.GzipDecompressor{},
+	}
+	backend := &failingRenameStorage{
+		InMemoryStorage: NewInMemoryStorage(),
+		fail:            map[string]bool{"a": true},
+	}
+	backend.Files["a"] = []byte("1")
+	backend.Files["a.gz"] = []byte("2")
+
+	vs, err := NewVariadicStorage(backend, Algorithms{Gzip: gzipPair}, ".gz")
+	require.NoError(t, err)
+
+	err = vs.Rename(ctx, "a", "b")
+	ves := VariantErrors(err)
+	require.Len(t, ves, 1)
+	assert.Equal(t, &VariantError{Op: "rename", Path: "a", Stored: "a", Err: ves[0].Err}, ves[0])
+	assert.Contains(t, backend.Files, "b.gz", "the other variant is still renamed")
+}
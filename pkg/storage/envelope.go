@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/hashmap-kz/storecrypt/pkg/crypters"
+)
+
+// EnvelopeStorage encrypts every object with its own data key, which is
+// stored in the object header wrapped by a master key (local keyfile or
+// KMS, see crypters.KeyWrapper).
+//
+// Rotating the master key is a matter of constructing the storage with the
+// new key as primary and the old one in previous, then calling RewrapAll:
+// only the headers are rewritten, the payloads are never re-encrypted.
+type EnvelopeStorage struct {
+	*TransformingStorage
+	envelope *crypters.Envelope
+}
+
+var _ Storage = (*EnvelopeStorage)(nil)
+
+// NewEnvelopeStorage creates an EnvelopeStorage. New objects are wrapped
+// by primary; objects wrapped by any of previous remain readable.
+func NewEnvelopeStorage(backend Storage, primary crypters.KeyWrapper, previous ...crypters.KeyWrapper) *EnvelopeStorage {
+	env := crypters.NewEnvelope(primary, previous...)
+	return &EnvelopeStorage{
+		TransformingStorage: &TransformingStorage{Backend: backend, Crypter: env},
+		envelope:            env,
+	}
+}
+
+// KeyID returns the id of the master key that wraps the object's data key.
+func (es *EnvelopeStorage) KeyID(ctx context.Context, remotePath string) (string, error) {
+	rc, err := es.Backend.Get(ctx, es.encodePath(remotePath))
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	hdr, err := crypters.ReadEnvelopeHeader(rc)
+	if err != nil {
+		return "", err
+	}
+	return hdr.KeyID, nil
+}
+
+// Rewrap re-wraps the data key of a single object with the primary master
+// key. Objects already wrapped by the primary key are left untouched.
+func (es *EnvelopeStorage) Rewrap(ctx context.Context, remotePath string) error {
+	_, err := es.rewrap(ctx, es.encodePath(remotePath))
+	return err
+}
+
+// RewrapAll re-wraps every object under prefix with the primary master key
+// and returns the number of objects rewritten.
+func (es *EnvelopeStorage) RewrapAll(ctx context.Context, prefix string) (int, error) {
+	files, err := es.Backend.List(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	ext := es.envelope.FileExtension()
+	count := 0
+	var errs []error
+	for _, f := range files {
+		if !strings.HasSuffix(f, ext) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		changed, err := es.rewrap(ctx, f)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rewrap %q: %w", f, err))
+			continue
+		}
+		if changed {
+			count++
+		}
+	}
+	return count, errors.Join(errs...)
+}
+
+func (es *EnvelopeStorage) rewrap(ctx context.Context, encoded string) (bool, error) {
+	rc, err := es.Backend.Get(ctx, encoded)
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+
+	var head bytes.Buffer
+	hdr, err := crypters.ReadEnvelopeHeader(io.TeeReader(rc, &head))
+	if err != nil {
+		return false, err
+	}
+	if hdr.KeyID == es.envelope.PrimaryKeyID() {
+		return false, nil
+	}
+
+	// Write next to the original and rename over it: some backends
+	// truncate the destination on Put, which would clobber the object
+	// we are still reading from.
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(es.envelope.Rewrap(ctx, io.MultiReader(&head, rc), pw))
+	}()
+	tmp := encoded + ".rewrap-" + randomSuffix()
+	err = es.Backend.Put(ctx, tmp, pr)
+	_ = pr.Close()
+	if err != nil {
+		_ = es.Backend.Delete(ctx, tmp)
+		return false, err
+	}
+	_ = rc.Close()
+
+	if err := replaceObject(ctx, es.Backend, tmp, encoded); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// errReplaceIncomplete marks a replaceObject failure that left to missing:
+// both the original, moved aside, and its replacement are kept and named
+// in the error, and neither may be deleted.
+var errReplaceIncomplete = errors.New("replace incomplete")
+
+// replaceObject renames from over to. Some backends (SFTP servers without
+// posix-rename) refuse to rename over an existing object; then to is
+// moved aside, from renamed into place and the original deleted, or put
+// back if the rename fails again. On any other failure to is intact.
+func replaceObject(ctx context.Context, st Storage, from, to string) error {
+	err := st.Rename(ctx, from, to)
+	if err == nil {
+		return nil
+	}
+	aside := to + ".replaced-" + randomSuffix()
+	if asideErr := st.Rename(ctx, to, aside); asideErr != nil {
+		return errors.Join(err, asideErr)
+	}
+	if err := st.Rename(ctx, from, to); err != nil {
+		if restoreErr := st.Rename(ctx, aside, to); restoreErr != nil {
+			return fmt.Errorf("%w: %q is kept as %q, its replacement as %q: %w",
+				errReplaceIncomplete, to, aside, from, errors.Join(err, restoreErr))
+		}
+		return err
+	}
+	if err := st.Delete(ctx, aside); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("delete replaced %q: %w", aside, err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/hashmap-kz/storecrypt/pkg/crypters"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newEnvelopeKey(t *testing.T, id string) crypters.KeyWrapper {
+	t.Helper()
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	w, err := crypters.NewLocalKeyWrapper(id, key)
+	require.NoError(t, err)
+	return w
+}
+
+func TestEnvelopeStorage_RewrapAll(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	k1 := newEnvelopeKey(t, "k1")
+	k2 := newEnvelopeKey(t, "k2")
+
+	es := NewEnvelopeStorage(mem, k1)
+	require.NoError(t, es.Put(ctx, "data/a", bytes.NewReader([]byte("alpha"))))
+	require.NoError(t, es.Put(ctx, "data/b", bytes.NewReader([]byte("beta"))))
+	assert.Contains(t, mem.Files, "data/a.enc")
+	assert.NotContains(t, string(mem.Files["data/a.enc"]), "alpha")
+
+	rotated := NewEnvelopeStorage(mem, k2, k1)
+	n, err := rotated.RewrapAll(ctx, "data")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	// A second pass has nothing to do.
+	n, err = rotated.RewrapAll(ctx, "data")
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	id, err := rotated.KeyID(ctx, "data/a")
+	require.NoError(t, err)
+	assert.Equal(t, "k2", id)
+
+	files, err := mem.List(ctx, "data")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"data/a.enc", "data/b.enc"}, files)
+
+	rc, err := NewEnvelopeStorage(mem, k2).Get(ctx, "data/b")
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, "beta", string(got))
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// Errors every backend maps its own failures onto, so that callers can
+// check them with errors.Is whatever the backend. The first three are the
+// io/fs errors: local file system errors already match them, and checks
+// against fs.ErrNotExist keep working.
+var (
+	ErrNotExist      = fs.ErrNotExist
+	ErrAlreadyExists = fs.ErrExist
+	ErrPermission    = fs.ErrPermission
+
+	// ErrThrottled means the backend asked to slow down, e.g. S3 SlowDown
+	// or HTTP 429; the call is worth retrying later.
+	ErrThrottled = errors.New("storage: request throttled")
+
+	// ErrUnavailable means the backend could not be reached or failed
+	// internally, e.g. HTTP 503 or a lost SFTP connection.
+	ErrUnavailable = errors.New("storage: backend unavailable")
+)
+
+// classifiedError is a backend error that also matches one of the errors
+// above; its message is that of the backend error.
+type classifiedError struct {
+	err  error
+	kind error
+}
+
+func (e *classifiedError) Error() string { return e.err.Error() }
+
+func (e *classifiedError) Unwrap() []error { return []error{e.err, e.kind} }
+
+// classify makes err match kind as well, unless it already does.
+func classify(err, kind error) error {
+	if err == nil || kind == nil || errors.Is(err, kind) {
+		return err
+	}
+	return &classifiedError{err: err, kind: kind}
+}
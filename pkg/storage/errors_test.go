@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassify(t *testing.T) {
+	base := errors.New("503 Service Unavailable")
+	err := classify(fmt.Errorf("get %q: %w", "wal/0001", base), ErrUnavailable)
+
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.ErrorIs(t, err, base)
+	assert.NotErrorIs(t, err, ErrThrottled)
+	assert.Equal(t, `get "wal/0001": 503 Service Unavailable`, err.Error())
+
+	assert.NoError(t, classify(nil, ErrUnavailable))
+	assert.Same(t, err, classify(err, ErrUnavailable), "already classified")
+}
+
+func TestErrors_MatchFS(t *testing.T) {
+	ctx := context.Background()
+	local, err := NewLocal(&LocalStorageOpts{BaseDir: t.TempDir()})
+	require.NoError(t, err)
+	for name, st := range map[string]Storage{
+		"mem":   NewInMemoryStorage(),
+		"local": local,
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, st.Put(ctx, "a", strings.NewReader("a")))
+
+			_, err := st.Get(ctx, "missing")
+			assert.ErrorIs(t, err, ErrNotExist)
+			assert.ErrorIs(t, st.Rename(ctx, "missing", "b"), ErrNotExist)
+			_, err = StatObject(ctx, st, "missing")
+			assert.ErrorIs(t, err, ErrNotExist)
+		})
+	}
+}
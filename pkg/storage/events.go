@@ -0,0 +1,276 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Event describes a storage operation that started or finished.
+type Event struct {
+	Op   string // "put", "get", "delete", "delete_all", "delete_dir", "rename", ...
+	Path string
+
+	// Bytes is the number of bytes streamed so far: read from the Put
+	// reader, or by the caller from the Get reader. Zero for operations
+	// that move no data.
+	Bytes int64
+
+	// Duration is the time since the operation started; for Get it runs
+	// until the reader is closed.
+	Duration time.Duration
+
+	// Err is the error the operation failed with, if any.
+	Err error
+}
+
+// Events holds callbacks invoked by EventStorage. Any of them may be nil.
+// They are called from the goroutine doing the operation and must not
+// block.
+type Events struct {
+	// OnPutStart is called before the backend's Put.
+	OnPutStart func(Event)
+	// OnPutDone is called after a successful Put.
+	OnPutDone func(Event)
+	// OnGetDone is called when the reader of a successful Get is closed.
+	OnGetDone func(Event)
+	// OnDelete is called after each path is removed by Delete, DeleteAll,
+	// DeleteDir or DeleteAllBulk, and for the old path of a Rename, so that
+	// caches can be invalidated.
+	OnDelete func(Event)
+	// OnError is called when any operation fails, including reads from
+	// and closing of a Get reader.
+	OnError func(Event)
+}
+
+// EventStorage reports the operations on Backend to Events. It can wrap
+// any backend or wrapper, so embedding applications hook in once instead
+// of at every call site.
+type EventStorage struct {
+	Backend Storage
+	Events  Events
+
+	// observe, if set, sees every finished operation, successful or not.
+	observe func(Event)
+}
+
+var (
+	_ Storage     = &EventStorage{}
+	_ Stater      = &EventStorage{}
+	_ Walker      = &EventStorage{}
+	_ RangeReader = &EventStorage{}
+	_ Pinger      = &EventStorage{}
+)
+
+// NewEventStorage wraps backend with the given callbacks.
+func NewEventStorage(backend Storage, events Events) *EventStorage {
+	return &EventStorage{Backend: backend, Events: events}
+}
+
+func emit(fn func(Event), ev Event) {
+	if fn != nil {
+		fn(ev)
+	}
+}
+
+func (e *EventStorage) finished(ev Event) {
+	if e.observe != nil {
+		e.observe(ev)
+	}
+}
+
+// done reports the outcome of an operation that moves no data: to OnError
+// if it failed, otherwise to onSuccess.
+func (e *EventStorage) done(op, remotePath string, start time.Time, err error, onSuccess func(Event)) {
+	ev := Event{Op: op, Path: remotePath, Duration: time.Since(start), Err: err}
+	e.finished(ev)
+	if err != nil {
+		emit(e.Events.OnError, ev)
+		return
+	}
+	emit(onSuccess, ev)
+}
+
+func (e *EventStorage) Put(ctx context.Context, remotePath string, r io.Reader) error {
+	start := time.Now()
+	emit(e.Events.OnPutStart, Event{Op: "put", Path: remotePath})
+	var n int64
+	err := e.Backend.Put(ctx, remotePath, &countingReader{r: r, add: func(c int64) { n += c }})
+	ev := Event{Op: "put", Path: remotePath, Bytes: n, Duration: time.Since(start), Err: err}
+	e.finished(ev)
+	if err != nil {
+		emit(e.Events.OnError, ev)
+		return err
+	}
+	emit(e.Events.OnPutDone, ev)
+	return nil
+}
+
+func (e *EventStorage) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	start := time.Now()
+	rc, err := e.Backend.Get(ctx, remotePath)
+	if err != nil {
+		e.done("get", remotePath, start, err, nil)
+		return nil, err
+	}
+	return e.trackRead("get", remotePath, start, rc), nil
+}
+
+func (e *EventStorage) GetRange(ctx context.Context, remotePath string, offset, length int64) (io.ReadCloser, error) {
+	start := time.Now()
+	rc, err := GetRange(ctx, e.Backend, remotePath, offset, length)
+	if err != nil {
+		e.done("get_range", remotePath, start, err, nil)
+		return nil, err
+	}
+	return e.trackRead("get_range", remotePath, start, rc), nil
+}
+
+func (e *EventStorage) trackRead(op, remotePath string, start time.Time, rc io.ReadCloser) io.ReadCloser {
+	er := &eventReader{e: e, op: op, path: remotePath, start: start, c: rc}
+	er.countingReader = countingReader{r: rc, add: func(n int64) { er.n += n }}
+	return er
+}
+
+// eventReader counts the bytes read and reports OnGetDone (or OnError)
+// once, on Close. A read error other than EOF is reported as it happens.
+type eventReader struct {
+	countingReader
+	e     *EventStorage
+	op    string
+	path  string
+	start time.Time
+	n     int64
+	c     io.Closer
+	once  sync.Once
+
+	readErr error // first read error other than EOF
+}
+
+func (r *eventReader) Read(p []byte) (int, error) {
+	n, err := r.countingReader.Read(p)
+	if err != nil && err != io.EOF {
+		if r.readErr == nil {
+			r.readErr = err
+		}
+		emit(r.e.Events.OnError, Event{Op: r.op, Path: r.path, Bytes: r.n, Duration: time.Since(r.start), Err: err})
+	}
+	return n, err
+}
+
+func (r *eventReader) Close() error {
+	err := r.c.Close()
+	r.once.Do(func() {
+		ev := Event{Op: r.op, Path: r.path, Bytes: r.n, Duration: time.Since(r.start), Err: err}
+		observed := ev
+		if observed.Err == nil {
+			observed.Err = r.readErr
+		}
+		r.e.finished(observed)
+		if err != nil {
+			emit(r.e.Events.OnError, ev)
+			return
+		}
+		emit(r.e.Events.OnGetDone, ev)
+	})
+	return err
+}
+
+func (e *EventStorage) List(ctx context.Context, remotePath string) ([]string, error) {
+	start := time.Now()
+	names, err := e.Backend.List(ctx, remotePath)
+	e.done("list", remotePath, start, err, nil)
+	return names, err
+}
+
+func (e *EventStorage) ListInfo(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	start := time.Now()
+	infos, err := e.Backend.ListInfo(ctx, remotePath)
+	e.done("list_info", remotePath, start, err, nil)
+	return infos, err
+}
+
+func (e *EventStorage) WalkInfo(ctx context.Context, remotePath string, fn func(fi FileInfo) error) error {
+	start := time.Now()
+	err := WalkInfo(ctx, e.Backend, remotePath, fn)
+	e.done("walk_info", remotePath, start, err, nil)
+	return err
+}
+
+func (e *EventStorage) Delete(ctx context.Context, remotePath string) error {
+	start := time.Now()
+	err := e.Backend.Delete(ctx, remotePath)
+	e.done("delete", remotePath, start, err, e.Events.OnDelete)
+	return err
+}
+
+func (e *EventStorage) DeleteAll(ctx context.Context, remotePath string) error {
+	start := time.Now()
+	err := e.Backend.DeleteAll(ctx, remotePath)
+	e.done("delete_all", remotePath, start, err, e.Events.OnDelete)
+	return err
+}
+
+func (e *EventStorage) DeleteDir(ctx context.Context, remotePath string) error {
+	start := time.Now()
+	err := e.Backend.DeleteDir(ctx, remotePath)
+	e.done("delete_dir", remotePath, start, err, e.Events.OnDelete)
+	return err
+}
+
+// DeleteAllBulk reports OnDelete for every path once the batch succeeded,
+// or a single OnError for the batch.
+func (e *EventStorage) DeleteAllBulk(ctx context.Context, paths []string) error {
+	start := time.Now()
+	err := e.Backend.DeleteAllBulk(ctx, paths)
+	d := time.Since(start)
+	e.finished(Event{Op: "delete_all_bulk", Duration: d, Err: err})
+	if err != nil {
+		emit(e.Events.OnError, Event{Op: "delete_all_bulk", Duration: d, Err: err})
+		return err
+	}
+	for _, p := range paths {
+		emit(e.Events.OnDelete, Event{Op: "delete_all_bulk", Path: p, Duration: d})
+	}
+	return nil
+}
+
+func (e *EventStorage) Exists(ctx context.Context, remotePath string) (bool, error) {
+	start := time.Now()
+	ok, err := e.Backend.Exists(ctx, remotePath)
+	e.done("exists", remotePath, start, err, nil)
+	return ok, err
+}
+
+func (e *EventStorage) Stat(ctx context.Context, remotePath string) (FileInfo, error) {
+	start := time.Now()
+	fi, err := StatObject(ctx, e.Backend, remotePath)
+	e.done("stat", remotePath, start, err, nil)
+	return fi, err
+}
+
+func (e *EventStorage) ListTopLevelDirs(ctx context.Context, prefix string) (map[string]bool, error) {
+	start := time.Now()
+	dirs, err := e.Backend.ListTopLevelDirs(ctx, prefix)
+	e.done("list_top_level_dirs", prefix, start, err, nil)
+	return dirs, err
+}
+
+func (e *EventStorage) Rename(ctx context.Context, oldRemotePath, newRemotePath string) error {
+	start := time.Now()
+	err := e.Backend.Rename(ctx, oldRemotePath, newRemotePath)
+	onSuccess := e.Events.OnDelete
+	if oldRemotePath == newRemotePath {
+		onSuccess = nil // nothing moved
+	}
+	e.done("rename", oldRemotePath, start, err, onSuccess)
+	return err
+}
+
+func (e *EventStorage) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := Ping(ctx, e.Backend)
+	e.done("ping", "", start, err, nil)
+	return err
+}
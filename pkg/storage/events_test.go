@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type eventLog struct {
+	events []string
+	last   Event
+}
+
+func (l *eventLog) record(kind string) func(Event) {
+	return func(ev Event) {
+		l.events = append(l.events, kind+" "+ev.Op+" "+ev.Path)
+		l.last = ev
+	}
+}
+
+func (l *eventLog) hooks() Events {
+	return Events{
+		OnPutStart: l.record("put-start"),
+		OnPutDone:  l.record("put-done"),
+		OnGetDone:  l.record("get-done"),
+		OnDelete:   l.record("delete"),
+		OnError:    l.record("error"),
+	}
+}
+
+func TestEventStorage(t *testing.T) {
+	ctx := context.Background()
+	log := &eventLog{}
+	es := NewEventStorage(NewInMemoryStorage(), log.hooks())
+
+	require.NoError(t, es.Put(ctx, "wal/0001", strings.NewReader("hello")))
+	assert.Equal(t, []string{"put-start put wal/0001", "put-done put wal/0001"}, log.events)
+	assert.Equal(t, int64(5), log.last.Bytes)
+
+	log.events = nil
+	rc, err := es.Get(ctx, "wal/0001")
+	require.NoError(t, err)
+	_, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Empty(t, log.events, "Get is reported when the reader is closed")
+	require.NoError(t, rc.Close())
+	require.NoError(t, rc.Close())
+	assert.Equal(t, []string{"get-done get wal/0001"}, log.events)
+	assert.Equal(t, int64(5), log.last.Bytes)
+
+	log.events = nil
+	require.NoError(t, es.Rename(ctx, "wal/0001", "wal/0002"))
+	require.NoError(t, es.Put(ctx, "wal/0003", strings.NewReader("x")))
+	require.NoError(t, es.DeleteAllBulk(ctx, []string{"wal/0002", "wal/0003"}))
+	assert.Equal(t, []string{
+		"delete rename wal/0001",
+		"put-start put wal/0003", "put-done put wal/0003",
+		"delete delete_all_bulk wal/0002", "delete delete_all_bulk wal/0003",
+	}, log.events)
+
+	log.events = nil
+	_, err = es.Get(ctx, "wal/missing")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	assert.Equal(t, []string{"error get wal/missing"}, log.events)
+	assert.ErrorIs(t, log.last.Err, fs.ErrNotExist)
+}
+
+func TestEventStorage_PutError(t *testing.T) {
+	ctx := context.Background()
+	log := &eventLog{}
+	es := NewEventStorage(NewInMemoryStorage(), log.hooks())
+
+	errRead := errors.New("read failed")
+	err := es.Put(ctx, "a", io.MultiReader(strings.NewReader("abc"), &errReader{err: errRead}))
+	assert.ErrorIs(t, err, errRead)
+	assert.Equal(t, []string{"put-start put a", "error put a"}, log.events)
+	assert.Equal(t, int64(3), log.last.Bytes)
+}
+
+type errReader struct{ err error }
+
+func (r *errReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestEventStorage_NilHooks(t *testing.T) {
+	ctx := context.Background()
+	es := NewEventStorage(NewInMemoryStorage(), Events{})
+	require.NoError(t, es.Put(ctx, "a", strings.NewReader("x")))
+	require.NoError(t, es.Delete(ctx, "a"))
+	assert.Error(t, es.Delete(ctx, "a"))
+}
@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ExclusivePutter is implemented by backends that can create an object
+// atomically if and only if it does not exist yet (O_EXCL semantics on
+// local disks, If-None-Match on S3). Of concurrent calls for one path,
+// exactly one succeeds, and readers never see a partial object.
+type ExclusivePutter interface {
+	// PutIfAbsent writes r to remotePath unless an object exists there,
+	// in which case it fails with an error matching ErrAlreadyExists.
+	PutIfAbsent(ctx context.Context, remotePath string, r io.Reader) error
+}
+
+// PutIfAbsent creates remotePath from r if it does not exist, failing with
+// ErrAlreadyExists if it does. Storages that are not ExclusivePutters
+// fail with errors.ErrUnsupported: an Exists check followed by Put would
+// race.
+func PutIfAbsent(ctx context.Context, st Storage, remotePath string, r io.Reader) error {
+	if ep, ok := st.(ExclusivePutter); ok {
+		return ep.PutIfAbsent(ctx, remotePath, r)
+	}
+	return fmt.Errorf("put if absent %q: %T: %w", remotePath, st, errors.ErrUnsupported)
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPutIfAbsent(t *testing.T) {
+	ctx := context.Background()
+	local, err := NewLocal(&LocalStorageOpts{BaseDir: t.TempDir(), FsyncOnWrite: true})
+	require.NoError(t, err)
+
+	for name, st := range map[string]Storage{
+		"mem":    NewInMemoryStorage(),
+		"local":  local,
+		"prefix": NewPrefixStorage(NewInMemoryStorage(), "p"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, PutIfAbsent(ctx, st, "locks/a", strings.NewReader("first")))
+			err := PutIfAbsent(ctx, st, "locks/a", strings.NewReader("second"))
+			require.ErrorIs(t, err, ErrAlreadyExists)
+
+			rc, err := st.Get(ctx, "locks/a")
+			require.NoError(t, err)
+			data, err := io.ReadAll(rc)
+			require.NoError(t, err)
+			require.NoError(t, rc.Close())
+			assert.Equal(t, "first", string(data))
+
+			files, err := st.List(ctx, "locks")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"locks/a"}, files, "no temporary files are left behind")
+
+			// Of concurrent creators exactly one wins.
+			var wg sync.WaitGroup
+			errs := make([]error, 16)
+			for i := range errs {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					errs[i] = PutIfAbsent(ctx, st, "locks/b", strings.NewReader(fmt.Sprint(i)))
+				}()
+			}
+			wg.Wait()
+			won := 0
+			for _, err := range errs {
+				if err == nil {
+					won++
+				} else {
+					require.ErrorIs(t, err, ErrAlreadyExists)
+				}
+			}
+			assert.Equal(t, 1, won)
+		})
+	}
+}
+
+func TestPutIfAbsent_Unsupported(t *testing.T) {
+	st := NewFSStorage(nil)
+	err := PutIfAbsent(context.Background(), st, "a", strings.NewReader("x"))
+	assert.ErrorIs(t, err, errors.ErrUnsupported)
+}
@@ -0,0 +1,24 @@
+//go:build linux
+
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize is FALLOC_FL_KEEP_SIZE: reserve the blocks without
+// changing the file size, so a short write leaves no zero tail.
+const fallocKeepSize = 0x1
+
+// preallocate reserves size bytes for f. File systems without fallocate
+// support are not an error; running out of space is.
+func preallocate(f *os.File, size int64) error {
+	err := syscall.Fallocate(int(f.Fd()), fallocKeepSize, 0, size)
+	if err == nil || errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) || errors.Is(err, syscall.EINVAL) {
+		return nil
+	}
+	return &fs.PathError{Op: "fallocate", Path: f.Name(), Err: err}
+}
@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+)
+
+type fsStorage struct {
+	fsys fs.FS
+}
+
+var (
+	_ Storage     = &fsStorage{}
+	_ Stater      = &fsStorage{}
+	_ Walker      = &fsStorage{}
+	_ RangeReader = &fsStorage{}
+)
+
+// NewFSStorage serves the files of fsys as objects, e.g. an embed.FS of
+// test fixtures, a zip.Reader of a backup bundle or an os.DirFS. It is
+// read-only: writes fail with ErrReadOnly.
+func NewFSStorage(fsys fs.FS) Storage {
+	return &fsStorage{fsys: fsys}
+}
+
+// name maps a remote path to a name of the file system, "." for the root.
+func (s *fsStorage) name(remotePath string) (string, error) {
+	clean, err := CleanPath(remotePath)
+	if err != nil {
+		return "", err
+	}
+	if clean == "" {
+		return ".", nil
+	}
+	return clean, nil
+}
+
+// open opens the regular file at remotePath.
+func (s *fsStorage) open(remotePath string) (fs.File, fs.FileInfo, error) {
+	name, err := objectKey(remotePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, err
+	}
+	if !info.Mode().IsRegular() {
+		_ = f.Close()
+		return nil, nil, fmt.Errorf("open %q: not a regular file: %w", name, fs.ErrNotExist)
+	}
+	return f, info, nil
+}
+
+func (s *fsStorage) Put(_ context.Context, _ string, _ io.Reader) error {
+	return ErrReadOnly
+}
+
+func (s *fsStorage) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	f, info, err := s.open(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	return trackGetProgress(ctx, remotePath, f, info.Size()), nil
+}
+
+// GetRange seeks files that can, such as those of os.DirFS and embed.FS;
+// the leading bytes of other files (e.g. compressed zip entries) are read
+// and discarded.
+func (s *fsStorage) GetRange(_ context.Context, remotePath string, offset, length int64) (io.ReadCloser, error) {
+	f, _, err := s.open(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	if seeker, ok := f.(io.Seeker); ok {
+		_, err = seeker.Seek(offset, io.SeekStart)
+	} else if _, err = io.CopyN(io.Discard, f, offset); errors.Is(err, io.EOF) {
+		err = nil
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return limitRange(f, length), nil
+}
+
+func (s *fsStorage) List(_ context.Context, remotePath string) ([]string, error) {
+	root, err := s.name(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+
+	err = fs.WalkDir(s.fsys, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == root && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return fmt.Errorf("error accessing path %q: %w", p, err)
+		}
+		if p == root || d.IsDir() {
+			return nil
+		}
+		result = append(result, p)
+		return nil
+	})
+	return result, err
+}
+
+func (s *fsStorage) ListInfo(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	return collectInfo(ctx, s, remotePath)
+}
+
+func (s *fsStorage) WalkInfo(ctx context.Context, remotePath string, fn func(fi FileInfo) error) error {
+	root, err := s.name(remotePath)
+	if err != nil {
+		return err
+	}
+	opts := ListOptionsFromContext(ctx)
+
+	return fs.WalkDir(s.fsys, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == root && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return fmt.Errorf("error accessing path %q: %w", p, err)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if p == root || (d.IsDir() && !opts.IncludeDirs) {
+			return nil
+		}
+		// Skip the stat of names the filters rule out anyway.
+		if !d.IsDir() && !opts.MatchName(p) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return fn(FileInfo{Path: p, ModTime: info.ModTime(), IsDir: true})
+		}
+		fi := FileInfo{
+			Path:    p,
+			ModTime: info.ModTime(),
+			Size:    info.Size(),
+		}
+		if !opts.Match(fi) {
+			return nil
+		}
+		return fn(fi)
+	})
+}
+
+func (s *fsStorage) Delete(_ context.Context, _ string) error {
+	return ErrReadOnly
+}
+
+func (s *fsStorage) DeleteAll(_ context.Context, _ string) error {
+	return ErrReadOnly
+}
+
+func (s *fsStorage) DeleteDir(_ context.Context, _ string) error {
+	return ErrReadOnly
+}
+
+func (s *fsStorage) DeleteAllBulk(_ context.Context, _ []string) error {
+	return ErrReadOnly
+}
+
+func (s *fsStorage) Exists(ctx context.Context, remotePath string) (bool, error) {
+	_, err := s.Stat(ctx, remotePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *fsStorage) Stat(_ context.Context, remotePath string) (FileInfo, error) {
+	name, err := objectKey(remotePath)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	info, err := fs.Stat(s.fsys, name)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return FileInfo{}, fmt.Errorf("stat %q: not a regular file: %w", name, fs.ErrNotExist)
+	}
+	return FileInfo{Path: name, ModTime: info.ModTime(), Size: info.Size()}, nil
+}
+
+func (s *fsStorage) ListTopLevelDirs(_ context.Context, prefix string) (map[string]bool, error) {
+	root, err := s.name(prefix)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]bool)
+
+	entries, err := fs.ReadDir(s.fsys, root)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return result, nil
+		}
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			result[path.Join(root, entry.Name())] = true
+		}
+	}
+	return result, nil
+}
+
+func (s *fsStorage) Rename(_ context.Context, _, _ string) error {
+	return ErrReadOnly
+}
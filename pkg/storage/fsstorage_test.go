@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFSStorage_MapFS(t *testing.T) {
+	ctx := context.Background()
+	mtime := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	st := NewFSStorage(fstest.MapFS{
+		"base/0001.gz": {Data: []byte("base"), ModTime: mtime},
+		"wal/0001.gz":  {Data: []byte("one")},
+		"wal/0002.gz":  {Data: []byte("two!")},
+		"wal/sub/0003": {Data: []byte("three")},
+	})
+
+	files, err := st.List(ctx, "wal")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"wal/0001.gz", "wal/0002.gz", "wal/sub/0003"}, files)
+
+	infos, err := ListInfoWithOptions(ctx, st, "", WithSuffix(".gz"), WithSizeBetween(4, 0))
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	assert.Equal(t, FileInfo{Path: "base/0001.gz", ModTime: mtime, Size: 4}, infos[0])
+	assert.Equal(t, "wal/0002.gz", infos[1].Path)
+
+	rc, err := GetRange(ctx, st, "wal/sub/0003", 1, 3)
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, "hre", string(data))
+
+	dirs, err := st.ListTopLevelDirs(ctx, "wal")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"wal/sub": true}, dirs)
+
+	ok, err := st.Exists(ctx, "wal")
+	require.NoError(t, err)
+	assert.False(t, ok, "directories are not objects")
+	_, err = st.Get(ctx, "wal/missing")
+	assert.ErrorIs(t, err, ErrNotExist)
+	files, err = st.List(ctx, "missing")
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	assert.ErrorIs(t, st.Put(ctx, "wal/0004.gz", strings.NewReader("")), ErrReadOnly)
+	assert.ErrorIs(t, st.DeleteAll(ctx, "wal"), ErrReadOnly)
+	assert.ErrorIs(t, st.Rename(ctx, "wal/0001.gz", "wal/0005.gz"), ErrReadOnly)
+}
+
+func TestFSStorage_ZipBundle(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range map[string]string{"wal/0001": "0123456789", "manifest.json": "{}"} {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	st := NewFSStorage(zr)
+
+	files, err := st.List(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"manifest.json", "wal/0001"}, files)
+
+	// Zip entries do not seek: the leading bytes are skipped.
+	rc, err := GetRange(ctx, st, "wal/0001", 7, -1)
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, "789", string(data))
+}
@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashmap-kz/storecrypt/pkg/crypters"
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/aesgcm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The golden corpus under testdata/golden holds objects written by earlier
+// releases. Every release must decode all of them: a failure here means the
+// on-disk format changed. Fixtures are only ever added, never rewritten;
+// a new format (e.g. a header version) gets a new set next to the old ones.
+//
+// Missing fixtures are written with:
+//
+//	go test ./pkg/storage -run TestGolden -update-golden
+var updateGolden = flag.Bool("update-golden", false, "write missing fixtures of the golden corpus")
+
+const (
+	goldenDir      = "testdata/golden"
+	goldenPassword = "storecrypt-golden"
+	goldenObject   = "object"
+)
+
+// goldenSeekableFrame is small enough for the plaintext to span several
+// frames.
+const goldenSeekableFrame = 1024
+
+func goldenKey() []byte {
+	sum := sha256.Sum256([]byte(goldenPassword))
+	return sum[:]
+}
+
+// goldenAlgorithms returns the algorithms the corpus is written and read
+// with. The ".aes" crypter is: the chunked GCM crypter, or
+// PasswordAES if password is set.
+func goldenAlgorithms(t *testing.T, password bool) Algorithms {
+	t.Helper()
+	var aes crypt.Crypter = aesgcm.NewChunkedGCMCrypter(goldenPassword)
+	if password {
+		pw, err := crypters.NewPasswordAES(goldenPassword, crypters.KDFParams{
+			Algorithm: crypters.KDFScrypt, Iterations: 10, Memory: 8, Parallelism: 1,
+		})
+		require.NoError(t, err)
+		aes = pw
+	}
+	xchacha, err := crypters.NewXChaCha20(goldenKey())
+	require.NoError(t, err)
+	wrapper, err := crypters.NewLocalKeyWrapper("golden", goldenKey())
+	require.NoError(t, err)
+	recipient, err := crypters.NewAgeScryptRecipient(goldenPassword)
+	require.NoError(t, err)
+	recipient.SetWorkFactor(10)
+	identity, err := crypters.NewAgeScryptIdentity(goldenPassword)
+	require.NoError(t, err)
+	age := crypters.NewAge([]crypters.AgeRecipient{recipient}, []crypters.AgeIdentity{identity})
+	return Algorithms{
+		Gzip: &CodecPair{Compressor: codec.GzipCompressor{}, Decompressor: codec.GzipDecompressor{}},
+		Zstd: &CodecPair{Compressor: codec.ZstdCompressor{}, Decompressor: codec.ZstdDecompressor{}},
+		AES:  aes,
+		Crypters: map[string]crypt.Crypter{
+			".age":     age,
+			".xchacha": xchacha,
+			".enc":     crypters.NewEnvelope(wrapper),
+		},
+	}
+}
+
+// goldenSet is a directory of the corpus: objects written with one
+// configuration, one per extension.
+type goldenSet struct {
+	dir      string
+	exts     []string
+	password bool // ".aes" is PasswordAES
+
+	// For VariadicStorage sets.
+	header bool
+
+	// For TransformingStorage sets.
+	transforming bool
+	trailer      bool
+	seekable     bool
+}
+
+var goldenExts = []string{
+	"", ".gz", ".zst",
+	".aes", ".gz.aes", ".zst.aes",
+	".age", ".zst.age",
+	".xchacha", ".zst.xchacha",
+	".enc", ".gz.enc",
+}
+
+var goldenTransformingExts = []string{".gz.enc", ".zst.aes"}
+
+var goldenSets = []goldenSet{
+	{dir: "noheader", exts: goldenExts},
+	{dir: "header-v1", exts: goldenExts, header: true},
+	{dir: "password-noheader", exts: []string{".aes", ".zst.aes"}, password: true},
+	{dir: "password-header-v1", exts: []string{".aes", ".zst.aes"}, password: true, header: true},
+	{dir: "trailer-noheader", exts: goldenTransformingExts, transforming: true, trailer: true},
+	{dir: "trailer-header-v1", exts: goldenTransformingExts, transforming: true, trailer: true, header: true},
+	{dir: "seekable-v1", exts: goldenTransformingExts, transforming: true, seekable: true},
+}
+
+// transformingStorage returns the TransformingStorage of a set with ext over
+// backend.
+func (gs *goldenSet) transformingStorage(t *testing.T, backend Storage, ext string) *TransformingStorage {
+	t.Helper()
+	alg := goldenAlgorithms(t, gs.password)
+	ts := &TransformingStorage{
+		Backend:          backend,
+		WriteHeader:      gs.header,
+		IntegrityTrailer: gs.trailer,
+	}
+	if gs.seekable {
+		ts.SeekableFrameSize = goldenSeekableFrame
+	}
+	for prefix, pair := range map[string]*CodecPair{".gz": alg.Gzip, ".zst": alg.Zstd} {
+		if strings.HasPrefix(ext, prefix+".") || ext == prefix {
+			ts.Compressor, ts.Decompressor = pair.Compressor, pair.Decompressor
+		}
+	}
+	for _, nc := range alg.crypters() {
+		if strings.HasSuffix(ext, nc.ext) {
+			ts.Crypter = nc.crypter
+		}
+	}
+	return ts
+}
+
+func goldenPlaintext(t *testing.T) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(goldenDir, "plaintext.txt"))
+	if errors.Is(err, fs.ErrNotExist) && *updateGolden {
+		var buf bytes.Buffer
+		for i := 0; buf.Len() < 5*goldenSeekableFrame+123; i++ {
+			fmt.Fprintf(&buf, "%06d the quick brown fox jumps over the lazy dog %x\n", i, i*i)
+		}
+		require.NoError(t, os.MkdirAll(goldenDir, 0o750))
+		require.NoError(t, os.WriteFile(filepath.Join(goldenDir, "plaintext.txt"), buf.Bytes(), 0o600))
+		return buf.Bytes()
+	}
+	require.NoError(t, err)
+	return data
+}
+
+// writeGoldenSet writes the fixtures of gs that do not exist yet.
+func writeGoldenSet(t *testing.T, gs *goldenSet, plaintext []byte) {
+	t.Helper()
+	ctx := ContextWithPutOptions(context.Background(), WithSizeHint(int64(len(plaintext))))
+	dir := filepath.Join(goldenDir, gs.dir)
+	for _, ext := range gs.exts {
+		name := filepath.Join(dir, goldenObject+ext)
+		if _, err := os.Stat(name); err == nil {
+			continue
+		}
+		mem := NewInMemoryStorage()
+		if gs.transforming {
+			require.NoError(t, gs.transformingStorage(t, mem, ext).Put(ctx, goldenObject, bytes.NewReader(plaintext)))
+		} else {
+			vs, err := NewVariadicStorage(mem, goldenAlgorithms(t, gs.password), ext)
+			require.NoError(t, err)
+			vs.WriteHeader = gs.header
+			require.NoError(t, vs.Put(ctx, goldenObject, bytes.NewReader(plaintext)))
+		}
+		data, ok := mem.Files[goldenObject+ext]
+		require.True(t, ok, "%s not written", name)
+		require.NoError(t, os.MkdirAll(dir, 0o750))
+		require.NoError(t, os.WriteFile(name, data, 0o600))
+		t.Logf("wrote %s", name)
+	}
+}
+
+func TestGolden(t *testing.T) {
+	plaintext := goldenPlaintext(t)
+	for i := range goldenSets {
+		gs := &goldenSets[i]
+		t.Run(gs.dir, func(t *testing.T) {
+			if *updateGolden {
+				writeGoldenSet(t, gs, plaintext)
+			}
+			for _, ext := range gs.exts {
+				t.Run("object"+ext, func(t *testing.T) {
+					testGoldenObject(t, gs, ext, plaintext)
+				})
+			}
+		})
+	}
+}
+
+func testGoldenObject(t *testing.T, gs *goldenSet, ext string, plaintext []byte) {
+	ctx := context.Background()
+	stored, err := os.ReadFile(filepath.Join(goldenDir, gs.dir, goldenObject+ext))
+	if errors.Is(err, fs.ErrNotExist) {
+		// Combinations are listed before their fixtures exist; the next
+		// -update-golden run adds them.
+		t.Skip("no fixture yet; add it with -update-golden")
+	}
+	require.NoError(t, err)
+
+	mem := NewInMemoryStorage()
+	mem.Files[goldenObject+ext] = stored
+
+	_, _, hasHeader := parseObjectHeader(stored)
+	assert.Equal(t, gs.header || gs.seekable, hasHeader, "object header")
+
+	var st Storage
+	if gs.transforming {
+		st = gs.transformingStorage(t, mem, ext)
+	} else {
+		// Read through a storage configured for another write extension,
+		// as a later release with different settings would.
+		vs, err := NewVariadicStorage(mem, goldenAlgorithms(t, gs.password), "")
+		require.NoError(t, err)
+		st = vs
+	}
+
+	rc, err := st.Get(ctx, goldenObject)
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.True(t, bytes.Equal(plaintext, got), "decoded %d bytes, want %d", len(got), len(plaintext))
+
+	if gs.seekable {
+		off, n := int64(3*goldenSeekableFrame-10), int64(100)
+		rc, err := GetRange(ctx, st, goldenObject, off, n)
+		require.NoError(t, err)
+		got, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		assert.Equal(t, string(plaintext[off:off+n]), string(got))
+	}
+}
@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+)
+
+// ObjectHeaderVersion is the current version of the object header format.
+const ObjectHeaderVersion = 1
+
+var objectHeaderMagic = []byte("SCOH")
+
+// maxObjectHeaderLen bounds the encoded header: magic, version, flags,
+// three u8-prefixed strings, size and checksum.
+const maxObjectHeaderLen = 4 + 1 + 1 + 3*(1+255) + 8 + 4
+
+const (
+	// HeaderFlagTrailer marks objects whose plaintext ends with an
+	// integrity trailer (see TransformingStorage.IntegrityTrailer).
+	HeaderFlagTrailer uint8 = 1 << 0
+
+	// HeaderFlagSeekable marks objects stored as independently decodable
+	// frames followed by a chunk index (see
+	// TransformingStorage.SeekableFrameSize).
+	HeaderFlagSeekable uint8 = 1 << 1
+)
+
+// ErrUnsupportedHeader is returned when an object header names a codec or
+// crypter the storage is not configured with, or has an unknown version.
+var ErrUnsupportedHeader = errors.New("unsupported object header")
+
+// UnknownTransformError is returned when an object header names a codec or
+// crypter that is not configured. It matches ErrUnsupportedHeader.
+type UnknownTransformError struct {
+	Kind string // "codec" or "crypter"
+	Name string // as recorded in the header, e.g. "zst"
+}
+
+func (e *UnknownTransformError) Error() string {
+	return fmt.Sprintf("%s: %s %q is not configured", ErrUnsupportedHeader, e.Kind, e.Name)
+}
+
+func (e *UnknownTransformError) Unwrap() error {
+	return ErrUnsupportedHeader
+}
+
+// crypterMismatch reports a header naming another crypter than the one
+// configured for the object. Headers are only protected by a CRC, so one
+// must never switch encryption off or swap the crypter: whoever can write
+// to the backend could otherwise have forged plaintext returned as if it
+// had been decrypted.
+func crypterMismatch(got, want string) error {
+	return fmt.Errorf("%w: crypter %q, but objects here are encrypted with %q", ErrUnsupportedHeader, got, want)
+}
+
+// ObjectHeader is the optional clear-text header written in front of the
+// transformed stream (see TransformingStorage.WriteHeader and
+// VariadicStorage.WriteHeader). It makes objects self-describing, so they
+// can be decoded even when their extension is missing or wrong.
+//
+// Layout (big endian):
+//
+//	"SCOH" | version u8 | flags u8 | codec | crypter | key id | size i64 | crc32 u32
+//
+// where codec, crypter and key id are u8-length-prefixed strings and the
+// CRC covers every preceding byte.
+type ObjectHeader struct {
+	Version uint8
+	Flags   uint8  // HeaderFlag* bits
+	Codec   string // compression extension without the dot ("gz"), "" for none
+	Crypter string // encryption extension without the dot ("aes"), "" for none
+	KeyID   string // key identifier, if the crypter exposes one
+	Size    int64  // plaintext size, -1 if unknown when writing
+}
+
+// KeyIdentifier is implemented by crypters that can name the key they
+// encrypt with; the id is recorded in the object header.
+type KeyIdentifier interface {
+	KeyID() string
+}
+
+// MarshalBinary encodes the header.
+func (h *ObjectHeader) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(objectHeaderMagic)
+	buf.WriteByte(h.Version)
+	buf.WriteByte(h.Flags)
+	for _, s := range []string{h.Codec, h.Crypter, h.KeyID} {
+		if len(s) > 255 {
+			return nil, fmt.Errorf("object header field %q too long", s)
+		}
+		buf.WriteByte(byte(len(s)))
+		buf.WriteString(s)
+	}
+	_ = binary.Write(&buf, binary.BigEndian, h.Size)
+	_ = binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(buf.Bytes()))
+	return buf.Bytes(), nil
+}
+
+// parseObjectHeader decodes a header from the start of b and returns its
+// encoded length. ok is false if b does not start with a valid header.
+func parseObjectHeader(b []byte) (h *ObjectHeader, n int, ok bool) {
+	if !bytes.HasPrefix(b, objectHeaderMagic) {
+		return nil, 0, false
+	}
+	r := bytes.NewReader(b[len(objectHeaderMagic):])
+	h = &ObjectHeader{}
+	var err error
+	if h.Version, err = r.ReadByte(); err != nil {
+		return nil, 0, false
+	}
+	if h.Flags, err = r.ReadByte(); err != nil {
+		return nil, 0, false
+	}
+	fields := make([]string, 3)
+	for i := range fields {
+		l, err := r.ReadByte()
+		if err != nil {
+			return nil, 0, false
+		}
+		s := make([]byte, l)
+		if _, err := io.ReadFull(r, s); err != nil {
+			return nil, 0, false
+		}
+		fields[i] = string(s)
+	}
+	h.Codec, h.Crypter, h.KeyID = fields[0], fields[1], fields[2]
+	if err := binary.Read(r, binary.BigEndian, &h.Size); err != nil {
+		return nil, 0, false
+	}
+	n = len(b) - r.Len()
+	var sum uint32
+	if err := binary.Read(r, binary.BigEndian, &sum); err != nil {
+		return nil, 0, false
+	}
+	if sum != crc32.ChecksumIEEE(b[:n]) {
+		return nil, 0, false
+	}
+	return h, n + 4, true
+}
+
+// storedObjectHeader reads just the header of the stored object p; it is
+// nil for objects without one.
+func storedObjectHeader(ctx context.Context, st Storage, p string) (*ObjectHeader, error) {
+	rc, err := GetRange(withoutGetProgress(ctx), st, p, 0, maxObjectHeaderLen)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	hdr, _, _ := parseObjectHeader(b)
+	return hdr, nil
+}
+
+// readObjectHeader consumes an object header at the start of rc, if there
+// is one. The returned reader continues right after the header (or at the
+// very start when there is none) and closes rc.
+func readObjectHeader(rc io.ReadCloser) (*ObjectHeader, io.ReadCloser, error) {
+	br := bufio.NewReaderSize(rc, maxObjectHeaderLen)
+	out := readCloser{Reader: br, Closer: rc}
+
+	b, err := br.Peek(maxObjectHeaderLen)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, nil, err
+	}
+	h, n, ok := parseObjectHeader(b)
+	if !ok {
+		return nil, out, nil
+	}
+	if h.Version != ObjectHeaderVersion {
+		return nil, nil, fmt.Errorf("%w: version %d", ErrUnsupportedHeader, h.Version)
+	}
+	if _, err := br.Discard(n); err != nil {
+		return nil, nil, err
+	}
+	return h, out, nil
+}
+
+// newObjectHeader describes an object written with the given transforms.
+func newObjectHeader(comp codec.Compressor, c crypt.Crypter, size int64) *ObjectHeader {
+	h := &ObjectHeader{Version: ObjectHeaderVersion, Size: size}
+	if h.Size <= 0 {
+		h.Size = -1
+	}
+	if comp != nil {
+		h.Codec = strings.TrimPrefix(comp.FileExtension(), ".")
+	}
+	if c != nil {
+		h.Crypter = strings.TrimPrefix(c.FileExtension(), ".")
+		if k, ok := c.(KeyIdentifier); ok {
+			h.KeyID = k.KeyID()
+		}
+	}
+	return h
+}
+
+// prependObjectHeader returns a reader yielding the encoded header
+// followed by r.
+func prependObjectHeader(h *ObjectHeader, r io.Reader) (io.Reader, error) {
+	b, err := h.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return io.MultiReader(bytes.NewReader(b), r), nil
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/hashmap-kz/storecrypt/pkg/crypters"
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/aesgcm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObjectHeader_RoundTrip(t *testing.T) {
+	h := &ObjectHeader{Version: ObjectHeaderVersion, Codec: "zst", Crypter: "aes", KeyID: "k1", Size: 42}
+	b, err := h.MarshalBinary()
+	require.NoError(t, err)
+
+	got, n, ok := parseObjectHeader(append(b, "payload"...))
+	require.True(t, ok)
+	assert.Equal(t, len(b), n)
+	assert.Equal(t, h, got)
+
+	// A corrupted header is not mistaken for one.
+	b[6] ^= 0xff
+	_, _, ok = parseObjectHeader(b)
+	assert.False(t, ok)
+
+	_, _, ok = parseObjectHeader([]byte("SCO"))
+	assert.False(t, ok)
+}
+
+func TestVariadicStorage_WriteHeader_WrongExtension(t *testing.T) {
+	ctx := context.Background()
+
+	gzipPair := &CodecPair{
+		Compressor:   codec.GzipCompressor{},
+		Decompressor: codec.GzipDecompressor{},
+	}
+	zstdPair := &CodecPair{
+		Compressor:   codec.ZstdCompressor{},
+		Decompressor: codec.ZstdDecompressor{},
+	}
+	alg := Algorithms{Gzip: gzipPair, Zstd: zstdPair, AES: aesgcm.NewChunkedGCMCrypter("password")}
+
+	mem := NewInMemoryStorage()
+	vs, err := NewVariadicStorage(mem, alg, ".zst.aes")
+	require.NoError(t, err)
+	vs.WriteHeader = true
+	require.NoError(t, PutWithOptions(ctx, vs, "wal/0001", bytes.NewReader([]byte("hello")), WithSizeHint(5)))
+
+	hdr, _, ok := parseObjectHeader(mem.Files["wal/0001.zst.aes"])
+	require.True(t, ok)
+	assert.Equal(t, "zst", hdr.Codec)
+	assert.Equal(t, "aes", hdr.Crypter)
+	assert.Equal(t, int64(5), hdr.Size)
+
+	// Somebody renamed the object with the wrong extension.
+	mem.Files["wal/0001.gz"] = mem.Files["wal/0001.zst.aes"]
+	delete(mem.Files, "wal/0001.zst.aes")
+
+	rc, err := vs.Get(ctx, "wal/0001")
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, "hello", string(got))
+
+	// Objects without a header still decode by extension.
+	vs.WriteHeader = false
+	require.NoError(t, vs.Put(ctx, "wal/0002", bytes.NewReader([]byte("legacy"))))
+	rc, err = vs.Get(ctx, "wal/0002")
+	require.NoError(t, err)
+	got, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, "legacy", string(got))
+}
+
+func TestTransformingStorage_WriteHeader(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+
+	env := crypters.NewEnvelope(newEnvelopeKey(t, "master-1"))
+	ts := &TransformingStorage{
+		Backend:      mem,
+		Crypter:      env,
+		Compressor:   codec.GzipCompressor{},
+		Decompressor: codec.GzipDecompressor{},
+		WriteHeader:  true,
+	}
+	require.NoError(t, ts.Put(ctx, "a", bytes.NewReader([]byte("data"))))
+
+	hdr, _, ok := parseObjectHeader(mem.Files["a.gz.enc"])
+	require.True(t, ok)
+	assert.Equal(t, "gz", hdr.Codec)
+	assert.Equal(t, "enc", hdr.Crypter)
+	assert.Equal(t, "master-1", hdr.KeyID)
+	assert.Equal(t, int64(-1), hdr.Size)
+
+	rc, err := ts.Get(ctx, "a")
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, "data", string(got))
+
+	// A header naming transforms this storage lacks is rejected.
+	other := &TransformingStorage{Backend: mem, Crypter: env}
+	mem.Files["a.enc"] = mem.Files["a.gz.enc"]
+	_, err = other.Get(ctx, "a")
+	assert.ErrorIs(t, err, ErrUnsupportedHeader)
+	var unknown *UnknownTransformError
+	require.ErrorAs(t, err, &unknown)
+	assert.Equal(t, UnknownTransformError{Kind: "codec", Name: "gz"}, *unknown)
+	assert.EqualError(t, err, `unsupported object header: codec "gz" is not configured`)
+
+	// Algorithms resolve it.
+	other.Algorithms = &Algorithms{Gzip: &CodecPair{Compressor: codec.GzipCompressor{}, Decompressor: codec.GzipDecompressor{}}}
+	rc, err = other.Get(ctx, "a")
+	require.NoError(t, err)
+	got, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, "data", string(got))
+}
+
+// forgeObject returns data behind a header naming crypter (and no codec),
+// as anyone with write access to the backend could store it.
+func forgeObject(t *testing.T, crypter, data string) []byte {
+	t.Helper()
+	r, err := prependObjectHeader(&ObjectHeader{Version: ObjectHeaderVersion, Crypter: crypter, Size: -1}, bytes.NewReader([]byte(data)))
+	require.NoError(t, err)
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return b
+}
+
+func TestObjectHeader_CannotDowngradeCrypter(t *testing.T) {
+	ctx := context.Background()
+	aes := aesgcm.NewChunkedGCMCrypter("password")
+	xchacha, err := crypters.NewXChaCha20(bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+
+	t.Run("transforming", func(t *testing.T) {
+		mem := NewInMemoryStorage()
+		ts := &TransformingStorage{
+			Backend:    mem,
+			Crypter:    aes,
+			Algorithms: &Algorithms{Crypters: map[string]crypt.Crypter{".xchacha": xchacha}},
+		}
+		for _, crypter := range []string{"", "xchacha"} {
+			mem.Files["a.aes"] = forgeObject(t, crypter, "attacker data")
+			_, err := ts.Get(ctx, "a")
+			assert.ErrorIs(t, err, ErrUnsupportedHeader, "header crypter %q", crypter)
+		}
+		ts.SeekableFrameSize = 1024
+		mem.Files["a.aes"] = forgeObject(t, "", "attacker data")
+		_, err := GetRange(ctx, ts, "a", 0, 4)
+		assert.ErrorIs(t, err, ErrUnsupportedHeader)
+	})
+
+	t.Run("variadic", func(t *testing.T) {
+		mem := NewInMemoryStorage()
+		vs, err := NewVariadicStorage(mem, Algorithms{AES: aes, Crypters: map[string]crypt.Crypter{".xchacha": xchacha}}, ".aes")
+		require.NoError(t, err)
+		for _, crypter := range []string{"", "xchacha"} {
+			mem.Files["a.aes"] = forgeObject(t, crypter, "attacker data")
+			_, err := vs.Get(ctx, "a")
+			assert.ErrorIs(t, err, ErrUnsupportedHeader, "header crypter %q", crypter)
+		}
+	})
+}
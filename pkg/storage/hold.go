@@ -0,0 +1,402 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"sync"
+)
+
+// DefaultHoldManifest is the backend object where HoldStorage persists the
+// holds of backends without native legal hold.
+const DefaultHoldManifest = ".holds/manifest.json"
+
+// ErrHeld is returned when a held object would be deleted or overwritten.
+var ErrHeld = errors.New("storage: object under legal hold")
+
+// Holder is implemented by backends that keep objects under legal hold
+// natively (S3 Object Lock). A held object cannot be deleted until the
+// hold is released.
+type Holder interface {
+	Hold(ctx context.Context, remotePath string) error
+	ReleaseHold(ctx context.Context, remotePath string) error
+	Held(ctx context.Context, remotePath string) (bool, error)
+}
+
+type holdManifest struct {
+	Held []string `json:"held"`
+}
+
+// HoldStorage puts objects under legal hold, e.g. to freeze backups during
+// an incident. Held objects cannot be deleted, renamed or overwritten:
+// Delete, Rename and Put fail with ErrHeld, while DeleteAll, DeleteDir and
+// DeleteAllBulk remove everything else and keep the held objects, which
+// DeleteAllReport lists in DeleteReport.Held.
+//
+// When the backend implements Holder (S3), holds are set on the objects
+// themselves and checked one request per object as DeleteAll walks them.
+// Otherwise (local, SFTP) they are persisted in a manifest object in the
+// same backend, which is hidden from listings and cannot be written or
+// deleted through HoldStorage. The manifest is re-read for every check,
+// so holds set by another HoldStorage on the same backend (a CLI next to
+// a daemon) are honoured at once. Updates are read-modify-write: two
+// holds set at the same instant by different instances may lose one,
+// which native holds avoid.
+type HoldStorage struct {
+	Backend  Storage
+	manifest string
+
+	mu sync.Mutex // serializes manifest updates
+}
+
+var (
+	_ Storage           = (*HoldStorage)(nil)
+	_ Holder            = (*HoldStorage)(nil)
+	_ DeleteAllReporter = (*HoldStorage)(nil)
+)
+
+// NewHoldStorage creates a HoldStorage.
+func NewHoldStorage(backend Storage) *HoldStorage {
+	return &HoldStorage{
+		Backend:  backend,
+		manifest: DefaultHoldManifest,
+	}
+}
+
+func (h *HoldStorage) native() (Holder, bool) {
+	n, ok := h.Backend.(Holder)
+	return n, ok
+}
+
+func (h *HoldStorage) isInternal(p string) bool {
+	return p == h.manifest
+}
+
+// guardManifest fails with ErrHeld if remotePath is the manifest or a
+// directory containing it.
+func (h *HoldStorage) guardManifest(op, remotePath string) error {
+	if hasPathPrefix(h.manifest, remotePath) {
+		return fmt.Errorf("%s %q: contains the hold manifest: %w", op, remotePath, ErrHeld)
+	}
+	return nil
+}
+
+// load reads the held set from the manifest.
+func (h *HoldStorage) load(ctx context.Context) (map[string]bool, error) {
+	held := make(map[string]bool)
+	rc, err := h.Backend.Get(ctx, h.manifest)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return held, nil
+	case err != nil:
+		return nil, err
+	}
+	var m holdManifest
+	err = json.NewDecoder(rc).Decode(&m)
+	_ = rc.Close()
+	if err != nil {
+		return nil, fmt.Errorf("decode hold manifest: %w", err)
+	}
+	for _, p := range m.Held {
+		held[p] = true
+	}
+	return held, nil
+}
+
+// update applies fn to the held set, as currently stored, and persists
+// the manifest.
+func (h *HoldStorage) update(ctx context.Context, fn func(held map[string]bool) bool) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	held, err := h.load(ctx)
+	if err != nil {
+		return err
+	}
+	if !fn(held) {
+		return nil
+	}
+	m := holdManifest{Held: make([]string, 0, len(held))}
+	for p := range held {
+		m.Held = append(m.Held, p)
+	}
+	sort.Strings(m.Held)
+	data, err := json.Marshal(&m)
+	if err != nil {
+		return err
+	}
+	return h.Backend.Put(ctx, h.manifest, bytes.NewReader(data))
+}
+
+// heldUnder reports whether the manifest holds any object below
+// remotePath. With native holds it cannot tell and reports true.
+func (h *HoldStorage) heldUnder(ctx context.Context, remotePath string) (bool, error) {
+	if _, ok := h.native(); ok {
+		return true, nil
+	}
+	held, err := h.load(ctx)
+	if err != nil {
+		return false, err
+	}
+	for p := range held {
+		if hasPathPrefix(p, remotePath) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Hold puts an existing object under legal hold.
+func (h *HoldStorage) Hold(ctx context.Context, remotePath string) error {
+	if n, ok := h.native(); ok {
+		return n.Hold(ctx, remotePath)
+	}
+	exists, err := h.Backend.Exists(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("hold %q: %w", remotePath, fs.ErrNotExist)
+	}
+	return h.update(ctx, func(held map[string]bool) bool {
+		if held[remotePath] {
+			return false
+		}
+		held[remotePath] = true
+		return true
+	})
+}
+
+// ReleaseHold lifts the legal hold of an object; releasing an object that
+// is not held does nothing.
+func (h *HoldStorage) ReleaseHold(ctx context.Context, remotePath string) error {
+	if n, ok := h.native(); ok {
+		return n.ReleaseHold(ctx, remotePath)
+	}
+	return h.update(ctx, func(held map[string]bool) bool {
+		if !held[remotePath] {
+			return false
+		}
+		delete(held, remotePath)
+		return true
+	})
+}
+
+// Held reports whether an object is under legal hold.
+func (h *HoldStorage) Held(ctx context.Context, remotePath string) (bool, error) {
+	if n, ok := h.native(); ok {
+		return n.Held(ctx, remotePath)
+	}
+	held, err := h.load(ctx)
+	if err != nil {
+		return false, err
+	}
+	return held[remotePath], nil
+}
+
+// Holds lists the objects held by the manifest, sorted. With native holds
+// there is no such list and it returns nil.
+func (h *HoldStorage) Holds(ctx context.Context) ([]string, error) {
+	if _, ok := h.native(); ok {
+		return nil, nil
+	}
+	set, err := h.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	held := make([]string, 0, len(set))
+	for p := range set {
+		held = append(held, p)
+	}
+	sort.Strings(held)
+	return held, nil
+}
+
+// checkNotHeld fails with ErrHeld if remotePath is held or is the
+// manifest.
+func (h *HoldStorage) checkNotHeld(ctx context.Context, op, remotePath string) error {
+	if err := h.guardManifest(op, remotePath); err != nil {
+		return err
+	}
+	held, err := h.Held(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+	if held {
+		return fmt.Errorf("%s %q: %w", op, remotePath, ErrHeld)
+	}
+	return nil
+}
+
+// DeleteAllReport removes everything below remotePath except the held
+// objects, which are listed in the report, and the manifest. Each object
+// is checked against the manifest as it stands before its deletion. When
+// nothing below remotePath is held and the manifest is not there, the
+// backend removes it all at once.
+func (h *HoldStorage) DeleteAllReport(ctx context.Context, remotePath string) (DeleteReport, error) {
+	some, err := h.heldUnder(ctx, remotePath)
+	if err != nil {
+		return DeleteReport{}, err
+	}
+	if !some && !hasPathPrefix(h.manifest, remotePath) {
+		return DeleteAllReport(ctx, h.Backend, remotePath)
+	}
+
+	var report DeleteReport
+	var paths []string
+	err = WalkInfo(ctx, h.Backend, remotePath, func(fi FileInfo) error {
+		if !fi.IsDir && !h.isInternal(fi.Path) {
+			paths = append(paths, fi.Path)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	for _, p := range paths {
+		if err := ctx.Err(); err != nil {
+			return report, errors.Join(err, report.Err())
+		}
+		held, err := h.Held(ctx, p)
+		if err != nil {
+			report.fail(p, err)
+			continue
+		}
+		if held {
+			report.Held = append(report.Held, p)
+			continue
+		}
+		err = h.Backend.Delete(ctx, p)
+		switch {
+		case err == nil:
+			report.Deleted++
+		case !errors.Is(err, fs.ErrNotExist):
+			report.fail(p, err)
+		}
+	}
+	return report, report.Err()
+}
+
+// Storage implementation
+
+func (h *HoldStorage) Put(ctx context.Context, remotePath string, r io.Reader) error {
+	if err := h.checkNotHeld(ctx, "put", remotePath); err != nil {
+		return err
+	}
+	return h.Backend.Put(ctx, remotePath, r)
+}
+
+func (h *HoldStorage) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	return h.Backend.Get(ctx, remotePath)
+}
+
+func (h *HoldStorage) List(ctx context.Context, remotePath string) ([]string, error) {
+	files, err := h.Backend.List(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	result := files[:0]
+	for _, f := range files {
+		if !h.isInternal(f) {
+			result = append(result, f)
+		}
+	}
+	return result, nil
+}
+
+func (h *HoldStorage) ListInfo(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	files, err := h.Backend.ListInfo(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	result := files[:0]
+	for _, f := range files {
+		if !h.isInternal(f.Path) {
+			result = append(result, f)
+		}
+	}
+	return result, nil
+}
+
+func (h *HoldStorage) Delete(ctx context.Context, remotePath string) error {
+	if err := h.checkNotHeld(ctx, "delete", remotePath); err != nil {
+		return err
+	}
+	return h.Backend.Delete(ctx, remotePath)
+}
+
+// DeleteAll removes everything below remotePath but the held objects.
+func (h *HoldStorage) DeleteAll(ctx context.Context, remotePath string) error {
+	_, err := h.DeleteAllReport(ctx, remotePath)
+	return err
+}
+
+// DeleteDir removes remotePath and everything below it. If held objects
+// are kept, the directory is too, and DeleteDir fails with ErrHeld. The
+// directory of the manifest is never removed.
+func (h *HoldStorage) DeleteDir(ctx context.Context, remotePath string) error {
+	if err := h.guardManifest("delete dir", remotePath); err != nil {
+		return err
+	}
+	report, err := h.DeleteAllReport(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+	if len(report.Held) > 0 {
+		return fmt.Errorf("delete dir %q: %d objects kept: %w", remotePath, len(report.Held), ErrHeld)
+	}
+	return h.Backend.DeleteDir(ctx, remotePath)
+}
+
+// DeleteAllBulk removes each object, or everything below each path, but
+// the held objects.
+func (h *HoldStorage) DeleteAllBulk(ctx context.Context, paths []string) error {
+	var errs []error
+	for _, p := range paths {
+		if h.isInternal(p) {
+			continue
+		}
+		held, err := h.Held(ctx, p)
+		if err != nil {
+			return err
+		}
+		if held {
+			continue
+		}
+		exists, err := h.Backend.Exists(ctx, p)
+		if err != nil {
+			return err
+		}
+		if exists {
+			errs = append(errs, h.Backend.Delete(ctx, p))
+			continue
+		}
+		_, err = h.DeleteAllReport(ctx, p)
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (h *HoldStorage) Exists(ctx context.Context, remotePath string) (bool, error) {
+	return h.Backend.Exists(ctx, remotePath)
+}
+
+func (h *HoldStorage) ListTopLevelDirs(ctx context.Context, prefix string) (map[string]bool, error) {
+	return h.Backend.ListTopLevelDirs(ctx, prefix)
+}
+
+// Rename fails with ErrHeld if either path is held; a manifest hold does
+// not follow the object.
+func (h *HoldStorage) Rename(ctx context.Context, oldRemotePath, newRemotePath string) error {
+	if err := h.checkNotHeld(ctx, "rename", oldRemotePath); err != nil {
+		return err
+	}
+	if err := h.checkNotHeld(ctx, "rename", newRemotePath); err != nil {
+		return err
+	}
+	return h.Backend.Rename(ctx, oldRemotePath, newRemotePath)
+}
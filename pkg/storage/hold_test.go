@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHoldStorage_HoldAndRelease(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	hs := NewHoldStorage(mem)
+	require.NoError(t, hs.Put(ctx, "base/0001", strings.NewReader("one")))
+	require.NoError(t, hs.Put(ctx, "base/0002", strings.NewReader("two")))
+
+	assert.ErrorIs(t, hs.Hold(ctx, "base/missing"), fs.ErrNotExist)
+	require.NoError(t, hs.Hold(ctx, "base/0001"))
+	held, err := hs.Held(ctx, "base/0001")
+	require.NoError(t, err)
+	assert.True(t, held)
+
+	assert.ErrorIs(t, hs.Delete(ctx, "base/0001"), ErrHeld)
+	assert.ErrorIs(t, hs.Put(ctx, "base/0001", strings.NewReader("new")), ErrHeld)
+	assert.ErrorIs(t, hs.Rename(ctx, "base/0001", "base/0003"), ErrHeld)
+	assert.ErrorIs(t, hs.Rename(ctx, "base/0002", "base/0001"), ErrHeld)
+
+	// The manifest is hidden and survives a new HoldStorage.
+	files, err := hs.List(ctx, "")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"base/0001", "base/0002"}, files)
+	holds, err := NewHoldStorage(mem).Holds(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"base/0001"}, holds)
+
+	require.NoError(t, hs.ReleaseHold(ctx, "base/0001"))
+	require.NoError(t, hs.ReleaseHold(ctx, "base/0001"))
+	require.NoError(t, hs.Delete(ctx, "base/0001"))
+}
+
+func TestHoldStorage_DeleteAllKeepsHeld(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	hs := NewHoldStorage(mem)
+	for _, p := range []string{"base/0001", "base/0002", "base/0003", "wal/0001"} {
+		require.NoError(t, hs.Put(ctx, p, strings.NewReader(p)))
+	}
+	require.NoError(t, hs.Hold(ctx, "base/0002"))
+
+	report, err := DeleteAllReport(ctx, hs, "base")
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Deleted)
+	assert.Equal(t, []string{"base/0002"}, report.Held)
+	assert.Empty(t, report.Failed)
+
+	assert.ErrorIs(t, hs.DeleteDir(ctx, "base"), ErrHeld)
+	require.NoError(t, hs.DeleteAllBulk(ctx, []string{"base/0002", "wal"}))
+	files, err := hs.List(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"base/0002"}, files)
+
+	// Nothing held below the root: everything but the manifest goes.
+	require.NoError(t, hs.ReleaseHold(ctx, "base/0002"))
+	require.NoError(t, hs.DeleteAll(ctx, ""))
+	assert.Len(t, mem.Files, 1)
+	assert.Contains(t, mem.Files, DefaultHoldManifest)
+}
+
+func TestHoldStorage_SharedManifest(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	cli, daemon := NewHoldStorage(mem), NewHoldStorage(mem)
+	for _, p := range []string{"base/0001", "base/0002"} {
+		require.NoError(t, cli.Put(ctx, p, strings.NewReader(p)))
+	}
+	// The daemon has read the (empty) manifest before the hold is set.
+	_, err := daemon.Held(ctx, "base/0001")
+	require.NoError(t, err)
+
+	require.NoError(t, cli.Hold(ctx, "base/0001"))
+	assert.ErrorIs(t, daemon.Delete(ctx, "base/0001"), ErrHeld)
+	require.NoError(t, daemon.Hold(ctx, "base/0002"))
+
+	holds, err := cli.Holds(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"base/0001", "base/0002"}, holds, "updates keep the holds of the other instance")
+}
+
+func TestHoldStorage_ManifestProtected(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	hs := NewHoldStorage(mem)
+	require.NoError(t, hs.Put(ctx, "base/0001", strings.NewReader("one")))
+	require.NoError(t, hs.Hold(ctx, "base/0001"))
+
+	assert.ErrorIs(t, hs.Put(ctx, DefaultHoldManifest, strings.NewReader("{}")), ErrHeld)
+	assert.ErrorIs(t, hs.Delete(ctx, DefaultHoldManifest), ErrHeld)
+	assert.ErrorIs(t, hs.Rename(ctx, DefaultHoldManifest, "x"), ErrHeld)
+	assert.ErrorIs(t, hs.Rename(ctx, "base/0001", DefaultHoldManifest), ErrHeld)
+	assert.ErrorIs(t, hs.DeleteDir(ctx, ".holds"), ErrHeld)
+	require.NoError(t, hs.DeleteAll(ctx, ".holds"))
+	require.NoError(t, hs.DeleteAllBulk(ctx, []string{DefaultHoldManifest, ".holds"}))
+
+	holds, err := NewHoldStorage(mem).Holds(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"base/0001"}, holds)
+}
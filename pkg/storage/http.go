@@ -0,0 +1,561 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHTTPHeadConcurrency bounds the HEAD requests in flight that read
+// the sizes and times of the objects in a directory index page.
+const DefaultHTTPHeadConcurrency = 8
+
+// HTTPOptions configures NewHTTPStorage.
+type HTTPOptions struct {
+	// Client sends the requests, http.DefaultClient if nil.
+	Client *http.Client
+
+	// Header is added to every request, e.g. Authorization.
+	Header http.Header
+
+	// Manifest, if set, is the path below the base URL of a JSON listing
+	// of the objects (see WriteHTTPManifest), which List, Stat and Exists
+	// then answer from. Otherwise listings follow the links of directory
+	// index pages, as generated by nginx, Apache or "python -m
+	// http.server".
+	Manifest string
+
+	// HeadConcurrency bounds the HEAD requests of directory listings,
+	// DefaultHTTPHeadConcurrency if zero.
+	HeadConcurrency int
+}
+
+// HTTPManifest lists the objects published under a base URL. A
+// SnapshotManifest has the same entries and can be published as is.
+type HTTPManifest struct {
+	Entries []HTTPManifestEntry `json:"entries"`
+}
+
+// HTTPManifestEntry describes an object of an HTTPManifest.
+type HTTPManifestEntry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+type httpStorage struct {
+	base            *url.URL
+	client          *http.Client
+	header          http.Header
+	manifest        string
+	headConcurrency int
+
+	mu      sync.Mutex
+	entries map[string]FileInfo // the loaded manifest
+}
+
+var (
+	_ Storage     = &httpStorage{}
+	_ Stater      = &httpStorage{}
+	_ RangeReader = &httpStorage{}
+	_ Pinger      = &httpStorage{}
+)
+
+// NewHTTPStorage reads objects from a web server or CDN below baseURL,
+// e.g. to restore an encrypted archive published as static files. Objects
+// are fetched with GET and ranged GET. It is read-only: writes fail with
+// ErrReadOnly. The manifest, if any, is loaded once.
+func NewHTTPStorage(baseURL string, opts HTTPOptions) (Storage, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("http: parse base url: %w", err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("http: unsupported base url scheme %q", base.Scheme)
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+		base.RawPath = ""
+	}
+	base.RawQuery, base.Fragment = "", ""
+
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	headConcurrency := opts.HeadConcurrency
+	if headConcurrency <= 0 {
+		headConcurrency = DefaultHTTPHeadConcurrency
+	}
+	return &httpStorage{
+		base:            base,
+		client:          client,
+		header:          opts.Header,
+		manifest:        strings.TrimPrefix(opts.Manifest, "/"),
+		headConcurrency: headConcurrency,
+	}, nil
+}
+
+// WriteHTTPManifest writes the manifest of the objects below remotePath
+// of st, with paths relative to it, for an HTTP storage to read.
+func WriteHTTPManifest(ctx context.Context, st Storage, remotePath string, w io.Writer) error {
+	root, err := CleanPath(remotePath)
+	if err != nil {
+		return err
+	}
+	m := HTTPManifest{Entries: make([]HTTPManifestEntry, 0)}
+	err = WalkInfo(ctx, st, root, func(fi FileInfo) error {
+		rel, _ := relKey(root, fi.Path)
+		m.Entries = append(m.Entries, HTTPManifestEntry{Path: rel, Size: fi.Size, ModTime: fi.ModTime})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(m.Entries, func(i, j int) bool { return m.Entries[i].Path < m.Entries[j].Path })
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+// url returns the URL of p below the base URL; each segment is escaped.
+func (s *httpStorage) url(p string) *url.URL {
+	u := *s.base
+	if p != "" {
+		u.Path += p
+		u.RawPath = s.base.EscapedPath() + escapeSegments(p)
+	}
+	return &u
+}
+
+func escapeSegments(p string) string {
+	segs := strings.Split(p, "/")
+	for i, seg := range segs {
+		segs[i] = url.PathEscape(seg)
+	}
+	return strings.Join(segs, "/")
+}
+
+// do sends a request and fails on any status but 2xx, matching it to a
+// storage error.
+func (s *httpStorage) do(ctx context.Context, method string, u *url.URL, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range s.header {
+		req.Header[k] = vs
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, classify(err, ErrUnavailable)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		_ = resp.Body.Close()
+		err := &httpStatusError{method: method, url: u.Redacted(), status: resp.Status, code: resp.StatusCode}
+		return nil, classify(err, httpStatusKind(resp.StatusCode))
+	}
+	return resp, nil
+}
+
+// httpStatusError is a response with a status other than 2xx.
+type httpStatusError struct {
+	method, url, status string
+	code                int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.method, e.url, e.status)
+}
+
+func (s *httpStorage) Put(_ context.Context, _ string, _ io.Reader) error {
+	return ErrReadOnly
+}
+
+func (s *httpStorage) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	name, err := objectKey(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.url(name), nil)
+	if err != nil {
+		return nil, fmt.Errorf("get %q: %w", name, err)
+	}
+	return trackGetProgress(ctx, name, resp.Body, resp.ContentLength), nil
+}
+
+// GetRange sends a ranged GET; a server that ignores the range is read
+// from the start and the leading bytes are skipped.
+func (s *httpStorage) GetRange(ctx context.Context, remotePath string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	name, err := objectKey(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length > 0 {
+		byteRange = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.url(name), http.Header{"Range": {byteRange}})
+	if err != nil {
+		var se *httpStatusError
+		if errors.As(err, &se) && se.code == http.StatusRequestedRangeNotSatisfiable {
+			// The range starts at or past the end of the object.
+			return io.NopCloser(bytes.NewReader(nil)), nil
+		}
+		return nil, fmt.Errorf("get %q: %w", name, err)
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		return limitRange(resp.Body, length), nil
+	}
+	if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil && !errors.Is(err, io.EOF) {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("get %q: %w", name, err)
+	}
+	return limitRange(resp.Body, length), nil
+}
+
+func (s *httpStorage) List(ctx context.Context, remotePath string) ([]string, error) {
+	infos, err := s.ListInfo(context.WithValue(ctx, listOptionsKey{}, ListOptions{}), remotePath)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(infos))
+	for _, fi := range infos {
+		names = append(names, fi.Path)
+	}
+	return names, nil
+}
+
+// ListInfo reads the manifest, or crawls the directory index pages below
+// remotePath. A missing directory lists nothing.
+func (s *httpStorage) ListInfo(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	root, err := CleanPath(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	var files []FileInfo
+	if s.manifest != "" {
+		entries, err := s.loadManifest(ctx)
+		if err != nil {
+			return nil, err
+		}
+		prefix := dirPrefix(root)
+		for name, fi := range entries {
+			if strings.HasPrefix(name, prefix) {
+				files = append(files, fi)
+			}
+		}
+	} else if files, err = s.crawl(ctx, root); err != nil {
+		return nil, fmt.Errorf("list %q: %w", root, err)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	opts := ListOptionsFromContext(ctx)
+	dirs := newImpliedDirs(root)
+	var infos []FileInfo
+	for _, fi := range files {
+		if opts.IncludeDirs {
+			// Directories are implied by every object, filtered or not.
+			_ = dirs.add(fi.Path, func(dir FileInfo) error {
+				infos = append(infos, dir)
+				return nil
+			})
+		}
+		if opts.Match(fi) {
+			infos = append(infos, fi)
+		}
+	}
+	return infos, nil
+}
+
+// loadManifest fetches the manifest the first time it is needed.
+func (s *httpStorage) loadManifest(ctx context.Context) (map[string]FileInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entries != nil {
+		return s.entries, nil
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.url(s.manifest), nil)
+	if err != nil {
+		return nil, fmt.Errorf("load manifest: %w", err)
+	}
+	defer resp.Body.Close()
+	var m HTTPManifest
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("load manifest: %w", err)
+	}
+	entries := make(map[string]FileInfo, len(m.Entries))
+	for _, e := range m.Entries {
+		name, err := CleanPath(e.Path)
+		if err != nil || name == "" {
+			return nil, fmt.Errorf("load manifest: invalid path %q", e.Path)
+		}
+		entries[name] = FileInfo{Path: name, Size: e.Size, ModTime: e.ModTime}
+	}
+	s.entries = entries
+	return entries, nil
+}
+
+// indexLink matches the links of a directory index page.
+var indexLink = regexp.MustCompile(`(?i)<a\s[^>]*?href\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+
+// nginxIndexEntry is an item of an nginx index in JSON (autoindex_format
+// json), which gives the sizes and times of the files.
+type nginxIndexEntry struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	MTime string `json:"mtime"`
+	Size  int64  `json:"size"`
+}
+
+// crawl lists the files below the directory root by its index pages and
+// those of its subdirectories. Files of an HTML index are described by
+// HEAD requests.
+func (s *httpStorage) crawl(ctx context.Context, root string) ([]FileInfo, error) {
+	var (
+		files   []FileInfo
+		unsized []string
+	)
+	pending := []string{root}
+	seen := map[string]bool{root: true}
+	for len(pending) > 0 {
+		dir := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		described, names, subdirs, err := s.readIndex(ctx, dir)
+		if err != nil {
+			if dir == root && errors.Is(err, ErrNotExist) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		files = append(files, described...)
+		unsized = append(unsized, names...)
+		for _, sub := range subdirs {
+			if !seen[sub] {
+				seen[sub] = true
+				pending = append(pending, sub)
+			}
+		}
+	}
+
+	var (
+		mu   sync.Mutex
+		errs []error
+	)
+	runConcurrently(ctx, len(unsized), s.headConcurrency, func(i int) {
+		fi, err := s.head(ctx, unsized[i])
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case err == nil:
+			files = append(files, fi)
+		case !errors.Is(err, ErrNotExist): // gone since the index was read
+			errs = append(errs, err)
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return files, errors.Join(errs...)
+}
+
+// readIndex reads the index page of dir and returns the files it lists,
+// described if the page gives their sizes and times and named otherwise,
+// and the subdirectories. Links out of dir, such as the parent or sort
+// links, are ignored.
+func (s *httpStorage) readIndex(ctx context.Context, dir string) (described []FileInfo, names, subdirs []string, err error) {
+	u := s.url(dirPrefix(dir))
+	resp, err := s.do(ctx, http.MethodGet, u, http.Header{"Accept": {"application/json, text/html;q=0.9"}})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		var items []nginxIndexEntry
+		if err := json.Unmarshal(body, &items); err != nil {
+			return nil, nil, nil, fmt.Errorf("read index %s: %w", u.Redacted(), err)
+		}
+		for _, item := range items {
+			name := joinKey(dir, item.Name)
+			if item.Type == "directory" {
+				subdirs = append(subdirs, name)
+				continue
+			}
+			mtime, err := http.ParseTime(item.MTime)
+			if err != nil {
+				names = append(names, name)
+				continue
+			}
+			described = append(described, FileInfo{Path: name, Size: item.Size, ModTime: mtime})
+		}
+		return described, names, subdirs, nil
+	}
+
+	for _, m := range indexLink.FindAllSubmatch(body, -1) {
+		href := html.UnescapeString(string(bytes.Join(m[1:], nil)))
+		ref, err := u.Parse(href)
+		if err != nil || ref.Host != u.Host || ref.RawQuery != "" {
+			continue
+		}
+		rel, ok := strings.CutPrefix(ref.Path, u.Path)
+		if !ok || rel == "" || strings.HasPrefix(rel, "/") {
+			continue
+		}
+		if sub, isDir := strings.CutSuffix(rel, "/"); isDir {
+			if !strings.Contains(sub, "/") {
+				subdirs = append(subdirs, joinKey(dir, sub))
+			}
+			continue
+		}
+		if !strings.Contains(rel, "/") {
+			names = append(names, joinKey(dir, rel))
+		}
+	}
+	return nil, names, subdirs, nil
+}
+
+func joinKey(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
+
+// head describes the object name by a HEAD request.
+func (s *httpStorage) head(ctx context.Context, name string) (FileInfo, error) {
+	resp, err := s.do(ctx, http.MethodHead, s.url(name), nil)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	_ = resp.Body.Close()
+	fi := FileInfo{Path: name, Size: resp.ContentLength}
+	fi.ModTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return fi, nil
+}
+
+func (s *httpStorage) Delete(_ context.Context, _ string) error {
+	return ErrReadOnly
+}
+
+func (s *httpStorage) DeleteAll(_ context.Context, _ string) error {
+	return ErrReadOnly
+}
+
+func (s *httpStorage) DeleteDir(_ context.Context, _ string) error {
+	return ErrReadOnly
+}
+
+func (s *httpStorage) DeleteAllBulk(_ context.Context, _ []string) error {
+	return ErrReadOnly
+}
+
+func (s *httpStorage) Exists(ctx context.Context, remotePath string) (bool, error) {
+	_, err := s.Stat(ctx, remotePath)
+	if errors.Is(err, ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Stat answers from the manifest if there is one, and sends HEAD
+// otherwise.
+func (s *httpStorage) Stat(ctx context.Context, remotePath string) (FileInfo, error) {
+	name, err := objectKey(remotePath)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if s.manifest != "" {
+		entries, err := s.loadManifest(ctx)
+		if err != nil {
+			return FileInfo{}, err
+		}
+		fi, ok := entries[name]
+		if !ok {
+			return FileInfo{}, fs.ErrNotExist
+		}
+		return fi, nil
+	}
+	fi, err := s.head(ctx, name)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("stat %q: %w", name, err)
+	}
+	return fi, nil
+}
+
+func (s *httpStorage) ListTopLevelDirs(ctx context.Context, prefix string) (map[string]bool, error) {
+	names, err := s.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	root, _ := CleanPath(prefix) // checked by List
+	normalizedPrefix := dirPrefix(root)
+
+	result := make(map[string]bool)
+	for _, name := range names {
+		rel := strings.TrimPrefix(name, normalizedPrefix)
+		if dir, _, ok := strings.Cut(rel, "/"); ok && dir != "" {
+			result[normalizedPrefix+dir] = true
+		}
+	}
+	return result, nil
+}
+
+func (s *httpStorage) Rename(_ context.Context, _, _ string) error {
+	return ErrReadOnly
+}
+
+// Ping fetches the manifest, or the index page of the base URL.
+func (s *httpStorage) Ping(ctx context.Context) error {
+	target := s.url("")
+	if s.manifest != "" {
+		target = s.url(s.manifest)
+	}
+	resp, err := s.do(ctx, http.MethodHead, target, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// httpStatusKind maps an HTTP status to the storage error it matches, or
+// nil.
+func httpStatusKind(status int) error {
+	switch {
+	case status == http.StatusNotFound || status == http.StatusGone:
+		return ErrNotExist
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrPermission
+	case status == http.StatusTooManyRequests:
+		return ErrThrottled
+	case status == http.StatusRequestTimeout || status >= 500:
+		return ErrUnavailable
+	}
+	return nil
+}
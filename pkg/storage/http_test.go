@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newHTTPTestServer(t *testing.T, files map[string]string) *httptest.Server {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte(data), 0o600))
+	}
+	srv := httptest.NewServer(http.StripPrefix("/pub", http.FileServer(http.Dir(dir))))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHTTPStorage_DirectoryIndex(t *testing.T) {
+	ctx := context.Background()
+	srv := newHTTPTestServer(t, map[string]string{
+		"base/0001.gz":      "base",
+		"wal/0001.gz":       "one",
+		"wal/0002 copy.gz":  "two",
+		"wal/sub/0003#1.gz": "three",
+	})
+	st, err := NewHTTPStorage(srv.URL+"/pub", HTTPOptions{})
+	require.NoError(t, err)
+
+	files, err := st.List(ctx, "wal")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"wal/0001.gz", "wal/0002 copy.gz", "wal/sub/0003#1.gz"}, files)
+
+	infos, err := st.ListInfo(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, infos, 4)
+	assert.Equal(t, "base/0001.gz", infos[0].Path)
+	assert.Equal(t, int64(4), infos[0].Size)
+	assert.False(t, infos[0].ModTime.IsZero())
+
+	rc, err := st.Get(ctx, "wal/sub/0003#1.gz")
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, "three", string(data))
+
+	rc, err = GetRange(ctx, st, "wal/sub/0003#1.gz", 1, 3)
+	require.NoError(t, err)
+	data, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, "hre", string(data))
+
+	_, err = st.Get(ctx, "wal/missing")
+	assert.ErrorIs(t, err, ErrNotExist)
+	ok, err := st.Exists(ctx, "wal/0002 copy.gz")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = st.Exists(ctx, "wal/missing")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	files, err = st.List(ctx, "missing")
+	require.NoError(t, err)
+	assert.Empty(t, files)
+	assert.ErrorIs(t, st.Put(ctx, "wal/0004.gz", bytes.NewReader(nil)), ErrReadOnly)
+	assert.ErrorIs(t, st.Delete(ctx, "wal/0001.gz"), ErrReadOnly)
+}
+
+func TestHTTPStorage_Manifest(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	require.NoError(t, mem.Put(ctx, "archive/wal/0001.gz", bytes.NewReader([]byte("one"))))
+	require.NoError(t, mem.Put(ctx, "archive/wal/0002.gz", bytes.NewReader([]byte("two!"))))
+	var manifest bytes.Buffer
+	require.NoError(t, WriteHTTPManifest(ctx, mem, "archive", &manifest))
+
+	// The server has no directory index: the manifest is the listing.
+	srv := newHTTPTestServer(t, map[string]string{
+		"index.json":  manifest.String(),
+		"wal/0001.gz": "one",
+		"wal/0002.gz": "two!",
+	})
+	st, err := NewHTTPStorage(srv.URL+"/pub/", HTTPOptions{Manifest: "index.json"})
+	require.NoError(t, err)
+	require.NoError(t, Ping(ctx, st))
+
+	infos, err := st.ListInfo(ctx, "wal")
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	assert.Equal(t, "wal/0002.gz", infos[1].Path)
+	assert.Equal(t, int64(4), infos[1].Size)
+
+	fi, err := st.(Stater).Stat(ctx, "wal/0001.gz")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), fi.Size)
+	_, err = st.(Stater).Stat(ctx, "wal/0003.gz")
+	assert.ErrorIs(t, err, ErrNotExist)
+}
+
+func TestHTTPStatusKind(t *testing.T) {
+	assert.ErrorIs(t, httpStatusKind(http.StatusNotFound), ErrNotExist)
+	assert.ErrorIs(t, httpStatusKind(http.StatusForbidden), ErrPermission)
+	assert.ErrorIs(t, httpStatusKind(http.StatusTooManyRequests), ErrThrottled)
+	assert.ErrorIs(t, httpStatusKind(http.StatusBadGateway), ErrUnavailable)
+	assert.NoError(t, httpStatusKind(http.StatusBadRequest))
+}
@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// ErrIntegrity is matched (via errors.Is) by *IntegrityError.
+var ErrIntegrity = errors.New("integrity check failed")
+
+// IntegrityError is returned when an object's integrity trailer is missing
+// or does not match the plaintext read, e.g. because the stored stream was
+// truncated at a chunk boundary.
+type IntegrityError struct {
+	Path   string
+	Reason string
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("integrity check failed for %q: %s", e.Path, e.Reason)
+}
+
+func (e *IntegrityError) Unwrap() error {
+	return ErrIntegrity
+}
+
+var integrityTrailerMagic = []byte("SCIT")
+
+// integrityTrailerLen is the size of the trailer appended to the
+// plaintext: magic followed by the SHA-256 of everything before it.
+const integrityTrailerLen = 4 + sha256.Size
+
+// trailerReader passes r through and appends the integrity trailer at EOF.
+// It sits in front of compression and encryption, so the trailer is
+// authenticated together with the data.
+type trailerReader struct {
+	r       io.Reader
+	h       hash.Hash
+	trailer *bytes.Reader // set once r is exhausted
+}
+
+func newTrailerReader(r io.Reader) *trailerReader {
+	return &trailerReader{r: r, h: sha256.New()}
+}
+
+func (t *trailerReader) Read(p []byte) (int, error) {
+	if t.trailer != nil {
+		return t.trailer.Read(p)
+	}
+	n, err := t.r.Read(p)
+	t.h.Write(p[:n])
+	if errors.Is(err, io.EOF) {
+		trailer := append(append([]byte(nil), integrityTrailerMagic...), t.h.Sum(nil)...)
+		t.trailer = bytes.NewReader(trailer)
+		if n > 0 {
+			return n, nil
+		}
+		return t.trailer.Read(p)
+	}
+	return n, err
+}
+
+// integrityReader strips and verifies the trailer written by
+// trailerReader. It withholds the last integrityTrailerLen bytes of the
+// stream; at EOF it returns *IntegrityError instead of io.EOF if the
+// trailer is missing or wrong, and Close reports the same error. If
+// optional is set, a missing or wrong trailer is returned as data
+// instead.
+type integrityReader struct {
+	r        io.Reader
+	c        io.Closer
+	path     string
+	optional bool
+	h        hash.Hash
+	buf      []byte
+	tail     []byte // withheld bytes, possibly the trailer
+	out      []byte // verified-to-be-data bytes not yet returned
+	err      error  // sticky: io.EOF, *IntegrityError or a read error
+}
+
+func newIntegrityReader(rc io.ReadCloser, p string) *integrityReader {
+	return &integrityReader{r: rc, c: rc, path: p, h: sha256.New(), buf: make([]byte, 32*1024)}
+}
+
+func (ir *integrityReader) Read(p []byte) (int, error) {
+	for len(ir.out) == 0 {
+		if ir.err != nil {
+			return 0, ir.err
+		}
+		n, err := ir.r.Read(ir.buf)
+		ir.tail = append(ir.tail, ir.buf[:n]...)
+		if k := len(ir.tail) - integrityTrailerLen; k > 0 {
+			ir.out = append(ir.out[:0], ir.tail[:k]...)
+			ir.h.Write(ir.out)
+			ir.tail = append(ir.tail[:0], ir.tail[k:]...)
+		}
+		switch {
+		case errors.Is(err, io.EOF):
+			ir.err = ir.verify()
+		case err != nil:
+			ir.err = err
+		}
+	}
+	n := copy(p, ir.out)
+	ir.out = ir.out[n:]
+	return n, nil
+}
+
+func (ir *integrityReader) verify() error {
+	err := ir.checkTrailer()
+	if !errors.Is(err, io.EOF) && ir.optional {
+		ir.out = append(ir.out, ir.tail...)
+		return io.EOF
+	}
+	return err
+}
+
+func (ir *integrityReader) checkTrailer() error {
+	if len(ir.tail) != integrityTrailerLen || !bytes.HasPrefix(ir.tail, integrityTrailerMagic) {
+		return &IntegrityError{Path: ir.path, Reason: "missing trailer (truncated stream?)"}
+	}
+	if !bytes.Equal(ir.tail[len(integrityTrailerMagic):], ir.h.Sum(nil)) {
+		return &IntegrityError{Path: ir.path, Reason: "digest mismatch"}
+	}
+	return io.EOF
+}
+
+// Close closes the underlying stream and reports a failed verification.
+// Closing before EOF skips verification.
+func (ir *integrityReader) Close() error {
+	err := ir.c.Close()
+	var ie *IntegrityError
+	if errors.As(ir.err, &ie) {
+		return errors.Join(ie, err)
+	}
+	return err
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformingStorage_IntegrityTrailer_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	ts := &TransformingStorage{
+		Backend:          NewInMemoryStorage(),
+		Compressor:       codec.GzipCompressor{},
+		Decompressor:     codec.GzipDecompressor{},
+		IntegrityTrailer: true,
+	}
+	for _, data := range []string{"", "x", strings.Repeat("0123456789", 10_000)} {
+		require.NoError(t, ts.Put(ctx, "obj", strings.NewReader(data)))
+		rc, err := ts.Get(ctx, "obj")
+		require.NoError(t, err)
+		got, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		assert.Equal(t, data, string(got))
+	}
+}
+
+func TestTransformingStorage_IntegrityTrailer_Truncated(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	ts := &TransformingStorage{Backend: mem, IntegrityTrailer: true}
+	data := strings.Repeat("a", 100)
+	require.NoError(t, ts.Put(ctx, "obj", strings.NewReader(data)))
+	stored := mem.Files["obj"]
+	hdr, n, ok := parseObjectHeader(stored)
+	require.True(t, ok, "a trailer is announced in the header")
+	require.Equal(t, HeaderFlagTrailer, hdr.Flags&HeaderFlagTrailer)
+	require.Len(t, stored, n+len(data)+integrityTrailerLen)
+
+	// Cut the stream short: the trailer is gone.
+	mem.Files["obj"] = stored[:n+len(data)]
+	rc, err := ts.Get(ctx, "obj")
+	require.NoError(t, err)
+	_, err = io.ReadAll(rc)
+	assert.ErrorIs(t, err, ErrIntegrity)
+	assert.ErrorIs(t, rc.Close(), ErrIntegrity)
+
+	// Alter the payload: the digest no longer matches.
+	require.NoError(t, ts.Put(ctx, "obj", strings.NewReader(data)))
+	mem.Files["obj"][n] = 'b'
+	rc, err = ts.Get(ctx, "obj")
+	require.NoError(t, err)
+	_, err = io.ReadAll(rc)
+	var ie *IntegrityError
+	require.ErrorAs(t, err, &ie)
+	assert.Equal(t, "obj", ie.Path)
+	assert.Equal(t, "digest mismatch", ie.Reason)
+	assert.ErrorIs(t, rc.Close(), ErrIntegrity)
+}
+
+func TestTransformingStorage_IntegrityTrailer_HeaderFlag(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	writer := &TransformingStorage{Backend: mem, WriteHeader: true, IntegrityTrailer: true}
+	require.NoError(t, writer.Put(ctx, "with", bytes.NewReader([]byte("data"))))
+
+	hdr, _, ok := parseObjectHeader(mem.Files["with"])
+	require.True(t, ok)
+	assert.Equal(t, HeaderFlagTrailer, hdr.Flags&HeaderFlagTrailer)
+
+	// The flag, not the reader's setting, decides whether to verify.
+	reader := &TransformingStorage{Backend: mem}
+	rc, err := reader.Get(ctx, "with")
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, "data", string(got))
+
+	// Headered objects written without a trailer stay readable.
+	writer.IntegrityTrailer = false
+	require.NoError(t, writer.Put(ctx, "without", bytes.NewReader([]byte("legacy"))))
+	writer.IntegrityTrailer = true
+	rc, err = writer.Get(ctx, "without")
+	require.NoError(t, err)
+	got, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, "legacy", string(got))
+}
+
+func TestTransformingStorage_IntegrityTrailer_NoHeader(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	ts := &TransformingStorage{Backend: mem, IntegrityTrailer: true}
+
+	// Objects written before the trailer was enabled are not failed.
+	plain := &TransformingStorage{Backend: mem}
+	require.NoError(t, plain.Put(ctx, "old", strings.NewReader("written without a trailer")))
+	rc, err := ts.Get(ctx, "old")
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, "written without a trailer", string(got))
+
+	// The trailer of a header-less object, as written by earlier
+	// releases, is stripped.
+	trailed, err := io.ReadAll(newTrailerReader(strings.NewReader("data")))
+	require.NoError(t, err)
+	mem.Files["legacy"] = trailed
+	rc, err = ts.Get(ctx, "legacy")
+	require.NoError(t, err)
+	got, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, "data", string(got))
+}
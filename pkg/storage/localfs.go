@@ -2,12 +2,12 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/hashmap-kz/storecrypt/pkg/fsync"
 )
@@ -15,29 +15,54 @@ import (
 type LocalStorageOpts struct {
 	BaseDir      string
 	FsyncOnWrite bool
+
+	// DeleteConcurrency bounds the paths DeleteAllBulk removes at once;
+	// zero means DefaultDeleteConcurrency.
+	DeleteConcurrency int
 }
 
 type localStorage struct {
-	baseDir      string
-	fsyncOnWrite bool
+	baseDir           string
+	fsyncOnWrite      bool
+	deleteConcurrency int
 }
 
-var _ Storage = &localStorage{}
+var (
+	_ Storage           = &localStorage{}
+	_ Stater            = &localStorage{}
+	_ Walker            = &localStorage{}
+	_ RangeReader       = &localStorage{}
+	_ Pinger            = &localStorage{}
+	_ DeleteAllReporter = &localStorage{}
+	_ ExclusivePutter   = &localStorage{}
+)
 
 func NewLocal(o *LocalStorageOpts) (Storage, error) {
-	bd := strings.TrimSuffix(o.BaseDir, "/")
+	bd := filepath.Clean(o.BaseDir)
 	if err := os.MkdirAll(bd, 0o750); err != nil {
 		return nil, err
 	}
-	return &localStorage{baseDir: bd, fsyncOnWrite: o.FsyncOnWrite}, nil
+	return &localStorage{baseDir: bd, fsyncOnWrite: o.FsyncOnWrite, deleteConcurrency: o.DeleteConcurrency}, nil
 }
 
-func (l *localStorage) fullPath(path string) string {
-	return filepath.ToSlash(filepath.Join(l.baseDir, filepath.Clean(path)))
+// fullPath checks a remote path and maps it into the base directory.
+func (l *localStorage) fullPath(path string) (string, error) {
+	clean, err := CleanPath(path)
+	if err != nil {
+		return "", err
+	}
+	if err := checkNameLength(clean); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.baseDir, filepath.FromSlash(clean)), nil
 }
 
-func (l *localStorage) Put(_ context.Context, remotePath string, r io.Reader) error {
-	fullPath := l.fullPath(remotePath)
+func (l *localStorage) Put(ctx context.Context, remotePath string, r io.Reader) error {
+	fullPath, err := l.fullPath(remotePath)
+	if err != nil {
+		return err
+	}
+	_, r = trackPutProgress(ctx, remotePath, r)
 	if err := os.MkdirAll(filepath.Dir(fullPath), 0o750); err != nil {
 		return err
 	}
@@ -46,12 +71,32 @@ func (l *localStorage) Put(_ context.Context, remotePath string, r io.Reader) er
 		return err
 	}
 
+	// With a size hint, reserve the space up front: it avoids fragmentation
+	// and fails on a full disk before anything was streamed.
+	hint := PutOptionsFromContext(ctx).Size
+	if hint > 0 {
+		if err := preallocate(f, hint); err != nil {
+			_ = f.Close()
+			_ = os.Remove(fullPath)
+			return err
+		}
+	}
+
 	// Copy contents
-	if _, err := io.Copy(f, r); err != nil {
+	n, err := io.Copy(f, r)
+	if err != nil {
 		_ = f.Close() // ignore close error if we already have a copy error
 		return err
 	}
 
+	// Release what was reserved beyond a shorter stream.
+	if hint > n {
+		if err := f.Truncate(n); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+
 	// Fsync if needed
 	if l.fsyncOnWrite {
 		if err := fsync.Fsync(f); err != nil {
@@ -64,19 +109,97 @@ func (l *localStorage) Put(_ context.Context, remotePath string, r io.Reader) er
 	return f.Close()
 }
 
-func (l *localStorage) Get(_ context.Context, remotePath string) (io.ReadCloser, error) {
-	return os.Open(l.fullPath(remotePath))
+// PutIfAbsent implements ExclusivePutter. The object is written to a
+// temporary file first and hard-linked into place, which fails if the name
+// exists, so it appears complete or not at all.
+func (l *localStorage) PutIfAbsent(ctx context.Context, remotePath string, r io.Reader) error {
+	fullPath, err := l.fullPath(remotePath)
+	if err != nil {
+		return err
+	}
+	_, r = trackPutProgress(ctx, remotePath, r)
+	dir := filepath.Dir(fullPath)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(fullPath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if l.fsyncOnWrite {
+		if err := fsync.Fsync(f); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Link(f.Name(), fullPath); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("put if absent %q: %w", remotePath, ErrAlreadyExists)
+		}
+		return err
+	}
+	if l.fsyncOnWrite {
+		return fsync.FsyncDir(dir)
+	}
+	return nil
+}
+
+func (l *localStorage) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	fullPath, err := l.fullPath(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	total := int64(-1)
+	if st, err := f.Stat(); err == nil {
+		total = st.Size()
+	}
+	return trackGetProgress(ctx, remotePath, f, total), nil
+}
+
+func (l *localStorage) GetRange(_ context.Context, remotePath string, offset, length int64) (io.ReadCloser, error) {
+	fullPath, err := l.fullPath(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return limitRange(f, length), nil
 }
 
 func (l *localStorage) List(_ context.Context, remotePath string) ([]string, error) {
-	fullPath := l.fullPath(remotePath)
+	fullPath, err := l.fullPath(remotePath)
+	if err != nil {
+		return nil, err
+	}
 	var result []string
 
-	err := filepath.WalkDir(fullPath, func(path string, d fs.DirEntry, err error) error {
+	err = filepath.WalkDir(fullPath, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
+			if path == fullPath && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
 			return fmt.Errorf("error accessing path %q: %w", path, err)
 		}
-		if d.IsDir() {
+		if path == fullPath || d.IsDir() {
 			return nil
 		}
 		rel, err := filepath.Rel(l.baseDir, path)
@@ -89,75 +212,157 @@ func (l *localStorage) List(_ context.Context, remotePath string) ([]string, err
 	return result, err
 }
 
-func (l *localStorage) ListInfo(_ context.Context, remotePath string) ([]FileInfo, error) {
-	fullPath := l.fullPath(remotePath)
-	var result []FileInfo
+func (l *localStorage) ListInfo(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	return collectInfo(ctx, l, remotePath)
+}
 
-	err := filepath.WalkDir(fullPath, func(path string, d fs.DirEntry, err error) error {
+func (l *localStorage) WalkInfo(ctx context.Context, remotePath string, fn func(fi FileInfo) error) error {
+	fullPath, err := l.fullPath(remotePath)
+	if err != nil {
+		return err
+	}
+	opts := ListOptionsFromContext(ctx)
+
+	return filepath.WalkDir(fullPath, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
+			if path == fullPath && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
 			return fmt.Errorf("error accessing path %q: %w", path, err)
 		}
-		if d.IsDir() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if path == fullPath || (d.IsDir() && !opts.IncludeDirs) {
 			return nil
 		}
 		rel, err := filepath.Rel(l.baseDir, path)
 		if err != nil {
 			return err
 		}
+		rel = filepath.ToSlash(rel)
+		// Skip the stat of names the filters rule out anyway.
+		if !d.IsDir() && !opts.MatchName(rel) {
+			return nil
+		}
 		stat, err := os.Stat(path)
 		if err != nil {
 			return err
 		}
-		result = append(result, FileInfo{
-			Path:    filepath.ToSlash(rel),
+		if d.IsDir() {
+			return fn(FileInfo{Path: rel, ModTime: stat.ModTime(), IsDir: true})
+		}
+		fi := FileInfo{
+			Path:    rel,
 			ModTime: stat.ModTime(),
 			Size:    stat.Size(),
-		})
-		return nil
+		}
+		if !opts.Match(fi) {
+			return nil
+		}
+		return fn(fi)
 	})
-	return result, err
 }
 
 func (l *localStorage) Delete(_ context.Context, remotePath string) error {
-	return os.Remove(l.fullPath(remotePath))
+	fullPath, err := l.fullPath(remotePath)
+	if err != nil {
+		return err
+	}
+	return os.Remove(fullPath)
 }
 
 func (l *localStorage) DeleteDir(_ context.Context, remotePath string) error {
-	return os.RemoveAll(l.fullPath(remotePath))
+	fullPath, err := l.fullPath(remotePath)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(fullPath)
 }
 
-func (l *localStorage) DeleteAll(_ context.Context, remotePath string) error {
-	fullPath := l.fullPath(remotePath)
+func (l *localStorage) DeleteAll(ctx context.Context, remotePath string) error {
+	_, err := l.DeleteAllReport(ctx, remotePath)
+	return err
+}
 
-	entries, err := os.ReadDir(fullPath)
+// DeleteAllReport removes the files below remotePath one by one, then the
+// directories that became empty, deepest first.
+func (l *localStorage) DeleteAllReport(ctx context.Context, remotePath string) (DeleteReport, error) {
+	var report DeleteReport
+	fullPath, err := l.fullPath(remotePath)
 	if err != nil {
-		return err
+		return report, err
+	}
+	if _, err := os.ReadDir(fullPath); err != nil {
+		return report, err
 	}
 
-	for _, entry := range entries {
-		path := filepath.Join(fullPath, entry.Name())
-		err := os.RemoveAll(path)
+	var dirs []string
+	err = filepath.WalkDir(fullPath, func(p string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 		if err != nil {
-			return err
+			// An unreadable directory: its content stays where it is.
+			report.fail(l.remoteKey(p), err)
+			return nil
+		}
+		if p == fullPath {
+			return nil
+		}
+		if d.IsDir() {
+			dirs = append(dirs, p)
+			return nil
+		}
+		if err := os.Remove(p); err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				report.fail(l.remoteKey(p), err)
+			}
+			return nil
 		}
+		report.Deleted++
+		return nil
+	})
+	if err != nil {
+		return report, errors.Join(err, report.Err())
 	}
 
-	return nil
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Remove(dirs[i]); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			// A directory that still holds a failed file is already
+			// accounted for by that file.
+			if entries, _ := os.ReadDir(dirs[i]); len(entries) == 0 {
+				report.fail(l.remoteKey(dirs[i]), err)
+			}
+		}
+	}
+	return report, report.Err()
+}
+
+// remoteKey maps a native path below the base directory to its key.
+func (l *localStorage) remoteKey(fullPath string) string {
+	rel, err := filepath.Rel(l.baseDir, fullPath)
+	if err != nil {
+		return filepath.ToSlash(fullPath)
+	}
+	return filepath.ToSlash(rel)
 }
 
-func (l *localStorage) DeleteAllBulk(_ context.Context, paths []string) error {
-	for i := range paths {
-		fullPath := l.fullPath(paths[i])
-		err := os.RemoveAll(fullPath)
+func (l *localStorage) DeleteAllBulk(ctx context.Context, paths []string) error {
+	return deleteConcurrently(ctx, paths, l.deleteConcurrency, func(p string) error {
+		fullPath, err := l.fullPath(p)
 		if err != nil {
 			return err
 		}
-	}
-	return nil
+		return os.RemoveAll(fullPath)
+	})
 }
 
 func (l *localStorage) Exists(_ context.Context, remotePath string) (bool, error) {
-	fullPath := l.fullPath(remotePath)
+	fullPath, err := l.fullPath(remotePath)
+	if err != nil {
+		return false, err
+	}
 
 	info, err := os.Stat(fullPath)
 	if err != nil {
@@ -173,19 +378,39 @@ func (l *localStorage) Exists(_ context.Context, remotePath string) (bool, error
 	return false, nil
 }
 
+func (l *localStorage) Stat(_ context.Context, remotePath string) (FileInfo, error) {
+	fullPath, err := l.fullPath(remotePath)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return FileInfo{}, fmt.Errorf("stat %q: not a regular file: %w", remotePath, fs.ErrNotExist)
+	}
+	return FileInfo{Path: remotePath, ModTime: info.ModTime(), Size: info.Size()}, nil
+}
+
 func (l *localStorage) ListTopLevelDirs(_ context.Context, prefix string) (map[string]bool, error) {
-	fullPath := l.fullPath(prefix)
+	fullPath, err := l.fullPath(prefix)
+	if err != nil {
+		return nil, err
+	}
 	result := make(map[string]bool)
 
 	entries, err := os.ReadDir(fullPath)
 	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return result, nil
+		}
 		return nil, err
 	}
 
 	for _, entry := range entries {
 		if entry.IsDir() {
-			dirFullPath := filepath.ToSlash(filepath.Join(fullPath, entry.Name()))
-			rel, err := filepath.Rel(l.baseDir, dirFullPath)
+			rel, err := filepath.Rel(l.baseDir, filepath.Join(fullPath, entry.Name()))
 			if err != nil {
 				return nil, err
 			}
@@ -196,8 +421,14 @@ func (l *localStorage) ListTopLevelDirs(_ context.Context, prefix string) (map[s
 }
 
 func (l *localStorage) Rename(_ context.Context, oldRemotePath, newRemotePath string) error {
-	oldFull := l.fullPath(oldRemotePath)
-	newFull := l.fullPath(newRemotePath)
+	oldFull, err := l.fullPath(oldRemotePath)
+	if err != nil {
+		return err
+	}
+	newFull, err := l.fullPath(newRemotePath)
+	if err != nil {
+		return err
+	}
 
 	if oldFull == newFull {
 		return nil
@@ -210,3 +441,16 @@ func (l *localStorage) Rename(_ context.Context, oldRemotePath, newRemotePath st
 
 	return os.Rename(oldFull, newFull)
 }
+
+// Ping checks that the base directory exists and that its file system
+// answers statfs, which catches stale network mounts.
+func (l *localStorage) Ping(_ context.Context) error {
+	fi, err := os.Stat(l.baseDir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("ping: %s is not a directory", l.baseDir)
+	}
+	return statfs(l.baseDir)
+}
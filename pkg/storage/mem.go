@@ -2,8 +2,10 @@ package storage
 
 import (
 	"bytes"
+	"container/list"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"strings"
@@ -11,34 +13,167 @@ import (
 	"time"
 )
 
-// Used as mock in unit-tests
+// ErrQuota is returned by InMemoryStorage.Put when an object does not fit
+// in the configured limits.
+var ErrQuota = errors.New("storage quota exceeded")
 
+// MemLimits caps what an InMemoryStorage holds. Zero values mean no limit.
+type MemLimits struct {
+	MaxBytes   int64
+	MaxObjects int
+
+	// Evict makes Put drop the least recently used objects until the new
+	// one fits; otherwise Put fails with ErrQuota. An object larger than
+	// MaxBytes is always rejected.
+	Evict bool
+
+	// OnEvict, if set, is called for every evicted path. It runs with the
+	// storage locked and must not call back into it.
+	OnEvict func(path string)
+}
+
+// InMemoryStorage keeps objects in a map. It serves as mock in unit-tests
+// and, with Limits set, as a bounded cache tier.
 type InMemoryStorage struct {
 	Files map[string][]byte
-	mu    sync.RWMutex
+
+	// Now is the clock modification times are taken from; nil means
+	// time.Now. Set it to make ListInfo and Stat deterministic.
+	Now func() time.Time
+
+	// Limits applies to objects stored through the Storage methods; files
+	// added to the Files map directly are neither counted nor evicted.
+	Limits MemLimits
+
+	modTimes map[string]time.Time
+	mu       sync.RWMutex
+
+	// Usage in least recently used order, guarded by lruMu. Readers only
+	// hold mu for reading, so they need a lock of their own to touch.
+	lruMu   sync.Mutex
+	lru     *list.List // of *memEntry, most recently used first
+	entries map[string]*list.Element
+	used    int64
+}
+
+type memEntry struct {
+	path string
+	size int64
 }
 
-var _ Storage = &InMemoryStorage{}
+var (
+	_ Storage           = &InMemoryStorage{}
+	_ Stater            = &InMemoryStorage{}
+	_ RangeReader       = &InMemoryStorage{}
+	_ Pinger            = &InMemoryStorage{}
+	_ DeleteAllReporter = &InMemoryStorage{}
+	_ ExclusivePutter   = &InMemoryStorage{}
+)
 
 func NewInMemoryStorage() *InMemoryStorage {
 	return &InMemoryStorage{
-		Files: make(map[string][]byte),
+		Files:    make(map[string][]byte),
+		modTimes: make(map[string]time.Time),
+	}
+}
+
+func (s *InMemoryStorage) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
 	}
+	return time.Now()
 }
 
-func (s *InMemoryStorage) Put(_ context.Context, path string, r io.Reader) error {
+// modTime returns the time path was stored at. Files added to the Files
+// map directly have none recorded and report the current time.
+func (s *InMemoryStorage) modTime(path string) time.Time {
+	if t, ok := s.modTimes[path]; ok {
+		return t
+	}
+	return s.now()
+}
+
+// SetModTime overrides the modification time of a stored file, e.g. to
+// age it in tests of retention. It returns fs.ErrNotExist for a missing
+// file.
+func (s *InMemoryStorage) SetModTime(path string, t time.Time) error {
+	path, err := CleanPath(path)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.Files[path]; !ok {
+		return fs.ErrNotExist
+	}
+	s.setModTime(path, t)
+	return nil
+}
+
+func (s *InMemoryStorage) setModTime(path string, t time.Time) {
+	if s.modTimes == nil {
+		s.modTimes = make(map[string]time.Time)
+	}
+	s.modTimes[path] = t
+}
+
+func (s *InMemoryStorage) Put(ctx context.Context, path string, r io.Reader) error {
+	path, err := CleanPath(path)
+	if err != nil {
+		return err
+	}
+	_, r = trackPutProgress(ctx, path, r)
+
+	// Read before locking: the source may itself read from this storage.
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if err := s.makeRoom(path, int64(len(data))); err != nil {
+		return err
+	}
+	s.Files[path] = data
+	s.setModTime(path, s.now())
+	s.track(path, int64(len(data)))
+	return nil
+}
+
+// PutIfAbsent implements ExclusivePutter.
+func (s *InMemoryStorage) PutIfAbsent(ctx context.Context, path string, r io.Reader) error {
+	path, err := CleanPath(path)
+	if err != nil {
+		return err
+	}
+	_, r = trackPutProgress(ctx, path, r)
 	data, err := io.ReadAll(r)
 	if err != nil {
 		return err
 	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.Files[path]; ok {
+		return fmt.Errorf("put if absent %q: %w", path, ErrAlreadyExists)
+	}
+	if err := s.makeRoom(path, int64(len(data))); err != nil {
+		return err
+	}
 	s.Files[path] = data
+	s.setModTime(path, s.now())
+	s.track(path, int64(len(data)))
 	return nil
 }
 
-func (s *InMemoryStorage) Get(_ context.Context, path string) (io.ReadCloser, error) {
+func (s *InMemoryStorage) Get(ctx context.Context, path string) (io.ReadCloser, error) {
+	path, err := CleanPath(path)
+	if err != nil {
+		return nil, err
+	}
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -46,14 +181,39 @@ func (s *InMemoryStorage) Get(_ context.Context, path string) (io.ReadCloser, er
 	if !ok {
 		return nil, fs.ErrNotExist
 	}
+	s.touch(path)
+	return trackGetProgress(ctx, path, io.NopCloser(bytes.NewReader(data)), int64(len(data))), nil
+}
+
+func (s *InMemoryStorage) GetRange(_ context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	path, err := CleanPath(path)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.Files[path]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	s.touch(path)
+	data = data[min(offset, int64(len(data))):]
+	if length >= 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
 	return io.NopCloser(bytes.NewReader(data)), nil
 }
 
 func (s *InMemoryStorage) List(_ context.Context, path string) ([]string, error) {
+	path, err := CleanPath(path)
+	if err != nil {
+		return nil, err
+	}
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	prefix := strings.TrimSuffix(path, "/") + "/"
+	prefix := dirPrefix(path)
 
 	keys := make([]string, 0)
 	for k := range s.Files {
@@ -64,26 +224,47 @@ func (s *InMemoryStorage) List(_ context.Context, path string) ([]string, error)
 	return keys, nil
 }
 
-func (s *InMemoryStorage) ListInfo(_ context.Context, path string) ([]FileInfo, error) {
+func (s *InMemoryStorage) ListInfo(ctx context.Context, path string) ([]FileInfo, error) {
+	path, err := CleanPath(path)
+	if err != nil {
+		return nil, err
+	}
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	var infos []FileInfo
-	prefix := strings.TrimSuffix(path, "/") + "/"
+	prefix := dirPrefix(path)
+	opts := ListOptionsFromContext(ctx)
+	dirs := newImpliedDirs(path)
 
 	for name, data := range s.Files {
-		if strings.HasPrefix(name, prefix) {
-			infos = append(infos, FileInfo{
-				Path:    name,
-				ModTime: time.Now(),
-				Size:    int64(len(data)),
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if opts.IncludeDirs {
+			// Directories are implied by every object, filtered or not.
+			_ = dirs.add(name, func(dir FileInfo) error {
+				infos = append(infos, dir)
+				return nil
 			})
 		}
+		fi := FileInfo{
+			Path:    name,
+			ModTime: s.modTime(name),
+			Size:    int64(len(data)),
+		}
+		if opts.Match(fi) {
+			infos = append(infos, fi)
+		}
 	}
 	return infos, nil
 }
 
 func (s *InMemoryStorage) Delete(_ context.Context, path string) error {
+	path, err := CleanPath(path)
+	if err != nil {
+		return err
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -91,28 +272,43 @@ func (s *InMemoryStorage) Delete(_ context.Context, path string) error {
 		return fs.ErrNotExist
 	}
 	delete(s.Files, path)
+	delete(s.modTimes, path)
+	s.forget(path)
 	return nil
 }
 
 func (s *InMemoryStorage) DeleteAll(ctx context.Context, path string) error {
+	_, err := s.DeleteAllReport(ctx, path)
+	return err
+}
+
+func (s *InMemoryStorage) DeleteAllReport(ctx context.Context, path string) (DeleteReport, error) {
+	var report DeleteReport
+	path, err := CleanPath(path)
+	if err != nil {
+		return report, err
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	prefix := strings.TrimSuffix(path, "/") + "/"
+	prefix := dirPrefix(path)
 
 	for key := range s.Files {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return report, ctx.Err()
 		default:
 		}
 
 		if strings.HasPrefix(key, prefix) || key == path {
 			delete(s.Files, key)
+			delete(s.modTimes, key)
+			s.forget(key)
+			report.Deleted++
 		}
 	}
 
-	return nil
+	return report, nil
 }
 
 func (s *InMemoryStorage) DeleteDir(ctx context.Context, path string) error {
@@ -131,18 +327,40 @@ func (s *InMemoryStorage) DeleteAllBulk(ctx context.Context, paths []string) err
 }
 
 func (s *InMemoryStorage) Exists(_ context.Context, path string) (bool, error) {
+	path, err := CleanPath(path)
+	if err != nil {
+		return false, err
+	}
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	_, ok := s.Files[path]
 	return ok, nil
 }
 
+func (s *InMemoryStorage) Stat(_ context.Context, path string) (FileInfo, error) {
+	path, err := CleanPath(path)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, ok := s.Files[path]
+	if !ok {
+		return FileInfo{}, fs.ErrNotExist
+	}
+	return FileInfo{Path: path, ModTime: s.modTime(path), Size: int64(len(data))}, nil
+}
+
 func (s *InMemoryStorage) ListTopLevelDirs(ctx context.Context, prefix string) (map[string]bool, error) {
+	prefix, err := CleanPath(prefix)
+	if err != nil {
+		return nil, err
+	}
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	result := make(map[string]bool)
-	normalizedPrefix := strings.TrimSuffix(prefix, "/") + "/"
+	normalizedPrefix := dirPrefix(prefix)
 
 	for filePath := range s.Files {
 		select {
@@ -156,7 +374,7 @@ func (s *InMemoryStorage) ListTopLevelDirs(ctx context.Context, prefix string) (
 			if idx := strings.Index(relativePath, "/"); idx != -1 {
 				dirname := relativePath[:idx]
 				if dirname != "" {
-					result[dirname] = true
+					result[normalizedPrefix+dirname] = true
 				}
 			}
 		}
@@ -166,6 +384,14 @@ func (s *InMemoryStorage) ListTopLevelDirs(ctx context.Context, prefix string) (
 }
 
 func (s *InMemoryStorage) Rename(ctx context.Context, oldRemotePath, newRemotePath string) error {
+	oldRemotePath, err := CleanPath(oldRemotePath)
+	if err != nil {
+		return err
+	}
+	newRemotePath, err = CleanPath(newRemotePath)
+	if err != nil {
+		return err
+	}
 	if oldRemotePath == newRemotePath {
 		return nil
 	}
@@ -189,12 +415,132 @@ func (s *InMemoryStorage) Rename(ctx context.Context, oldRemotePath, newRemotePa
 
 	data, ok := s.Files[oldRemotePath]
 	if !ok {
-		return errors.New("file not found")
+		return fmt.Errorf("rename %q: %w", oldRemotePath, fs.ErrNotExist)
 	}
 
-	// Move entry under new key
+	// Move entry under new key, keeping its modification time as a
+	// rename on a file system does
+	modTime := s.modTime(oldRemotePath)
 	s.Files[newRemotePath] = data
 	delete(s.Files, oldRemotePath)
+	delete(s.modTimes, oldRemotePath)
+	s.setModTime(newRemotePath, modTime)
+	s.forget(newRemotePath)
+	if s.forget(oldRemotePath) {
+		s.track(newRemotePath, int64(len(data)))
+	}
 
 	return nil
 }
+
+func (s *InMemoryStorage) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// Usage returns the bytes and objects counted against Limits.
+func (s *InMemoryStorage) Usage() (size int64, objects int) {
+	s.lruMu.Lock()
+	defer s.lruMu.Unlock()
+	if s.lru == nil {
+		return 0, 0
+	}
+	return s.used, s.lru.Len()
+}
+
+// makeRoom checks that size bytes can be stored at path, evicting other
+// objects if Limits allow it. The caller holds mu.
+func (s *InMemoryStorage) makeRoom(path string, size int64) error {
+	l := s.Limits
+	if l.MaxBytes <= 0 && l.MaxObjects <= 0 {
+		return nil
+	}
+	if l.MaxBytes > 0 && size > l.MaxBytes {
+		return fmt.Errorf("put %q: %d bytes exceed the limit of %d: %w", path, size, l.MaxBytes, ErrQuota)
+	}
+
+	s.lruMu.Lock()
+	defer s.lruMu.Unlock()
+	s.initLRU()
+	used, objects := s.used, s.lru.Len()
+	if el, ok := s.entries[path]; ok {
+		// replaced by the new object
+		used -= el.Value.(*memEntry).size
+		objects--
+	}
+	fits := func() bool {
+		return (l.MaxBytes <= 0 || used+size <= l.MaxBytes) &&
+			(l.MaxObjects <= 0 || objects < l.MaxObjects)
+	}
+	if fits() {
+		return nil
+	}
+	if !l.Evict {
+		return fmt.Errorf("put %q: %w", path, ErrQuota)
+	}
+	for el := s.lru.Back(); !fits(); {
+		if el == nil {
+			return fmt.Errorf("put %q: %w", path, ErrQuota)
+		}
+		e := el.Value.(*memEntry)
+		el = el.Prev()
+		if e.path == path {
+			continue
+		}
+		s.remove(s.entries[e.path])
+		delete(s.Files, e.path)
+		delete(s.modTimes, e.path)
+		used -= e.size
+		objects--
+		if l.OnEvict != nil {
+			l.OnEvict(e.path)
+		}
+	}
+	return nil
+}
+
+func (s *InMemoryStorage) initLRU() {
+	if s.lru == nil {
+		s.lru = list.New()
+		s.entries = make(map[string]*list.Element)
+	}
+}
+
+// track records path as stored with size bytes and most recently used.
+func (s *InMemoryStorage) track(path string, size int64) {
+	s.lruMu.Lock()
+	defer s.lruMu.Unlock()
+	s.initLRU()
+	if el, ok := s.entries[path]; ok {
+		s.remove(el)
+	}
+	s.entries[path] = s.lru.PushFront(&memEntry{path: path, size: size})
+	s.used += size
+}
+
+// touch marks path as most recently used.
+func (s *InMemoryStorage) touch(path string) {
+	s.lruMu.Lock()
+	defer s.lruMu.Unlock()
+	if el, ok := s.entries[path]; ok {
+		s.lru.MoveToFront(el)
+	}
+}
+
+// forget stops counting path and reports whether it was counted.
+func (s *InMemoryStorage) forget(path string) bool {
+	s.lruMu.Lock()
+	defer s.lruMu.Unlock()
+	el, ok := s.entries[path]
+	if ok {
+		s.remove(el)
+	}
+	return ok
+}
+
+// remove drops an entry; the caller holds lruMu.
+func (s *InMemoryStorage) remove(el *list.Element) {
+	e := el.Value.(*memEntry)
+	s.lru.Remove(el)
+	delete(s.entries, e.path)
+	s.used -= e.size
+}
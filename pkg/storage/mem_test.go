@@ -3,8 +3,10 @@ package storage
 import (
 	"bytes"
 	"context"
+	"io/fs"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -140,9 +142,9 @@ func TestInMemoryStorage_ListTopLevelDirs(t *testing.T) {
 	assert.NoError(t, err)
 
 	expected := map[string]bool{
-		"dir1": true,
-		"dir2": true,
-		"dir3": true,
+		"prefix/dir1": true,
+		"prefix/dir2": true,
+		"prefix/dir3": true,
 	}
 
 	assert.Len(t, result, 3)
@@ -239,3 +241,100 @@ func TestInMemoryStorage_DeleteNonExisting(t *testing.T) {
 	err := s.Delete(ctx, "nope.txt")
 	assert.Error(t, err)
 }
+
+func TestInMemoryStorage_ModTime(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := NewInMemoryStorage()
+	s.Now = func() time.Time { return now }
+
+	assert.NoError(t, s.Put(ctx, "dir/a", strings.NewReader("abc")))
+	now = now.Add(time.Hour)
+	assert.NoError(t, s.Put(ctx, "dir/b", strings.NewReader("abcdef")))
+	now = now.Add(time.Hour)
+
+	fi, err := s.Stat(ctx, "dir/a")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), fi.ModTime)
+	assert.Equal(t, int64(3), fi.Size)
+
+	// Rename keeps the time of the original Put.
+	assert.NoError(t, s.Rename(ctx, "dir/b", "dir/c"))
+	infos, err := s.ListInfo(ctx, "dir")
+	assert.NoError(t, err)
+	got := make(map[string]FileInfo)
+	for _, fi := range infos {
+		got[fi.Path] = fi
+	}
+	assert.Len(t, got, 2)
+	assert.Equal(t, time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC), got["dir/c"].ModTime)
+	assert.Equal(t, int64(6), got["dir/c"].Size)
+
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, s.SetModTime("dir/a", old))
+	fi, err = s.Stat(ctx, "dir/a")
+	assert.NoError(t, err)
+	assert.Equal(t, old, fi.ModTime)
+	assert.ErrorIs(t, s.SetModTime("dir/missing", old), fs.ErrNotExist)
+
+	// Files stored directly in the map report the clock.
+	s.Files["dir/raw"] = []byte("x")
+	fi, err = s.Stat(ctx, "dir/raw")
+	assert.NoError(t, err)
+	assert.Equal(t, now, fi.ModTime)
+}
+
+func TestInMemoryStorage_Quota(t *testing.T) {
+	ctx := context.Background()
+	s := NewInMemoryStorage()
+	s.Limits = MemLimits{MaxBytes: 10, MaxObjects: 2}
+
+	assert.NoError(t, s.Put(ctx, "dir/a", strings.NewReader("aaaa")))
+	assert.NoError(t, s.Put(ctx, "dir/b", strings.NewReader("bbbb")))
+	assert.ErrorIs(t, s.Put(ctx, "dir/c", strings.NewReader("c")), ErrQuota, "object limit")
+	assert.ErrorIs(t, s.Put(ctx, "dir/a", strings.NewReader("aaaaaaa")), ErrQuota, "byte limit")
+
+	// Replacing an object only counts the difference.
+	assert.NoError(t, s.Put(ctx, "dir/a", strings.NewReader("aaaaaa")))
+	size, objects := s.Usage()
+	assert.Equal(t, int64(10), size)
+	assert.Equal(t, 2, objects)
+
+	assert.NoError(t, s.Delete(ctx, "dir/b"))
+	size, objects = s.Usage()
+	assert.Equal(t, int64(6), size)
+	assert.Equal(t, 1, objects)
+}
+
+func TestInMemoryStorage_Evict(t *testing.T) {
+	ctx := context.Background()
+	var evicted []string
+	s := NewInMemoryStorage()
+	s.Limits = MemLimits{MaxBytes: 10, Evict: true, OnEvict: func(p string) { evicted = append(evicted, p) }}
+
+	assert.NoError(t, s.Put(ctx, "dir/a", strings.NewReader("aaa")))
+	assert.NoError(t, s.Put(ctx, "dir/b", strings.NewReader("bbb")))
+	assert.NoError(t, s.Put(ctx, "dir/c", strings.NewReader("ccc")))
+
+	// Reading a makes b the least recently used.
+	rc, err := s.Get(ctx, "dir/a")
+	assert.NoError(t, err)
+	assert.NoError(t, rc.Close())
+
+	assert.NoError(t, s.Put(ctx, "dir/d", strings.NewReader("dddd")))
+	assert.Equal(t, []string{"dir/b"}, evicted)
+	files, err := s.List(ctx, "dir")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"dir/a", "dir/c", "dir/d"}, files)
+
+	assert.ErrorIs(t, s.Put(ctx, "dir/e", strings.NewReader("eeeeeeeeeee")), ErrQuota, "larger than the cache")
+	size, objects := s.Usage()
+	assert.Equal(t, int64(10), size)
+	assert.Equal(t, 3, objects)
+
+	// A renamed object keeps being counted.
+	assert.NoError(t, s.Rename(ctx, "dir/a", "dir/z"))
+	size, objects = s.Usage()
+	assert.Equal(t, int64(10), size)
+	assert.Equal(t, 3, objects)
+}
@@ -2,10 +2,15 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
+	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/hashmap-kz/storecrypt/pkg/crypters"
 	"github.com/hashmap-kz/streamcrypt/pkg/codec"
 	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
 	"github.com/hashmap-kz/streamcrypt/pkg/pipe"
@@ -16,27 +21,136 @@ type TransformingStorage struct {
 	Crypter      crypt.Crypter
 	Compressor   codec.Compressor
 	Decompressor codec.Decompressor
+
+	// PreviousCrypters are tried, in order, when Crypter fails to
+	// authenticate an object on Get. New writes always use Crypter, so a
+	// key can be rotated without orphaning existing archives. They must
+	// share Crypter's file extension.
+	PreviousCrypters []crypt.Crypter
+
+	// RecordSizes makes Put record the plaintext size of every object in
+	// its ObjectHeader so that ListInfo can report logical sizes, at the
+	// cost of a ranged read of every header it lists. The size must be
+	// known before the object is streamed: it is taken from WithSizeHint,
+	// which must then be exact, or else measured by spooling the
+	// plaintext to a temporary file.
+	RecordSizes bool
+
+	// WriteHeader makes Put write a self-describing ObjectHeader in front
+	// of every object. Get always honours a header when one is present.
+	WriteHeader bool
+
+	// IntegrityTrailer makes Put append a SHA-256 digest of the plaintext
+	// before compressing and encrypting it, and Get verify it as the
+	// stream completes. A truncated or altered object then fails with
+	// ErrIntegrity (from Read at EOF and from Close) instead of ending
+	// early without notice. Such objects always carry an ObjectHeader
+	// with HeaderFlagTrailer set, and Get verifies a trailer only when the
+	// header says there is one, so objects written before this was set
+	// stay readable. A valid trailer of a header-less object, as written
+	// by earlier releases, is stripped but cannot be required.
+	IntegrityTrailer bool
+
+	// Algorithms resolve the codecs and crypters named by object headers
+	// other than Compressor and Crypter, e.g. of objects written before
+	// the compression was changed. Without it, such objects fail with
+	// *UnknownTransformError.
+	Algorithms *Algorithms
+
+	// SeekableFrameSize, when > 0, makes Put split the plaintext into
+	// frames of this many bytes, compressed and encrypted independently
+	// and followed by a chunk index, so that GetRange and OpenSeekable
+	// decode only the frames a read touches. Such objects always carry an
+	// ObjectHeader and need no integrity trailer: frames record their
+	// position and the last one is flagged. Larger frames compress better;
+	// smaller ones waste less on short reads.
+	SeekableFrameSize int
 }
 
-var _ Storage = &TransformingStorage{}
+var (
+	_ Storage     = &TransformingStorage{}
+	_ Walker      = &TransformingStorage{}
+	_ RangeReader = &TransformingStorage{}
+)
 
 func (ts *TransformingStorage) Put(ctx context.Context, path string, r io.Reader) error {
-	transformed, err := ts.wrapWrite(r)
-	if err != nil {
-		return err
+	// Progress is reported on the plaintext stream.
+	ctx, r = trackPutProgress(ctx, path, r)
+	size := PutOptionsFromContext(ctx).Size
+	if ts.RecordSizes {
+		var release func()
+		var err error
+		if r, size, release, err = measurePlaintext(r, path, size); err != nil {
+			return err
+		}
+		defer release()
+	}
+	var transformed io.Reader
+	if ts.SeekableFrameSize > 0 {
+		hdr := ts.newObjectHeader(size)
+		hdr.Flags |= HeaderFlagSeekable
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(ts.writeSeekable(pw, hdr, r))
+		}()
+		defer pr.Close()
+		transformed = pr
+	} else {
+		if ts.IntegrityTrailer {
+			r = newTrailerReader(r)
+		}
+		var err error
+		if transformed, err = ts.wrapWrite(r); err != nil {
+			return err
+		}
+		if ts.WriteHeader || ts.IntegrityTrailer || ts.RecordSizes {
+			hdr := ts.newObjectHeader(size)
+			if ts.IntegrityTrailer {
+				hdr.Flags |= HeaderFlagTrailer
+			}
+			if transformed, err = prependObjectHeader(hdr, transformed); err != nil {
+				return err
+			}
+		}
 	}
-	return ts.Backend.Put(ctx, ts.encodePath(path), transformed)
+	return ts.Backend.Put(withoutPutSizeHint(ctx), ts.encodePath(path), transformed)
 }
 
 func (ts *TransformingStorage) Get(ctx context.Context, path string) (io.ReadCloser, error) {
 	// Fetch from backend
 	encodePath := ts.encodePath(path)
-	rc, err := ts.Backend.Get(ctx, encodePath)
+	rc, err := ts.Backend.Get(withoutGetProgress(ctx), encodePath)
+	if err != nil {
+		return nil, err
+	}
+	hdr, body, err := readObjectHeader(rc)
 	if err != nil {
+		_ = rc.Close()
 		return nil, err
 	}
+	if hdr != nil && hdr.Flags&HeaderFlagSeekable != 0 {
+		if _, _, err := ts.transformsFromHeader(hdr); err != nil {
+			_ = rc.Close()
+			return nil, err
+		}
+		sr := &seekableReader{ts: ts, r: body, c: body, hdr: hdr, path: path}
+		return trackGetProgress(ctx, path, sr, -1), nil
+	}
 	// Wrap with decrypt + decompress
-	return ts.wrapRead(rc)
+	decoded, err := ts.wrapRead(body, hdr)
+	if err != nil {
+		_ = rc.Close()
+		return nil, err
+	}
+	switch {
+	case hdr != nil && hdr.Flags&HeaderFlagTrailer != 0:
+		decoded = newIntegrityReader(decoded, path)
+	case hdr == nil && ts.IntegrityTrailer:
+		ir := newIntegrityReader(decoded, path)
+		ir.optional = true
+		decoded = ir
+	}
+	return trackGetProgress(ctx, path, decoded, -1), nil
 }
 
 func (ts *TransformingStorage) List(ctx context.Context, prefix string) ([]string, error) {
@@ -50,34 +164,77 @@ func (ts *TransformingStorage) List(ctx context.Context, prefix string) ([]strin
 	return files, nil
 }
 
+// ListInfo rewrites paths to logical names. With RecordSizes, Size is the
+// logical size recorded in the object header (when there is one) and
+// StoredSize the backend size.
 func (ts *TransformingStorage) ListInfo(ctx context.Context, prefix string) ([]FileInfo, error) {
-	files, err := ts.Backend.ListInfo(ctx, prefix)
-	if err != nil {
-		return nil, err
-	}
-	for i := range files {
-		files[i].Path = ts.decodePath(files[i].Path)
-	}
-	return files, nil
+	return collectInfo(ctx, ts, prefix)
+}
+
+// WalkInfo streams what ListInfo returns. With RecordSizes, the header of
+// every object is read as it is listed. The backend applies the time
+// filters; names and sizes are filtered once they are logical.
+func (ts *TransformingStorage) WalkInfo(ctx context.Context, prefix string, fn func(fi FileInfo) error) error {
+	opts := ListOptionsFromContext(ctx)
+	return WalkInfo(withoutEntryFilters(ctx), ts.Backend, prefix, func(fi FileInfo) error {
+		if fi.IsDir {
+			return fn(fi)
+		}
+		if ts.RecordSizes {
+			n, ok, err := ts.recordedSize(ctx, fi.Path)
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil // deleted since it was listed
+			}
+			if err != nil {
+				return err
+			}
+			fi.StoredSize = fi.Size
+			if ok {
+				fi.Size = n
+			}
+		}
+		fi.Path = ts.decodePath(fi.Path)
+		if !opts.Match(fi) {
+			return nil
+		}
+		return fn(fi)
+	})
 }
 
 func (ts *TransformingStorage) Delete(ctx context.Context, path string) error {
 	return ts.Backend.Delete(ctx, ts.encodePath(path))
 }
 
+// DeleteDir removes the logical path both as a directory and as an
+// object, like the backends do for raw paths.
 func (ts *TransformingStorage) DeleteDir(ctx context.Context, path string) error {
-	return ts.Backend.DeleteDir(ctx, path)
+	path = strings.TrimSuffix(filepath.ToSlash(path), "/")
+	if err := ts.Backend.DeleteDir(ctx, path); err != nil {
+		return err
+	}
+	if err := ts.Delete(ctx, path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
 }
 
 func (ts *TransformingStorage) DeleteAll(ctx context.Context, path string) error {
-	return ts.Backend.DeleteAll(ctx, path)
+	return ts.Backend.DeleteAll(ctx, strings.TrimSuffix(filepath.ToSlash(path), "/"))
 }
 
+// DeleteAllBulk removes every logical path as an object (its encoded name)
+// and as a directory (its raw name), in a single backend call. paths is
+// not modified.
 func (ts *TransformingStorage) DeleteAllBulk(ctx context.Context, paths []string) error {
-	for i := range paths {
-		paths[i] = ts.encodePath(paths[i])
+	targets := make([]string, 0, 2*len(paths))
+	for _, p := range paths {
+		p = strings.TrimSuffix(filepath.ToSlash(p), "/")
+		targets = append(targets, p)
+		if encoded := ts.encodePath(p); encoded != p {
+			targets = append(targets, encoded)
+		}
 	}
-	return ts.Backend.DeleteAllBulk(ctx, paths)
+	return ts.Backend.DeleteAllBulk(ctx, targets)
 }
 
 func (ts *TransformingStorage) Exists(ctx context.Context, path string) (bool, error) {
@@ -96,18 +253,133 @@ func (ts *TransformingStorage) Rename(ctx context.Context, oldRemotePath, newRem
 	if oldEncoded == newEncoded {
 		return nil
 	}
-
 	return ts.Backend.Rename(ctx, oldEncoded, newEncoded)
 }
 
+// logical sizes
+
+// newObjectHeader describes an object written by ts. With RecordSizes,
+// size is exact and recorded even if zero.
+func (ts *TransformingStorage) newObjectHeader(size int64) *ObjectHeader {
+	hdr := newObjectHeader(ts.Compressor, ts.Crypter, size)
+	if ts.RecordSizes {
+		hdr.Size = size
+	}
+	return hdr
+}
+
+// measurePlaintext returns a reader yielding what r yields and its size:
+// hint, which the reader then enforces, if positive, or else the size
+// found by spooling r to a temporary file. release removes the spool.
+func measurePlaintext(r io.Reader, path string, hint int64) (io.Reader, int64, func(), error) {
+	if hint > 0 {
+		return &exactReader{r: r, size: hint, path: path}, hint, func() {}, nil
+	}
+	spool, err := os.CreateTemp("", "storecrypt-size-")
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	release := func() {
+		_ = spool.Close()
+		_ = os.Remove(spool.Name())
+	}
+	n, err := io.Copy(spool, r)
+	if err == nil {
+		_, err = spool.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		release()
+		return nil, 0, nil, err
+	}
+	return spool, n, release, nil
+}
+
+// exactReader fails unless r yields exactly size bytes, the size already
+// recorded in the header.
+type exactReader struct {
+	r    io.Reader
+	size int64
+	n    int64
+	path string
+}
+
+func (e *exactReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	e.n += int64(n)
+	if e.n > e.size || (errors.Is(err, io.EOF) && e.n < e.size) {
+		return n, fmt.Errorf("put %q: read %d bytes, the size hint is %d", e.path, e.n, e.size)
+	}
+	return n, err
+}
+
+// recordedSize returns the logical size recorded in the header of the
+// stored object encoded. ok is false for objects without one.
+func (ts *TransformingStorage) recordedSize(ctx context.Context, encoded string) (size int64, ok bool, err error) {
+	hdr, err := storedObjectHeader(ctx, ts.Backend, encoded)
+	if err != nil || hdr == nil || hdr.Size < 0 {
+		return 0, false, err
+	}
+	return hdr.Size, true, nil
+}
+
 // compress/encrypt wrappers
 
 func (ts *TransformingStorage) wrapWrite(in io.Reader) (io.Reader, error) {
 	return pipe.CompressAndEncryptOptional(in, ts.Compressor, ts.Crypter)
 }
 
-func (ts *TransformingStorage) wrapRead(in io.Reader) (io.ReadCloser, error) {
-	return pipe.DecryptAndDecompressOptional(in, ts.Crypter, ts.Decompressor)
+// wrapRead decodes in with the configured transforms, or with the ones
+// named by hdr when the object carries a header.
+func (ts *TransformingStorage) wrapRead(in io.Reader, hdr *ObjectHeader) (io.ReadCloser, error) {
+	c, d := ts.Crypter, ts.Decompressor
+	if hdr != nil {
+		var err error
+		if c, d, err = ts.transformsFromHeader(hdr); err != nil {
+			return nil, err
+		}
+	}
+	if ts.Crypter != nil && len(ts.PreviousCrypters) > 0 {
+		c = crypters.NewKeyring(c, ts.PreviousCrypters...)
+	}
+	return pipe.DecryptAndDecompressOptional(in, c, d)
+}
+
+// transformsFromHeader resolves the transforms named by hdr: the
+// configured ones first, then Algorithms. With a Crypter configured, hdr
+// must name it (see crypterMismatch).
+func (ts *TransformingStorage) transformsFromHeader(hdr *ObjectHeader) (crypt.Crypter, codec.Decompressor, error) {
+	var c crypt.Crypter
+	var d codec.Decompressor
+	if hdr.Codec != "" {
+		if ts.Compressor != nil && strings.TrimPrefix(ts.Compressor.FileExtension(), ".") == hdr.Codec {
+			d = ts.Decompressor
+		} else if pair := ts.Algorithms.codec(hdr.Codec); pair != nil {
+			d = pair.Decompressor
+		} else {
+			return nil, nil, &UnknownTransformError{Kind: "codec", Name: hdr.Codec}
+		}
+	}
+	switch {
+	case ts.Crypter != nil:
+		if want := strings.TrimPrefix(ts.Crypter.FileExtension(), "."); hdr.Crypter != want {
+			return nil, nil, crypterMismatch(hdr.Crypter, want)
+		}
+		c = ts.Crypter
+	case hdr.Crypter != "":
+		if c = ts.Algorithms.crypter(hdr.Crypter); c == nil {
+			return nil, nil, &UnknownTransformError{Kind: "crypter", Name: hdr.Crypter}
+		}
+	}
+	return c, d, nil
+}
+
+// KeyID returns the id of the key new objects are encrypted with, if the
+// crypter exposes one (see KeyIdentifier), and "" otherwise.
+func (ts *TransformingStorage) KeyID() string {
+	if k, ok := ts.Crypter.(KeyIdentifier); ok {
+		return k.KeyID()
+	}
+	return ""
 }
 
 // utils
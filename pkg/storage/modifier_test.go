@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/hashmap-kz/storecrypt/pkg/crypters"
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/aesgcm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformingStorage_RecordSizes(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	ts := &TransformingStorage{
+		Backend:      mem,
+		Crypter:      aesgcm.NewChunkedGCMCrypter("password"),
+		Compressor:   codec.GzipCompressor{},
+		Decompressor: codec.GzipDecompressor{},
+		RecordSizes:  true,
+	}
+
+	payload := bytes.Repeat([]byte("a"), 10_000)
+	require.NoError(t, ts.Put(ctx, "base/big", bytes.NewReader(payload)))
+	require.NoError(t, ts.Put(ctx, "base/small", bytes.NewReader([]byte("xyz"))))
+	// Overwrite replaces the recorded size.
+	require.NoError(t, ts.Put(ctx, "base/small", bytes.NewReader([]byte("xy"))))
+
+	infos, err := ts.ListInfo(ctx, "base")
+	require.NoError(t, err)
+	got := make(map[string]FileInfo)
+	for _, fi := range infos {
+		got[fi.Path] = fi
+	}
+	require.Len(t, got, 2)
+	assert.Equal(t, int64(10_000), got["base/big"].Size)
+	assert.Less(t, got["base/big"].StoredSize, int64(10_000))
+	assert.Equal(t, int64(2), got["base/small"].Size)
+
+	files, err := ts.List(ctx, "base")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"base/big", "base/small"}, files)
+
+	require.NoError(t, ts.Rename(ctx, "base/big", "base/moved"))
+	require.NoError(t, ts.Delete(ctx, "base/small"))
+
+	infos, err = ts.ListInfo(ctx, "base")
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "base/moved", infos[0].Path)
+	assert.Equal(t, int64(10_000), infos[0].Size)
+
+	// Sizes live in the object headers; nothing else is stored.
+	stored, err := mem.List(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"base/moved" + ts.getFileExt()}, stored)
+	hdr, _, ok := parseObjectHeader(mem.Files["base/moved"+ts.getFileExt()])
+	require.True(t, ok)
+	assert.Equal(t, int64(10_000), hdr.Size)
+}
+
+func TestTransformingStorage_RecordSizes_Hint(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	ts := &TransformingStorage{Backend: mem, RecordSizes: true}
+
+	// An empty object records a size of zero, not an unknown one.
+	require.NoError(t, ts.Put(ctx, "empty", bytes.NewReader(nil)))
+	hintCtx := ContextWithPutOptions(ctx, WithSizeHint(4))
+	require.NoError(t, ts.Put(hintCtx, "hinted", bytes.NewReader([]byte("data"))))
+
+	infos, err := ts.ListInfo(ctx, "")
+	require.NoError(t, err)
+	sizes := make(map[string]int64)
+	for _, fi := range infos {
+		sizes[fi.Path] = fi.Size
+	}
+	assert.Equal(t, map[string]int64{"empty": 0, "hinted": 4}, sizes)
+
+	// A wrong hint fails the Put instead of recording a wrong size.
+	for _, data := range []string{"dat", "data!"} {
+		err := ts.Put(hintCtx, "wrong", bytes.NewReader([]byte(data)))
+		assert.ErrorContains(t, err, "the size hint is 4", data)
+		ok, err := ts.Exists(ctx, "wrong")
+		require.NoError(t, err)
+		assert.False(t, ok, data)
+	}
+}
+
+func TestTransformingStorage_PreviousCrypters(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+
+	oldKey := crypters.NewEnvelope(newEnvelopeKey(t, "k"))
+	newKey := crypters.NewEnvelope(newEnvelopeKey(t, "k"))
+
+	before := &TransformingStorage{Backend: mem, Crypter: oldKey}
+	require.NoError(t, before.Put(ctx, "a", bytes.NewReader([]byte("old archive"))))
+
+	after := &TransformingStorage{Backend: mem, Crypter: newKey, PreviousCrypters: []crypt.Crypter{oldKey}}
+	require.NoError(t, after.Put(ctx, "b", bytes.NewReader([]byte("new archive"))))
+
+	for name, want := range map[string]string{"a": "old archive", "b": "new archive"} {
+		rc, err := after.Get(ctx, name)
+		require.NoError(t, err)
+		got, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		assert.Equal(t, want, string(got))
+	}
+
+	// Without the old key the archive is unreadable.
+	_, err := (&TransformingStorage{Backend: mem, Crypter: newKey}).Get(ctx, "a")
+	assert.Error(t, err)
+}
+
+func TestTransformingStorage_PrefixDeletes(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	ts := &TransformingStorage{
+		Backend:      mem,
+		Compressor:   codec.GzipCompressor{},
+		Decompressor: codec.GzipDecompressor{},
+		RecordSizes:  true,
+	}
+	for _, p := range []string{"x", "x/a", "dir/a", "dir/sub/b", "other"} {
+		require.NoError(t, ts.Put(ctx, p, bytes.NewReader([]byte(p))))
+	}
+
+	paths := []string{"x", "dir/sub/"}
+	require.NoError(t, ts.DeleteAllBulk(ctx, paths))
+	assert.Equal(t, []string{"x", "dir/sub/"}, paths, "input must not be modified")
+
+	for p, want := range map[string]bool{"x": false, "x/a": false, "dir/sub/b": false, "dir/a": true, "other": true} {
+		ok, err := ts.Exists(ctx, p)
+		require.NoError(t, err)
+		assert.Equal(t, want, ok, p)
+	}
+
+	require.NoError(t, ts.Put(ctx, "dir", bytes.NewReader([]byte("dir"))))
+	require.NoError(t, ts.DeleteDir(ctx, "dir"))
+	for p, want := range map[string]bool{"dir": false, "dir/a": false, "other": true} {
+		ok, err := ts.Exists(ctx, p)
+		require.NoError(t, err)
+		assert.Equal(t, want, ok, p)
+	}
+}
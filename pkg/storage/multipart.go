@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+)
+
+// DefaultUploadDir is the backend directory where emulated multipart
+// uploads keep their parts until they are completed or aborted.
+const DefaultUploadDir = ".uploads"
+
+// MaxUploadParts is the highest part number of a multipart upload.
+const MaxUploadParts = 10000
+
+// uploadTargetName is the object of an emulated upload that records the
+// path it was started for.
+const uploadTargetName = "target"
+
+// Part is an uploaded part of a multipart upload, as returned by
+// UploadPart and passed back to CompleteUpload.
+type Part struct {
+	Number int    // 1..MaxUploadParts
+	ETag   string // what the backend returned for the part
+	Size   int64
+}
+
+// MultipartUploader is implemented by backends that take an object in
+// parts, uploaded independently and possibly at once from several
+// goroutines or processes, and assembled into the object by
+// CompleteUpload. Until then the object does not exist; AbortUpload
+// discards the parts.
+//
+// Part numbers need not be consecutive; parts are joined in ascending
+// order. On S3 every part but the last must be at least MinS3PartSize
+// bytes, and a part that is not an io.ReadSeeker is buffered in memory.
+type MultipartUploader interface {
+	InitUpload(ctx context.Context, remotePath string) (uploadID string, err error)
+	UploadPart(ctx context.Context, remotePath, uploadID string, number int, r io.Reader) (Part, error)
+	CompleteUpload(ctx context.Context, remotePath, uploadID string, parts []Part) error
+	AbortUpload(ctx context.Context, remotePath, uploadID string) error
+}
+
+// Multipart returns st's multipart uploads: st itself when it implements
+// MultipartUploader (S3 does), and otherwise an emulation that stores each
+// part as an object under DefaultUploadDir and concatenates them with a
+// Put on CompleteUpload. The emulation works through every wrapper, so
+// parts are compressed and encrypted like any other object.
+func Multipart(st Storage) MultipartUploader {
+	if m, ok := st.(MultipartUploader); ok {
+		return m
+	}
+	return &partUploader{st: st}
+}
+
+// sortedParts checks the part numbers of a completed upload and returns
+// the parts in ascending order.
+func sortedParts(parts []Part) ([]Part, error) {
+	sorted := slices.Clone(parts)
+	slices.SortFunc(sorted, func(a, b Part) int { return a.Number - b.Number })
+	for i, p := range sorted {
+		if p.Number < 1 || p.Number > MaxUploadParts {
+			return nil, fmt.Errorf("part number %d out of range 1..%d", p.Number, MaxUploadParts)
+		}
+		if i > 0 && sorted[i-1].Number == p.Number {
+			return nil, fmt.Errorf("part %d given twice", p.Number)
+		}
+	}
+	return sorted, nil
+}
+
+// partUploader emulates multipart uploads with one object per part.
+type partUploader struct {
+	st Storage
+}
+
+func (u *partUploader) dir(uploadID string) (string, error) {
+	if uploadID == "" || strings.ContainsAny(uploadID, "/\\") || uploadID == "." || uploadID == ".." {
+		return "", fmt.Errorf("invalid upload id %q", uploadID)
+	}
+	return path.Join(DefaultUploadDir, uploadID), nil
+}
+
+// checkUpload returns the directory of an upload started for remotePath.
+func (u *partUploader) checkUpload(ctx context.Context, remotePath, uploadID string) (string, error) {
+	dir, err := u.dir(uploadID)
+	if err != nil {
+		return "", err
+	}
+	rc, err := u.st.Get(ctx, path.Join(dir, uploadTargetName))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("upload %s: %w", uploadID, fs.ErrNotExist)
+		}
+		return "", err
+	}
+	target, err := io.ReadAll(rc)
+	if closeErr := rc.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	if string(target) != remotePath {
+		return "", fmt.Errorf("upload %s was started for %q, not %q", uploadID, target, remotePath)
+	}
+	return dir, nil
+}
+
+func (u *partUploader) partPath(dir string, number int) string {
+	return path.Join(dir, fmt.Sprintf("%05d", number))
+}
+
+func (u *partUploader) InitUpload(ctx context.Context, remotePath string) (string, error) {
+	uploadID := randomSuffix()
+	dir, err := u.dir(uploadID)
+	if err != nil {
+		return "", err
+	}
+	if err := u.st.Put(ctx, path.Join(dir, uploadTargetName), strings.NewReader(remotePath)); err != nil {
+		return "", fmt.Errorf("init upload %q: %w", remotePath, err)
+	}
+	return uploadID, nil
+}
+
+func (u *partUploader) UploadPart(ctx context.Context, remotePath, uploadID string, number int, r io.Reader) (Part, error) {
+	if number < 1 || number > MaxUploadParts {
+		return Part{}, fmt.Errorf("part number %d out of range 1..%d", number, MaxUploadParts)
+	}
+	dir, err := u.checkUpload(ctx, remotePath, uploadID)
+	if err != nil {
+		return Part{}, err
+	}
+	var n int64
+	cr := &countingReader{r: r, add: func(k int64) { n += k }}
+	if err := u.st.Put(ctx, u.partPath(dir, number), cr); err != nil {
+		return Part{}, fmt.Errorf("upload part %d of %q: %w", number, remotePath, err)
+	}
+	return Part{Number: number, Size: n}, nil
+}
+
+func (u *partUploader) CompleteUpload(ctx context.Context, remotePath, uploadID string, parts []Part) error {
+	sorted, err := sortedParts(parts)
+	if err != nil {
+		return fmt.Errorf("complete upload %q: %w", remotePath, err)
+	}
+	dir, err := u.checkUpload(ctx, remotePath, uploadID)
+	if err != nil {
+		return err
+	}
+	paths := make([]string, len(sorted))
+	for i, p := range sorted {
+		paths[i] = u.partPath(dir, p.Number)
+	}
+	r := &concatReader{ctx: ctx, st: u.st, paths: paths}
+	err = u.st.Put(ctx, remotePath, r)
+	if closeErr := r.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("complete upload %q: %w", remotePath, err)
+	}
+	return u.st.DeleteDir(ctx, dir)
+}
+
+func (u *partUploader) AbortUpload(ctx context.Context, remotePath, uploadID string) error {
+	dir, err := u.checkUpload(ctx, remotePath, uploadID)
+	if err != nil {
+		return err
+	}
+	return u.st.DeleteDir(ctx, dir)
+}
+
+// concatReader reads the objects at paths one after the other, opening
+// each when the previous one is exhausted.
+type concatReader struct {
+	ctx   context.Context
+	st    Storage
+	paths []string
+	cur   io.ReadCloser
+}
+
+func (c *concatReader) Read(p []byte) (int, error) {
+	for {
+		if c.cur == nil {
+			if len(c.paths) == 0 {
+				return 0, io.EOF
+			}
+			rc, err := c.st.Get(c.ctx, c.paths[0])
+			if err != nil {
+				return 0, fmt.Errorf("read part %q: %w", c.paths[0], err)
+			}
+			c.cur, c.paths = rc, c.paths[1:]
+		}
+		n, err := c.cur.Read(p)
+		if errors.Is(err, io.EOF) {
+			err = c.cur.Close()
+			c.cur = nil
+			if err != nil {
+				return n, err
+			}
+			if n == 0 {
+				continue
+			}
+		}
+		return n, err
+	}
+}
+
+func (c *concatReader) Close() error {
+	if c.cur == nil {
+		return nil
+	}
+	err := c.cur.Close()
+	c.cur = nil
+	return err
+}
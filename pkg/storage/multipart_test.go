@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultipart_Emulated(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	mp := Multipart(mem)
+
+	id, err := mp.InitUpload(ctx, "backup/base.tar")
+	require.NoError(t, err)
+
+	// Shards produced in parallel, uploaded out of order.
+	parts := make([]Part, 5)
+	var wg sync.WaitGroup
+	for i := range parts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p, err := mp.UploadPart(ctx, "backup/base.tar", id, 5-i, strings.NewReader(fmt.Sprintf("shard-%d;", 5-i)))
+			assert.NoError(t, err)
+			parts[i] = p
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(len("shard-1;")), parts[4].Size)
+
+	ok, err := mem.Exists(ctx, "backup/base.tar")
+	require.NoError(t, err)
+	assert.False(t, ok, "the object appears on completion")
+
+	require.NoError(t, mp.CompleteUpload(ctx, "backup/base.tar", id, parts))
+	assert.Equal(t, "shard-1;shard-2;shard-3;shard-4;shard-5;", readAll(t, mem, "backup/base.tar"))
+	assert.Equal(t, []string{"backup/base.tar"}, keys(mem.Files), "parts are removed")
+
+	err = mp.CompleteUpload(ctx, "backup/base.tar", id, parts)
+	assert.ErrorIs(t, err, fs.ErrNotExist, "an upload completes once")
+}
+
+func TestMultipart_Abort(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	mp := Multipart(mem)
+
+	id, err := mp.InitUpload(ctx, "x")
+	require.NoError(t, err)
+	_, err = mp.UploadPart(ctx, "x", id, 1, strings.NewReader("data"))
+	require.NoError(t, err)
+
+	_, err = mp.UploadPart(ctx, "y", id, 2, strings.NewReader("data"))
+	assert.Error(t, err, "the upload belongs to another path")
+	_, err = mp.UploadPart(ctx, "x", id, 0, strings.NewReader("data"))
+	assert.Error(t, err)
+	_, err = mp.UploadPart(ctx, "x", "../x", 1, strings.NewReader("data"))
+	assert.Error(t, err)
+	assert.Error(t, mp.CompleteUpload(ctx, "x", id, []Part{{Number: 1}, {Number: 1}}))
+
+	require.NoError(t, mp.AbortUpload(ctx, "x", id))
+	assert.Empty(t, mem.Files)
+}
+
+func TestMultipart_ThroughWrappers(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	p := NewPrefixStorage(mem, "tenant")
+	mp := Multipart(p)
+
+	id, err := mp.InitUpload(ctx, "obj")
+	require.NoError(t, err)
+	p1, err := mp.UploadPart(ctx, "obj", id, 1, strings.NewReader("hello "))
+	require.NoError(t, err)
+	p2, err := mp.UploadPart(ctx, "obj", id, 2, strings.NewReader("world"))
+	require.NoError(t, err)
+	require.NoError(t, mp.CompleteUpload(ctx, "obj", id, []Part{p2, p1}))
+	assert.Equal(t, "hello world", readAll(t, p, "obj"))
+	assert.Equal(t, []string{"tenant/obj"}, keys(mem.Files))
+}
@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"context"
+	"io"
+)
+
+// NormalizingStorage applies one Unicode normalization form to every key,
+// so that "é" written as one code point or as "e" and a combining accent
+// names the same object. Backends disagree here: a macOS file system may
+// hand back names in NFD while S3 and most SFTP servers keep the bytes
+// they were given, and Exists or Rename then miss an object that List
+// shows.
+//
+// Normalize is the form, typically norm.NFC.String or norm.NFD.String from
+// golang.org/x/text/unicode/norm. It is applied to paths on the way in and
+// to listings on the way out. Objects stored before the wrapper was added
+// keep their old keys; rename them once to the normalized form.
+type NormalizingStorage struct {
+	Backend   Storage
+	Normalize func(string) string
+}
+
+var (
+	_ Storage     = &NormalizingStorage{}
+	_ Stater      = &NormalizingStorage{}
+	_ Walker      = &NormalizingStorage{}
+	_ RangeReader = &NormalizingStorage{}
+	_ Pinger      = &NormalizingStorage{}
+)
+
+// NewNormalizingStorage creates a NormalizingStorage.
+func NewNormalizingStorage(backend Storage, normalize func(string) string) *NormalizingStorage {
+	return &NormalizingStorage{Backend: backend, Normalize: normalize}
+}
+
+func (n *NormalizingStorage) normInfo(fi FileInfo) FileInfo {
+	fi.Path = n.Normalize(fi.Path)
+	if fi.StoredPath != "" {
+		fi.StoredPath = n.Normalize(fi.StoredPath)
+	}
+	return fi
+}
+
+func (n *NormalizingStorage) Put(ctx context.Context, remotePath string, r io.Reader) error {
+	return n.Backend.Put(ctx, n.Normalize(remotePath), r)
+}
+
+func (n *NormalizingStorage) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	return n.Backend.Get(ctx, n.Normalize(remotePath))
+}
+
+func (n *NormalizingStorage) GetRange(ctx context.Context, remotePath string, offset, length int64) (io.ReadCloser, error) {
+	return GetRange(ctx, n.Backend, n.Normalize(remotePath), offset, length)
+}
+
+func (n *NormalizingStorage) List(ctx context.Context, remotePath string) ([]string, error) {
+	names, err := n.Backend.List(ctx, n.Normalize(remotePath))
+	if err != nil {
+		return nil, err
+	}
+	for i := range names {
+		names[i] = n.Normalize(names[i])
+	}
+	return names, nil
+}
+
+// ListInfo filters names once they are normalized; the backend applies the
+// time and size filters.
+func (n *NormalizingStorage) ListInfo(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	infos, err := n.Backend.ListInfo(withoutEntryFilters(ctx), n.Normalize(remotePath))
+	if err != nil {
+		return nil, err
+	}
+	for i := range infos {
+		infos[i] = n.normInfo(infos[i])
+	}
+	return filterInfos(ctx, infos), nil
+}
+
+func (n *NormalizingStorage) WalkInfo(ctx context.Context, remotePath string, fn func(fi FileInfo) error) error {
+	opts := ListOptionsFromContext(ctx)
+	return WalkInfo(withoutEntryFilters(ctx), n.Backend, n.Normalize(remotePath), func(fi FileInfo) error {
+		if fi = n.normInfo(fi); !opts.Match(fi) {
+			return nil
+		}
+		return fn(fi)
+	})
+}
+
+func (n *NormalizingStorage) Delete(ctx context.Context, remotePath string) error {
+	return n.Backend.Delete(ctx, n.Normalize(remotePath))
+}
+
+func (n *NormalizingStorage) DeleteAll(ctx context.Context, remotePath string) error {
+	return n.Backend.DeleteAll(ctx, n.Normalize(remotePath))
+}
+
+func (n *NormalizingStorage) DeleteDir(ctx context.Context, remotePath string) error {
+	return n.Backend.DeleteDir(ctx, n.Normalize(remotePath))
+}
+
+func (n *NormalizingStorage) DeleteAllBulk(ctx context.Context, paths []string) error {
+	normalized := make([]string, len(paths))
+	for i, p := range paths {
+		normalized[i] = n.Normalize(p)
+	}
+	return n.Backend.DeleteAllBulk(ctx, normalized)
+}
+
+func (n *NormalizingStorage) Exists(ctx context.Context, remotePath string) (bool, error) {
+	return n.Backend.Exists(ctx, n.Normalize(remotePath))
+}
+
+func (n *NormalizingStorage) Stat(ctx context.Context, remotePath string) (FileInfo, error) {
+	fi, err := StatObject(ctx, n.Backend, n.Normalize(remotePath))
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return n.normInfo(fi), nil
+}
+
+func (n *NormalizingStorage) ListTopLevelDirs(ctx context.Context, prefix string) (map[string]bool, error) {
+	dirs, err := n.Backend.ListTopLevelDirs(ctx, n.Normalize(prefix))
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]bool, len(dirs))
+	for dir, ok := range dirs {
+		result[n.Normalize(dir)] = ok
+	}
+	return result, nil
+}
+
+func (n *NormalizingStorage) Rename(ctx context.Context, oldRemotePath, newRemotePath string) error {
+	return n.Backend.Rename(ctx, n.Normalize(oldRemotePath), n.Normalize(newRemotePath))
+}
+
+func (n *NormalizingStorage) Ping(ctx context.Context) error {
+	return Ping(ctx, n.Backend)
+}
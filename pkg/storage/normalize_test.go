@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// toNFC composes the only decomposed sequence the tests use, standing in
+// for norm.NFC.String.
+var toNFC = strings.NewReplacer("é", "é").Replace
+
+func TestNormalizingStorage(t *testing.T) {
+	ctx := context.Background()
+	const (
+		nfc = "docs/café.txt"
+		nfd = "docs/café.txt"
+	)
+
+	mem := NewInMemoryStorage()
+	// A file system that hands back decomposed names.
+	require.NoError(t, mem.Put(ctx, nfd, strings.NewReader("old")))
+	ns := NewNormalizingStorage(mem, toNFC)
+
+	names, err := ns.List(ctx, "docs")
+	require.NoError(t, err)
+	assert.Equal(t, []string{nfc}, names, "listings are normalized")
+
+	require.NoError(t, mem.Rename(ctx, nfd, nfc))
+	for _, name := range []string{nfc, nfd} {
+		ok, err := ns.Exists(ctx, name)
+		require.NoError(t, err)
+		assert.True(t, ok, "%+q", name)
+		assert.Equal(t, "old", readAll(t, ns, name))
+	}
+
+	require.NoError(t, ns.Put(ctx, nfd, strings.NewReader("new")))
+	assert.Equal(t, []string{nfc}, keys(mem.Files), "writes use the normalized key")
+
+	require.NoError(t, ns.Rename(ctx, nfd, "docs/résumé.txt"))
+	fi, err := ns.Stat(ctx, "docs/résumé.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "docs/résumé.txt", fi.Path)
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Opener opens the content of an upload, from the start, every time it is
+// called. Unlike the reader given to Put, it lets a failed upload start
+// over: RetryStorage reopens it for every attempt.
+type Opener func() (io.ReadCloser, error)
+
+// OpenFile returns an Opener that opens the named local file.
+func OpenFile(name string) Opener {
+	return func() (io.ReadCloser, error) {
+		return os.Open(name)
+	}
+}
+
+// OpenReaderAt returns an Opener reading the first size bytes of r. The
+// readers it opens implement io.ReaderAt and io.Seeker, so that S3 can
+// upload parts of them without buffering. r must allow concurrent reads,
+// as io.ReaderAt requires.
+func OpenReaderAt(r io.ReaderAt, size int64) Opener {
+	return func() (io.ReadCloser, error) {
+		return sectionReadCloser{io.NewSectionReader(r, 0, size)}, nil
+	}
+}
+
+// OpenSeeker returns an Opener that rewinds r to its current offset on
+// every call. The readers it opens share r, so only the last one opened
+// may be read from.
+func OpenSeeker(r io.ReadSeeker) (Opener, error) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	return func() (io.ReadCloser, error) {
+		if _, err := r.Seek(start, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("rewind source: %w", err)
+		}
+		return io.NopCloser(r), nil
+	}, nil
+}
+
+type sectionReadCloser struct {
+	*io.SectionReader
+}
+
+func (sectionReadCloser) Close() error { return nil }
+
+// OpenPutter is implemented by backends and wrappers that can make use of
+// a reopenable source, e.g. to retry an upload or to upload it in parts
+// without buffering them.
+type OpenPutter interface {
+	PutFrom(ctx context.Context, remotePath string, open Opener) error
+}
+
+// PutFrom stores the content open returns at remotePath. It uses
+// OpenPutter when st implements it, and otherwise opens the content once
+// and passes it to st.Put.
+func PutFrom(ctx context.Context, st Storage, remotePath string, open Opener) error {
+	if p, ok := st.(OpenPutter); ok {
+		return p.PutFrom(ctx, remotePath, open)
+	}
+	rc, err := open()
+	if err != nil {
+		return fmt.Errorf("open source for %q: %w", remotePath, err)
+	}
+	err = st.Put(ctx, remotePath, rc)
+	if closeErr := rc.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
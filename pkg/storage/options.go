@@ -0,0 +1,399 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progressInterval throttles progress callbacks; the final state is
+// always reported regardless.
+const progressInterval = 100 * time.Millisecond
+
+// Progress describes the state of a single transfer.
+type Progress struct {
+	Path        string
+	Bytes       int64 // bytes transferred so far
+	Total       int64 // expected total, -1 if unknown
+	Elapsed     time.Duration
+	BytesPerSec float64
+	Done        bool // set on the final report (EOF or error)
+}
+
+// ProgressFunc receives transfer progress. It is called from the goroutine
+// doing the transfer and must not block.
+type ProgressFunc func(Progress)
+
+// PutOptions are optional per-call parameters for Put.
+type PutOptions struct {
+	// Size is the expected object size in bytes (0 = unknown).
+	Size int64
+
+	// Progress, if set, receives upload progress.
+	Progress ProgressFunc
+}
+
+// PutOption configures PutOptions.
+type PutOption func(*PutOptions)
+
+// WithSizeHint tells the storage how many bytes the reader will yield.
+func WithSizeHint(size int64) PutOption {
+	return func(o *PutOptions) { o.Size = size }
+}
+
+// WithPutProgress reports upload progress to fn.
+func WithPutProgress(fn ProgressFunc) PutOption {
+	return func(o *PutOptions) { o.Progress = fn }
+}
+
+// GetOptions are optional per-call parameters for Get.
+type GetOptions struct {
+	// Progress, if set, receives download progress.
+	Progress ProgressFunc
+}
+
+// GetOption configures GetOptions.
+type GetOption func(*GetOptions)
+
+// WithGetProgress reports download progress to fn.
+func WithGetProgress(fn ProgressFunc) GetOption {
+	return func(o *GetOptions) { o.Progress = fn }
+}
+
+// ListOptions are optional per-call parameters for ListInfo and WalkInfo.
+type ListOptions struct {
+	// IncludeDirs adds an entry with IsDir set for every directory below
+	// the listed path: the directories of file system backends, empty ones
+	// included, and for object stores the directory placeholder objects
+	// ("dir/") and the directories implied by keys.
+	IncludeDirs bool
+
+	// Filters leave objects out of the listing. Backends apply them as
+	// they list, before an entry is built where they can: the local
+	// backend does not stat a name with the wrong suffix. WalkInfo and
+	// ListInfoWithOptions apply them on top of any Storage. Directory
+	// entries are never filtered.
+	ModifiedAfter  time.Time // if set, only objects modified after it
+	ModifiedBefore time.Time // if set, only objects modified before it
+	MinSize        int64     // only objects of at least MinSize bytes
+	MaxSize        int64     // if > 0, only objects of at most MaxSize bytes
+	Suffix         string    // if set, only paths ending in Suffix
+}
+
+// filtered reports whether o leaves any object out.
+func (o ListOptions) filtered() bool {
+	return !o.ModifiedAfter.IsZero() || !o.ModifiedBefore.IsZero() ||
+		o.MinSize > 0 || o.MaxSize > 0 || o.Suffix != ""
+}
+
+// Match reports whether fi passes the filters of o.
+func (o ListOptions) Match(fi FileInfo) bool {
+	switch {
+	case fi.IsDir:
+		return true
+	case !o.MatchName(fi.Path),
+		!o.ModifiedAfter.IsZero() && !fi.ModTime.After(o.ModifiedAfter),
+		!o.ModifiedBefore.IsZero() && !fi.ModTime.Before(o.ModifiedBefore),
+		fi.Size < o.MinSize,
+		o.MaxSize > 0 && fi.Size > o.MaxSize:
+		return false
+	}
+	return true
+}
+
+// MatchName reports whether an object named p can pass the filters of o,
+// for backends that learn names before sizes and times.
+func (o ListOptions) MatchName(p string) bool {
+	return strings.HasSuffix(p, o.Suffix)
+}
+
+// ListOption configures ListOptions.
+type ListOption func(*ListOptions)
+
+// WithDirs includes directory entries in a listing.
+func WithDirs() ListOption {
+	return func(o *ListOptions) { o.IncludeDirs = true }
+}
+
+// WithModifiedAfter lists only objects modified after t.
+func WithModifiedAfter(t time.Time) ListOption {
+	return func(o *ListOptions) { o.ModifiedAfter = t }
+}
+
+// WithModifiedBefore lists only objects modified before t, e.g. the
+// candidates of a retention scan.
+func WithModifiedBefore(t time.Time) ListOption {
+	return func(o *ListOptions) { o.ModifiedBefore = t }
+}
+
+// WithSizeBetween lists only objects of min to max bytes; a max of zero
+// means no upper bound.
+func WithSizeBetween(minSize, maxSize int64) ListOption {
+	return func(o *ListOptions) { o.MinSize, o.MaxSize = minSize, maxSize }
+}
+
+// WithSuffix lists only objects whose path ends in suffix.
+func WithSuffix(suffix string) ListOption {
+	return func(o *ListOptions) { o.Suffix = suffix }
+}
+
+type putOptionsKey struct{}
+
+type getOptionsKey struct{}
+
+type listOptionsKey struct{}
+
+// ContextWithPutOptions attaches Put options to ctx. Storage wrappers and
+// backends pick them up from the context, so the Storage interface itself
+// stays unchanged.
+func ContextWithPutOptions(ctx context.Context, opts ...PutOption) context.Context {
+	o := PutOptionsFromContext(ctx)
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return context.WithValue(ctx, putOptionsKey{}, o)
+}
+
+// PutOptionsFromContext returns the Put options attached to ctx.
+func PutOptionsFromContext(ctx context.Context) PutOptions {
+	o, _ := ctx.Value(putOptionsKey{}).(PutOptions)
+	return o
+}
+
+// ContextWithGetOptions attaches Get options to ctx.
+func ContextWithGetOptions(ctx context.Context, opts ...GetOption) context.Context {
+	o := GetOptionsFromContext(ctx)
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return context.WithValue(ctx, getOptionsKey{}, o)
+}
+
+// GetOptionsFromContext returns the Get options attached to ctx.
+func GetOptionsFromContext(ctx context.Context) GetOptions {
+	o, _ := ctx.Value(getOptionsKey{}).(GetOptions)
+	return o
+}
+
+// ContextWithListOptions attaches ListInfo and WalkInfo options to ctx.
+func ContextWithListOptions(ctx context.Context, opts ...ListOption) context.Context {
+	o := ListOptionsFromContext(ctx)
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return context.WithValue(ctx, listOptionsKey{}, o)
+}
+
+// ListOptionsFromContext returns the ListInfo and WalkInfo options attached
+// to ctx.
+func ListOptionsFromContext(ctx context.Context) ListOptions {
+	o, _ := ctx.Value(listOptionsKey{}).(ListOptions)
+	return o
+}
+
+// PutWithOptions calls st.Put with the given options attached.
+func PutWithOptions(ctx context.Context, st Storage, remotePath string, r io.Reader, opts ...PutOption) error {
+	return st.Put(ContextWithPutOptions(ctx, opts...), remotePath, r)
+}
+
+// GetWithOptions calls st.Get with the given options attached.
+func GetWithOptions(ctx context.Context, st Storage, remotePath string, opts ...GetOption) (io.ReadCloser, error) {
+	return st.Get(ContextWithGetOptions(ctx, opts...), remotePath)
+}
+
+// ListInfoWithOptions calls st.ListInfo with the given options attached.
+// The filters hold whether or not st applies them.
+func ListInfoWithOptions(ctx context.Context, st Storage, remotePath string, opts ...ListOption) ([]FileInfo, error) {
+	ctx = ContextWithListOptions(ctx, opts...)
+	infos, err := st.ListInfo(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	return filterInfos(ctx, infos), nil
+}
+
+// filterInfos drops the entries that do not pass the filters attached to
+// ctx, in place.
+func filterInfos(ctx context.Context, infos []FileInfo) []FileInfo {
+	o := ListOptionsFromContext(ctx)
+	if !o.filtered() {
+		return infos
+	}
+	result := infos[:0]
+	for _, fi := range infos {
+		if o.Match(fi) {
+			result = append(result, fi)
+		}
+	}
+	return result
+}
+
+// withoutEntryFilters keeps only the time filters attached to ctx. Used by
+// layers that rename or resize entries: they pass the rest down no
+// further and apply it to the entries they return.
+func withoutEntryFilters(ctx context.Context) context.Context {
+	o := ListOptionsFromContext(ctx)
+	if o.MinSize == 0 && o.MaxSize == 0 && o.Suffix == "" {
+		return ctx
+	}
+	o.MinSize, o.MaxSize, o.Suffix = 0, 0, ""
+	return context.WithValue(ctx, listOptionsKey{}, o)
+}
+
+// withoutListFilters drops every list filter attached to ctx, for layers
+// that must see all entries before they can tell which ones they return.
+func withoutListFilters(ctx context.Context) context.Context {
+	o := ListOptionsFromContext(ctx)
+	if !o.filtered() {
+		return ctx
+	}
+	return context.WithValue(ctx, listOptionsKey{}, ListOptions{IncludeDirs: o.IncludeDirs})
+}
+
+// trackPutProgress wraps r if a progress callback is attached to ctx and
+// returns a context without the callback, so that the layers below do not
+// report the same transfer again.
+func trackPutProgress(ctx context.Context, remotePath string, r io.Reader) (context.Context, io.Reader) {
+	o := PutOptionsFromContext(ctx)
+	if o.Progress == nil {
+		return ctx, r
+	}
+	total := o.Size
+	if total <= 0 {
+		total = readerSize(r)
+	}
+	pr := newProgressReader(r, nil, remotePath, total, o.Progress)
+	o.Progress = nil
+	return context.WithValue(ctx, putOptionsKey{}, o), pr
+}
+
+// trackGetProgress wraps rc if a progress callback is attached to ctx.
+func trackGetProgress(ctx context.Context, remotePath string, rc io.ReadCloser, total int64) io.ReadCloser {
+	o := GetOptionsFromContext(ctx)
+	if o.Progress == nil {
+		return rc
+	}
+	return newProgressReader(rc, rc, remotePath, total, o.Progress)
+}
+
+// withoutGetProgress strips the Get progress callback from ctx. Used by
+// transforming layers that report progress on the decoded stream.
+func withoutGetProgress(ctx context.Context) context.Context {
+	o := GetOptionsFromContext(ctx)
+	if o.Progress == nil {
+		return ctx
+	}
+	o.Progress = nil
+	return context.WithValue(ctx, getOptionsKey{}, o)
+}
+
+// withoutPutSizeHint strips the size hint from ctx. Used by transforming
+// layers, since the stored size differs from the logical one.
+func withoutPutSizeHint(ctx context.Context) context.Context {
+	o := PutOptionsFromContext(ctx)
+	if o.Size == 0 {
+		return ctx
+	}
+	o.Size = 0
+	return context.WithValue(ctx, putOptionsKey{}, o)
+}
+
+// readerSize returns the number of bytes left in r if that is cheaply
+// known, -1 otherwise.
+func readerSize(r io.Reader) int64 {
+	switch v := r.(type) {
+	case interface{ Len() int }:
+		return int64(v.Len())
+	case *io.SectionReader:
+		return v.Size()
+	case *os.File:
+		st, err := v.Stat()
+		if err != nil || !st.Mode().IsRegular() {
+			return -1
+		}
+		off, err := v.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		return st.Size() - off
+	default:
+		return -1
+	}
+}
+
+// progressReader reports bytes flowing through it.
+type progressReader struct {
+	r      io.Reader
+	c      io.Closer
+	fn     ProgressFunc
+	path   string
+	total  int64
+	start  time.Time
+	mu     sync.Mutex
+	n      int64
+	last   time.Time
+	closed bool
+	done   bool
+}
+
+func newProgressReader(r io.Reader, c io.Closer, p string, total int64, fn ProgressFunc) *progressReader {
+	if total <= 0 {
+		total = -1
+	}
+	now := time.Now()
+	return &progressReader{r: r, c: c, fn: fn, path: p, total: total, start: now, last: now}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.n += int64(n)
+	now := time.Now()
+	switch {
+	case err != nil:
+		p.report(now, true)
+	case now.Sub(p.last) >= progressInterval:
+		p.report(now, false)
+	}
+	return n, err
+}
+
+func (p *progressReader) Close() error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		p.report(time.Now(), true)
+	}
+	p.mu.Unlock()
+	if p.c == nil {
+		return nil
+	}
+	return p.c.Close()
+}
+
+// report must be called with p.mu held.
+func (p *progressReader) report(now time.Time, final bool) {
+	if p.done {
+		return
+	}
+	p.done = final
+	p.last = now
+	elapsed := now.Sub(p.start)
+	var bps float64
+	if secs := elapsed.Seconds(); secs > 0 {
+		bps = float64(p.n) / secs
+	}
+	p.fn(Progress{
+		Path:        p.path,
+		Bytes:       p.n,
+		Total:       p.total,
+		Elapsed:     elapsed,
+		BytesPerSec: bps,
+		Done:        final,
+	})
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/aesgcm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgress_ReportedOnPlaintextThroughTransformingStorage(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	ts := &TransformingStorage{
+		Backend:      mem,
+		Crypter:      aesgcm.NewChunkedGCMCrypter("password"),
+		Compressor:   codec.GzipCompressor{},
+		Decompressor: codec.GzipDecompressor{},
+	}
+	payload := strings.Repeat("wal-segment ", 4096)
+
+	var puts []Progress
+	err := PutWithOptions(ctx, ts, "wal/0001", bytes.NewReader([]byte(payload)),
+		WithPutProgress(func(p Progress) { puts = append(puts, p) }))
+	require.NoError(t, err)
+	require.NotEmpty(t, puts)
+	last := puts[len(puts)-1]
+	assert.True(t, last.Done)
+	assert.Equal(t, int64(len(payload)), last.Bytes)
+	assert.Equal(t, int64(len(payload)), last.Total)
+	assert.Equal(t, "wal/0001", last.Path)
+
+	var gets []Progress
+	rc, err := GetWithOptions(ctx, ts, "wal/0001",
+		WithGetProgress(func(p Progress) { gets = append(gets, p) }))
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, payload, string(data))
+
+	// Reported once, by the transforming layer, on the decoded stream.
+	require.Len(t, gets, 1)
+	assert.Equal(t, int64(len(payload)), gets[0].Bytes)
+	assert.True(t, gets[0].Done)
+}
+
+func TestLocal_PutSizeHintPreallocates(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	st, err := NewLocal(&LocalStorageOpts{BaseDir: dir})
+	require.NoError(t, err)
+
+	// A hint larger than the stream leaves no tail behind.
+	require.NoError(t, PutWithOptions(ctx, st, "wal/short", strings.NewReader("hello"), WithSizeHint(1<<20)))
+	fi, err := os.Stat(filepath.Join(dir, "wal/short"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), fi.Size())
+
+	// A hint smaller than the stream does not cut it.
+	require.NoError(t, PutWithOptions(ctx, st, "wal/long", strings.NewReader("hello world"), WithSizeHint(3)))
+	data, err := os.ReadFile(filepath.Join(dir, "wal/long"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+}
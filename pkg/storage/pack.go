@@ -0,0 +1,631 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPackDir is where PackStorage keeps packfiles and their indexes.
+	DefaultPackDir = ".packs"
+
+	DefaultPackThreshold int64 = 64 * 1024
+	DefaultPackMaxBytes  int64 = 8 * 1024 * 1024
+	DefaultPackMaxCount        = 1024
+)
+
+// PackOptions configure PackStorage.
+type PackOptions struct {
+	// Threshold is the largest object that gets batched; bigger objects are
+	// written directly (default 64 KiB).
+	Threshold int64
+
+	// MaxPackBytes triggers a flush when pending data reaches it (default 8 MiB).
+	MaxPackBytes int64
+
+	// MaxPackCount triggers a flush when this many objects are pending
+	// (default 1024).
+	MaxPackCount int
+}
+
+// packEntry locates an object inside a packfile. Deleted entries are
+// tombstones recorded by Delete/Rename.
+type packEntry struct {
+	Path    string    `json:"path"`
+	Pack    string    `json:"pack,omitempty"`
+	Offset  int64     `json:"offset"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Deleted bool      `json:"deleted,omitempty"`
+}
+
+type packIndex struct {
+	Entries []packEntry `json:"entries"`
+}
+
+type pendingObject struct {
+	data    []byte
+	modTime time.Time
+	seq     uint64
+}
+
+// PackStorage coalesces many tiny Puts into packfiles: small objects are
+// buffered in memory and written as one <dir>/<id>.pack plus an index
+// object <dir>/<id>.idx describing where each object lives inside it.
+// Objects above the threshold are written through unchanged.
+//
+// Buffered objects are visible to Get/List/Exists immediately but are only
+// durable after Flush (called automatically when the pending batch is full,
+// periodically via Start, and by Close).
+type PackStorage struct {
+	Backend Storage
+	opts    PackOptions
+	dir     string
+
+	mu           sync.Mutex
+	pending      map[string]*pendingObject
+	pendingBytes int64
+	seq          uint64
+	index        map[string]packEntry // nil until loaded
+	flushMu      sync.Mutex
+
+	loopMu sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+var _ Storage = (*PackStorage)(nil)
+
+// NewPackStorage creates a PackStorage.
+func NewPackStorage(backend Storage, opts PackOptions) *PackStorage {
+	if opts.Threshold <= 0 {
+		opts.Threshold = DefaultPackThreshold
+	}
+	if opts.MaxPackBytes <= 0 {
+		opts.MaxPackBytes = DefaultPackMaxBytes
+	}
+	if opts.MaxPackCount <= 0 {
+		opts.MaxPackCount = DefaultPackMaxCount
+	}
+	return &PackStorage{
+		Backend: backend,
+		opts:    opts,
+		dir:     DefaultPackDir,
+		pending: make(map[string]*pendingObject),
+	}
+}
+
+func (ps *PackStorage) isInternal(p string) bool {
+	return hasPathPrefix(p, ps.dir)
+}
+
+func (ps *PackStorage) newObjectID() string {
+	return time.Now().UTC().Format(trashTimeFormat) + "-" + randomSuffix()
+}
+
+// loadIndex replays all index objects (in name order, i.e. oldest first)
+// into the in-memory index.
+func (ps *PackStorage) loadIndex(ctx context.Context) error {
+	ps.mu.Lock()
+	loaded := ps.index != nil
+	ps.mu.Unlock()
+	if loaded {
+		return nil
+	}
+
+	files, err := ps.Backend.List(ctx, ps.dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	var idxFiles []string
+	for _, f := range files {
+		if strings.HasSuffix(f, ".idx") {
+			idxFiles = append(idxFiles, f)
+		}
+	}
+	sort.Strings(idxFiles)
+
+	index := make(map[string]packEntry)
+	for _, f := range idxFiles {
+		idx, err := ps.readIndex(ctx, f)
+		if err != nil {
+			return err
+		}
+		applyPackEntries(index, idx.Entries)
+	}
+
+	ps.mu.Lock()
+	if ps.index == nil {
+		ps.index = index
+	}
+	ps.mu.Unlock()
+	return nil
+}
+
+func applyPackEntries(index map[string]packEntry, entries []packEntry) {
+	for _, e := range entries {
+		if e.Deleted {
+			delete(index, e.Path)
+			continue
+		}
+		index[e.Path] = e
+	}
+}
+
+func (ps *PackStorage) readIndex(ctx context.Context, p string) (*packIndex, error) {
+	rc, err := ps.Backend.Get(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var idx packIndex
+	if err := json.NewDecoder(rc).Decode(&idx); err != nil {
+		return nil, fmt.Errorf("decode pack index %q: %w", p, err)
+	}
+	return &idx, nil
+}
+
+// writeIndex persists entries as a new index object and applies them to
+// the in-memory index.
+func (ps *PackStorage) writeIndex(ctx context.Context, id string, entries []packEntry) error {
+	if err := ps.putIndex(ctx, id, entries); err != nil {
+		return err
+	}
+	ps.mu.Lock()
+	applyPackEntries(ps.index, entries)
+	ps.mu.Unlock()
+	return nil
+}
+
+// putIndex persists entries as a new index object.
+func (ps *PackStorage) putIndex(ctx context.Context, id string, entries []packEntry) error {
+	data, err := json.Marshal(&packIndex{Entries: entries})
+	if err != nil {
+		return err
+	}
+	return ps.Backend.Put(ctx, path.Join(ps.dir, id+".idx"), bytes.NewReader(data))
+}
+
+// tombstone records deletion of packed objects.
+func (ps *PackStorage) tombstone(ctx context.Context, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	entries := make([]packEntry, 0, len(paths))
+	for _, p := range paths {
+		entries = append(entries, packEntry{Path: p, Deleted: true})
+	}
+	return ps.writeIndex(ctx, ps.newObjectID(), entries)
+}
+
+// Flush writes all pending objects as one packfile plus its index.
+func (ps *PackStorage) Flush(ctx context.Context) error {
+	if err := ps.loadIndex(ctx); err != nil {
+		return err
+	}
+
+	ps.flushMu.Lock()
+	defer ps.flushMu.Unlock()
+
+	ps.mu.Lock()
+	if len(ps.pending) == 0 {
+		ps.mu.Unlock()
+		return nil
+	}
+	names := make([]string, 0, len(ps.pending))
+	batch := make(map[string]*pendingObject, len(ps.pending))
+	for name, obj := range ps.pending {
+		names = append(names, name)
+		batch[name] = obj
+	}
+	ps.mu.Unlock()
+	sort.Strings(names)
+
+	id := ps.newObjectID()
+	packPath := path.Join(ps.dir, id+".pack")
+
+	var buf bytes.Buffer
+	entries := make([]packEntry, 0, len(names))
+	for _, name := range names {
+		obj := batch[name]
+		entries = append(entries, packEntry{
+			Path:    name,
+			Pack:    packPath,
+			Offset:  int64(buf.Len()),
+			Size:    int64(len(obj.data)),
+			ModTime: obj.modTime,
+		})
+		buf.Write(obj.data)
+	}
+
+	if err := ps.Backend.Put(ctx, packPath, bytes.NewReader(buf.Bytes())); err != nil {
+		return fmt.Errorf("write packfile: %w", err)
+	}
+
+	// Objects deleted, renamed or overwritten while the packfile was
+	// written must not come back through its index, so the index only
+	// covers what is still pending unchanged, and mu is held until it is
+	// written and the objects leave the pending set.
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	committed := entries[:0]
+	for _, e := range entries {
+		if cur, ok := ps.pending[e.Path]; ok && cur.seq == batch[e.Path].seq {
+			committed = append(committed, e)
+		}
+	}
+	if len(committed) == 0 {
+		_ = ps.Backend.Delete(ctx, packPath)
+		return nil
+	}
+	if err := ps.putIndex(ctx, id, committed); err != nil {
+		return fmt.Errorf("write pack index: %w", err)
+	}
+	applyPackEntries(ps.index, committed)
+	for _, e := range committed {
+		delete(ps.pending, e.Path)
+		ps.pendingBytes -= e.Size
+	}
+	return nil
+}
+
+// Close flushes pending objects and stops the periodic flush.
+func (ps *PackStorage) Close(ctx context.Context) error {
+	ps.Stop()
+	return ps.Flush(ctx)
+}
+
+// Start flushes pending objects every interval until Stop is called or ctx
+// is canceled. Errors are reported to onError, which may be nil.
+func (ps *PackStorage) Start(ctx context.Context, interval time.Duration, onError func(error)) {
+	ps.loopMu.Lock()
+	defer ps.loopMu.Unlock()
+	if ps.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	ps.cancel = cancel
+	ps.done = done
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := ps.Flush(ctx); err != nil && onError != nil && ctx.Err() == nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop terminates the periodic flush and waits for it to exit. Pending
+// objects are not flushed; use Close for that.
+func (ps *PackStorage) Stop() {
+	ps.loopMu.Lock()
+	cancel, done := ps.cancel, ps.done
+	ps.cancel, ps.done = nil, nil
+	ps.loopMu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// forget removes path from the pending set and the packed index (with a
+// tombstone) and reports whether it was found in either.
+func (ps *PackStorage) forget(ctx context.Context, paths []string) (bool, error) {
+	found := false
+	var packed []string
+
+	ps.mu.Lock()
+	for _, p := range paths {
+		if obj, ok := ps.pending[p]; ok {
+			delete(ps.pending, p)
+			ps.pendingBytes -= int64(len(obj.data))
+			found = true
+		}
+		if _, ok := ps.index[p]; ok {
+			packed = append(packed, p)
+		}
+	}
+	ps.mu.Unlock()
+
+	if len(packed) > 0 {
+		found = true
+	}
+	return found, ps.tombstone(ctx, packed)
+}
+
+func (ps *PackStorage) matching(prefix string) []string {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	var result []string
+	for p := range ps.pending {
+		if hasPathPrefix(p, prefix) {
+			result = append(result, p)
+		}
+	}
+	for p := range ps.index {
+		if _, ok := ps.pending[p]; !ok && hasPathPrefix(p, prefix) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// Put buffers small objects and writes large ones directly.
+func (ps *PackStorage) Put(ctx context.Context, remotePath string, r io.Reader) error {
+	if err := ps.loadIndex(ctx); err != nil {
+		return err
+	}
+
+	head, err := io.ReadAll(io.LimitReader(r, ps.opts.Threshold+1))
+	if err != nil {
+		return err
+	}
+
+	if int64(len(head)) > ps.opts.Threshold {
+		if _, err := ps.forget(ctx, []string{remotePath}); err != nil {
+			return err
+		}
+		return ps.Backend.Put(ctx, remotePath, io.MultiReader(bytes.NewReader(head), r))
+	}
+
+	// A direct object with the same name would shadow the packed one.
+	exists, err := ps.Backend.Exists(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if err := ps.Backend.Delete(ctx, remotePath); err != nil {
+			return err
+		}
+	}
+
+	ps.mu.Lock()
+	if old, ok := ps.pending[remotePath]; ok {
+		ps.pendingBytes -= int64(len(old.data))
+	}
+	ps.seq++
+	ps.pending[remotePath] = &pendingObject{data: head, modTime: time.Now().UTC(), seq: ps.seq}
+	ps.pendingBytes += int64(len(head))
+	full := ps.pendingBytes >= ps.opts.MaxPackBytes || len(ps.pending) >= ps.opts.MaxPackCount
+	ps.mu.Unlock()
+
+	if full {
+		return ps.Flush(ctx)
+	}
+	return nil
+}
+
+func (ps *PackStorage) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	if err := ps.loadIndex(ctx); err != nil {
+		return nil, err
+	}
+
+	ps.mu.Lock()
+	obj, isPending := ps.pending[remotePath]
+	entry, isPacked := ps.index[remotePath]
+	ps.mu.Unlock()
+
+	if isPending {
+		return io.NopCloser(bytes.NewReader(obj.data)), nil
+	}
+	if !isPacked {
+		return ps.Backend.Get(ctx, remotePath)
+	}
+
+	rc, err := ps.Backend.Get(ctx, entry.Pack)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, rc, entry.Offset); err != nil {
+		_ = rc.Close()
+		return nil, fmt.Errorf("seek %q in %q: %w", remotePath, entry.Pack, err)
+	}
+	return &readCloser{Reader: io.LimitReader(rc, entry.Size), Closer: rc}, nil
+}
+
+// readCloser combines a derived reader with the closer of its source.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func (ps *PackStorage) List(ctx context.Context, remotePath string) ([]string, error) {
+	infos, err := ps.ListInfo(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(infos))
+	for _, fi := range infos {
+		files = append(files, fi.Path)
+	}
+	return files, nil
+}
+
+func (ps *PackStorage) ListInfo(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	if err := ps.loadIndex(ctx); err != nil {
+		return nil, err
+	}
+
+	direct, err := ps.Backend.ListInfo(ctx, remotePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var result []FileInfo
+
+	ps.mu.Lock()
+	for p, obj := range ps.pending {
+		if hasPathPrefix(p, remotePath) {
+			seen[p] = true
+			result = append(result, FileInfo{Path: p, ModTime: obj.modTime, Size: int64(len(obj.data))})
+		}
+	}
+	for p, e := range ps.index {
+		if !seen[p] && hasPathPrefix(p, remotePath) {
+			seen[p] = true
+			result = append(result, FileInfo{Path: p, ModTime: e.ModTime, Size: e.Size})
+		}
+	}
+	ps.mu.Unlock()
+
+	for _, fi := range direct {
+		if !seen[fi.Path] && !ps.isInternal(fi.Path) {
+			result = append(result, fi)
+		}
+	}
+	return filterInfos(ctx, result), nil
+}
+
+func (ps *PackStorage) Delete(ctx context.Context, remotePath string) error {
+	if err := ps.loadIndex(ctx); err != nil {
+		return err
+	}
+	found, err := ps.forget(ctx, []string{remotePath})
+	if err != nil {
+		return err
+	}
+	if found {
+		return nil
+	}
+	return ps.Backend.Delete(ctx, remotePath)
+}
+
+func (ps *PackStorage) DeleteAll(ctx context.Context, remotePath string) error {
+	if err := ps.loadIndex(ctx); err != nil {
+		return err
+	}
+	if _, err := ps.forget(ctx, ps.matching(remotePath)); err != nil {
+		return err
+	}
+	return ps.Backend.DeleteAll(ctx, remotePath)
+}
+
+func (ps *PackStorage) DeleteDir(ctx context.Context, remotePath string) error {
+	if err := ps.loadIndex(ctx); err != nil {
+		return err
+	}
+	if _, err := ps.forget(ctx, ps.matching(remotePath)); err != nil {
+		return err
+	}
+	return ps.Backend.DeleteDir(ctx, remotePath)
+}
+
+func (ps *PackStorage) DeleteAllBulk(ctx context.Context, paths []string) error {
+	if err := ps.loadIndex(ctx); err != nil {
+		return err
+	}
+	var packed []string
+	for _, p := range paths {
+		packed = append(packed, ps.matching(p)...)
+	}
+	if _, err := ps.forget(ctx, packed); err != nil {
+		return err
+	}
+	return ps.Backend.DeleteAllBulk(ctx, paths)
+}
+
+func (ps *PackStorage) Exists(ctx context.Context, remotePath string) (bool, error) {
+	if err := ps.loadIndex(ctx); err != nil {
+		return false, err
+	}
+	ps.mu.Lock()
+	_, isPending := ps.pending[remotePath]
+	_, isPacked := ps.index[remotePath]
+	ps.mu.Unlock()
+	if isPending || isPacked {
+		return true, nil
+	}
+	return ps.Backend.Exists(ctx, remotePath)
+}
+
+func (ps *PackStorage) ListTopLevelDirs(ctx context.Context, prefix string) (map[string]bool, error) {
+	dirs, err := ps.Backend.ListTopLevelDirs(ctx, prefix)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if dirs == nil {
+		dirs = make(map[string]bool)
+	}
+	for d := range dirs {
+		if ps.isInternal(d) {
+			delete(dirs, d)
+		}
+	}
+
+	prefix = strings.Trim(prefix, "/")
+	for _, p := range ps.matching(prefix) {
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, prefix), "/")
+		if idx := strings.Index(rel, "/"); idx > 0 {
+			dirs[path.Join(prefix, rel[:idx])] = true
+		}
+	}
+	return dirs, nil
+}
+
+// Rename of a packed object only rewrites the index; the bytes stay in
+// their packfile.
+func (ps *PackStorage) Rename(ctx context.Context, oldRemotePath, newRemotePath string) error {
+	if oldRemotePath == newRemotePath {
+		return nil
+	}
+	if err := ps.loadIndex(ctx); err != nil {
+		return err
+	}
+
+	ps.mu.Lock()
+	obj, isPending := ps.pending[oldRemotePath]
+	entry, isPacked := ps.index[oldRemotePath]
+	if isPending {
+		delete(ps.pending, oldRemotePath)
+		if old, ok := ps.pending[newRemotePath]; ok {
+			ps.pendingBytes -= int64(len(old.data))
+		}
+		ps.pending[newRemotePath] = obj
+	}
+	ps.mu.Unlock()
+
+	if isPending {
+		if isPacked {
+			return ps.tombstone(ctx, []string{oldRemotePath})
+		}
+		return nil
+	}
+	if !isPacked {
+		if err := ps.Backend.Rename(ctx, oldRemotePath, newRemotePath); err != nil {
+			return err
+		}
+		// A pending or packed object would shadow the one renamed over it.
+		_, err := ps.forget(ctx, []string{newRemotePath})
+		return err
+	}
+
+	moved := entry
+	moved.Path = newRemotePath
+	return ps.writeIndex(ctx, ps.newObjectID(), []packEntry{
+		moved,
+		{Path: oldRemotePath, Deleted: true},
+	})
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readString(t *testing.T, st Storage, p string) string {
+	t.Helper()
+	rc, err := st.Get(context.Background(), p)
+	require.NoError(t, err)
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestPackStorage_BatchesSmallObjects(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	ps := NewPackStorage(mem, PackOptions{Threshold: 8, MaxPackCount: 100})
+
+	require.NoError(t, ps.Put(ctx, "m/a", bytes.NewReader([]byte("aaa"))))
+	require.NoError(t, ps.Put(ctx, "m/b", bytes.NewReader([]byte("bbbb"))))
+	require.NoError(t, ps.Put(ctx, "m/big", bytes.NewReader([]byte(strings.Repeat("x", 20)))))
+
+	// Only the big object hit the backend so far.
+	assert.Len(t, mem.Files, 1)
+	assert.Equal(t, "aaa", readString(t, ps, "m/a"))
+
+	require.NoError(t, ps.Flush(ctx))
+	assert.Len(t, mem.Files, 3, "big object + one pack + one index")
+
+	// A fresh instance reads the packed objects from the index.
+	ps2 := NewPackStorage(mem, PackOptions{Threshold: 8})
+	assert.Equal(t, "aaa", readString(t, ps2, "m/a"))
+	assert.Equal(t, "bbbb", readString(t, ps2, "m/b"))
+	assert.Equal(t, strings.Repeat("x", 20), readString(t, ps2, "m/big"))
+
+	files, err := ps2.List(ctx, "m")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"m/a", "m/b", "m/big"}, files)
+
+	require.NoError(t, ps2.Rename(ctx, "m/a", "m/c"))
+	require.NoError(t, ps2.Delete(ctx, "m/b"))
+
+	ps3 := NewPackStorage(mem, PackOptions{Threshold: 8})
+	files, err = ps3.List(ctx, "m")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"m/c", "m/big"}, files)
+	assert.Equal(t, "aaa", readString(t, ps3, "m/c"))
+
+	ok, err := ps3.Exists(ctx, "m/b")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestPackStorage_AutoFlush(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	ps := NewPackStorage(mem, PackOptions{Threshold: 8, MaxPackCount: 2})
+
+	require.NoError(t, ps.Put(ctx, "a", bytes.NewReader([]byte("1"))))
+	assert.Empty(t, mem.Files)
+	require.NoError(t, ps.Put(ctx, "b", bytes.NewReader([]byte("2"))))
+	assert.Len(t, mem.Files, 2)
+}
+
+// blockingPackStorage signals on started and waits for release on the
+// first packfile Put, pausing a Flush before it writes the index.
+type blockingPackStorage struct {
+	Storage
+	once     sync.Once
+	started  chan struct{}
+	released chan struct{}
+}
+
+func (s *blockingPackStorage) Put(ctx context.Context, remotePath string, r io.Reader) error {
+	if strings.HasSuffix(remotePath, ".pack") {
+		s.once.Do(func() {
+			close(s.started)
+			<-s.released
+		})
+	}
+	return s.Storage.Put(ctx, remotePath, r)
+}
+
+func TestPackStorage_ChangesDuringFlush(t *testing.T) {
+	ctx := context.Background()
+	big := strings.Repeat("x", 20)
+
+	for name, change := range map[string]func(ps *PackStorage) error{
+		"Delete": func(ps *PackStorage) error {
+			return ps.Delete(ctx, "m/a")
+		},
+		"Rename": func(ps *PackStorage) error {
+			return ps.Rename(ctx, "m/a", "m/c")
+		},
+		"PutDirect": func(ps *PackStorage) error {
+			return ps.Put(ctx, "m/a", bytes.NewReader([]byte(big)))
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mem := NewInMemoryStorage()
+			backend := &blockingPackStorage{Storage: mem, started: make(chan struct{}), released: make(chan struct{})}
+			ps := NewPackStorage(backend, PackOptions{Threshold: 8, MaxPackCount: 100})
+			require.NoError(t, ps.Put(ctx, "m/a", bytes.NewReader([]byte("aaa"))))
+			require.NoError(t, ps.Put(ctx, "m/b", bytes.NewReader([]byte("bbb"))))
+
+			flushed := make(chan error, 1)
+			go func() { flushed <- ps.Flush(ctx) }()
+			<-backend.started
+			require.NoError(t, change(ps))
+			close(backend.released)
+			require.NoError(t, <-flushed)
+			require.NoError(t, ps.Flush(ctx))
+
+			for _, st := range []Storage{ps, NewPackStorage(mem, PackOptions{Threshold: 8})} {
+				switch name {
+				case "Delete":
+					ok, err := st.Exists(ctx, "m/a")
+					require.NoError(t, err)
+					assert.False(t, ok)
+				case "Rename":
+					ok, err := st.Exists(ctx, "m/a")
+					require.NoError(t, err)
+					assert.False(t, ok)
+					assert.Equal(t, "aaa", readString(t, st, "m/c"))
+				case "PutDirect":
+					assert.Equal(t, big, readString(t, st, "m/a"))
+				}
+				assert.Equal(t, "bbb", readString(t, st, "m/b"))
+			}
+		})
+	}
+}
+
+func TestPackStorage_RenameDirectOverPacked(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	ps := NewPackStorage(mem, PackOptions{Threshold: 8, MaxPackCount: 100})
+	big := strings.Repeat("x", 20)
+
+	require.NoError(t, ps.Put(ctx, "m/packed", bytes.NewReader([]byte("old"))))
+	require.NoError(t, ps.Flush(ctx))
+	require.NoError(t, ps.Put(ctx, "m/pending", bytes.NewReader([]byte("old"))))
+	require.NoError(t, ps.Put(ctx, "m/big1", bytes.NewReader([]byte(big))))
+	require.NoError(t, ps.Put(ctx, "m/big2", bytes.NewReader([]byte(big))))
+
+	require.NoError(t, ps.Rename(ctx, "m/big1", "m/packed"))
+	require.NoError(t, ps.Rename(ctx, "m/big2", "m/pending"))
+	require.NoError(t, ps.Flush(ctx))
+
+	for _, st := range []Storage{ps, NewPackStorage(mem, PackOptions{Threshold: 8})} {
+		assert.Equal(t, big, readString(t, st, "m/packed"))
+		assert.Equal(t, big, readString(t, st, "m/pending"))
+		files, err := st.List(ctx, "m")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"m/packed", "m/pending"}, files)
+	}
+}
@@ -0,0 +1,287 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+)
+
+const (
+	// DefaultParallelPartSize is the range size ParallelGetStorage fetches
+	// per request when none is configured.
+	DefaultParallelPartSize int64 = 16 << 20
+
+	// DefaultParallelConcurrency is the number of ranges ParallelGetStorage
+	// keeps in flight when none is configured.
+	DefaultParallelConcurrency = 4
+)
+
+// ParallelGetStorage downloads large objects in several concurrent ranged
+// reads and reassembles them in order behind a plain io.ReadCloser, so a
+// single Get is not limited to one connection.
+//
+// It needs a backend implementing RangeReader; otherwise, and for objects
+// no larger than one part, Get is passed through. At most Concurrency parts
+// are fetched or buffered at any time, which bounds the memory used per
+// reader to Concurrency*PartSize.
+//
+// The parts are separate requests, so the object is pinned by a Stat
+// before the download and another one once the last part was read: if
+// its size or modification time differ, the read fails with
+// ErrObjectChanged instead of returning parts of two versions. A rewrite
+// that keeps both, within the backend's modification time resolution,
+// goes unnoticed.
+type ParallelGetStorage struct {
+	Backend     Storage
+	PartSize    int64
+	Concurrency int
+}
+
+// ErrObjectChanged is returned by a ParallelGetStorage reader when the
+// object was modified while it was being downloaded.
+var ErrObjectChanged = errors.New("storage: object changed during read")
+
+var (
+	_ Storage     = &ParallelGetStorage{}
+	_ Stater      = &ParallelGetStorage{}
+	_ Walker      = &ParallelGetStorage{}
+	_ RangeReader = &ParallelGetStorage{}
+	_ Pinger      = &ParallelGetStorage{}
+)
+
+// NewParallelGetStorage creates a ParallelGetStorage. Non-positive values
+// select DefaultParallelPartSize and DefaultParallelConcurrency.
+func NewParallelGetStorage(backend Storage, partSize int64, concurrency int) *ParallelGetStorage {
+	if partSize <= 0 {
+		partSize = DefaultParallelPartSize
+	}
+	if concurrency <= 0 {
+		concurrency = DefaultParallelConcurrency
+	}
+	return &ParallelGetStorage{Backend: backend, PartSize: partSize, Concurrency: concurrency}
+}
+
+func (p *ParallelGetStorage) Put(ctx context.Context, remotePath string, r io.Reader) error {
+	return p.Backend.Put(ctx, remotePath, r)
+}
+
+func (p *ParallelGetStorage) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	rr, ok := p.Backend.(RangeReader)
+	if !ok || p.Concurrency < 2 || p.PartSize <= 0 {
+		return p.Backend.Get(ctx, remotePath)
+	}
+	fi, err := StatObject(ctx, p.Backend, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size <= p.PartSize {
+		return p.Backend.Get(ctx, remotePath)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	pr := &parallelReader{
+		ctx:     ctx,
+		backend: p.Backend,
+		path:    remotePath,
+		info:    fi,
+		size:    fi.Size,
+		cancel:  cancel,
+		order:   make(chan chan rangePart, p.Concurrency),
+		slots:   make(chan struct{}, p.Concurrency),
+	}
+	pr.wg.Add(1)
+	go pr.fetchAll(ctx, rr, remotePath, p.PartSize)
+	return pr, nil
+}
+
+func (p *ParallelGetStorage) GetRange(ctx context.Context, remotePath string, offset, length int64) (io.ReadCloser, error) {
+	return GetRange(ctx, p.Backend, remotePath, offset, length)
+}
+
+func (p *ParallelGetStorage) List(ctx context.Context, remotePath string) ([]string, error) {
+	return p.Backend.List(ctx, remotePath)
+}
+
+func (p *ParallelGetStorage) ListInfo(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	return p.Backend.ListInfo(ctx, remotePath)
+}
+
+func (p *ParallelGetStorage) WalkInfo(ctx context.Context, remotePath string, fn func(fi FileInfo) error) error {
+	return WalkInfo(ctx, p.Backend, remotePath, fn)
+}
+
+func (p *ParallelGetStorage) Delete(ctx context.Context, remotePath string) error {
+	return p.Backend.Delete(ctx, remotePath)
+}
+
+func (p *ParallelGetStorage) DeleteAll(ctx context.Context, remotePath string) error {
+	return p.Backend.DeleteAll(ctx, remotePath)
+}
+
+func (p *ParallelGetStorage) DeleteDir(ctx context.Context, remotePath string) error {
+	return p.Backend.DeleteDir(ctx, remotePath)
+}
+
+func (p *ParallelGetStorage) DeleteAllBulk(ctx context.Context, paths []string) error {
+	return p.Backend.DeleteAllBulk(ctx, paths)
+}
+
+func (p *ParallelGetStorage) Exists(ctx context.Context, remotePath string) (bool, error) {
+	return p.Backend.Exists(ctx, remotePath)
+}
+
+func (p *ParallelGetStorage) Stat(ctx context.Context, remotePath string) (FileInfo, error) {
+	return StatObject(ctx, p.Backend, remotePath)
+}
+
+func (p *ParallelGetStorage) ListTopLevelDirs(ctx context.Context, prefix string) (map[string]bool, error) {
+	return p.Backend.ListTopLevelDirs(ctx, prefix)
+}
+
+func (p *ParallelGetStorage) Rename(ctx context.Context, oldRemotePath, newRemotePath string) error {
+	return p.Backend.Rename(ctx, oldRemotePath, newRemotePath)
+}
+
+func (p *ParallelGetStorage) Ping(ctx context.Context) error {
+	return Ping(ctx, p.Backend)
+}
+
+// rangePart is the outcome of fetching one range.
+type rangePart struct {
+	data []byte
+	err  error
+}
+
+// parallelReader hands out the parts in order. Every part holds a slot
+// from the moment its fetch starts until it was read completely, so
+// len(slots) parts exist at most.
+type parallelReader struct {
+	ctx     context.Context
+	backend Storage
+	path    string
+	info    FileInfo // as stated before the download
+	size    int64
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+
+	// order receives one channel per part, in offset order; it is closed
+	// once all parts were started or the context is done.
+	order chan chan rangePart
+	slots chan struct{}
+
+	cur     []byte
+	holding bool // cur's slot is not released yet
+	read    int64
+	err     error
+}
+
+func (r *parallelReader) fetchAll(ctx context.Context, rr RangeReader, remotePath string, partSize int64) {
+	defer r.wg.Done()
+	defer close(r.order)
+	for off := int64(0); off < r.size; off += partSize {
+		select {
+		case r.slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		length := min(partSize, r.size-off)
+		res := make(chan rangePart, 1)
+		r.order <- res
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			res <- fetchRange(ctx, rr, remotePath, off, length)
+		}()
+	}
+}
+
+func fetchRange(ctx context.Context, rr RangeReader, remotePath string, offset, length int64) rangePart {
+	rc, err := rr.GetRange(ctx, remotePath, offset, length)
+	if err != nil {
+		return rangePart{err: err}
+	}
+	defer rc.Close()
+	data := make([]byte, length)
+	if _, err := io.ReadFull(rc, data); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return rangePart{err: fmt.Errorf("get range %q at %d: %w", remotePath, offset, err)}
+	}
+	return rangePart{data: data}
+}
+
+func (r *parallelReader) Read(p []byte) (int, error) {
+	for len(r.cur) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.release()
+		res, ok := <-r.order
+		if !ok {
+			r.err = io.EOF
+			if r.read < r.size {
+				// canceled before all parts were started
+				r.err = r.ctx.Err()
+			} else if err := r.checkUnchanged(); err != nil {
+				r.err = err
+			}
+			continue
+		}
+		part := <-res
+		if part.err != nil {
+			r.err = part.err
+			r.cancel()
+			continue
+		}
+		r.cur, r.holding = part.data, true
+	}
+	n := copy(p, r.cur)
+	r.cur = r.cur[n:]
+	r.read += int64(n)
+	return n, nil
+}
+
+// checkUnchanged stats the object again after the last part and fails
+// if it is no longer the version the download started with.
+func (r *parallelReader) checkUnchanged() error {
+	fi, err := StatObject(r.ctx, r.backend, r.path)
+	if errors.Is(err, ErrNotExist) {
+		return fmt.Errorf("%w: %q was deleted", ErrObjectChanged, r.path)
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Size != r.info.Size || !fi.ModTime.Equal(r.info.ModTime) {
+		return fmt.Errorf("%w: %q", ErrObjectChanged, r.path)
+	}
+	return nil
+}
+
+func (r *parallelReader) release() {
+	if r.holding {
+		r.holding = false
+		<-r.slots
+	}
+}
+
+// Close stops the outstanding fetches and waits for them to return.
+func (r *parallelReader) Close() error {
+	r.cancel()
+	r.release()
+	// Free the slots of parts nobody will read, so that fetchAll is not
+	// stuck waiting for one.
+	for res := range r.order {
+		<-res
+		<-r.slots
+	}
+	r.wg.Wait()
+	r.cur = nil
+	if r.err == nil {
+		r.err = fs.ErrClosed
+	}
+	return nil
+}
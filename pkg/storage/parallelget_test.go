@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rangeCounter counts the ranged reads in flight and can fail one offset.
+type rangeCounter struct {
+	*InMemoryStorage
+	calls, inFlight, peak atomic.Int32
+	failAt                int64
+	err                   error
+	onRange               func(offset int64)
+}
+
+func (r *rangeCounter) GetRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	r.calls.Add(1)
+	n := r.inFlight.Add(1)
+	defer r.inFlight.Add(-1)
+	for {
+		old := r.peak.Load()
+		if n <= old || r.peak.CompareAndSwap(old, n) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond)
+	if r.onRange != nil {
+		r.onRange(offset)
+	}
+	if r.err != nil && offset == r.failAt {
+		return nil, r.err
+	}
+	return r.InMemoryStorage.GetRange(ctx, path, offset, length)
+}
+
+func newRangeCounter(t *testing.T, size int) (*rangeCounter, []byte) {
+	t.Helper()
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	mem := NewInMemoryStorage()
+	require.NoError(t, mem.Put(context.Background(), "base/0001", bytes.NewReader(data)))
+	return &rangeCounter{InMemoryStorage: mem, failAt: -1}, data
+}
+
+func TestParallelGet_Reassembles(t *testing.T) {
+	backend, data := newRangeCounter(t, 1000)
+	pg := NewParallelGetStorage(backend, 64, 3)
+
+	rc, err := pg.Get(context.Background(), "base/0001")
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+
+	assert.Equal(t, data, got)
+	assert.Equal(t, int32(16), backend.calls.Load())
+	assert.LessOrEqual(t, backend.peak.Load(), int32(3))
+}
+
+func TestParallelGet_SmallObjectPassesThrough(t *testing.T) {
+	backend, data := newRangeCounter(t, 64)
+	pg := NewParallelGetStorage(backend, 64, 3)
+
+	rc, err := pg.Get(context.Background(), "base/0001")
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+
+	assert.Equal(t, data, got)
+	assert.Zero(t, backend.calls.Load())
+}
+
+func TestParallelGet_RangeError(t *testing.T) {
+	backend, data := newRangeCounter(t, 1000)
+	boom := errors.New("connection reset")
+	backend.failAt, backend.err = 512, boom
+	pg := NewParallelGetStorage(backend, 64, 4)
+
+	rc, err := pg.Get(context.Background(), "base/0001")
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.ErrorIs(t, err, boom)
+	assert.Equal(t, data[:512], got, "parts before the failed one are delivered")
+	require.NoError(t, rc.Close())
+}
+
+func TestParallelGet_CloseEarly(t *testing.T) {
+	backend, data := newRangeCounter(t, 1000)
+	pg := NewParallelGetStorage(backend, 16, 4)
+
+	rc, err := pg.Get(context.Background(), "base/0001")
+	require.NoError(t, err)
+	buf := make([]byte, 100)
+	_, err = io.ReadFull(rc, buf)
+	require.NoError(t, err)
+	assert.Equal(t, data[:100], buf)
+	require.NoError(t, rc.Close())
+	assert.Zero(t, backend.inFlight.Load(), "Close waits for the fetches")
+
+	_, err = rc.Read(buf)
+	assert.Error(t, err)
+}
+
+func TestParallelGet_Missing(t *testing.T) {
+	backend, _ := newRangeCounter(t, 10)
+	_, err := NewParallelGetStorage(backend, 4, 2).Get(context.Background(), "base/missing")
+	assert.Error(t, err)
+}
+
+func TestParallelGet_ObjectChanged(t *testing.T) {
+	for name, change := range map[string]func(mem *InMemoryStorage) error{
+		"Overwritten": func(mem *InMemoryStorage) error {
+			if err := mem.Put(context.Background(), "base/0001", bytes.NewReader(make([]byte, 1000))); err != nil {
+				return err
+			}
+			return mem.SetModTime("base/0001", time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+		},
+		"Grown": func(mem *InMemoryStorage) error {
+			if err := mem.Put(context.Background(), "base/0001", bytes.NewReader(make([]byte, 1001))); err != nil {
+				return err
+			}
+			return mem.SetModTime("base/0001", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		},
+		"Deleted": func(mem *InMemoryStorage) error {
+			return mem.Delete(context.Background(), "base/0001")
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			backend, _ := newRangeCounter(t, 1000)
+			require.NoError(t, backend.SetModTime("base/0001", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+			var once sync.Once
+			backend.onRange = func(offset int64) {
+				if offset == 960 {
+					once.Do(func() { require.NoError(t, change(backend.InMemoryStorage)) })
+				}
+			}
+			pg := NewParallelGetStorage(backend, 64, 1<<10)
+
+			rc, err := pg.Get(context.Background(), "base/0001")
+			require.NoError(t, err)
+			_, err = io.ReadAll(rc)
+			require.NoError(t, rc.Close())
+			if name == "Deleted" && !errors.Is(err, ErrObjectChanged) {
+				// The last range itself may already miss the object.
+				require.ErrorIs(t, err, ErrNotExist)
+				return
+			}
+			require.ErrorIs(t, err, ErrObjectChanged)
+		})
+	}
+}
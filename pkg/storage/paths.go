@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// MaxS3KeyLength is the longest object key S3 accepts, in bytes,
+	// prefix included.
+	MaxS3KeyLength = 1024
+
+	// MaxNameLength is the longest path segment the local and SFTP
+	// backends accept, in bytes: NAME_MAX of common file systems.
+	MaxNameLength = 255
+)
+
+// ErrInvalidPath is matched by the errors of calls given a remote path the
+// backend refuses.
+var ErrInvalidPath = errors.New("storage: invalid path")
+
+// InvalidPathError reports a refused remote path and why.
+type InvalidPathError struct {
+	Path   string
+	Reason string
+}
+
+func (e *InvalidPathError) Error() string {
+	return fmt.Sprintf("storage: invalid path %q: %s", e.Path, e.Reason)
+}
+
+func (e *InvalidPathError) Is(target error) bool {
+	return target == ErrInvalidPath
+}
+
+// CleanPath checks a remote path and returns it in canonical form: relative,
+// slash separated and without a trailing slash. "" and "/" name the root
+// and clean to "".
+//
+// Backslashes, NUL bytes, empty segments ("a//b") and "." or ".." segments
+// are refused rather than resolved, so that a path cannot escape the base
+// directory of a backend and names the same object on every backend.
+func CleanPath(p string) (string, error) {
+	clean := strings.TrimPrefix(p, "/")
+	clean = strings.TrimSuffix(clean, "/")
+	if clean == "" {
+		return "", nil
+	}
+	if strings.ContainsRune(clean, '\\') {
+		return "", &InvalidPathError{Path: p, Reason: "backslash"}
+	}
+	if strings.ContainsRune(clean, 0) {
+		return "", &InvalidPathError{Path: p, Reason: "NUL byte"}
+	}
+	for _, seg := range strings.Split(clean, "/") {
+		switch seg {
+		case "":
+			return "", &InvalidPathError{Path: p, Reason: "empty segment"}
+		case ".", "..":
+			return "", &InvalidPathError{Path: p, Reason: fmt.Sprintf("%q segment", seg)}
+		}
+	}
+	return clean, nil
+}
+
+// checkNameLength refuses a clean path with a segment longer than
+// MaxNameLength.
+func checkNameLength(clean string) error {
+	for _, seg := range strings.Split(clean, "/") {
+		if len(seg) > MaxNameLength {
+			return &InvalidPathError{
+				Path:   clean,
+				Reason: fmt.Sprintf("segment of %d bytes exceeds %d", len(seg), MaxNameLength),
+			}
+		}
+	}
+	return nil
+}
+
+// relKey returns key relative to base. Both are slash separated remote
+// paths, so this uses string operations rather than filepath.Rel, which
+// would treat "c:" as a volume on Windows. ok is false when key is not
+// below base.
+func relKey(base, key string) (rel string, ok bool) {
+	if base == "" {
+		return key, key != ""
+	}
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	rel, ok = strings.CutPrefix(key, base)
+	return rel, ok && rel != ""
+}
+
+// dirPrefix is the key prefix of everything below the directory p:
+// listings have directory semantics, so "wal" does not match "wal2/x",
+// and the empty path, the storage root, matches every key.
+func dirPrefix(p string) string {
+	if p == "" || strings.HasSuffix(p, "/") {
+		return p
+	}
+	return p + "/"
+}
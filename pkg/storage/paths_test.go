@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanPath(t *testing.T) {
+	valid := map[string]string{
+		"":              "",
+		"/":             "",
+		"wal/0001":      "wal/0001",
+		"/wal/0001":     "wal/0001",
+		"wal/":          "wal",
+		".hidden/a..b":  ".hidden/a..b",
+		"with space/ñ":  "with space/ñ",
+		"a/b/c/d/e.txt": "a/b/c/d/e.txt",
+	}
+	for in, want := range valid {
+		got, err := CleanPath(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+
+	for _, in := range []string{
+		"..",
+		"../etc/passwd",
+		"wal/../../etc",
+		"./wal",
+		"wal/.",
+		"wal//0001",
+		"//wal",
+		`wal\0001`,
+		"wal/\x00",
+	} {
+		_, err := CleanPath(in)
+		assert.ErrorIs(t, err, ErrInvalidPath, in)
+		var pe *InvalidPathError
+		if assert.ErrorAs(t, err, &pe, in) {
+			assert.Equal(t, in, pe.Path)
+		}
+	}
+}
+
+func TestLocal_RefusesEscapes(t *testing.T) {
+	ctx := context.Background()
+	parent := t.TempDir()
+	st, err := NewLocal(&LocalStorageOpts{BaseDir: filepath.Join(parent, "base")})
+	require.NoError(t, err)
+
+	err = st.Put(ctx, "../outside", strings.NewReader("x"))
+	assert.ErrorIs(t, err, ErrInvalidPath)
+	_, err = os.Stat(filepath.Join(parent, "outside"))
+	assert.True(t, os.IsNotExist(err), "nothing written outside the base directory")
+
+	long := strings.Repeat("a", MaxNameLength+1)
+	assert.ErrorIs(t, st.Put(ctx, "wal/"+long, strings.NewReader("x")), ErrInvalidPath)
+	require.NoError(t, st.Put(ctx, "wal/"+long[1:], strings.NewReader("x")))
+}
+
+func TestPrefix_RefusesEscapes(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	p := NewPrefixStorage(mem, "tenant-a")
+
+	err := p.Put(ctx, "../tenant-b/x", strings.NewReader("x"))
+	assert.ErrorIs(t, err, ErrInvalidPath)
+	assert.Empty(t, mem.Files)
+
+	require.NoError(t, p.Put(ctx, "/x", strings.NewReader("x")))
+	assert.Equal(t, []string{"tenant-a/x"}, keys(mem.Files))
+}
+
+func TestRelKey(t *testing.T) {
+	for _, tt := range []struct {
+		base, key, rel string
+		ok             bool
+	}{
+		{"", "wal/0001", "wal/0001", true},
+		{"pg", "pg/wal/0001", "wal/0001", true},
+		{"pg/", "pg/wal/0001", "wal/0001", true},
+		{"/backups", "/backups/c:/wal", "c:/wal", true},
+		{"/", "/wal/0001", "wal/0001", true},
+		{"pg", "pg2/wal/0001", "", false},
+		{"pg", "pg", "", false},
+		{"pg", "pg/", "", false},
+	} {
+		rel, ok := relKey(tt.base, tt.key)
+		assert.Equal(t, tt.ok, ok, "%q in %q", tt.key, tt.base)
+		if tt.ok {
+			assert.Equal(t, tt.rel, rel, "%q in %q", tt.key, tt.base)
+		}
+	}
+}
+
+func TestLocal_KeysAreSlashSeparated(t *testing.T) {
+	ctx := context.Background()
+	st, err := NewLocal(&LocalStorageOpts{BaseDir: t.TempDir() + string(filepath.Separator)})
+	require.NoError(t, err)
+	require.NoError(t, st.Put(ctx, "pg/wal/0001", strings.NewReader("x")))
+	require.NoError(t, st.Put(ctx, "pg/base/label", strings.NewReader("x")))
+
+	names, err := st.List(ctx, "pg")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"pg/wal/0001", "pg/base/label"}, names)
+
+	infos, err := st.ListInfo(ctx, "pg/wal")
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "pg/wal/0001", infos[0].Path)
+
+	dirs, err := st.ListTopLevelDirs(ctx, "pg")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"pg/wal": true, "pg/base": true}, dirs)
+}
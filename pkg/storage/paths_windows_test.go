@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocal_WindowsBaseDir(t *testing.T) {
+	ctx := context.Background()
+	// Forward slashes and a trailing separator in the configured directory
+	// must not leak into keys.
+	base := strings.ReplaceAll(t.TempDir(), `\`, "/") + "/"
+	st, err := NewLocal(&LocalStorageOpts{BaseDir: base})
+	require.NoError(t, err)
+	require.NoError(t, st.Put(ctx, "pg/wal/0001", strings.NewReader("x")))
+
+	names, err := st.List(ctx, "pg")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"pg/wal/0001"}, names)
+
+	dirs, err := st.ListTopLevelDirs(ctx, "pg")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"pg/wal": true}, dirs)
+
+	_, err = st.Get(ctx, `pg\wal\0001`)
+	assert.ErrorIs(t, err, ErrInvalidPath, "backslashes are not separators in keys")
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// pingPath is the object Ping looks up on storages that are not Pingers.
+const pingPath = ".storecrypt-ping"
+
+// Pinger is implemented by backends (and wrappers) that can verify they
+// reach their storage, e.g. for readiness probes.
+type Pinger interface {
+	// Ping makes a cheap round trip to the storage (HeadBucket on S3, a
+	// stat on SFTP, statfs on local disks) and returns its error.
+	Ping(ctx context.Context) error
+}
+
+// Ping checks that st reaches its storage, using Pinger when st
+// implements it and falling back to an Exists lookup.
+func Ping(ctx context.Context, st Storage) error {
+	if p, ok := st.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	if _, err := st.Exists(ctx, pingPath); err != nil {
+		return fmt.Errorf("ping: %w", err)
+	}
+	return nil
+}
+
+// Wrappers ping what they wrap.
+
+var (
+	_ Pinger = (*VariadicStorage)(nil)
+	_ Pinger = (*TransformingStorage)(nil)
+	_ Pinger = (*BandwidthStorage)(nil)
+	_ Pinger = (*PackStorage)(nil)
+	_ Pinger = (*SizeLimitStorage)(nil)
+	_ Pinger = (*SnapshotStorage)(nil)
+	_ Pinger = (*TieringStorage)(nil)
+	_ Pinger = (*TrashStorage)(nil)
+	_ Pinger = (*TTLStorage)(nil)
+)
+
+func (vs *VariadicStorage) Ping(ctx context.Context) error {
+	return Ping(ctx, vs.Backend)
+}
+
+func (ts *TransformingStorage) Ping(ctx context.Context) error {
+	return Ping(ctx, ts.Backend)
+}
+
+func (b *BandwidthStorage) Ping(ctx context.Context) error {
+	return Ping(ctx, b.Backend)
+}
+
+func (ps *PackStorage) Ping(ctx context.Context) error {
+	return Ping(ctx, ps.Backend)
+}
+
+func (s *SizeLimitStorage) Ping(ctx context.Context) error {
+	return Ping(ctx, s.Backend)
+}
+
+func (ss *SnapshotStorage) Ping(ctx context.Context) error {
+	return Ping(ctx, ss.Backend)
+}
+
+// Ping pings both tiers.
+func (t *TieringStorage) Ping(ctx context.Context) error {
+	var errs []error
+	if err := Ping(ctx, t.Hot); err != nil {
+		errs = append(errs, fmt.Errorf("hot tier: %w", err))
+	}
+	if err := Ping(ctx, t.Cold); err != nil {
+		errs = append(errs, fmt.Errorf("cold tier: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+func (ts *TrashStorage) Ping(ctx context.Context) error {
+	return Ping(ctx, ts.Backend)
+}
+
+func (t *TTLStorage) Ping(ctx context.Context) error {
+	return Ping(ctx, t.Backend)
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// errExistsStorage fails Exists, the fallback of Ping.
+type errExistsStorage struct {
+	Storage
+	err error
+}
+
+func (s errExistsStorage) Exists(context.Context, string) (bool, error) {
+	return false, s.err
+}
+
+func TestPing_Local(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "archive")
+	st, err := NewLocal(&LocalStorageOpts{BaseDir: dir})
+	require.NoError(t, err)
+	require.NoError(t, Ping(ctx, st))
+
+	vs, err := NewVariadicStorage(st, Algorithms{}, "")
+	require.NoError(t, err)
+	require.NoError(t, Ping(ctx, vs))
+
+	require.NoError(t, os.Remove(dir))
+	assert.ErrorIs(t, Ping(ctx, st), os.ErrNotExist)
+	assert.ErrorIs(t, Ping(ctx, vs), os.ErrNotExist)
+}
+
+func TestPing_Fallback(t *testing.T) {
+	ctx := context.Background()
+	// Embedding the interface hides InMemoryStorage's Ping.
+	require.NoError(t, Ping(ctx, struct{ Storage }{NewInMemoryStorage()}))
+
+	down := errors.New("connection refused")
+	st := errExistsStorage{Storage: NewInMemoryStorage(), err: down}
+	assert.ErrorIs(t, Ping(ctx, st), down)
+
+	tiers := &TieringStorage{Hot: NewInMemoryStorage(), Cold: st}
+	err := Ping(ctx, tiers)
+	assert.ErrorIs(t, err, down)
+	assert.ErrorContains(t, err, "cold tier")
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.ErrorIs(t, Ping(canceled, NewInMemoryStorage()), context.Canceled)
+}
@@ -0,0 +1,574 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultPostgresTable is the table NewPostgresStorage keeps objects in
+	// when none is configured.
+	DefaultPostgresTable = "storecrypt_objects"
+
+	// DefaultPostgresChunkSize is the size of the pieces large objects are
+	// written and read in when none is configured.
+	DefaultPostgresChunkSize = 1024 * 1024
+)
+
+// PostgresOptions configures NewPostgresStorage.
+type PostgresOptions struct {
+	// Table holds the objects, DefaultPostgresTable if empty. It may be
+	// schema-qualified ("backups.objects").
+	Table string
+
+	// CreateTable creates the table if it does not exist.
+	CreateTable bool
+
+	// LargeObjects stores the data of new objects in large objects,
+	// written and read in ChunkSize pieces, instead of a bytea column,
+	// which Put and Get hold in memory whole and which is limited to
+	// 1 GB. Objects stored either way are read either way.
+	LargeObjects bool
+	ChunkSize    int
+}
+
+type postgresStorage struct {
+	db           *sql.DB
+	table        string
+	largeObjects bool
+	chunkSize    int
+}
+
+var (
+	_ Storage           = &postgresStorage{}
+	_ Stater            = &postgresStorage{}
+	_ Walker            = &postgresStorage{}
+	_ RangeReader       = &postgresStorage{}
+	_ Pinger            = &postgresStorage{}
+	_ DeleteAllReporter = &postgresStorage{}
+)
+
+var sqlTableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// NewPostgresStorage stores objects in a PostgreSQL table with one row per
+// object (path, data, size, mtime). db is opened by the caller with a
+// PostgreSQL driver, e.g. pgx's stdlib or lib/pq. Put and Rename are
+// transactional: a failed Put leaves the previous object in place, and
+// readers see either object, never part of one. Of two Puts of the same
+// path at once, one may fail.
+func NewPostgresStorage(ctx context.Context, db *sql.DB, opts PostgresOptions) (Storage, error) {
+	table := opts.Table
+	if table == "" {
+		table = DefaultPostgresTable
+	}
+	if !sqlTableName.MatchString(table) {
+		return nil, fmt.Errorf("postgres: invalid table name %q", table)
+	}
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultPostgresChunkSize
+	}
+	p := &postgresStorage{
+		db:           db,
+		table:        table,
+		largeObjects: opts.LargeObjects,
+		chunkSize:    chunkSize,
+	}
+	if opts.CreateTable {
+		_, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	path  text PRIMARY KEY,
+	data  bytea,
+	lo    oid,
+	size  bigint NOT NULL,
+	mtime timestamptz NOT NULL
+)`, table))
+		if err != nil {
+			return nil, fmt.Errorf("postgres: create table %s: %w", table, postgresError(err))
+		}
+	}
+	return p, nil
+}
+
+// sqlQuerier is what *sql.DB and *sql.Tx have in common.
+type sqlQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (p *postgresStorage) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return postgresError(err)
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return postgresError(tx.Commit())
+}
+
+// objectKey cleans the path of an object; the root is not one.
+func objectKey(remotePath string) (string, error) {
+	key, err := CleanPath(remotePath)
+	if err != nil {
+		return "", err
+	}
+	if key == "" {
+		return "", &InvalidPathError{Path: remotePath, Reason: "names the root"}
+	}
+	return key, nil
+}
+
+func (p *postgresStorage) Put(ctx context.Context, remotePath string, r io.Reader) error {
+	key, err := objectKey(remotePath)
+	if err != nil {
+		return err
+	}
+	_, r = trackPutProgress(ctx, key, r)
+
+	if !p.largeObjects {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("read source for %q: %w", key, err)
+		}
+		return p.inTx(ctx, func(tx *sql.Tx) error {
+			return p.replace(ctx, tx, key, data, sql.NullInt64{}, int64(len(data)))
+		})
+	}
+
+	// A large object created in a transaction that fails is gone with it.
+	return p.inTx(ctx, func(tx *sql.Tx) error {
+		var lo int64
+		if err := tx.QueryRowContext(ctx, "SELECT lo_create(0)").Scan(&lo); err != nil {
+			return fmt.Errorf("create large object for %q: %w", key, postgresError(err))
+		}
+		buf := make([]byte, p.chunkSize)
+		var size int64
+		for {
+			n, readErr := io.ReadFull(r, buf)
+			if n > 0 {
+				_, err := tx.ExecContext(ctx, "SELECT lo_put($1::oid, $2, $3)", lo, size, buf[:n])
+				if err != nil {
+					return fmt.Errorf("write %q: %w", key, postgresError(err))
+				}
+				size += int64(n)
+			}
+			if errors.Is(readErr, io.EOF) || errors.Is(readErr, io.ErrUnexpectedEOF) {
+				break
+			}
+			if readErr != nil {
+				return fmt.Errorf("read source for %q: %w", key, readErr)
+			}
+		}
+		return p.replace(ctx, tx, key, nil, sql.NullInt64{Int64: lo, Valid: true}, size)
+	})
+}
+
+// replace stores the row of key, removing the large object of the row it
+// replaces.
+func (p *postgresStorage) replace(ctx context.Context, tx *sql.Tx, key string, data []byte, lo sql.NullInt64, size int64) error {
+	if _, err := p.deleteWhere(ctx, tx, "path = $1", key); err != nil {
+		return fmt.Errorf("replace %q: %w", key, err)
+	}
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(
+		"INSERT INTO %s (path, data, lo, size, mtime) VALUES ($1, $2, $3, $4, now())", p.table),
+		key, data, lo, size)
+	if err != nil {
+		return fmt.Errorf("put %q: %w", key, postgresError(err))
+	}
+	return nil
+}
+
+// deleteWhere deletes the rows matching cond with their large objects and
+// returns how many there were.
+func (p *postgresStorage) deleteWhere(ctx context.Context, q sqlQuerier, cond string, args ...any) (int, error) {
+	var n int
+	err := q.QueryRowContext(ctx, fmt.Sprintf(
+		"WITH d AS (DELETE FROM %s WHERE %s RETURNING lo) SELECT count(*), count(lo_unlink(lo)) FROM d",
+		p.table, cond), args...).Scan(&n, new(int))
+	return n, postgresError(err)
+}
+
+func (p *postgresStorage) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	key, err := objectKey(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		data []byte
+		lo   sql.NullInt64
+		size int64
+	)
+	err = p.db.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT data, lo, size FROM %s WHERE path = $1", p.table), key).Scan(&data, &lo, &size)
+	if err != nil {
+		return nil, fmt.Errorf("get %q: %w", key, postgresError(err))
+	}
+	var rc io.ReadCloser = io.NopCloser(bytes.NewReader(data))
+	if lo.Valid {
+		rc = p.largeObjectReader(ctx, key, lo.Int64, 0, size)
+	}
+	return trackGetProgress(ctx, key, rc, size), nil
+}
+
+func (p *postgresStorage) GetRange(ctx context.Context, remotePath string, offset, length int64) (io.ReadCloser, error) {
+	key, err := objectKey(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		lo   sql.NullInt64
+		size int64
+	)
+	err = p.db.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT lo, size FROM %s WHERE path = $1", p.table), key).Scan(&lo, &size)
+	if err != nil {
+		return nil, fmt.Errorf("get %q: %w", key, postgresError(err))
+	}
+	offset = min(offset, size)
+	end := size
+	if length >= 0 && offset+length < size {
+		end = offset + length
+	}
+	if lo.Valid {
+		return p.largeObjectReader(ctx, key, lo.Int64, offset, end), nil
+	}
+
+	// bytea positions start at 1.
+	var data []byte
+	err = p.db.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT substring(data FROM $2::int FOR $3::int) FROM %s WHERE path = $1", p.table),
+		key, offset+1, end-offset).Scan(&data)
+	if err != nil {
+		return nil, fmt.Errorf("get %q: %w", key, postgresError(err))
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// largeObjectReader reads bytes off..end of a large object, a chunk per
+// query. A Put that replaces the object meanwhile makes it fail.
+func (p *postgresStorage) largeObjectReader(ctx context.Context, key string, lo, off, end int64) io.ReadCloser {
+	return &pgLargeObjectReader{ctx: ctx, p: p, key: key, lo: lo, off: off, end: end}
+}
+
+type pgLargeObjectReader struct {
+	ctx      context.Context
+	p        *postgresStorage
+	key      string
+	lo       int64
+	off, end int64
+	buf      []byte
+}
+
+func (r *pgLargeObjectReader) Read(b []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if r.off >= r.end {
+			return 0, io.EOF
+		}
+		n := min(int64(r.p.chunkSize), r.end-r.off)
+		err := r.p.db.QueryRowContext(r.ctx, "SELECT lo_get($1::oid, $2, $3::int)", r.lo, r.off, n).Scan(&r.buf)
+		if err != nil {
+			return 0, fmt.Errorf("read %q: %w", r.key, postgresError(err))
+		}
+		if len(r.buf) == 0 {
+			return 0, fmt.Errorf("read %q: %w", r.key, io.ErrUnexpectedEOF)
+		}
+		r.off += int64(len(r.buf))
+	}
+	n := copy(b, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *pgLargeObjectReader) Close() error { return nil }
+
+func (p *postgresStorage) List(ctx context.Context, remotePath string) ([]string, error) {
+	root, err := CleanPath(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := p.db.QueryContext(ctx,
+		fmt.Sprintf("SELECT path FROM %s WHERE starts_with(path, $1) ORDER BY path", p.table), dirPrefix(root))
+	if err != nil {
+		return nil, fmt.Errorf("list %q: %w", root, postgresError(err))
+	}
+	defer rows.Close()
+
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, postgresError(err)
+		}
+		names = append(names, name)
+	}
+	return names, postgresError(rows.Err())
+}
+
+func (p *postgresStorage) ListInfo(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	return collectInfo(ctx, p, remotePath)
+}
+
+// WalkInfo streams the rows of one query. The list filters are part of
+// it, unless directory entries are listed: those are implied by every
+// object, filtered or not.
+func (p *postgresStorage) WalkInfo(ctx context.Context, remotePath string, fn func(fi FileInfo) error) error {
+	root, err := CleanPath(remotePath)
+	if err != nil {
+		return err
+	}
+	opts := ListOptionsFromContext(ctx)
+	var dirs *impliedDirs
+	query, args := p.listQuery(dirPrefix(root), opts)
+	if opts.IncludeDirs {
+		dirs = newImpliedDirs(root)
+		query, args = p.listQuery(dirPrefix(root), ListOptions{})
+	}
+
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("list %q: %w", root, postgresError(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var fi FileInfo
+		if err := rows.Scan(&fi.Path, &fi.Size, &fi.ModTime); err != nil {
+			return postgresError(err)
+		}
+		if dirs != nil {
+			if err := dirs.add(fi.Path, fn); err != nil {
+				return walkResult(err)
+			}
+		}
+		if !opts.Match(fi) {
+			continue
+		}
+		if err := fn(fi); err != nil {
+			return walkResult(err)
+		}
+	}
+	return postgresError(rows.Err())
+}
+
+// listQuery selects the objects below prefix that pass the list filters
+// of o.
+func (p *postgresStorage) listQuery(prefix string, o ListOptions) (string, []any) {
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	where := []string{"starts_with(path, " + arg(prefix) + ")"}
+	if !o.ModifiedAfter.IsZero() {
+		where = append(where, "mtime > "+arg(o.ModifiedAfter))
+	}
+	if !o.ModifiedBefore.IsZero() {
+		where = append(where, "mtime < "+arg(o.ModifiedBefore))
+	}
+	if o.MinSize > 0 {
+		where = append(where, "size >= "+arg(o.MinSize))
+	}
+	if o.MaxSize > 0 {
+		where = append(where, "size <= "+arg(o.MaxSize))
+	}
+	if o.Suffix != "" {
+		where = append(where, fmt.Sprintf("right(path, %d) = %s", utf8.RuneCountInString(o.Suffix), arg(o.Suffix)))
+	}
+	return fmt.Sprintf("SELECT path, size, mtime FROM %s WHERE %s ORDER BY path",
+		p.table, strings.Join(where, " AND ")), args
+}
+
+func (p *postgresStorage) Delete(ctx context.Context, remotePath string) error {
+	key, err := objectKey(remotePath)
+	if err != nil {
+		return err
+	}
+	n, err := p.deleteWhere(ctx, p.db, "path = $1", key)
+	if err != nil {
+		return fmt.Errorf("delete %q: %w", key, err)
+	}
+	if n == 0 {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+func (p *postgresStorage) DeleteAll(ctx context.Context, remotePath string) error {
+	_, err := p.DeleteAllReport(ctx, remotePath)
+	return err
+}
+
+// DeleteAllReport deletes in a single statement, so that nothing or
+// everything is deleted.
+func (p *postgresStorage) DeleteAllReport(ctx context.Context, remotePath string) (DeleteReport, error) {
+	var report DeleteReport
+	root, err := CleanPath(remotePath)
+	if err != nil {
+		return report, err
+	}
+	report.Deleted, err = p.deleteAll(ctx, p.db, root)
+	if err != nil {
+		report.fail(root, err)
+	}
+	return report, report.Err()
+}
+
+func (p *postgresStorage) deleteAll(ctx context.Context, q sqlQuerier, root string) (int, error) {
+	return p.deleteWhere(ctx, q, "path = $1 OR starts_with(path, $2)", root, dirPrefix(root))
+}
+
+func (p *postgresStorage) DeleteDir(ctx context.Context, remotePath string) error {
+	return p.DeleteAll(ctx, remotePath)
+}
+
+// DeleteAllBulk deletes the paths in one transaction.
+func (p *postgresStorage) DeleteAllBulk(ctx context.Context, paths []string) error {
+	roots := make([]string, len(paths))
+	for i, path := range paths {
+		root, err := CleanPath(path)
+		if err != nil {
+			return err
+		}
+		roots[i] = root
+	}
+	return p.inTx(ctx, func(tx *sql.Tx) error {
+		for _, root := range roots {
+			if _, err := p.deleteAll(ctx, tx, root); err != nil {
+				return fmt.Errorf("delete %q: %w", root, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (p *postgresStorage) Exists(ctx context.Context, remotePath string) (bool, error) {
+	key, err := objectKey(remotePath)
+	if err != nil {
+		return false, err
+	}
+	var ok bool
+	err = p.db.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE path = $1)", p.table), key).Scan(&ok)
+	return ok, postgresError(err)
+}
+
+func (p *postgresStorage) Stat(ctx context.Context, remotePath string) (FileInfo, error) {
+	key, err := objectKey(remotePath)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	fi := FileInfo{Path: key}
+	err = p.db.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT size, mtime FROM %s WHERE path = $1", p.table), key).Scan(&fi.Size, &fi.ModTime)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("stat %q: %w", key, postgresError(err))
+	}
+	return fi, nil
+}
+
+func (p *postgresStorage) ListTopLevelDirs(ctx context.Context, prefix string) (map[string]bool, error) {
+	root, err := CleanPath(prefix)
+	if err != nil {
+		return nil, err
+	}
+	normalizedPrefix := dirPrefix(root)
+	// substr counts characters, starting at 1.
+	rows, err := p.db.QueryContext(ctx, fmt.Sprintf(`SELECT DISTINCT split_part(substr(path, $2), '/', 1)
+FROM %s WHERE starts_with(path, $1) AND strpos(substr(path, $2), '/') > 1`, p.table),
+		normalizedPrefix, utf8.RuneCountInString(normalizedPrefix)+1)
+	if err != nil {
+		return nil, fmt.Errorf("list dirs %q: %w", root, postgresError(err))
+	}
+	defer rows.Close()
+
+	result := make(map[string]bool)
+	for rows.Next() {
+		var dir string
+		if err := rows.Scan(&dir); err != nil {
+			return nil, postgresError(err)
+		}
+		result[normalizedPrefix+dir] = true
+	}
+	return result, postgresError(rows.Err())
+}
+
+// Rename replaces the destination and renames the source in one
+// transaction. The object keeps its modification time.
+func (p *postgresStorage) Rename(ctx context.Context, oldRemotePath, newRemotePath string) error {
+	oldKey, err := objectKey(oldRemotePath)
+	if err != nil {
+		return err
+	}
+	newKey, err := objectKey(newRemotePath)
+	if err != nil {
+		return err
+	}
+	if oldKey == newKey {
+		return nil
+	}
+	return p.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := p.deleteWhere(ctx, tx, "path = $1", newKey); err != nil {
+			return fmt.Errorf("rename %q -> %q: %w", oldKey, newKey, err)
+		}
+		res, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET path = $2 WHERE path = $1", p.table), oldKey, newKey)
+		if err != nil {
+			return fmt.Errorf("rename %q -> %q: %w", oldKey, newKey, postgresError(err))
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return fmt.Errorf("rename %q: %w", oldKey, fs.ErrNotExist)
+		}
+		return nil
+	})
+}
+
+// Ping checks the connection to the database.
+func (p *postgresStorage) Ping(ctx context.Context) error {
+	return postgresError(p.db.PingContext(ctx))
+}
+
+// postgresError makes a database error match the storage error it stands
+// for: a missing row, a lost connection, or a PostgreSQL error by its
+// SQLSTATE, which pgx and lib/pq errors report.
+func postgresError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return classify(err, ErrNotExist)
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) {
+		return classify(err, ErrUnavailable)
+	}
+	var se interface{ SQLState() string }
+	if errors.As(err, &se) {
+		return classify(err, postgresStateKind(se.SQLState()))
+	}
+	return err
+}
+
+// postgresStateKind maps a SQLSTATE to the storage error it matches, or nil.
+func postgresStateKind(state string) error {
+	switch {
+	case state == "42501": // insufficient_privilege
+		return ErrPermission
+	case strings.HasPrefix(state, "08"), // connection exceptions
+		state == "53300",                                     // too_many_connections
+		state == "57P01", state == "57P02", state == "57P03": // shutdowns
+		return ErrUnavailable
+	}
+	return nil
+}
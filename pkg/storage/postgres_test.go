@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPostgresStorage_TableName(t *testing.T) {
+	for _, table := range []string{"objects; DROP TABLE x", "a.b.c", "1objects", `"objects"`} {
+		_, err := NewPostgresStorage(context.Background(), nil, PostgresOptions{Table: table})
+		assert.Error(t, err, table)
+	}
+}
+
+func TestPostgresStorage_ListQuery(t *testing.T) {
+	p := &postgresStorage{table: "backups.objects"}
+
+	query, args := p.listQuery("wal/", ListOptions{})
+	assert.Equal(t, "SELECT path, size, mtime FROM backups.objects WHERE starts_with(path, $1) ORDER BY path", query)
+	assert.Equal(t, []any{"wal/"}, args)
+
+	before := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	query, args = p.listQuery("", ListOptions{ModifiedBefore: before, MaxSize: 1 << 20, Suffix: ".gzé"})
+	assert.Equal(t, "SELECT path, size, mtime FROM backups.objects WHERE starts_with(path, $1)"+
+		" AND mtime < $2 AND size <= $3 AND right(path, 4) = $4 ORDER BY path", query)
+	require.Len(t, args, 4)
+	assert.Equal(t, []any{"", before, int64(1 << 20), ".gzé"}, args)
+}
+
+func TestPostgresStateKind(t *testing.T) {
+	assert.ErrorIs(t, postgresStateKind("42501"), ErrPermission)
+	assert.ErrorIs(t, postgresStateKind("08006"), ErrUnavailable)
+	assert.ErrorIs(t, postgresStateKind("57P01"), ErrUnavailable)
+	assert.NoError(t, postgresStateKind("23505"))
+}
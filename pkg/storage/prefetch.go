@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+)
+
+// DefaultPrefetchDepth is the number of objects PrefetchStorage reads ahead
+// when no depth is configured.
+const DefaultPrefetchDepth = 2
+
+// PrefetchStorage reads ahead along a known sequence of names, e.g.
+// consecutive WAL segments during recovery: every Get starts downloading
+// the next Depth objects, as named by Next, so that network latency
+// overlaps with the consumer's work on the current one.
+//
+// Prefetched objects are held in memory whole until they are read or fall
+// out of the window of the last Get. A prefetch that failed (typically
+// because the next object does not exist yet) is not reported; the Get of
+// that path simply reads from the backend. Any write, rename or delete
+// drops what was prefetched.
+type PrefetchStorage struct {
+	Backend Storage
+
+	// Next returns the name following remotePath, or false if there is
+	// none to prefetch.
+	Next  func(remotePath string) (string, bool)
+	Depth int
+
+	mu    sync.Mutex
+	cache map[string]*prefetched
+	wg    sync.WaitGroup
+}
+
+var (
+	_ Storage     = &PrefetchStorage{}
+	_ Stater      = &PrefetchStorage{}
+	_ Walker      = &PrefetchStorage{}
+	_ RangeReader = &PrefetchStorage{}
+	_ Pinger      = &PrefetchStorage{}
+)
+
+// prefetched is one object being read ahead; data and err are set when
+// done is closed.
+type prefetched struct {
+	done   chan struct{}
+	data   []byte
+	err    error
+	cancel context.CancelFunc
+}
+
+// NewPrefetchStorage creates a PrefetchStorage; depth <= 0 selects
+// DefaultPrefetchDepth.
+func NewPrefetchStorage(backend Storage, next func(remotePath string) (string, bool), depth int) *PrefetchStorage {
+	if depth <= 0 {
+		depth = DefaultPrefetchDepth
+	}
+	return &PrefetchStorage{Backend: backend, Next: next, Depth: depth}
+}
+
+func (p *PrefetchStorage) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	p.mu.Lock()
+	pf := p.cache[remotePath]
+	delete(p.cache, remotePath)
+	p.readAhead(remotePath)
+	p.mu.Unlock()
+
+	if pf != nil {
+		select {
+		case <-pf.done:
+			if pf.err == nil {
+				return io.NopCloser(bytes.NewReader(pf.data)), nil
+			}
+		case <-ctx.Done():
+			pf.cancel()
+			return nil, ctx.Err()
+		}
+	}
+	return p.Backend.Get(ctx, remotePath)
+}
+
+// readAhead makes the window the Depth names after remotePath: missing
+// ones are started, the others are dropped. The caller holds mu.
+func (p *PrefetchStorage) readAhead(remotePath string) {
+	if p.Next == nil {
+		return
+	}
+	if p.cache == nil {
+		p.cache = make(map[string]*prefetched)
+	}
+	window := make(map[string]bool, p.Depth)
+	name := remotePath
+	for range p.Depth {
+		next, ok := p.Next(name)
+		if !ok || next == remotePath || window[next] {
+			break
+		}
+		window[next] = true
+		if _, ok := p.cache[next]; !ok {
+			p.cache[next] = p.start(next)
+		}
+		name = next
+	}
+	for name, pf := range p.cache {
+		if !window[name] {
+			pf.cancel()
+			delete(p.cache, name)
+		}
+	}
+}
+
+func (p *PrefetchStorage) start(remotePath string) *prefetched {
+	ctx, cancel := context.WithCancel(context.Background())
+	pf := &prefetched{done: make(chan struct{}), cancel: cancel}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(pf.done)
+		defer cancel()
+		rc, err := p.Backend.Get(ctx, remotePath)
+		if err != nil {
+			pf.err = err
+			return
+		}
+		pf.err = ConsumeObject(rc, func(r io.Reader) (err error) {
+			pf.data, err = io.ReadAll(r)
+			return err
+		})
+	}()
+	return pf
+}
+
+// invalidate drops everything prefetched.
+func (p *PrefetchStorage) invalidate() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name, pf := range p.cache {
+		pf.cancel()
+		delete(p.cache, name)
+	}
+}
+
+// Close stops the prefetches and waits for them to return.
+func (p *PrefetchStorage) Close() error {
+	p.invalidate()
+	p.wg.Wait()
+	return nil
+}
+
+func (p *PrefetchStorage) Put(ctx context.Context, remotePath string, r io.Reader) error {
+	defer p.invalidate()
+	return p.Backend.Put(ctx, remotePath, r)
+}
+
+func (p *PrefetchStorage) GetRange(ctx context.Context, remotePath string, offset, length int64) (io.ReadCloser, error) {
+	return GetRange(ctx, p.Backend, remotePath, offset, length)
+}
+
+func (p *PrefetchStorage) List(ctx context.Context, remotePath string) ([]string, error) {
+	return p.Backend.List(ctx, remotePath)
+}
+
+func (p *PrefetchStorage) ListInfo(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	return p.Backend.ListInfo(ctx, remotePath)
+}
+
+func (p *PrefetchStorage) WalkInfo(ctx context.Context, remotePath string, fn func(fi FileInfo) error) error {
+	return WalkInfo(ctx, p.Backend, remotePath, fn)
+}
+
+func (p *PrefetchStorage) Delete(ctx context.Context, remotePath string) error {
+	defer p.invalidate()
+	return p.Backend.Delete(ctx, remotePath)
+}
+
+func (p *PrefetchStorage) DeleteAll(ctx context.Context, remotePath string) error {
+	defer p.invalidate()
+	return p.Backend.DeleteAll(ctx, remotePath)
+}
+
+func (p *PrefetchStorage) DeleteDir(ctx context.Context, remotePath string) error {
+	defer p.invalidate()
+	return p.Backend.DeleteDir(ctx, remotePath)
+}
+
+func (p *PrefetchStorage) DeleteAllBulk(ctx context.Context, paths []string) error {
+	defer p.invalidate()
+	return p.Backend.DeleteAllBulk(ctx, paths)
+}
+
+func (p *PrefetchStorage) Exists(ctx context.Context, remotePath string) (bool, error) {
+	return p.Backend.Exists(ctx, remotePath)
+}
+
+func (p *PrefetchStorage) Stat(ctx context.Context, remotePath string) (FileInfo, error) {
+	return StatObject(ctx, p.Backend, remotePath)
+}
+
+func (p *PrefetchStorage) ListTopLevelDirs(ctx context.Context, prefix string) (map[string]bool, error) {
+	return p.Backend.ListTopLevelDirs(ctx, prefix)
+}
+
+func (p *PrefetchStorage) Rename(ctx context.Context, oldRemotePath, newRemotePath string) error {
+	defer p.invalidate()
+	return p.Backend.Rename(ctx, oldRemotePath, newRemotePath)
+}
+
+func (p *PrefetchStorage) Ping(ctx context.Context) error {
+	return Ping(ctx, p.Backend)
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// getCounter counts the Gets per path.
+type getCounter struct {
+	*InMemoryStorage
+	mu   sync.Mutex
+	gets map[string]int
+}
+
+func (g *getCounter) Get(ctx context.Context, path string) (io.ReadCloser, error) {
+	g.mu.Lock()
+	g.gets[path]++
+	g.mu.Unlock()
+	return g.InMemoryStorage.Get(ctx, path)
+}
+
+func (g *getCounter) count(path string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.gets[path]
+}
+
+func nextNumbered(p string) (string, bool) {
+	var n int
+	if _, err := fmt.Sscanf(p, "wal/%04d", &n); err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("wal/%04d", n+1), true
+}
+
+func readAll(t *testing.T, st Storage, path string) string {
+	t.Helper()
+	rc, err := st.Get(context.Background(), path)
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	return string(data)
+}
+
+func TestPrefetchStorage_Sequential(t *testing.T) {
+	backend := &getCounter{InMemoryStorage: NewInMemoryStorage(), gets: make(map[string]int)}
+	paths := putWAL(t, backend, 5)
+	ps := NewPrefetchStorage(backend, nextNumbered, 2)
+	defer ps.Close()
+
+	for _, p := range paths {
+		assert.Equal(t, p, readAll(t, ps, p))
+	}
+	require.NoError(t, ps.Close())
+
+	for _, p := range paths {
+		assert.Equal(t, 1, backend.count(p), p)
+	}
+	// The missing successor was tried once and not cached.
+	assert.Equal(t, 1, backend.count("wal/0005"))
+}
+
+func TestPrefetchStorage_Invalidate(t *testing.T) {
+	ctx := context.Background()
+	backend := &getCounter{InMemoryStorage: NewInMemoryStorage(), gets: make(map[string]int)}
+	putWAL(t, backend, 3)
+	ps := NewPrefetchStorage(backend, nextNumbered, 1)
+	defer ps.Close()
+
+	readAll(t, ps, "wal/0000")
+	require.NoError(t, ps.Put(ctx, "wal/0001", strings.NewReader("changed")))
+	assert.Equal(t, "changed", readAll(t, ps, "wal/0001"))
+
+	// A jump out of the window drops what was read ahead.
+	readAll(t, ps, "wal/0000")
+	assert.Equal(t, "wal/0002", readAll(t, ps, "wal/0002"))
+	ps.mu.Lock()
+	_, ok := ps.cache["wal/0001"]
+	ps.mu.Unlock()
+	assert.False(t, ok)
+}
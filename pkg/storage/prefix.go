@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+)
+
+// PrefixStorage roots a storage at a directory of its backend, e.g. to give
+// every tenant its own part of a bucket: paths are joined to Prefix on the
+// way in and the prefix is stripped from listings on the way out.
+type PrefixStorage struct {
+	Backend Storage
+	Prefix  string
+}
+
+var (
+	_ Storage           = &PrefixStorage{}
+	_ Stater            = &PrefixStorage{}
+	_ Walker            = &PrefixStorage{}
+	_ RangeReader       = &PrefixStorage{}
+	_ Pinger            = &PrefixStorage{}
+	_ DeleteAllReporter = &PrefixStorage{}
+	_ ExclusivePutter   = &PrefixStorage{}
+)
+
+// NewPrefixStorage creates a PrefixStorage; leading and trailing slashes
+// of prefix are ignored.
+func NewPrefixStorage(backend Storage, prefix string) *PrefixStorage {
+	return &PrefixStorage{Backend: backend, Prefix: strings.Trim(prefix, "/")}
+}
+
+// full joins remotePath to the prefix without resolving it, so that ".."
+// cannot climb out of the prefix: the backend refuses such paths.
+func (p *PrefixStorage) full(remotePath string) string {
+	if p.Prefix == "" {
+		return remotePath
+	}
+	return p.Prefix + "/" + strings.TrimPrefix(remotePath, "/")
+}
+
+// rel strips the prefix from a backend path.
+func (p *PrefixStorage) rel(stored string) string {
+	if p.Prefix == "" {
+		return stored
+	}
+	return strings.TrimPrefix(stored, p.Prefix+"/")
+}
+
+func (p *PrefixStorage) relInfo(fi FileInfo) FileInfo {
+	fi.Path = p.rel(fi.Path)
+	if fi.StoredPath != "" {
+		fi.StoredPath = p.rel(fi.StoredPath)
+	}
+	return fi
+}
+
+func (p *PrefixStorage) Put(ctx context.Context, remotePath string, r io.Reader) error {
+	return p.Backend.Put(ctx, p.full(remotePath), r)
+}
+
+// PutIfAbsent implements ExclusivePutter if the backend does.
+func (p *PrefixStorage) PutIfAbsent(ctx context.Context, remotePath string, r io.Reader) error {
+	return PutIfAbsent(ctx, p.Backend, p.full(remotePath), r)
+}
+
+func (p *PrefixStorage) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	return p.Backend.Get(ctx, p.full(remotePath))
+}
+
+func (p *PrefixStorage) GetRange(ctx context.Context, remotePath string, offset, length int64) (io.ReadCloser, error) {
+	return GetRange(ctx, p.Backend, p.full(remotePath), offset, length)
+}
+
+func (p *PrefixStorage) List(ctx context.Context, remotePath string) ([]string, error) {
+	names, err := p.Backend.List(ctx, p.full(remotePath))
+	if err != nil {
+		return nil, err
+	}
+	for i := range names {
+		names[i] = p.rel(names[i])
+	}
+	return names, nil
+}
+
+func (p *PrefixStorage) ListInfo(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	infos, err := p.Backend.ListInfo(ctx, p.full(remotePath))
+	if err != nil {
+		return nil, err
+	}
+	for i := range infos {
+		infos[i] = p.relInfo(infos[i])
+	}
+	return infos, nil
+}
+
+func (p *PrefixStorage) WalkInfo(ctx context.Context, remotePath string, fn func(fi FileInfo) error) error {
+	return WalkInfo(ctx, p.Backend, p.full(remotePath), func(fi FileInfo) error {
+		return fn(p.relInfo(fi))
+	})
+}
+
+func (p *PrefixStorage) Delete(ctx context.Context, remotePath string) error {
+	return p.Backend.Delete(ctx, p.full(remotePath))
+}
+
+func (p *PrefixStorage) DeleteAll(ctx context.Context, remotePath string) error {
+	return p.Backend.DeleteAll(ctx, p.full(remotePath))
+}
+
+func (p *PrefixStorage) DeleteAllReport(ctx context.Context, remotePath string) (DeleteReport, error) {
+	report, err := DeleteAllReport(ctx, p.Backend, p.full(remotePath))
+	for i := range report.Failed {
+		report.Failed[i].Path = p.rel(report.Failed[i].Path)
+	}
+	return report, err
+}
+
+func (p *PrefixStorage) DeleteDir(ctx context.Context, remotePath string) error {
+	return p.Backend.DeleteDir(ctx, p.full(remotePath))
+}
+
+func (p *PrefixStorage) DeleteAllBulk(ctx context.Context, paths []string) error {
+	full := make([]string, len(paths))
+	for i, rp := range paths {
+		full[i] = p.full(rp)
+	}
+	return p.Backend.DeleteAllBulk(ctx, full)
+}
+
+func (p *PrefixStorage) Exists(ctx context.Context, remotePath string) (bool, error) {
+	return p.Backend.Exists(ctx, p.full(remotePath))
+}
+
+func (p *PrefixStorage) Stat(ctx context.Context, remotePath string) (FileInfo, error) {
+	fi, err := StatObject(ctx, p.Backend, p.full(remotePath))
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return p.relInfo(fi), nil
+}
+
+func (p *PrefixStorage) ListTopLevelDirs(ctx context.Context, prefix string) (map[string]bool, error) {
+	dirs, err := p.Backend.ListTopLevelDirs(ctx, p.full(prefix))
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]bool, len(dirs))
+	for dir, ok := range dirs {
+		result[p.rel(dir)] = ok
+	}
+	return result, nil
+}
+
+func (p *PrefixStorage) Rename(ctx context.Context, oldRemotePath, newRemotePath string) error {
+	return p.Backend.Rename(ctx, p.full(oldRemotePath), p.full(newRemotePath))
+}
+
+func (p *PrefixStorage) Ping(ctx context.Context) error {
+	return Ping(ctx, p.Backend)
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefixStorage(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	ps := NewPrefixStorage(mem, "/tenant-a/")
+
+	require.NoError(t, ps.Put(ctx, "wal/0001", strings.NewReader("one")))
+	require.NoError(t, ps.Put(ctx, "wal/0002", strings.NewReader("two")))
+	require.NoError(t, mem.Put(ctx, "tenant-b/wal/0001", strings.NewReader("other")))
+	assert.Contains(t, mem.Files, "tenant-a/wal/0001")
+
+	names, err := ps.List(ctx, "wal")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"wal/0001", "wal/0002"}, names)
+
+	fi, err := ps.Stat(ctx, "wal/0002")
+	require.NoError(t, err)
+	assert.Equal(t, "wal/0002", fi.Path)
+
+	var walked []string
+	require.NoError(t, WalkInfo(ctx, ps, "wal", func(fi FileInfo) error {
+		walked = append(walked, fi.Path)
+		return nil
+	}))
+	assert.ElementsMatch(t, names, walked)
+
+	dirs, err := ps.ListTopLevelDirs(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"wal": true}, dirs)
+
+	require.NoError(t, ps.Rename(ctx, "wal/0002", "old/0002"))
+	assert.Contains(t, mem.Files, "tenant-a/old/0002")
+	require.NoError(t, ps.DeleteAllBulk(ctx, []string{"wal", "old"}))
+	assert.Equal(t, []string{"tenant-b/wal/0001"}, keys(mem.Files))
+}
+
+func keys(m map[string][]byte) []string {
+	var ks []string
+	for k := range m {
+		ks = append(ks, k)
+	}
+	return ks
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// RangeReader is implemented by backends (and wrappers) that can read part
+// of an object without fetching all of it.
+type RangeReader interface {
+	// GetRange returns length bytes of the object starting at offset; a
+	// negative length reads to the end. Reading past the end yields fewer
+	// bytes, not an error.
+	GetRange(ctx context.Context, remotePath string, offset, length int64) (io.ReadCloser, error)
+}
+
+// GetRange reads part of an object, using RangeReader when the storage
+// implements it and falling back to a full Get that skips the leading
+// bytes.
+func GetRange(ctx context.Context, st Storage, remotePath string, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("get range %q: negative offset %d", remotePath, offset)
+	}
+	if rr, ok := st.(RangeReader); ok {
+		return rr.GetRange(ctx, remotePath, offset, length)
+	}
+	return getRangeByGet(ctx, st, remotePath, offset, length)
+}
+
+// getRangeByGet reads the whole object and discards the bytes before
+// offset.
+func getRangeByGet(ctx context.Context, st Storage, remotePath string, offset, length int64) (io.ReadCloser, error) {
+	rc, err := st.Get(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, rc, offset); err != nil && !errors.Is(err, io.EOF) {
+		_ = rc.Close()
+		return nil, err
+	}
+	return limitRange(rc, length), nil
+}
+
+// limitRange caps rc at length bytes unless length is negative.
+func limitRange(rc io.ReadCloser, length int64) io.ReadCloser {
+	if length < 0 {
+		return rc
+	}
+	return &readCloser{Reader: io.LimitReader(rc, length), Closer: rc}
+}
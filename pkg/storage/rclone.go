@@ -0,0 +1,436 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// RcloneOptions configures NewRcloneStorage.
+type RcloneOptions struct {
+	// Binary is the rclone executable, "rclone" (looked up in $PATH) if
+	// empty.
+	Binary string
+
+	// ConfigFile is passed as --config; rclone's default config is used
+	// if it is empty.
+	ConfigFile string
+
+	// Args are extra flags given to every command, e.g. "--fast-list" or
+	// "--s3-upload-concurrency=8".
+	Args []string
+
+	// Env is added to the environment of rclone, e.g. RCLONE_CONFIG_PASS
+	// or the RCLONE_CONFIG_<REMOTE>_<OPTION> variables that define a
+	// remote without a config file.
+	Env []string
+}
+
+type rcloneStorage struct {
+	base   string
+	binary string
+	args   []string
+	env    []string
+}
+
+var (
+	_ Storage = &rcloneStorage{}
+	_ Stater  = &rcloneStorage{}
+	_ Walker  = &rcloneStorage{}
+)
+
+// NewRcloneStorage stores objects in an rclone remote, such as
+// "b2:bucket/archive" or ":sftp,host=backup:/srv", so that any provider
+// rclone supports can hold an archive. Every call runs the rclone
+// executable: objects are streamed through rcat and cat, listings are
+// read from lsjson. It needs rclone 1.54 or later.
+func NewRcloneStorage(remote string, opts RcloneOptions) Storage {
+	binary := opts.Binary
+	if binary == "" {
+		binary = "rclone"
+	}
+	var args []string
+	if opts.ConfigFile != "" {
+		args = append(args, "--config", opts.ConfigFile)
+	}
+	args = append(args, opts.Args...)
+	return &rcloneStorage{
+		base:   strings.TrimSuffix(remote, "/"),
+		binary: binary,
+		args:   args,
+		env:    opts.Env,
+	}
+}
+
+// remotePath returns the rclone path of the object or directory p.
+func (s *rcloneStorage) remotePath(p string) string {
+	if p == "" {
+		return s.base
+	}
+	if s.base == "" || strings.HasSuffix(s.base, ":") {
+		return s.base + p
+	}
+	return s.base + "/" + p
+}
+
+func (s *rcloneStorage) command(ctx context.Context, args ...string) (*exec.Cmd, *bytes.Buffer) {
+	cmd := exec.CommandContext(ctx, s.binary, append(append([]string{}, s.args...), args...)...)
+	if len(s.env) > 0 {
+		cmd.Env = append(os.Environ(), s.env...)
+	}
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	return cmd, stderr
+}
+
+// run runs an rclone command and returns its output.
+func (s *rcloneStorage) run(ctx context.Context, args ...string) ([]byte, error) {
+	cmd, stderr := s.command(ctx, args...)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, rcloneError(ctx, err, stderr)
+	}
+	return out, nil
+}
+
+// Put streams r to rclone rcat. If r fails, rclone is killed before it
+// sees the end of its input, so that no truncated object is stored.
+func (s *rcloneStorage) Put(ctx context.Context, remotePath string, r io.Reader) error {
+	name, err := objectKey(remotePath)
+	if err != nil {
+		return err
+	}
+	ctx, r = trackPutProgress(ctx, name, r)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd, stderr := s.command(ctx, "rcat", s.remotePath(name))
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("put %q: %w", name, rcloneError(ctx, err, stderr))
+	}
+	_, copyErr := io.Copy(stdin, r)
+	if copyErr != nil {
+		cancel()
+	}
+	_ = stdin.Close()
+	waitErr := cmd.Wait()
+	if copyErr != nil {
+		return fmt.Errorf("put %q: %w", name, copyErr)
+	}
+	if waitErr != nil {
+		return fmt.Errorf("put %q: %w", name, rcloneError(ctx, waitErr, stderr))
+	}
+	return nil
+}
+
+// Get checks that the object exists first: rclone cat prints nothing for
+// a missing object on some remotes.
+func (s *rcloneStorage) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	fi, err := s.Stat(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	cmd, stderr := s.command(ctx, "cat", s.remotePath(fi.Path))
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("get %q: %w", fi.Path, rcloneError(ctx, err, stderr))
+	}
+	rc := &rcloneReader{ReadCloser: stdout, ctx: ctx, cmd: cmd, stderr: stderr, name: fi.Path}
+	return trackGetProgress(ctx, fi.Path, rc, fi.Size), nil
+}
+
+// rcloneReader reads the output of rclone cat; the exit status of rclone
+// is reported at the end of it.
+type rcloneReader struct {
+	io.ReadCloser
+	ctx    context.Context
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	name   string
+	waited bool
+}
+
+func (r *rcloneReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF && !r.waited {
+		r.waited = true
+		if waitErr := r.cmd.Wait(); waitErr != nil {
+			return n, fmt.Errorf("get %q: %w", r.name, rcloneError(r.ctx, waitErr, r.stderr))
+		}
+	}
+	return n, err
+}
+
+// Close stops rclone if the object was not read to the end. Once Wait has
+// run, it has closed the pipe already.
+func (r *rcloneReader) Close() error {
+	if r.waited {
+		return nil
+	}
+	r.waited = true
+	err := r.ReadCloser.Close()
+	_ = r.cmd.Process.Kill()
+	_ = r.cmd.Wait()
+	return err
+}
+
+// rcloneEntry is an item of the output of rclone lsjson.
+type rcloneEntry struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+}
+
+func (s *rcloneStorage) List(ctx context.Context, remotePath string) ([]string, error) {
+	infos, err := s.ListInfo(context.WithValue(ctx, listOptionsKey{}, ListOptions{}), remotePath)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(infos))
+	for _, fi := range infos {
+		names = append(names, fi.Path)
+	}
+	return names, nil
+}
+
+func (s *rcloneStorage) ListInfo(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	return collectInfo(ctx, s, remotePath)
+}
+
+// WalkInfo decodes the output of rclone lsjson as it arrives. A missing
+// directory lists nothing, as on the other backends.
+func (s *rcloneStorage) WalkInfo(ctx context.Context, remotePath string, fn func(fi FileInfo) error) error {
+	root, err := CleanPath(remotePath)
+	if err != nil {
+		return err
+	}
+	opts := ListOptionsFromContext(ctx)
+	var dirs *impliedDirs
+	if opts.IncludeDirs {
+		dirs = newImpliedDirs(root)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmd, stderr := s.command(ctx, "lsjson", "--recursive", "--files-only", "--no-mimetype", s.remotePath(root))
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("list %q: %w", root, rcloneError(ctx, err, stderr))
+	}
+	walkErr := s.decodeListing(stdout, root, func(fi FileInfo) error {
+		if dirs != nil {
+			if err := dirs.add(fi.Path, fn); err != nil {
+				return err
+			}
+		}
+		if !opts.Match(fi) {
+			return nil
+		}
+		return fn(fi)
+	})
+	if walkErr != nil {
+		cancel()
+	}
+	waitErr := cmd.Wait()
+	if walkErr != nil {
+		return walkResult(walkErr)
+	}
+	if waitErr != nil {
+		err := rcloneError(ctx, waitErr, stderr)
+		if errors.Is(err, ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("list %q: %w", root, err)
+	}
+	return nil
+}
+
+// decodeListing feeds the entries of a JSON array of lsjson items to fn,
+// with paths relative to the storage.
+func (s *rcloneStorage) decodeListing(r io.Reader, root string, fn func(fi FileInfo) error) error {
+	dec := json.NewDecoder(r)
+	if _, err := dec.Token(); err != nil {
+		if err == io.EOF {
+			return nil // rclone failed; Wait tells why
+		}
+		return fmt.Errorf("rclone: decode listing: %w", err)
+	}
+	for dec.More() {
+		var e rcloneEntry
+		if err := dec.Decode(&e); err != nil {
+			return fmt.Errorf("rclone: decode listing: %w", err)
+		}
+		if e.IsDir {
+			continue
+		}
+		p := e.Path
+		if root != "" {
+			p = root + "/" + p
+		}
+		if err := fn(FileInfo{Path: p, Size: e.Size, ModTime: e.ModTime}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *rcloneStorage) Delete(ctx context.Context, remotePath string) error {
+	name, err := objectKey(remotePath)
+	if err != nil {
+		return err
+	}
+	if _, err := s.run(ctx, "deletefile", s.remotePath(name)); err != nil {
+		return fmt.Errorf("delete %q: %w", name, err)
+	}
+	return nil
+}
+
+// DeleteAll deletes the object at remotePath, if any, and everything
+// below it, then the directories left empty. The root of the remote is
+// kept.
+func (s *rcloneStorage) DeleteAll(ctx context.Context, remotePath string) error {
+	root, err := CleanPath(remotePath)
+	if err != nil {
+		return err
+	}
+	if root != "" {
+		err := s.Delete(ctx, root)
+		if err == nil || !errors.Is(err, ErrNotExist) {
+			return err
+		}
+	}
+	if _, err := s.run(ctx, "delete", "--rmdirs", s.remotePath(root)); err != nil && !errors.Is(err, ErrNotExist) {
+		return fmt.Errorf("delete %q: %w", root, err)
+	}
+	return nil
+}
+
+func (s *rcloneStorage) DeleteDir(ctx context.Context, remotePath string) error {
+	return s.DeleteAll(ctx, remotePath)
+}
+
+func (s *rcloneStorage) DeleteAllBulk(ctx context.Context, paths []string) error {
+	for _, p := range paths {
+		if err := s.DeleteAll(ctx, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *rcloneStorage) Exists(ctx context.Context, remotePath string) (bool, error) {
+	_, err := s.Stat(ctx, remotePath)
+	if errors.Is(err, ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *rcloneStorage) Stat(ctx context.Context, remotePath string) (FileInfo, error) {
+	name, err := objectKey(remotePath)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	out, err := s.run(ctx, "lsjson", "--stat", "--no-mimetype", s.remotePath(name))
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("stat %q: %w", name, err)
+	}
+	var e rcloneEntry
+	if err := json.Unmarshal(out, &e); err != nil {
+		return FileInfo{}, fmt.Errorf("stat %q: rclone: %w", name, err)
+	}
+	if e.IsDir {
+		return FileInfo{}, fmt.Errorf("stat %q: %w", name, fs.ErrNotExist)
+	}
+	return FileInfo{Path: name, Size: e.Size, ModTime: e.ModTime}, nil
+}
+
+func (s *rcloneStorage) ListTopLevelDirs(ctx context.Context, prefix string) (map[string]bool, error) {
+	names, err := s.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	root, _ := CleanPath(prefix) // checked by List
+	normalizedPrefix := dirPrefix(root)
+
+	result := make(map[string]bool)
+	for _, name := range names {
+		rel := strings.TrimPrefix(name, normalizedPrefix)
+		if dir, _, ok := strings.Cut(rel, "/"); ok && dir != "" {
+			result[normalizedPrefix+dir] = true
+		}
+	}
+	return result, nil
+}
+
+// Rename moves the object with rclone moveto, server-side where the
+// provider can.
+func (s *rcloneStorage) Rename(ctx context.Context, oldRemotePath, newRemotePath string) error {
+	oldName, err := objectKey(oldRemotePath)
+	if err != nil {
+		return err
+	}
+	newName, err := objectKey(newRemotePath)
+	if err != nil {
+		return err
+	}
+	if oldName == newName {
+		return nil
+	}
+	// moveto succeeds without a source on some remotes.
+	if _, err := s.Stat(ctx, oldName); err != nil {
+		return fmt.Errorf("rename %q: %w", oldName, err)
+	}
+	if _, err := s.run(ctx, "moveto", s.remotePath(oldName), s.remotePath(newName)); err != nil {
+		return fmt.Errorf("rename %q -> %q: %w", oldName, newName, err)
+	}
+	return nil
+}
+
+// rcloneError describes a failed rclone command by the last line rclone
+// logged, and makes it match the storage error its exit code stands for.
+func rcloneError(ctx context.Context, err error, stderr *bytes.Buffer) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) {
+		return fmt.Errorf("rclone: %w", err)
+	}
+	msg := strings.TrimSpace(stderr.String())
+	if i := strings.LastIndexByte(msg, '\n'); i >= 0 {
+		msg = msg[i+1:]
+	}
+	return classify(fmt.Errorf("rclone: %w: %s", err, msg), rcloneExitKind(ee.ExitCode()))
+}
+
+// rcloneExitKind maps an exit code of rclone to the storage error it
+// matches, or nil.
+func rcloneExitKind(code int) error {
+	switch code {
+	case 3, 4: // directory not found, file not found
+		return ErrNotExist
+	case 5: // temporary error
+		return ErrUnavailable
+	}
+	return nil
+}
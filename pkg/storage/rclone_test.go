@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRcloneStorage_RemotePath(t *testing.T) {
+	s := NewRcloneStorage("b2:bucket/archive/", RcloneOptions{}).(*rcloneStorage)
+	assert.Equal(t, "b2:bucket/archive", s.remotePath(""))
+	assert.Equal(t, "b2:bucket/archive/wal/0001", s.remotePath("wal/0001"))
+
+	s = NewRcloneStorage("gdrive:", RcloneOptions{}).(*rcloneStorage)
+	assert.Equal(t, "gdrive:", s.remotePath(""))
+	assert.Equal(t, "gdrive:wal/0001", s.remotePath("wal/0001"))
+}
+
+func TestRcloneStorage_DecodeListing(t *testing.T) {
+	s := &rcloneStorage{}
+	listing := `[
+{"Path":"0001","Name":"0001","Size":3,"ModTime":"2024-03-01T10:00:00.5Z","IsDir":false},
+{"Path":"sub","Name":"sub","Size":-1,"ModTime":"2024-03-01T10:00:00Z","IsDir":true},
+{"Path":"sub/0002","Name":"0002","Size":5,"ModTime":"2024-03-01T11:00:00Z","IsDir":false}
+]`
+	var infos []FileInfo
+	require.NoError(t, s.decodeListing(strings.NewReader(listing), "wal", func(fi FileInfo) error {
+		infos = append(infos, fi)
+		return nil
+	}))
+	require.Len(t, infos, 2)
+	assert.Equal(t, "wal/0001", infos[0].Path)
+	assert.Equal(t, int64(3), infos[0].Size)
+	assert.Equal(t, 500_000_000, infos[0].ModTime.Nanosecond())
+	assert.Equal(t, "wal/sub/0002", infos[1].Path)
+
+	// rclone failed before printing anything.
+	assert.NoError(t, s.decodeListing(strings.NewReader(""), "", func(FileInfo) error { return nil }))
+}
+
+func TestRcloneStorage_ExitCodes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script")
+	}
+	bin := filepath.Join(t.TempDir(), "rclone")
+	script := "#!/bin/sh\necho \"2024/03/01 10:00:00 ERROR : wal/0001: object not found\" >&2\nexit 3\n"
+	require.NoError(t, os.WriteFile(bin, []byte(script), 0o755))
+
+	ctx := context.Background()
+	s := NewRcloneStorage("remote:archive", RcloneOptions{Binary: bin})
+	_, err := s.Get(ctx, "wal/0001")
+	assert.ErrorIs(t, err, ErrNotExist)
+	assert.ErrorContains(t, err, "object not found")
+	ok, err := s.Exists(ctx, "wal/0001")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// A missing directory lists nothing.
+	files, err := s.List(ctx, "wal")
+	require.NoError(t, err)
+	assert.Empty(t, files)
+	assert.NoError(t, s.DeleteAll(ctx, "wal"))
+}
+
+func TestRcloneStorage_GetClose(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script")
+	}
+	bin := filepath.Join(t.TempDir(), "rclone")
+	script := `#!/bin/sh
+case "$1" in
+lsjson) echo '{"Path":"0001","Name":"0001","Size":5,"ModTime":"2024-03-01T10:00:00Z","IsDir":false}' ;;
+cat) printf hello ;;
+esac
+`
+	require.NoError(t, os.WriteFile(bin, []byte(script), 0o755))
+
+	ctx := context.Background()
+	s := NewRcloneStorage("remote:archive", RcloneOptions{Binary: bin})
+	rc, err := s.Get(ctx, "wal/0001")
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+	require.NoError(t, rc.Close())
+
+	// Closing before the end stops rclone.
+	rc, err = s.Get(ctx, "wal/0001")
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+}
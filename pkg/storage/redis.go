@@ -0,0 +1,475 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashmap-kz/storecrypt/pkg/clients"
+)
+
+const (
+	// DefaultRedisScanCount is the COUNT hint of the SCAN calls that list
+	// keys when none is configured.
+	DefaultRedisScanCount = 1000
+
+	// DefaultRedisMaxSize is the largest object put when no MaxSize is
+	// configured: 512 MiB, the limit of a Redis string
+	// (proto-max-bulk-len).
+	DefaultRedisMaxSize = 512 << 20
+)
+
+// RedisOptions configures NewRedisStorage.
+type RedisOptions struct {
+	// Prefix is prepended to every object path to make its key, e.g.
+	// "storecrypt:" to share a database with other data.
+	Prefix string
+
+	// TTL, if set, expires every object that long after it was put. A
+	// rename keeps the time left.
+	TTL time.Duration
+
+	// ScanCount is the COUNT hint of SCAN, DefaultRedisScanCount if zero.
+	ScanCount int
+
+	// MaxSize is the largest object Put accepts, DefaultRedisMaxSize if
+	// zero. Larger ones fail with *ObjectTooLargeError as soon as the
+	// limit is crossed, before anything is sent to the server.
+	MaxSize int64
+}
+
+type redisStorage struct {
+	client    *clients.RedisClient
+	prefix    string
+	ttl       time.Duration
+	scanCount int
+	maxSize   int64
+	now       func() time.Time
+}
+
+var (
+	_ Storage = &redisStorage{}
+	_ Stater  = &redisStorage{}
+	_ Walker  = &redisStorage{}
+	_ Pinger  = &redisStorage{}
+)
+
+// NewRedisStorage stores objects in Redis, one hash (data, mtime) per
+// object keyed by its path, e.g. as a fast cache of encrypted WAL segments
+// and manifests in front of a slower archive. Objects are held in memory
+// whole by Put and Get, so it suits small objects. Listings SCAN the
+// keyspace below the prefix, which costs in proportion to the whole
+// database, not to the listing.
+func NewRedisStorage(client *clients.RedisClient, opts RedisOptions) Storage {
+	scanCount := opts.ScanCount
+	if scanCount <= 0 {
+		scanCount = DefaultRedisScanCount
+	}
+	maxSize := opts.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultRedisMaxSize
+	}
+	return &redisStorage{
+		client:    client,
+		prefix:    opts.Prefix,
+		ttl:       opts.TTL,
+		scanCount: scanCount,
+		maxSize:   maxSize,
+		now:       time.Now,
+	}
+}
+
+func (s *redisStorage) key(remotePath string) (name, key string, err error) {
+	name, err = objectKey(remotePath)
+	if err != nil {
+		return "", "", err
+	}
+	return name, s.prefix + name, nil
+}
+
+// Put replaces the whole hash, so that an object put again loses the
+// expiry of the previous one, as with SET.
+func (s *redisStorage) Put(ctx context.Context, remotePath string, r io.Reader) error {
+	name, key, err := s.key(remotePath)
+	if err != nil {
+		return err
+	}
+	_, r = trackPutProgress(ctx, name, r)
+	data, err := io.ReadAll(&limitReader{r: r, left: s.maxSize, limit: s.maxSize, path: name})
+	if err != nil {
+		var tooLarge *ObjectTooLargeError
+		if errors.As(err, &tooLarge) {
+			return err
+		}
+		return fmt.Errorf("read source for %q: %w", name, err)
+	}
+	cmds := [][]any{
+		{"MULTI"},
+		{"DEL", key},
+		{"HSET", key, "data", data, "mtime", s.now().UnixNano()},
+	}
+	if s.ttl > 0 {
+		cmds = append(cmds, []any{"PEXPIRE", key, s.ttl.Milliseconds()})
+	}
+	cmds = append(cmds, []any{"EXEC"})
+	if _, err := s.pipeline(ctx, cmds); err != nil {
+		return fmt.Errorf("put %q: %w", name, err)
+	}
+	return nil
+}
+
+func (s *redisStorage) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	name, key, err := s.key(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := s.client.Do(ctx, "HGET", key, "data")
+	if err != nil {
+		return nil, fmt.Errorf("get %q: %w", name, redisError(err))
+	}
+	data, ok := reply.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("get %q: %w", name, fs.ErrNotExist)
+	}
+	return trackGetProgress(ctx, name, io.NopCloser(bytes.NewReader(data)), int64(len(data))), nil
+}
+
+func (s *redisStorage) List(ctx context.Context, remotePath string) ([]string, error) {
+	root, err := CleanPath(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	err = s.scan(ctx, dirPrefix(root), func(keys []string) error {
+		for _, key := range keys {
+			names = append(names, strings.TrimPrefix(key, s.prefix))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list %q: %w", root, err)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *redisStorage) ListInfo(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	root, err := CleanPath(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	var files []FileInfo
+	err = s.scan(ctx, dirPrefix(root), func(keys []string) error {
+		infos, err := s.stat(ctx, keys)
+		files = append(files, infos...)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list %q: %w", root, err)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	opts := ListOptionsFromContext(ctx)
+	dirs := newImpliedDirs(root)
+	var infos []FileInfo
+	for _, fi := range files {
+		if opts.IncludeDirs {
+			// Directories are implied by every object, filtered or not.
+			_ = dirs.add(fi.Path, func(dir FileInfo) error {
+				infos = append(infos, dir)
+				return nil
+			})
+		}
+		if opts.Match(fi) {
+			infos = append(infos, fi)
+		}
+	}
+	return infos, nil
+}
+
+// WalkInfo reads the whole listing before fn is called: SCAN returns keys
+// in no order and may return a key twice.
+func (s *redisStorage) WalkInfo(ctx context.Context, remotePath string, fn func(fi FileInfo) error) error {
+	infos, err := s.ListInfo(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+	return walkSlice(ctx, infos, fn)
+}
+
+// scan calls fn with the keys matching the object paths below prefix, a
+// page at a time, each key once.
+func (s *redisStorage) scan(ctx context.Context, prefix string, fn func(keys []string) error) error {
+	pattern := redisGlobEscape(s.prefix+prefix) + "*"
+	seen := make(map[string]bool)
+	cursor := "0"
+	for {
+		reply, err := s.client.Do(ctx, "SCAN", cursor, "MATCH", pattern, "COUNT", s.scanCount)
+		if err != nil {
+			return redisError(err)
+		}
+		page, ok := reply.([]any)
+		if !ok || len(page) != 2 {
+			return fmt.Errorf("redis: unexpected SCAN reply %v", reply)
+		}
+		next, _ := page[0].([]byte)
+		items, _ := page[1].([]any)
+		keys := make([]string, 0, len(items))
+		for _, item := range items {
+			key := string(item.([]byte))
+			if !seen[key] && key != s.prefix+prefix {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return nil
+		}
+	}
+}
+
+// stat reads the size and modification time of the objects at keys in
+// one round trip, skipping those that expired or were deleted since they
+// were listed.
+func (s *redisStorage) stat(ctx context.Context, keys []string) ([]FileInfo, error) {
+	cmds := make([][]any, 0, 2*len(keys))
+	for _, key := range keys {
+		cmds = append(cmds, []any{"HSTRLEN", key, "data"}, []any{"HGET", key, "mtime"})
+	}
+	replies, err := s.pipeline(ctx, cmds)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]FileInfo, 0, len(keys))
+	for i, key := range keys {
+		size, _ := replies[2*i].(int64)
+		mtime, ok := replies[2*i+1].([]byte)
+		if !ok {
+			continue
+		}
+		nanos, err := strconv.ParseInt(string(mtime), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad mtime of %q: %w", key, err)
+		}
+		infos = append(infos, FileInfo{
+			Path:    strings.TrimPrefix(key, s.prefix),
+			Size:    size,
+			ModTime: time.Unix(0, nanos),
+		})
+	}
+	return infos, nil
+}
+
+// pipeline sends cmds at once and fails with the first error reply,
+// looking into the replies of a transaction.
+func (s *redisStorage) pipeline(ctx context.Context, cmds [][]any) ([]any, error) {
+	replies, err := s.client.Pipeline(ctx, cmds)
+	if err != nil {
+		return nil, redisError(err)
+	}
+	for _, reply := range replies {
+		if err := redisReplyError(reply); err != nil {
+			return nil, err
+		}
+	}
+	return replies, nil
+}
+
+func redisReplyError(reply any) error {
+	switch v := reply.(type) {
+	case clients.RedisError:
+		return redisError(v)
+	case []any:
+		for _, item := range v {
+			if err := redisReplyError(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *redisStorage) Delete(ctx context.Context, remotePath string) error {
+	name, key, err := s.key(remotePath)
+	if err != nil {
+		return err
+	}
+	reply, err := s.client.Do(ctx, "DEL", key)
+	if err != nil {
+		return fmt.Errorf("delete %q: %w", name, redisError(err))
+	}
+	if n, _ := reply.(int64); n == 0 {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+func (s *redisStorage) DeleteAll(ctx context.Context, remotePath string) error {
+	root, err := CleanPath(remotePath)
+	if err != nil {
+		return err
+	}
+	if root != "" {
+		if _, err := s.client.Do(ctx, "DEL", s.prefix+root); err != nil {
+			return fmt.Errorf("delete %q: %w", root, redisError(err))
+		}
+	}
+	err = s.scan(ctx, dirPrefix(root), func(keys []string) error {
+		args := make([]any, 0, len(keys)+1)
+		args = append(args, "DEL")
+		for _, key := range keys {
+			args = append(args, key)
+		}
+		_, err := s.client.Do(ctx, args...)
+		return redisError(err)
+	})
+	if err != nil {
+		return fmt.Errorf("delete %q: %w", root, err)
+	}
+	return nil
+}
+
+func (s *redisStorage) DeleteDir(ctx context.Context, remotePath string) error {
+	return s.DeleteAll(ctx, remotePath)
+}
+
+func (s *redisStorage) DeleteAllBulk(ctx context.Context, paths []string) error {
+	for _, p := range paths {
+		if err := s.DeleteAll(ctx, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *redisStorage) Exists(ctx context.Context, remotePath string) (bool, error) {
+	_, key, err := s.key(remotePath)
+	if err != nil {
+		return false, err
+	}
+	reply, err := s.client.Do(ctx, "EXISTS", key)
+	if err != nil {
+		return false, redisError(err)
+	}
+	n, _ := reply.(int64)
+	return n > 0, nil
+}
+
+func (s *redisStorage) Stat(ctx context.Context, remotePath string) (FileInfo, error) {
+	name, key, err := s.key(remotePath)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	infos, err := s.stat(ctx, []string{key})
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("stat %q: %w", name, err)
+	}
+	if len(infos) == 0 {
+		return FileInfo{}, fs.ErrNotExist
+	}
+	return infos[0], nil
+}
+
+func (s *redisStorage) ListTopLevelDirs(ctx context.Context, prefix string) (map[string]bool, error) {
+	names, err := s.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	root, _ := CleanPath(prefix) // checked by List
+	normalizedPrefix := dirPrefix(root)
+
+	result := make(map[string]bool)
+	for _, name := range names {
+		rel := strings.TrimPrefix(name, normalizedPrefix)
+		if dir, _, ok := strings.Cut(rel, "/"); ok && dir != "" {
+			result[normalizedPrefix+dir] = true
+		}
+	}
+	return result, nil
+}
+
+// Rename replaces the destination atomically with RENAME. The object keeps
+// its modification time and the time left to its expiry.
+func (s *redisStorage) Rename(ctx context.Context, oldRemotePath, newRemotePath string) error {
+	oldName, oldKey, err := s.key(oldRemotePath)
+	if err != nil {
+		return err
+	}
+	newName, newKey, err := s.key(newRemotePath)
+	if err != nil {
+		return err
+	}
+	if oldKey == newKey {
+		return nil
+	}
+	if _, err := s.client.Do(ctx, "RENAME", oldKey, newKey); err != nil {
+		return fmt.Errorf("rename %q -> %q: %w", oldName, newName, redisError(err))
+	}
+	return nil
+}
+
+// Ping checks the connection to the server.
+func (s *redisStorage) Ping(ctx context.Context) error {
+	return redisError(s.client.Ping(ctx))
+}
+
+// redisGlobEscape quotes the characters SCAN MATCH patterns give a
+// meaning to.
+func redisGlobEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\', '^', '-':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// redisError makes a Redis error match the storage error it stands for.
+func redisError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var re clients.RedisError
+	if errors.As(err, &re) {
+		return classify(err, redisErrorKind(string(re)))
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return classify(err, ErrUnavailable)
+	}
+	return err
+}
+
+// redisErrorKind maps an error reply, by its code, to the storage error
+// it matches, or nil.
+func redisErrorKind(reply string) error {
+	code, _, _ := strings.Cut(reply, " ")
+	switch code {
+	case "NOAUTH", "NOPERM", "WRONGPASS":
+		return ErrPermission
+	case "BUSY", "LOADING", "MASTERDOWN", "CLUSTERDOWN", "TRYAGAIN":
+		return ErrUnavailable
+	case "ERR":
+		if strings.HasPrefix(reply, "ERR no such key") {
+			return ErrNotExist
+		}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/hashmap-kz/storecrypt/pkg/clients"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisGlobEscape(t *testing.T) {
+	assert.Equal(t, `cache:wal/`, redisGlobEscape("cache:wal/"))
+	assert.Equal(t, `a\*b\?c\[d\]e\\f`, redisGlobEscape(`a*b?c[d]e\f`))
+}
+
+func TestRedisError(t *testing.T) {
+	assert.ErrorIs(t, redisError(clients.RedisError("NOAUTH Authentication required.")), ErrPermission)
+	assert.ErrorIs(t, redisError(clients.RedisError("LOADING Redis is loading the dataset in memory")), ErrUnavailable)
+	assert.ErrorIs(t, redisError(clients.RedisError("ERR no such key")), ErrNotExist)
+	assert.NotErrorIs(t, redisError(clients.RedisError("ERR syntax error")), ErrNotExist)
+
+	// Errors of a transaction are found in the EXEC reply.
+	err := redisReplyError([]any{int64(1), clients.RedisError("WRONGTYPE Operation against a key holding the wrong kind of value")})
+	assert.EqualError(t, err, "redis: WRONGTYPE Operation against a key holding the wrong kind of value")
+	assert.NoError(t, redisReplyError([]any{"OK", int64(2)}))
+}
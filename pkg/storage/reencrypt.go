@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+)
+
+// reencryptTempMarker tags the temporary objects written by Reencrypt;
+// they are renamed into place once fully written.
+const reencryptTempMarker = ".reencrypt-"
+
+// reencryptCheckpointEvery is how many completed objects Reencrypt
+// accumulates before rewriting its checkpoint.
+const reencryptCheckpointEvery = 100
+
+// ReencryptReport summarises a Reencrypt run.
+type ReencryptReport struct {
+	Total   int   // objects found under the prefix
+	Done    int   // objects re-encrypted by this run
+	Skipped int   // objects already done according to the checkpoint
+	Failed  int   // objects that could not be re-encrypted
+	Bytes   int64 // plaintext bytes re-encrypted
+}
+
+// ReencryptOptions tune Reencrypt.
+type ReencryptOptions struct {
+	// Concurrency is the number of objects processed at once (default 1).
+	Concurrency int
+
+	// Checkpoint is the backend path of a checkpoint object listing the
+	// logical paths already re-encrypted. A run resumed with the same
+	// checkpoint skips them. The checkpoint is rewritten as the run
+	// progresses and deleted once it completes without errors. It holds
+	// object names in clear text.
+	Checkpoint string
+
+	// Progress, if set, is called after every object with the running
+	// totals. Calls are serialised but may come from any worker.
+	Progress func(ReencryptReport)
+}
+
+// ReencryptOption configures ReencryptOptions.
+type ReencryptOption func(*ReencryptOptions)
+
+// WithReencryptConcurrency processes n objects at once.
+func WithReencryptConcurrency(n int) ReencryptOption {
+	return func(o *ReencryptOptions) { o.Concurrency = n }
+}
+
+// WithReencryptCheckpoint records progress in the backend object at path,
+// so an interrupted run can be resumed.
+func WithReencryptCheckpoint(path string) ReencryptOption {
+	return func(o *ReencryptOptions) { o.Checkpoint = path }
+}
+
+// WithReencryptProgress reports the running totals to fn after every
+// object.
+func WithReencryptProgress(fn func(ReencryptReport)) ReencryptOption {
+	return func(o *ReencryptOptions) { o.Progress = fn }
+}
+
+// Reencrypt rewrites every object under prefix encrypted with newCrypter:
+// each one is decoded with the current settings (including
+// PreviousCrypters and object headers), re-encoded into a temporary object
+// and renamed over the original. Compression, headers, trailers and frame
+// layout follow the storage's settings. If newCrypter's extension differs
+// from the current one, the object moves to its new name.
+//
+// Once the run succeeds, switch Crypter to newCrypter (keeping the old one
+// in PreviousCrypters until every archive has been rotated). Failures are
+// collected and reported together; the returned report is always set.
+func (ts *TransformingStorage) Reencrypt(ctx context.Context, prefix string, newCrypter crypt.Crypter, opts ...ReencryptOption) (*ReencryptReport, error) {
+	o := ReencryptOptions{Concurrency: 1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	report := &ReencryptReport{}
+
+	files, err := ts.Backend.List(ctx, prefix)
+	if err != nil {
+		return report, err
+	}
+	done := make(map[string]bool)
+	if o.Checkpoint != "" {
+		if done, err = loadReencryptCheckpoint(ctx, ts.Backend, o.Checkpoint); err != nil {
+			return report, fmt.Errorf("load checkpoint: %w", err)
+		}
+	}
+	ext := ts.getFileExt()
+	var todo []string
+	for _, f := range files {
+		if f == o.Checkpoint || strings.Contains(f, reencryptTempMarker) || !strings.HasSuffix(f, ext) {
+			continue
+		}
+		report.Total++
+		logical := ts.decodePath(f)
+		if done[logical] {
+			report.Skipped++
+			continue
+		}
+		todo = append(todo, logical)
+	}
+	sort.Strings(todo)
+
+	next := *ts
+	next.Crypter = newCrypter
+	next.PreviousCrypters = nil
+
+	var (
+		mu    sync.Mutex
+		errs  []error
+		dirty int
+		wg    sync.WaitGroup
+	)
+	jobs := make(chan string)
+	for range max(o.Concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for logical := range jobs {
+				n, err := ts.reencryptObject(ctx, &next, logical)
+				mu.Lock()
+				if err != nil {
+					report.Failed++
+					errs = append(errs, fmt.Errorf("reencrypt %q: %w", logical, err))
+				} else {
+					report.Done++
+					report.Bytes += n
+					done[logical] = true
+					if dirty++; o.Checkpoint != "" && dirty >= reencryptCheckpointEvery {
+						if err := saveReencryptCheckpoint(ctx, ts.Backend, o.Checkpoint, done); err != nil {
+							errs = append(errs, fmt.Errorf("save checkpoint: %w", err))
+						}
+						dirty = 0
+					}
+				}
+				if o.Progress != nil {
+					o.Progress(*report)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, logical := range todo {
+		select {
+		case jobs <- logical:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	if o.Checkpoint != "" {
+		if len(errs) == 0 {
+			err = ts.Backend.Delete(ctx, o.Checkpoint)
+		} else {
+			// Keep what was achieved for the next attempt, even if ctx
+			// was cancelled.
+			err = saveReencryptCheckpoint(context.WithoutCancel(ctx), ts.Backend, o.Checkpoint, done)
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("checkpoint: %w", err))
+		}
+	}
+	return report, errors.Join(errs...)
+}
+
+// reencryptObject re-encodes one logical object with next and returns its
+// plaintext size.
+func (ts *TransformingStorage) reencryptObject(ctx context.Context, next *TransformingStorage, logical string) (int64, error) {
+	source, target := ts.encodePath(logical), next.encodePath(logical)
+	putCtx := ctx
+	if ts.RecordSizes {
+		// Carry the recorded size over instead of spooling to measure it.
+		if size, ok, err := ts.recordedSize(ctx, source); err == nil && ok && size > 0 {
+			putCtx = ContextWithPutOptions(ctx, WithSizeHint(size))
+		}
+	}
+	rc, err := ts.Get(withoutGetProgress(ctx), logical)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	tmp := logical + reencryptTempMarker + randomSuffix()
+	err = ConsumeObject(rc, func(r io.Reader) error {
+		return next.Put(putCtx, tmp, &countingReader{r: r, add: func(k int64) { n += k }})
+	})
+	if err != nil {
+		_ = ts.Backend.Delete(ctx, next.encodePath(tmp))
+		return 0, err
+	}
+
+	if err := replaceObject(ctx, ts.Backend, next.encodePath(tmp), target); err != nil {
+		if !errors.Is(err, errReplaceIncomplete) {
+			_ = ts.Backend.Delete(ctx, next.encodePath(tmp))
+		}
+		return 0, err
+	}
+	if source != target {
+		if err := ts.Backend.Delete(ctx, source); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return n, fmt.Errorf("delete old object %q: %w", source, err)
+		}
+	}
+	return n, nil
+}
+
+func loadReencryptCheckpoint(ctx context.Context, st Storage, p string) (map[string]bool, error) {
+	done := make(map[string]bool)
+	rc, err := st.Get(ctx, p)
+	if errors.Is(err, ErrNotExist) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	sc := bufio.NewScanner(rc)
+	for sc.Scan() {
+		if line := sc.Text(); line != "" {
+			done[line] = true
+		}
+	}
+	return done, sc.Err()
+}
+
+func saveReencryptCheckpoint(ctx context.Context, st Storage, p string, done map[string]bool) error {
+	names := make([]string, 0, len(done))
+	for name := range done {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return st.Put(ctx, p, strings.NewReader(strings.Join(names, "\n")))
+}
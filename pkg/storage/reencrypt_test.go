@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashmap-kz/storecrypt/pkg/crypters"
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformingStorage_Reencrypt(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	oldEnv := crypters.NewEnvelope(newEnvelopeKey(t, "old"))
+	newEnv := crypters.NewEnvelope(newEnvelopeKey(t, "new"))
+	ts := &TransformingStorage{
+		Backend:      mem,
+		Crypter:      oldEnv,
+		Compressor:   codec.GzipCompressor{},
+		Decompressor: codec.GzipDecompressor{},
+		RecordSizes:  true,
+	}
+	for i := range 10 {
+		p := fmt.Sprintf("wal/%04d", i)
+		require.NoError(t, ts.Put(ctx, p, strings.NewReader(p)))
+	}
+
+	var calls atomic.Int32
+	report, err := ts.Reencrypt(ctx, "wal", newEnv,
+		WithReencryptConcurrency(4),
+		WithReencryptCheckpoint("reencrypt.state"),
+		WithReencryptProgress(func(ReencryptReport) { calls.Add(1) }))
+	require.NoError(t, err)
+	assert.Equal(t, &ReencryptReport{Total: 10, Done: 10, Bytes: 80}, report)
+	assert.Equal(t, int32(10), calls.Load())
+
+	_, exists := mem.Files["reencrypt.state"]
+	assert.False(t, exists, "checkpoint is removed after a clean run")
+	for p := range mem.Files {
+		assert.NotContains(t, p, reencryptTempMarker)
+	}
+
+	rotated := &TransformingStorage{
+		Backend:      mem,
+		Crypter:      newEnv,
+		Compressor:   codec.GzipCompressor{},
+		Decompressor: codec.GzipDecompressor{},
+		RecordSizes:  true,
+	}
+	rc, err := rotated.Get(ctx, "wal/0003")
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, "wal/0003", string(got))
+
+	infos, err := rotated.ListInfo(ctx, "wal")
+	require.NoError(t, err)
+	require.Len(t, infos, 10)
+	for _, fi := range infos {
+		assert.Equal(t, int64(8), fi.Size, fi.Path)
+	}
+
+	_, err = ts.Get(ctx, "wal/0003")
+	assert.Error(t, err, "the old key no longer decrypts")
+}
+
+func TestTransformingStorage_Reencrypt_Resume(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	oldEnv := crypters.NewEnvelope(newEnvelopeKey(t, "old"))
+	newEnv := crypters.NewEnvelope(newEnvelopeKey(t, "new"))
+	ts := &TransformingStorage{Backend: mem, Crypter: oldEnv}
+	for _, p := range []string{"a/1", "a/2", "a/3", "a/4"} {
+		require.NoError(t, ts.Put(ctx, p, strings.NewReader(p)))
+	}
+	// A previous run got through a/1; a/3 is damaged.
+	mem.Files["a/3.enc"] = bytes.Repeat([]byte{1}, 100)
+	require.NoError(t, mem.Put(ctx, "state", strings.NewReader("a/1\n")))
+
+	report, err := ts.Reencrypt(ctx, "a", newEnv, WithReencryptCheckpoint("state"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `"a/3"`)
+	assert.Equal(t, &ReencryptReport{Total: 4, Done: 2, Skipped: 1, Failed: 1, Bytes: 6}, report)
+
+	// The checkpoint survives the failure and lists everything done so far.
+	assert.Equal(t, "a/1\na/2\na/4", string(mem.Files["state"]))
+}
+
+// noOverwriteStorage refuses to rename over an existing object, like SFTP
+// without posix-rename, and fails the renames fail selects.
+type noOverwriteStorage struct {
+	*InMemoryStorage
+	fail func(from, to string) bool
+}
+
+func (s *noOverwriteStorage) Rename(ctx context.Context, from, to string) error {
+	if ok, _ := s.InMemoryStorage.Exists(ctx, to); ok {
+		return fmt.Errorf("rename %q: %w", to, fs.ErrExist)
+	}
+	if s.fail != nil && s.fail(from, to) {
+		return errors.New("connection lost")
+	}
+	return s.InMemoryStorage.Rename(ctx, from, to)
+}
+
+func TestReplaceObject_NoOverwrite(t *testing.T) {
+	ctx := context.Background()
+	st := &noOverwriteStorage{InMemoryStorage: NewInMemoryStorage()}
+	st.Files["obj"] = []byte("old")
+	st.Files["tmp"] = []byte("new")
+
+	require.NoError(t, replaceObject(ctx, st, "tmp", "obj"))
+	assert.Equal(t, map[string][]byte{"obj": []byte("new")}, st.Files)
+
+	// The replacement cannot be renamed into place: the original is put
+	// back.
+	st.Files["tmp"] = []byte("newer")
+	st.fail = func(from, _ string) bool { return from == "tmp" }
+	err := replaceObject(ctx, st, "tmp", "obj")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, errReplaceIncomplete)
+	assert.Equal(t, map[string][]byte{"obj": []byte("new"), "tmp": []byte("newer")}, st.Files)
+}
+
+func TestTransformingStorage_Reencrypt_KeepsTempWhenReplaceIsIncomplete(t *testing.T) {
+	ctx := context.Background()
+	st := &noOverwriteStorage{InMemoryStorage: NewInMemoryStorage()}
+	oldEnv := crypters.NewEnvelope(newEnvelopeKey(t, "old"))
+	ts := &TransformingStorage{Backend: st, Crypter: oldEnv}
+	require.NoError(t, ts.Put(ctx, "wal/0001", strings.NewReader("data")))
+
+	// Neither the replacement nor the original can be renamed into place.
+	st.fail = func(from, _ string) bool {
+		return strings.Contains(from, reencryptTempMarker) || strings.Contains(from, ".replaced-")
+	}
+	_, err := ts.Reencrypt(ctx, "wal", crypters.NewEnvelope(newEnvelopeKey(t, "new")))
+	require.ErrorIs(t, err, errReplaceIncomplete)
+
+	var tmp, aside int
+	for p := range st.Files {
+		switch {
+		case strings.Contains(p, reencryptTempMarker):
+			tmp++
+		case strings.Contains(p, ".replaced-"):
+			aside++
+		}
+	}
+	assert.Equal(t, 1, tmp, "the re-encrypted copy is kept")
+	assert.Equal(t, 1, aside, "the original is kept")
+}
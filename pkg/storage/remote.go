@@ -0,0 +1,405 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashmap-kz/storecrypt/pkg/crypters"
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/aesgcm"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPasswordEnv is the environment variable holding the password of
+// an "aes" remote when neither PasswordFile nor PasswordEnv is set.
+const DefaultPasswordEnv = "STORECRYPT_PASSWORD"
+
+// ErrUnknownRemote is returned when a remote is not defined in the config.
+var ErrUnknownRemote = errors.New("storage: unknown remote")
+
+// Config is the named-remote configuration file, e.g.:
+//
+//	remotes:
+//	  backups:
+//	    backend: s3
+//	    prefix: pg/main
+//	    s3:
+//	      endpoint: https://minio.local:9000
+//	      bucket: backups
+//	      path_style: true
+//	    codec: zstd
+//	    codec_level: 9
+//	    crypter: aes
+//	    password_file: /etc/storecrypt/password
+//	  scratch:
+//	    backend: local
+//	    local:
+//	      dir: /var/tmp/storecrypt
+//	    codec: none
+type Config struct {
+	Remotes map[string]RemoteConfig `yaml:"remotes" json:"remotes"`
+}
+
+// RemoteConfig describes how to connect to a backend and which transforms
+// to apply to it.
+type RemoteConfig struct {
+	// Backend is "local", "s3", "sftp", "redis", "rclone", "http"
+	// (read-only) or "mem" (in-process, for tests).
+	Backend string `yaml:"backend" json:"backend"`
+
+	// Prefix is the directory of the archive in the backend.
+	Prefix string `yaml:"prefix" json:"prefix"`
+
+	Local  LocalRemote  `yaml:"local" json:"local"`
+	S3     S3Remote     `yaml:"s3" json:"s3"`
+	SFTP   SFTPRemote   `yaml:"sftp" json:"sftp"`
+	Redis  RedisRemote  `yaml:"redis" json:"redis"`
+	Rclone RcloneRemote `yaml:"rclone" json:"rclone"`
+	HTTP   HTTPRemote   `yaml:"http" json:"http"`
+
+	// Codec compresses new objects: "gzip" (the default), "zstd" or
+	// "none". Objects written with any codec can be read.
+	Codec string `yaml:"codec" json:"codec"`
+
+	// CodecLevel, if not zero, is the compression level of Codec: 1-9
+	// (or -2 for Huffman only) for gzip, 1-22 for zstd.
+	CodecLevel int `yaml:"codec_level" json:"codec_level"`
+
+	// Crypter encrypts new objects: "none" (the default), "aes" with a
+	// password, or "envelope" with a local master key.
+	Crypter string `yaml:"crypter" json:"crypter"`
+
+	// PasswordFile holds the "aes" password; otherwise it is read from
+	// the PasswordEnv environment variable (default DefaultPasswordEnv).
+	PasswordFile string `yaml:"password_file" json:"password_file"`
+	PasswordEnv  string `yaml:"password_env" json:"password_env"`
+
+	// KeyFile and KeyID are the "envelope" master key (32 raw bytes or 64
+	// hex characters) and its id (default "default").
+	KeyFile string `yaml:"key_file" json:"key_file"`
+	KeyID   string `yaml:"key_id" json:"key_id"`
+
+	// WriteExt, if set, overrides the write extension derived from Codec
+	// and Crypter, e.g. ".gz" to keep reading ".aes" objects but write
+	// them unencrypted.
+	WriteExt string `yaml:"write_ext" json:"write_ext"`
+
+	// DeleteConcurrency bounds the objects removed at once by bulk
+	// deletes (e.g. pruning); zero means DefaultDeleteConcurrency.
+	DeleteConcurrency int `yaml:"delete_concurrency" json:"delete_concurrency"`
+}
+
+// LocalRemote configures a local backend.
+type LocalRemote struct {
+	Dir   string `yaml:"dir" json:"dir"`
+	Fsync bool   `yaml:"fsync" json:"fsync"`
+}
+
+// S3Remote configures an S3 backend. Empty credentials are read from
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+type S3Remote struct {
+	Endpoint        string `yaml:"endpoint" json:"endpoint"`
+	Region          string `yaml:"region" json:"region"`
+	Bucket          string `yaml:"bucket" json:"bucket"`
+	AccessKeyID     string `yaml:"access_key_id" json:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key" json:"secret_access_key"`
+	PathStyle       bool   `yaml:"path_style" json:"path_style"`
+	Insecure        bool   `yaml:"insecure" json:"insecure"`       // skip TLS certificate verification
+	AutoRegion      bool   `yaml:"auto_region" json:"auto_region"` // look up the bucket's region
+
+	// ListShards fans listings out by key prefix, e.g. the hex digits
+	// (see HexListShards); ListConcurrency bounds the concurrent pages.
+	ListShards      []string `yaml:"list_shards" json:"list_shards"`
+	ListConcurrency int      `yaml:"list_concurrency" json:"list_concurrency"`
+}
+
+// SFTPRemote configures an SFTP backend.
+type SFTPRemote struct {
+	Host       string `yaml:"host" json:"host"`
+	Port       string `yaml:"port" json:"port"`
+	User       string `yaml:"user" json:"user"`
+	KeyFile    string `yaml:"key_file" json:"key_file"`
+	Passphrase string `yaml:"passphrase" json:"passphrase"`
+
+	// SSHConfig resolves Host as an alias of ~/.ssh/config, which then
+	// supplies the settings left empty, ProxyJump included.
+	SSHConfig bool `yaml:"ssh_config" json:"ssh_config"`
+}
+
+// RedisRemote configures a Redis backend; the prefix of the remote is
+// prepended to the keys. An empty password is read from REDIS_PASSWORD.
+type RedisRemote struct {
+	Addr     string        `yaml:"addr" json:"addr"`
+	Username string        `yaml:"username" json:"username"`
+	Password string        `yaml:"password" json:"password"`
+	DB       int           `yaml:"db" json:"db"`
+	TLS      bool          `yaml:"tls" json:"tls"`
+	Insecure bool          `yaml:"insecure" json:"insecure"` // skip TLS certificate verification
+	TTL      time.Duration `yaml:"ttl" json:"ttl"`           // e.g. "24h"; zero keeps objects
+	MaxSize  int64         `yaml:"max_size" json:"max_size"` // bytes, DefaultRedisMaxSize if zero
+}
+
+// RcloneRemote configures a backend run through the rclone executable;
+// the prefix of the remote is a directory of Remote, e.g. "b2:bucket".
+type RcloneRemote struct {
+	Remote string   `yaml:"remote" json:"remote"`
+	Binary string   `yaml:"binary" json:"binary"`
+	Config string   `yaml:"config" json:"config"` // rclone.conf, rclone's default if empty
+	Args   []string `yaml:"args" json:"args"`     // extra flags, e.g. --fast-list
+}
+
+// HTTPRemote configures a read-only backend on a web server; the prefix
+// of the remote is a directory below URL.
+type HTTPRemote struct {
+	URL      string `yaml:"url" json:"url"`
+	Manifest string `yaml:"manifest" json:"manifest"` // JSON listing below URL, else directory index pages
+}
+
+// DefaultConfigPath returns $STORECRYPT_CONFIG, or else
+// storecrypt/config.yaml in the user's config directory (e.g.
+// ~/.config/storecrypt/config.yaml).
+func DefaultConfigPath() (string, error) {
+	if p := os.Getenv("STORECRYPT_CONFIG"); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "storecrypt", "config.yaml"), nil
+}
+
+// LoadConfig reads a config file. Relative paths in it (local dirs,
+// password and key files) are resolved against the file's directory.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("storage: %w", err)
+	}
+	var c Config
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("storage: parse %s: %w", path, err)
+	}
+	base := filepath.Dir(path)
+	abs := func(p *string) {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(base, *p)
+		}
+	}
+	for name, rc := range c.Remotes {
+		abs(&rc.Local.Dir)
+		abs(&rc.PasswordFile)
+		abs(&rc.KeyFile)
+		abs(&rc.Rclone.Config)
+		c.Remotes[name] = rc
+	}
+	return &c, nil
+}
+
+// Remote returns the configuration of the named remote.
+func (c *Config) Remote(name string) (RemoteConfig, error) {
+	rc, ok := c.Remotes[name]
+	if !ok {
+		return RemoteConfig{}, fmt.Errorf("%w %q", ErrUnknownRemote, name)
+	}
+	return rc, nil
+}
+
+// Names returns the names of the remotes, sorted.
+func (c *Config) Names() []string {
+	names := make([]string, 0, len(c.Remotes))
+	for name := range c.Remotes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// OpenRemote opens the named remote of the config at DefaultConfigPath.
+func OpenRemote(ctx context.Context, name string) (*Remote, error) {
+	path, err := DefaultConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	c, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := c.Remote(name)
+	if err != nil {
+		return nil, err
+	}
+	return rc.Open(ctx)
+}
+
+// Remote is an opened remote: a VariadicStorage over the configured
+// backend. Close releases the backend connection.
+type Remote struct {
+	*VariadicStorage
+	closeFn func() error
+}
+
+// Close closes the backend connection, if any.
+func (r *Remote) Close() error {
+	return r.closeFn()
+}
+
+// Open connects to the backend and applies the transforms.
+func (rc RemoteConfig) Open(ctx context.Context) (*Remote, error) {
+	alg, err := rc.Algorithms()
+	if err != nil {
+		return nil, err
+	}
+	writeExt, err := rc.WriteExtension()
+	if err != nil {
+		return nil, err
+	}
+	backend, closeFn, err := rc.OpenBackend(ctx)
+	if err != nil {
+		return nil, err
+	}
+	vs, err := NewVariadicStorage(backend, alg, writeExt)
+	if err != nil {
+		_ = closeFn()
+		return nil, err
+	}
+	vs.DeleteConcurrency = rc.DeleteConcurrency
+	return &Remote{VariadicStorage: vs, closeFn: closeFn}, nil
+}
+
+// OpenBackend connects to the backend alone, without transforms. The
+// returned function closes the connection.
+func (rc RemoteConfig) OpenBackend(ctx context.Context) (Storage, func() error, error) {
+	var (
+		st      Storage
+		closeFn = func() error { return nil }
+		err     error
+	)
+	switch rc.Backend {
+	case "local":
+		if rc.Local.Dir == "" {
+			return nil, nil, errors.New("storage: local remote needs a dir")
+		}
+		st, err = NewLocal(&LocalStorageOpts{
+			BaseDir:           filepath.Join(rc.Local.Dir, filepath.FromSlash(rc.Prefix)),
+			FsyncOnWrite:      rc.Local.Fsync,
+			DeleteConcurrency: rc.DeleteConcurrency,
+		})
+	case "s3":
+		st, err = openS3Remote(ctx, rc)
+	case "sftp":
+		st, closeFn, err = openSFTPRemote(rc)
+	case "redis":
+		st, closeFn, err = openRedisRemote(rc)
+	case "rclone":
+		st, err = openRcloneRemote(rc)
+	case "http":
+		st, err = openHTTPRemote(rc)
+	case "mem":
+		st = NewInMemoryStorage()
+	default:
+		return nil, nil, fmt.Errorf("storage: unknown backend %q", rc.Backend)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("storage: open %s backend: %w", rc.Backend, err)
+	}
+	return st, closeFn, nil
+}
+
+// Algorithms returns the transforms the remote can read: gzip and zstd,
+// the one of Codec at CodecLevel, and the configured crypter.
+func (rc RemoteConfig) Algorithms() (Algorithms, error) {
+	alg := Algorithms{
+		Gzip: &CodecPair{Compressor: codec.GzipCompressor{}, Decompressor: codec.GzipDecompressor{}},
+		Zstd: &CodecPair{Compressor: codec.ZstdCompressor{}, Decompressor: codec.ZstdDecompressor{}},
+	}
+	if rc.CodecLevel != 0 {
+		switch rc.Codec {
+		case "", "gzip":
+			alg.Gzip.Level = rc.CodecLevel
+		case "zstd":
+			alg.Zstd.Level = rc.CodecLevel
+		default:
+			return Algorithms{}, fmt.Errorf("storage: codec_level needs codec gzip or zstd, not %q", rc.Codec)
+		}
+	}
+	switch rc.Crypter {
+	case "", "none":
+	case "aes":
+		password, err := rc.password()
+		if err != nil {
+			return Algorithms{}, err
+		}
+		alg.AES = aesgcm.NewChunkedGCMCrypter(password)
+	case "envelope":
+		if rc.KeyFile == "" {
+			return Algorithms{}, errors.New("storage: envelope crypter needs a key_file")
+		}
+		id := rc.KeyID
+		if id == "" {
+			id = "default"
+		}
+		w, err := crypters.NewLocalKeyWrapperFromFile(id, rc.KeyFile)
+		if err != nil {
+			return Algorithms{}, fmt.Errorf("storage: %w", err)
+		}
+		env := crypters.NewEnvelope(w)
+		alg.Crypters = map[string]crypt.Crypter{env.FileExtension(): env}
+	default:
+		return Algorithms{}, fmt.Errorf("storage: unknown crypter %q", rc.Crypter)
+	}
+	return alg, nil
+}
+
+// WriteExtension returns the extensions new objects are written with:
+// WriteExt if set, else those of Codec and Crypter.
+func (rc RemoteConfig) WriteExtension() (string, error) {
+	if rc.WriteExt != "" {
+		return rc.WriteExt, nil
+	}
+	var ext string
+	switch rc.Codec {
+	case "", "gzip":
+		ext = ".gz"
+	case "zstd":
+		ext = ".zst"
+	case "none":
+	default:
+		return "", fmt.Errorf("storage: unknown codec %q", rc.Codec)
+	}
+	switch rc.Crypter {
+	case "aes":
+		ext += ".aes"
+	case "envelope":
+		ext += ".enc"
+	}
+	return ext, nil
+}
+
+func (rc RemoteConfig) password() (string, error) {
+	if rc.PasswordFile != "" {
+		data, err := os.ReadFile(rc.PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("storage: read password file: %w", err)
+		}
+		if password := strings.TrimRight(string(data), "\r\n"); password != "" {
+			return password, nil
+		}
+		return "", fmt.Errorf("storage: password file %s is empty", rc.PasswordFile)
+	}
+	env := rc.PasswordEnv
+	if env == "" {
+		env = DefaultPasswordEnv
+	}
+	if password := os.Getenv(env); password != "" {
+		return password, nil
+	}
+	return "", fmt.Errorf("storage: aes crypter needs a password_file or $%s", env)
+}
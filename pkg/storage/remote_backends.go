@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/hashmap-kz/storecrypt/pkg/clients"
+)
+
+func openS3Remote(_ context.Context, rc RemoteConfig) (Storage, error) {
+	c := rc.S3
+	if c.Bucket == "" {
+		return nil, errors.New("s3 remote needs a bucket")
+	}
+	if c.AccessKeyID == "" {
+		c.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if c.SecretAccessKey == "" {
+		c.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if c.Region == "" {
+		c.Region = "us-east-1"
+	}
+	client, err := clients.NewS3Client(&clients.S3Config{
+		EndpointURL:     c.Endpoint,
+		AccessKeyID:     c.AccessKeyID,
+		SecretAccessKey: c.SecretAccessKey,
+		Bucket:          c.Bucket,
+		Region:          c.Region,
+		UsePathStyle:    c.PathStyle,
+		DisableSSL:      c.Insecure,
+		AutoRegion:      c.AutoRegion,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewS3StorageWithOptions(client.Client(), client.Bucket(), rc.Prefix, S3Options{
+		ListShards:      c.ListShards,
+		ListConcurrency: c.ListConcurrency,
+	}), nil
+}
+
+func openSFTPRemote(rc RemoteConfig) (Storage, func() error, error) {
+	c := rc.SFTP
+	switch {
+	case c.SSHConfig:
+		if c.Host == "" {
+			return nil, nil, errors.New("sftp remote needs a host")
+		}
+	case c.Host == "" || c.User == "" || c.KeyFile == "":
+		return nil, nil, errors.New("sftp remote needs a host, user and key_file")
+	case c.Port == "":
+		c.Port = "22"
+	}
+	client, err := clients.NewSFTPClient(&clients.SFTPConfig{
+		Host:         c.Host,
+		Port:         c.Port,
+		User:         c.User,
+		PkeyPath:     c.KeyFile,
+		Passphrase:   c.Passphrase,
+		UseSSHConfig: c.SSHConfig,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewSFTPStorage(client.SFTPClient(), rc.Prefix, WithSFTPDeleteConcurrency(rc.DeleteConcurrency)), client.Close, nil
+}
+
+func openRedisRemote(rc RemoteConfig) (Storage, func() error, error) {
+	c := rc.Redis
+	if c.Addr == "" {
+		return nil, nil, errors.New("redis remote needs an addr")
+	}
+	if c.Password == "" {
+		c.Password = os.Getenv("REDIS_PASSWORD")
+	}
+	client, err := clients.NewRedisClient(&clients.RedisConfig{
+		Addr:               c.Addr,
+		Username:           c.Username,
+		Password:           c.Password,
+		DB:                 c.DB,
+		TLS:                c.TLS,
+		InsecureSkipVerify: c.Insecure,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	prefix := rc.Prefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return NewRedisStorage(client, RedisOptions{Prefix: prefix, TTL: c.TTL, MaxSize: c.MaxSize}), client.Close, nil
+}
+
+func openRcloneRemote(rc RemoteConfig) (Storage, error) {
+	c := rc.Rclone
+	if c.Remote == "" {
+		return nil, errors.New("rclone remote needs a remote")
+	}
+	remote := strings.TrimSuffix(c.Remote, "/")
+	if rc.Prefix != "" && !strings.HasSuffix(remote, ":") {
+		remote += "/"
+	}
+	return NewRcloneStorage(remote+rc.Prefix, RcloneOptions{
+		Binary:     c.Binary,
+		ConfigFile: c.Config,
+		Args:       c.Args,
+	}), nil
+}
+
+func openHTTPRemote(rc RemoteConfig) (Storage, error) {
+	c := rc.HTTP
+	if c.URL == "" {
+		return nil, errors.New("http remote needs a url")
+	}
+	base := strings.TrimSuffix(c.URL, "/") + "/" + strings.Trim(rc.Prefix, "/")
+	return NewHTTPStorage(base, HTTPOptions{Manifest: c.Manifest})
+}
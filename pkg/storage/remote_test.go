@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `
+remotes:
+  plain:
+    backend: local
+    prefix: archive
+    local:
+      dir: data
+    codec: none
+  secret:
+    backend: local
+    local:
+      dir: data
+    codec: zstd
+    crypter: aes
+    password_file: password
+  sealed:
+    backend: local
+    local:
+      dir: data
+    crypter: envelope
+    key_file: master.key
+`
+
+func writeTestConfig(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(testConfig), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "password"), []byte("s3cret\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "master.key"), []byte(strings.Repeat("ab", 32)), 0o600))
+	return dir
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := writeTestConfig(t)
+	c, err := LoadConfig(filepath.Join(dir, "config.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"plain", "sealed", "secret"}, c.Names())
+
+	rc, err := c.Remote("secret")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "data"), rc.Local.Dir)
+	assert.Equal(t, filepath.Join(dir, "password"), rc.PasswordFile)
+	ext, err := rc.WriteExtension()
+	require.NoError(t, err)
+	assert.Equal(t, ".zst.aes", ext)
+
+	_, err = c.Remote("missing")
+	assert.ErrorIs(t, err, ErrUnknownRemote)
+}
+
+func TestOpenRemote(t *testing.T) {
+	ctx := context.Background()
+	dir := writeTestConfig(t)
+	t.Setenv("STORECRYPT_CONFIG", filepath.Join(dir, "config.yaml"))
+
+	for name, stored := range map[string]string{
+		"plain":  "archive/a/b.txt",
+		"secret": "a/b.txt.zst.aes",
+		"sealed": "a/b.txt.gz.enc",
+	} {
+		t.Run(name, func(t *testing.T) {
+			r, err := OpenRemote(ctx, name)
+			require.NoError(t, err)
+			defer r.Close()
+
+			require.NoError(t, r.Put(ctx, "a/b.txt", strings.NewReader("payload-"+name)))
+			assert.FileExists(t, filepath.Join(dir, "data", filepath.FromSlash(stored)))
+
+			rc, err := r.Get(ctx, "a/b.txt")
+			require.NoError(t, err)
+			data, err := io.ReadAll(rc)
+			require.NoError(t, err)
+			require.NoError(t, rc.Close())
+			assert.Equal(t, "payload-"+name, string(data))
+			require.NoError(t, r.DeleteAll(ctx, "a"))
+		})
+	}
+}
+
+func TestRemoteConfig_Errors(t *testing.T) {
+	t.Setenv(DefaultPasswordEnv, "")
+
+	_, err := RemoteConfig{Backend: "ftp"}.Open(context.Background())
+	assert.ErrorContains(t, err, `unknown backend "ftp"`)
+
+	_, err = RemoteConfig{Backend: "local", Local: LocalRemote{Dir: t.TempDir()}, Codec: "lz4"}.Open(context.Background())
+	assert.ErrorContains(t, err, `unknown codec "lz4"`)
+
+	_, err = RemoteConfig{Backend: "local", Local: LocalRemote{Dir: t.TempDir()}, Crypter: "aes"}.Open(context.Background())
+	assert.ErrorContains(t, err, "$STORECRYPT_PASSWORD")
+
+	_, err = RemoteConfig{Crypter: "envelope"}.Algorithms()
+	assert.ErrorContains(t, err, "key_file")
+}
+
+func TestRemoteConfig_CodecLevel(t *testing.T) {
+	ctx := context.Background()
+	rc := RemoteConfig{Backend: "mem", Codec: "zstd", CodecLevel: 19}
+	alg, err := rc.Algorithms()
+	require.NoError(t, err)
+	assert.Equal(t, 19, alg.Zstd.Level)
+	assert.Zero(t, alg.Gzip.Level)
+
+	r, err := rc.Open(ctx)
+	require.NoError(t, err)
+	defer r.Close()
+	require.NoError(t, r.Put(ctx, "a", strings.NewReader("tuned")))
+	rc2, err := r.Get(ctx, "a")
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc2)
+	require.NoError(t, err)
+	require.NoError(t, rc2.Close())
+	assert.Equal(t, "tuned", string(data))
+
+	_, err = RemoteConfig{Backend: "mem", CodecLevel: 10}.Open(ctx)
+	assert.ErrorContains(t, err, "invalid compression level 10")
+	_, err = RemoteConfig{Codec: "none", CodecLevel: 1}.Algorithms()
+	assert.ErrorContains(t, err, "codec_level")
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+)
+
+// ResolveStrategy selects how VariadicStorage finds the stored variant of
+// a logical path.
+type ResolveStrategy int
+
+const (
+	// ResolveProbe issues one Exists per known extension, in priority
+	// order, stopping at the first hit (the default).
+	ResolveProbe ResolveStrategy = iota
+
+	// ResolveParallel issues the Exists calls for all extensions
+	// concurrently; latency is one round trip instead of up to N.
+	ResolveParallel
+
+	// ResolveList issues a single List of the directory of the logical
+	// path and picks its variants from the result. It pays off when
+	// directories are small or round trips are expensive; the whole
+	// directory, subdirectories included, is listed for every lookup.
+	ResolveList
+)
+
+// SetResolveCache enables an LRU cache of up to size resolved logical
+// names (size <= 0 disables it). Entries are invalidated by Put, Delete,
+// Rename and prefix deletes done through this VariadicStorage; changes made
+// by other writers are not seen until the entry is evicted.
+func (vs *VariadicStorage) SetResolveCache(size int) {
+	if size <= 0 {
+		vs.cache = nil
+		return
+	}
+	vs.cache = newResolveCache(size)
+}
+
+// lookupVariants returns the stored names of the existing variants of base,
+// in priority order. Unless all is set it may stop at the first hit.
+func (vs *VariadicStorage) lookupVariants(ctx context.Context, base string, all bool) ([]string, error) {
+	exts := vs.supportedExts()
+	switch vs.Resolve {
+	case ResolveList:
+		// List has directory semantics: list the parent, not base itself.
+		dir := path.Dir(base)
+		if dir == "." {
+			dir = ""
+		}
+		files, err := vs.Backend.List(ctx, dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		present := make(map[string]bool, len(files))
+		for _, f := range files {
+			present[f] = true
+		}
+		var found []string
+		for _, ext := range exts {
+			if present[base+ext] {
+				found = append(found, base+ext)
+			}
+		}
+		return found, nil
+
+	case ResolveParallel:
+		hits := make([]bool, len(exts))
+		errs := make([]error, len(exts))
+		var wg sync.WaitGroup
+		for i, ext := range exts {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				hits[i], errs[i] = vs.Backend.Exists(ctx, base+ext)
+			}()
+		}
+		wg.Wait()
+		var found []string
+		for i, ext := range exts {
+			if errs[i] != nil {
+				return nil, errs[i]
+			}
+			if hits[i] {
+				found = append(found, base+ext)
+			}
+		}
+		return found, nil
+
+	default:
+		var found []string
+		for _, ext := range exts {
+			ok, err := vs.Backend.Exists(ctx, base+ext)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			found = append(found, base+ext)
+			if !all {
+				break
+			}
+		}
+		return found, nil
+	}
+}
+
+func (vs *VariadicStorage) forgetResolved(base string) {
+	if vs.cache != nil {
+		vs.cache.remove(base)
+	}
+}
+
+func (vs *VariadicStorage) forgetResolvedUnder(prefix string) {
+	if vs.cache != nil {
+		vs.cache.removePrefix(prefix)
+	}
+}
+
+// resolveCache is a fixed-size LRU of logical name -> stored name.
+type resolveCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // front = most recently used
+	items map[string]*list.Element
+}
+
+type resolveEntry struct {
+	base   string
+	stored string
+}
+
+func newResolveCache(size int) *resolveCache {
+	return &resolveCache{
+		size:  size,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (c *resolveCache) get(base string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[base]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*resolveEntry).stored, true
+}
+
+func (c *resolveCache) put(base, stored string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[base]; ok {
+		el.Value.(*resolveEntry).stored = stored
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[base] = c.order.PushFront(&resolveEntry{base: base, stored: stored})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*resolveEntry).base)
+	}
+}
+
+func (c *resolveCache) remove(base string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[base]; ok {
+		c.order.Remove(el)
+		delete(c.items, base)
+	}
+}
+
+func (c *resolveCache) removePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prefix = strings.TrimSuffix(prefix, "/")
+	for base, el := range c.items {
+		if hasPathPrefix(base, prefix) {
+			c.order.Remove(el)
+			delete(c.items, base)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/aesgcm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// probeCountingStorage counts Exists/List round trips.
+type probeCountingStorage struct {
+	*InMemoryStorage
+	exists atomic.Int64
+	lists  atomic.Int64
+}
+
+func (p *probeCountingStorage) Exists(ctx context.Context, path string) (bool, error) {
+	p.exists.Add(1)
+	return p.InMemoryStorage.Exists(ctx, path)
+}
+
+func (p *probeCountingStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	p.lists.Add(1)
+	return p.InMemoryStorage.List(ctx, prefix)
+}
+
+func newResolveTestStorage(t *testing.T) (*probeCountingStorage, *VariadicStorage) {
+	t.Helper()
+	gzipPair := &CodecPair{
+		Compressor:   codec.GzipCompressor{},
+		Decompressor: codec.GzipDecompressor{},
+	}
+	zstdPair := &CodecPair{
+		Compressor:   codec.ZstdCompressor{},
+		Decompressor: codec.ZstdDecompressor{},
+	}
+	alg := Algorithms{Gzip: gzipPair, Zstd: zstdPair, AES: aesgcm.NewChunkedGCMCrypter("password")}
+
+	backend := &probeCountingStorage{InMemoryStorage: NewInMemoryStorage()}
+	vs, err := NewVariadicStorage(backend, alg, ".zst")
+	require.NoError(t, err)
+	return backend, vs
+}
+
+func TestVariadicStorage_ResolveStrategies(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name     string
+		strategy ResolveStrategy
+		exists   int64
+		lists    int64
+	}{
+		{"probe", ResolveProbe, 4, 0}, // .gz.aes, .zst.aes, .gz, .zst
+		{"parallel", ResolveParallel, 6, 0},
+		{"list", ResolveList, 0, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			backend, vs := newResolveTestStorage(t)
+			vs.Resolve = tc.strategy
+			require.NoError(t, vs.Put(ctx, "wal/0001", bytes.NewReader([]byte("x"))))
+			backend.Files["wal/00010"] = []byte("other object sharing the prefix")
+
+			ok, err := vs.Exists(ctx, "wal/0001")
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, tc.exists, backend.exists.Load())
+			assert.Equal(t, tc.lists, backend.lists.Load())
+
+			ok, err = vs.Exists(ctx, "wal/0002")
+			require.NoError(t, err)
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestVariadicStorage_ResolveCache(t *testing.T) {
+	ctx := context.Background()
+	backend, vs := newResolveTestStorage(t)
+	vs.SetResolveCache(2)
+
+	require.NoError(t, vs.Put(ctx, "wal/0001", bytes.NewReader([]byte("x"))))
+	for i := 0; i < 3; i++ {
+		ok, err := vs.Exists(ctx, "wal/0001")
+		require.NoError(t, err)
+		assert.True(t, ok)
+	}
+	assert.Equal(t, int64(4), backend.exists.Load(), "only the first lookup probes")
+
+	require.NoError(t, vs.Delete(ctx, "wal/0001"))
+	ok, err := vs.Exists(ctx, "wal/0001")
+	require.NoError(t, err)
+	assert.False(t, ok, "Delete invalidates the cached name")
+
+	// Eviction keeps the cache bounded.
+	for _, p := range []string{"a", "b", "c"} {
+		require.NoError(t, vs.Put(ctx, p+"/x", bytes.NewReader([]byte(p))))
+		_, err := vs.Exists(ctx, p+"/x")
+		require.NoError(t, err)
+	}
+	assert.Len(t, vs.cache.items, 2)
+	_, cached := vs.cache.get("a/x")
+	assert.False(t, cached)
+
+	require.NoError(t, vs.DeleteDir(ctx, "b"))
+	_, cached = vs.cache.get("b/x")
+	assert.False(t, cached)
+}
+
+func TestVariadicStorage_ResolveList(t *testing.T) {
+	ctx := context.Background()
+	local, err := NewLocal(&LocalStorageOpts{BaseDir: t.TempDir()})
+	require.NoError(t, err)
+	alg := Algorithms{Gzip: &CodecPair{Compressor: codec.GzipCompressor{}, Decompressor: codec.GzipDecompressor{}}}
+
+	for name, backend := range map[string]Storage{"mem": NewInMemoryStorage(), "local": local} {
+		t.Run(name, func(t *testing.T) {
+			vs, err := NewVariadicStorage(backend, alg, ".gz")
+			require.NoError(t, err)
+			vs.Resolve = ResolveList
+			for _, p := range []string{"wal/seg1", "top"} {
+				require.NoError(t, vs.Put(ctx, p, strings.NewReader(p)))
+				rc, err := vs.Get(ctx, p)
+				require.NoError(t, err)
+				got, err := io.ReadAll(rc)
+				require.NoError(t, err)
+				require.NoError(t, rc.Close())
+				assert.Equal(t, p, string(got))
+			}
+			_, err = vs.Get(ctx, "missing/seg1")
+			assert.ErrorIs(t, err, fs.ErrNotExist)
+		})
+	}
+}
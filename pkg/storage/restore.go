@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashmap-kz/storecrypt/pkg/fsync"
+)
+
+// restoreTempMarker tags the files Restore is writing; they are renamed
+// into place once complete.
+const restoreTempMarker = ".restore-"
+
+// RestoreReport summarises a Restore run.
+type RestoreReport struct {
+	Total    int   // objects selected by the filters
+	Restored int   // objects written by this run
+	Skipped  int   // objects already present locally (Resume)
+	Failed   int   // objects that could not be restored
+	Bytes    int64 // bytes written
+}
+
+// RestoreOptions tune Restore.
+type RestoreOptions struct {
+	// Prefix limits the restore to objects under it. Local files are
+	// named by their path relative to Prefix.
+	Prefix string
+
+	// Include, if set, restores only objects matching one of the
+	// patterns; Exclude skips objects matching one of them. Patterns use
+	// path.Match syntax and are matched against the path relative to
+	// Prefix. A pattern matching a directory selects everything below it,
+	// and one without a slash also matches any single name in the path,
+	// so "base", "wal/0000000100000002*" and "*.partial" all work.
+	Include []string
+	Exclude []string
+
+	// Concurrency is the number of objects restored at once (default 1).
+	Concurrency int
+
+	// Resume skips objects whose local file already exists. Files are
+	// written under a temporary name and renamed once complete, so an
+	// existing file is never a partial one.
+	Resume bool
+
+	// Fsync syncs every file and its directory before it is renamed into
+	// place.
+	Fsync bool
+
+	// Progress, if set, is called after every object with the running
+	// totals. Calls are serialised but may come from any worker.
+	Progress func(RestoreReport)
+}
+
+// Restore downloads the objects of src under opts.Prefix that pass the
+// filters into localDir. Failures are collected and reported together;
+// the returned report is always set.
+func Restore(ctx context.Context, src Storage, localDir string, opts RestoreOptions) (*RestoreReport, error) {
+	report := &RestoreReport{}
+	for _, p := range append(append([]string(nil), opts.Include...), opts.Exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return report, fmt.Errorf("restore pattern %q: %w", p, err)
+		}
+	}
+	files, err := src.List(ctx, opts.Prefix)
+	if err != nil {
+		return report, fmt.Errorf("list source: %w", err)
+	}
+	prefix := strings.Trim(opts.Prefix, "/")
+
+	type job struct{ remote, local string }
+	var (
+		todo []job
+		errs []error
+		seen = make(map[string]bool)
+	)
+	for _, f := range files {
+		if !hasPathPrefix(f, prefix) {
+			continue
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(f, prefix), "/")
+		if rel == "" || seen[rel] {
+			continue // e.g. several stored variants of one logical path
+		}
+		seen[rel] = true
+		if (len(opts.Include) > 0 && !matchAnyPattern(opts.Include, rel)) || matchAnyPattern(opts.Exclude, rel) {
+			continue
+		}
+		report.Total++
+		if !filepath.IsLocal(filepath.FromSlash(rel)) {
+			report.Failed++
+			errs = append(errs, fmt.Errorf("restore %q: path escapes the target directory", f))
+			continue
+		}
+		local := filepath.Join(localDir, filepath.FromSlash(rel))
+		if opts.Resume {
+			if _, err := os.Stat(local); err == nil {
+				report.Skipped++
+				continue
+			}
+		}
+		todo = append(todo, job{remote: f, local: local})
+	}
+	sort.Slice(todo, func(i, j int) bool { return todo[i].remote < todo[j].remote })
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	jobs := make(chan job)
+	for range max(opts.Concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				n, err := restoreObject(ctx, src, j.remote, j.local, opts.Fsync)
+				mu.Lock()
+				if err != nil {
+					report.Failed++
+					errs = append(errs, fmt.Errorf("restore %q: %w", j.remote, err))
+				} else {
+					report.Restored++
+					report.Bytes += n
+				}
+				if opts.Progress != nil {
+					opts.Progress(*report)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, j := range todo {
+		select {
+		case jobs <- j:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return report, errors.Join(errs...)
+}
+
+// restoreObject downloads one object into a temporary file next to local
+// and renames it into place.
+func restoreObject(ctx context.Context, src Storage, remote, local string, doFsync bool) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(local), 0o750); err != nil {
+		return 0, err
+	}
+	rc, err := src.Get(ctx, remote)
+	if err != nil {
+		return 0, err
+	}
+	tmp := local + restoreTempMarker + randomSuffix()
+	f, err := os.Create(tmp)
+	if err != nil {
+		_ = rc.Close()
+		return 0, err
+	}
+	n, err := CopyObject(f, rc)
+	if err == nil && doFsync {
+		err = fsync.Fsync(f)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, local)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return 0, err
+	}
+	if doFsync {
+		return n, fsync.FsyncDir(filepath.Dir(local))
+	}
+	return n, nil
+}
+
+// matchAnyPattern reports whether rel, or one of its parent directories,
+// matches one of patterns. Patterns without a slash are also matched
+// against every name in rel.
+func matchAnyPattern(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		for candidate := rel; candidate != "." && candidate != "/"; candidate = path.Dir(candidate) {
+			if ok, _ := path.Match(p, candidate); ok {
+				return true
+			}
+			if !strings.Contains(p, "/") {
+				if ok, _ := path.Match(p, path.Base(candidate)); ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
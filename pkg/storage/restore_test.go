@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestore_Filters(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	for _, p := range []string{
+		"pg/base/20240101/base.tar",
+		"pg/wal/000000010000000100000001",
+		"pg/wal/000000010000000200000001",
+		"pg/wal/000000010000000200000002",
+		"pg/wal/000000010000000200000002.partial",
+		"pgx/wal/000000010000000200000003",
+	} {
+		require.NoError(t, mem.Put(ctx, p, strings.NewReader(p)))
+	}
+
+	dir := t.TempDir()
+	report, err := Restore(ctx, mem, dir, RestoreOptions{
+		Prefix:      "pg",
+		Include:     []string{"base", "wal/0000000100000002*"},
+		Exclude:     []string{"*.partial"},
+		Concurrency: 3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Total)
+	assert.Equal(t, 3, report.Restored)
+
+	got, err := os.ReadFile(filepath.Join(dir, "wal", "000000010000000200000002"))
+	require.NoError(t, err)
+	assert.Equal(t, "pg/wal/000000010000000200000002", string(got))
+	assert.FileExists(t, filepath.Join(dir, "base", "20240101", "base.tar"))
+	assert.NoFileExists(t, filepath.Join(dir, "wal", "000000010000000100000001"))
+	assert.NoFileExists(t, filepath.Join(dir, "wal", "000000010000000200000002.partial"))
+	assert.NoFileExists(t, filepath.Join(dir, "wal", "000000010000000200000003"))
+
+	_, err = Restore(ctx, mem, dir, RestoreOptions{Prefix: "pg", Include: []string{"[bad"}})
+	assert.Error(t, err)
+}
+
+func TestRestore_Resume(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	for _, p := range []string{"wal/1", "wal/2", "wal/3"} {
+		require.NoError(t, mem.Put(ctx, p, strings.NewReader(p)))
+	}
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "1"), []byte("already here"), 0o600))
+	// Leftover of an interrupted run.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "2"+restoreTempMarker+"x"), []byte("wa"), 0o600))
+
+	report, err := Restore(ctx, mem, dir, RestoreOptions{Prefix: "wal", Resume: true, Fsync: true})
+	require.NoError(t, err)
+	assert.Equal(t, &RestoreReport{Total: 3, Restored: 2, Skipped: 1, Bytes: 10}, report)
+
+	got, err := os.ReadFile(filepath.Join(dir, "1"))
+	require.NoError(t, err)
+	assert.Equal(t, "already here", string(got))
+	got, err = os.ReadFile(filepath.Join(dir, "2"))
+	require.NoError(t, err)
+	assert.Equal(t, "wal/2", string(got))
+}
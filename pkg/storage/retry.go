@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"time"
+)
+
+const (
+	// DefaultRetryAttempts is the number of calls RetryStorage makes per
+	// operation when none is configured.
+	DefaultRetryAttempts = 3
+
+	// DefaultRetryDelay is the wait before the first retry when none is
+	// configured. It doubles with every attempt.
+	DefaultRetryDelay = 200 * time.Millisecond
+)
+
+// RetryStorage retries failed calls with exponential backoff. Errors
+// matching fs.ErrNotExist are final, and nothing is retried once ctx is
+// done.
+//
+// Put is retried only if its reader is an io.ReadSeeker (a file, a
+// bytes.Reader), which is rewound for every attempt; any other reader may
+// be partially consumed by a failed attempt and is passed through. PutFrom
+// retries any source by reopening it. WalkInfo is passed through, since fn
+// may have seen part of the listing. Get retries opening the object, not
+// reading it.
+type RetryStorage struct {
+	Backend  Storage
+	Attempts int
+	Delay    time.Duration
+}
+
+var (
+	_ Storage     = &RetryStorage{}
+	_ Stater      = &RetryStorage{}
+	_ Walker      = &RetryStorage{}
+	_ RangeReader = &RetryStorage{}
+	_ Pinger      = &RetryStorage{}
+	_ OpenPutter  = &RetryStorage{}
+)
+
+// NewRetryStorage creates a RetryStorage making up to attempts calls per
+// operation. Non-positive values select DefaultRetryAttempts and
+// DefaultRetryDelay.
+func NewRetryStorage(backend Storage, attempts int, delay time.Duration) *RetryStorage {
+	if attempts <= 0 {
+		attempts = DefaultRetryAttempts
+	}
+	if delay <= 0 {
+		delay = DefaultRetryDelay
+	}
+	return &RetryStorage{Backend: backend, Attempts: attempts, Delay: delay}
+}
+
+// retry calls fn until it succeeds, fails for good, or the attempts are
+// used up, and returns its last error.
+func (r *RetryStorage) retry(ctx context.Context, fn func() error) error {
+	delay := r.Delay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= r.Attempts || errors.Is(err, fs.ErrNotExist) || ctx.Err() != nil {
+			return err
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+		delay *= 2
+	}
+}
+
+func (r *RetryStorage) Put(ctx context.Context, remotePath string, rd io.Reader) error {
+	if rs, ok := rd.(io.ReadSeeker); ok {
+		if open, err := OpenSeeker(rs); err == nil {
+			return r.PutFrom(ctx, remotePath, open)
+		}
+	}
+	return r.Backend.Put(ctx, remotePath, rd)
+}
+
+// PutFrom reopens the source for every attempt.
+func (r *RetryStorage) PutFrom(ctx context.Context, remotePath string, open Opener) error {
+	return r.retry(ctx, func() error { return PutFrom(ctx, r.Backend, remotePath, open) })
+}
+
+func (r *RetryStorage) Get(ctx context.Context, remotePath string) (rc io.ReadCloser, err error) {
+	err = r.retry(ctx, func() error {
+		rc, err = r.Backend.Get(ctx, remotePath)
+		return err
+	})
+	return rc, err
+}
+
+func (r *RetryStorage) GetRange(ctx context.Context, remotePath string, offset, length int64) (rc io.ReadCloser, err error) {
+	err = r.retry(ctx, func() error {
+		rc, err = GetRange(ctx, r.Backend, remotePath, offset, length)
+		return err
+	})
+	return rc, err
+}
+
+func (r *RetryStorage) List(ctx context.Context, remotePath string) (names []string, err error) {
+	err = r.retry(ctx, func() error {
+		names, err = r.Backend.List(ctx, remotePath)
+		return err
+	})
+	return names, err
+}
+
+func (r *RetryStorage) ListInfo(ctx context.Context, remotePath string) (infos []FileInfo, err error) {
+	err = r.retry(ctx, func() error {
+		infos, err = r.Backend.ListInfo(ctx, remotePath)
+		return err
+	})
+	return infos, err
+}
+
+func (r *RetryStorage) WalkInfo(ctx context.Context, remotePath string, fn func(fi FileInfo) error) error {
+	return WalkInfo(ctx, r.Backend, remotePath, fn)
+}
+
+func (r *RetryStorage) Delete(ctx context.Context, remotePath string) error {
+	return r.retry(ctx, func() error { return r.Backend.Delete(ctx, remotePath) })
+}
+
+func (r *RetryStorage) DeleteAll(ctx context.Context, remotePath string) error {
+	return r.retry(ctx, func() error { return r.Backend.DeleteAll(ctx, remotePath) })
+}
+
+func (r *RetryStorage) DeleteDir(ctx context.Context, remotePath string) error {
+	return r.retry(ctx, func() error { return r.Backend.DeleteDir(ctx, remotePath) })
+}
+
+func (r *RetryStorage) DeleteAllBulk(ctx context.Context, paths []string) error {
+	return r.retry(ctx, func() error { return r.Backend.DeleteAllBulk(ctx, paths) })
+}
+
+func (r *RetryStorage) Exists(ctx context.Context, remotePath string) (ok bool, err error) {
+	err = r.retry(ctx, func() error {
+		ok, err = r.Backend.Exists(ctx, remotePath)
+		return err
+	})
+	return ok, err
+}
+
+func (r *RetryStorage) Stat(ctx context.Context, remotePath string) (fi FileInfo, err error) {
+	err = r.retry(ctx, func() error {
+		fi, err = StatObject(ctx, r.Backend, remotePath)
+		return err
+	})
+	return fi, err
+}
+
+func (r *RetryStorage) ListTopLevelDirs(ctx context.Context, prefix string) (dirs map[string]bool, err error) {
+	err = r.retry(ctx, func() error {
+		dirs, err = r.Backend.ListTopLevelDirs(ctx, prefix)
+		return err
+	})
+	return dirs, err
+}
+
+func (r *RetryStorage) Rename(ctx context.Context, oldRemotePath, newRemotePath string) error {
+	return r.retry(ctx, func() error { return r.Backend.Rename(ctx, oldRemotePath, newRemotePath) })
+}
+
+func (r *RetryStorage) Ping(ctx context.Context) error {
+	return r.retry(ctx, func() error { return Ping(ctx, r.Backend) })
+}
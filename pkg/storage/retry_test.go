@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flaky fails the first failures calls of Get and List.
+type flaky struct {
+	*InMemoryStorage
+	failures int32
+	calls    atomic.Int32
+}
+
+func (f *flaky) fail() error {
+	if f.calls.Add(1) <= f.failures {
+		return errors.New("connection reset")
+	}
+	return nil
+}
+
+func (f *flaky) Get(ctx context.Context, path string) (io.ReadCloser, error) {
+	if err := f.fail(); err != nil {
+		return nil, err
+	}
+	return f.InMemoryStorage.Get(ctx, path)
+}
+
+func (f *flaky) List(ctx context.Context, path string) ([]string, error) {
+	if err := f.fail(); err != nil {
+		return nil, err
+	}
+	return f.InMemoryStorage.List(ctx, path)
+}
+
+func TestRetryStorage(t *testing.T) {
+	ctx := context.Background()
+	backend := &flaky{InMemoryStorage: NewInMemoryStorage(), failures: 2}
+	putWAL(t, backend, 1)
+	rs := NewRetryStorage(backend, 3, time.Millisecond)
+
+	assert.Equal(t, "wal/0000", readAll(t, rs, "wal/0000"))
+	assert.Equal(t, int32(3), backend.calls.Load())
+
+	backend.calls.Store(0)
+	backend.failures = 5
+	_, err := rs.List(ctx, "wal")
+	assert.ErrorContains(t, err, "connection reset")
+	assert.Equal(t, int32(3), backend.calls.Load(), "gives up after the attempts")
+}
+
+func TestRetryStorage_NotExistIsFinal(t *testing.T) {
+	backend := &flaky{InMemoryStorage: NewInMemoryStorage()}
+	_, err := NewRetryStorage(backend, 5, time.Millisecond).Get(context.Background(), "missing")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	assert.Equal(t, int32(1), backend.calls.Load())
+}
+
+func TestRetryStorage_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	backend := &flaky{InMemoryStorage: NewInMemoryStorage(), failures: 10}
+	rs := NewRetryStorage(backend, 10, time.Hour)
+	go func() {
+		for backend.calls.Load() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	_, err := rs.List(ctx, "wal")
+	require.Error(t, err)
+	assert.Equal(t, int32(1), backend.calls.Load())
+}
+
+// cutPut reads a few bytes of the first failures Puts and then fails them.
+type cutPut struct {
+	*InMemoryStorage
+	failures int
+	calls    int
+}
+
+func (c *cutPut) Put(ctx context.Context, path string, r io.Reader) error {
+	if c.calls++; c.calls <= c.failures {
+		_, _ = io.ReadFull(r, make([]byte, 3))
+		return errors.New("connection reset")
+	}
+	return c.InMemoryStorage.Put(ctx, path, r)
+}
+
+func TestRetryStorage_PutRewinds(t *testing.T) {
+	ctx := context.Background()
+	backend := &cutPut{InMemoryStorage: NewInMemoryStorage(), failures: 2}
+	rs := NewRetryStorage(backend, 3, time.Millisecond)
+
+	require.NoError(t, rs.Put(ctx, "wal/0001", bytes.NewReader([]byte("segment"))))
+	assert.Equal(t, "segment", string(backend.Files["wal/0001"]))
+	assert.Equal(t, 3, backend.calls)
+
+	// Readers that cannot be rewound get a single attempt.
+	backend.calls, backend.failures = 0, 1
+	err := rs.Put(ctx, "wal/0003", io.MultiReader(strings.NewReader("segment")))
+	assert.ErrorContains(t, err, "connection reset")
+	assert.Equal(t, 1, backend.calls)
+
+	backend.calls, backend.failures = 0, 2
+	src := []byte("0123456789")
+	require.NoError(t, PutFrom(ctx, rs, "wal/0004", OpenReaderAt(bytes.NewReader(src), 6)))
+	assert.Equal(t, "012345", string(backend.Files["wal/0004"]))
+	assert.Equal(t, 3, backend.calls)
+}
@@ -6,8 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
-	"path/filepath"
+	"path"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -26,6 +27,16 @@ const (
 type S3Options struct {
 	PartSizeBytes int64
 	Concurrency   int
+
+	// ListShards, if set, makes List and ListInfo fan out: the keys under
+	// the listed prefix are split at "<prefix>/<shard>" for each shard
+	// (e.g. HexListShards) and the ranges are paginated concurrently, then
+	// merged in key order. Keys outside all shards are still listed.
+	ListShards []string
+
+	// ListConcurrency bounds the ranges listed at once; zero lists all of
+	// them at once.
+	ListConcurrency int
 }
 
 type s3Storage struct {
@@ -33,9 +44,24 @@ type s3Storage struct {
 	bucket   string
 	prefix   string
 	uploader *transfermanager.Client
+
+	listShards           []string
+	listShardConcurrency int
 }
 
-var _ Storage = &s3Storage{}
+var (
+	_ Storage           = &s3Storage{}
+	_ Stater            = &s3Storage{}
+	_ Walker            = &s3Storage{}
+	_ RangeReader       = &s3Storage{}
+	_ Pinger            = &s3Storage{}
+	_ DeleteAllReporter = &s3Storage{}
+	_ MultipartUploader = &s3Storage{}
+	_ Holder            = &s3Storage{}
+	_ OpenPutter        = &s3Storage{}
+	_ BulkRenamer       = &s3Storage{}
+	_ ExclusivePutter   = &s3Storage{}
+)
 
 func NewS3Storage(client *s3.Client, bucket, prefix string) Storage {
 	return NewS3StorageWithOptions(client, bucket, prefix, S3Options{})
@@ -61,15 +87,30 @@ func NewS3StorageWithOptions(client *s3.Client, bucket, prefix string, opts S3Op
 	})
 
 	return &s3Storage{
-		client:   client,
-		bucket:   bucket,
-		prefix:   filepath.ToSlash(strings.TrimPrefix(prefix, "/")),
-		uploader: tmClient,
+		client:               client,
+		bucket:               bucket,
+		prefix:               strings.Trim(prefix, "/"),
+		uploader:             tmClient,
+		listShards:           opts.ListShards,
+		listShardConcurrency: opts.ListConcurrency,
 	}
 }
 
-func (s *s3Storage) fullPath(path string) string {
-	return filepath.ToSlash(filepath.Join(s.prefix, path))
+// fullPath checks a remote path and maps it to an object key under the
+// prefix.
+func (s *s3Storage) fullPath(p string) (string, error) {
+	clean, err := CleanPath(p)
+	if err != nil {
+		return "", err
+	}
+	key := path.Join(s.prefix, clean)
+	if len(key) > MaxS3KeyLength {
+		return "", &InvalidPathError{
+			Path:   p,
+			Reason: fmt.Sprintf("key of %d bytes exceeds %d", len(key), MaxS3KeyLength),
+		}
+	}
+	return key, nil
 }
 
 // CreateUploader creates a new S3 uploader with the given part size and concurrency.
@@ -112,129 +153,257 @@ func ChooseUploadPartSize(size int64) int64 {
 }
 
 func (s *s3Storage) Put(ctx context.Context, remotePath string, r io.Reader) error {
-	remotePath = s.fullPath(remotePath)
+	remotePath, err := s.fullPath(remotePath)
+	if err != nil {
+		return err
+	}
 
 	// If we know the size, use transfermanager with computed part size.
 	if f, ok := isSeekable(r); ok {
 		st, err := f.Stat()
 		if err == nil {
-			size := st.Size()
-			partSize := ChooseUploadPartSize(size)
-			uploader := CreateUploader(s.client, partSize, DefaultS3Conc)
-
 			if _, err := f.Seek(0, io.SeekStart); err != nil {
 				return fmt.Errorf("seek file for %q: %w", remotePath, err)
 			}
-
-			_, err = uploader.UploadObject(ctx, &transfermanager.UploadObjectInput{
-				Bucket: aws.String(s.bucket),
-				Key:    aws.String(remotePath),
-				Body:   f,
-			})
-			if err != nil {
-				return fmt.Errorf("s3 upload %q: %w", remotePath, err)
-			}
-			return nil
+			return s.uploadSized(ctx, remotePath, f, st.Size())
 		}
 	}
 
 	// Unknown-size stream: use manual multipart with a conservative part size.
 	// 256 MiB gives ~2.44 TiB before hitting 10k parts.
+	_, r = trackPutProgress(ctx, remotePath, r)
 	return s.putMultipartStream(ctx, remotePath, r, 256*1024*1024)
 }
 
+// PutIfAbsent implements ExclusivePutter with a conditional PutObject
+// (If-None-Match: *). The body is buffered, so it is meant for small
+// objects such as leases and markers.
+func (s *s3Storage) PutIfAbsent(ctx context.Context, remotePath string, r io.Reader) error {
+	key, err := s.fullPath(remotePath)
+	if err != nil {
+		return err
+	}
+	_, r = trackPutProgress(ctx, remotePath, r)
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("read body for %q: %w", key, err)
+	}
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		IfNoneMatch: aws.String("*"),
+	})
+	if err != nil {
+		return fmt.Errorf("put if absent %q: %w", key, s3Error(err))
+	}
+	return nil
+}
+
+// PutFrom uploads a source that opens as an io.ReaderAt and io.Seeker (a
+// file, OpenReaderAt) with transfermanager, which reads each part from it
+// and can resend a part without buffering it. Other sources go to Put.
+func (s *s3Storage) PutFrom(ctx context.Context, remotePath string, open Opener) error {
+	key, err := s.fullPath(remotePath)
+	if err != nil {
+		return err
+	}
+	rc, err := open()
+	if err != nil {
+		return fmt.Errorf("open source for %q: %w", key, err)
+	}
+	defer rc.Close()
+
+	ra, ok := rc.(interface {
+		io.ReaderAt
+		io.Seeker
+	})
+	if !ok {
+		return s.Put(ctx, remotePath, rc)
+	}
+	size, err := ra.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = ra.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return fmt.Errorf("seek source for %q: %w", key, err)
+	}
+	return s.uploadSized(ctx, key, io.NewSectionReader(ra, 0, size), size)
+}
+
+// uploadSized uploads a body of known size to key with a part size chosen
+// for it.
+func (s *s3Storage) uploadSized(ctx context.Context, key string, body io.Reader, size int64) error {
+	uploader := CreateUploader(s.client, ChooseUploadPartSize(size), DefaultS3Conc)
+
+	// Body stays as it is unless progress reporting was requested.
+	_, body = trackPutProgress(ctx, key, body)
+
+	_, err := uploader.UploadObject(ctx, &transfermanager.UploadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	})
+	if err != nil {
+		return fmt.Errorf("s3 upload %q: %w", key, s3Error(err))
+	}
+	return nil
+}
+
 func (s *s3Storage) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
-	remotePath = s.fullPath(remotePath)
+	remotePath, err := s.fullPath(remotePath)
+	if err != nil {
+		return nil, err
+	}
 
 	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(remotePath),
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to read object from S3: %w", err)
+		return nil, fmt.Errorf("failed to read object from S3: %w", s3Error(err))
 	}
-	return out.Body, nil
+	return trackGetProgress(ctx, remotePath, out.Body, aws.ToInt64(out.ContentLength)), nil
 }
 
-func (s *s3Storage) List(ctx context.Context, remotePath string) ([]string, error) {
-	fullPath := s.fullPath(remotePath)
-	var objects []string
-
-	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+func (s *s3Storage) GetRange(ctx context.Context, remotePath string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	key, err := s.fullPath(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length > 0 {
+		byteRange = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	}
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
-		Prefix: aws.String(fullPath),
+		Key:    aws.String(key),
+		Range:  aws.String(byteRange),
 	})
-
-	// Iterate over pages of results
-	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get page: %w", err)
+	if err != nil {
+		var re interface{ ErrorCode() string }
+		if errors.As(err, &re) && re.ErrorCode() == "InvalidRange" {
+			// The range starts at or past the end of the object.
+			return io.NopCloser(bytes.NewReader(nil)), nil
 		}
+		return nil, fmt.Errorf("failed to read object range from S3: %w", s3Error(err))
+	}
+	return out.Body, nil
+}
 
-		for _, obj := range page.Contents {
-			rel, err := filepath.Rel(s.prefix, *obj.Key)
-			if err != nil {
-				return nil, err
-			}
-			objects = append(objects, filepath.ToSlash(rel))
-		}
+func (s *s3Storage) List(ctx context.Context, remotePath string) ([]string, error) {
+	fullPath, err := s.fullPath(remotePath)
+	if err != nil {
+		return nil, err
 	}
+	var objects []string
 
+	err = s.listObjects(ctx, fullPath, func(obj s3types.Object) error {
+		if rel, ok := relKey(s.prefix, aws.ToString(obj.Key)); ok && !strings.HasSuffix(rel, "/") {
+			objects = append(objects, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 	return objects, nil
 }
 
 func (s *s3Storage) ListInfo(ctx context.Context, remotePath string) ([]FileInfo, error) {
-	fullPath := s.fullPath(remotePath)
+	fullPath, err := s.fullPath(remotePath)
+	if err != nil {
+		return nil, err
+	}
 	var objects []FileInfo
 
-	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
-		Bucket: aws.String(s.bucket),
-		Prefix: aws.String(fullPath),
-	})
-
-	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get page: %w", err)
-		}
-
-		// Iterate over pages of results
-		for _, obj := range page.Contents {
-			key := aws.ToString(obj.Key)
+	err = s.listObjects(ctx, fullPath, s.infoFunc(ctx, remotePath, func(fi FileInfo) error {
+		objects = append(objects, fi)
+		return nil
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
 
-			// Normalize S3 keys using strings, not filepath
-			rel := strings.TrimPrefix(key, s.prefix)
-			rel = strings.TrimPrefix(rel, "/")
+// WalkInfo pages through the objects one listing page at a time. List
+// shards are not used, as they need the ranges buffered to keep the order.
+func (s *s3Storage) WalkInfo(ctx context.Context, remotePath string, fn func(fi FileInfo) error) error {
+	fullPath, err := s.fullPath(remotePath)
+	if err != nil {
+		return err
+	}
+	err = s.listRange(ctx, fullPath, "", "", s.infoFunc(ctx, remotePath, fn))
+	return walkResult(err)
+}
 
-			objects = append(objects, FileInfo{
-				Path:    filepath.ToSlash(rel),
-				ModTime: aws.ToTime(obj.LastModified),
-				Size:    aws.ToInt64(obj.Size),
-			})
+// infoFunc adapts fn to listed objects. Placeholder objects ("dir/") are
+// not files; with WithDirs they and the directories implied by keys are
+// reported as directory entries. S3 filters by prefix only, so the list
+// filters are applied to each page as it arrives.
+func (s *s3Storage) infoFunc(ctx context.Context, remotePath string, fn func(fi FileInfo) error) func(obj s3types.Object) error {
+	opts := ListOptionsFromContext(ctx)
+	var dirs *impliedDirs
+	if opts.IncludeDirs {
+		root, _ := CleanPath(remotePath) // checked by fullPath
+		dirs = newImpliedDirs(root)
+	}
+	return func(obj s3types.Object) error {
+		fi, ok := s.fileInfo(obj)
+		if !ok {
+			return nil
 		}
+		if dirs != nil {
+			if err := dirs.add(fi.Path, fn); err != nil {
+				return err
+			}
+		}
+		if strings.HasSuffix(fi.Path, "/") || !opts.Match(fi) {
+			return nil
+		}
+		return fn(fi)
 	}
+}
 
-	return objects, nil
+// fileInfo describes a listed object; ok is false for a key outside the
+// prefix, such as "pg2/x" listed for the prefix "pg".
+func (s *s3Storage) fileInfo(obj s3types.Object) (FileInfo, bool) {
+	rel, ok := relKey(s.prefix, aws.ToString(obj.Key))
+	return FileInfo{
+		Path:    rel,
+		ModTime: aws.ToTime(obj.LastModified),
+		Size:    aws.ToInt64(obj.Size),
+	}, ok
 }
 
 func (s *s3Storage) Delete(ctx context.Context, remotePath string) error {
-	fullPath := s.fullPath(remotePath)
+	fullPath, err := s.fullPath(remotePath)
+	if err != nil {
+		return err
+	}
 
-	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+	_, err = s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: &s.bucket,
 		Key:    &fullPath,
 	})
-	return err
+	return s3Error(err)
 }
 
 func (s *s3Storage) DeleteAll(ctx context.Context, remotePath string) error {
+	_, err := s.deleteAllVersions(ctx, remotePath)
+	return err
+}
+
+func (s *s3Storage) DeleteAllReport(ctx context.Context, remotePath string) (DeleteReport, error) {
 	return s.deleteAllVersions(ctx, remotePath)
 }
 
 func (s *s3Storage) DeleteDir(ctx context.Context, remotePath string) error {
-	err := s.deleteAllVersions(ctx, remotePath)
-	if err != nil {
+	if _, err := s.deleteAllVersions(ctx, remotePath); err != nil {
 		return err
 	}
 	return s.Delete(ctx, remotePath)
@@ -244,8 +413,15 @@ func (s *s3Storage) DeleteAllBulk(ctx context.Context, paths []string) error {
 	return s.deleteAllVersionsBulk(ctx, paths)
 }
 
-func (s *s3Storage) deleteAllVersions(ctx context.Context, remotePath string) error {
-	prefix := s.fullPath(remotePath)
+// deleteAllVersions removes every version and delete marker below
+// remotePath, in batches of up to 1000 keys. A failed batch or key does
+// not stop the others.
+func (s *s3Storage) deleteAllVersions(ctx context.Context, remotePath string) (DeleteReport, error) {
+	var report DeleteReport
+	prefix, err := s.fullPath(remotePath)
+	if err != nil {
+		return report, err
+	}
 	if prefix != "" && !endsWithSlash(prefix) {
 		prefix += "/"
 	}
@@ -259,7 +435,7 @@ func (s *s3Storage) deleteAllVersions(ctx context.Context, remotePath string) er
 	for paginator.HasMorePages() {
 		page, err := paginator.NextPage(ctx)
 		if err != nil {
-			return fmt.Errorf("list object versions: %w", err)
+			return report, fmt.Errorf("list object versions: %w", s3Error(err))
 		}
 
 		for i := range page.Versions {
@@ -278,12 +454,15 @@ func (s *s3Storage) deleteAllVersions(ctx context.Context, remotePath string) er
 	}
 
 	for i := 0; i < len(toDelete); i += 1000 {
+		if err := ctx.Err(); err != nil {
+			return report, errors.Join(err, report.Err())
+		}
 		end := i + 1000
 		if end > len(toDelete) {
 			end = len(toDelete)
 		}
 
-		_, err := s.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		out, err := s.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
 			Bucket: &s.bucket,
 			Delete: &s3types.Delete{
 				Objects: toDelete[i:end],
@@ -291,18 +470,46 @@ func (s *s3Storage) deleteAllVersions(ctx context.Context, remotePath string) er
 			},
 		})
 		if err != nil {
-			return fmt.Errorf("delete versions: %w", err)
+			err = fmt.Errorf("delete versions: %w", s3Error(err))
+			for _, id := range toDelete[i:end] {
+				report.fail(s.remoteKey(aws.ToString(id.Key)), err)
+			}
+			continue
+		}
+		report.Deleted += end - i - len(out.Errors)
+		for _, e := range out.Errors {
+			report.fail(s.remoteKey(aws.ToString(e.Key)), s3DeleteError(e))
 		}
 	}
 
-	return nil
+	return report, report.Err()
+}
+
+// remoteKey maps a bucket key below the prefix to its remote path.
+func (s *s3Storage) remoteKey(key string) string {
+	if rel, ok := relKey(s.prefix, key); ok {
+		return rel
+	}
+	return key
+}
+
+// s3DeleteError is the error of one key DeleteObjects did not delete.
+func s3DeleteError(e s3types.Error) error {
+	err := fmt.Errorf("%s: %s", aws.ToString(e.Code), aws.ToString(e.Message))
+	if v := aws.ToString(e.VersionId); v != "" {
+		err = fmt.Errorf("version %s: %w", v, err)
+	}
+	return classify(err, s3CodeKind(aws.ToString(e.Code)))
 }
 
 func (s *s3Storage) deleteAllVersionsBulk(ctx context.Context, paths []string) error {
 	var objectsToDelete []s3types.ObjectIdentifier
 
 	for _, path := range paths {
-		prefix := s.fullPath(path)
+		prefix, err := s.fullPath(path)
+		if err != nil {
+			return err
+		}
 
 		paginator := s3.NewListObjectVersionsPaginator(s.client, &s3.ListObjectVersionsInput{
 			Bucket: &s.bucket,
@@ -312,7 +519,7 @@ func (s *s3Storage) deleteAllVersionsBulk(ctx context.Context, paths []string) e
 		for paginator.HasMorePages() {
 			page, err := paginator.NextPage(ctx)
 			if err != nil {
-				return fmt.Errorf("list object versions for %q: %w", prefix, err)
+				return fmt.Errorf("list object versions for %q: %w", prefix, s3Error(err))
 			}
 			for i := range page.Versions {
 				version := page.Versions[i]
@@ -347,7 +554,7 @@ func (s *s3Storage) deleteAllVersionsBulk(ctx context.Context, paths []string) e
 			},
 		})
 		if err != nil {
-			return fmt.Errorf("delete objects batch %d–%d: %w", i, end, err)
+			return fmt.Errorf("delete objects batch %d–%d: %w", i, end, s3Error(err))
 		}
 	}
 
@@ -355,37 +562,63 @@ func (s *s3Storage) deleteAllVersionsBulk(ctx context.Context, paths []string) e
 }
 
 func (s *s3Storage) Exists(ctx context.Context, remotePath string) (bool, error) {
-	remotePath = s.fullPath(remotePath)
+	key, err := s.fullPath(remotePath)
+	if err != nil {
+		return false, err
+	}
 
-	_, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+	_, err = s.client.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(s.bucket),
-		Key:    aws.String(remotePath),
+		Key:    aws.String(key),
 	})
 	if err != nil {
 		var nf *s3types.NotFound
 		if errors.As(err, &nf) {
 			return false, nil
 		}
-		return false, err
+		return false, s3Error(err)
 	}
 	return true, nil // S3 has no dirs, so it's a valid file
 }
 
+func (s *s3Storage) Stat(ctx context.Context, remotePath string) (FileInfo, error) {
+	key, err := s.fullPath(remotePath)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var nf *s3types.NotFound
+		if errors.As(err, &nf) {
+			return FileInfo{}, fmt.Errorf("stat %q: %w", remotePath, fs.ErrNotExist)
+		}
+		return FileInfo{}, fmt.Errorf("stat %q: %w", remotePath, s3Error(err))
+	}
+	return FileInfo{
+		Path:    remotePath,
+		ModTime: aws.ToTime(out.LastModified),
+		Size:    aws.ToInt64(out.ContentLength),
+	}, nil
+}
+
 func (s *s3Storage) ListTopLevelDirs(ctx context.Context, prefix string) (map[string]bool, error) {
-	remotePath := s.fullPath(prefix)
-	if !endsWithSlash(remotePath) {
-		remotePath += "/"
+	remotePath, err := s.fullPath(prefix)
+	if err != nil {
+		return nil, err
 	}
 
 	input := &s3.ListObjectsV2Input{
 		Bucket:    aws.String(s.bucket),
 		Delimiter: aws.String("/"), // Groups results by prefix (like top-level directories)
-		Prefix:    aws.String(remotePath),
+		Prefix:    aws.String(dirPrefix(remotePath)),
 	}
 
 	output, err := s.client.ListObjectsV2(ctx, input)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list objects in bucket: %w", err)
+		return nil, fmt.Errorf("failed to list objects in bucket: %w", s3Error(err))
 	}
 
 	// Extract top-level prefixes (directories)
@@ -394,20 +627,23 @@ func (s *s3Storage) ListTopLevelDirs(ctx context.Context, prefix string) (map[st
 		if prefix.Prefix == nil {
 			continue
 		}
-		prefixClean := strings.TrimSuffix(*prefix.Prefix, "/")
-		rel, err := filepath.Rel(s.prefix, prefixClean)
-		if err != nil {
-			return nil, err
+		if rel, ok := relKey(s.prefix, strings.TrimSuffix(*prefix.Prefix, "/")); ok {
+			prefixes[rel] = true
 		}
-		prefixes[filepath.ToSlash(rel)] = true
 	}
 
 	return prefixes, nil
 }
 
 func (s *s3Storage) Rename(ctx context.Context, oldRemotePath, newRemotePath string) error {
-	srcKey := s.fullPath(oldRemotePath)
-	dstKey := s.fullPath(newRemotePath)
+	srcKey, err := s.fullPath(oldRemotePath)
+	if err != nil {
+		return err
+	}
+	dstKey, err := s.fullPath(newRemotePath)
+	if err != nil {
+		return err
+	}
 
 	if srcKey == dstKey {
 		return nil
@@ -416,13 +652,13 @@ func (s *s3Storage) Rename(ctx context.Context, oldRemotePath, newRemotePath str
 	// Copy source object to destination key
 	copySource := s.bucket + "/" + srcKey
 
-	_, err := s.client.CopyObject(ctx, &s3.CopyObjectInput{
+	_, err = s.client.CopyObject(ctx, &s3.CopyObjectInput{
 		Bucket:     aws.String(s.bucket),
 		CopySource: aws.String(copySource),
 		Key:        aws.String(dstKey),
 	})
 	if err != nil {
-		return fmt.Errorf("copy object %q -> %q: %w", srcKey, dstKey, err)
+		return fmt.Errorf("copy object %q -> %q: %w", srcKey, dstKey, s3Error(err))
 	}
 
 	// Delete source object (only latest version if bucket is versioned)
@@ -431,12 +667,91 @@ func (s *s3Storage) Rename(ctx context.Context, oldRemotePath, newRemotePath str
 		Key:    aws.String(srcKey),
 	})
 	if err != nil {
-		return fmt.Errorf("delete source after copy %q: %w", srcKey, err)
+		return fmt.Errorf("delete source after copy %q: %w", srcKey, s3Error(err))
 	}
 
 	return nil
 }
 
+// RenameBulk copies the objects concurrently and then deletes the copied
+// sources with DeleteObjects, in batches of up to 1000 keys.
+func (s *s3Storage) RenameBulk(ctx context.Context, pairs []RenamePair, concurrency int) ([]RenameResult, error) {
+	if concurrency <= 0 {
+		concurrency = DefaultRenameConcurrency
+	}
+	results := newRenameResults(pairs)
+	srcKeys := make([]string, len(pairs))
+	notStarted := runConcurrently(ctx, len(pairs), concurrency, func(i int) {
+		srcKeys[i], results[i].Err = s.copyForRename(ctx, pairs[i])
+	})
+	for _, i := range notStarted {
+		results[i].Err = ctx.Err()
+	}
+
+	// A key may be the source of several copies.
+	copied := make(map[string][]int)
+	var toDelete []s3types.ObjectIdentifier
+	for i, key := range srcKeys {
+		if results[i].Err != nil || key == "" {
+			continue
+		}
+		if _, ok := copied[key]; !ok {
+			toDelete = append(toDelete, s3types.ObjectIdentifier{Key: aws.String(key)})
+		}
+		copied[key] = append(copied[key], i)
+	}
+	fail := func(key string, err error) {
+		for _, i := range copied[key] {
+			results[i].Err = fmt.Errorf("delete source after copy %q: %w", key, err)
+		}
+	}
+	for i := 0; i < len(toDelete); i += 1000 {
+		end := min(i+1000, len(toDelete))
+		out, err := s.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: &s.bucket,
+			Delete: &s3types.Delete{
+				Objects: toDelete[i:end],
+				Quiet:   aws.Bool(true),
+			},
+		})
+		if err != nil {
+			for _, id := range toDelete[i:end] {
+				fail(aws.ToString(id.Key), s3Error(err))
+			}
+			continue
+		}
+		for _, e := range out.Errors {
+			fail(aws.ToString(e.Key), s3DeleteError(e))
+		}
+	}
+	return results, renameErr(results)
+}
+
+// copyForRename copies the source of p to its destination and returns the
+// source key, or "" if both are the same object.
+func (s *s3Storage) copyForRename(ctx context.Context, p RenamePair) (string, error) {
+	srcKey, err := s.fullPath(p.From)
+	if err != nil {
+		return "", err
+	}
+	dstKey, err := s.fullPath(p.To)
+	if err != nil {
+		return "", err
+	}
+	if srcKey == dstKey {
+		return "", nil
+	}
+	_, err = s.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(s.bucket),
+		CopySource: aws.String(s.bucket + "/" + srcKey),
+		Key:        aws.String(dstKey),
+	})
+	if err != nil {
+		return "", fmt.Errorf("copy object %q -> %q: %w", srcKey, dstKey, s3Error(err))
+	}
+	return srcKey, nil
+}
+
 func endsWithSlash(s string) bool {
 	return s != "" && s[len(s)-1] == '/'
 }
@@ -461,7 +776,7 @@ func (s *s3Storage) putMultipartStream(ctx context.Context, remotePath string, r
 		Key:    aws.String(remotePath),
 	})
 	if err != nil {
-		return fmt.Errorf("create multipart upload %q: %w", remotePath, err)
+		return fmt.Errorf("create multipart upload %q: %w", remotePath, s3Error(err))
 	}
 
 	uploadID := aws.ToString(createOut.UploadId)
@@ -512,7 +827,7 @@ func (s *s3Storage) putMultipartStream(ctx context.Context, remotePath string, r
 				ContentLength: aws.Int64(int64(n)),
 			})
 			if err != nil {
-				return abort(fmt.Errorf("upload part %d for %q: %w", partNumber, remotePath, err))
+				return abort(fmt.Errorf("upload part %d for %q: %w", partNumber, remotePath, s3Error(err)))
 			}
 
 			completedParts = append(completedParts, s3types.CompletedPart{
@@ -536,7 +851,7 @@ func (s *s3Storage) putMultipartStream(ctx context.Context, remotePath string, r
 			Body:   bytes.NewReader(nil),
 		})
 		if err != nil {
-			return abort(fmt.Errorf("put empty object %q: %w", remotePath, err))
+			return abort(fmt.Errorf("put empty object %q: %w", remotePath, s3Error(err)))
 		}
 		return nil
 	}
@@ -550,8 +865,214 @@ func (s *s3Storage) putMultipartStream(ctx context.Context, remotePath string, r
 		},
 	})
 	if err != nil {
-		return abort(fmt.Errorf("complete multipart upload %q: %w", remotePath, err))
+		return abort(fmt.Errorf("complete multipart upload %q: %w", remotePath, s3Error(err)))
+	}
+
+	return nil
+}
+
+// MultipartUploader: native S3 multipart uploads.
+
+func (s *s3Storage) InitUpload(ctx context.Context, remotePath string) (string, error) {
+	key, err := s.fullPath(remotePath)
+	if err != nil {
+		return "", err
+	}
+	out, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", fmt.Errorf("create multipart upload %q: %w", key, s3Error(err))
+	}
+	return aws.ToString(out.UploadId), nil
+}
+
+func (s *s3Storage) UploadPart(ctx context.Context, remotePath, uploadID string, number int, r io.Reader) (Part, error) {
+	key, err := s.fullPath(remotePath)
+	if err != nil {
+		return Part{}, err
+	}
+	if number < 1 || number > MaxUploadParts {
+		return Part{}, fmt.Errorf("part number %d out of range 1..%d", number, MaxUploadParts)
+	}
+	// The SDK needs the length of the body up front.
+	body, ok := r.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return Part{}, fmt.Errorf("read part %d of %q: %w", number, key, err)
+		}
+		body = bytes.NewReader(data)
+	}
+	size, err := body.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = body.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return Part{}, fmt.Errorf("seek part %d of %q: %w", number, key, err)
+	}
+	out, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(key),
+		UploadId:      aws.String(uploadID),
+		PartNumber:    aws.Int32(int32(number)),
+		Body:          body,
+		ContentLength: aws.Int64(size),
+	})
+	if err != nil {
+		return Part{}, fmt.Errorf("upload part %d for %q: %w", number, key, s3Error(err))
 	}
+	return Part{Number: number, ETag: aws.ToString(out.ETag), Size: size}, nil
+}
 
+func (s *s3Storage) CompleteUpload(ctx context.Context, remotePath, uploadID string, parts []Part) error {
+	key, err := s.fullPath(remotePath)
+	if err != nil {
+		return err
+	}
+	sorted, err := sortedParts(parts)
+	if err != nil {
+		return fmt.Errorf("complete multipart upload %q: %w", key, err)
+	}
+	completed := make([]s3types.CompletedPart, len(sorted))
+	for i, p := range sorted {
+		completed[i] = s3types.CompletedPart{
+			ETag:       aws.String(p.ETag),
+			PartNumber: aws.Int32(int32(p.Number)),
+		}
+	}
+	_, err = s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return fmt.Errorf("complete multipart upload %q: %w", key, s3Error(err))
+	}
+	return nil
+}
+
+func (s *s3Storage) AbortUpload(ctx context.Context, remotePath, uploadID string) error {
+	key, err := s.fullPath(remotePath)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	if err != nil {
+		return fmt.Errorf("abort multipart upload %q: %w", key, s3Error(err))
+	}
+	return nil
+}
+
+// Holder: S3 Object Lock legal holds, on the current version. The bucket
+// must have Object Lock enabled.
+
+func (s *s3Storage) Hold(ctx context.Context, remotePath string) error {
+	return s.putLegalHold(ctx, remotePath, s3types.ObjectLockLegalHoldStatusOn)
+}
+
+func (s *s3Storage) ReleaseHold(ctx context.Context, remotePath string) error {
+	return s.putLegalHold(ctx, remotePath, s3types.ObjectLockLegalHoldStatusOff)
+}
+
+func (s *s3Storage) putLegalHold(ctx context.Context, remotePath string, status s3types.ObjectLockLegalHoldStatus) error {
+	key, err := s.fullPath(remotePath)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObjectLegalHold(ctx, &s3.PutObjectLegalHoldInput{
+		Bucket:    aws.String(s.bucket),
+		Key:       aws.String(key),
+		LegalHold: &s3types.ObjectLockLegalHold{Status: status},
+	})
+	if err != nil {
+		return fmt.Errorf("put legal hold %q: %w", key, s3Error(err))
+	}
+	return nil
+}
+
+// Held reports false for missing objects and for buckets without Object
+// Lock, where nothing can be held.
+func (s *s3Storage) Held(ctx context.Context, remotePath string) (bool, error) {
+	key, err := s.fullPath(remotePath)
+	if err != nil {
+		return false, err
+	}
+	out, err := s.client.GetObjectLegalHold(ctx, &s3.GetObjectLegalHoldInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var ae interface{ ErrorCode() string }
+		if errors.As(err, &ae) && ae.ErrorCode() == "NoSuchObjectLockConfiguration" {
+			return false, nil
+		}
+		if err = s3Error(err); errors.Is(err, ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("get legal hold %q: %w", key, err)
+	}
+	return out.LegalHold != nil && out.LegalHold.Status == s3types.ObjectLockLegalHoldStatusOn, nil
+}
+
+// Ping checks that the bucket exists and is accessible with HeadBucket.
+func (s *s3Storage) Ping(ctx context.Context) error {
+	_, err := s.client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(s.bucket),
+	})
+	return s3Error(err)
+}
+
+// s3Error makes an S3 error match the storage error it stands for, by its
+// error code or, for responses without a body such as HeadObject, by the
+// HTTP status.
+func s3Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	var ae interface{ ErrorCode() string }
+	if errors.As(err, &ae) {
+		if kind := s3CodeKind(ae.ErrorCode()); kind != nil {
+			return classify(err, kind)
+		}
+	}
+	var re interface{ HTTPStatusCode() int }
+	if errors.As(err, &re) {
+		switch re.HTTPStatusCode() {
+		case 404:
+			return classify(err, ErrNotExist)
+		case 403:
+			return classify(err, ErrPermission)
+		case 429:
+			return classify(err, ErrThrottled)
+		case 500, 502, 503, 504:
+			return classify(err, ErrUnavailable)
+		}
+	}
+	return err
+}
+
+// s3CodeKind maps an S3 error code to the storage error it matches, or nil.
+func s3CodeKind(code string) error {
+	switch code {
+	case "NoSuchKey", "NotFound", "NoSuchBucket", "NoSuchUpload":
+		return ErrNotExist
+	case "AccessDenied", "Forbidden", "InvalidAccessKeyId", "SignatureDoesNotMatch":
+		return ErrPermission
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		// A conditional write lost to an existing or concurrent object.
+		return ErrAlreadyExists
+	case "SlowDown", "Throttling", "ThrottlingException", "TooManyRequests",
+		"RequestLimitExceeded", "RequestThrottled":
+		return ErrThrottled
+	case "ServiceUnavailable", "InternalError":
+		return ErrUnavailable
+	}
 	return nil
 }
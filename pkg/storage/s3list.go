@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// HexListShards splits listings by the first hex digit of the names under
+// the listed prefix, which suits WAL segments and content-addressed keys.
+var HexListShards = []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "a", "b", "c", "d", "e", "f"}
+
+// listObjects calls fn for every object below fullPath, in key order, and
+// stops at the first error fn returns. With list shards configured, key
+// ranges are listed concurrently.
+func (s *s3Storage) listObjects(ctx context.Context, fullPath string, fn func(obj s3types.Object) error) error {
+	if len(s.listShards) == 0 {
+		return s.listRange(ctx, fullPath, "", "", fn)
+	}
+
+	// Boundaries split the keys into ranges (b[i-1], b[i]], the first one
+	// open below and the last one above, so that together they cover every
+	// key, including those outside the shards.
+	bounds := make([]string, 0, len(s.listShards))
+	for _, shard := range s.listShards {
+		bounds = append(bounds, dirPrefix(fullPath)+shard)
+	}
+	sort.Strings(bounds)
+
+	ranges := make([][]s3types.Object, len(bounds)+1)
+	errs := make([]error, len(ranges))
+	sem := make(chan struct{}, s.listConcurrency(len(ranges)))
+	var wg sync.WaitGroup
+	for i := range ranges {
+		after, upTo := "", ""
+		if i > 0 {
+			after = bounds[i-1]
+		}
+		if i < len(bounds) {
+			upTo = bounds[i]
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				return
+			}
+			defer func() { <-sem }()
+			errs[i] = s.listRange(ctx, fullPath, after, upTo, func(obj s3types.Object) error {
+				ranges[i] = append(ranges[i], obj)
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	for _, objs := range ranges {
+		for _, obj := range objs {
+			if err := fn(obj); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *s3Storage) listConcurrency(ranges int) int {
+	if s.listShardConcurrency > 0 {
+		return min(s.listShardConcurrency, ranges)
+	}
+	return ranges
+}
+
+// listRange lists the keys below fullPath that sort after after (if set)
+// and not after upTo (if set).
+func (s *s3Storage) listRange(ctx context.Context, fullPath, after, upTo string, fn func(obj s3types.Object) error) error {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(dirPrefix(fullPath)),
+	}
+	if after != "" {
+		input.StartAfter = aws.String(after)
+	}
+	paginator := s3.NewListObjectsV2Paginator(s.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get page: %w", s3Error(err))
+		}
+		for _, obj := range page.Contents {
+			if upTo != "" && aws.ToString(obj.Key) > upTo {
+				return nil
+			}
+			if err := fn(obj); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,410 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Seekable objects (TransformingStorage.SeekableFrameSize) are laid out as
+//
+//	ObjectHeader (with HeaderFlagSeekable)
+//	u32 len | frame_0 | ... | u32 len | frame_n
+//	u32 0
+//	index
+//	u64 index length | "SCIX"
+//
+// Every frame is the compressed and encrypted form of
+//
+//	u64 plaintext offset | u8 last | up to frame size plaintext bytes
+//
+// so it can be decoded on its own but not moved, dropped or cut short
+// unnoticed. The index, transformed the same way, records the frame size,
+// the plaintext size, where the first frame starts and the stored length
+// of every frame.
+
+var seekableIndexMagic = []byte("SCIX")
+
+// seekableFooterLen is the size of the footer closing a seekable object.
+const seekableFooterLen = 8 + 4
+
+// ErrNotSeekable is returned by OpenSeekable for objects that were not
+// written as seekable.
+var ErrNotSeekable = errors.New("object is not seekable")
+
+type seekableIndex struct {
+	frameSize int64
+	size      int64 // plaintext size
+	dataStart int64 // stored offset of the first frame's length prefix
+	lengths   []int64
+	offsets   []int64 // stored offset of every frame, derived
+}
+
+func (idx *seekableIndex) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, idx.frameSize)
+	_ = binary.Write(&buf, binary.BigEndian, idx.size)
+	_ = binary.Write(&buf, binary.BigEndian, idx.dataStart)
+	_ = binary.Write(&buf, binary.BigEndian, uint32(len(idx.lengths)))
+	_ = binary.Write(&buf, binary.BigEndian, idx.lengths)
+	return buf.Bytes(), nil
+}
+
+func parseSeekableIndex(b []byte) (*seekableIndex, error) {
+	r := bytes.NewReader(b)
+	idx := &seekableIndex{}
+	var count uint32
+	for _, v := range []any{&idx.frameSize, &idx.size, &idx.dataStart, &count} {
+		if err := binary.Read(r, binary.BigEndian, v); err != nil {
+			return nil, fmt.Errorf("read chunk index: %w", err)
+		}
+	}
+	if idx.frameSize <= 0 || idx.size < 0 || count == 0 || int64(r.Len()) != 8*int64(count) {
+		return nil, errors.New("malformed chunk index")
+	}
+	idx.lengths = make([]int64, count)
+	if err := binary.Read(r, binary.BigEndian, idx.lengths); err != nil {
+		return nil, fmt.Errorf("read chunk index: %w", err)
+	}
+	idx.offsets = make([]int64, count)
+	pos := idx.dataStart
+	for i, n := range idx.lengths {
+		idx.offsets[i] = pos + 4
+		pos += 4 + n
+	}
+	return idx, nil
+}
+
+// writeSeekable writes r to w in the seekable layout.
+func (ts *TransformingStorage) writeSeekable(w io.Writer, hdr *ObjectHeader, r io.Reader) error {
+	hb, err := hdr.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(hb); err != nil {
+		return err
+	}
+	idx := &seekableIndex{frameSize: int64(ts.SeekableFrameSize), dataStart: int64(len(hb))}
+	br := bufio.NewReader(r)
+	buf := make([]byte, ts.SeekableFrameSize)
+	for last := false; !last; {
+		n, err := io.ReadFull(br, buf)
+		switch {
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			last = true
+		case err != nil:
+			return err
+		default:
+			if _, err := br.Peek(1); errors.Is(err, io.EOF) {
+				last = true
+			} else if err != nil {
+				return err
+			}
+		}
+		prefix := make([]byte, 9)
+		binary.BigEndian.PutUint64(prefix, uint64(idx.size))
+		if last {
+			prefix[8] = 1
+		}
+		frame, err := ts.encodeBlob(append(prefix, buf[:n]...))
+		if err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, uint32(len(frame))); err != nil {
+			return err
+		}
+		if _, err := w.Write(frame); err != nil {
+			return err
+		}
+		idx.size += int64(n)
+		idx.lengths = append(idx.lengths, int64(len(frame)))
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(0)); err != nil {
+		return err
+	}
+	plain, _ := idx.MarshalBinary()
+	encoded, err := ts.encodeBlob(plain)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(encoded); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, uint64(len(encoded))); err != nil {
+		return err
+	}
+	_, err = w.Write(seekableIndexMagic)
+	return err
+}
+
+// encodeBlob compresses and encrypts b with the configured transforms.
+func (ts *TransformingStorage) encodeBlob(b []byte) ([]byte, error) {
+	r, err := ts.wrapWrite(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// decodeBlob reverses encodeBlob using the transforms named by hdr.
+func (ts *TransformingStorage) decodeBlob(b []byte, hdr *ObjectHeader) ([]byte, error) {
+	rc, err := ts.wrapRead(bytes.NewReader(b), hdr)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// decodeFrame decodes a stored frame into its plaintext offset, last flag
+// and data.
+func (ts *TransformingStorage) decodeFrame(b []byte, hdr *ObjectHeader, p string) (int64, bool, []byte, error) {
+	plain, err := ts.decodeBlob(b, hdr)
+	if err != nil {
+		return 0, false, nil, err
+	}
+	if len(plain) < 9 {
+		return 0, false, nil, &IntegrityError{Path: p, Reason: "short frame"}
+	}
+	return int64(binary.BigEndian.Uint64(plain)), plain[8] == 1, plain[9:], nil
+}
+
+// seekableReader decodes a seekable object front to back for Get.
+type seekableReader struct {
+	ts     *TransformingStorage
+	r      io.Reader
+	c      io.Closer
+	hdr    *ObjectHeader
+	path   string
+	offset int64
+	last   bool
+	out    []byte
+	err    error
+}
+
+func (sr *seekableReader) Read(p []byte) (int, error) {
+	for len(sr.out) == 0 {
+		if sr.err != nil {
+			return 0, sr.err
+		}
+		sr.err = sr.next()
+	}
+	n := copy(p, sr.out)
+	sr.out = sr.out[n:]
+	return n, nil
+}
+
+// next decodes the following frame into out, returning io.EOF once the
+// end marker after the last frame has been read.
+func (sr *seekableReader) next() error {
+	var n uint32
+	if err := binary.Read(sr.r, binary.BigEndian, &n); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return &IntegrityError{Path: sr.path, Reason: "truncated stream"}
+		}
+		return err
+	}
+	if sr.last || n == 0 {
+		if sr.last && n == 0 {
+			return io.EOF
+		}
+		return &IntegrityError{Path: sr.path, Reason: "misplaced end of frames"}
+	}
+	frame := make([]byte, n)
+	if _, err := io.ReadFull(sr.r, frame); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return &IntegrityError{Path: sr.path, Reason: "truncated stream"}
+		}
+		return err
+	}
+	off, last, data, err := sr.ts.decodeFrame(frame, sr.hdr, sr.path)
+	if err != nil {
+		return err
+	}
+	if off != sr.offset {
+		return &IntegrityError{Path: sr.path, Reason: fmt.Sprintf("frame at offset %d, expected %d", off, sr.offset)}
+	}
+	sr.offset += int64(len(data))
+	sr.last = last
+	sr.out = data
+	return nil
+}
+
+func (sr *seekableReader) Close() error {
+	return sr.c.Close()
+}
+
+// SeekableObject gives random access to an object written with
+// TransformingStorage.SeekableFrameSize: only the frames overlapping a
+// read are fetched and decoded. It implements io.ReaderAt and is safe for
+// concurrent use.
+type SeekableObject struct {
+	ts     *TransformingStorage
+	ctx    context.Context
+	path   string
+	stored string
+	hdr    *ObjectHeader
+	idx    *seekableIndex
+
+	mu         sync.Mutex
+	cachedIdx  int // frame held in cachedData, -1 if none
+	cachedData []byte
+}
+
+var _ io.ReaderAt = (*SeekableObject)(nil)
+
+// OpenSeekable reads the header and chunk index of a seekable object. The
+// context is used for every read made through the returned object. Objects
+// not written as seekable yield an error matching ErrNotSeekable.
+func (ts *TransformingStorage) OpenSeekable(ctx context.Context, path string) (*SeekableObject, error) {
+	stored := ts.encodePath(path)
+	fi, err := StatObject(ctx, ts.Backend, stored)
+	if err != nil {
+		return nil, err
+	}
+	o := &SeekableObject{ts: ts, ctx: ctx, path: path, stored: stored, cachedIdx: -1}
+
+	head, err := o.readStored(0, min(maxObjectHeaderLen, fi.Size), false)
+	if err != nil {
+		return nil, err
+	}
+	hdr, _, ok := parseObjectHeader(head)
+	if !ok || hdr.Flags&HeaderFlagSeekable == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrNotSeekable, path)
+	}
+	if hdr.Version != ObjectHeaderVersion {
+		return nil, fmt.Errorf("%w: version %d", ErrUnsupportedHeader, hdr.Version)
+	}
+	if _, _, err := ts.transformsFromHeader(hdr); err != nil {
+		return nil, err
+	}
+	o.hdr = hdr
+
+	if fi.Size < seekableFooterLen {
+		return nil, &IntegrityError{Path: path, Reason: "missing chunk index"}
+	}
+	footer, err := o.readStored(fi.Size-seekableFooterLen, seekableFooterLen, true)
+	if err != nil {
+		return nil, err
+	}
+	idxLen := int64(binary.BigEndian.Uint64(footer))
+	if !bytes.Equal(footer[8:], seekableIndexMagic) || idxLen <= 0 || idxLen > fi.Size-seekableFooterLen {
+		return nil, &IntegrityError{Path: path, Reason: "missing chunk index"}
+	}
+	encoded, err := o.readStored(fi.Size-seekableFooterLen-idxLen, idxLen, true)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := ts.decodeBlob(encoded, hdr)
+	if err != nil {
+		return nil, fmt.Errorf("decode chunk index of %q: %w", path, err)
+	}
+	if o.idx, err = parseSeekableIndex(plain); err != nil {
+		return nil, &IntegrityError{Path: path, Reason: err.Error()}
+	}
+	return o, nil
+}
+
+// Size returns the plaintext size of the object.
+func (o *SeekableObject) Size() int64 {
+	return o.idx.size
+}
+
+// ReadAt implements io.ReaderAt.
+func (o *SeekableObject) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("read %q: negative offset %d", o.path, off)
+	}
+	n := 0
+	for n < len(p) && off < o.idx.size {
+		i := int(off / o.idx.frameSize)
+		data, err := o.frame(i)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p[n:], data[off-int64(i)*o.idx.frameSize:])
+		n += c
+		off += int64(c)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// frame returns the plaintext of frame i, verifying its position.
+func (o *SeekableObject) frame(i int) ([]byte, error) {
+	o.mu.Lock()
+	if o.cachedIdx == i {
+		data := o.cachedData
+		o.mu.Unlock()
+		return data, nil
+	}
+	o.mu.Unlock()
+
+	if i >= len(o.idx.lengths) {
+		return nil, &IntegrityError{Path: o.path, Reason: fmt.Sprintf("frame %d missing from chunk index", i)}
+	}
+	stored, err := o.readStored(o.idx.offsets[i], o.idx.lengths[i], true)
+	if err != nil {
+		return nil, err
+	}
+	off, last, data, err := o.ts.decodeFrame(stored, o.hdr, o.path)
+	if err != nil {
+		return nil, err
+	}
+	wantOff := int64(i) * o.idx.frameSize
+	wantLen := min(o.idx.frameSize, o.idx.size-wantOff)
+	if off != wantOff || last != (i == len(o.idx.lengths)-1) || int64(len(data)) != wantLen {
+		return nil, &IntegrityError{Path: o.path, Reason: fmt.Sprintf("frame %d does not match the chunk index", i)}
+	}
+
+	o.mu.Lock()
+	o.cachedIdx, o.cachedData = i, data
+	o.mu.Unlock()
+	return data, nil
+}
+
+// readStored reads a range of the stored object. With exact set, a short
+// read is reported as a truncated object.
+func (o *SeekableObject) readStored(offset, length int64, exact bool) ([]byte, error) {
+	rc, err := GetRange(o.ctx, o.ts.Backend, o.stored, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	if exact && int64(len(b)) != length {
+		return nil, &IntegrityError{Path: o.path, Reason: "truncated object"}
+	}
+	return b, nil
+}
+
+// GetRange implements RangeReader. For seekable objects only the frames
+// covering the range are fetched and decoded; other objects are decoded
+// from the start, discarding the bytes before offset.
+func (ts *TransformingStorage) GetRange(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("get range %q: negative offset %d", path, offset)
+	}
+	o, err := ts.OpenSeekable(ctx, path)
+	if errors.Is(err, ErrNotSeekable) {
+		return getRangeByGet(ctx, ts, path, offset, length)
+	}
+	if err != nil {
+		return nil, err
+	}
+	offset = min(offset, o.Size())
+	if length < 0 || length > o.Size()-offset {
+		length = o.Size() - offset
+	}
+	return io.NopCloser(io.NewSectionReader(o, offset, length)), nil
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/hashmap-kz/storecrypt/pkg/crypters"
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSeekableStorage(t *testing.T) (*TransformingStorage, *InMemoryStorage) {
+	t.Helper()
+	mem := NewInMemoryStorage()
+	return &TransformingStorage{
+		Backend:           mem,
+		Crypter:           crypters.NewEnvelope(newEnvelopeKey(t, "k1")),
+		Compressor:        codec.GzipCompressor{},
+		Decompressor:      codec.GzipDecompressor{},
+		SeekableFrameSize: 1000,
+	}, mem
+}
+
+func readRange(t *testing.T, st Storage, p string, off, n int64) []byte {
+	t.Helper()
+	rc, err := GetRange(context.Background(), st, p, off, n)
+	require.NoError(t, err)
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return b
+}
+
+func TestTransformingStorage_Seekable(t *testing.T) {
+	ctx := context.Background()
+	ts, _ := newSeekableStorage(t)
+
+	data := make([]byte, 10_500)
+	_, _ = rand.Read(data)
+	require.NoError(t, ts.Put(ctx, "base/big", bytes.NewReader(data)))
+
+	// Full reads still work.
+	rc, err := ts.Get(ctx, "base/big")
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, data, got)
+
+	o, err := ts.OpenSeekable(ctx, "base/big")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), o.Size())
+
+	for _, r := range [][2]int64{{0, 10}, {995, 10}, {2000, 1000}, {10_400, 500}, {0, -1}, {20_000, 5}} {
+		want := data[min(r[0], int64(len(data))):]
+		if r[1] >= 0 && r[1] < int64(len(want)) {
+			want = want[:r[1]]
+		}
+		assert.Equal(t, want, readRange(t, ts, "base/big", r[0], r[1]), "range %v", r)
+	}
+
+	// Empty and frame-aligned objects.
+	for _, n := range []int{0, 1000, 3000} {
+		require.NoError(t, ts.Put(ctx, "base/n", bytes.NewReader(data[:n])))
+		assert.Equal(t, data[:n], readRange(t, ts, "base/n", 0, -1), "size %d", n)
+	}
+}
+
+func TestTransformingStorage_Seekable_DecodesOnlyNeededFrames(t *testing.T) {
+	ctx := context.Background()
+	ts, mem := newSeekableStorage(t)
+
+	data := bytes.Repeat([]byte("0123456789"), 500)
+	require.NoError(t, ts.Put(ctx, "obj", bytes.NewReader(data)))
+	o, err := ts.OpenSeekable(ctx, "obj")
+	require.NoError(t, err)
+
+	// Corrupt the last frame: ranges before it are unaffected.
+	stored := mem.Files["obj.gz.enc"]
+	last := len(o.idx.lengths) - 1
+	stored[o.idx.offsets[last]+o.idx.lengths[last]-1] ^= 0xff
+
+	assert.Equal(t, data[100:200], readRange(t, ts, "obj", 100, 100))
+
+	rc, err := ts.GetRange(ctx, "obj", 4500, 100)
+	require.NoError(t, err)
+	_, err = io.ReadAll(rc)
+	assert.Error(t, err)
+	_ = rc.Close()
+}
+
+func TestTransformingStorage_Seekable_Tampering(t *testing.T) {
+	ctx := context.Background()
+	ts, mem := newSeekableStorage(t)
+
+	data := bytes.Repeat([]byte("x"), 2500)
+	require.NoError(t, ts.Put(ctx, "obj", bytes.NewReader(data)))
+	o, err := ts.OpenSeekable(ctx, "obj")
+	require.NoError(t, err)
+	stored := mem.Files["obj.gz.enc"]
+
+	// Dropping the last frame and everything after it is noticed by Get.
+	mem.Files["obj.gz.enc"] = append([]byte(nil), stored[:o.idx.offsets[2]-4]...)
+	mem.Files["obj.gz.enc"] = append(mem.Files["obj.gz.enc"], 0, 0, 0, 0)
+	rc, err := ts.Get(ctx, "obj")
+	require.NoError(t, err)
+	_, err = io.ReadAll(rc)
+	assert.ErrorIs(t, err, ErrIntegrity)
+	_ = rc.Close()
+
+	// So is a truncated index.
+	mem.Files["obj.gz.enc"] = stored[:len(stored)-1]
+	_, err = ts.OpenSeekable(ctx, "obj")
+	assert.ErrorIs(t, err, ErrIntegrity)
+}
+
+func TestTransformingStorage_GetRange_NotSeekable(t *testing.T) {
+	ctx := context.Background()
+	ts := &TransformingStorage{
+		Backend:      NewInMemoryStorage(),
+		Compressor:   codec.GzipCompressor{},
+		Decompressor: codec.GzipDecompressor{},
+	}
+	require.NoError(t, ts.Put(ctx, "obj", bytes.NewReader([]byte("hello world"))))
+
+	_, err := ts.OpenSeekable(ctx, "obj")
+	assert.ErrorIs(t, err, ErrNotSeekable)
+	assert.Equal(t, []byte("world"), readRange(t, ts, "obj", 6, -1))
+	assert.Equal(t, []byte("lo"), readRange(t, ts, "obj", 3, 2))
+}
@@ -2,71 +2,141 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path"
-	"path/filepath"
-	"strings"
 
 	"github.com/pkg/sftp"
 )
 
 type sftpStorage struct {
-	client  *sftp.Client
-	baseDir string
+	client            *sftp.Client
+	baseDir           string
+	deleteConcurrency int
 }
 
-var _ Storage = &sftpStorage{}
+var (
+	_ Storage           = &sftpStorage{}
+	_ Stater            = &sftpStorage{}
+	_ Walker            = &sftpStorage{}
+	_ RangeReader       = &sftpStorage{}
+	_ Pinger            = &sftpStorage{}
+	_ DeleteAllReporter = &sftpStorage{}
+)
+
+// SFTPOption configures NewSFTPStorage.
+type SFTPOption func(*sftpStorage)
+
+// WithSFTPDeleteConcurrency bounds the paths DeleteAllBulk removes at
+// once; zero means DefaultDeleteConcurrency. The requests share the
+// client's connection, so little is gained beyond its request limit.
+func WithSFTPDeleteConcurrency(n int) SFTPOption {
+	return func(s *sftpStorage) { s.deleteConcurrency = n }
+}
 
-func NewSFTPStorage(client *sftp.Client, remoteDir string) Storage {
-	return &sftpStorage{
+func NewSFTPStorage(client *sftp.Client, remoteDir string, opts ...SFTPOption) Storage {
+	baseDir := path.Clean(remoteDir)
+	if baseDir == "." {
+		baseDir = ""
+	}
+	s := &sftpStorage{
 		client:  client,
-		baseDir: strings.TrimSuffix(remoteDir, "/"),
+		baseDir: baseDir,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
-func (s *sftpStorage) fullPath(p string) string {
-	return filepath.ToSlash(filepath.Join(s.baseDir, filepath.Clean(p)))
+// fullPath checks a remote path and maps it into the base directory.
+func (s *sftpStorage) fullPath(p string) (string, error) {
+	clean, err := CleanPath(p)
+	if err != nil {
+		return "", err
+	}
+	if err := checkNameLength(clean); err != nil {
+		return "", err
+	}
+	if full := path.Join(s.baseDir, clean); full != "" {
+		return full, nil
+	}
+	return ".", nil
 }
 
-func (s *sftpStorage) Put(_ context.Context, remotePath string, r io.Reader) error {
-	fullPath := s.fullPath(remotePath)
+func (s *sftpStorage) Put(ctx context.Context, remotePath string, r io.Reader) error {
+	fullPath, err := s.fullPath(remotePath)
+	if err != nil {
+		return err
+	}
+	_, r = trackPutProgress(ctx, remotePath, r)
 
 	// Ensure directory exists
 	dir := path.Dir(fullPath)
 	if err := s.client.MkdirAll(dir); err != nil {
-		return fmt.Errorf("mkdir: %w", err)
+		return fmt.Errorf("mkdir: %w", sftpError(err))
 	}
 
 	// Open file for writing
 	f, err := s.client.Create(fullPath)
 	if err != nil {
-		return fmt.Errorf("sftp create: %w", err)
+		return fmt.Errorf("sftp create: %w", sftpError(err))
 	}
 	defer f.Close()
 
 	_, err = io.Copy(f, r)
-	return err
+	return sftpError(err)
 }
 
-func (s *sftpStorage) Get(_ context.Context, remotePath string) (io.ReadCloser, error) {
-	fullPath := s.fullPath(remotePath)
+func (s *sftpStorage) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	fullPath, err := s.fullPath(remotePath)
+	if err != nil {
+		return nil, err
+	}
 	f, err := s.client.Open(fullPath)
 	if err != nil {
-		return nil, fmt.Errorf("sftp open: %w", err)
+		return nil, fmt.Errorf("sftp open: %w", sftpError(err))
 	}
-	return f, nil
+	total := int64(-1)
+	if st, err := f.Stat(); err == nil {
+		total = st.Size()
+	}
+	return trackGetProgress(ctx, remotePath, f, total), nil
+}
+
+func (s *sftpStorage) GetRange(_ context.Context, remotePath string, offset, length int64) (io.ReadCloser, error) {
+	fullPath, err := s.fullPath(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	f, err := s.client.Open(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("sftp open: %w", sftpError(err))
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return limitRange(f, length), nil
 }
 
 func (s *sftpStorage) List(_ context.Context, remotePath string) ([]string, error) {
-	fullPath := s.fullPath(remotePath)
+	fullPath, err := s.fullPath(remotePath)
+	if err != nil {
+		return nil, err
+	}
 	var result []string
 
 	walker := s.client.Walk(fullPath)
 	for walker.Step() {
 		if err := walker.Err(); err != nil {
-			return nil, fmt.Errorf("error walking directory: %w", err)
+			if walker.Path() == fullPath && errors.Is(err, fs.ErrNotExist) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("error walking directory: %w", sftpError(err))
 		}
 		stat := walker.Stat()
 		if stat == nil {
@@ -75,11 +145,7 @@ func (s *sftpStorage) List(_ context.Context, remotePath string) ([]string, erro
 		if stat.IsDir() {
 			continue
 		}
-		if walker.Path() != fullPath {
-			rel, err := filepath.Rel(s.baseDir, walker.Path())
-			if err != nil {
-				return nil, err
-			}
+		if rel, ok := relKey(s.baseDir, walker.Path()); ok && walker.Path() != fullPath {
 			result = append(result, rel)
 		}
 	}
@@ -87,124 +153,216 @@ func (s *sftpStorage) List(_ context.Context, remotePath string) ([]string, erro
 	return result, nil
 }
 
-func (s *sftpStorage) ListInfo(_ context.Context, remotePath string) ([]FileInfo, error) {
-	fullPath := s.fullPath(remotePath)
-	var result []FileInfo
+func (s *sftpStorage) ListInfo(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	return collectInfo(ctx, s, remotePath)
+}
+
+func (s *sftpStorage) WalkInfo(ctx context.Context, remotePath string, fn func(fi FileInfo) error) error {
+	fullPath, err := s.fullPath(remotePath)
+	if err != nil {
+		return err
+	}
+	opts := ListOptionsFromContext(ctx)
 
 	walker := s.client.Walk(fullPath)
 	for walker.Step() {
 		if err := walker.Err(); err != nil {
-			return nil, fmt.Errorf("error walking directory: %w", err)
+			if walker.Path() == fullPath && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return fmt.Errorf("error walking directory: %w", sftpError(err))
+		}
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 		stat := walker.Stat()
 		if stat == nil {
 			continue
 		}
-		if stat.IsDir() {
+		if stat.IsDir() && !opts.IncludeDirs {
 			continue
 		}
-		if walker.Path() != fullPath {
-			rel, err := filepath.Rel(s.baseDir, walker.Path())
+		if rel, ok := relKey(s.baseDir, walker.Path()); ok && walker.Path() != fullPath {
+			fi := FileInfo{Path: rel, ModTime: stat.ModTime(), IsDir: stat.IsDir()}
+			if !fi.IsDir {
+				fi.Size = stat.Size()
+			}
+			if !opts.Match(fi) {
+				continue
+			}
+			err := fn(fi)
 			if err != nil {
-				return nil, err
+				return walkResult(err)
 			}
-			result = append(result, FileInfo{
-				Path:    rel,
-				ModTime: stat.ModTime(),
-				Size:    stat.Size(),
-			})
 		}
 	}
 
-	return result, nil
+	return nil
 }
 
 func (s *sftpStorage) Delete(_ context.Context, remotePath string) error {
-	return s.client.Remove(s.fullPath(remotePath))
+	fullPath, err := s.fullPath(remotePath)
+	if err != nil {
+		return err
+	}
+	return sftpError(s.client.Remove(fullPath))
 }
 
 func (s *sftpStorage) DeleteDir(_ context.Context, remotePath string) error {
-	return s.client.RemoveAll(s.fullPath(remotePath))
+	fullPath, err := s.fullPath(remotePath)
+	if err != nil {
+		return err
+	}
+	return sftpError(s.client.RemoveAll(fullPath))
 }
 
-func (s *sftpStorage) DeleteAll(_ context.Context, remotePath string) error {
-	fullPath := s.fullPath(remotePath)
+func (s *sftpStorage) DeleteAll(ctx context.Context, remotePath string) error {
+	_, err := s.DeleteAllReport(ctx, remotePath)
+	return err
+}
 
-	entries, err := s.client.ReadDir(fullPath)
+// DeleteAllReport removes the files below remotePath one by one, then the
+// directories that became empty, deepest first.
+func (s *sftpStorage) DeleteAllReport(ctx context.Context, remotePath string) (DeleteReport, error) {
+	var report DeleteReport
+	fullPath, err := s.fullPath(remotePath)
 	if err != nil {
-		errMsg := err.Error()
-		if strings.Contains(errMsg, "file does not exist") {
-			return nil
+		return report, err
+	}
+
+	var dirs []string
+	walker := s.client.Walk(fullPath)
+	for walker.Step() {
+		if err := ctx.Err(); err != nil {
+			return report, errors.Join(err, report.Err())
 		}
-		return fmt.Errorf("reading directory %q: %w", fullPath, err)
+		if err := walker.Err(); err != nil {
+			if walker.Path() == fullPath {
+				if errors.Is(err, fs.ErrNotExist) {
+					return report, nil
+				}
+				return report, fmt.Errorf("reading directory %q: %w", fullPath, sftpError(err))
+			}
+			report.fail(s.remoteKey(walker.Path()), sftpError(err))
+			continue
+		}
+		if walker.Path() == fullPath {
+			continue
+		}
+		if walker.Stat().IsDir() {
+			dirs = append(dirs, walker.Path())
+			continue
+		}
+		if err := s.client.Remove(walker.Path()); err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				report.fail(s.remoteKey(walker.Path()), sftpError(err))
+			}
+			continue
+		}
+		report.Deleted++
 	}
 
-	for _, entry := range entries {
-		pathToRemove := path.Join(fullPath, entry.Name())
-		err := s.client.RemoveAll(pathToRemove)
-		if err != nil {
-			errMsg := err.Error()
-			if strings.Contains(errMsg, "file does not exist") {
-				return nil
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := s.client.RemoveDirectory(dirs[i]); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			// A directory that still holds a failed file is already
+			// accounted for by that file.
+			if entries, _ := s.client.ReadDir(dirs[i]); len(entries) == 0 {
+				report.fail(s.remoteKey(dirs[i]), sftpError(err))
 			}
-			return err
 		}
 	}
-	return nil
+	return report, report.Err()
+}
+
+// remoteKey maps a server path below the base directory to its key.
+func (s *sftpStorage) remoteKey(fullPath string) string {
+	if rel, ok := relKey(s.baseDir, fullPath); ok {
+		return rel
+	}
+	return fullPath
 }
 
-func (s *sftpStorage) DeleteAllBulk(_ context.Context, paths []string) error {
-	for i := range paths {
-		fullPath := s.fullPath(paths[i])
-		err := s.client.RemoveAll(fullPath)
+func (s *sftpStorage) DeleteAllBulk(ctx context.Context, paths []string) error {
+	return deleteConcurrently(ctx, paths, s.deleteConcurrency, func(p string) error {
+		fullPath, err := s.fullPath(p)
 		if err != nil {
-			errMsg := err.Error()
-			if strings.Contains(errMsg, "file does not exist") {
-				return nil
-			}
 			return err
 		}
-	}
-	return nil
+		err = s.client.RemoveAll(fullPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return sftpError(err)
+	})
 }
 
 func (s *sftpStorage) Exists(_ context.Context, remotePath string) (bool, error) {
-	fullPath := s.fullPath(remotePath)
+	fullPath, err := s.fullPath(remotePath)
+	if err != nil {
+		return false, err
+	}
 	info, err := s.client.Stat(fullPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return false, nil
 		}
-		return false, err
+		return false, sftpError(err)
 	}
 	return info.Mode().IsRegular(), nil
 }
 
+func (s *sftpStorage) Stat(_ context.Context, remotePath string) (FileInfo, error) {
+	fullPath, err := s.fullPath(remotePath)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	info, err := s.client.Stat(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return FileInfo{}, fmt.Errorf("stat %q: %w", remotePath, fs.ErrNotExist)
+		}
+		return FileInfo{}, fmt.Errorf("stat %q: %w", remotePath, sftpError(err))
+	}
+	if !info.Mode().IsRegular() {
+		return FileInfo{}, fmt.Errorf("stat %q: not a regular file: %w", remotePath, fs.ErrNotExist)
+	}
+	return FileInfo{Path: remotePath, ModTime: info.ModTime(), Size: info.Size()}, nil
+}
+
 func (s *sftpStorage) ListTopLevelDirs(_ context.Context, prefix string) (map[string]bool, error) {
-	fullPath := s.fullPath(prefix)
+	fullPath, err := s.fullPath(prefix)
+	if err != nil {
+		return nil, err
+	}
 	result := make(map[string]bool)
 
 	entries, err := s.client.ReadDir(fullPath)
 	if err != nil {
-		return nil, err
+		if errors.Is(err, fs.ErrNotExist) {
+			return result, nil
+		}
+		return nil, sftpError(err)
 	}
 
 	for _, entry := range entries {
 		if entry.IsDir() {
-			dirFullPath := filepath.ToSlash(filepath.Join(fullPath, entry.Name()))
-			rel, err := filepath.Rel(s.baseDir, dirFullPath)
-			if err != nil {
-				return nil, err
+			if rel, ok := relKey(s.baseDir, path.Join(fullPath, entry.Name())); ok {
+				result[rel] = true
 			}
-			result[filepath.ToSlash(rel)] = true
 		}
 	}
 	return result, nil
 }
 
 func (s *sftpStorage) Rename(_ context.Context, oldRemotePath, newRemotePath string) error {
-	oldFull := s.fullPath(oldRemotePath)
-	newFull := s.fullPath(newRemotePath)
+	oldFull, err := s.fullPath(oldRemotePath)
+	if err != nil {
+		return err
+	}
+	newFull, err := s.fullPath(newRemotePath)
+	if err != nil {
+		return err
+	}
 
 	if oldFull == newFull {
 		return nil
@@ -213,12 +371,53 @@ func (s *sftpStorage) Rename(_ context.Context, oldRemotePath, newRemotePath str
 	// Ensure destination directory exists
 	dir := path.Dir(newFull)
 	if err := s.client.MkdirAll(dir); err != nil {
-		return fmt.Errorf("mkdir dest dir %q: %w", dir, err)
+		return fmt.Errorf("mkdir dest dir %q: %w", dir, sftpError(err))
 	}
 
-	if err := s.client.Rename(oldFull, newFull); err != nil {
-		return fmt.Errorf("sftp rename %q -> %q: %w", oldFull, newFull, err)
+	// Plain SFTP rename fails if the destination exists; the OpenSSH
+	// extension replaces it atomically, like rename(2).
+	rename := s.client.Rename
+	if _, ok := s.client.HasExtension("posix-rename@openssh.com"); ok {
+		rename = s.client.PosixRename
+	}
+	if err := rename(oldFull, newFull); err != nil {
+		return fmt.Errorf("sftp rename %q -> %q: %w", oldFull, newFull, sftpError(err))
 	}
 
 	return nil
 }
+
+// Ping stats the base directory, or gets the working directory if there
+// is none. A base directory not created yet still proves the server
+// answers.
+func (s *sftpStorage) Ping(_ context.Context) error {
+	if s.baseDir == "" {
+		_, err := s.client.Getwd()
+		return sftpError(err)
+	}
+	if _, err := s.client.Stat(s.baseDir); err != nil && !os.IsNotExist(err) {
+		return sftpError(err)
+	}
+	return nil
+}
+
+// sftpStatusFileAlreadyExists is SSH_FX_FILE_ALREADY_EXISTS, which servers
+// speaking protocol version 5 or later return.
+const sftpStatusFileAlreadyExists = 11
+
+// sftpError makes an SFTP error match the storage error it stands for. The
+// client already reports missing files and denied access as the os errors;
+// this adds lost connections and existing targets.
+func sftpError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sftp.ErrSSHFxConnectionLost) || errors.Is(err, sftp.ErrSSHFxNoConnection) {
+		return classify(err, ErrUnavailable)
+	}
+	var se *sftp.StatusError
+	if errors.As(err, &se) && se.Code == sftpStatusFileAlreadyExists {
+		return classify(err, ErrAlreadyExists)
+	}
+	return err
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ObjectTooLargeError is returned by SizeLimitStorage.Put when the source
+// stream exceeds the configured limit.
+type ObjectTooLargeError struct {
+	Path  string
+	Limit int64
+}
+
+func (e *ObjectTooLargeError) Error() string {
+	return fmt.Sprintf("object %q exceeds size limit of %d bytes", e.Path, e.Limit)
+}
+
+// SizeLimitStorage rejects Puts larger than MaxBytes. Bytes are counted as
+// they stream, so an oversized upload is aborted as soon as the limit is
+// crossed instead of after the whole object was transferred. The upload
+// goes to a temporary object that replaces remotePath only once the whole
+// stream fit, so a rejected Put leaves an existing object untouched.
+//
+// The limit applies to the stream handed to Put, i.e. wrap it around a
+// TransformingStorage to limit logical size, or inside one to limit the
+// stored (compressed+encrypted) size.
+type SizeLimitStorage struct {
+	Backend  Storage
+	MaxBytes int64
+}
+
+// sizeLimitTempMarker tags the temporary objects Put uploads to before
+// they replace the target.
+const sizeLimitTempMarker = ".sizelimit-"
+
+var (
+	_ Storage = (*SizeLimitStorage)(nil)
+	_ Walker  = (*SizeLimitStorage)(nil)
+)
+
+// NewSizeLimitStorage creates a SizeLimitStorage. A non-positive maxBytes
+// disables the check.
+func NewSizeLimitStorage(backend Storage, maxBytes int64) *SizeLimitStorage {
+	return &SizeLimitStorage{Backend: backend, MaxBytes: maxBytes}
+}
+
+func (s *SizeLimitStorage) Put(ctx context.Context, remotePath string, r io.Reader) error {
+	if s.MaxBytes <= 0 {
+		return s.Backend.Put(ctx, remotePath, r)
+	}
+
+	lr := &limitReader{r: r, left: s.MaxBytes, path: remotePath, limit: s.MaxBytes}
+	tmp := remotePath + sizeLimitTempMarker + randomSuffix()
+	err := s.Backend.Put(ctx, tmp, lr)
+	if err == nil {
+		err = lr.err
+	}
+	if err != nil {
+		_ = s.Backend.Delete(ctx, tmp)
+		if lr.err != nil && !errors.Is(err, lr.err) {
+			return lr.err
+		}
+		return err
+	}
+	if err := replaceObject(ctx, s.Backend, tmp, remotePath); err != nil {
+		if !errors.Is(err, errReplaceIncomplete) {
+			_ = s.Backend.Delete(ctx, tmp)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *SizeLimitStorage) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	return s.Backend.Get(ctx, remotePath)
+}
+
+func (s *SizeLimitStorage) List(ctx context.Context, remotePath string) ([]string, error) {
+	return s.Backend.List(ctx, remotePath)
+}
+
+func (s *SizeLimitStorage) ListInfo(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	return s.Backend.ListInfo(ctx, remotePath)
+}
+
+func (s *SizeLimitStorage) WalkInfo(ctx context.Context, remotePath string, fn func(fi FileInfo) error) error {
+	return WalkInfo(ctx, s.Backend, remotePath, fn)
+}
+
+func (s *SizeLimitStorage) Delete(ctx context.Context, remotePath string) error {
+	return s.Backend.Delete(ctx, remotePath)
+}
+
+func (s *SizeLimitStorage) DeleteAll(ctx context.Context, remotePath string) error {
+	return s.Backend.DeleteAll(ctx, remotePath)
+}
+
+func (s *SizeLimitStorage) DeleteDir(ctx context.Context, remotePath string) error {
+	return s.Backend.DeleteDir(ctx, remotePath)
+}
+
+func (s *SizeLimitStorage) DeleteAllBulk(ctx context.Context, paths []string) error {
+	return s.Backend.DeleteAllBulk(ctx, paths)
+}
+
+func (s *SizeLimitStorage) Exists(ctx context.Context, remotePath string) (bool, error) {
+	return s.Backend.Exists(ctx, remotePath)
+}
+
+func (s *SizeLimitStorage) ListTopLevelDirs(ctx context.Context, prefix string) (map[string]bool, error) {
+	return s.Backend.ListTopLevelDirs(ctx, prefix)
+}
+
+func (s *SizeLimitStorage) Rename(ctx context.Context, oldRemotePath, newRemotePath string) error {
+	return s.Backend.Rename(ctx, oldRemotePath, newRemotePath)
+}
+
+// limitReader fails with *ObjectTooLargeError once more than limit bytes
+// were read. Reading exactly limit bytes followed by EOF is fine.
+type limitReader struct {
+	r     io.Reader
+	left  int64
+	limit int64
+	path  string
+	err   error
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+	// Allow one extra byte through so we can tell "exactly at the limit"
+	// from "over the limit".
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.left {
+		l.err = &ObjectTooLargeError{Path: l.path, Limit: l.limit}
+		return int(l.left), l.err
+	}
+	l.left -= int64(n)
+	return n, err
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSizeLimitStorage_Put(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	s := NewSizeLimitStorage(mem, 4)
+
+	require.NoError(t, s.Put(ctx, "ok", bytes.NewReader([]byte("1234"))))
+
+	err := s.Put(ctx, "big", bytes.NewReader([]byte("12345")))
+	var tooLarge *ObjectTooLargeError
+	require.True(t, errors.As(err, &tooLarge))
+	assert.Equal(t, "big", tooLarge.Path)
+	assert.Equal(t, int64(4), tooLarge.Limit)
+
+	assert.Contains(t, mem.Files, "ok")
+	assert.NotContains(t, mem.Files, "big")
+}
+
+func TestSizeLimitStorage_Put_KeepsExisting(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	s := NewSizeLimitStorage(mem, 4)
+
+	require.NoError(t, s.Put(ctx, "obj", bytes.NewReader([]byte("old"))))
+
+	err := s.Put(ctx, "obj", bytes.NewReader([]byte("too large")))
+	var tooLarge *ObjectTooLargeError
+	require.ErrorAs(t, err, &tooLarge)
+
+	rc, err := s.Get(ctx, "obj")
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, "old", string(data))
+	assert.Len(t, mem.Files, 1, "no temporary object is left behind")
+
+	require.NoError(t, s.Put(ctx, "obj", bytes.NewReader([]byte("new"))))
+	rc, err = s.Get(ctx, "obj")
+	require.NoError(t, err)
+	data, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, "new", string(data))
+	assert.Len(t, mem.Files, 1)
+}
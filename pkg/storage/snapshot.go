@@ -0,0 +1,585 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSnapshotDir is the backend directory where SnapshotStorage keeps
+// its manifests and preserved object versions.
+const DefaultSnapshotDir = ".snapshots"
+
+var (
+	// ErrReadOnly is returned by write operations on read-only views.
+	ErrReadOnly = errors.New("read-only storage")
+
+	// ErrSnapshotExists is returned when a snapshot name is already taken.
+	ErrSnapshotExists = errors.New("snapshot already exists")
+
+	// ErrSnapshotMismatch is returned when an object read through a snapshot
+	// view no longer matches the checksum recorded in the manifest.
+	ErrSnapshotMismatch = errors.New("object does not match snapshot manifest")
+)
+
+// SnapshotEntry describes a single object recorded in a snapshot.
+type SnapshotEntry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	SHA256  string    `json:"sha256"`
+}
+
+// SnapshotManifest is the immutable record of a prefix at a point in time.
+type SnapshotManifest struct {
+	Name      string          `json:"name"`
+	Prefix    string          `json:"prefix"`
+	CreatedAt time.Time       `json:"created_at"`
+	Entries   []SnapshotEntry `json:"entries"`
+}
+
+// SnapshotStorage is a storage wrapper that can record immutable manifests
+// (paths, sizes and checksums) of a prefix and open read-only views pinned
+// to them.
+//
+// Writes that go through the wrapper preserve the content of any object
+// referenced by a snapshot before it is overwritten, deleted or renamed
+// (copy-on-write into <dir>/blobs/<sha256>), so a snapshot view keeps
+// serving the recorded data while new writes continue.
+//
+// Writes that bypass the wrapper are detected on read: a view returns
+// ErrSnapshotMismatch instead of silently serving changed data.
+type SnapshotStorage struct {
+	Backend Storage
+	prefix  string
+	dir     string
+
+	mu     sync.Mutex
+	pinned map[string]map[string]bool // path -> set of sha256 referenced by snapshots
+}
+
+var _ Storage = (*SnapshotStorage)(nil)
+
+// NewSnapshotStorage creates a SnapshotStorage recording objects under
+// prefix. Manifests are stored under DefaultSnapshotDir in the backend.
+func NewSnapshotStorage(backend Storage, prefix string) *SnapshotStorage {
+	return &SnapshotStorage{
+		Backend: backend,
+		prefix:  strings.Trim(path.Clean("/"+prefix), "/"),
+		dir:     DefaultSnapshotDir,
+	}
+}
+
+func (ss *SnapshotStorage) manifestPath(name string) string {
+	return path.Join(ss.dir, name+".json")
+}
+
+func (ss *SnapshotStorage) blobPath(sum string) string {
+	return path.Join(ss.dir, "blobs", sum)
+}
+
+func (ss *SnapshotStorage) isInternal(p string) bool {
+	return hasPathPrefix(p, ss.dir)
+}
+
+func validSnapshotName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid snapshot name %q", name)
+	}
+	return nil
+}
+
+// Snapshot records a manifest of every object under the configured prefix.
+// Snapshot names are immutable: recording an existing name fails with
+// ErrSnapshotExists.
+func (ss *SnapshotStorage) Snapshot(ctx context.Context, name string) (*SnapshotManifest, error) {
+	if err := validSnapshotName(name); err != nil {
+		return nil, err
+	}
+	if err := ss.loadPinned(ctx); err != nil {
+		return nil, err
+	}
+
+	exists, err := ss.Backend.Exists(ctx, ss.manifestPath(name))
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotExists, name)
+	}
+
+	infos, err := ss.Backend.ListInfo(ctx, ss.prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &SnapshotManifest{
+		Name:      name,
+		Prefix:    ss.prefix,
+		CreatedAt: time.Now().UTC(),
+		Entries:   make([]SnapshotEntry, 0, len(infos)),
+	}
+	for _, fi := range infos {
+		if ss.isInternal(fi.Path) {
+			continue
+		}
+		sum, size, err := hashObject(ctx, ss.Backend, fi.Path)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot %q: %w", fi.Path, err)
+		}
+		m.Entries = append(m.Entries, SnapshotEntry{
+			Path:    fi.Path,
+			Size:    size,
+			ModTime: fi.ModTime,
+			SHA256:  sum,
+		})
+	}
+	sort.Slice(m.Entries, func(i, j int) bool { return m.Entries[i].Path < m.Entries[j].Path })
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	if err := ss.Backend.Put(ctx, ss.manifestPath(name), bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	ss.mu.Lock()
+	ss.pin(m)
+	ss.mu.Unlock()
+
+	return m, nil
+}
+
+// Manifest loads the manifest of a previously recorded snapshot.
+func (ss *SnapshotStorage) Manifest(ctx context.Context, name string) (*SnapshotManifest, error) {
+	if err := validSnapshotName(name); err != nil {
+		return nil, err
+	}
+	return readSnapshotManifest(ctx, ss.Backend, ss.manifestPath(name))
+}
+
+// Snapshots returns the names of all recorded snapshots, sorted.
+func (ss *SnapshotStorage) Snapshots(ctx context.Context) ([]string, error) {
+	files, err := ss.Backend.List(ctx, ss.dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, f := range files {
+		if path.Dir(f) != ss.dir || !strings.HasSuffix(f, ".json") {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(path.Base(f), ".json"))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// OpenSnapshot returns a read-only Storage view pinned to the named snapshot.
+func (ss *SnapshotStorage) OpenSnapshot(ctx context.Context, name string) (Storage, error) {
+	m, err := ss.Manifest(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]SnapshotEntry, len(m.Entries))
+	for _, e := range m.Entries {
+		entries[e.Path] = e
+	}
+	return &snapshotView{ss: ss, manifest: m, entries: entries}, nil
+}
+
+func readSnapshotManifest(ctx context.Context, st Storage, p string) (*SnapshotManifest, error) {
+	rc, err := st.Get(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var m SnapshotManifest
+	if err := json.NewDecoder(rc).Decode(&m); err != nil {
+		return nil, fmt.Errorf("decode snapshot manifest %q: %w", p, err)
+	}
+	return &m, nil
+}
+
+// pinned index
+
+// loadPinned reads all manifests once and builds the path -> checksums index
+// used to decide which objects must be preserved before a write.
+func (ss *SnapshotStorage) loadPinned(ctx context.Context) error {
+	ss.mu.Lock()
+	loaded := ss.pinned != nil
+	ss.mu.Unlock()
+	if loaded {
+		return nil
+	}
+
+	names, err := ss.Snapshots(ctx)
+	if err != nil {
+		return err
+	}
+	pinned := make(map[string]map[string]bool)
+	for _, name := range names {
+		m, err := readSnapshotManifest(ctx, ss.Backend, ss.manifestPath(name))
+		if err != nil {
+			return err
+		}
+		pinManifest(pinned, m)
+	}
+
+	ss.mu.Lock()
+	if ss.pinned == nil {
+		ss.pinned = pinned
+	}
+	ss.mu.Unlock()
+	return nil
+}
+
+// pin must be called with ss.mu held.
+func (ss *SnapshotStorage) pin(m *SnapshotManifest) {
+	if ss.pinned == nil {
+		ss.pinned = make(map[string]map[string]bool)
+	}
+	pinManifest(ss.pinned, m)
+}
+
+func pinManifest(pinned map[string]map[string]bool, m *SnapshotManifest) {
+	for _, e := range m.Entries {
+		if pinned[e.Path] == nil {
+			pinned[e.Path] = make(map[string]bool)
+		}
+		pinned[e.Path][e.SHA256] = true
+	}
+}
+
+func (ss *SnapshotStorage) pinnedSums(p string) map[string]bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	sums := make(map[string]bool, len(ss.pinned[p]))
+	for k := range ss.pinned[p] {
+		sums[k] = true
+	}
+	return sums
+}
+
+func (ss *SnapshotStorage) pinnedUnder(prefix string) []string {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	var paths []string
+	for p := range ss.pinned {
+		if hasPathPrefix(p, prefix) {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// preserve copies the current content of p into the blob area if some
+// snapshot references exactly that content and it was not preserved yet.
+func (ss *SnapshotStorage) preserve(ctx context.Context, p string) error {
+	if err := ss.loadPinned(ctx); err != nil {
+		return err
+	}
+	sums := ss.pinnedSums(p)
+	if len(sums) == 0 {
+		return nil
+	}
+
+	missing := false
+	for sum := range sums {
+		ok, err := ss.Backend.Exists(ctx, ss.blobPath(sum))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			missing = true
+			break
+		}
+	}
+	if !missing {
+		return nil
+	}
+
+	exists, err := ss.Backend.Exists(ctx, p)
+	if err != nil || !exists {
+		return err
+	}
+
+	rc, err := ss.Backend.Get(ctx, p)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	tmp := path.Join(ss.dir, "blobs", "tmp-"+randomSuffix())
+	h := sha256.New()
+	if err := ss.Backend.Put(ctx, tmp, io.TeeReader(rc, h)); err != nil {
+		return err
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	if !sums[sum] {
+		// Current content is not referenced by any snapshot.
+		return ss.Backend.Delete(ctx, tmp)
+	}
+	return ss.Backend.Rename(ctx, tmp, ss.blobPath(sum))
+}
+
+func (ss *SnapshotStorage) preserveUnder(ctx context.Context, prefix string) error {
+	if err := ss.loadPinned(ctx); err != nil {
+		return err
+	}
+	for _, p := range ss.pinnedUnder(prefix) {
+		if err := ss.preserve(ctx, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Storage implementation: reads pass through, writes preserve first.
+
+func (ss *SnapshotStorage) Put(ctx context.Context, remotePath string, r io.Reader) error {
+	if err := ss.preserve(ctx, remotePath); err != nil {
+		return err
+	}
+	return ss.Backend.Put(ctx, remotePath, r)
+}
+
+func (ss *SnapshotStorage) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	return ss.Backend.Get(ctx, remotePath)
+}
+
+func (ss *SnapshotStorage) List(ctx context.Context, remotePath string) ([]string, error) {
+	return ss.Backend.List(ctx, remotePath)
+}
+
+func (ss *SnapshotStorage) ListInfo(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	return ss.Backend.ListInfo(ctx, remotePath)
+}
+
+func (ss *SnapshotStorage) Delete(ctx context.Context, remotePath string) error {
+	if err := ss.preserve(ctx, remotePath); err != nil {
+		return err
+	}
+	return ss.Backend.Delete(ctx, remotePath)
+}
+
+func (ss *SnapshotStorage) DeleteAll(ctx context.Context, remotePath string) error {
+	if err := ss.preserveUnder(ctx, remotePath); err != nil {
+		return err
+	}
+	return ss.Backend.DeleteAll(ctx, remotePath)
+}
+
+func (ss *SnapshotStorage) DeleteDir(ctx context.Context, remotePath string) error {
+	if err := ss.preserveUnder(ctx, remotePath); err != nil {
+		return err
+	}
+	return ss.Backend.DeleteDir(ctx, remotePath)
+}
+
+func (ss *SnapshotStorage) DeleteAllBulk(ctx context.Context, paths []string) error {
+	for _, p := range paths {
+		if err := ss.preserveUnder(ctx, p); err != nil {
+			return err
+		}
+	}
+	return ss.Backend.DeleteAllBulk(ctx, paths)
+}
+
+func (ss *SnapshotStorage) Exists(ctx context.Context, remotePath string) (bool, error) {
+	return ss.Backend.Exists(ctx, remotePath)
+}
+
+func (ss *SnapshotStorage) ListTopLevelDirs(ctx context.Context, prefix string) (map[string]bool, error) {
+	return ss.Backend.ListTopLevelDirs(ctx, prefix)
+}
+
+func (ss *SnapshotStorage) Rename(ctx context.Context, oldRemotePath, newRemotePath string) error {
+	if err := ss.preserve(ctx, oldRemotePath); err != nil {
+		return err
+	}
+	if err := ss.preserve(ctx, newRemotePath); err != nil {
+		return err
+	}
+	return ss.Backend.Rename(ctx, oldRemotePath, newRemotePath)
+}
+
+// snapshotView is a read-only Storage pinned to a manifest.
+type snapshotView struct {
+	ss       *SnapshotStorage
+	manifest *SnapshotManifest
+	entries  map[string]SnapshotEntry
+}
+
+var _ Storage = (*snapshotView)(nil)
+
+func (v *snapshotView) Put(_ context.Context, _ string, _ io.Reader) error {
+	return ErrReadOnly
+}
+
+// Get serves the preserved copy when the live object was changed through
+// the wrapper, and verifies the checksum of whatever is served.
+func (v *snapshotView) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	e, ok := v.entries[remotePath]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+
+	src := remotePath
+	blob := v.ss.blobPath(e.SHA256)
+	preserved, err := v.ss.Backend.Exists(ctx, blob)
+	if err != nil {
+		return nil, err
+	}
+	if preserved {
+		src = blob
+	}
+
+	rc, err := v.ss.Backend.Get(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	return &verifyingReader{rc: rc, h: sha256.New(), want: e.SHA256, size: e.Size, path: remotePath}, nil
+}
+
+func (v *snapshotView) List(_ context.Context, remotePath string) ([]string, error) {
+	var result []string
+	for _, e := range v.manifest.Entries {
+		if hasPathPrefix(e.Path, remotePath) {
+			result = append(result, e.Path)
+		}
+	}
+	return result, nil
+}
+
+func (v *snapshotView) ListInfo(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	var result []FileInfo
+	for _, e := range v.manifest.Entries {
+		if hasPathPrefix(e.Path, remotePath) {
+			result = append(result, FileInfo{Path: e.Path, ModTime: e.ModTime, Size: e.Size})
+		}
+	}
+	return filterInfos(ctx, result), nil
+}
+
+func (v *snapshotView) Delete(_ context.Context, _ string) error {
+	return ErrReadOnly
+}
+
+func (v *snapshotView) DeleteAll(_ context.Context, _ string) error {
+	return ErrReadOnly
+}
+
+func (v *snapshotView) DeleteDir(_ context.Context, _ string) error {
+	return ErrReadOnly
+}
+
+func (v *snapshotView) DeleteAllBulk(_ context.Context, _ []string) error {
+	return ErrReadOnly
+}
+
+func (v *snapshotView) Exists(_ context.Context, remotePath string) (bool, error) {
+	_, ok := v.entries[remotePath]
+	return ok, nil
+}
+
+func (v *snapshotView) ListTopLevelDirs(_ context.Context, prefix string) (map[string]bool, error) {
+	result := make(map[string]bool)
+	prefix = strings.Trim(prefix, "/")
+	for _, e := range v.manifest.Entries {
+		if !hasPathPrefix(e.Path, prefix) || e.Path == prefix {
+			continue
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(e.Path, prefix), "/")
+		if idx := strings.Index(rel, "/"); idx > 0 {
+			result[path.Join(prefix, rel[:idx])] = true
+		}
+	}
+	return result, nil
+}
+
+func (v *snapshotView) Rename(_ context.Context, _, _ string) error {
+	return ErrReadOnly
+}
+
+// verifyingReader hashes the stream and reports ErrSnapshotMismatch at EOF
+// if the content differs from the recorded checksum.
+type verifyingReader struct {
+	rc   io.ReadCloser
+	h    hash.Hash
+	want string
+	size int64
+	n    int64
+	path string
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	r.h.Write(p[:n])
+	r.n += int64(n)
+	if errors.Is(err, io.EOF) {
+		if r.n != r.size || hex.EncodeToString(r.h.Sum(nil)) != r.want {
+			return n, fmt.Errorf("%w: %s", ErrSnapshotMismatch, r.path)
+		}
+	}
+	return n, err
+}
+
+func (r *verifyingReader) Close() error {
+	return r.rc.Close()
+}
+
+// utils
+
+// hasPathPrefix reports whether p is prefix itself or lies under it.
+// An empty prefix matches everything.
+func hasPathPrefix(p, prefix string) bool {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return true
+	}
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// hashObject streams an object and returns its hex sha256 and size.
+func hashObject(ctx context.Context, st Storage, p string) (string, int64, error) {
+	rc, err := st.Get(ctx, p)
+	if err != nil {
+		return "", 0, err
+	}
+	defer rc.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, rc)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+func randomSuffix() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b[:])
+}
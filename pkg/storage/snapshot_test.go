@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotStorage_ViewIsPinnedWhileWritesContinue(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	ss := NewSnapshotStorage(mem, "wal")
+
+	require.NoError(t, ss.Put(ctx, "wal/0001", bytes.NewReader([]byte("one"))))
+	require.NoError(t, ss.Put(ctx, "wal/0002", bytes.NewReader([]byte("two"))))
+
+	m, err := ss.Snapshot(ctx, "gen1")
+	require.NoError(t, err)
+	require.Len(t, m.Entries, 2)
+
+	// New writes continue: overwrite, delete, add.
+	require.NoError(t, ss.Put(ctx, "wal/0001", bytes.NewReader([]byte("changed"))))
+	require.NoError(t, ss.Delete(ctx, "wal/0002"))
+	require.NoError(t, ss.Put(ctx, "wal/0003", bytes.NewReader([]byte("three"))))
+
+	view, err := ss.OpenSnapshot(ctx, "gen1")
+	require.NoError(t, err)
+
+	files, err := view.List(ctx, "wal")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"wal/0001", "wal/0002"}, files)
+
+	for p, want := range map[string]string{"wal/0001": "one", "wal/0002": "two"} {
+		rc, err := view.Get(ctx, p)
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		assert.Equal(t, want, string(data))
+	}
+
+	ok, err := view.Exists(ctx, "wal/0003")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.ErrorIs(t, view.Put(ctx, "wal/0004", bytes.NewReader(nil)), ErrReadOnly)
+	require.ErrorIs(t, view.Delete(ctx, "wal/0001"), ErrReadOnly)
+}
+
+func TestSnapshotStorage_NamesAreImmutable(t *testing.T) {
+	ctx := context.Background()
+	ss := NewSnapshotStorage(NewInMemoryStorage(), "data")
+	require.NoError(t, ss.Put(ctx, "data/a", bytes.NewReader([]byte("a"))))
+
+	_, err := ss.Snapshot(ctx, "s1")
+	require.NoError(t, err)
+	_, err = ss.Snapshot(ctx, "s1")
+	require.ErrorIs(t, err, ErrSnapshotExists)
+
+	_, err = ss.Snapshot(ctx, "bad/name")
+	require.Error(t, err)
+
+	names, err := ss.Snapshots(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"s1"}, names)
+}
+
+func TestSnapshotStorage_DetectsChangesBypassingWrapper(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	ss := NewSnapshotStorage(mem, "data")
+	require.NoError(t, ss.Put(ctx, "data/a", bytes.NewReader([]byte("a"))))
+
+	_, err := ss.Snapshot(ctx, "s1")
+	require.NoError(t, err)
+
+	// Overwrite directly on the backend: nothing was preserved.
+	require.NoError(t, mem.Put(ctx, "data/a", bytes.NewReader([]byte("tampered"))))
+
+	view, err := ss.OpenSnapshot(ctx, "s1")
+	require.NoError(t, err)
+	rc, err := view.Get(ctx, "data/a")
+	require.NoError(t, err)
+	_, err = io.ReadAll(rc)
+	require.ErrorIs(t, err, ErrSnapshotMismatch)
+}
+
+func TestSnapshotStorage_Diff(t *testing.T) {
+	ctx := context.Background()
+	ss := NewSnapshotStorage(NewInMemoryStorage(), "wal")
+
+	require.NoError(t, ss.Put(ctx, "wal/0001", bytes.NewReader([]byte("one"))))
+	require.NoError(t, ss.Put(ctx, "wal/0002", bytes.NewReader([]byte("two"))))
+	require.NoError(t, ss.Put(ctx, "wal/0003", bytes.NewReader([]byte("three"))))
+	_, err := ss.Snapshot(ctx, "gen1")
+	require.NoError(t, err)
+
+	require.NoError(t, ss.Put(ctx, "wal/0001", bytes.NewReader([]byte("ONE"))))
+	require.NoError(t, ss.Delete(ctx, "wal/0002"))
+	require.NoError(t, ss.Put(ctx, "wal/0004", bytes.NewReader([]byte("four!"))))
+	_, err = ss.Snapshot(ctx, "gen2")
+	require.NoError(t, err)
+
+	d, err := ss.Diff(ctx, "gen1", "gen2")
+	require.NoError(t, err)
+	require.Len(t, d.Added, 1)
+	assert.Equal(t, "wal/0004", d.Added[0].Path)
+	assert.Equal(t, int64(5), d.AddedBytes())
+	require.Len(t, d.Removed, 1)
+	assert.Equal(t, "wal/0002", d.Removed[0].Path)
+	assert.Equal(t, int64(3), d.RemovedBytes())
+	require.Len(t, d.Changed, 1)
+	assert.Equal(t, "wal/0001", d.Changed[0].To.Path)
+	assert.Equal(t, d.Changed[0].From.Size, d.Changed[0].To.Size)
+
+	same, err := ss.Diff(ctx, "gen2", "gen2")
+	require.NoError(t, err)
+	assert.True(t, same.Empty())
+
+	_, err = ss.Diff(ctx, "gen1", "missing")
+	assert.Error(t, err)
+}
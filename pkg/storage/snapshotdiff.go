@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"sort"
+)
+
+// SnapshotChange is an object present in both snapshots with different
+// content.
+type SnapshotChange struct {
+	From SnapshotEntry `json:"from"`
+	To   SnapshotEntry `json:"to"`
+}
+
+// SnapshotDiff lists what changed between two snapshot manifests. Every
+// list is sorted by path.
+type SnapshotDiff struct {
+	Added   []SnapshotEntry  `json:"added"`
+	Removed []SnapshotEntry  `json:"removed"`
+	Changed []SnapshotChange `json:"changed"`
+}
+
+// Empty reports whether the snapshots hold the same objects.
+func (d *SnapshotDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// AddedBytes returns the total size of the added objects.
+func (d *SnapshotDiff) AddedBytes() int64 {
+	var n int64
+	for _, e := range d.Added {
+		n += e.Size
+	}
+	return n
+}
+
+// RemovedBytes returns the total size of the removed objects.
+func (d *SnapshotDiff) RemovedBytes() int64 {
+	var n int64
+	for _, e := range d.Removed {
+		n += e.Size
+	}
+	return n
+}
+
+// DiffSnapshots compares two manifests. Objects are matched by path and
+// compared by checksum, or by size when either side has none recorded.
+func DiffSnapshots(from, to *SnapshotManifest) *SnapshotDiff {
+	old := make(map[string]SnapshotEntry, len(from.Entries))
+	for _, e := range from.Entries {
+		old[e.Path] = e
+	}
+	d := &SnapshotDiff{}
+	for _, e := range to.Entries {
+		prev, ok := old[e.Path]
+		if !ok {
+			d.Added = append(d.Added, e)
+			continue
+		}
+		delete(old, e.Path)
+		if prev.SHA256 != "" && e.SHA256 != "" {
+			if prev.SHA256 != e.SHA256 {
+				d.Changed = append(d.Changed, SnapshotChange{From: prev, To: e})
+			}
+		} else if prev.Size != e.Size {
+			d.Changed = append(d.Changed, SnapshotChange{From: prev, To: e})
+		}
+	}
+	for _, e := range old {
+		d.Removed = append(d.Removed, e)
+	}
+	sort.Slice(d.Added, func(i, j int) bool { return d.Added[i].Path < d.Added[j].Path })
+	sort.Slice(d.Removed, func(i, j int) bool { return d.Removed[i].Path < d.Removed[j].Path })
+	sort.Slice(d.Changed, func(i, j int) bool { return d.Changed[i].To.Path < d.Changed[j].To.Path })
+	return d
+}
+
+// Diff compares two recorded snapshots.
+func (ss *SnapshotStorage) Diff(ctx context.Context, from, to string) (*SnapshotDiff, error) {
+	a, err := ss.Manifest(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ss.Manifest(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+	return DiffSnapshots(a, b), nil
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// StorageSpec describes a whole storage stack, so that applications can
+// keep it in a config file: the backend with its transforms, as for a
+// named remote, and the wrappers applied on top, e.g.
+//
+//	backend: s3
+//	prefix: pg
+//	s3:
+//	  bucket: backups
+//	codec: zstd
+//	crypter: aes
+//	wrappers:
+//	  - type: prefix
+//	    prefix: tenant-a
+//	  - type: retry
+//	    attempts: 5
+//	    delay: 500ms
+//	  - type: metrics
+//
+// It decodes from YAML as well as from JSON.
+type StorageSpec struct {
+	RemoteConfig `yaml:",inline"`
+
+	// Wrappers are applied in order, the first one right above the
+	// transforms, so the last one receives the calls.
+	Wrappers []WrapperSpec `yaml:"wrappers" json:"wrappers"`
+}
+
+// WrapperSpec is one wrapper of a StorageSpec. Type selects it; the other
+// fields configure the wrapper of that type and are ignored otherwise.
+type WrapperSpec struct {
+	// Type is "prefix", "retry", "metrics", "size_limit" or
+	// "parallel_get".
+	Type string `yaml:"type" json:"type"`
+
+	// prefix: the directory the storage is rooted at.
+	Prefix string `yaml:"prefix" json:"prefix"`
+
+	// retry: calls per operation and the first backoff, e.g. "500ms"
+	// (defaults DefaultRetryAttempts and DefaultRetryDelay).
+	Attempts int    `yaml:"attempts" json:"attempts"`
+	Delay    string `yaml:"delay" json:"delay"`
+
+	// size_limit: the largest object Put accepts.
+	MaxBytes int64 `yaml:"max_bytes" json:"max_bytes"`
+
+	// parallel_get: the range size and the ranges in flight.
+	PartSize    int64 `yaml:"part_size" json:"part_size"`
+	Concurrency int   `yaml:"concurrency" json:"concurrency"`
+}
+
+// Stack is a storage built from a StorageSpec.
+type Stack struct {
+	// Storage is the outermost layer.
+	Storage
+
+	// Stats is the "metrics" wrapper, or nil without one.
+	Stats *StatsStorage
+
+	closeFn func() error
+}
+
+// Close closes the backend connection, if any.
+func (s *Stack) Close() error {
+	return s.closeFn()
+}
+
+// Build connects to the backend of spec and stacks the transforms and the
+// wrappers on it.
+func Build(ctx context.Context, spec StorageSpec) (*Stack, error) {
+	r, err := spec.RemoteConfig.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s := &Stack{Storage: r.VariadicStorage, closeFn: r.Close}
+	for i, w := range spec.Wrappers {
+		if err := s.wrap(w); err != nil {
+			_ = r.Close()
+			return nil, fmt.Errorf("storage: wrapper %d: %w", i, err)
+		}
+	}
+	return s, nil
+}
+
+func (s *Stack) wrap(w WrapperSpec) error {
+	switch w.Type {
+	case "prefix":
+		if w.Prefix == "" {
+			return errors.New("prefix wrapper needs a prefix")
+		}
+		s.Storage = NewPrefixStorage(s.Storage, w.Prefix)
+	case "retry":
+		var delay time.Duration
+		if w.Delay != "" {
+			d, err := time.ParseDuration(w.Delay)
+			if err != nil {
+				return fmt.Errorf("retry delay: %w", err)
+			}
+			delay = d
+		}
+		s.Storage = NewRetryStorage(s.Storage, w.Attempts, delay)
+	case "metrics":
+		s.Stats = NewStatsStorage(s.Storage)
+		s.Storage = s.Stats
+	case "size_limit":
+		if w.MaxBytes <= 0 {
+			return errors.New("size_limit wrapper needs max_bytes")
+		}
+		s.Storage = NewSizeLimitStorage(s.Storage, w.MaxBytes)
+	case "parallel_get":
+		s.Storage = NewParallelGetStorage(s.Storage, w.PartSize, w.Concurrency)
+	default:
+		return fmt.Errorf("unknown wrapper type %q", w.Type)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuild(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	var spec StorageSpec
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"backend": "local",
+		"local": {"dir": `+jsonString(dir)+`},
+		"codec": "none",
+		"delete_concurrency": 2,
+		"wrappers": [
+			{"type": "prefix", "prefix": "tenant-a"},
+			{"type": "retry", "attempts": 2, "delay": "10ms"},
+			{"type": "metrics"}
+		]
+	}`), &spec))
+	assert.Equal(t, 2, spec.DeleteConcurrency)
+
+	s, err := Build(ctx, spec)
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, s.Put(ctx, "wal/0001", strings.NewReader("hello")))
+	assert.FileExists(t, filepath.Join(dir, "tenant-a", "wal", "0001"))
+	assert.Equal(t, "hello", readAll(t, s, "wal/0001"))
+
+	names, err := s.List(ctx, "wal")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"wal/0001"}, names)
+
+	require.NotNil(t, s.Stats)
+	assert.Equal(t, int64(1), s.Stats.Stats().Ops["put"].Count)
+
+	retry, ok := s.Stats.Backend.(*RetryStorage)
+	require.True(t, ok)
+	assert.Equal(t, 10*time.Millisecond, retry.Delay)
+}
+
+func TestBuild_Errors(t *testing.T) {
+	ctx := context.Background()
+	base := StorageSpec{RemoteConfig: RemoteConfig{Backend: "mem", Codec: "none"}}
+	for msg, w := range map[string]WrapperSpec{
+		`unknown wrapper type "cache"`: {Type: "cache"},
+		"needs a prefix":               {Type: "prefix"},
+		"retry delay":                  {Type: "retry", Delay: "soon"},
+		"needs max_bytes":              {Type: "size_limit"},
+	} {
+		spec := base
+		spec.Wrappers = []WrapperSpec{w}
+		_, err := Build(ctx, spec)
+		assert.ErrorContains(t, err, msg)
+	}
+}
+
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
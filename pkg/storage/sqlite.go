@@ -0,0 +1,437 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultSQLiteTable is the table NewSQLiteStorage keeps objects in when
+// none is configured.
+const DefaultSQLiteTable = "files"
+
+// SQLiteOptions configures NewSQLiteStorage.
+type SQLiteOptions struct {
+	// Table holds the objects, DefaultSQLiteTable if empty.
+	Table string
+
+	// CreateTable creates the table if it does not exist.
+	CreateTable bool
+}
+
+type sqliteStorage struct {
+	db    *sql.DB
+	table string
+	now   func() time.Time
+}
+
+var (
+	_ Storage     = &sqliteStorage{}
+	_ Stater      = &sqliteStorage{}
+	_ Walker      = &sqliteStorage{}
+	_ RangeReader = &sqliteStorage{}
+	_ Pinger      = &sqliteStorage{}
+)
+
+// NewSQLiteStorage stores objects in a table of a SQLite database, one row
+// (path, data, size, mtime) per object, e.g. to keep an encrypted archive
+// in a single file. db is opened by the caller with a SQLite driver such
+// as modernc.org/sqlite or mattn/go-sqlite3. Objects are held in memory
+// whole by Put and Get, like rows of any SQLite blob.
+func NewSQLiteStorage(ctx context.Context, db *sql.DB, opts SQLiteOptions) (Storage, error) {
+	table := opts.Table
+	if table == "" {
+		table = DefaultSQLiteTable
+	}
+	if !sqlTableName.MatchString(table) {
+		return nil, fmt.Errorf("sqlite: invalid table name %q", table)
+	}
+	if opts.CreateTable {
+		_, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	path  TEXT PRIMARY KEY,
+	data  BLOB NOT NULL,
+	size  INTEGER NOT NULL,
+	mtime INTEGER NOT NULL
+)`, table))
+		if err != nil {
+			return nil, fmt.Errorf("sqlite: create table %s: %w", table, sqliteError(err))
+		}
+	}
+	return &sqliteStorage{db: db, table: table, now: time.Now}, nil
+}
+
+// OpenSQLite opens the SQLite database file with the named driver, which
+// the program registers by importing it, and creates the table if
+// needed. The database is used over a single connection, as SQLite lets
+// one writer at a time in. The returned function closes it.
+func OpenSQLite(ctx context.Context, driverName, file string, opts SQLiteOptions) (Storage, func() error, error) {
+	db, err := sql.Open(driverName, file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("sqlite: open %s: %w", file, err)
+	}
+	db.SetMaxOpenConns(1)
+	opts.CreateTable = true
+	st, err := NewSQLiteStorage(ctx, db, opts)
+	if err != nil {
+		_ = db.Close()
+		return nil, nil, err
+	}
+	return st, db.Close, nil
+}
+
+// prefixCond matches the paths below prefix ("dir/") by a range of the
+// primary key; the empty prefix matches every path.
+func (s *sqliteStorage) prefixCond(prefix string) (string, []any) {
+	if prefix == "" {
+		return "1", nil
+	}
+	// Text compares bytewise; '0' follows '/'.
+	return "path >= ? AND path < ?", []any{prefix, strings.TrimSuffix(prefix, "/") + "0"}
+}
+
+func (s *sqliteStorage) Put(ctx context.Context, remotePath string, r io.Reader) error {
+	key, err := objectKey(remotePath)
+	if err != nil {
+		return err
+	}
+	_, r = trackPutProgress(ctx, key, r)
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("read source for %q: %w", key, err)
+	}
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (path, data, size, mtime) VALUES (?, ?, ?, ?)
+ON CONFLICT (path) DO UPDATE SET data = excluded.data, size = excluded.size, mtime = excluded.mtime`, s.table),
+		key, data, len(data), s.now().UnixNano())
+	if err != nil {
+		return fmt.Errorf("put %q: %w", key, sqliteError(err))
+	}
+	return nil
+}
+
+func (s *sqliteStorage) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	key, err := objectKey(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	err = s.db.QueryRowContext(ctx, fmt.Sprintf("SELECT data FROM %s WHERE path = ?", s.table), key).Scan(&data)
+	if err != nil {
+		return nil, fmt.Errorf("get %q: %w", key, sqliteError(err))
+	}
+	return trackGetProgress(ctx, key, io.NopCloser(bytes.NewReader(data)), int64(len(data))), nil
+}
+
+func (s *sqliteStorage) GetRange(ctx context.Context, remotePath string, offset, length int64) (io.ReadCloser, error) {
+	key, err := objectKey(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 {
+		length = 1 << 62
+	}
+	// substr counts the bytes of a blob, starting at 1.
+	var data []byte
+	err = s.db.QueryRowContext(ctx, fmt.Sprintf("SELECT substr(data, ?, ?) FROM %s WHERE path = ?", s.table),
+		offset+1, length, key).Scan(&data)
+	if err != nil {
+		return nil, fmt.Errorf("get %q: %w", key, sqliteError(err))
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *sqliteStorage) List(ctx context.Context, remotePath string) ([]string, error) {
+	root, err := CleanPath(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	cond, args := s.prefixCond(dirPrefix(root))
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf("SELECT path FROM %s WHERE %s ORDER BY path", s.table, cond), args...)
+	if err != nil {
+		return nil, fmt.Errorf("list %q: %w", root, sqliteError(err))
+	}
+	defer rows.Close()
+
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, sqliteError(err)
+		}
+		names = append(names, name)
+	}
+	return names, sqliteError(rows.Err())
+}
+
+// ListInfo applies the list filters in its query, unless directory
+// entries are listed: those are implied by every object, filtered or not.
+func (s *sqliteStorage) ListInfo(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	root, err := CleanPath(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	opts := ListOptionsFromContext(ctx)
+	var dirs *impliedDirs
+	query, args := s.listQuery(dirPrefix(root), opts)
+	if opts.IncludeDirs {
+		dirs = newImpliedDirs(root)
+		query, args = s.listQuery(dirPrefix(root), ListOptions{})
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list %q: %w", root, sqliteError(err))
+	}
+	defer rows.Close()
+
+	var infos []FileInfo
+	for rows.Next() {
+		var (
+			fi    FileInfo
+			mtime int64
+		)
+		if err := rows.Scan(&fi.Path, &fi.Size, &mtime); err != nil {
+			return nil, sqliteError(err)
+		}
+		fi.ModTime = time.Unix(0, mtime)
+		if dirs != nil {
+			_ = dirs.add(fi.Path, func(dir FileInfo) error {
+				infos = append(infos, dir)
+				return nil
+			})
+		}
+		if opts.Match(fi) {
+			infos = append(infos, fi)
+		}
+	}
+	return infos, sqliteError(rows.Err())
+}
+
+// WalkInfo reads the whole listing before fn is called, so that fn can
+// use the storage on a database with a single connection.
+func (s *sqliteStorage) WalkInfo(ctx context.Context, remotePath string, fn func(fi FileInfo) error) error {
+	infos, err := s.ListInfo(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+	return walkSlice(ctx, infos, fn)
+}
+
+// listQuery selects the objects below prefix that pass the list filters
+// of o.
+func (s *sqliteStorage) listQuery(prefix string, o ListOptions) (string, []any) {
+	cond, args := s.prefixCond(prefix)
+	where := []string{cond}
+	if !o.ModifiedAfter.IsZero() {
+		where = append(where, "mtime > ?")
+		args = append(args, o.ModifiedAfter.UnixNano())
+	}
+	if !o.ModifiedBefore.IsZero() {
+		where = append(where, "mtime < ?")
+		args = append(args, o.ModifiedBefore.UnixNano())
+	}
+	if o.MinSize > 0 {
+		where = append(where, "size >= ?")
+		args = append(args, o.MinSize)
+	}
+	if o.MaxSize > 0 {
+		where = append(where, "size <= ?")
+		args = append(args, o.MaxSize)
+	}
+	if o.Suffix != "" {
+		where = append(where, fmt.Sprintf("substr(path, -%d) = ?", utf8.RuneCountInString(o.Suffix)))
+		args = append(args, o.Suffix)
+	}
+	return fmt.Sprintf("SELECT path, size, mtime FROM %s WHERE %s ORDER BY path",
+		s.table, strings.Join(where, " AND ")), args
+}
+
+func (s *sqliteStorage) Delete(ctx context.Context, remotePath string) error {
+	key, err := objectKey(remotePath)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE path = ?", s.table), key)
+	if err != nil {
+		return fmt.Errorf("delete %q: %w", key, sqliteError(err))
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+func (s *sqliteStorage) DeleteAll(ctx context.Context, remotePath string) error {
+	root, err := CleanPath(remotePath)
+	if err != nil {
+		return err
+	}
+	return s.deleteAll(ctx, s.db, root)
+}
+
+func (s *sqliteStorage) deleteAll(ctx context.Context, q sqlQuerier, root string) error {
+	cond, args := s.prefixCond(dirPrefix(root))
+	_, err := q.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE path = ? OR (%s)", s.table, cond),
+		append([]any{root}, args...)...)
+	if err != nil {
+		return fmt.Errorf("delete %q: %w", root, sqliteError(err))
+	}
+	return nil
+}
+
+func (s *sqliteStorage) DeleteDir(ctx context.Context, remotePath string) error {
+	return s.DeleteAll(ctx, remotePath)
+}
+
+// DeleteAllBulk deletes the paths in one transaction.
+func (s *sqliteStorage) DeleteAllBulk(ctx context.Context, paths []string) error {
+	roots := make([]string, len(paths))
+	for i, p := range paths {
+		root, err := CleanPath(p)
+		if err != nil {
+			return err
+		}
+		roots[i] = root
+	}
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, root := range roots {
+			if err := s.deleteAll(ctx, tx, root); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqliteStorage) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return sqliteError(err)
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return sqliteError(tx.Commit())
+}
+
+func (s *sqliteStorage) Exists(ctx context.Context, remotePath string) (bool, error) {
+	key, err := objectKey(remotePath)
+	if err != nil {
+		return false, err
+	}
+	var ok bool
+	err = s.db.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE path = ?)", s.table), key).Scan(&ok)
+	return ok, sqliteError(err)
+}
+
+func (s *sqliteStorage) Stat(ctx context.Context, remotePath string) (FileInfo, error) {
+	key, err := objectKey(remotePath)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	fi := FileInfo{Path: key}
+	var mtime int64
+	err = s.db.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT size, mtime FROM %s WHERE path = ?", s.table), key).Scan(&fi.Size, &mtime)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("stat %q: %w", key, sqliteError(err))
+	}
+	fi.ModTime = time.Unix(0, mtime)
+	return fi, nil
+}
+
+func (s *sqliteStorage) ListTopLevelDirs(ctx context.Context, prefix string) (map[string]bool, error) {
+	names, err := s.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	root, _ := CleanPath(prefix) // checked by List
+	normalizedPrefix := dirPrefix(root)
+
+	result := make(map[string]bool)
+	for _, name := range names {
+		rel := strings.TrimPrefix(name, normalizedPrefix)
+		if dir, _, ok := strings.Cut(rel, "/"); ok && dir != "" {
+			result[normalizedPrefix+dir] = true
+		}
+	}
+	return result, nil
+}
+
+// Rename replaces the destination and renames the source in one
+// transaction. The object keeps its modification time.
+func (s *sqliteStorage) Rename(ctx context.Context, oldRemotePath, newRemotePath string) error {
+	oldKey, err := objectKey(oldRemotePath)
+	if err != nil {
+		return err
+	}
+	newKey, err := objectKey(newRemotePath)
+	if err != nil {
+		return err
+	}
+	if oldKey == newKey {
+		return nil
+	}
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		var exists bool
+		err := tx.QueryRowContext(ctx,
+			fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE path = ?)", s.table), oldKey).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("rename %q -> %q: %w", oldKey, newKey, sqliteError(err))
+		}
+		if !exists {
+			return fmt.Errorf("rename %q: %w", oldKey, fs.ErrNotExist)
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE path = ?", s.table), newKey); err != nil {
+			return fmt.Errorf("rename %q -> %q: %w", oldKey, newKey, sqliteError(err))
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET path = ? WHERE path = ?", s.table), newKey, oldKey); err != nil {
+			return fmt.Errorf("rename %q -> %q: %w", oldKey, newKey, sqliteError(err))
+		}
+		return nil
+	})
+}
+
+// Ping checks the connection to the database.
+func (s *sqliteStorage) Ping(ctx context.Context) error {
+	return sqliteError(s.db.PingContext(ctx))
+}
+
+// sqliteError makes a database error match the storage error it stands
+// for: a missing row, or a SQLite result code for drivers that report one
+// with a Code method (modernc.org/sqlite).
+func sqliteError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return classify(err, ErrNotExist)
+	}
+	var ce interface{ Code() int }
+	if errors.As(err, &ce) {
+		return classify(err, sqliteCodeKind(ce.Code()))
+	}
+	return err
+}
+
+// sqliteCodeKind maps a SQLite result code, extended or not, to the
+// storage error it matches, or nil.
+func sqliteCodeKind(code int) error {
+	switch code & 0xff {
+	case 3, 8, 23: // SQLITE_PERM, SQLITE_READONLY, SQLITE_AUTH
+		return ErrPermission
+	case 5, 6: // SQLITE_BUSY, SQLITE_LOCKED
+		return ErrThrottled
+	case 10, 14: // SQLITE_IOERR, SQLITE_CANTOPEN
+		return ErrUnavailable
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSQLiteStorage_TableName(t *testing.T) {
+	_, err := NewSQLiteStorage(context.Background(), nil, SQLiteOptions{Table: "files; DROP TABLE x"})
+	assert.Error(t, err)
+}
+
+func TestSQLiteStorage_ListQuery(t *testing.T) {
+	s := &sqliteStorage{table: "files"}
+
+	query, args := s.listQuery("", ListOptions{})
+	assert.Equal(t, "SELECT path, size, mtime FROM files WHERE 1 ORDER BY path", query)
+	assert.Empty(t, args)
+
+	after := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	query, args = s.listQuery("wal/", ListOptions{ModifiedAfter: after, MinSize: 10, Suffix: ".aes"})
+	assert.Equal(t, "SELECT path, size, mtime FROM files WHERE path >= ? AND path < ?"+
+		" AND mtime > ? AND size >= ? AND substr(path, -4) = ? ORDER BY path", query)
+	assert.Equal(t, []any{"wal/", "wal0", after.UnixNano(), int64(10), ".aes"}, args)
+}
+
+func TestSQLiteCodeKind(t *testing.T) {
+	assert.ErrorIs(t, sqliteCodeKind(5), ErrThrottled)
+	assert.ErrorIs(t, sqliteCodeKind(5|2<<8), ErrThrottled, "SQLITE_BUSY_SNAPSHOT")
+	assert.ErrorIs(t, sqliteCodeKind(8), ErrPermission)
+	assert.ErrorIs(t, sqliteCodeKind(14), ErrUnavailable)
+	assert.NoError(t, sqliteCodeKind(19))
+}
@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+)
+
+// Stater is implemented by backends that can describe a single object
+// without listing its directory.
+type Stater interface {
+	// Stat returns the FileInfo of an object, or an error matching
+	// fs.ErrNotExist if it does not exist.
+	Stat(ctx context.Context, remotePath string) (FileInfo, error)
+}
+
+// StatObject returns the FileInfo of a single object, using Stater when the
+// storage implements it and falling back to listing the parent directory.
+func StatObject(ctx context.Context, st Storage, remotePath string) (FileInfo, error) {
+	if s, ok := st.(Stater); ok {
+		return s.Stat(ctx, remotePath)
+	}
+	infos, err := st.ListInfo(ctx, path.Dir(remotePath))
+	if err != nil {
+		return FileInfo{}, err
+	}
+	for _, fi := range infos {
+		if fi.Path == remotePath {
+			return fi, nil
+		}
+	}
+	return FileInfo{}, fmt.Errorf("stat %q: %w", remotePath, fs.ErrNotExist)
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"context"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listOnlyStorage hides the backend's Stat method.
+type listOnlyStorage struct {
+	Storage
+}
+
+func TestStatObject_FallsBackToListInfo(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	mem.Files["dir/a"] = []byte("abc")
+	mem.Files["dir/ab"] = []byte("abcdef")
+
+	for _, st := range []Storage{mem, listOnlyStorage{mem}} {
+		fi, err := StatObject(ctx, st, "dir/a")
+		require.NoError(t, err)
+		assert.Equal(t, "dir/a", fi.Path)
+		assert.Equal(t, int64(3), fi.Size)
+
+		_, err = StatObject(ctx, st, "dir/missing")
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	}
+}
+
+func TestLocalStorage_Stat(t *testing.T) {
+	ctx := context.Background()
+	st, err := NewLocal(&LocalStorageOpts{BaseDir: t.TempDir()})
+	require.NoError(t, err)
+	require.NoError(t, st.Put(ctx, "x/y", strings.NewReader("hello")))
+
+	fi, err := StatObject(ctx, st, "x/y")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), fi.Size)
+	assert.False(t, fi.ModTime.IsZero())
+
+	_, err = StatObject(ctx, st, "x")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = StatObject(ctx, st, "x/z")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
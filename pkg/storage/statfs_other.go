@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd)
+
+package storage
+
+// statfs is a no-op where syscall has no Statfs; the stat of the
+// directory stands alone.
+func statfs(string) error {
+	return nil
+}
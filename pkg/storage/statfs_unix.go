@@ -0,0 +1,16 @@
+//go:build linux || darwin || freebsd
+
+package storage
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+func statfs(dir string) error {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return &fs.PathError{Op: "statfs", Path: dir, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+// OpStats are the counters of one kind of operation.
+type OpStats struct {
+	Count  int64 // calls, failed ones included
+	Errors int64
+
+	// BytesIn is the data written by Put, BytesOut the data read from Get
+	// and GetRange readers.
+	BytesIn  int64
+	BytesOut int64
+
+	// MaxLatency is the longest call; for Get it runs until the reader is
+	// closed.
+	MaxLatency time.Duration
+}
+
+// Stats is a snapshot of the counters of a StatsStorage.
+type Stats struct {
+	Since time.Time
+	Ops   map[string]OpStats // by Event.Op: "put", "get", "list", ...
+}
+
+// Total sums the counters of all operations; MaxLatency is the largest.
+func (s Stats) Total() OpStats {
+	var t OpStats
+	for _, o := range s.Ops {
+		t.Count += o.Count
+		t.Errors += o.Errors
+		t.BytesIn += o.BytesIn
+		t.BytesOut += o.BytesOut
+		t.MaxLatency = max(t.MaxLatency, o.MaxLatency)
+	}
+	return t
+}
+
+// StatsStorage counts the operations on its backend, for applications that
+// want visibility into storage behavior without running a metrics system.
+// It is an EventStorage, so Events can be set as well.
+type StatsStorage struct {
+	*EventStorage
+
+	mu    sync.Mutex
+	since time.Time
+	ops   map[string]*OpStats
+}
+
+// NewStatsStorage wraps backend with zeroed counters.
+func NewStatsStorage(backend Storage) *StatsStorage {
+	s := &StatsStorage{
+		EventStorage: NewEventStorage(backend, Events{}),
+		since:        time.Now().UTC(),
+		ops:          make(map[string]*OpStats),
+	}
+	s.observe = s.record
+	return s
+}
+
+func (s *StatsStorage) record(ev Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.ops[ev.Op]
+	if !ok {
+		o = &OpStats{}
+		s.ops[ev.Op] = o
+	}
+	o.Count++
+	if ev.Err != nil {
+		o.Errors++
+	}
+	switch ev.Op {
+	case "put":
+		o.BytesIn += ev.Bytes
+	case "get", "get_range":
+		o.BytesOut += ev.Bytes
+	}
+	o.MaxLatency = max(o.MaxLatency, ev.Duration)
+}
+
+// Stats returns a snapshot of the counters.
+func (s *StatsStorage) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshot()
+}
+
+// ResetStats returns the counters and starts counting from zero.
+func (s *StatsStorage) ResetStats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.snapshot()
+	s.ops = make(map[string]*OpStats)
+	s.since = time.Now().UTC()
+	return stats
+}
+
+func (s *StatsStorage) snapshot() Stats {
+	ops := make(map[string]OpStats, len(s.ops))
+	for k, v := range s.ops {
+		ops[k] = *v
+	}
+	return Stats{Since: s.since, Ops: ops}
+}
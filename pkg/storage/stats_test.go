@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsStorage(t *testing.T) {
+	ctx := context.Background()
+	ss := NewStatsStorage(NewInMemoryStorage())
+
+	require.NoError(t, ss.Put(ctx, "wal/0001", strings.NewReader("hello")))
+	require.NoError(t, ss.Put(ctx, "wal/0002", strings.NewReader("hi")))
+
+	rc, err := ss.Get(ctx, "wal/0001")
+	require.NoError(t, err)
+	_, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+
+	_, err = ss.Get(ctx, "wal/missing")
+	require.Error(t, err)
+	_, err = ss.List(ctx, "wal")
+	require.NoError(t, err)
+
+	stats := ss.Stats()
+	assert.Equal(t, OpStats{Count: 2, BytesIn: 7}, withoutLatency(stats.Ops["put"]))
+	assert.Equal(t, OpStats{Count: 2, Errors: 1, BytesOut: 5}, withoutLatency(stats.Ops["get"]))
+	assert.Equal(t, OpStats{Count: 1}, withoutLatency(stats.Ops["list"]))
+
+	total := stats.Total()
+	assert.Equal(t, int64(5), total.Count)
+	assert.Equal(t, int64(1), total.Errors)
+	assert.Greater(t, total.MaxLatency, time.Duration(0))
+
+	old := ss.ResetStats()
+	assert.Equal(t, stats.Ops, old.Ops)
+	assert.Empty(t, ss.Stats().Ops)
+}
+
+func withoutLatency(o OpStats) OpStats {
+	o.MaxLatency = 0
+	return o
+}
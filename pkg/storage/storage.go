@@ -10,6 +10,18 @@ type FileInfo struct {
 	Path    string
 	ModTime time.Time
 	Size    int64
+
+	// StoredPath is the physical name backing Path when a wrapper maps
+	// logical names to stored ones (e.g. VariadicStorage); empty otherwise.
+	StoredPath string
+
+	// StoredSize is the number of bytes occupied in the backend, set by
+	// transforming wrappers whose Size reports the logical (decoded) size.
+	StoredSize int64
+
+	// IsDir marks a directory entry, listed only with WithDirs. Path has
+	// no trailing slash and Size is zero.
+	IsDir bool
 }
 
 // Storage is an interface for handling remote file storage.
@@ -20,10 +32,13 @@ type Storage interface {
 	// Get retrieves a remote file as a stream. Caller must close the reader.
 	Get(ctx context.Context, remotePath string) (io.ReadCloser, error)
 
-	// List returns all file names under the given directory.
+	// List returns all file names under the given directory, at any depth.
+	// "" lists the whole storage; a missing directory lists nothing, and
+	// "wal" does not match "wal2/x".
 	List(ctx context.Context, remotePath string) ([]string, error)
 
-	// ListInfo returns all file infos under the given directory.
+	// ListInfo returns all file infos under the given directory, with the
+	// semantics of List.
 	ListInfo(ctx context.Context, remotePath string) ([]FileInfo, error)
 
 	// Delete removes the specified file.
@@ -42,6 +57,7 @@ type Storage interface {
 	Exists(ctx context.Context, remotePath string) (bool, error)
 
 	// ListTopLevelDirs retrieves ONLY directories at a given prefix path.
+	// The keys are full paths, e.g. "pg/wal" for the prefix "pg".
 	ListTopLevelDirs(ctx context.Context, prefix string) (map[string]bool, error)
 
 	// Rename moves/renames a single object from oldRemotePath to newRemotePath.
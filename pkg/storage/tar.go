@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Export writes the objects under prefix to w as a tar stream, named by
+// their full path. Objects are read through st, so exporting a
+// TransformingStorage yields plaintext while exporting its Backend yields
+// the stored (compressed, encrypted) objects as they are.
+//
+// Logical sizes are not known before reading, so every object is spooled
+// to a temporary file first.
+func Export(ctx context.Context, st Storage, prefix string, w io.Writer) error {
+	infos, err := st.ListInfo(ctx, prefix)
+	if err != nil {
+		return fmt.Errorf("list %q: %w", prefix, err)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Path < infos[j].Path })
+
+	spool, err := os.CreateTemp("", "storecrypt-export-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = spool.Close()
+		_ = os.Remove(spool.Name())
+	}()
+
+	tw := tar.NewWriter(w)
+	var last string
+	for _, fi := range infos {
+		if fi.Path == last {
+			continue // several stored variants of one logical path
+		}
+		last = fi.Path
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := exportObject(ctx, st, tw, spool, fi); err != nil {
+			return fmt.Errorf("export %q: %w", fi.Path, err)
+		}
+	}
+	return tw.Close()
+}
+
+func exportObject(ctx context.Context, st Storage, tw *tar.Writer, spool *os.File, fi FileInfo) error {
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := spool.Truncate(0); err != nil {
+		return err
+	}
+	rc, err := st.Get(ctx, fi.Path)
+	if err != nil {
+		return err
+	}
+	n, err := CopyObject(spool, rc)
+	if err != nil {
+		return err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     fi.Path,
+		Size:     n,
+		Mode:     0o600,
+		ModTime:  fi.ModTime,
+		Format:   tar.FormatPAX,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, spool, n)
+	return err
+}
+
+// Import stores every regular file of the tar stream r in st, under its
+// name in the archive. Directories and other entry types are skipped;
+// names escaping the root (absolute or with "..") are rejected.
+func Import(ctx context.Context, st Storage, r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read tar: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("import %q: path escapes the archive root", hdr.Name)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := st.Put(ctx, name, tr); err != nil {
+			return fmt.Errorf("import %q: %w", name, err)
+		}
+	}
+}
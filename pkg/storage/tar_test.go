@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	ts := &TransformingStorage{
+		Backend:      mem,
+		Compressor:   codec.GzipCompressor{},
+		Decompressor: codec.GzipDecompressor{},
+	}
+	for _, p := range []string{"wal/0002", "wal/0001", "base/data"} {
+		require.NoError(t, ts.Put(ctx, p, strings.NewReader("content of "+p)))
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, Export(ctx, ts, "wal", &buf))
+
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		assert.Equal(t, "content of "+hdr.Name, string(data))
+	}
+	assert.Equal(t, []string{"wal/0001", "wal/0002"}, names)
+
+	// Importing through another TransformingStorage re-encodes the objects.
+	dst := NewInMemoryStorage()
+	dts := &TransformingStorage{
+		Backend:      dst,
+		Compressor:   codec.GzipCompressor{},
+		Decompressor: codec.GzipDecompressor{},
+	}
+	require.NoError(t, Import(ctx, dts, bytes.NewReader(buf.Bytes())))
+	rc, err := dts.Get(ctx, "wal/0002")
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, "content of wal/0002", string(got))
+
+	// Exporting the backend ships the stored objects as they are.
+	buf.Reset()
+	require.NoError(t, Export(ctx, mem, "wal", &buf))
+	raw := NewInMemoryStorage()
+	require.NoError(t, Import(ctx, raw, &buf))
+	require.NotEmpty(t, raw.Files["wal/0001.gz"])
+	assert.Equal(t, mem.Files["wal/0001.gz"], raw.Files["wal/0001.gz"])
+}
+
+func TestImport_RejectsEscapingNames(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "../etc/passwd", Size: 1, Mode: 0o600}))
+	_, err := tw.Write([]byte("x"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	mem := NewInMemoryStorage()
+	assert.Error(t, Import(context.Background(), mem, &buf))
+	assert.Empty(t, mem.Files)
+}
@@ -0,0 +1,393 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultTieringInterval is how often the background migration runs when
+// TieringPolicy.Interval is not set.
+const DefaultTieringInterval = 10 * time.Minute
+
+// TieringPolicy controls when objects move from the hot to the cold tier.
+type TieringPolicy struct {
+	// MinAge is the age (by ModTime) after which an object is migrated.
+	MinAge time.Duration
+
+	// Prefix limits migration to objects under this path ("" = everything).
+	Prefix string
+
+	// Interval between background migrations started with Start.
+	Interval time.Duration
+}
+
+// TieringStorage writes to a hot backend and migrates objects older than
+// the policy age to a cold backend. Reads fall through to the cold tier
+// transparently, listings merge both tiers.
+//
+// Typical setup: local disk as hot tier for recent WAL, S3 (Glacier-backed
+// storage class) as cold tier for history.
+type TieringStorage struct {
+	Hot    Storage
+	Cold   Storage
+	policy TieringPolicy
+	now    func() time.Time
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+	runMu  sync.Mutex // serializes migrations
+	paths  pathLocks  // serializes writes to one path with its migration
+}
+
+var _ Storage = (*TieringStorage)(nil)
+
+// NewTieringStorage creates a TieringStorage over the given tiers.
+func NewTieringStorage(hot, cold Storage, policy TieringPolicy) *TieringStorage {
+	if policy.Interval <= 0 {
+		policy.Interval = DefaultTieringInterval
+	}
+	return &TieringStorage{
+		Hot:    hot,
+		Cold:   cold,
+		policy: policy,
+		now:    time.Now,
+	}
+}
+
+// Migrate moves every hot object older than the policy age to the cold
+// tier and returns the number of migrated objects. Objects are copied
+// first and removed from the hot tier only after the copy succeeded.
+//
+// Put, Delete and Rename of a path wait for its migration. An object
+// changed in the hot tier behind the TieringStorage's back while it was
+// copied is left there, and the copy is dropped.
+func (t *TieringStorage) Migrate(ctx context.Context) (int, error) {
+	t.runMu.Lock()
+	defer t.runMu.Unlock()
+
+	infos, err := t.Hot.ListInfo(ctx, t.policy.Prefix)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	cutoff := t.now().Add(-t.policy.MinAge)
+	migrated := 0
+	for _, fi := range infos {
+		if err := ctx.Err(); err != nil {
+			return migrated, err
+		}
+		if fi.ModTime.After(cutoff) {
+			continue
+		}
+		ok, err := t.migrate(ctx, fi)
+		if err != nil {
+			return migrated, err
+		}
+		if ok {
+			migrated++
+		}
+	}
+	return migrated, nil
+}
+
+// migrate moves one object to the cold tier. It reports false if the hot
+// object changed since it was listed.
+func (t *TieringStorage) migrate(ctx context.Context, fi FileInfo) (bool, error) {
+	defer t.paths.lock(fi.Path)()
+
+	if err := copyObject(ctx, t.Hot, t.Cold, fi.Path, fi.Path); err != nil {
+		return false, fmt.Errorf("migrate %q: %w", fi.Path, err)
+	}
+	cur, err := StatObject(ctx, t.Hot, fi.Path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, fmt.Errorf("migrate %q: %w", fi.Path, err)
+	}
+	if err != nil || !cur.ModTime.Equal(fi.ModTime) || cur.Size != fi.Size {
+		// Rewritten or removed while it was copied: the copy is stale.
+		if err := t.Cold.Delete(ctx, fi.Path); err != nil {
+			return false, fmt.Errorf("drop stale copy of %q from cold tier: %w", fi.Path, err)
+		}
+		return false, nil
+	}
+	if err := t.Hot.Delete(ctx, fi.Path); err != nil {
+		return false, fmt.Errorf("remove migrated %q from hot tier: %w", fi.Path, err)
+	}
+	return true, nil
+}
+
+// Start runs Migrate every policy interval in a background goroutine until
+// Stop is called or ctx is canceled. Errors are reported to onError, which
+// may be nil.
+func (t *TieringStorage) Start(ctx context.Context, onError func(error)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	t.cancel = cancel
+	t.done = done
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(t.policy.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := t.Migrate(ctx); err != nil && onError != nil && ctx.Err() == nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop terminates the background migration and waits for it to exit.
+func (t *TieringStorage) Stop() {
+	t.mu.Lock()
+	cancel, done := t.cancel, t.done
+	t.cancel, t.done = nil, nil
+	t.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// tierFor returns the tier currently holding remotePath, hot first.
+func (t *TieringStorage) tierFor(ctx context.Context, remotePath string) (Storage, error) {
+	ok, err := t.Hot.Exists(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return t.Hot, nil
+	}
+	ok, err = t.Cold.Exists(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return t.Cold, nil
+	}
+	return nil, fs.ErrNotExist
+}
+
+// both runs fn on both tiers. A "not exist" on one tier is not an error;
+// it is returned only if neither tier had anything to act on.
+func (t *TieringStorage) both(fn func(Storage) error) error {
+	errHot := fn(t.Hot)
+	errCold := fn(t.Cold)
+
+	notExist := func(err error) bool { return errors.Is(err, fs.ErrNotExist) }
+	if notExist(errHot) && notExist(errCold) {
+		return errHot
+	}
+	if notExist(errHot) {
+		errHot = nil
+	}
+	if notExist(errCold) {
+		errCold = nil
+	}
+	return errors.Join(errHot, errCold)
+}
+
+// Put always writes to the hot tier. A stale cold copy of the same path is
+// removed so reads never observe an outdated version.
+func (t *TieringStorage) Put(ctx context.Context, remotePath string, r io.Reader) error {
+	defer t.paths.lock(remotePath)()
+	if err := t.Hot.Put(ctx, remotePath, r); err != nil {
+		return err
+	}
+	ok, err := t.Cold.Exists(ctx, remotePath)
+	if err != nil || !ok {
+		return err
+	}
+	return t.Cold.Delete(ctx, remotePath)
+}
+
+func (t *TieringStorage) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	tier, err := t.tierFor(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	return tier.Get(ctx, remotePath)
+}
+
+func (t *TieringStorage) List(ctx context.Context, remotePath string) ([]string, error) {
+	infos, err := t.ListInfo(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(infos))
+	for _, fi := range infos {
+		files = append(files, fi.Path)
+	}
+	return files, nil
+}
+
+// ListInfo merges both tiers; if a path exists in both, the hot entry wins.
+// The list filters apply to the merged listing, so that a hot entry left
+// out by them does not let the stale cold one through.
+func (t *TieringStorage) ListInfo(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	tierCtx := withoutListFilters(ctx)
+	hot, errHot := t.Hot.ListInfo(tierCtx, remotePath)
+	cold, errCold := t.Cold.ListInfo(tierCtx, remotePath)
+	if errHot != nil && errCold != nil {
+		return nil, errors.Join(errHot, errCold)
+	}
+	if errHot != nil && !errors.Is(errHot, fs.ErrNotExist) {
+		return nil, errHot
+	}
+	if errCold != nil && !errors.Is(errCold, fs.ErrNotExist) {
+		return nil, errCold
+	}
+
+	seen := make(map[string]bool, len(hot))
+	result := make([]FileInfo, 0, len(hot)+len(cold))
+	for _, fi := range hot {
+		seen[fi.Path] = true
+		result = append(result, fi)
+	}
+	for _, fi := range cold {
+		if !seen[fi.Path] {
+			result = append(result, fi)
+		}
+	}
+	return filterInfos(ctx, result), nil
+}
+
+func (t *TieringStorage) Delete(ctx context.Context, remotePath string) error {
+	defer t.paths.lock(remotePath)()
+	return t.both(func(s Storage) error { return s.Delete(ctx, remotePath) })
+}
+
+func (t *TieringStorage) DeleteAll(ctx context.Context, remotePath string) error {
+	return t.both(func(s Storage) error { return s.DeleteAll(ctx, remotePath) })
+}
+
+func (t *TieringStorage) DeleteDir(ctx context.Context, remotePath string) error {
+	return t.both(func(s Storage) error { return s.DeleteDir(ctx, remotePath) })
+}
+
+func (t *TieringStorage) DeleteAllBulk(ctx context.Context, paths []string) error {
+	return t.both(func(s Storage) error {
+		return s.DeleteAllBulk(ctx, append([]string(nil), paths...))
+	})
+}
+
+func (t *TieringStorage) Exists(ctx context.Context, remotePath string) (bool, error) {
+	_, err := t.tierFor(ctx, remotePath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (t *TieringStorage) ListTopLevelDirs(ctx context.Context, prefix string) (map[string]bool, error) {
+	hot, errHot := t.Hot.ListTopLevelDirs(ctx, prefix)
+	cold, errCold := t.Cold.ListTopLevelDirs(ctx, prefix)
+	if errHot != nil && errCold != nil {
+		return nil, errors.Join(errHot, errCold)
+	}
+	result := make(map[string]bool, len(hot)+len(cold))
+	for d := range hot {
+		result[d] = true
+	}
+	for d := range cold {
+		result[d] = true
+	}
+	return result, nil
+}
+
+// Rename renames the object within whichever tier currently holds it.
+func (t *TieringStorage) Rename(ctx context.Context, oldRemotePath, newRemotePath string) error {
+	if oldRemotePath == newRemotePath {
+		return nil
+	}
+	defer t.paths.lock(oldRemotePath, newRemotePath)()
+	tier, err := t.tierFor(ctx, oldRemotePath)
+	if err != nil {
+		return err
+	}
+	return tier.Rename(ctx, oldRemotePath, newRemotePath)
+}
+
+// copyObject streams a single object from src to dst.
+func copyObject(ctx context.Context, src, dst Storage, srcPath, dstPath string) error {
+	rc, err := src.Get(ctx, srcPath)
+	if err != nil {
+		return err
+	}
+	return ConsumeObject(rc, func(r io.Reader) error { return dst.Put(ctx, dstPath, r) })
+}
+
+// pathLocks hands out one mutex per path, dropped when no longer used.
+type pathLocks struct {
+	mu    sync.Mutex
+	locks map[string]*pathLock
+}
+
+type pathLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks the given paths, in sorted order so that callers cannot
+// deadlock, and returns the function unlocking them.
+func (pl *pathLocks) lock(paths ...string) func() {
+	paths = slices.Clone(paths)
+	slices.Sort(paths)
+	paths = slices.Compact(paths)
+
+	held := make([]*pathLock, len(paths))
+	pl.mu.Lock()
+	if pl.locks == nil {
+		pl.locks = make(map[string]*pathLock)
+	}
+	for i, p := range paths {
+		l := pl.locks[p]
+		if l == nil {
+			l = &pathLock{}
+			pl.locks[p] = l
+		}
+		l.refs++
+		held[i] = l
+	}
+	pl.mu.Unlock()
+
+	for _, l := range held {
+		l.Lock()
+	}
+	return func() {
+		for _, l := range held {
+			l.Unlock()
+		}
+		pl.mu.Lock()
+		for i, p := range paths {
+			if held[i].refs--; held[i].refs == 0 {
+				delete(pl.locks, p)
+			}
+		}
+		pl.mu.Unlock()
+	}
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTieringStorage_MigrateAndReadThrough(t *testing.T) {
+	ctx := context.Background()
+	hot := NewInMemoryStorage()
+	cold := NewInMemoryStorage()
+	ts := NewTieringStorage(hot, cold, TieringPolicy{MinAge: time.Hour, Prefix: "wal"})
+
+	require.NoError(t, ts.Put(ctx, "wal/0001", bytes.NewReader([]byte("one"))))
+	require.NoError(t, ts.Put(ctx, "wal/0002", bytes.NewReader([]byte("two"))))
+
+	// Nothing is old enough yet.
+	n, err := ts.Migrate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	ts.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	n, err = ts.Migrate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Empty(t, hot.Files)
+	assert.Len(t, cold.Files, 2)
+
+	rc, err := ts.Get(ctx, "wal/0001")
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, "one", string(data))
+
+	// Overwrite lands in hot and drops the stale cold copy.
+	require.NoError(t, ts.Put(ctx, "wal/0001", bytes.NewReader([]byte("new"))))
+	assert.NotContains(t, cold.Files, "wal/0001")
+
+	files, err := ts.List(ctx, "wal")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"wal/0001", "wal/0002"}, files)
+
+	require.NoError(t, ts.Delete(ctx, "wal/0002"))
+	ok, err := ts.Exists(ctx, "wal/0002")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+// blockingGetStorage signals on started and waits for release on the first
+// Get, pausing a migration in the middle of its copy.
+type blockingGetStorage struct {
+	Storage
+	once     sync.Once
+	started  chan struct{}
+	released chan struct{}
+}
+
+func (s *blockingGetStorage) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	s.once.Do(func() {
+		close(s.started)
+		<-s.released
+	})
+	return s.Storage.Get(ctx, remotePath)
+}
+
+func TestTieringStorage_PutDuringMigrate(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	hot := &blockingGetStorage{Storage: mem, started: make(chan struct{}), released: make(chan struct{})}
+	cold := NewInMemoryStorage()
+	ts := NewTieringStorage(hot, cold, TieringPolicy{})
+	require.NoError(t, mem.Put(ctx, "wal/0001", bytes.NewReader([]byte("old"))))
+
+	migrated := make(chan error, 1)
+	go func() {
+		_, err := ts.Migrate(ctx)
+		migrated <- err
+	}()
+	<-hot.started
+
+	put := make(chan error, 1)
+	go func() { put <- ts.Put(ctx, "wal/0001", bytes.NewReader([]byte("new!"))) }()
+	select {
+	case err := <-put:
+		t.Fatalf("Put finished during the migration of its path: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(hot.released)
+	require.NoError(t, <-migrated)
+	require.NoError(t, <-put)
+
+	assert.Equal(t, "new!", string(mem.Files["wal/0001"]))
+	assert.NotContains(t, cold.Files, "wal/0001")
+}
+
+func TestTieringStorage_MigrateSkipsRewrittenObject(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	hot := &blockingGetStorage{Storage: mem, started: make(chan struct{}), released: make(chan struct{})}
+	cold := NewInMemoryStorage()
+	ts := NewTieringStorage(hot, cold, TieringPolicy{})
+	require.NoError(t, mem.Put(ctx, "wal/0001", bytes.NewReader([]byte("old"))))
+
+	go func() {
+		<-hot.started
+		// Written to the hot tier directly, bypassing the path lock.
+		assert.NoError(t, mem.Put(ctx, "wal/0001", bytes.NewReader([]byte("new!"))))
+		close(hot.released)
+	}()
+	n, err := ts.Migrate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	assert.Equal(t, "new!", string(mem.Files["wal/0001"]))
+	assert.NotContains(t, cold.Files, "wal/0001")
+}
@@ -0,0 +1,295 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultTrashDir is the backend directory where TrashStorage moves
+// deleted objects.
+const DefaultTrashDir = ".trash"
+
+// trashTimeFormat is sortable and contains no characters that are
+// problematic in file names on any backend.
+const trashTimeFormat = "20060102T150405.000000000Z"
+
+// TrashEntry describes a single soft-deleted object.
+type TrashEntry struct {
+	Path      string    // original logical path
+	TrashPath string    // where the object currently lives
+	DeletedAt time.Time // generation timestamp
+}
+
+// TrashStorage is a soft-delete decorator: Delete, DeleteAll, DeleteDir and
+// DeleteAllBulk move objects under .trash/<ts>/ in the same backend instead
+// of destroying them. Objects can be brought back with Restore, and Purge
+// removes trash generations older than the configured retention.
+//
+// Trash contents are hidden from List, ListInfo and ListTopLevelDirs.
+type TrashStorage struct {
+	Backend   Storage
+	retention time.Duration
+	dir       string
+	now       func() time.Time
+}
+
+var _ Storage = (*TrashStorage)(nil)
+
+// NewTrashStorage creates a TrashStorage. Purge removes generations older
+// than retention; a zero retention purges everything.
+func NewTrashStorage(backend Storage, retention time.Duration) *TrashStorage {
+	return &TrashStorage{
+		Backend:   backend,
+		retention: retention,
+		dir:       DefaultTrashDir,
+		now:       time.Now,
+	}
+}
+
+func (ts *TrashStorage) isInternal(p string) bool {
+	return hasPathPrefix(p, ts.dir)
+}
+
+func (ts *TrashStorage) generation() string {
+	return path.Join(ts.dir, ts.now().UTC().Format(trashTimeFormat))
+}
+
+// moveToTrash moves every given object into a single trash generation.
+func (ts *TrashStorage) moveToTrash(ctx context.Context, paths []string) error {
+	gen := ts.generation()
+	for _, p := range paths {
+		if ts.isInternal(p) {
+			continue
+		}
+		if err := ts.Backend.Rename(ctx, p, path.Join(gen, p)); err != nil {
+			return fmt.Errorf("move %q to trash: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func (ts *TrashStorage) listUnder(ctx context.Context, prefix string) ([]string, error) {
+	files, err := ts.Backend.List(ctx, prefix)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return files, nil
+}
+
+// Trash lists soft-deleted objects, newest generation first.
+func (ts *TrashStorage) Trash(ctx context.Context) ([]TrashEntry, error) {
+	files, err := ts.listUnder(ctx, ts.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]TrashEntry, 0, len(files))
+	for _, f := range files {
+		rel := strings.TrimPrefix(f, ts.dir+"/")
+		gen, orig, ok := strings.Cut(rel, "/")
+		if !ok {
+			continue
+		}
+		deletedAt, err := time.Parse(trashTimeFormat, gen)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, TrashEntry{Path: orig, TrashPath: f, DeletedAt: deletedAt})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].DeletedAt.Equal(entries[j].DeletedAt) {
+			return entries[i].Path < entries[j].Path
+		}
+		return entries[i].DeletedAt.After(entries[j].DeletedAt)
+	})
+	return entries, nil
+}
+
+// Restore moves the most recently deleted version of remotePath back to its
+// original location. It fails if the object already exists there.
+func (ts *TrashStorage) Restore(ctx context.Context, remotePath string) error {
+	entries, err := ts.Trash(ctx)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Path != remotePath {
+			continue
+		}
+		exists, err := ts.Backend.Exists(ctx, remotePath)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("restore %q: %w", remotePath, fs.ErrExist)
+		}
+		return ts.Backend.Rename(ctx, e.TrashPath, remotePath)
+	}
+	return fmt.Errorf("restore %q: %w", remotePath, fs.ErrNotExist)
+}
+
+// Expired lists the soft-deleted objects older than the retention, newest
+// generation first; they are what the next Purge removes.
+func (ts *TrashStorage) Expired(ctx context.Context) ([]TrashEntry, error) {
+	entries, err := ts.Trash(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := ts.now().Add(-ts.retention)
+	expired := entries[:0]
+	for _, e := range entries {
+		if !e.DeletedAt.After(cutoff) {
+			expired = append(expired, e)
+		}
+	}
+	return expired, nil
+}
+
+// Purge permanently removes trash generations older than the retention.
+// It returns the number of objects removed.
+func (ts *TrashStorage) Purge(ctx context.Context) (int, error) {
+	entries, err := ts.Expired(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	expired := make([]string, len(entries))
+	for i, e := range entries {
+		expired[i] = e.TrashPath
+	}
+	if err := ts.Backend.DeleteAllBulk(ctx, expired); err != nil {
+		return 0, err
+	}
+	return len(expired), nil
+}
+
+// Storage implementation
+
+func (ts *TrashStorage) Put(ctx context.Context, remotePath string, r io.Reader) error {
+	return ts.Backend.Put(ctx, remotePath, r)
+}
+
+func (ts *TrashStorage) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	return ts.Backend.Get(ctx, remotePath)
+}
+
+func (ts *TrashStorage) List(ctx context.Context, remotePath string) ([]string, error) {
+	files, err := ts.Backend.List(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	result := files[:0]
+	for _, f := range files {
+		if !ts.isInternal(f) {
+			result = append(result, f)
+		}
+	}
+	return result, nil
+}
+
+func (ts *TrashStorage) ListInfo(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	files, err := ts.Backend.ListInfo(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	result := files[:0]
+	for _, f := range files {
+		if !ts.isInternal(f.Path) {
+			result = append(result, f)
+		}
+	}
+	return result, nil
+}
+
+// Delete moves a single object to the trash.
+func (ts *TrashStorage) Delete(ctx context.Context, remotePath string) error {
+	exists, err := ts.Backend.Exists(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fs.ErrNotExist
+	}
+	return ts.moveToTrash(ctx, []string{remotePath})
+}
+
+// DeleteAll moves every object under remotePath to the trash.
+func (ts *TrashStorage) DeleteAll(ctx context.Context, remotePath string) error {
+	files, err := ts.listUnder(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+	return ts.moveToTrash(ctx, files)
+}
+
+// DeleteDir moves every object under remotePath to the trash and then
+// removes the (now empty) directory.
+func (ts *TrashStorage) DeleteDir(ctx context.Context, remotePath string) error {
+	if err := ts.DeleteAll(ctx, remotePath); err != nil {
+		return err
+	}
+	left, err := ts.listUnder(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+	if len(left) > 0 {
+		return nil
+	}
+	return ts.Backend.DeleteDir(ctx, remotePath)
+}
+
+// DeleteAllBulk moves every object under each path to the same trash
+// generation.
+func (ts *TrashStorage) DeleteAllBulk(ctx context.Context, paths []string) error {
+	var all []string
+	for _, p := range paths {
+		exists, err := ts.Backend.Exists(ctx, p)
+		if err != nil {
+			return err
+		}
+		if exists {
+			all = append(all, p)
+			continue
+		}
+		files, err := ts.listUnder(ctx, p)
+		if err != nil {
+			return err
+		}
+		all = append(all, files...)
+	}
+	return ts.moveToTrash(ctx, all)
+}
+
+func (ts *TrashStorage) Exists(ctx context.Context, remotePath string) (bool, error) {
+	return ts.Backend.Exists(ctx, remotePath)
+}
+
+func (ts *TrashStorage) ListTopLevelDirs(ctx context.Context, prefix string) (map[string]bool, error) {
+	dirs, err := ts.Backend.ListTopLevelDirs(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	for d := range dirs {
+		if ts.isInternal(d) {
+			delete(dirs, d)
+		}
+	}
+	return dirs, nil
+}
+
+func (ts *TrashStorage) Rename(ctx context.Context, oldRemotePath, newRemotePath string) error {
+	return ts.Backend.Rename(ctx, oldRemotePath, newRemotePath)
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrashStorage_DeleteRestorePurge(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	ts := NewTrashStorage(mem, 24*time.Hour)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts.now = func() time.Time { return now }
+
+	require.NoError(t, ts.Put(ctx, "wal/0001", bytes.NewReader([]byte("one"))))
+	require.NoError(t, ts.Put(ctx, "wal/0002", bytes.NewReader([]byte("two"))))
+
+	require.NoError(t, ts.Delete(ctx, "wal/0001"))
+	ok, err := ts.Exists(ctx, "wal/0001")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Hidden from listings but still in the backend.
+	files, err := ts.List(ctx, "wal")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"wal/0002"}, files)
+	assert.Len(t, mem.Files, 2)
+
+	require.NoError(t, ts.Restore(ctx, "wal/0001"))
+	ok, err = ts.Exists(ctx, "wal/0001")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	now = now.Add(time.Hour)
+	require.NoError(t, ts.DeleteAll(ctx, "wal"))
+	entries, err := ts.Trash(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	// Still within retention.
+	n, err := ts.Purge(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	now = now.Add(25 * time.Hour)
+	n, err = ts.Purge(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Empty(t, mem.Files)
+}
+
+func TestTrashStorage_RestoreMissing(t *testing.T) {
+	ts := NewTrashStorage(NewInMemoryStorage(), time.Hour)
+	require.Error(t, ts.Restore(context.Background(), "nope"))
+}
@@ -0,0 +1,367 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultTTLManifest is the backend object where TTLStorage persists
+// expiry times.
+const DefaultTTLManifest = ".ttl/manifest.json"
+
+type ttlManifest struct {
+	Expires map[string]time.Time `json:"expires"`
+}
+
+// TTLStorage adds per-object expiry to backends without native lifecycle
+// rules (SFTP, local). Expiry times are persisted in a manifest object in
+// the same backend; Sweep deletes objects whose expiry has passed.
+//
+// Expired objects are hidden from Get/Exists/List even before the next
+// Sweep, so readers never observe data past its expiry.
+//
+// Writes re-read the manifest and apply their change to what is stored,
+// so expiries set by another TTLStorage on the same backend are kept, and
+// Expired and Sweep judge every object by the stored expiry. The manifest
+// update is a plain read-modify-write though: instances writing at the
+// same time can lose one another's change, so only one of them should be
+// writing at a time. Get, Exists and List use the copy loaded by the last
+// write, Expired or Sweep.
+type TTLStorage struct {
+	Backend    Storage
+	DefaultTTL time.Duration // applied by Put; zero means "never expires"
+	manifest   string
+	now        func() time.Time
+
+	mu      sync.Mutex
+	expires map[string]time.Time // nil until loaded
+}
+
+var _ Storage = (*TTLStorage)(nil)
+
+// NewTTLStorage creates a TTLStorage. defaultTTL is applied to plain Put
+// calls; use PutWithTTL to choose the expiry per object.
+func NewTTLStorage(backend Storage, defaultTTL time.Duration) *TTLStorage {
+	return &TTLStorage{
+		Backend:    backend,
+		DefaultTTL: defaultTTL,
+		manifest:   DefaultTTLManifest,
+		now:        time.Now,
+	}
+}
+
+func (t *TTLStorage) load(ctx context.Context) error {
+	t.mu.Lock()
+	loaded := t.expires != nil
+	t.mu.Unlock()
+	if loaded {
+		return nil
+	}
+
+	expires, err := t.readManifest(ctx)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	if t.expires == nil {
+		t.expires = expires
+	}
+	t.mu.Unlock()
+	return nil
+}
+
+// readManifest reads the stored expiry map; it is empty if there is no
+// manifest yet.
+func (t *TTLStorage) readManifest(ctx context.Context) (map[string]time.Time, error) {
+	rc, err := t.Backend.Get(ctx, t.manifest)
+	if errors.Is(err, fs.ErrNotExist) {
+		return make(map[string]time.Time), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m ttlManifest
+	err = json.NewDecoder(rc).Decode(&m)
+	_ = rc.Close()
+	if err != nil {
+		return nil, fmt.Errorf("decode ttl manifest: %w", err)
+	}
+	if m.Expires == nil {
+		m.Expires = make(map[string]time.Time)
+	}
+	return m.Expires, nil
+}
+
+// reload re-reads the manifest into the cached copy and returns it.
+func (t *TTLStorage) reload(ctx context.Context) (map[string]time.Time, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	expires, err := t.readManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	t.expires = expires
+	return expires, nil
+}
+
+// update applies fn to the stored expiry map, re-read so that changes
+// made by other TTLStorages are merged rather than overwritten, and
+// persists the manifest if fn reports a change.
+func (t *TTLStorage) update(ctx context.Context, fn func(expires map[string]time.Time) bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	expires, err := t.readManifest(ctx)
+	if err != nil {
+		return err
+	}
+	t.expires = expires
+	if !fn(expires) {
+		return nil
+	}
+	data, err := json.Marshal(&ttlManifest{Expires: expires})
+	if err != nil {
+		return err
+	}
+	return t.Backend.Put(ctx, t.manifest, bytes.NewReader(data))
+}
+
+func (t *TTLStorage) expired(ctx context.Context, remotePath string) (bool, error) {
+	if err := t.load(ctx); err != nil {
+		return false, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	exp, ok := t.expires[remotePath]
+	return ok && !t.now().Before(exp), nil
+}
+
+// ExpiresAt returns the expiry of an object; ok is false if it never expires.
+func (t *TTLStorage) ExpiresAt(ctx context.Context, remotePath string) (exp time.Time, ok bool, err error) {
+	if err := t.load(ctx); err != nil {
+		return time.Time{}, false, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	exp, ok = t.expires[remotePath]
+	return exp, ok, nil
+}
+
+// PutWithTTL stores an object that expires after ttl (zero = never).
+func (t *TTLStorage) PutWithTTL(ctx context.Context, remotePath string, r io.Reader, ttl time.Duration) error {
+	if err := t.Backend.Put(ctx, remotePath, r); err != nil {
+		return err
+	}
+	return t.update(ctx, func(expires map[string]time.Time) bool {
+		if ttl <= 0 {
+			_, had := expires[remotePath]
+			delete(expires, remotePath)
+			return had
+		}
+		expires[remotePath] = t.now().Add(ttl).UTC()
+		return true
+	})
+}
+
+// Expired returns the objects whose expiry has passed, sorted; they are
+// what the next Sweep removes.
+func (t *TTLStorage) Expired(ctx context.Context) ([]string, error) {
+	expires, err := t.reload(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := t.now()
+	var due []string
+	for p, exp := range expires {
+		if !now.Before(exp) {
+			due = append(due, p)
+		}
+	}
+	sort.Strings(due)
+	return due, nil
+}
+
+// Sweep deletes every expired object and returns the number removed. The
+// expiry of each object is read again right before it is deleted, so one
+// that was extended or removed meanwhile is kept.
+func (t *TTLStorage) Sweep(ctx context.Context) (int, error) {
+	due, err := t.Expired(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var removed []string
+	var errs []error
+	for _, p := range due {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		expires, err := t.reload(ctx)
+		if err != nil {
+			errs = append(errs, err)
+			break
+		}
+		if exp, ok := expires[p]; !ok || t.now().Before(exp) {
+			continue
+		}
+		if err := t.Backend.Delete(ctx, p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("sweep %q: %w", p, err))
+			continue
+		}
+		removed = append(removed, p)
+	}
+
+	err = t.update(ctx, func(expires map[string]time.Time) bool {
+		for _, p := range removed {
+			delete(expires, p)
+		}
+		return len(removed) > 0
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return len(removed), errors.Join(errs...)
+}
+
+func (t *TTLStorage) forget(ctx context.Context, match func(p string) bool) error {
+	return t.update(ctx, func(expires map[string]time.Time) bool {
+		changed := false
+		for p := range expires {
+			if match(p) {
+				delete(expires, p)
+				changed = true
+			}
+		}
+		return changed
+	})
+}
+
+// Storage implementation
+
+func (t *TTLStorage) Put(ctx context.Context, remotePath string, r io.Reader) error {
+	return t.PutWithTTL(ctx, remotePath, r, t.DefaultTTL)
+}
+
+func (t *TTLStorage) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	gone, err := t.expired(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	if gone {
+		return nil, fs.ErrNotExist
+	}
+	return t.Backend.Get(ctx, remotePath)
+}
+
+func (t *TTLStorage) List(ctx context.Context, remotePath string) ([]string, error) {
+	files, err := t.Backend.List(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	result := files[:0]
+	for _, f := range files {
+		gone, err := t.expired(ctx, f)
+		if err != nil {
+			return nil, err
+		}
+		if !gone && f != t.manifest {
+			result = append(result, f)
+		}
+	}
+	return result, nil
+}
+
+func (t *TTLStorage) ListInfo(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	files, err := t.Backend.ListInfo(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	result := files[:0]
+	for _, f := range files {
+		gone, err := t.expired(ctx, f.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !gone && f.Path != t.manifest {
+			result = append(result, f)
+		}
+	}
+	return result, nil
+}
+
+func (t *TTLStorage) Delete(ctx context.Context, remotePath string) error {
+	if err := t.Backend.Delete(ctx, remotePath); err != nil {
+		return err
+	}
+	return t.forget(ctx, func(p string) bool { return p == remotePath })
+}
+
+func (t *TTLStorage) DeleteAll(ctx context.Context, remotePath string) error {
+	if err := t.Backend.DeleteAll(ctx, remotePath); err != nil {
+		return err
+	}
+	return t.forget(ctx, func(p string) bool { return p != remotePath && hasPathPrefix(p, remotePath) })
+}
+
+func (t *TTLStorage) DeleteDir(ctx context.Context, remotePath string) error {
+	if err := t.Backend.DeleteDir(ctx, remotePath); err != nil {
+		return err
+	}
+	return t.forget(ctx, func(p string) bool { return hasPathPrefix(p, remotePath) })
+}
+
+func (t *TTLStorage) DeleteAllBulk(ctx context.Context, paths []string) error {
+	if err := t.Backend.DeleteAllBulk(ctx, append([]string(nil), paths...)); err != nil {
+		return err
+	}
+	return t.forget(ctx, func(p string) bool {
+		for _, prefix := range paths {
+			if hasPathPrefix(p, prefix) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (t *TTLStorage) Exists(ctx context.Context, remotePath string) (bool, error) {
+	gone, err := t.expired(ctx, remotePath)
+	if err != nil || gone {
+		return false, err
+	}
+	return t.Backend.Exists(ctx, remotePath)
+}
+
+func (t *TTLStorage) ListTopLevelDirs(ctx context.Context, prefix string) (map[string]bool, error) {
+	return t.Backend.ListTopLevelDirs(ctx, prefix)
+}
+
+// Rename carries the expiry over to the new path.
+func (t *TTLStorage) Rename(ctx context.Context, oldRemotePath, newRemotePath string) error {
+	if oldRemotePath == newRemotePath {
+		return nil
+	}
+	if err := t.Backend.Rename(ctx, oldRemotePath, newRemotePath); err != nil {
+		return err
+	}
+	return t.update(ctx, func(expires map[string]time.Time) bool {
+		exp, hadOld := expires[oldRemotePath]
+		_, hadNew := expires[newRemotePath]
+		delete(expires, oldRemotePath)
+		delete(expires, newRemotePath)
+		if hadOld {
+			expires[newRemotePath] = exp
+		}
+		return hadOld || hadNew
+	})
+}
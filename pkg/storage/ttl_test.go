@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTTLStorage_ExpireAndSweep(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	ts := NewTTLStorage(mem, 0)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts.now = func() time.Time { return now }
+
+	require.NoError(t, ts.PutWithTTL(ctx, "cache/a", bytes.NewReader([]byte("a")), time.Hour))
+	require.NoError(t, ts.PutWithTTL(ctx, "cache/b", bytes.NewReader([]byte("b")), 3*time.Hour))
+	require.NoError(t, ts.Put(ctx, "cache/keep", bytes.NewReader([]byte("k"))))
+	require.NoError(t, ts.Rename(ctx, "cache/b", "cache/c"))
+
+	now = now.Add(2 * time.Hour)
+
+	// Expired but not yet swept: hidden from readers.
+	ok, err := ts.Exists(ctx, "cache/a")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Contains(t, mem.Files, "cache/a")
+
+	n, err := ts.Sweep(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.NotContains(t, mem.Files, "cache/a")
+
+	// The manifest survives a restart.
+	ts2 := NewTTLStorage(mem, 0)
+	ts2.now = func() time.Time { return now.Add(2 * time.Hour) }
+	n, err = ts2.Sweep(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	files, err := ts2.List(ctx, "cache")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cache/keep"}, files)
+}
+
+func TestTTLStorage_SharedManifest(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	a := NewTTLStorage(mem, time.Hour)
+	b := NewTTLStorage(mem, time.Hour)
+
+	// Both load the (empty) manifest before either writes.
+	for _, ts := range []*TTLStorage{a, b} {
+		_, err := ts.Exists(ctx, "x")
+		require.NoError(t, err)
+	}
+	require.NoError(t, a.Put(ctx, "from-a", bytes.NewReader([]byte("a"))))
+	require.NoError(t, b.Put(ctx, "from-b", bytes.NewReader([]byte("b"))))
+	require.NoError(t, a.Delete(ctx, "from-a"))
+
+	// Each write merged into what the other stored.
+	c := NewTTLStorage(mem, 0)
+	for p, want := range map[string]bool{"from-a": false, "from-b": true} {
+		_, ok, err := c.ExpiresAt(ctx, p)
+		require.NoError(t, err)
+		assert.Equal(t, want, ok, p)
+	}
+}
+
+func TestTTLStorage_SweepRereadsExpiry(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := NewTTLStorage(mem, time.Minute)
+	b := NewTTLStorage(mem, time.Minute)
+	a.now = func() time.Time { return now }
+	b.now = func() time.Time { return now }
+
+	require.NoError(t, a.Put(ctx, "extended", bytes.NewReader([]byte("e"))))
+	require.NoError(t, a.Put(ctx, "expired", bytes.NewReader([]byte("x"))))
+	now = now.Add(2 * time.Minute)
+
+	// b renews one object after a last looked at the manifest.
+	require.NoError(t, b.PutWithTTL(ctx, "extended", bytes.NewReader([]byte("e2")), time.Hour))
+
+	due, err := a.Expired(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"expired"}, due)
+
+	n, err := a.Sweep(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Contains(t, mem.Files, "extended")
+	assert.NotContains(t, mem.Files, "expired")
+
+	// A renewal while the sweep is running is honored too.
+	require.NoError(t, a.Put(ctx, "first", bytes.NewReader([]byte("1"))))
+	require.NoError(t, a.Put(ctx, "second", bytes.NewReader([]byte("2"))))
+	now = now.Add(2 * time.Minute)
+	a.Backend = &deleteHookStorage{Storage: mem, onDelete: func(p string) {
+		if p == "first" {
+			require.NoError(t, b.PutWithTTL(ctx, "second", bytes.NewReader([]byte("2")), time.Hour))
+		}
+	}}
+	n, err = a.Sweep(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.NotContains(t, mem.Files, "first")
+	assert.Contains(t, mem.Files, "second")
+}
+
+// deleteHookStorage calls onDelete before every Delete.
+type deleteHookStorage struct {
+	Storage
+	onDelete func(p string)
+}
+
+func (s *deleteHookStorage) Delete(ctx context.Context, remotePath string) error {
+	s.onDelete(remotePath)
+	return s.Storage.Delete(ctx, remotePath)
+}
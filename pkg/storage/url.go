@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Open connects to the backend a URL describes, without transforms. The
+// returned function closes the connection. See ParseURL for the syntax;
+// to apply the codec and crypter parameters, open the RemoteConfig it
+// returns instead.
+func Open(ctx context.Context, rawURL string) (Storage, func() error, error) {
+	rc, err := ParseURL(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rc.OpenBackend(ctx)
+}
+
+// ParseURL translates a backend URL into a RemoteConfig:
+//
+//	s3://bucket/prefix?region=eu-west-1&endpoint=https://minio:9000&path_style=1
+//	sftp://user@host:2323/backups?key_file=/home/me/.ssh/id_ed25519
+//	file:///var/backups
+//	mem://
+//
+// S3 credentials may be given as user and password ("s3://KEY:SECRET@bucket"),
+// otherwise they are read from the environment as for a configured remote.
+// The query takes the settings of RemoteConfig and of the backend by their
+// YAML names, e.g. codec=zstd, crypter=aes, password_env=PGPASS,
+// delete_concurrency=16; unknown parameters are an error.
+func ParseURL(rawURL string) (RemoteConfig, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return RemoteConfig{}, fmt.Errorf("storage: %w", err)
+	}
+	if u.Opaque != "" {
+		return RemoteConfig{}, fmt.Errorf("storage: URL %q needs a //", rawURL)
+	}
+	rc := RemoteConfig{Backend: u.Scheme}
+	switch u.Scheme {
+	case "s3":
+		if u.Host == "" {
+			return RemoteConfig{}, fmt.Errorf("storage: URL %q needs a bucket", rawURL)
+		}
+		rc.S3.Bucket = u.Host
+		rc.Prefix = strings.Trim(u.Path, "/")
+		if u.User != nil {
+			rc.S3.AccessKeyID = u.User.Username()
+			rc.S3.SecretAccessKey, _ = u.User.Password()
+		}
+	case "sftp":
+		if u.Hostname() == "" {
+			return RemoteConfig{}, fmt.Errorf("storage: URL %q needs a host", rawURL)
+		}
+		rc.SFTP.Host = u.Hostname()
+		rc.SFTP.Port = u.Port()
+		if u.User != nil {
+			rc.SFTP.User = u.User.Username()
+		}
+		rc.Prefix = u.Path
+	case "file":
+		if u.Host != "" && u.Host != "localhost" {
+			return RemoteConfig{}, fmt.Errorf("storage: URL %q: file URLs cannot name a host", rawURL)
+		}
+		rc.Backend = "local"
+		rc.Local.Dir = u.Path
+	case "mem":
+	default:
+		return RemoteConfig{}, fmt.Errorf("storage: unknown URL scheme %q", u.Scheme)
+	}
+
+	for key, values := range u.Query() {
+		if err := rc.setParam(key, values[len(values)-1]); err != nil {
+			return RemoteConfig{}, fmt.Errorf("storage: URL parameter %s: %w", key, err)
+		}
+	}
+	return rc, nil
+}
+
+// setParam applies one query parameter of a backend URL.
+func (rc *RemoteConfig) setParam(key, value string) error {
+	str := map[string]*string{
+		"codec":         &rc.Codec,
+		"crypter":       &rc.Crypter,
+		"password_file": &rc.PasswordFile,
+		"password_env":  &rc.PasswordEnv,
+		"key_file":      &rc.KeyFile,
+		"key_id":        &rc.KeyID,
+		"write_ext":     &rc.WriteExt,
+	}
+	boolean := map[string]*bool{}
+	integer := map[string]*int{
+		"delete_concurrency": &rc.DeleteConcurrency,
+		"codec_level":        &rc.CodecLevel,
+	}
+	switch rc.Backend {
+	case "s3":
+		str["endpoint"] = &rc.S3.Endpoint
+		str["region"] = &rc.S3.Region
+		boolean["path_style"] = &rc.S3.PathStyle
+		boolean["insecure"] = &rc.S3.Insecure
+		boolean["auto_region"] = &rc.S3.AutoRegion
+		integer["list_concurrency"] = &rc.S3.ListConcurrency
+		if key == "list_shards" {
+			rc.S3.ListShards = strings.Split(value, ",")
+			return nil
+		}
+	case "sftp":
+		// key_file is the SSH key here, not the envelope master key.
+		str["key_file"] = &rc.SFTP.KeyFile
+		str["passphrase"] = &rc.SFTP.Passphrase
+		boolean["ssh_config"] = &rc.SFTP.SSHConfig
+	case "local":
+		boolean["fsync"] = &rc.Local.Fsync
+	}
+
+	if p, ok := str[key]; ok {
+		*p = value
+		return nil
+	}
+	if p, ok := boolean[key]; ok {
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*p = v
+		return nil
+	}
+	if p, ok := integer[key]; ok {
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*p = v
+		return nil
+	}
+	return fmt.Errorf("unknown for %s URLs", rc.Backend)
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want RemoteConfig
+	}{
+		{
+			url: "s3://backups/pg/main?region=eu-west-1&endpoint=https://minio:9000&path_style=1&codec=zstd&codec_level=19&list_shards=0,8",
+			want: RemoteConfig{Backend: "s3", Prefix: "pg/main", Codec: "zstd", CodecLevel: 19, S3: S3Remote{
+				Bucket: "backups", Region: "eu-west-1", Endpoint: "https://minio:9000", PathStyle: true,
+				ListShards: []string{"0", "8"},
+			}},
+		},
+		{
+			url: "s3://KEY:SECRET@backups",
+			want: RemoteConfig{Backend: "s3", S3: S3Remote{
+				Bucket: "backups", AccessKeyID: "KEY", SecretAccessKey: "SECRET",
+			}},
+		},
+		{
+			url: "sftp://postgres@db1:2323/backups?key_file=/keys/id&crypter=aes&password_env=PGPASS",
+			want: RemoteConfig{Backend: "sftp", Prefix: "/backups", Crypter: "aes", PasswordEnv: "PGPASS", SFTP: SFTPRemote{
+				Host: "db1", Port: "2323", User: "postgres", KeyFile: "/keys/id",
+			}},
+		},
+		{
+			url:  "file:///var/backups?fsync=true&delete_concurrency=16",
+			want: RemoteConfig{Backend: "local", DeleteConcurrency: 16, Local: LocalRemote{Dir: "/var/backups", Fsync: true}},
+		},
+		{
+			url:  "mem://",
+			want: RemoteConfig{Backend: "mem"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			rc, err := ParseURL(tt.url)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, rc)
+		})
+	}
+}
+
+func TestParseURL_Errors(t *testing.T) {
+	for url, msg := range map[string]string{
+		"ftp://host/dir":              `unknown URL scheme "ftp"`,
+		"s3:///prefix":                "needs a bucket",
+		"sftp:///backups":             "needs a host",
+		"file://remote/var":           "cannot name a host",
+		"s3://b?fsync=1":              "URL parameter fsync: unknown for s3 URLs",
+		"file:///tmp?fsync=maybe":     "URL parameter fsync",
+		"mem://?delete_concurrency=x": "URL parameter delete_concurrency",
+		"s3:bucket":                   "needs a //",
+	} {
+		_, err := ParseURL(url)
+		assert.ErrorContains(t, err, msg, url)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	for _, url := range []string{"file://" + dir, "mem://"} {
+		st, closeFn, err := Open(ctx, url)
+		require.NoError(t, err, url)
+		require.NoError(t, st.Put(ctx, "a/b.txt", strings.NewReader("payload")))
+		ok, err := st.Exists(ctx, "a/b.txt")
+		require.NoError(t, err)
+		assert.True(t, ok, url)
+		require.NoError(t, closeFn())
+	}
+	assert.FileExists(t, dir+"/a/b.txt")
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Walker is implemented by backends (and wrappers) that can stream a
+// listing instead of collecting it, so that memory stays bounded however
+// many objects live under a prefix.
+type Walker interface {
+	// WalkInfo calls fn for every object ListInfo would return, in no
+	// particular order. If fn returns fs.SkipAll the walk stops and
+	// WalkInfo returns nil; any other error stops it and is returned. The
+	// walk also stops once ctx is done.
+	WalkInfo(ctx context.Context, remotePath string, fn func(fi FileInfo) error) error
+}
+
+// WalkInfo streams the objects under remotePath to fn, using Walker when
+// the storage implements it and falling back to ListInfo. The list filters
+// attached to ctx hold whether or not st applies them.
+func WalkInfo(ctx context.Context, st Storage, remotePath string, fn func(fi FileInfo) error) error {
+	if o := ListOptionsFromContext(ctx); o.filtered() {
+		walk := fn
+		fn = func(fi FileInfo) error {
+			if !o.Match(fi) {
+				return nil
+			}
+			return walk(fi)
+		}
+	}
+	if w, ok := st.(Walker); ok {
+		return w.WalkInfo(ctx, remotePath, fn)
+	}
+	infos, err := st.ListInfo(ctx, remotePath)
+	if err != nil {
+		return err
+	}
+	return walkSlice(ctx, infos, fn)
+}
+
+// walkSlice feeds infos to fn with the semantics of Walker.
+func walkSlice(ctx context.Context, infos []FileInfo, fn func(fi FileInfo) error) error {
+	for _, fi := range infos {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(fi); err != nil {
+			return walkResult(err)
+		}
+	}
+	return nil
+}
+
+// walkResult maps the error that stopped a walk to WalkInfo's result.
+func walkResult(err error) error {
+	if err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// collectInfo implements ListInfo on top of a WalkInfo method.
+func collectInfo(ctx context.Context, w Walker, remotePath string) ([]FileInfo, error) {
+	var result []FileInfo
+	err := w.WalkInfo(ctx, remotePath, func(fi FileInfo) error {
+		result = append(result, fi)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// impliedDirs derives the directory entries of a listing from keys, for
+// backends without real directories. Each directory below root is reported
+// once, before the first key under it; a placeholder key such as "a/b/"
+// yields "a/b" itself.
+type impliedDirs struct {
+	root string
+	seen map[string]bool
+}
+
+func newImpliedDirs(root string) *impliedDirs {
+	return &impliedDirs{root: strings.Trim(root, "/"), seen: make(map[string]bool)}
+}
+
+// add calls fn with the directory entries key implies that were not
+// reported yet, outermost first.
+func (d *impliedDirs) add(key string, fn func(fi FileInfo) error) error {
+	rel, ok := relKey(d.root, key)
+	if !ok {
+		return nil
+	}
+	dir := d.root
+	for {
+		seg, rest, found := strings.Cut(rel, "/")
+		if !found {
+			return nil
+		}
+		dir = path.Join(dir, seg)
+		if !d.seen[dir] {
+			d.seen[dir] = true
+			if err := fn(FileInfo{Path: dir, IsDir: true}); err != nil {
+				return err
+			}
+		}
+		rel = rest
+	}
+}
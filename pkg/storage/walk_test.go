@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listOnly hides the Walker implementation of its backend.
+type listOnly struct{ Storage }
+
+func putWAL(t *testing.T, st Storage, n int) []string {
+	t.Helper()
+	var paths []string
+	for i := 0; i < n; i++ {
+		p := fmt.Sprintf("wal/%04d", i)
+		require.NoError(t, st.Put(context.Background(), p, strings.NewReader(p)))
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+func TestWalkInfo(t *testing.T) {
+	local, err := NewLocal(&LocalStorageOpts{BaseDir: t.TempDir()})
+	require.NoError(t, err)
+	backends := map[string]Storage{
+		"local":    local,
+		"fallback": listOnly{NewInMemoryStorage()},
+	}
+	for name, st := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			paths := putWAL(t, st, 10)
+
+			var seen []string
+			require.NoError(t, WalkInfo(ctx, st, "wal", func(fi FileInfo) error {
+				assert.Equal(t, int64(len(fi.Path)), fi.Size)
+				seen = append(seen, fi.Path)
+				return nil
+			}))
+			assert.ElementsMatch(t, paths, seen)
+
+			calls := 0
+			require.NoError(t, WalkInfo(ctx, st, "wal", func(FileInfo) error {
+				calls++
+				if calls == 3 {
+					return fs.SkipAll
+				}
+				return nil
+			}))
+			assert.Equal(t, 3, calls)
+
+			boom := errors.New("boom")
+			err := WalkInfo(ctx, st, "wal", func(FileInfo) error { return boom })
+			assert.ErrorIs(t, err, boom)
+
+			ctx, cancel := context.WithCancel(ctx)
+			calls = 0
+			err = WalkInfo(ctx, st, "wal", func(FileInfo) error {
+				calls++
+				cancel()
+				return nil
+			})
+			assert.ErrorIs(t, err, context.Canceled)
+			assert.Equal(t, 1, calls)
+		})
+	}
+}
+
+func TestWalkInfo_Transforming(t *testing.T) {
+	ctx := context.Background()
+	local, err := NewLocal(&LocalStorageOpts{BaseDir: t.TempDir()})
+	require.NoError(t, err)
+	vs, err := NewVariadicStorage(local, Algorithms{}, "")
+	require.NoError(t, err)
+	paths := putWAL(t, vs, 3)
+
+	var seen []FileInfo
+	require.NoError(t, WalkInfo(ctx, vs, "wal", func(fi FileInfo) error {
+		seen = append(seen, fi)
+		return nil
+	}))
+	infos, err := vs.ListInfo(ctx, "wal")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, infos, seen)
+	assert.Len(t, seen, len(paths))
+}
+
+func TestListInfoWithOptions_Filters(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	mem := NewInMemoryStorage()
+	for i, p := range []string{"wal/0001", "wal/0002.partial", "wal/0003", "wal/0004"} {
+		require.NoError(t, mem.Put(ctx, p, strings.NewReader(strings.Repeat("x", i+1))))
+		require.NoError(t, mem.SetModTime(p, base.Add(time.Duration(i)*time.Hour)))
+	}
+	paths := func(infos []FileInfo) []string {
+		var names []string
+		for _, fi := range infos {
+			names = append(names, fi.Path)
+		}
+		return names
+	}
+
+	for name, st := range map[string]Storage{"mem": mem, "fallback": listOnly{mem}} {
+		t.Run(name, func(t *testing.T) {
+			infos, err := ListInfoWithOptions(ctx, st, "wal", WithModifiedBefore(base.Add(2*time.Hour)))
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{"wal/0001", "wal/0002.partial"}, paths(infos))
+
+			infos, err = ListInfoWithOptions(ctx, st, "wal", WithModifiedAfter(base), WithSizeBetween(2, 3))
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{"wal/0002.partial", "wal/0003"}, paths(infos))
+
+			infos, err = ListInfoWithOptions(ctx, st, "wal", WithSuffix(".partial"), WithDirs())
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{"wal/0002.partial"}, paths(infos))
+
+			var seen []string
+			require.NoError(t, WalkInfo(ContextWithListOptions(ctx, WithSizeBetween(4, 0)), st, "wal", func(fi FileInfo) error {
+				seen = append(seen, fi.Path)
+				return nil
+			}))
+			assert.Equal(t, []string{"wal/0004"}, seen)
+		})
+	}
+}
+
+func TestListInfoWithOptions_LogicalNames(t *testing.T) {
+	ctx := context.Background()
+	pair := &CodecPair{Compressor: codec.GzipCompressor{}, Decompressor: codec.GzipDecompressor{}}
+	vs, err := NewVariadicStorage(NewInMemoryStorage(), Algorithms{Gzip: pair}, ".gz")
+	require.NoError(t, err)
+	require.NoError(t, vs.Put(ctx, "wal/0001.partial", strings.NewReader("a")))
+	require.NoError(t, vs.Put(ctx, "wal/0002", strings.NewReader("b")))
+
+	// The suffix applies to the logical name, not to "wal/0001.partial.gz".
+	infos, err := ListInfoWithOptions(ctx, vs, "wal", WithSuffix(".partial"))
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "wal/0001.partial", infos[0].Path)
+
+	infos, err = ListInfoWithOptions(ctx, vs, "wal", WithSuffix(".gz"))
+	require.NoError(t, err)
+	assert.Empty(t, infos)
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// WriteRule selects the extension used for new writes to paths matching
+// Pattern. Patterns are slash-separated; each segment follows path.Match
+// syntax, and a "**" segment matches any number of segments, e.g.
+// "wal/**", "basebackups/**/*.tar" or "manifests/*".
+type WriteRule struct {
+	Pattern string
+	Ext     string
+}
+
+// SetWriteRules configures per-path write extensions. The first matching
+// rule wins; paths matching no rule use the writeExt given to
+// NewVariadicStorage. This lets one VariadicStorage write e.g. WAL as
+// ".lz4.aes", base backups as ".zst.aes" and manifests as plain files.
+func (vs *VariadicStorage) SetWriteRules(rules []WriteRule) error {
+	for _, r := range rules {
+		if !vs.isSupportedWriteExt(r.Ext) {
+			return fmt.Errorf("write rule %q: ext %q not supported by provided algorithms", r.Pattern, r.Ext)
+		}
+		for _, seg := range strings.Split(r.Pattern, "/") {
+			if _, err := path.Match(seg, ""); err != nil {
+				return fmt.Errorf("write rule %q: %w", r.Pattern, err)
+			}
+		}
+	}
+	vs.rules = append([]WriteRule(nil), rules...)
+	return nil
+}
+
+// writeExtFor returns the extension new writes of base should use.
+func (vs *VariadicStorage) writeExtFor(base string) string {
+	for _, r := range vs.rules {
+		if matchPathPattern(r.Pattern, base) {
+			return r.Ext
+		}
+	}
+	return vs.writeExt
+}
+
+// matchPathPattern reports whether name matches pattern (see WriteRule).
+func matchPathPattern(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(strings.Trim(name, "/"), "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
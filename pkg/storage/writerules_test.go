@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/hashmap-kz/streamcrypt/pkg/crypt/aesgcm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchPathPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"wal/**", "wal/0001", true},
+		{"wal/**", "wal/2024/01/0001", true},
+		{"wal/**", "wal", true},
+		{"wal/**", "walx/0001", false},
+		{"manifests/*", "manifests/a.json", true},
+		{"manifests/*", "manifests/sub/a.json", false},
+		{"**/*.json", "a/b/c.json", true},
+		{"**/*.json", "c.json", true},
+		{"**/*.json", "a/b/c.txt", false},
+		{"basebackups/**/base.tar", "basebackups/2024/x/base.tar", true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, matchPathPattern(tt.pattern, tt.name), "%s ~ %s", tt.pattern, tt.name)
+	}
+}
+
+func TestVariadicStorage_WriteRules(t *testing.T) {
+	ctx := context.Background()
+
+	gzipPair := &CodecPair{
+		Compressor:   codec.GzipCompressor{},
+		Decompressor: codec.GzipDecompressor{},
+	}
+	zstdPair := &CodecPair{
+		Compressor:   codec.ZstdCompressor{},
+		Decompressor: codec.ZstdDecompressor{},
+	}
+	alg := Algorithms{Gzip: gzipPair, Zstd: zstdPair, AES: aesgcm.NewChunkedGCMCrypter("password")}
+
+	mem := NewInMemoryStorage()
+	vs, err := NewVariadicStorage(mem, alg, ".gz")
+	require.NoError(t, err)
+	require.NoError(t, vs.SetWriteRules([]WriteRule{
+		{Pattern: "wal/**", Ext: ".zst.aes"},
+		{Pattern: "manifests/**", Ext: ""},
+	}))
+
+	for _, p := range []string{"wal/0001", "manifests/m.json", "other/x"} {
+		require.NoError(t, vs.Put(ctx, p, bytes.NewReader([]byte(p))))
+	}
+	assert.Contains(t, mem.Files, "wal/0001.zst.aes")
+	assert.Contains(t, mem.Files, "manifests/m.json")
+	assert.Contains(t, mem.Files, "other/x.gz")
+
+	// Migrate honours the rules too.
+	mem.Files["wal/0002.gz"] = mem.Files["other/x.gz"]
+	n, err := vs.Migrate(ctx, "wal")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Contains(t, mem.Files, "wal/0002.zst.aes")
+
+	assert.Error(t, vs.SetWriteRules([]WriteRule{{Pattern: "x/**", Ext: ".xz"}}))
+	assert.Error(t, vs.SetWriteRules([]WriteRule{{Pattern: "x/[", Ext: ".gz"}}))
+}
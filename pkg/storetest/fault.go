@@ -0,0 +1,317 @@
+package storetest
+
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+)
+
+// Op names a storage operation a Fault applies to.
+type Op string
+
+const (
+	OpPut              Op = "Put"
+	OpGet              Op = "Get"
+	OpGetRange         Op = "GetRange"
+	OpList             Op = "List"
+	OpListInfo         Op = "ListInfo"
+	OpDelete           Op = "Delete"
+	OpDeleteAll        Op = "DeleteAll"
+	OpDeleteDir        Op = "DeleteDir"
+	OpDeleteAllBulk    Op = "DeleteAllBulk"
+	OpExists           Op = "Exists"
+	OpStat             Op = "Stat"
+	OpListTopLevelDirs Op = "ListTopLevelDirs"
+	OpRename           Op = "Rename"
+	OpPing             Op = "Ping"
+)
+
+// Fault describes a failure injected into the calls matching Op and Path.
+type Fault struct {
+	// Op is the operation affected; empty matches all.
+	Op Op
+
+	// Path is matched against the path of the call (any of them for
+	// DeleteAllBulk, either one for Rename): a path.Match pattern, or a
+	// prefix if it ends with "/". Empty matches all.
+	Path string
+
+	// Err is returned instead of calling the backend. With ShortRead it
+	// is instead returned by the reader once the data runs out.
+	Err error
+
+	// Delay is waited for before the call, or until the context is done.
+	Delay time.Duration
+
+	// ShortRead makes Get and GetRange return a reader that stops after
+	// ReadLimit bytes with Err, or io.ErrUnexpectedEOF if Err is nil.
+	ShortRead bool
+	ReadLimit int64
+
+	// Times limits the fault to the first Times matching calls; zero
+	// means all of them.
+	Times int
+}
+
+func (f *Fault) matches(op Op, paths []string) bool {
+	if f.Op != "" && f.Op != op {
+		return false
+	}
+	if f.Path == "" {
+		return true
+	}
+	for _, p := range paths {
+		if strings.HasSuffix(f.Path, "/") {
+			if strings.HasPrefix(p, f.Path) {
+				return true
+			}
+		} else if ok, _ := path.Match(f.Path, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// FaultStorage is a storage for tests of failure handling: it passes calls
+// to Backend unless a programmed Fault matches them.
+type FaultStorage struct {
+	Backend storage.Storage
+
+	mu     sync.Mutex
+	faults []*faultState
+	calls  map[Op]int
+}
+
+type faultState struct {
+	Fault
+	hits int
+}
+
+var (
+	_ storage.Storage     = &FaultStorage{}
+	_ storage.Stater      = &FaultStorage{}
+	_ storage.RangeReader = &FaultStorage{}
+	_ storage.Pinger      = &FaultStorage{}
+)
+
+// NewFaultStorage wraps backend, or a new InMemoryStorage if it is nil.
+func NewFaultStorage(backend storage.Storage) *FaultStorage {
+	if backend == nil {
+		backend = storage.NewInMemoryStorage()
+	}
+	return &FaultStorage{Backend: backend, calls: make(map[Op]int)}
+}
+
+// Inject adds a fault. Faults are tried in the order they were added and
+// the first match applies.
+func (s *FaultStorage) Inject(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &faultState{Fault: f})
+}
+
+// FailOn is shorthand for injecting Err on every call of op on paths
+// matching pattern.
+func (s *FaultStorage) FailOn(op Op, pattern string, err error) {
+	s.Inject(Fault{Op: op, Path: pattern, Err: err})
+}
+
+// Reset removes all faults and clears the call counts.
+func (s *FaultStorage) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+	s.calls = make(map[Op]int)
+}
+
+// Calls returns how many times op was called, failed calls included.
+func (s *FaultStorage) Calls(op Op) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[op]
+}
+
+// fault counts the call and returns the fault that applies to it, if any,
+// after waiting for its delay.
+func (s *FaultStorage) fault(ctx context.Context, op Op, paths ...string) (*Fault, error) {
+	s.mu.Lock()
+	s.calls[op]++
+	var hit *Fault
+	for _, f := range s.faults {
+		if (f.Times == 0 || f.hits < f.Times) && f.matches(op, paths) {
+			f.hits++
+			c := f.Fault
+			hit = &c
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if hit == nil {
+		return nil, nil
+	}
+	if hit.Delay > 0 {
+		timer := time.NewTimer(hit.Delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if hit.Err != nil && !hit.ShortRead {
+		return nil, hit.Err
+	}
+	return hit, nil
+}
+
+// shortReader returns err after limit bytes of r.
+type shortReader struct {
+	r     io.Reader
+	limit int64
+	err   error
+}
+
+func (r *shortReader) Read(p []byte) (int, error) {
+	if r.limit <= 0 {
+		return 0, r.err
+	}
+	if int64(len(p)) > r.limit {
+		p = p[:r.limit]
+	}
+	n, err := r.r.Read(p)
+	r.limit -= int64(n)
+	if err == io.EOF {
+		// The object was shorter than the limit; still fail, as a
+		// dropped connection would.
+		err = r.err
+	}
+	return n, err
+}
+
+func shortRead(f *Fault, rc io.ReadCloser) io.ReadCloser {
+	if f == nil || !f.ShortRead {
+		return rc
+	}
+	err := f.Err
+	if err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{&shortReader{r: rc, limit: f.ReadLimit, err: err}, rc}
+}
+
+func (s *FaultStorage) Put(ctx context.Context, remotePath string, r io.Reader) error {
+	if _, err := s.fault(ctx, OpPut, remotePath); err != nil {
+		return err
+	}
+	return s.Backend.Put(ctx, remotePath, r)
+}
+
+func (s *FaultStorage) Get(ctx context.Context, remotePath string) (io.ReadCloser, error) {
+	f, err := s.fault(ctx, OpGet, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := s.Backend.Get(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	return shortRead(f, rc), nil
+}
+
+func (s *FaultStorage) GetRange(ctx context.Context, remotePath string, offset, length int64) (io.ReadCloser, error) {
+	f, err := s.fault(ctx, OpGetRange, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := storage.GetRange(ctx, s.Backend, remotePath, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return shortRead(f, rc), nil
+}
+
+func (s *FaultStorage) List(ctx context.Context, remotePath string) ([]string, error) {
+	if _, err := s.fault(ctx, OpList, remotePath); err != nil {
+		return nil, err
+	}
+	return s.Backend.List(ctx, remotePath)
+}
+
+func (s *FaultStorage) ListInfo(ctx context.Context, remotePath string) ([]storage.FileInfo, error) {
+	if _, err := s.fault(ctx, OpListInfo, remotePath); err != nil {
+		return nil, err
+	}
+	return s.Backend.ListInfo(ctx, remotePath)
+}
+
+func (s *FaultStorage) Delete(ctx context.Context, remotePath string) error {
+	if _, err := s.fault(ctx, OpDelete, remotePath); err != nil {
+		return err
+	}
+	return s.Backend.Delete(ctx, remotePath)
+}
+
+func (s *FaultStorage) DeleteAll(ctx context.Context, remotePath string) error {
+	if _, err := s.fault(ctx, OpDeleteAll, remotePath); err != nil {
+		return err
+	}
+	return s.Backend.DeleteAll(ctx, remotePath)
+}
+
+func (s *FaultStorage) DeleteDir(ctx context.Context, remotePath string) error {
+	if _, err := s.fault(ctx, OpDeleteDir, remotePath); err != nil {
+		return err
+	}
+	return s.Backend.DeleteDir(ctx, remotePath)
+}
+
+func (s *FaultStorage) DeleteAllBulk(ctx context.Context, paths []string) error {
+	if _, err := s.fault(ctx, OpDeleteAllBulk, paths...); err != nil {
+		return err
+	}
+	return s.Backend.DeleteAllBulk(ctx, paths)
+}
+
+func (s *FaultStorage) Exists(ctx context.Context, remotePath string) (bool, error) {
+	if _, err := s.fault(ctx, OpExists, remotePath); err != nil {
+		return false, err
+	}
+	return s.Backend.Exists(ctx, remotePath)
+}
+
+func (s *FaultStorage) Stat(ctx context.Context, remotePath string) (storage.FileInfo, error) {
+	if _, err := s.fault(ctx, OpStat, remotePath); err != nil {
+		return storage.FileInfo{}, err
+	}
+	return storage.StatObject(ctx, s.Backend, remotePath)
+}
+
+func (s *FaultStorage) ListTopLevelDirs(ctx context.Context, prefix string) (map[string]bool, error) {
+	if _, err := s.fault(ctx, OpListTopLevelDirs, prefix); err != nil {
+		return nil, err
+	}
+	return s.Backend.ListTopLevelDirs(ctx, prefix)
+}
+
+func (s *FaultStorage) Rename(ctx context.Context, oldRemotePath, newRemotePath string) error {
+	if _, err := s.fault(ctx, OpRename, oldRemotePath, newRemotePath); err != nil {
+		return err
+	}
+	return s.Backend.Rename(ctx, oldRemotePath, newRemotePath)
+}
+
+func (s *FaultStorage) Ping(ctx context.Context) error {
+	if _, err := s.fault(ctx, OpPing); err != nil {
+		return err
+	}
+	return storage.Ping(ctx, s.Backend)
+}
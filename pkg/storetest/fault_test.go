@@ -0,0 +1,100 @@
+package storetest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultStorage_Conformance(t *testing.T) {
+	TestStorage(t, func() storage.Storage {
+		return NewFaultStorage(nil)
+	})
+}
+
+func TestFaultStorage_PerPathErrors(t *testing.T) {
+	ctx := context.Background()
+	errBoom := errors.New("boom")
+	st := NewFaultStorage(nil)
+	st.FailOn(OpPut, "wal/*.partial", errBoom)
+	st.FailOn("", "broken/", errBoom)
+
+	assert.ErrorIs(t, st.Put(ctx, "wal/0001.partial", strings.NewReader("x")), errBoom)
+	require.NoError(t, st.Put(ctx, "wal/0001", strings.NewReader("x")))
+
+	_, err := st.Get(ctx, "broken/a")
+	assert.ErrorIs(t, err, errBoom)
+	assert.ErrorIs(t, st.Rename(ctx, "wal/0001", "broken/b"), errBoom)
+	assert.ErrorIs(t, st.DeleteAllBulk(ctx, []string{"wal/0001", "broken/c"}), errBoom)
+
+	ok, err := st.Exists(ctx, "wal/0001")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 2, st.Calls(OpPut))
+
+	st.Reset()
+	require.NoError(t, st.Put(ctx, "wal/0001.partial", strings.NewReader("x")))
+	assert.Equal(t, 1, st.Calls(OpPut))
+}
+
+func TestFaultStorage_Times(t *testing.T) {
+	ctx := context.Background()
+	st := NewFaultStorage(nil)
+	require.NoError(t, st.Put(ctx, "a", strings.NewReader("x")))
+	st.Inject(Fault{Op: OpGet, Err: io.ErrClosedPipe, Times: 2})
+
+	for i := 0; i < 2; i++ {
+		_, err := st.Get(ctx, "a")
+		assert.ErrorIs(t, err, io.ErrClosedPipe)
+	}
+	rc, err := st.Get(ctx, "a")
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+}
+
+func TestFaultStorage_Delay(t *testing.T) {
+	st := NewFaultStorage(nil)
+	st.Inject(Fault{Op: OpExists, Delay: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := st.Exists(ctx, "a")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	st.Reset()
+	st.Inject(Fault{Op: OpExists, Delay: 10 * time.Millisecond})
+	start := time.Now()
+	_, err = st.Exists(context.Background(), "a")
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+}
+
+func TestFaultStorage_ShortRead(t *testing.T) {
+	ctx := context.Background()
+	st := NewFaultStorage(nil)
+	require.NoError(t, st.Put(ctx, "a", strings.NewReader("0123456789")))
+	st.Inject(Fault{Op: OpGet, ShortRead: true, ReadLimit: 4})
+
+	rc, err := st.Get(ctx, "a")
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, "0123", string(data))
+	require.NoError(t, rc.Close())
+
+	errReset := errors.New("connection reset")
+	st.Inject(Fault{Op: OpGetRange, ShortRead: true, ReadLimit: 100, Err: errReset})
+	rc, err = storage.GetRange(ctx, st, "a", 5, -1)
+	require.NoError(t, err)
+	data, err = io.ReadAll(rc)
+	assert.ErrorIs(t, err, errReset)
+	assert.Equal(t, "56789", string(data))
+	require.NoError(t, rc.Close())
+}
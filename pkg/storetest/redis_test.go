@@ -0,0 +1,357 @@
+package storetest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashmap-kz/storecrypt/pkg/clients"
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis is an in-memory server speaking RESP2, implementing the
+// commands of the Redis storage with their Redis semantics, expiry
+// included. Keys expire by the now clock.
+type fakeRedis struct {
+	ln net.Listener
+
+	mu      sync.Mutex
+	now     time.Time
+	hashes  map[string]map[string][]byte
+	expires map[string]time.Time
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	f := &fakeRedis{
+		ln:      ln,
+		now:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		hashes:  make(map[string]map[string][]byte),
+		expires: make(map[string]time.Time),
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+// advance moves the clock keys expire by.
+func (f *fakeRedis) advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+func (f *fakeRedis) client(t *testing.T) *clients.RedisClient {
+	t.Helper()
+	c, err := clients.NewRedisClient(&clients.RedisConfig{Addr: f.ln.Addr().String()})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	var queued [][][]byte // commands of an open MULTI
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		switch cmd := strings.ToUpper(string(args[0])); {
+		case cmd == "MULTI":
+			queued = [][][]byte{}
+			writeReply(w, "OK")
+		case cmd == "EXEC" && queued != nil:
+			f.mu.Lock()
+			replies := make([]any, len(queued))
+			for i, q := range queued {
+				replies[i] = f.exec(q)
+			}
+			f.mu.Unlock()
+			queued = nil
+			writeReply(w, replies)
+		case queued != nil:
+			queued = append(queued, args)
+			writeReply(w, "QUEUED")
+		default:
+			f.mu.Lock()
+			reply := f.exec(args)
+			f.mu.Unlock()
+			writeReply(w, reply)
+		}
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// exec runs one command with f.mu held.
+func (f *fakeRedis) exec(args [][]byte) any {
+	for key, at := range f.expires {
+		if !f.now.Before(at) {
+			delete(f.hashes, key)
+			delete(f.expires, key)
+		}
+	}
+	cmd, args := strings.ToUpper(string(args[0])), args[1:]
+	switch cmd {
+	case "PING", "AUTH", "SELECT":
+		return "OK"
+	case "EXISTS":
+		if _, ok := f.hashes[string(args[0])]; ok {
+			return int64(1)
+		}
+		return int64(0)
+	case "DEL":
+		var n int64
+		for _, key := range args {
+			if _, ok := f.hashes[string(key)]; ok {
+				delete(f.hashes, string(key))
+				delete(f.expires, string(key))
+				n++
+			}
+		}
+		return n
+	case "HSET":
+		key := string(args[0])
+		if f.hashes[key] == nil {
+			f.hashes[key] = make(map[string][]byte)
+		}
+		for i := 1; i+1 < len(args); i += 2 {
+			f.hashes[key][string(args[i])] = args[i+1]
+		}
+		return int64(len(args) / 2)
+	case "HGET":
+		return f.hashes[string(args[0])][string(args[1])]
+	case "HSTRLEN":
+		return int64(len(f.hashes[string(args[0])][string(args[1])]))
+	case "PEXPIRE":
+		key := string(args[0])
+		ms, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			return clients.RedisError("ERR value is not an integer or out of range")
+		}
+		if _, ok := f.hashes[key]; !ok {
+			return int64(0)
+		}
+		f.expires[key] = f.now.Add(time.Duration(ms) * time.Millisecond)
+		return int64(1)
+	case "PTTL":
+		key := string(args[0])
+		if _, ok := f.hashes[key]; !ok {
+			return int64(-2)
+		}
+		at, ok := f.expires[key]
+		if !ok {
+			return int64(-1)
+		}
+		return at.Sub(f.now).Milliseconds()
+	case "RENAME":
+		from, to := string(args[0]), string(args[1])
+		h, ok := f.hashes[from]
+		if !ok {
+			return clients.RedisError("ERR no such key")
+		}
+		at, hasExpiry := f.expires[from]
+		delete(f.hashes, from)
+		delete(f.expires, from)
+		delete(f.expires, to)
+		f.hashes[to] = h
+		if hasExpiry {
+			f.expires[to] = at
+		}
+		return "OK"
+	case "SCAN":
+		return f.scan(args)
+	}
+	return clients.RedisError("ERR unknown command '" + cmd + "'")
+}
+
+// scan pages through the sorted keys, COUNT at a time. Only patterns of
+// the form "<escaped literal>*", as sent by the storage, are supported.
+func (f *fakeRedis) scan(args [][]byte) any {
+	cursor, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return clients.RedisError("ERR invalid cursor")
+	}
+	prefix, count := "", 10
+	for i := 1; i+1 < len(args); i += 2 {
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern, ok := strings.CutSuffix(string(args[i+1]), "*")
+			if !ok {
+				return clients.RedisError("ERR unsupported pattern")
+			}
+			var b strings.Builder
+			for j := 0; j < len(pattern); j++ {
+				if pattern[j] == '\\' {
+					j++
+				}
+				b.WriteByte(pattern[j])
+			}
+			prefix = b.String()
+		case "COUNT":
+			count, _ = strconv.Atoi(string(args[i+1]))
+		}
+	}
+
+	var keys []string
+	for key := range f.hashes {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	end := min(cursor+count, len(keys))
+	page := make([]any, 0, end-cursor)
+	for _, key := range keys[min(cursor, end):end] {
+		page = append(page, []byte(key))
+	}
+	next := end
+	if next >= len(keys) {
+		next = 0
+	}
+	return []any{[]byte(strconv.Itoa(next)), page}
+}
+
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("not a command: %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("bad command length: %q", line)
+	}
+	args := make([][]byte, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = buf[:size]
+	}
+	return args, nil
+}
+
+func writeReply(w *bufio.Writer, reply any) {
+	switch v := reply.(type) {
+	case string:
+		w.WriteString("+" + v + "\r\n")
+	case clients.RedisError:
+		w.WriteString("-" + string(v) + "\r\n")
+	case int64:
+		w.WriteString(":" + strconv.FormatInt(v, 10) + "\r\n")
+	case []byte:
+		if v == nil {
+			w.WriteString("$-1\r\n")
+			return
+		}
+		w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n")
+		w.Write(v)
+		w.WriteString("\r\n")
+	case []any:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	}
+}
+
+func TestRedis(t *testing.T) {
+	TestStorage(t, func() storage.Storage {
+		// A small SCAN page makes listings follow the cursor.
+		return storage.NewRedisStorage(newFakeRedis(t).client(t), storage.RedisOptions{Prefix: "sc:", ScanCount: 2})
+	})
+}
+
+func TestRedis_TTL(t *testing.T) {
+	ctx := context.Background()
+	srv := newFakeRedis(t)
+	c := srv.client(t)
+	st := storage.NewRedisStorage(c, storage.RedisOptions{Prefix: "sc:", TTL: time.Hour})
+
+	pttl := func(key string) int64 {
+		t.Helper()
+		reply, err := c.Do(ctx, "PTTL", key)
+		require.NoError(t, err)
+		return reply.(int64)
+	}
+
+	require.NoError(t, st.Put(ctx, "wal/0001", strings.NewReader("one")))
+	assert.Equal(t, time.Hour.Milliseconds(), pttl("sc:wal/0001"))
+
+	// A rename keeps the time left.
+	srv.advance(20 * time.Minute)
+	require.NoError(t, st.Rename(ctx, "wal/0001", "wal/0002"))
+	assert.Equal(t, (40 * time.Minute).Milliseconds(), pttl("sc:wal/0002"))
+
+	// Putting again starts over.
+	require.NoError(t, st.Put(ctx, "wal/0002", strings.NewReader("two")))
+	assert.Equal(t, time.Hour.Milliseconds(), pttl("sc:wal/0002"))
+
+	srv.advance(time.Hour)
+	ok, err := st.Exists(ctx, "wal/0002")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	_, err = st.Get(ctx, "wal/0002")
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+	files, err := st.List(ctx, "wal")
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	// Without a TTL objects are kept.
+	keep := storage.NewRedisStorage(c, storage.RedisOptions{Prefix: "sc:"})
+	require.NoError(t, keep.Put(ctx, "base/0001", strings.NewReader("base")))
+	assert.Equal(t, int64(-1), pttl("sc:base/0001"))
+}
+
+func TestRedis_MaxSize(t *testing.T) {
+	ctx := context.Background()
+	st := storage.NewRedisStorage(newFakeRedis(t).client(t), storage.RedisOptions{MaxSize: 8})
+
+	require.NoError(t, st.Put(ctx, "exact", bytes.NewReader(make([]byte, 8))))
+	err := st.Put(ctx, "over", bytes.NewReader(make([]byte, 9)))
+	var tooLarge *storage.ObjectTooLargeError
+	require.ErrorAs(t, err, &tooLarge)
+	assert.Equal(t, int64(8), tooLarge.Limit)
+
+	ok, err := st.Exists(ctx, "over")
+	require.NoError(t, err)
+	assert.False(t, ok, "nothing is sent for an object over the limit")
+}
@@ -0,0 +1,24 @@
+//go:build cgo
+
+package storetest
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+)
+
+func TestSQLite(t *testing.T) {
+	TestStorage(t, func() storage.Storage {
+		st, closeDB, err := storage.OpenSQLite(context.Background(), "sqlite3",
+			filepath.Join(t.TempDir(), "objects.db"), storage.SQLiteOptions{})
+		require.NoError(t, err)
+		t.Cleanup(func() { require.NoError(t, closeDB()) })
+		return st
+	})
+}
@@ -0,0 +1,422 @@
+// Package storetest provides a conformance suite for storage.Storage
+// implementations, and FaultStorage, a storage that fails on demand for
+// tests of error handling.
+//
+// A backend proves compliance with a test such as:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.TestStorage(t, func() storage.Storage {
+//			return newMyStorage(t)
+//		})
+//	}
+//
+// The suite checks the behavior the in-tree backends agree on. Where they
+// legitimately differ (e.g. whether deleting a missing object is an error)
+// it does not constrain the implementation.
+package storetest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// root is the directory the objects of the suite are written under.
+const root = "storetest"
+
+// TestStorage runs the conformance suite. newStorage is called once per
+// subtest and must return an empty storage.
+func TestStorage(t *testing.T, newStorage func() storage.Storage) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, st storage.Storage)
+	}{
+		{"PutGet", testPutGet},
+		{"Overwrite", testOverwrite},
+		{"EmptyObject", testEmptyObject},
+		{"GetMissing", testGetMissing},
+		{"Exists", testExists},
+		{"List", testList},
+		{"ListInfo", testListInfo},
+		{"ListTopLevelDirs", testListTopLevelDirs},
+		{"ListInfoDirs", testListInfoDirs},
+		{"ListRoot", testListRoot},
+		{"ListDirSemantics", testListDirSemantics},
+		{"Delete", testDelete},
+		{"DeleteAll", testDeleteAll},
+		{"DeleteAllReport", testDeleteAllReport},
+		{"DeleteDir", testDeleteDir},
+		{"DeleteAllBulk", testDeleteAllBulk},
+		{"Rename", testRename},
+		{"RenameOverwrite", testRenameOverwrite},
+		{"RenameMissing", testRenameMissing},
+		{"RenameSamePath", testRenameSamePath},
+		{"PathEdgeCases", testPathEdgeCases},
+		{"InvalidPaths", testInvalidPaths},
+		{"Stat", testStat},
+		{"GetRange", testGetRange},
+		{"Multipart", testMultipart},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStorage())
+		})
+	}
+}
+
+func p(elem ...string) string {
+	return path.Join(append([]string{root}, elem...)...)
+}
+
+func put(t *testing.T, st storage.Storage, name, data string) {
+	t.Helper()
+	require.NoError(t, st.Put(context.Background(), name, strings.NewReader(data)), "put %s", name)
+}
+
+func get(t *testing.T, st storage.Storage, name string) string {
+	t.Helper()
+	rc, err := st.Get(context.Background(), name)
+	require.NoError(t, err, "get %s", name)
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err, "read %s", name)
+	require.NoError(t, rc.Close(), "close %s", name)
+	return string(data)
+}
+
+func exists(t *testing.T, st storage.Storage, name string) bool {
+	t.Helper()
+	ok, err := st.Exists(context.Background(), name)
+	require.NoError(t, err, "exists %s", name)
+	return ok
+}
+
+func list(t *testing.T, st storage.Storage, prefix string) []string {
+	t.Helper()
+	names, err := st.List(context.Background(), prefix)
+	require.NoError(t, err, "list %s", prefix)
+	return names
+}
+
+func testPutGet(t *testing.T, st storage.Storage) {
+	put(t, st, p("a.txt"), "hello")
+	assert.Equal(t, "hello", get(t, st, p("a.txt")))
+
+	// Larger than common copy buffers.
+	big := bytes.Repeat([]byte("0123456789abcdef"), 64<<10)
+	require.NoError(t, st.Put(context.Background(), p("big.bin"), bytes.NewReader(big)))
+	assert.Equal(t, string(big), get(t, st, p("big.bin")))
+}
+
+func testOverwrite(t *testing.T, st storage.Storage) {
+	put(t, st, p("a.txt"), "first version, longer")
+	put(t, st, p("a.txt"), "second")
+	assert.Equal(t, "second", get(t, st, p("a.txt")))
+	assert.Equal(t, []string{p("a.txt")}, list(t, st, root))
+}
+
+func testEmptyObject(t *testing.T, st storage.Storage) {
+	put(t, st, p("empty"), "")
+	assert.True(t, exists(t, st, p("empty")))
+	assert.Equal(t, "", get(t, st, p("empty")))
+}
+
+func testGetMissing(t *testing.T, st storage.Storage) {
+	put(t, st, p("a.txt"), "x")
+	_, err := st.Get(context.Background(), p("missing.txt"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func testExists(t *testing.T, st storage.Storage) {
+	assert.False(t, exists(t, st, p("a.txt")))
+	put(t, st, p("dir", "a.txt"), "x")
+	assert.True(t, exists(t, st, p("dir", "a.txt")))
+	assert.False(t, exists(t, st, p("dir", "b.txt")))
+	// A directory (or common prefix) is not an object.
+	assert.False(t, exists(t, st, p("dir")))
+}
+
+func testList(t *testing.T, st storage.Storage) {
+	put(t, st, p("a.txt"), "a")
+	put(t, st, p("dir", "b.txt"), "b")
+	put(t, st, p("dir", "sub", "c.txt"), "c")
+	put(t, st, "other/d.txt", "d")
+
+	assert.ElementsMatch(t, []string{p("a.txt"), p("dir", "b.txt"), p("dir", "sub", "c.txt")}, list(t, st, root))
+	assert.ElementsMatch(t, []string{p("dir", "b.txt"), p("dir", "sub", "c.txt")}, list(t, st, p("dir")))
+	assert.ElementsMatch(t, []string{p("dir", "b.txt"), p("dir", "sub", "c.txt")}, list(t, st, p("dir")+"/"),
+		"trailing slash")
+}
+
+func testListInfo(t *testing.T, st storage.Storage) {
+	put(t, st, p("a.txt"), "a")
+	put(t, st, p("dir", "b.txt"), "bbb")
+
+	infos, err := st.ListInfo(context.Background(), root)
+	require.NoError(t, err)
+	sizes := make(map[string]int64, len(infos))
+	for _, fi := range infos {
+		sizes[fi.Path] = fi.Size
+		assert.False(t, fi.ModTime.IsZero(), "mod time of %s", fi.Path)
+	}
+	assert.Equal(t, map[string]int64{p("a.txt"): 1, p("dir", "b.txt"): 3}, sizes)
+}
+
+func testListTopLevelDirs(t *testing.T, st storage.Storage) {
+	put(t, st, p("a.txt"), "a")
+	put(t, st, p("x", "b.txt"), "b")
+	put(t, st, p("y", "z", "c.txt"), "c")
+
+	dirs, err := st.ListTopLevelDirs(context.Background(), root)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{p("x"): true, p("y"): true}, dirs)
+
+	dirs, err = st.ListTopLevelDirs(context.Background(), root+"/")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{p("x"): true, p("y"): true}, dirs, "trailing slash")
+}
+
+func testListInfoDirs(t *testing.T, st storage.Storage) {
+	ctx := context.Background()
+	put(t, st, p("a.txt"), "a")
+	put(t, st, p("x", "b.txt"), "b")
+	put(t, st, p("y", "z", "c.txt"), "c")
+
+	infos, err := storage.ListInfoWithOptions(ctx, st, root, storage.WithDirs())
+	require.NoError(t, err)
+	entries := make(map[string]bool, len(infos))
+	for _, fi := range infos {
+		entries[fi.Path] = fi.IsDir
+		if fi.IsDir {
+			assert.Zero(t, fi.Size, fi.Path)
+		}
+	}
+	assert.Equal(t, map[string]bool{
+		p("a.txt"):           false,
+		p("x"):               true,
+		p("x", "b.txt"):      false,
+		p("y"):               true,
+		p("y", "z"):          true,
+		p("y", "z", "c.txt"): false,
+	}, entries)
+
+	infos, err = st.ListInfo(ctx, root)
+	require.NoError(t, err)
+	for _, fi := range infos {
+		assert.False(t, fi.IsDir, "%s listed without WithDirs", fi.Path)
+	}
+	assert.Len(t, infos, 3)
+}
+
+func testListRoot(t *testing.T, st storage.Storage) {
+	ctx := context.Background()
+	put(t, st, "top.txt", "t")
+	put(t, st, p("x", "a.txt"), "a")
+	want := []string{"top.txt", p("x", "a.txt")}
+
+	for _, prefix := range []string{"", "/"} {
+		assert.ElementsMatch(t, want, list(t, st, prefix), "List(%q)", prefix)
+
+		infos, err := st.ListInfo(ctx, prefix)
+		require.NoError(t, err)
+		names := make([]string, 0, len(infos))
+		for _, fi := range infos {
+			names = append(names, fi.Path)
+		}
+		assert.ElementsMatch(t, want, names, "ListInfo(%q)", prefix)
+
+		dirs, err := st.ListTopLevelDirs(ctx, prefix)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]bool{root: true}, dirs, "ListTopLevelDirs(%q)", prefix)
+	}
+}
+
+func testListDirSemantics(t *testing.T, st storage.Storage) {
+	ctx := context.Background()
+	put(t, st, p("wal", "0001"), "1")
+	put(t, st, p("wal2", "0001"), "2")
+
+	assert.Equal(t, []string{p("wal", "0001")}, list(t, st, p("wal")), "sibling with a common prefix")
+	assert.Empty(t, list(t, st, p("missing")), "missing directory")
+	assert.Empty(t, list(t, st, p("wal", "0001")), "a file is not a directory")
+
+	dirs, err := st.ListTopLevelDirs(ctx, p("missing"))
+	require.NoError(t, err)
+	assert.Empty(t, dirs)
+}
+
+func testDelete(t *testing.T, st storage.Storage) {
+	put(t, st, p("a.txt"), "a")
+	put(t, st, p("b.txt"), "b")
+	require.NoError(t, st.Delete(context.Background(), p("a.txt")))
+	assert.False(t, exists(t, st, p("a.txt")))
+	assert.True(t, exists(t, st, p("b.txt")))
+	_, err := st.Get(context.Background(), p("a.txt"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func seedTree(t *testing.T, st storage.Storage) {
+	t.Helper()
+	put(t, st, p("keep.txt"), "k")
+	put(t, st, p("dir", "a.txt"), "a")
+	put(t, st, p("dir", "sub", "b.txt"), "b")
+	put(t, st, p("dirx", "c.txt"), "c")
+}
+
+func testDeleteAll(t *testing.T, st storage.Storage) {
+	seedTree(t, st)
+	require.NoError(t, st.DeleteAll(context.Background(), p("dir")))
+	assert.ElementsMatch(t, []string{p("keep.txt"), p("dirx", "c.txt")}, list(t, st, root),
+		"DeleteAll must not remove siblings sharing the name as a prefix")
+}
+
+func testDeleteAllReport(t *testing.T, st storage.Storage) {
+	seedTree(t, st)
+	report, err := storage.DeleteAllReport(context.Background(), st, p("dir"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Deleted)
+	assert.Empty(t, report.Failed)
+	assert.ElementsMatch(t, []string{p("keep.txt"), p("dirx", "c.txt")}, list(t, st, root))
+}
+
+func testDeleteDir(t *testing.T, st storage.Storage) {
+	seedTree(t, st)
+	require.NoError(t, st.DeleteDir(context.Background(), p("dir")))
+	assert.ElementsMatch(t, []string{p("keep.txt"), p("dirx", "c.txt")}, list(t, st, root))
+}
+
+func testDeleteAllBulk(t *testing.T, st storage.Storage) {
+	seedTree(t, st)
+	require.NoError(t, st.DeleteAllBulk(context.Background(), []string{p("keep.txt"), p("dir", "sub")}))
+	assert.ElementsMatch(t, []string{p("dir", "a.txt"), p("dirx", "c.txt")}, list(t, st, root))
+}
+
+func testRename(t *testing.T, st storage.Storage) {
+	put(t, st, p("a.txt"), "data")
+	require.NoError(t, st.Rename(context.Background(), p("a.txt"), p("new", "dir", "b.txt")))
+	assert.False(t, exists(t, st, p("a.txt")))
+	assert.Equal(t, "data", get(t, st, p("new", "dir", "b.txt")))
+	assert.Equal(t, []string{p("new", "dir", "b.txt")}, list(t, st, root))
+}
+
+func testRenameOverwrite(t *testing.T, st storage.Storage) {
+	put(t, st, p("a.txt"), "new")
+	put(t, st, p("b.txt"), "old, replaced")
+	require.NoError(t, st.Rename(context.Background(), p("a.txt"), p("b.txt")))
+	assert.Equal(t, "new", get(t, st, p("b.txt")))
+	assert.Equal(t, []string{p("b.txt")}, list(t, st, root))
+}
+
+func testRenameMissing(t *testing.T, st storage.Storage) {
+	put(t, st, p("a.txt"), "a")
+	err := st.Rename(context.Background(), p("missing.txt"), p("b.txt"))
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+	assert.False(t, exists(t, st, p("b.txt")))
+}
+
+func testRenameSamePath(t *testing.T, st storage.Storage) {
+	put(t, st, p("a.txt"), "a")
+	require.NoError(t, st.Rename(context.Background(), p("a.txt"), p("a.txt")))
+	assert.Equal(t, "a", get(t, st, p("a.txt")))
+}
+
+func testPathEdgeCases(t *testing.T, st storage.Storage) {
+	names := []string{
+		p("with space.txt"),
+		p(".hidden"),
+		p("many.dots.tar.gz"),
+		p("unicode-ñ-日本.txt"),
+		p("a", "b", "c", "d", "e", "deep.txt"),
+		p("dash-and_underscore", "0001"),
+	}
+	for i, name := range names {
+		put(t, st, name, name)
+		assert.Equal(t, name, get(t, st, name), "content of %q", name)
+		assert.True(t, exists(t, st, name), "exists %q", name)
+		if i == 0 {
+			assert.False(t, exists(t, st, p("with")), "partial name")
+		}
+	}
+	assert.ElementsMatch(t, names, list(t, st, root))
+}
+
+func testInvalidPaths(t *testing.T, st storage.Storage) {
+	ctx := context.Background()
+	put(t, st, p("a.txt"), "a")
+	for _, name := range []string{
+		p("..", "..", "escape.txt"),
+		p("dir") + "/../a.txt",
+		p("dir") + "//a.txt",
+		p("dir") + `\a.txt`,
+	} {
+		err := st.Put(ctx, name, strings.NewReader("x"))
+		assert.ErrorIs(t, err, storage.ErrInvalidPath, "put %q", name)
+		_, err = st.Get(ctx, name)
+		assert.ErrorIs(t, err, storage.ErrInvalidPath, "get %q", name)
+		assert.ErrorIs(t, st.Rename(ctx, p("a.txt"), name), storage.ErrInvalidPath, "rename to %q", name)
+	}
+	assert.Equal(t, []string{p("a.txt")}, list(t, st, root))
+}
+
+func testStat(t *testing.T, st storage.Storage) {
+	ctx := context.Background()
+	put(t, st, p("dir", "a.txt"), "hello")
+
+	fi, err := storage.StatObject(ctx, st, p("dir", "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, p("dir", "a.txt"), fi.Path)
+	assert.Equal(t, int64(5), fi.Size)
+
+	_, err = storage.StatObject(ctx, st, p("dir", "missing.txt"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func testGetRange(t *testing.T, st storage.Storage) {
+	ctx := context.Background()
+	put(t, st, p("a.txt"), "0123456789")
+
+	read := func(offset, length int64) string {
+		t.Helper()
+		rc, err := storage.GetRange(ctx, st, p("a.txt"), offset, length)
+		require.NoError(t, err, "range %d+%d", offset, length)
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		return string(data)
+	}
+	assert.Equal(t, "234", read(2, 3))
+	assert.Equal(t, "789", read(7, -1), "to the end")
+	assert.Equal(t, "89", read(8, 100), "past the end")
+
+	_, err := storage.GetRange(ctx, st, p("missing.txt"), 0, 1)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+// testMultipart uses a single part: S3 requires every part but the last to
+// be at least 5 MiB.
+func testMultipart(t *testing.T, st storage.Storage) {
+	ctx := context.Background()
+	mp := storage.Multipart(st)
+
+	id, err := mp.InitUpload(ctx, p("mp.bin"))
+	require.NoError(t, err)
+	part, err := mp.UploadPart(ctx, p("mp.bin"), id, 1, strings.NewReader("multipart"))
+	require.NoError(t, err)
+	assert.False(t, exists(t, st, p("mp.bin")), "the object appears on completion")
+	require.NoError(t, mp.CompleteUpload(ctx, p("mp.bin"), id, []storage.Part{part}))
+	assert.Equal(t, "multipart", get(t, st, p("mp.bin")))
+
+	id, err = mp.InitUpload(ctx, p("aborted.bin"))
+	require.NoError(t, err)
+	_, err = mp.UploadPart(ctx, p("aborted.bin"), id, 1, strings.NewReader("x"))
+	require.NoError(t, err)
+	require.NoError(t, mp.AbortUpload(ctx, p("aborted.bin"), id))
+	assert.False(t, exists(t, st, p("aborted.bin")))
+}
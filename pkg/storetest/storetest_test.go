@@ -0,0 +1,22 @@
+package storetest
+
+import (
+	"testing"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemory(t *testing.T) {
+	TestStorage(t, func() storage.Storage {
+		return storage.NewInMemoryStorage()
+	})
+}
+
+func TestLocal(t *testing.T) {
+	TestStorage(t, func() storage.Storage {
+		st, err := storage.NewLocal(&storage.LocalStorageOpts{BaseDir: t.TempDir()})
+		require.NoError(t, err)
+		return st
+	})
+}
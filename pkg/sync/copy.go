@@ -0,0 +1,199 @@
+package sync
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	stdsync "sync"
+	"time"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+)
+
+// DefaultRetryDelay is the wait before the first retry of an object when
+// CopyOptions.RetryDelay is zero. It doubles with every attempt.
+const DefaultRetryDelay = time.Second
+
+// CopyOptions tune CopyPrefix.
+type CopyOptions struct {
+	// Concurrency is the number of objects copied at once.
+	Concurrency int
+
+	// Retries is the number of extra attempts for an object whose copy
+	// failed. Objects that disappeared from the source are not retried.
+	Retries int
+
+	// RetryDelay is the wait before the first retry; see
+	// DefaultRetryDelay.
+	RetryDelay time.Duration
+
+	// Checkpoint, if set, is a local file listing the paths already
+	// copied, one per line. A run resumed with the same checkpoint skips
+	// them. Paths are appended as objects complete, so it stays cheap for
+	// millions of objects, and the file is removed once a run completes
+	// without errors.
+	Checkpoint string
+
+	// Progress, if set, is called after every object with the running
+	// totals. Calls are serialised but may come from any worker.
+	Progress func(CopyReport)
+}
+
+// CopyReport summarises a CopyPrefix run.
+type CopyReport struct {
+	Total   int   // objects found under the prefix
+	Copied  int   // objects copied by this run
+	Skipped int   // objects already copied according to the checkpoint
+	Failed  int   // objects that could not be copied
+	Retries int   // extra attempts made
+	Bytes   int64 // bytes copied, as read from the source
+}
+
+// CopyPrefix copies every object under prefix from src to dst,
+// unconditionally, with a bounded worker pool. Unlike Sync it neither
+// lists nor compares the destination, which suits one-off migrations of
+// large archives; use Checkpoint to make them resumable. Failures are
+// collected and reported together; the returned report is always set.
+func CopyPrefix(ctx context.Context, src, dst storage.Storage, prefix string, opts CopyOptions) (*CopyReport, error) {
+	report := &CopyReport{}
+	files, err := src.List(ctx, prefix)
+	if err != nil {
+		return report, fmt.Errorf("list source: %w", err)
+	}
+	sort.Strings(files)
+
+	var (
+		done = make(map[string]bool)
+		ckpt *os.File
+	)
+	if opts.Checkpoint != "" {
+		if done, err = loadCopyCheckpoint(opts.Checkpoint); err != nil {
+			return report, fmt.Errorf("load checkpoint: %w", err)
+		}
+		if ckpt, err = os.OpenFile(opts.Checkpoint, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600); err != nil {
+			return report, fmt.Errorf("open checkpoint: %w", err)
+		}
+	}
+	var todo []string
+	for i, f := range files {
+		if i > 0 && f == files[i-1] {
+			continue // several stored variants of one logical path
+		}
+		report.Total++
+		if done[f] {
+			report.Skipped++
+			continue
+		}
+		todo = append(todo, f)
+	}
+
+	var (
+		mu   stdsync.Mutex
+		errs []error
+		wg   stdsync.WaitGroup
+	)
+	jobs := make(chan string)
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range jobs {
+				n, retries, err := copyWithRetries(ctx, src, dst, p, opts)
+				mu.Lock()
+				report.Retries += retries
+				if err != nil {
+					report.Failed++
+					errs = append(errs, fmt.Errorf("copy %q: %w", p, err))
+				} else {
+					report.Copied++
+					report.Bytes += n
+					if ckpt != nil {
+						if _, err := fmt.Fprintln(ckpt, p); err != nil {
+							errs = append(errs, fmt.Errorf("write checkpoint: %w", err))
+						}
+					}
+				}
+				if opts.Progress != nil {
+					opts.Progress(*report)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, p := range todo {
+		select {
+		case jobs <- p:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	if ckpt != nil {
+		if err := ckpt.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("write checkpoint: %w", err))
+		}
+		if len(errs) == 0 {
+			if err := os.Remove(opts.Checkpoint); err != nil {
+				errs = append(errs, fmt.Errorf("remove checkpoint: %w", err))
+			}
+		}
+	}
+	return report, errors.Join(errs...)
+}
+
+// copyWithRetries copies one object, retrying with exponential backoff.
+// It returns the bytes copied and the number of retries made.
+func copyWithRetries(ctx context.Context, src, dst storage.Storage, p string, opts CopyOptions) (int64, int, error) {
+	delay := opts.RetryDelay
+	if delay <= 0 {
+		delay = DefaultRetryDelay
+	}
+	for attempt := 0; ; attempt++ {
+		n, _, err := copyObject(ctx, src, dst, p, nil)
+		if err == nil || attempt >= opts.Retries || errors.Is(err, fs.ErrNotExist) || ctx.Err() != nil {
+			return n, attempt, err
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return 0, attempt, err
+		}
+		delay *= 2
+	}
+}
+
+func loadCopyCheckpoint(p string) (map[string]bool, error) {
+	done := make(map[string]bool)
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// A line cut short by a crash names no object and is harmless.
+		if line := sc.Text(); line != "" {
+			done[line] = true
+		}
+	}
+	return done, sc.Err()
+}
@@ -0,0 +1,88 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	stdsync "sync"
+	"testing"
+	"time"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyGet fails the first fails Gets of every path.
+type flakyGet struct {
+	*storage.InMemoryStorage
+	fails int
+
+	mu    stdsync.Mutex
+	calls map[string]int
+}
+
+func (f *flakyGet) Get(ctx context.Context, p string) (io.ReadCloser, error) {
+	f.mu.Lock()
+	f.calls[p]++
+	n := f.calls[p]
+	f.mu.Unlock()
+	if n <= f.fails {
+		return nil, errors.New("connection reset")
+	}
+	return f.InMemoryStorage.Get(ctx, p)
+}
+
+func TestCopyPrefix_Retries(t *testing.T) {
+	mem := storage.NewInMemoryStorage()
+	put(t, mem, "a/1", "one")
+	put(t, mem, "a/2", "two")
+	src := &flakyGet{InMemoryStorage: mem, fails: 2, calls: make(map[string]int)}
+	dst := storage.NewInMemoryStorage()
+
+	report, err := CopyPrefix(context.Background(), src, dst, "a", CopyOptions{Retries: 2, RetryDelay: time.Millisecond})
+	require.NoError(t, err)
+	assert.Equal(t, &CopyReport{Total: 2, Copied: 2, Retries: 4, Bytes: 6}, report)
+	assert.Equal(t, "two", get(t, dst, "a/2"))
+
+	src.calls = make(map[string]int)
+	report, err = CopyPrefix(context.Background(), src, storage.NewInMemoryStorage(), "a", CopyOptions{Retries: 1, RetryDelay: time.Millisecond})
+	assert.ErrorContains(t, err, "connection reset")
+	assert.Equal(t, 2, report.Failed)
+}
+
+func TestCopyPrefix_Checkpoint(t *testing.T) {
+	mem := storage.NewInMemoryStorage()
+	for _, p := range []string{"a/1", "a/2", "a/3", "a/4"} {
+		put(t, mem, p, p)
+	}
+	dst := storage.NewInMemoryStorage()
+	ckpt := filepath.Join(t.TempDir(), "copy.ckpt")
+
+	// An interrupted run copied a/1 and a/2, the last line was cut short.
+	require.NoError(t, os.WriteFile(ckpt, []byte("a/1\na/2\na/"), 0o600))
+	report, err := CopyPrefix(context.Background(), mem, dst, "a", CopyOptions{Checkpoint: ckpt, Concurrency: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 4, report.Total)
+	assert.Equal(t, 2, report.Skipped)
+	assert.Equal(t, 2, report.Copied)
+	assert.NotContains(t, dst.Files, "a/1")
+	assert.Equal(t, "a/4", get(t, dst, "a/4"))
+	assert.NoFileExists(t, ckpt, "checkpoint is removed after a clean run")
+
+	// A failed run keeps what it achieved.
+	src := failingGet{mem, "a/3"}
+	_, err = CopyPrefix(context.Background(), src, dst, "a", CopyOptions{Checkpoint: ckpt})
+	require.Error(t, err)
+	data, err := os.ReadFile(ckpt)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "a/4\n")
+	assert.NotContains(t, string(data), "a/3")
+
+	report, err = CopyPrefix(context.Background(), mem, dst, "a", CopyOptions{Checkpoint: ckpt})
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Copied)
+	assert.Equal(t, 3, report.Skipped)
+}
@@ -0,0 +1,83 @@
+package sync
+
+import (
+	"context"
+	"io"
+	stdsync "sync"
+	"time"
+)
+
+// limiter is a token bucket shared by the transfers of a run. Readers take
+// the bytes they read and sleep off any debt, so the aggregate rate stays
+// under the limit whatever the concurrency.
+type limiter struct {
+	rate  float64 // bytes per second
+	burst int
+
+	mu     stdsync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newLimiter returns a limiter of bytesPerSec, or nil (no limit) if it is
+// not positive.
+func newLimiter(bytesPerSec int64) *limiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	// Reads are cut to a tenth of a second worth of data, so that the
+	// transfers interleave smoothly.
+	burst := max(int(bytesPerSec/10), 1)
+	return &limiter{rate: float64(bytesPerSec), burst: burst, last: time.Now()}
+}
+
+func (l *limiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(float64(l.burst), l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if delay == 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reader limits r, or returns it as is without a limit.
+func (l *limiter) reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{ctx: ctx, r: r, l: l}
+}
+
+type limitedReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *limiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > lr.l.burst {
+		p = p[:lr.l.burst]
+	}
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		if werr := lr.l.wait(lr.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,78 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+)
+
+// stateVersion is the current version of the state manifest format.
+const stateVersion = 1
+
+// stateSaveEvery is how many completed objects Sync accumulates before
+// rewriting the state manifest, so that an interrupted run of a large
+// mirror does not start over.
+const stateSaveEvery = 1000
+
+// syncState is the state manifest: what the source looked like when each
+// object was last synced.
+type syncState struct {
+	Version int                   `json:"version"`
+	Objects map[string]stateEntry `json:"objects"`
+}
+
+type stateEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	SHA256  string    `json:"sha256,omitempty"`
+}
+
+func newState() *syncState {
+	return &syncState{Version: stateVersion, Objects: make(map[string]stateEntry)}
+}
+
+// record notes a synced source object. An empty digest keeps the one
+// already known for an unchanged object.
+func (s *syncState) record(fi storage.FileInfo, digest string) {
+	e := stateEntry{Size: fi.Size, ModTime: fi.ModTime, SHA256: digest}
+	if old, ok := s.Objects[fi.Path]; ok && digest == "" && old.Size == e.Size && old.ModTime.Equal(e.ModTime) {
+		e.SHA256 = old.SHA256
+	}
+	s.Objects[fi.Path] = e
+}
+
+// loadState reads the manifest at p, returning nil if there is none.
+func loadState(ctx context.Context, st storage.Storage, p string) (*syncState, error) {
+	rc, err := st.Get(ctx, p)
+	if errors.Is(err, storage.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	s := newState()
+	if err := json.NewDecoder(rc).Decode(s); err != nil {
+		return nil, err
+	}
+	if s.Version != stateVersion {
+		return nil, fmt.Errorf("unsupported sync state version %d", s.Version)
+	}
+	if s.Objects == nil {
+		s.Objects = make(map[string]stateEntry)
+	}
+	return s, nil
+}
+
+func (s *syncState) save(ctx context.Context, st storage.Storage, p string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return storage.PutWithOptions(ctx, st, p, bytes.NewReader(data), storage.WithSizeHint(int64(len(data))))
+}
@@ -0,0 +1,149 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingDst counts destination listings and reads.
+type countingDst struct {
+	*storage.InMemoryStorage
+	lists, gets int
+}
+
+func (c *countingDst) ListInfo(ctx context.Context, p string) ([]storage.FileInfo, error) {
+	c.lists++
+	return c.InMemoryStorage.ListInfo(ctx, p)
+}
+
+func (c *countingDst) Get(ctx context.Context, p string) (io.ReadCloser, error) {
+	c.gets++
+	return c.InMemoryStorage.Get(ctx, p)
+}
+
+func TestSync_State(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	src, err := storage.NewLocal(&storage.LocalStorageOpts{BaseDir: dir})
+	require.NoError(t, err)
+	put(t, src, "arch/1", "one")
+	put(t, src, "arch/2", "two")
+	put(t, src, "arch/3", "three")
+	dst := &countingDst{InMemoryStorage: storage.NewInMemoryStorage()}
+
+	opts := Options{Prefix: "arch", StatePath: "arch/.sync-state", Delete: true, Compare: CompareChecksum}
+	report, err := Sync(ctx, src, dst, opts)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Copied)
+	assert.Equal(t, 1, dst.lists)
+	require.Contains(t, dst.Files, "arch/.sync-state")
+
+	// Nothing changed: the destination is neither listed nor read.
+	dst.lists, dst.gets = 0, 0
+	report, err = Sync(ctx, src, dst, opts)
+	require.NoError(t, err)
+	assert.Equal(t, Report{Skipped: 3}, *report)
+	assert.Zero(t, dst.lists)
+	assert.Equal(t, 1, dst.gets) // the manifest
+
+	// Touched but identical content is verified against the recorded
+	// digest; a real change is copied; a removal is propagated.
+	future := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "arch/1"), future, future))
+	put(t, src, "arch/2", "TWO")
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "arch/2"), future, future))
+	require.NoError(t, src.Delete(ctx, "arch/3"))
+
+	dst.gets = 0
+	report, err = Sync(ctx, src, dst, opts)
+	require.NoError(t, err)
+	assert.Equal(t, Report{Copied: 1, Skipped: 1, Deleted: 1, Bytes: 3}, *report)
+	assert.Equal(t, 1, dst.gets)
+	assert.Equal(t, "TWO", get(t, dst, "arch/2"))
+	assert.NotContains(t, dst.Files, "arch/3")
+
+	// The manifest reflects the last run.
+	state, err := loadState(ctx, dst, opts.StatePath)
+	require.NoError(t, err)
+	assert.Len(t, state.Objects, 2)
+	assert.NotEmpty(t, state.Objects["arch/2"].SHA256)
+}
+
+func TestSync_StateRescan(t *testing.T) {
+	ctx := context.Background()
+	src, err := storage.NewLocal(&storage.LocalStorageOpts{BaseDir: t.TempDir()})
+	require.NoError(t, err)
+	put(t, src, "r/1", "one")
+	dst := storage.NewInMemoryStorage()
+
+	opts := Options{Prefix: "r", StatePath: "state.json"}
+	_, err = Sync(ctx, src, dst, opts)
+	require.NoError(t, err)
+
+	// Lost behind Sync's back: the manifest still says it is there.
+	delete(dst.Files, "r/1")
+	report, err := Sync(ctx, src, dst, opts)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Skipped)
+
+	opts.Rescan = true
+	report, err = Sync(ctx, src, dst, opts)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Copied)
+	assert.Contains(t, dst.Files, "r/1")
+
+	// Dry runs leave the manifest alone.
+	before := string(dst.Files["state.json"])
+	_, err = Sync(ctx, src, dst, Options{Prefix: "r", StatePath: "state.json", Rescan: true, DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, before, string(dst.Files["state.json"]))
+}
+
+func TestSync_StateInterrupted(t *testing.T) {
+	src := storage.NewInMemoryStorage()
+	dst := storage.NewInMemoryStorage()
+	put(t, src, "i/keep", "keep")
+	var gone []string
+	for i := range 10 {
+		p := fmt.Sprintf("i/%02d", i)
+		put(t, src, p, "x")
+		gone = append(gone, p)
+	}
+	opts := Options{Prefix: "i", StatePath: "state.json", Delete: true, Concurrency: 1}
+	_, err := Sync(context.Background(), src, dst, opts)
+	require.NoError(t, err)
+
+	for _, p := range gone {
+		require.NoError(t, src.Delete(context.Background(), p))
+	}
+
+	// Interrupted after the first delete: the rest stays in the manifest.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupted := opts
+	interrupted.Progress = func(Report) { cancel() }
+	report, err := Sync(ctx, src, dst, interrupted)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Less(t, report.Deleted, len(gone))
+
+	report, err = Sync(context.Background(), src, dst, opts)
+	require.NoError(t, err)
+	assert.Positive(t, report.Deleted)
+	files, err := dst.List(context.Background(), "i")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"i/keep"}, files)
+
+	state, err := loadState(context.Background(), dst, opts.StatePath)
+	require.NoError(t, err)
+	assert.Len(t, state.Objects, 1)
+	assert.Contains(t, state.Objects, "i/keep")
+}
@@ -0,0 +1,367 @@
+// Package sync mirrors objects from one Storage to another, e.g. a local
+// archive to S3. Both sides may be wrapped (VariadicStorage,
+// TransformingStorage, ...): objects are compared and copied by logical
+// path, so a plain local archive can be mirrored into an encrypted bucket.
+package sync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	stdsync "sync"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+)
+
+// CompareMode selects how Sync decides that an object changed.
+type CompareMode int
+
+const (
+	// CompareSizeModTime copies objects whose sizes differ or that were
+	// modified on the source after the destination copy was written.
+	CompareSizeModTime CompareMode = iota
+
+	// CompareModTime ignores sizes. Use it when the destination reports
+	// stored (compressed/encrypted) sizes, e.g. a TransformingStorage
+	// without RecordSizes.
+	CompareModTime
+
+	// CompareChecksum reads both sides and compares SHA-256 digests of
+	// the content. It is exact but reads everything.
+	CompareChecksum
+)
+
+// DefaultConcurrency is the number of objects Sync transfers at once when
+// Options.Concurrency is zero.
+const DefaultConcurrency = 4
+
+// Options tune Sync.
+type Options struct {
+	// Prefix limits the sync to objects under it (on both sides).
+	Prefix string
+
+	// Compare selects the change detection; see CompareMode.
+	Compare CompareMode
+
+	// Delete removes destination objects under Prefix that do not exist
+	// on the source.
+	Delete bool
+
+	// Concurrency is the number of objects transferred at once.
+	Concurrency int
+
+	// DryRun reports what would be copied and deleted without doing it.
+	DryRun bool
+
+	// BandwidthLimit, if positive, caps the bytes per second read from
+	// the source for copies, across all concurrent transfers.
+	BandwidthLimit int64
+
+	// StatePath, if set, is the destination path of a state manifest
+	// recording what was synced: source size, modification time and
+	// SHA-256 of every object. Runs that find it compare the source
+	// against it instead of listing (and, with CompareChecksum, reading)
+	// the destination, and keep it up to date. Objects changed on the
+	// destination behind Sync's back are not noticed; see Rescan.
+	StatePath string
+
+	// Rescan ignores the state manifest for this run, comparing against
+	// the destination itself, and rebuilds the manifest.
+	Rescan bool
+
+	// Progress, if set, is called after every object with the running
+	// totals. Calls are serialised but may come from any worker.
+	Progress func(Report)
+}
+
+// Report summarises a Sync run.
+type Report struct {
+	Copied  int   // objects copied (or that would be, with DryRun)
+	Skipped int   // objects unchanged
+	Deleted int   // extraneous destination objects removed
+	Failed  int   // objects that could not be compared, copied or deleted
+	Bytes   int64 // bytes copied, as read from the source
+}
+
+type action int
+
+const (
+	actionCopy    action = iota
+	actionCompare        // hash both sides
+	actionVerify         // hash the source, compare with the state
+	actionDelete
+)
+
+type task struct {
+	path   string
+	action action
+	src    storage.FileInfo // source object, unless deleting
+	digest string           // recorded digest, for actionVerify
+}
+
+// result is the outcome of a successful task.
+type result struct {
+	n       int64  // bytes copied
+	changed bool   // copied, or would be in a dry run
+	digest  string // source digest, if it was read
+}
+
+// Sync copies new and changed objects from src to dst and, with
+// opts.Delete, removes the ones src no longer has. Failures are collected
+// and reported together; the returned report is always set.
+func Sync(ctx context.Context, src, dst storage.Storage, opts Options) (*Report, error) {
+	report := &Report{}
+	srcFiles, err := src.ListInfo(ctx, opts.Prefix)
+	if err != nil {
+		return report, fmt.Errorf("list source: %w", err)
+	}
+
+	var prior *syncState
+	if opts.StatePath != "" && !opts.Rescan {
+		if prior, err = loadState(ctx, dst, opts.StatePath); err != nil {
+			return report, fmt.Errorf("load sync state: %w", err)
+		}
+	}
+	var dstFiles []storage.FileInfo
+	if prior == nil {
+		dstFiles, err = dst.ListInfo(ctx, opts.Prefix)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return report, fmt.Errorf("list destination: %w", err)
+		}
+	}
+	next := newState()
+	tasks := plan(srcFiles, dstFiles, prior, next, opts, report)
+
+	var (
+		mu    stdsync.Mutex
+		errs  []error
+		dirty int
+		wg    stdsync.WaitGroup
+	)
+	saveState := opts.StatePath != "" && !opts.DryRun
+	lim := newLimiter(opts.BandwidthLimit)
+	jobs := make(chan task)
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range jobs {
+				res, err := run(ctx, src, dst, t, opts.DryRun, lim)
+				mu.Lock()
+				switch {
+				case err != nil:
+					report.Failed++
+					errs = append(errs, err)
+				case t.action == actionDelete:
+					report.Deleted++
+					delete(next.Objects, t.path)
+				default:
+					if res.changed {
+						report.Copied++
+						report.Bytes += res.n
+					} else {
+						report.Skipped++
+					}
+					next.record(t.src, res.digest)
+				}
+				if dirty++; saveState && dirty >= stateSaveEvery {
+					if err := next.save(ctx, dst, opts.StatePath); err != nil {
+						errs = append(errs, fmt.Errorf("save sync state: %w", err))
+					}
+					dirty = 0
+				}
+				if opts.Progress != nil {
+					opts.Progress(*report)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, t := range tasks {
+		select {
+		case jobs <- t:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	if saveState {
+		// Keep what was achieved for the next run, even if ctx was
+		// cancelled.
+		if err := next.save(context.WithoutCancel(ctx), dst, opts.StatePath); err != nil {
+			errs = append(errs, fmt.Errorf("save sync state: %w", err))
+		}
+	}
+	return report, errors.Join(errs...)
+}
+
+// plan compares the source listing with the state manifest, or with the
+// destination listing when there is none, and returns the work to do.
+// Objects that are unchanged by metadata alone are counted as skipped and
+// recorded in next right away. Objects with pending work keep their prior
+// entry in next, and destination objects the source lacks are recorded
+// until they are deleted, so that a run that fails or is interrupted
+// leaves a manifest from which the next run picks up the rest.
+func plan(srcFiles, dstFiles []storage.FileInfo, prior, next *syncState, opts Options, report *Report) []task {
+	dstByPath := make(map[string]storage.FileInfo, len(dstFiles))
+	for _, fi := range dstFiles {
+		dstByPath[fi.Path] = fi
+	}
+	srcPaths := make(map[string]bool, len(srcFiles))
+	var tasks []task
+	for _, s := range srcFiles {
+		if srcPaths[s.Path] || s.Path == opts.StatePath {
+			continue // several stored variants of one logical path
+		}
+		srcPaths[s.Path] = true
+		copyTask := task{path: s.Path, action: actionCopy, src: s}
+
+		if prior != nil {
+			e, ok := prior.Objects[s.Path]
+			switch {
+			case !ok:
+				tasks = append(tasks, copyTask)
+			case e.Size == s.Size && e.ModTime.Equal(s.ModTime):
+				report.Skipped++
+				next.Objects[s.Path] = e
+			case opts.Compare == CompareChecksum && e.SHA256 != "":
+				next.Objects[s.Path] = e
+				tasks = append(tasks, task{path: s.Path, action: actionVerify, src: s, digest: e.SHA256})
+			default:
+				next.Objects[s.Path] = e
+				tasks = append(tasks, copyTask)
+			}
+			continue
+		}
+
+		d, ok := dstByPath[s.Path]
+		switch {
+		case !ok:
+			tasks = append(tasks, copyTask)
+		case opts.Compare == CompareChecksum:
+			tasks = append(tasks, task{path: s.Path, action: actionCompare, src: s})
+		case s.ModTime.After(d.ModTime),
+			opts.Compare == CompareSizeModTime && s.Size != d.Size:
+			tasks = append(tasks, copyTask)
+		default:
+			report.Skipped++
+			next.record(s, "")
+		}
+	}
+	extraneous := make(map[string]stateEntry)
+	if prior != nil {
+		for p, e := range prior.Objects {
+			extraneous[p] = e
+		}
+	} else {
+		for _, d := range dstFiles {
+			// Size -1 never matches, should the source get it back.
+			extraneous[d.Path] = stateEntry{Size: -1}
+		}
+	}
+	for p, e := range extraneous {
+		if srcPaths[p] || p == opts.StatePath {
+			continue
+		}
+		next.Objects[p] = e
+		if opts.Delete {
+			tasks = append(tasks, task{path: p, action: actionDelete})
+		}
+	}
+	sort.SliceStable(tasks, func(i, j int) bool { return tasks[i].path < tasks[j].path })
+	return tasks
+}
+
+// run carries out one task.
+func run(ctx context.Context, src, dst storage.Storage, t task, dryRun bool, lim *limiter) (result, error) {
+	switch t.action {
+	case actionDelete:
+		if dryRun {
+			return result{}, nil
+		}
+		if err := dst.Delete(ctx, t.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return result{}, fmt.Errorf("delete %q: %w", t.path, err)
+		}
+		return result{}, nil
+	case actionCompare, actionVerify:
+		digest, err := digestObject(ctx, src, t.path)
+		if err != nil {
+			return result{}, fmt.Errorf("compare %q: %w", t.path, err)
+		}
+		want := t.digest
+		if t.action == actionCompare {
+			if want, err = digestObject(ctx, dst, t.path); err != nil {
+				return result{}, fmt.Errorf("compare %q: %w", t.path, err)
+			}
+		}
+		if digest == want {
+			return result{digest: digest}, nil
+		}
+	}
+	if dryRun {
+		return result{changed: true}, nil
+	}
+	n, digest, err := copyObject(ctx, src, dst, t.path, lim)
+	if err != nil {
+		return result{}, fmt.Errorf("copy %q: %w", t.path, err)
+	}
+	return result{n: n, changed: true, digest: digest}, nil
+}
+
+// copyObject copies one object, at the pace of lim, and returns its size
+// and SHA-256.
+func copyObject(ctx context.Context, src, dst storage.Storage, p string, lim *limiter) (int64, string, error) {
+	rc, err := src.Get(ctx, p)
+	if err != nil {
+		return 0, "", err
+	}
+	h := sha256.New()
+	cr := &countingReader{}
+	err = storage.ConsumeObject(rc, func(r io.Reader) error {
+		cr.r = io.TeeReader(lim.reader(ctx, r), h)
+		return dst.Put(ctx, p, cr)
+	})
+	return cr.n, hex.EncodeToString(h.Sum(nil)), err
+}
+
+func digestObject(ctx context.Context, st storage.Storage, p string) (string, error) {
+	rc, err := st.Get(ctx, p)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, rc)
+	if closeErr := rc.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,156 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+	"github.com/hashmap-kz/streamcrypt/pkg/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func put(t *testing.T, st storage.Storage, p, data string) {
+	t.Helper()
+	require.NoError(t, st.Put(context.Background(), p, bytes.NewReader([]byte(data))))
+}
+
+func get(t *testing.T, st storage.Storage, p string) string {
+	t.Helper()
+	rc, err := st.Get(context.Background(), p)
+	require.NoError(t, err)
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestSync_CopiesAndDeletes(t *testing.T) {
+	ctx := context.Background()
+	src, dst := storage.NewInMemoryStorage(), storage.NewInMemoryStorage()
+	put(t, src, "wal/1", "one")
+	put(t, src, "wal/2", "two")
+	put(t, dst, "wal/stale", "old")
+
+	report, err := Sync(ctx, src, dst, Options{Prefix: "wal"})
+	require.NoError(t, err)
+	assert.Equal(t, Report{Copied: 2, Bytes: 6}, *report)
+	assert.Equal(t, "two", get(t, dst, "wal/2"))
+	assert.Contains(t, dst.Files, "wal/stale")
+
+	// Unchanged objects are skipped; a size change is picked up.
+	put(t, src, "wal/2", "two, amended")
+	var progress []Report
+	report, err = Sync(ctx, src, dst, Options{Prefix: "wal", Delete: true, Progress: func(r Report) {
+		progress = append(progress, r)
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, Report{Copied: 1, Skipped: 1, Deleted: 1, Bytes: 12}, *report)
+	assert.Equal(t, "two, amended", get(t, dst, "wal/2"))
+	assert.NotContains(t, dst.Files, "wal/stale")
+	assert.Len(t, progress, 2)
+}
+
+func TestSync_DryRun(t *testing.T) {
+	src, dst := storage.NewInMemoryStorage(), storage.NewInMemoryStorage()
+	put(t, src, "a/1", "one")
+	put(t, dst, "a/2", "two")
+
+	report, err := Sync(context.Background(), src, dst, Options{Prefix: "a", Delete: true, DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, Report{Copied: 1, Deleted: 1}, *report)
+	assert.Equal(t, map[string][]byte{"a/2": []byte("two")}, dst.Files)
+}
+
+func TestSync_ModTime(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	src, err := storage.NewLocal(&storage.LocalStorageOpts{BaseDir: dir})
+	require.NoError(t, err)
+	put(t, src, "base/1", "aaaa")
+
+	// A compressing destination reports stored sizes.
+	dst, err := storage.NewVariadicStorage(storage.NewInMemoryStorage(), storage.Algorithms{Gzip: &storage.CodecPair{
+		Compressor:   codec.GzipCompressor{},
+		Decompressor: codec.GzipDecompressor{},
+	}}, ".gz")
+	require.NoError(t, err)
+
+	opts := Options{Prefix: "base", Compare: CompareModTime}
+	report, err := Sync(ctx, src, dst, opts)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Copied)
+
+	report, err = Sync(ctx, src, dst, opts)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Skipped)
+
+	// Same size, touched after the copy.
+	put(t, src, "base/1", "bbbb")
+	future := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "base/1"), future, future))
+	report, err = Sync(ctx, src, dst, opts)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Copied)
+	assert.Equal(t, "bbbb", get(t, dst, "base/1"))
+}
+
+func TestSync_Checksum(t *testing.T) {
+	src, dst := storage.NewInMemoryStorage(), storage.NewInMemoryStorage()
+	put(t, src, "c/same", "identical")
+	put(t, dst, "c/same", "identical")
+	put(t, src, "c/diff", "new")
+	put(t, dst, "c/diff", "old")
+
+	report, err := Sync(context.Background(), src, dst, Options{Prefix: "c", Compare: CompareChecksum, Concurrency: 1})
+	require.NoError(t, err)
+	assert.Equal(t, Report{Copied: 1, Skipped: 1, Bytes: 3}, *report)
+	assert.Equal(t, "new", get(t, dst, "c/diff"))
+}
+
+// failingGet fails Get for one path.
+type failingGet struct {
+	*storage.InMemoryStorage
+	path string
+}
+
+func (f failingGet) Get(ctx context.Context, p string) (io.ReadCloser, error) {
+	if p == f.path {
+		return nil, errors.New("boom")
+	}
+	return f.InMemoryStorage.Get(ctx, p)
+}
+
+func TestSync_CollectsErrors(t *testing.T) {
+	mem := storage.NewInMemoryStorage()
+	put(t, mem, "e/1", "one")
+	put(t, mem, "e/2", "two")
+	dst := storage.NewInMemoryStorage()
+
+	report, err := Sync(context.Background(), failingGet{mem, "e/1"}, dst, Options{Prefix: "e"})
+	assert.ErrorContains(t, err, `copy "e/1": boom`)
+	assert.Equal(t, 1, report.Failed)
+	assert.Equal(t, 1, report.Copied)
+	assert.Contains(t, dst.Files, "e/2")
+}
+
+func TestSync_BandwidthLimit(t *testing.T) {
+	src, dst := storage.NewInMemoryStorage(), storage.NewInMemoryStorage()
+	payload := string(bytes.Repeat([]byte("x"), 20<<10))
+	put(t, src, "d/a", payload)
+	put(t, src, "d/b", payload)
+
+	// 40 KiB at 100 KiB/s, shared by both transfers.
+	start := time.Now()
+	report, err := Sync(context.Background(), src, dst, Options{Prefix: "d", Concurrency: 2, BandwidthLimit: 100 << 10})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Copied)
+	assert.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+	assert.Equal(t, payload, get(t, dst, "d/b"))
+}
@@ -0,0 +1,50 @@
+package sync
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashmap-kz/storecrypt/pkg/crypters"
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	local := storage.NewInMemoryStorage()
+	put(t, local, "v/same", "restorable")
+	put(t, local, "v/diff", "local version")
+	put(t, local, "v/local-only", "x")
+	put(t, local, "v/corrupt", "will not decrypt")
+
+	key, err := crypters.NewXChaCha20(make([]byte, 32))
+	require.NoError(t, err)
+	bucket := storage.NewInMemoryStorage()
+	offsite := &storage.TransformingStorage{Backend: bucket, Crypter: key}
+	put(t, offsite, "v/same", "restorable")
+	put(t, offsite, "v/diff", "offsite version")
+	put(t, offsite, "v/remote-only", "y")
+	put(t, offsite, "v/corrupt", "will not decrypt")
+	bucket.Files["v/corrupt.xchacha"][len(bucket.Files["v/corrupt.xchacha"])-1] ^= 1
+
+	report, err := Verify(ctx, local, offsite, "v")
+	require.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, 1, report.Matched)
+	assert.Equal(t, []string{"v/diff"}, report.Mismatched)
+	assert.Equal(t, []string{"v/remote-only"}, report.MissingInA)
+	assert.Equal(t, []string{"v/local-only"}, report.MissingInB)
+	require.Len(t, report.Unreadable, 1)
+	assert.Equal(t, "v/corrupt", report.Unreadable[0].Path)
+	assert.ErrorIs(t, report.Unreadable[0].Err, crypters.ErrAuthentication)
+
+	// After a sync the copy verifies.
+	delete(bucket.Files, "v/corrupt.xchacha")
+	_, err = Sync(ctx, local, offsite, Options{Prefix: "v", Delete: true, Compare: CompareChecksum})
+	require.NoError(t, err)
+	report, err = Verify(ctx, local, offsite, "v")
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%+v", report)
+	assert.Equal(t, 4, report.Matched)
+}
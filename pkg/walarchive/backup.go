@@ -0,0 +1,141 @@
+package walarchive
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultBasePrefix is the directory of base backups in the storage; a
+// backup labelled L is stored under DefaultBasePrefix/L.
+const DefaultBasePrefix = "base"
+
+// backupTimeFormat is the format of times in backup history files.
+const backupTimeFormat = "2006-01-02 15:04:05 MST"
+
+// BaseBackup describes a base backup, as recorded by PostgreSQL in the
+// backup history file it archives at the end of the backup.
+type BaseBackup struct {
+	Label     string
+	Timeline  uint32
+	StartLSN  LSN
+	StopLSN   LSN
+	StartTime time.Time
+	StopTime  time.Time
+
+	// Dir is the storage directory of the backup files and Objects their
+	// storage paths, sorted.
+	Dir     string
+	Objects []string
+}
+
+// ParseBackupHistory parses a backup history file
+// (<segment>.<offset>.backup). Dir and Objects are left empty.
+func ParseBackupHistory(r io.Reader) (*BaseBackup, error) {
+	b := &BaseBackup{}
+	var haveStart, haveStop bool
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		key, value, ok := strings.Cut(sc.Text(), ": ")
+		if !ok {
+			continue
+		}
+		var err error
+		switch key {
+		case "START WAL LOCATION":
+			b.StartLSN, err = ParseLSN(firstField(value))
+			haveStart = true
+		case "STOP WAL LOCATION":
+			b.StopLSN, err = ParseLSN(firstField(value))
+			haveStop = true
+		case "START TIME":
+			b.StartTime, err = time.Parse(backupTimeFormat, value)
+		case "STOP TIME":
+			b.StopTime, err = time.Parse(backupTimeFormat, value)
+		case "LABEL":
+			b.Label = value
+		case "START TIMELINE":
+			var tli uint64
+			tli, err = strconv.ParseUint(value, 10, 32)
+			b.Timeline = uint32(tli)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("walarchive: backup history %s: %w", strings.ToLower(key), err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if !haveStart || !haveStop {
+		return nil, errors.New("walarchive: backup history without start or stop location")
+	}
+	if b.Timeline == 0 {
+		b.Timeline = 1
+	}
+	return b, nil
+}
+
+// firstField returns the LSN of "0/2000028 (file 000000010000000000000002)".
+func firstField(s string) string {
+	lsn, _, _ := strings.Cut(strings.TrimSpace(s), " ")
+	return lsn
+}
+
+// Inventory is what a restore can be planned from: the base backups and
+// the names of the archived WAL files.
+type Inventory struct {
+	Backups []BaseBackup
+	WAL     []string
+}
+
+// Inventory collects the base backups stored under basePrefix
+// (DefaultBasePrefix if empty) that have a backup history file in the
+// archive, and the archived WAL file names. Backups without stored
+// objects are left out.
+func (a *Archive) Inventory(ctx context.Context, basePrefix string) (*Inventory, error) {
+	basePrefix = strings.Trim(basePrefix, "/")
+	if basePrefix == "" {
+		basePrefix = DefaultBasePrefix
+	}
+	names, err := a.Names(ctx)
+	if err != nil {
+		return nil, err
+	}
+	inv := &Inventory{WAL: names}
+	for _, name := range names {
+		if !IsBackupLabelName(name) {
+			continue
+		}
+		var buf strings.Builder
+		if err := a.FetchSegment(ctx, name, &buf); err != nil {
+			return nil, err
+		}
+		b, err := ParseBackupHistory(strings.NewReader(buf.String()))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if b.Label == "" || strings.ContainsAny(b.Label, "/\\") || b.Label == "." || b.Label == ".." {
+			continue
+		}
+		b.Dir = path.Join(basePrefix, b.Label)
+		objects, err := a.Storage.List(ctx, b.Dir)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		if len(objects) == 0 {
+			continue
+		}
+		sort.Strings(objects)
+		b.Objects = objects
+		inv.Backups = append(inv.Backups, *b)
+	}
+	return inv, nil
+}
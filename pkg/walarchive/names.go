@@ -0,0 +1,197 @@
+package walarchive
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// DefaultSegmentSize is the PostgreSQL default WAL segment size (16 MiB).
+const DefaultSegmentSize = 16 << 20
+
+// partialSuffix marks a segment that was archived before it was complete,
+// e.g. by pg_receivewal or at promotion.
+const partialSuffix = ".partial"
+
+// LSN is a PostgreSQL log sequence number: a byte position in the WAL.
+type LSN uint64
+
+// ParseLSN parses the textual form of an LSN, e.g. "16/B374D848".
+func ParseLSN(s string) (LSN, error) {
+	hi, lo, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, fmt.Errorf("walarchive: invalid LSN %q", s)
+	}
+	h, err := strconv.ParseUint(hi, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("walarchive: invalid LSN %q", s)
+	}
+	l, err := strconv.ParseUint(lo, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("walarchive: invalid LSN %q", s)
+	}
+	return LSN(h<<32 | l), nil
+}
+
+func (l LSN) String() string {
+	return fmt.Sprintf("%X/%X", uint64(l)>>32, uint64(l)&0xFFFFFFFF)
+}
+
+// Segment identifies a WAL segment file.
+type Segment struct {
+	Timeline uint32
+	No       uint64 // segment number: LSN / segment size
+}
+
+// segmentsPerXLogID is the number of segments per "log" id, the middle
+// part of a segment name.
+func segmentsPerXLogID(segSize int64) uint64 {
+	return 0x100000000 / uint64(segSize)
+}
+
+// SegmentOf returns the segment of timeline tli holding lsn.
+func SegmentOf(tli uint32, lsn LSN, segSize int64) Segment {
+	return Segment{Timeline: tli, No: uint64(lsn) / uint64(segSize)}
+}
+
+// StartLSN returns the LSN of the first byte of the segment.
+func (s Segment) StartLSN(segSize int64) LSN {
+	return LSN(s.No * uint64(segSize))
+}
+
+// Name returns the file name of the segment, e.g.
+// "000000010000000A0000003F" for 16 MiB segments.
+func (s Segment) Name(segSize int64) string {
+	per := segmentsPerXLogID(segSize)
+	return fmt.Sprintf("%08X%08X%08X", s.Timeline, s.No/per, s.No%per)
+}
+
+// ParseSegmentName parses a segment file name, with or without the
+// .partial suffix.
+func ParseSegmentName(name string, segSize int64) (Segment, error) {
+	name = strings.TrimSuffix(name, partialSuffix)
+	if !IsSegmentName(name) {
+		return Segment{}, fmt.Errorf("walarchive: not a WAL segment name: %q", name)
+	}
+	tli, _ := strconv.ParseUint(name[0:8], 16, 32)
+	log, _ := strconv.ParseUint(name[8:16], 16, 32)
+	seg, _ := strconv.ParseUint(name[16:24], 16, 32)
+	per := segmentsPerXLogID(segSize)
+	if seg >= per {
+		return Segment{}, fmt.Errorf("walarchive: segment %q out of range for %d-byte segments", name, segSize)
+	}
+	return Segment{Timeline: uint32(tli), No: log*per + seg}, nil
+}
+
+// NextSegmentPath returns a function naming the object of the segment that
+// follows the one at p on the same timeline, for use as the Next of a
+// storage.PrefetchStorage. Only complete segments have a successor.
+func NextSegmentPath(segSize int64) func(p string) (string, bool) {
+	return func(p string) (string, bool) {
+		dir, name := path.Split(p)
+		if !IsSegmentName(name) {
+			return "", false
+		}
+		seg, err := ParseSegmentName(name, segSize)
+		if err != nil {
+			return "", false
+		}
+		seg.No++
+		return dir + seg.Name(segSize), true
+	}
+}
+
+// IsSegmentName reports whether name is a complete WAL segment name.
+func IsSegmentName(name string) bool {
+	return len(name) == 24 && isHex(name)
+}
+
+// IsPartialName reports whether name is a partial WAL segment name.
+func IsPartialName(name string) bool {
+	return strings.HasSuffix(name, partialSuffix) && IsSegmentName(strings.TrimSuffix(name, partialSuffix))
+}
+
+// IsHistoryName reports whether name is a timeline history file name,
+// e.g. "00000002.history".
+func IsHistoryName(name string) bool {
+	tli, ok := strings.CutSuffix(name, ".history")
+	return ok && len(tli) == 8 && isHex(tli)
+}
+
+// IsBackupLabelName reports whether name is a backup history file, e.g.
+// "000000010000000000000002.00000028.backup".
+func IsBackupLabelName(name string) bool {
+	rest, ok := strings.CutSuffix(name, ".backup")
+	return ok && len(rest) == 33 && rest[24] == '.' && IsSegmentName(rest[:24]) && isHex(rest[25:])
+}
+
+// HistoryName returns the history file name of timeline tli.
+func HistoryName(tli uint32) string {
+	return fmt.Sprintf("%08X.history", tli)
+}
+
+// historyTimeline returns the timeline of a history file name.
+func historyTimeline(name string) uint32 {
+	tli, _ := strconv.ParseUint(name[:8], 16, 32)
+	return uint32(tli)
+}
+
+// ValidName reports whether name is a file PostgreSQL archives: a segment
+// (possibly partial), a timeline history file or a backup history file.
+func ValidName(name string) bool {
+	return IsSegmentName(name) || IsPartialName(name) || IsHistoryName(name) || IsBackupLabelName(name)
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'A' || c > 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// HistoryEntry is one line of a timeline history file: the switch from
+// Parent to the next timeline at LSN Switch.
+type HistoryEntry struct {
+	Parent uint32
+	Switch LSN
+	Reason string
+}
+
+// ParseHistory parses a timeline history file. Entries are ordered from
+// the oldest timeline.
+func ParseHistory(r io.Reader) ([]HistoryEntry, error) {
+	var entries []HistoryEntry
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) < 2 {
+			fields = strings.Fields(line)
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("walarchive: invalid history line %q", line)
+		}
+		parent, err := strconv.ParseUint(strings.TrimSpace(fields[0]), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("walarchive: invalid history line %q", line)
+		}
+		lsn, err := ParseLSN(strings.TrimSpace(fields[1]))
+		if err != nil {
+			return nil, err
+		}
+		e := HistoryEntry{Parent: uint32(parent), Switch: lsn}
+		if len(fields) > 2 {
+			e.Reason = strings.TrimSpace(strings.Join(fields[2:], " "))
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
@@ -0,0 +1,78 @@
+package walarchive
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLSN(t *testing.T) {
+	lsn, err := ParseLSN("16/B374D848")
+	require.NoError(t, err)
+	assert.Equal(t, LSN(0x16B374D848), lsn)
+	assert.Equal(t, "16/B374D848", lsn.String())
+
+	_, err = ParseLSN("16B374D848")
+	assert.Error(t, err)
+}
+
+func TestSegmentNames(t *testing.T) {
+	lsn, err := ParseLSN("A/3F000028")
+	require.NoError(t, err)
+	seg := SegmentOf(1, lsn, DefaultSegmentSize)
+	assert.Equal(t, "000000010000000A0000003F", seg.Name(DefaultSegmentSize))
+	assert.Equal(t, "A/3F000000", seg.StartLSN(DefaultSegmentSize).String())
+
+	parsed, err := ParseSegmentName("000000010000000A0000003F.partial", DefaultSegmentSize)
+	require.NoError(t, err)
+	assert.Equal(t, seg, parsed)
+
+	// 1 GiB segments: four per log id.
+	big := SegmentOf(2, lsn, 1<<30)
+	assert.Equal(t, "000000020000000A00000000", big.Name(1<<30))
+	_, err = ParseSegmentName("000000020000000A00000004", 1<<30)
+	assert.Error(t, err)
+
+	for name, valid := range map[string]bool{
+		"000000010000000A0000003F":                 true,
+		"000000010000000A0000003F.partial":         true,
+		"00000002.history":                         true,
+		"000000010000000000000002.00000028.backup": true,
+		"000000010000000a0000003F":                 false,
+		"00000002.history.tmp":                     false,
+		"pg_wal":                                   false,
+	} {
+		assert.Equal(t, valid, ValidName(name), name)
+	}
+}
+
+func TestNextSegmentPath(t *testing.T) {
+	next := NextSegmentPath(DefaultSegmentSize)
+
+	p, ok := next("wal/000000010000000A0000003F")
+	require.True(t, ok)
+	assert.Equal(t, "wal/000000010000000A00000040", p)
+
+	p, ok = next("000000010000000A000000FF")
+	require.True(t, ok)
+	assert.Equal(t, "000000010000000B00000000", p)
+
+	_, ok = next("wal/000000010000000A0000003F.partial")
+	assert.False(t, ok)
+	_, ok = next("wal/00000002.history")
+	assert.False(t, ok)
+}
+
+func TestParseHistory(t *testing.T) {
+	entries, err := ParseHistory(strings.NewReader(
+		"1\t0/3000000\tno recovery target specified\n\n2\t0/5000000\tbefore 2024-01-01 00:00:00+00\n"))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, HistoryEntry{Parent: 1, Switch: 0x3000000, Reason: "no recovery target specified"}, entries[0])
+	assert.Equal(t, uint32(2), entries[1].Parent)
+
+	_, err = ParseHistory(strings.NewReader("x\t0/1\n"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,195 @@
+package walarchive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+)
+
+var (
+	// ErrNoBackup is returned when no base backup can reach the target.
+	ErrNoBackup = errors.New("walarchive: no base backup can reach the restore target")
+
+	// ErrMissingWAL is returned when a WAL segment the restore needs is
+	// not archived.
+	ErrMissingWAL = errors.New("walarchive: WAL segment needed for the restore is missing")
+)
+
+// Target is a point-in-time restore target. With neither LSN nor Time
+// set, the restore replays all the WAL available.
+type Target struct {
+	// LSN, if set, is the position to restore to.
+	LSN LSN
+
+	// Time, if set, is the time to restore to. WAL records carry the
+	// times, not the segment names, so the plan includes WAL up to the
+	// start of the first base backup taken after Time, or up to the end
+	// of the archive if there is none.
+	Time time.Time
+
+	// Timeline is the timeline to restore to; zero means the latest one
+	// with a history file in the archive.
+	Timeline uint32
+}
+
+// ItemKind tells what a PlanItem is.
+type ItemKind int
+
+const (
+	ItemBackup  ItemKind = iota // a file of the base backup
+	ItemHistory                 // a timeline history file
+	ItemSegment                 // a WAL segment
+)
+
+// PlanItem is one object of a restore plan.
+type PlanItem struct {
+	Kind ItemKind
+
+	// Name is the WAL file name, or the path of a backup file relative to
+	// the backup directory.
+	Name string
+
+	// Path is the storage path of the object.
+	Path string
+}
+
+// Plan is the minimal set of objects needed to restore to a target, in
+// the order they are needed: the base backup files, the history files of
+// the timelines on the way, then the WAL segments in replay order.
+type Plan struct {
+	Backup   BaseBackup
+	Timeline uint32
+	Items    []PlanItem
+}
+
+// timelineRange is the part of the WAL a timeline of the target's
+// history holds: [begin, end).
+type timelineRange struct {
+	tli        uint32
+	begin, end LSN
+}
+
+// Plan computes the restore plan of target from inv, choosing the most
+// recent base backup that can reach it. segSize is the WAL segment size
+// (DefaultSegmentSize if zero).
+func (a *Archive) Plan(ctx context.Context, inv *Inventory, target Target, segSize int64) (*Plan, error) {
+	if segSize <= 0 {
+		segSize = DefaultSegmentSize
+	}
+	archived := make(map[string]bool, len(inv.WAL))
+	tli := target.Timeline
+	for _, name := range inv.WAL {
+		archived[name] = true
+		if target.Timeline == 0 && IsHistoryName(name) {
+			tli = max(tli, historyTimeline(name))
+		}
+	}
+	tli = max(tli, 1)
+
+	history, err := a.History(ctx, tli)
+	if err != nil {
+		return nil, fmt.Errorf("timeline %d history: %w", tli, err)
+	}
+	var timelines []timelineRange
+	var begin LSN
+	for _, e := range history {
+		timelines = append(timelines, timelineRange{tli: e.Parent, begin: begin, end: e.Switch})
+		begin = e.Switch
+	}
+	timelines = append(timelines, timelineRange{tli: tli, begin: begin, end: math.MaxUint64})
+	onPath := func(tli uint32, lsn LSN) bool {
+		for _, r := range timelines {
+			if r.tli == tli && r.begin <= lsn && lsn < r.end {
+				return true
+			}
+		}
+		return false
+	}
+
+	var backup *BaseBackup
+	for i := range inv.Backups {
+		b := &inv.Backups[i]
+		switch {
+		case !onPath(b.Timeline, b.StartLSN),
+			target.LSN != 0 && b.StopLSN > target.LSN,
+			!target.Time.IsZero() && b.StopTime.After(target.Time):
+			continue
+		}
+		if backup == nil || b.StopLSN > backup.StopLSN {
+			backup = b
+		}
+	}
+	if backup == nil {
+		return nil, ErrNoBackup
+	}
+
+	segOf := func(lsn LSN) uint64 { return uint64(lsn) / uint64(segSize) }
+	first, stop := segOf(backup.StartLSN), segOf(backup.StopLSN)
+	last, bounded := uint64(math.MaxUint64), false
+	switch {
+	case target.LSN != 0:
+		last, bounded = segOf(target.LSN), true
+	case !target.Time.IsZero():
+		for _, b := range inv.Backups {
+			if b.StartTime.After(target.Time) && onPath(b.Timeline, b.StartLSN) && b.StartLSN >= backup.StopLSN {
+				last, bounded = min(last, segOf(b.StartLSN)), true
+			}
+		}
+	}
+	last = max(last, stop)
+
+	p := &Plan{Backup: *backup, Timeline: tli}
+	for _, obj := range backup.Objects {
+		p.Items = append(p.Items, PlanItem{Kind: ItemBackup, Name: strings.TrimPrefix(obj, backup.Dir+"/"), Path: obj})
+	}
+	for _, r := range timelines {
+		if name := HistoryName(r.tli); r.tli > 1 && archived[name] {
+			p.Items = append(p.Items, PlanItem{Kind: ItemHistory, Name: name, Path: a.objectPath(name)})
+		}
+	}
+	for n := first; n <= last; n++ {
+		// Like recovery, read every segment from the newest timeline
+		// that had begun by then.
+		seg := Segment{No: n}
+		for i := len(timelines) - 1; i >= 0; i-- {
+			if segOf(timelines[i].begin) <= n {
+				seg.Timeline = timelines[i].tli
+				break
+			}
+		}
+		name := seg.Name(segSize)
+		if !archived[name] {
+			if bounded || n <= stop {
+				return nil, fmt.Errorf("%w: %s", ErrMissingWAL, name)
+			}
+			break // the end of the archive
+		}
+		p.Items = append(p.Items, PlanItem{Kind: ItemSegment, Name: name, Path: a.objectPath(name)})
+	}
+	return p, nil
+}
+
+// Stream reads the objects of p in order and passes each to fn. The
+// reader is only valid during the call.
+func (a *Archive) Stream(ctx context.Context, p *Plan, fn func(item PlanItem, r io.Reader) error) error {
+	for _, item := range p.Items {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rc, err := a.Storage.Get(ctx, item.Path)
+		if err != nil {
+			return fmt.Errorf("read %q: %w", item.Path, err)
+		}
+		err = storage.ConsumeObject(rc, func(r io.Reader) error { return fn(item, r) })
+		if err != nil {
+			return fmt.Errorf("%s: %w", item.Name, err)
+		}
+	}
+	return nil
+}
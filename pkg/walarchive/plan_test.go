@@ -0,0 +1,133 @@
+package walarchive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func backupHistory(label, start, stop, startTime, stopTime string) string {
+	return fmt.Sprintf("START WAL LOCATION: %s (file whatever)\nSTOP WAL LOCATION: %s (file whatever)\n"+
+		"CHECKPOINT LOCATION: %s\nBACKUP METHOD: streamed\nBACKUP FROM: primary\n"+
+		"START TIME: 2024-01-01 %s UTC\nLABEL: %s\nSTART TIMELINE: 1\nSTOP TIME: 2024-01-01 %s UTC\nSTOP TIMELINE: 1\n",
+		start, stop, start, startTime, label, stopTime)
+}
+
+// newTestArchive builds an archive with two base backups on timeline 1
+// and a switch to timeline 2 at 0/5000000:
+//
+//	tli 1: segments 1..6, base1 in segment 1, base2 in segment 3
+//	tli 2: segments 5..6
+func newTestArchive(t *testing.T) (*Archive, *storage.InMemoryStorage) {
+	t.Helper()
+	ctx := context.Background()
+	mem := storage.NewInMemoryStorage()
+	a := New(mem, "")
+	for n := uint64(1); n <= 6; n++ {
+		name := Segment{Timeline: 1, No: n}.Name(DefaultSegmentSize)
+		require.NoError(t, a.ArchiveSegment(ctx, name, strings.NewReader(name)))
+	}
+	for n := uint64(5); n <= 6; n++ {
+		name := Segment{Timeline: 2, No: n}.Name(DefaultSegmentSize)
+		require.NoError(t, a.ArchiveSegment(ctx, name, strings.NewReader(name)))
+	}
+	require.NoError(t, a.ArchiveSegment(ctx, HistoryName(2), strings.NewReader("1\t0/5000000\tno recovery target specified\n")))
+	require.NoError(t, a.ArchiveSegment(ctx, "000000010000000000000001.00000028.backup",
+		strings.NewReader(backupHistory("base1", "0/1000028", "0/1000100", "10:00:00", "10:01:00"))))
+	require.NoError(t, a.ArchiveSegment(ctx, "000000010000000000000003.00000028.backup",
+		strings.NewReader(backupHistory("base2", "0/3000028", "0/3000100", "12:00:00", "12:01:00"))))
+	for _, p := range []string{"base/base1/base.tar", "base/base2/base.tar", "base/base2/pg_wal.tar"} {
+		require.NoError(t, mem.Put(ctx, p, strings.NewReader(p)))
+	}
+	return a, mem
+}
+
+func itemNames(p *Plan, kind ItemKind) []string {
+	var names []string
+	for _, item := range p.Items {
+		if item.Kind == kind {
+			names = append(names, item.Name)
+		}
+	}
+	return names
+}
+
+func segNames(tli uint32, nos ...uint64) []string {
+	var names []string
+	for _, n := range nos {
+		names = append(names, Segment{Timeline: tli, No: n}.Name(DefaultSegmentSize))
+	}
+	return names
+}
+
+func TestArchive_Inventory(t *testing.T) {
+	a, _ := newTestArchive(t)
+	inv, err := a.Inventory(context.Background(), "")
+	require.NoError(t, err)
+	require.Len(t, inv.Backups, 2)
+	b := inv.Backups[1]
+	assert.Equal(t, "base2", b.Label)
+	assert.Equal(t, uint32(1), b.Timeline)
+	assert.Equal(t, "0/3000028", b.StartLSN.String())
+	assert.Equal(t, 12, b.StartTime.Hour())
+	assert.Equal(t, []string{"base/base2/base.tar", "base/base2/pg_wal.tar"}, b.Objects)
+}
+
+func TestArchive_Plan(t *testing.T) {
+	ctx := context.Background()
+	a, mem := newTestArchive(t)
+	inv, err := a.Inventory(ctx, "")
+	require.NoError(t, err)
+
+	lsn, err := ParseLSN("0/4000010")
+	require.NoError(t, err)
+	p, err := a.Plan(ctx, inv, Target{LSN: lsn, Timeline: 1}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "base2", p.Backup.Label)
+	assert.Equal(t, []string{"base.tar", "pg_wal.tar"}, itemNames(p, ItemBackup))
+	assert.Empty(t, itemNames(p, ItemHistory))
+	assert.Equal(t, segNames(1, 3, 4), itemNames(p, ItemSegment))
+
+	// base2 finished after the target time; WAL is needed up to where
+	// base2 started.
+	p, err = a.Plan(ctx, inv, Target{Time: inv.Backups[0].StopTime.Add(time.Hour), Timeline: 1}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "base1", p.Backup.Label)
+	assert.Equal(t, segNames(1, 1, 2, 3), itemNames(p, ItemSegment))
+
+	// Everything, on the latest timeline.
+	p, err = a.Plan(ctx, inv, Target{}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), p.Timeline)
+	assert.Equal(t, []string{HistoryName(2)}, itemNames(p, ItemHistory))
+	assert.Equal(t, append(segNames(1, 3, 4), segNames(2, 5, 6)...), itemNames(p, ItemSegment))
+
+	var streamed []string
+	require.NoError(t, a.Stream(ctx, p, func(item PlanItem, r io.Reader) error {
+		data, err := io.ReadAll(r)
+		if item.Kind == ItemSegment {
+			assert.Equal(t, item.Name, string(data))
+		}
+		streamed = append(streamed, item.Name)
+		return err
+	}))
+	assert.Len(t, streamed, len(p.Items))
+
+	early, err := ParseLSN("0/1000050")
+	require.NoError(t, err)
+	_, err = a.Plan(ctx, inv, Target{LSN: early}, 0)
+	assert.ErrorIs(t, err, ErrNoBackup)
+
+	delete(mem.Files, "wal/"+segNames(1, 4)[0])
+	inv, err = a.Inventory(ctx, "")
+	require.NoError(t, err)
+	_, err = a.Plan(ctx, inv, Target{LSN: lsn, Timeline: 1}, 0)
+	assert.ErrorIs(t, err, ErrMissingWAL)
+}
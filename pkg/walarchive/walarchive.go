@@ -0,0 +1,249 @@
+// Package walarchive implements PostgreSQL archive_command and
+// restore_command semantics on top of any storage.Storage:
+//
+//   - files are published atomically: uploaded under a temporary name and
+//     renamed into place, so a restore never sees half a segment;
+//   - archiving a file that already exists succeeds if the content is
+//     identical (PostgreSQL retries archive_command after a crash) and
+//     fails with ErrDuplicate otherwise, never overwriting it;
+//   - .partial segments and timeline history files are archived under
+//     their own names, and fetching a segment never falls back to its
+//     partial version.
+package walarchive
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashmap-kz/storecrypt/pkg/fsync"
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+)
+
+// DefaultPrefix is the directory of the archive in the storage.
+const DefaultPrefix = "wal"
+
+// tmpDir is the directory, below the prefix, of files being uploaded.
+const tmpDir = ".tmp"
+
+var (
+	// ErrDuplicate is returned when a file is already archived with
+	// different content.
+	ErrDuplicate = errors.New("walarchive: file already archived with different content")
+
+	// ErrInvalidName is returned for names PostgreSQL does not archive.
+	ErrInvalidName = errors.New("walarchive: not a WAL file name")
+)
+
+// Archive is a WAL archive under a prefix of a storage.
+type Archive struct {
+	Storage storage.Storage
+	prefix  string
+}
+
+// New creates an archive under prefix (DefaultPrefix if empty) of st.
+func New(st storage.Storage, prefix string) *Archive {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	return &Archive{Storage: st, prefix: prefix}
+}
+
+// Prefix returns the directory of the archive in the storage.
+func (a *Archive) Prefix() string {
+	return a.prefix
+}
+
+func (a *Archive) objectPath(name string) string {
+	return path.Join(a.prefix, name)
+}
+
+func checkName(name string) error {
+	if !ValidName(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	return nil
+}
+
+// ArchiveSegment stores the WAL file name (a segment, partial segment,
+// history or backup history file) read from r.
+func (a *Archive) ArchiveSegment(ctx context.Context, name string, r io.Reader) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	target := a.objectPath(name)
+	exists, err := a.Storage.Exists(ctx, target)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return a.compare(ctx, name, r)
+	}
+
+	tmp := path.Join(a.prefix, tmpDir, name+"."+randomSuffix())
+	if err := a.Storage.Put(ctx, tmp, r); err != nil {
+		_ = a.Storage.Delete(ctx, tmp)
+		return fmt.Errorf("archive %q: %w", name, err)
+	}
+	if err := a.Storage.Rename(ctx, tmp, target); err != nil {
+		_ = a.Storage.Delete(ctx, tmp)
+		return fmt.Errorf("archive %q: %w", name, err)
+	}
+	return nil
+}
+
+// ArchiveFile archives a local file under its base name; it is the
+// archive_command entry point (%p).
+func (a *Archive) ArchiveFile(ctx context.Context, localPath string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return a.ArchiveSegment(ctx, filepath.Base(localPath), f)
+}
+
+// compare succeeds if the archived file name holds the content of r.
+func (a *Archive) compare(ctx context.Context, name string, r io.Reader) error {
+	want := sha256.New()
+	if _, err := io.Copy(want, r); err != nil {
+		return err
+	}
+	rc, err := a.Storage.Get(ctx, a.objectPath(name))
+	if err != nil {
+		return err
+	}
+	got := sha256.New()
+	_, err = io.Copy(got, rc)
+	if closeErr := rc.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("compare %q: %w", name, err)
+	}
+	if !bytes.Equal(got.Sum(nil), want.Sum(nil)) {
+		return fmt.Errorf("%w: %q", ErrDuplicate, name)
+	}
+	return nil
+}
+
+// FetchSegment writes the archived file name to w. It returns an error
+// matching fs.ErrNotExist if the file is not archived, which a
+// restore_command must report as a non-zero exit.
+func (a *Archive) FetchSegment(ctx context.Context, name string, w io.Writer) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	rc, err := a.Storage.Get(ctx, a.objectPath(name))
+	if err != nil {
+		return fmt.Errorf("fetch %q: %w", name, err)
+	}
+	_, err = storage.CopyObject(w, rc)
+	if err != nil {
+		return fmt.Errorf("fetch %q: %w", name, err)
+	}
+	return nil
+}
+
+// FetchFile fetches the archived file name into localPath, atomically;
+// it is the restore_command entry point (%f, %p).
+func (a *Archive) FetchFile(ctx context.Context, name, localPath string) error {
+	tmp := localPath + ".tmp-" + randomSuffix()
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = a.FetchSegment(ctx, name, f)
+	if err == nil {
+		err = fsync.Fsync(f)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, localPath)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+	return err
+}
+
+// Names returns the names of the archived files, sorted.
+func (a *Archive) Names(ctx context.Context) ([]string, error) {
+	files, err := a.Storage.List(ctx, a.prefix)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, f := range files {
+		if path.Dir(f) != a.prefix {
+			continue // tmpDir, or not ours
+		}
+		if name := path.Base(f); ValidName(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Timelines returns the timelines that have a history file, sorted.
+// Timeline 1 never has one.
+func (a *Archive) Timelines(ctx context.Context) ([]uint32, error) {
+	names, err := a.Names(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var tlis []uint32
+	for _, name := range names {
+		if IsHistoryName(name) {
+			tlis = append(tlis, historyTimeline(name))
+		}
+	}
+	return tlis, nil
+}
+
+// History reads the history file of timeline tli. Timeline 1 has no
+// history; its result is empty.
+func (a *Archive) History(ctx context.Context, tli uint32) ([]HistoryEntry, error) {
+	if tli <= 1 {
+		return nil, nil
+	}
+	var buf strings.Builder
+	if err := a.FetchSegment(ctx, HistoryName(tli), &buf); err != nil {
+		return nil, err
+	}
+	return ParseHistory(strings.NewReader(buf.String()))
+}
+
+// Cleanup removes files left in the temporary directory by interrupted
+// uploads.
+func (a *Archive) Cleanup(ctx context.Context) error {
+	err := a.Storage.DeleteAll(ctx, path.Join(a.prefix, tmpDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func randomSuffix() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
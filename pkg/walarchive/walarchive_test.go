@@ -0,0 +1,97 @@
+package walarchive
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const seg1 = "000000010000000000000001"
+
+func TestArchive_ArchiveAndFetch(t *testing.T) {
+	ctx := context.Background()
+	mem := storage.NewInMemoryStorage()
+	a := New(mem, "")
+
+	require.NoError(t, a.ArchiveSegment(ctx, seg1, strings.NewReader("segment")))
+	assert.Contains(t, mem.Files, "wal/"+seg1)
+
+	// Retried after a crash: identical content is fine.
+	require.NoError(t, a.ArchiveSegment(ctx, seg1, strings.NewReader("segment")))
+	// Different content is never overwritten.
+	err := a.ArchiveSegment(ctx, seg1, strings.NewReader("other"))
+	require.ErrorIs(t, err, ErrDuplicate)
+	assert.Equal(t, []byte("segment"), mem.Files["wal/"+seg1])
+
+	require.ErrorIs(t, a.ArchiveSegment(ctx, "postmaster.pid", strings.NewReader("")), ErrInvalidName)
+
+	var buf bytes.Buffer
+	require.NoError(t, a.FetchSegment(ctx, seg1, &buf))
+	assert.Equal(t, "segment", buf.String())
+
+	// A partial segment is archived under its own name and never served
+	// in place of the segment.
+	const seg2 = "000000010000000000000002"
+	require.NoError(t, a.ArchiveSegment(ctx, seg2+".partial", strings.NewReader("half")))
+	err = a.FetchSegment(ctx, seg2, &buf)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	names, err := a.Names(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{seg1, seg2 + ".partial"}, names)
+	for p := range mem.Files {
+		assert.NotContains(t, p, tmpDir)
+	}
+}
+
+func TestArchive_Files(t *testing.T) {
+	ctx := context.Background()
+	a := New(storage.NewInMemoryStorage(), "pg/wal")
+	dir := t.TempDir()
+
+	src := filepath.Join(dir, seg1)
+	require.NoError(t, os.WriteFile(src, []byte("from pg_wal"), 0o600))
+	require.NoError(t, a.ArchiveFile(ctx, src))
+
+	dst := filepath.Join(dir, "RECOVERYXLOG")
+	require.NoError(t, a.FetchFile(ctx, seg1, dst))
+	data, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, "from pg_wal", string(data))
+
+	err = a.FetchFile(ctx, "000000010000000000000009", filepath.Join(dir, "missing"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "no temporary files are left behind")
+}
+
+func TestArchive_Timelines(t *testing.T) {
+	ctx := context.Background()
+	a := New(storage.NewInMemoryStorage(), "")
+
+	require.NoError(t, a.ArchiveSegment(ctx, HistoryName(2), strings.NewReader("1\t0/3000000\tno recovery target specified\n")))
+	require.NoError(t, a.ArchiveSegment(ctx, HistoryName(3), strings.NewReader(
+		"1\t0/3000000\tno recovery target specified\n2\t0/5000000\tno recovery target specified\n")))
+
+	tlis, err := a.Timelines(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{2, 3}, tlis)
+
+	history, err := a.History(ctx, 3)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, LSN(0x5000000), history[1].Switch)
+
+	history, err = a.History(ctx, 1)
+	require.NoError(t, err)
+	assert.Empty(t, history)
+}
@@ -0,0 +1,41 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashmap-kz/storecrypt/pkg/storage"
+	"github.com/hashmap-kz/storecrypt/pkg/storetest"
+)
+
+// conformanceStorage returns a constructor for storetest.TestStorage that
+// gives every subtest its own, empty prefix on a shared backend. The run
+// is stamped into the prefix, since S3 and SFTP keep data between runs.
+func conformanceStorage(t *testing.T, open func(prefix string) storage.Storage) func() storage.Storage {
+	run := time.Now().UTC().Format("20060102T150405.000000000")
+	var n atomic.Int64
+	return func() storage.Storage {
+		st := open(fmt.Sprintf("storetest/%s/%d", run, n.Add(1)))
+		t.Cleanup(func() { _ = st.DeleteAll(context.Background(), "") })
+		return st
+	}
+}
+
+func TestS3Storage_Conformance(t *testing.T) {
+	client := createS3Client()
+	storetest.TestStorage(t, conformanceStorage(t, func(prefix string) storage.Storage {
+		return storage.NewS3Storage(client, "backups", prefix)
+	}))
+}
+
+func TestSFTPStorage_Conformance(t *testing.T) {
+	client := createSftpClient()
+	storetest.TestStorage(t, conformanceStorage(t, func(prefix string) storage.Storage {
+		return storage.NewSFTPStorage(client, prefix)
+	}))
+}
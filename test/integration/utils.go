@@ -1,6 +1,7 @@
 package integration
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -30,6 +31,10 @@ func createS3Client() *s3.Client {
 	if err != nil {
 		log.Fatal(err)
 	}
+	err = client.EnsureBucket(context.Background(), clients.S3BucketSetup{Versioning: true})
+	if err != nil {
+		log.Fatal(err)
+	}
 	return client.Client()
 }
 